@@ -11,6 +11,11 @@
 // COSE signature verification is included for offline validation when the
 // issuer's public key is available.
 //
+// The same framing also carries W3C credentials serialized as a CWT Claims
+// Set with the credential in a "vc" claim (Testa Edu's CBOR QR mode). Those
+// are unwrapped back to the original JSON credential so its own proof can be
+// verified as usual.
+//
 // References:
 //   - https://docs.mosip.io/1.2.0/readme/standards-and-specifications/mosip-standards/169-qr-code-specification
 //   - RFC 8152 (COSE)
@@ -21,6 +26,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"reflect"
 
 	"github.com/fxamacker/cbor/v2"
 )
@@ -57,6 +63,11 @@ var CWTClaims = map[int]string{
 func decodeCBORPayload(data []byte) (string, error) {
 	log.Println("[CBOR] detected CBOR payload, decoding...")
 
+	// Try as a CWT Claims Set carrying a W3C credential.
+	if vc, ok, err := decodeCWTCredential(data); ok {
+		return vc, err
+	}
+
 	// Try to decode as COSE_Sign1 (4-element CBOR array: [protected, unprotected, payload, signature]).
 	var coseArray []cbor.RawMessage
 	if err := cbor.Unmarshal(data, &coseArray); err == nil && len(coseArray) == 4 {
@@ -76,6 +87,43 @@ func decodeCBORPayload(data []byte) (string, error) {
 	return decodeCBORMap(data)
 }
 
+// cwtClaimVC is the text claim key holding an embedded W3C credential.
+const cwtClaimVC = "vc"
+
+// decodeCWTCredential returns the credential embedded in a CWT Claims Set's
+// "vc" claim: JSON for JSON-LD credentials, or the compact serialization for
+// JWT/SD-JWT credentials. ok is false when data is not such a claims set.
+func decodeCWTCredential(data []byte) (string, bool, error) {
+	var claims map[any]cbor.RawMessage
+	if err := cbor.Unmarshal(data, &claims); err != nil {
+		return "", false, nil
+	}
+	raw, ok := claims[cwtClaimVC]
+	if !ok {
+		return "", false, nil
+	}
+
+	dm, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]any(nil))}.DecMode()
+	if err != nil {
+		return "", true, err
+	}
+	var vc any
+	if err := dm.Unmarshal(raw, &vc); err != nil {
+		return "", true, fmt.Errorf("cbor: decode vc claim: %w", err)
+	}
+	if compact, ok := vc.(string); ok {
+		log.Println("[CBOR] CWT vc claim holds a compact JWT credential")
+		return compact, true, nil
+	}
+
+	jsonBytes, err := json.Marshal(vc)
+	if err != nil {
+		return "", true, fmt.Errorf("cbor: marshal vc claim to JSON: %w", err)
+	}
+	log.Printf("[CBOR] decoded W3C credential from CWT vc claim (%d bytes)", len(jsonBytes))
+	return string(jsonBytes), true, nil
+}
+
 // decodeCOSESign1 extracts and decodes the payload from a COSE_Sign1 structure.
 func decodeCOSESign1(parts []cbor.RawMessage) (string, error) {
 	// parts[0] = protected headers (bstr)
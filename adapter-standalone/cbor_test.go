@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/fxamacker/cbor/v2"
@@ -92,6 +93,40 @@ func TestDecodeCOSESign1(t *testing.T) {
 	}
 }
 
+// TestDecodeCWTCredential verifies a W3C credential carried in the "vc"
+// claim of a CWT Claims Set round-trips to its original JSON.
+func TestDecodeCWTCredential(t *testing.T) {
+	original := `{"@context":["https://www.w3.org/2018/credentials/v1"],"credentialSubject":{"gpa":3.5,"name":"Alice"},"issuer":"did:example:issuer","proof":{"type":"Ed25519Signature2020"},"type":["VerifiableCredential"]}`
+	var vc any
+	if err := json.Unmarshal([]byte(original), &vc); err != nil {
+		t.Fatal(err)
+	}
+	data, err := cbor.Marshal(map[any]any{1: "did:example:issuer", 6: 1700000000, "vc": vc})
+	if err != nil {
+		t.Fatalf("marshal CBOR: %v", err)
+	}
+
+	result, err := decodeCBORPayload(data)
+	if err != nil {
+		t.Fatalf("decodeCBORPayload: %v", err)
+	}
+	if result != original {
+		t.Errorf("round trip mismatch:\n got %s\nwant %s", result, original)
+	}
+}
+
+// TestDecodeCWTCredentialJWT verifies compact JWT credentials are returned as-is.
+func TestDecodeCWTCredentialJWT(t *testing.T) {
+	data, _ := cbor.Marshal(map[any]any{1: "did:example:issuer", "vc": "eyJhbGciOiJFUzI1NksifQ.e30.c2ln"})
+	result, err := decodeCBORPayload(data)
+	if err != nil {
+		t.Fatalf("decodeCBORPayload: %v", err)
+	}
+	if result != "eyJhbGciOiJFUzI1NksifQ.e30.c2ln" {
+		t.Errorf("got %q", result)
+	}
+}
+
 // TestDecodePixelPassStillHandlesJSON verifies the existing JSON path is unchanged.
 func TestDecodePixelPassStillHandlesJSON(t *testing.T) {
 	// This test uses the existing Base45 test vectors to confirm
@@ -7,6 +7,9 @@
 //   - CBOR (MOSIP Claim 169 / CWT)
 //
 // The decoder inspects the first byte to determine which path to take.
+//
+// Credentials too large for one QR code arrive as a sequence of framed QR
+// payloads (animated QR), which are reassembled before decoding.
 package main
 
 import (
@@ -16,6 +19,7 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -281,3 +285,84 @@ func deepCopy(src map[string]any) (map[string]any, error) {
 	err = json.Unmarshal(data, &dst)
 	return dst, err
 }
+
+// ============================================================================
+// Animated / chunked QR reassembly.
+// ============================================================================
+
+// qrFramePrefix starts each frame of a chunked QR sequence:
+//
+//	EDUQR:<set>:<index>/<total>:<chunk>
+const qrFramePrefix = "EDUQR:"
+
+// IsQRFrameSequence returns true if the input holds chunked QR frames,
+// either newline-separated or as a JSON array of strings.
+func IsQRFrameSequence(input string) bool {
+	return strings.HasPrefix(input, qrFramePrefix) ||
+		(strings.HasPrefix(input, "[") && strings.Contains(input, `"`+qrFramePrefix))
+}
+
+// ReassembleQRFrames joins scanned frames, in any order, back into the
+// original QR payload. All frames must belong to the same set and the
+// sequence must be complete.
+func ReassembleQRFrames(input string) (string, error) {
+	var frames []string
+	if strings.HasPrefix(input, "[") {
+		if err := json.Unmarshal([]byte(input), &frames); err != nil {
+			return "", fmt.Errorf("decode: frame list: %w", err)
+		}
+	} else {
+		for _, line := range strings.Split(input, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				frames = append(frames, line)
+			}
+		}
+	}
+
+	var set string
+	total := 0
+	chunks := map[int]string{}
+	for _, frame := range frames {
+		rest, ok := strings.CutPrefix(frame, qrFramePrefix)
+		if !ok {
+			return "", fmt.Errorf("decode: not a QR frame: %.20q", frame)
+		}
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) != 3 {
+			return "", fmt.Errorf("decode: malformed QR frame header")
+		}
+		idxStr, totalStr, ok := strings.Cut(parts[1], "/")
+		if !ok {
+			return "", fmt.Errorf("decode: malformed QR frame index %q", parts[1])
+		}
+		idx, err1 := strconv.Atoi(idxStr)
+		n, err2 := strconv.Atoi(totalStr)
+		if err1 != nil || err2 != nil || n < 1 || idx < 1 || idx > n {
+			return "", fmt.Errorf("decode: invalid QR frame index %q", parts[1])
+		}
+		if set == "" {
+			set, total = parts[0], n
+		} else if parts[0] != set || n != total {
+			return "", fmt.Errorf("decode: QR frames from different sequences")
+		}
+		chunks[idx] = parts[2]
+	}
+
+	if total == 0 {
+		return "", fmt.Errorf("decode: no QR frames")
+	}
+	indices := make([]int, 0, len(chunks))
+	for i := range chunks {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	if len(indices) != total {
+		return "", fmt.Errorf("decode: have %d of %d QR frames", len(indices), total)
+	}
+
+	var b strings.Builder
+	for _, i := range indices {
+		b.WriteString(chunks[i])
+	}
+	return b.String(), nil
+}
@@ -145,3 +145,36 @@ func TestSetNestedValue(t *testing.T) {
 		t.Errorf("nested value = %q, want 'deep'", c)
 	}
 }
+
+// TestReassembleQRFrames verifies out-of-order frames are joined in index order.
+func TestReassembleQRFrames(t *testing.T) {
+	input := "EDUQR:K7P2:2/3:DEF\nEDUQR:K7P2:3/3:GH\nEDUQR:K7P2:1/3:ABC"
+	if !IsQRFrameSequence(input) {
+		t.Fatal("expected frame sequence to be detected")
+	}
+	got, err := ReassembleQRFrames(input)
+	if err != nil {
+		t.Fatalf("ReassembleQRFrames: %v", err)
+	}
+	if got != "ABCDEFGH" {
+		t.Errorf("got %q, want %q", got, "ABCDEFGH")
+	}
+
+	got, err = ReassembleQRFrames(`["EDUQR:K7P2:1/2:AB:C","EDUQR:K7P2:2/2:D"]`)
+	if err != nil || got != "AB:CD" {
+		t.Errorf("JSON frame list: got %q, %v", got, err)
+	}
+}
+
+// TestReassembleQRFramesRejectsIncomplete verifies missing or mixed frames fail.
+func TestReassembleQRFramesRejectsIncomplete(t *testing.T) {
+	for _, input := range []string{
+		"EDUQR:K7P2:1/3:ABC\nEDUQR:K7P2:3/3:GH",
+		"EDUQR:K7P2:1/2:ABC\nEDUQR:ZZZZ:2/2:DEF",
+		"EDUQR:K7P2:4/3:ABC",
+	} {
+		if _, err := ReassembleQRFrames(input); err == nil {
+			t.Errorf("expected error for %q", input)
+		}
+	}
+}
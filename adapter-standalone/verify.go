@@ -331,11 +331,22 @@ func (a *Adapter) VerifySDJWT(token string, contentType string, forceOffline boo
 // Request body parsing with PixelPass + JSON-XT support.
 // --------------------------------------------------------------------------
 
-// ParseRequestBody handles all input formats: PixelPass-encoded, JSON-XT URI,
-// or plain JSON. Returns the parsed request and whether JSON-XT was used.
+// ParseRequestBody handles all input formats: chunked QR frames,
+// PixelPass-encoded, JSON-XT URI, or plain JSON. Returns the parsed
+// request and whether JSON-XT was used.
 func (a *Adapter) ParseRequestBody(raw string) (map[string]any, bool, error) {
 	trimmed := strings.TrimSpace(raw)
 
+	// Reassemble animated/chunked QR frames into the original payload.
+	if IsQRFrameSequence(trimmed) {
+		log.Println("[ADAPTER] detected chunked QR frame sequence")
+		joined, err := ReassembleQRFrames(trimmed)
+		if err != nil {
+			return nil, false, err
+		}
+		trimmed = joined
+	}
+
 	// PixelPass decode first.
 	if IsPixelPassEncoded(trimmed) {
 		log.Println("[ADAPTER] detected PixelPass-encoded data")
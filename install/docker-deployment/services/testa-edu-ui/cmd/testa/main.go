@@ -0,0 +1,16 @@
+// Command testa issues, verifies and renders credentials from files, with
+// the service's configuration, for scripting and air-gapped issuance; see
+// internal/service/cli.go.
+//
+//	testa issue|verify|qr|backup|restore [flags] file...
+package main
+
+import (
+	"os"
+
+	"github.com/credebl/testa-edu-ui/internal/service"
+)
+
+func main() {
+	os.Exit(service.RunCLI(os.Args[1:]))
+}
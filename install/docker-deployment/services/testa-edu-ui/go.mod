@@ -2,4 +2,19 @@ module github.com/credebl/testa-edu-ui
 
 go 1.22.0
 
-require github.com/go-pdf/fpdf v0.9.0
+require (
+	github.com/boombuler/barcode v1.1.0
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.1
+	github.com/digitorus/pkcs7 v0.0.0-20250730155240-ffadbf3f398c
+	github.com/fxamacker/cbor/v2 v2.7.0
+	github.com/go-pdf/fpdf v0.9.0
+	github.com/piprate/json-gold v0.5.0
+	golang.org/x/crypto v0.33.0
+)
+
+require (
+	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	golang.org/x/net v0.21.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+)
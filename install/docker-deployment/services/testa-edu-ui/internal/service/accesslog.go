@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+// Access log. accessLog logs each request as it completes: method, path,
+// status, duration and response size, at info level, or debug for the
+// health probes and /metrics, which are polled.
+//
+// Every request has an ID: the X-Request-ID it arrived with, when a proxy
+// set one, or a new one. The ID is returned in the response, added to the
+// request's log fields and sent with its agent calls, so a request can be
+// followed from the proxy through this service to the agent.
+
+const requestIDHeader = "X-Request-ID"
+
+// requestIDSyntax is the syntax of the request IDs taken from clients;
+// other IDs are replaced, so a client cannot forge log lines with one.
+var requestIDSyntax = regexp.MustCompile(`^[A-Za-z0-9._:+=/-]{1,128}$`)
+
+// requestIDKey is the context key of a request's ID.
+type requestIDKey struct{}
+
+// requestID is the ID of the request ctx belongs to, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// accessLog gives each request an ID and logs it once served.
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !requestIDSyntax.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		r = r.WithContext(withLogFields(ctx, slog.String("request_id", id)))
+
+		lw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(lw, r)
+
+		level := slog.LevelInfo
+		if isProbePath(r.URL.Path) || r.URL.Path == "/metrics" {
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", lw.status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"bytes", lw.size,
+		)
+	})
+}
+
+// accessLogWriter records the status and size of a response.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *accessLogWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Unwrap gives http.ResponseController the underlying writer, to flush.
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// TestAccessLog checks each request is logged with its ID, which is kept
+// from the client when well formed and sent on to the agent.
+func TestAccessLog(t *testing.T) {
+	var agentIDs []string
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentIDs = append(agentIDs, r.Header.Get(requestIDHeader))
+		w.Write([]byte(`{"token":"t"}`))
+	}))
+	defer agent.Close()
+	client := NewAgentClient(agent.URL, "key")
+	client.tokens = nil
+
+	var buf bytes.Buffer
+	prev, flags := slog.Default(), log.Flags()
+	slog.SetDefault(slog.New(newLogHandler("json", &buf)))
+	// Setting the default logger redirects the log package; undo both.
+	t.Cleanup(func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+
+	handler := accessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := client.WithContext(r.Context()).GetToken(); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	for _, tc := range []struct{ sent, want string }{
+		{"proxy-7f3a", "proxy-7f3a"},
+		{"", ""},
+		{"bad id\nlevel=ERROR", ""},
+	} {
+		buf.Reset()
+		req := httptest.NewRequest("POST", "/issue", nil)
+		if tc.sent != "" {
+			req.Header.Set(requestIDHeader, tc.sent)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(requestIDHeader)
+		if tc.want != "" && id != tc.want || tc.want == "" && (id == tc.sent || !requestIDSyntax.MatchString(id)) {
+			t.Errorf("sent %q, request ID %q", tc.sent, id)
+		}
+		if got := agentIDs[len(agentIDs)-1]; got != id {
+			t.Errorf("agent got request ID %q, want %q", got, id)
+		}
+		var line map[string]interface{}
+		if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+			t.Fatalf("%v: %s", err, buf.Bytes())
+		}
+		if line["msg"] != "request" || line["request_id"] != id || line["method"] != "POST" || line["path"] != "/issue" ||
+			line["status"] != float64(http.StatusCreated) || line["bytes"] != float64(len("created")) || line["duration_ms"] == nil {
+			t.Errorf("access log %s", buf.Bytes())
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACME certificates. With TLS_ACME=true, certificates for PUBLIC_URL's
+// host and the tenant domains are obtained from an ACME CA (Let's Encrypt
+// unless TLS_ACME_DIRECTORY_URL says otherwise) by autocert on the first
+// HTTPS request for the domain, and renewed before they expire. autocert
+// caches them, and the account key registered with TLS_ACME_EMAIL as its
+// contact, in TLS_CERT_DIR; a certificate placed in
+// TLS_CERT_DIR/<domain>/cert.pem and key.pem is served instead.
+//
+// Domains are validated with the http-01 challenge, which the CA fetches
+// from http://<domain>/.well-known/acme-challenge/<token>: port 80 must
+// reach PORT, where plainHandler answers it.
+
+const letsEncryptDirectory = acme.LetsEncryptURL
+
+var acmeManager *autocert.Manager
+
+func newACMEManager(cfg *Config) *autocert.Manager {
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: acmeHostPolicy,
+		Cache:      autocert.DirCache(cfg.TLSCertDir),
+		Email:      cfg.ACMEEmail,
+		Client:     &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL},
+	}
+}
+
+// acmeHostPolicy allows certificates only for the names certAllowed
+// serves.
+func acmeHostPolicy(_ context.Context, host string) error {
+	if !certAllowed(host) {
+		return fmt.Errorf("ACME: %s is not PUBLIC_URL's host or a tenant domain", host)
+	}
+	return nil
+}
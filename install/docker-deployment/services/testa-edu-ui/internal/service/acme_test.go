@@ -0,0 +1,217 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/acme"
+)
+
+// fakeACME is an ACME CA that checks request signatures and fetches
+// http-01 key authorizations from the handler under test.
+type fakeACME struct {
+	t         *testing.T
+	srv       *httptest.Server
+	domain    string
+	challenge http.Handler
+
+	mu         sync.Mutex
+	account    *ecdsa.PublicKey
+	authzValid bool
+	certPEM    []byte
+	caKey      *ecdsa.PrivateKey
+	caCert     *x509.Certificate
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	f := &fakeACME{t: t}
+	f.caKey, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "Fake ACME CA"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour), IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &f.caKey.PublicKey, f.caKey)
+	f.caCert, _ = x509.ParseCertificate(der)
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeACME) serve(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Replay-Nonce", base64.RawURLEncoding.EncodeToString([]byte(time.Now().String())))
+	base := f.srv.URL
+	if r.Method == http.MethodGet && r.URL.Path == "/dir" {
+		json.NewEncoder(w).Encode(map[string]string{"newNonce": base + "/nonce", "newAccount": base + "/account", "newOrder": base + "/order"})
+		return
+	}
+	if r.Method == http.MethodHead {
+		return
+	}
+	payload := f.verify(r)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/account":
+		w.Header().Set("Location", base+"/acct/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"status": "valid"})
+	case "/order":
+		w.Header().Set("Location", base+"/order/1")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(f.order())
+	case "/order/1":
+		json.NewEncoder(w).Encode(f.order())
+	case "/authz/1":
+		status := "pending"
+		if f.authzValid {
+			status = "valid"
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": status, "challenges": []map[string]string{
+			{"type": "dns-01", "url": base + "/chal/2", "token": "dns-token"},
+			{"type": "http-01", "url": base + "/chal/1", "token": "http-token"},
+		}})
+	case "/chal/1":
+		rec := httptest.NewRecorder()
+		f.challenge.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+f.domain+"/.well-known/acme-challenge/http-token", nil))
+		thumbprint, _ := acme.JWKThumbprint(f.account)
+		if want := "http-token." + thumbprint; rec.Body.String() != want {
+			f.t.Errorf("key authorization %q, want %q", rec.Body.String(), want)
+		}
+		f.authzValid = true
+		json.NewEncoder(w).Encode(map[string]string{"type": "http-01", "url": base + "/chal/1", "token": "http-token", "status": "processing"})
+	case "/finalize/1":
+		var req struct{ CSR string }
+		json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(2), Subject: csr.Subject, DNSNames: csr.DNSNames, NotBefore: time.Now(), NotAfter: time.Now().Add(90 * 24 * time.Hour)}
+		leaf, _ := x509.CreateCertificate(rand.Reader, tmpl, f.caCert, csr.PublicKey, f.caKey)
+		f.certPEM = append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leaf}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: f.caCert.Raw})...)
+		w.Header().Set("Location", base+"/order/1")
+		json.NewEncoder(w).Encode(f.order())
+	case "/cert/1":
+		w.Write(f.certPEM)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// order is the order's state: ready once its authorization is valid,
+// valid once its certificate is issued.
+func (f *fakeACME) order() map[string]interface{} {
+	base := f.srv.URL
+	o := map[string]interface{}{"status": "pending", "authorizations": []string{base + "/authz/1"}, "finalize": base + "/finalize/1"}
+	switch {
+	case f.certPEM != nil:
+		o["status"], o["certificate"] = "valid", base+"/cert/1"
+	case f.authzValid:
+		o["status"] = "ready"
+	}
+	return o
+}
+
+// verify checks a request's JWS, registering the account key with the
+// first, and returns its payload.
+func (f *fakeACME) verify(r *http.Request) []byte {
+	var jws struct{ Protected, Payload, Signature string }
+	json.NewDecoder(r.Body).Decode(&jws)
+	header, _ := base64.RawURLEncoding.DecodeString(jws.Protected)
+	var protected struct {
+		Alg, Nonce, URL, Kid string
+		JWK                  map[string]string
+	}
+	json.Unmarshal(header, &protected)
+	if protected.Alg != "ES256" || protected.Nonce == "" || protected.URL != f.srv.URL+r.URL.Path {
+		f.t.Errorf("%s: protected header %s", r.URL.Path, header)
+	}
+	f.mu.Lock()
+	if protected.JWK != nil {
+		x, _ := base64.RawURLEncoding.DecodeString(protected.JWK["x"])
+		y, _ := base64.RawURLEncoding.DecodeString(protected.JWK["y"])
+		f.account = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+	} else if protected.Kid != f.srv.URL+"/acct/1" {
+		f.t.Errorf("%s: kid %q", r.URL.Path, protected.Kid)
+	}
+	account := f.account
+	f.mu.Unlock()
+	sig, _ := base64.RawURLEncoding.DecodeString(jws.Signature)
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	if len(sig) != 64 || !ecdsa.Verify(account, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		f.t.Errorf("%s: bad signature", r.URL.Path)
+	}
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	return payload
+}
+
+// TestACME obtains a certificate at the first handshake for PUBLIC_URL's
+// host, answering the CA's http-01 challenge on the plain handler, and
+// refuses names that are neither PUBLIC_URL's host nor a tenant domain.
+func TestACME(t *testing.T) {
+	saved, savedACME, savedTenants := config, acmeManager, tenants
+	t.Cleanup(func() { config, acmeManager, tenants = saved, savedACME, savedTenants })
+	tenants, _ = NewTenantStore(t.TempDir())
+	config.PublicURL = "https://credentials.example.ac.ke"
+	config.TLSAddr, config.TLSRedirect, config.TLSCertFile = ":8443", false, ""
+	config.TLSCertDir, config.ACMEEmail = t.TempDir(), "registrar@example.ac.ke"
+
+	ca := newFakeACME(t)
+	ca.domain = "credentials.example.ac.ke"
+	config.ACMEDirectoryURL = ca.srv.URL + "/dir"
+	acmeManager = newACMEManager(&config)
+	ca.challenge = plainHandler(http.NotFoundHandler())
+
+	certs := &certStore{dir: config.TLSCertDir, certs: make(map[string]*domainCert)}
+	cert, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "credentials.example.ac.ke"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || len(leaf.DNSNames) != 1 || leaf.DNSNames[0] != "credentials.example.ac.ke" {
+		t.Fatalf("certificate for %v, %v", leaf.DNSNames, err)
+	}
+	again, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "credentials.example.ac.ke"})
+	if err != nil || !bytes.Equal(again.Certificate[0], cert.Certificate[0]) {
+		t.Errorf("certificate not reused: %v", err)
+	}
+	if _, err := certs.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.com"}); err == nil {
+		t.Error("certificate for an unknown name")
+	}
+	if err := acmeHostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("host policy allowed an unknown name")
+	}
+}
+
+func TestPlainHandlerRedirect(t *testing.T) {
+	saved, savedACME := config, acmeManager
+	t.Cleanup(func() { config, acmeManager = saved, savedACME })
+	config.TLSAddr, config.TLSRedirect, acmeManager = ":8443", true, nil
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := plainHandler(ok)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://credentials.example.ac.ke:3002/verify?x=1", nil))
+	if loc := rec.Header().Get("Location"); rec.Code != http.StatusPermanentRedirect || loc != "https://credentials.example.ac.ke:8443/verify?x=1" {
+		t.Errorf("redirect %d to %q", rec.Code, loc)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://localhost:3002/health", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("health check: %d", rec.Code)
+	}
+}
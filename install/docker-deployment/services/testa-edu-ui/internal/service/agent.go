@@ -0,0 +1,659 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AgentClient struct {
+	BaseURL string
+	APIKey  string
+	client  *http.Client
+
+	// ctx, timeout and timeouts bound calls (agenttimeout.go); basePath
+	// is BaseURL's path, which endpoint timeouts do not include.
+	ctx      context.Context
+	timeout  time.Duration
+	timeouts map[string]time.Duration
+	basePath string
+
+	// retries and backoff govern retrying failed calls (agentretry.go).
+	retries int
+	backoff time.Duration
+	breaker *circuitBreaker
+	tokens  *tokenCache
+	// failover, when set, spreads calls over several agents
+	// (agentfailover.go); BaseURL is the first.
+	failover *agentPool
+	// oauth, when set, obtains tokens with OAuth2 (agentoauth.go).
+	oauth *oauthClient
+	// issuer is the agent's REST API (agentbackend.go).
+	issuer IssuerBackend
+	// responseLimit bounds response bodies (agentbody.go).
+	responseLimit int64
+	// limit bounds concurrent sign and verify calls (agentlimit.go).
+	limit *agentLimiter
+	// contract, when set, checks calls against the agent's API
+	// (agentcontract.go), failing those that differ if strictContract.
+	contract       *AgentContract
+	strictContract bool
+
+	// local signs and verifies in-process instead (SIGNING_MODE=local).
+	local *LocalSigner
+}
+
+// NewAgentClient returns a client for the agent at baseURL, with its own
+// connection pool. Its calls run under the server's context; see
+// WithContext.
+func NewAgentClient(baseURL, apiKey string) *AgentClient {
+	baseURL = strings.TrimRight(baseURL, "/")
+	var basePath string
+	if u, err := url.Parse(baseURL); err == nil {
+		basePath = u.Path
+	}
+	oauth := agentOAuth()
+	credential := apiKey
+	if oauth != nil {
+		credential = oauth.tokenURL + "\x00" + oauth.id
+	}
+	return &AgentClient{
+		BaseURL:  baseURL,
+		APIKey:   apiKey,
+		client:   &http.Client{Transport: agentTransport()},
+		timeout:  config.AgentTimeout,
+		timeouts: config.AgentTimeouts,
+		basePath: basePath,
+		retries:  config.AgentRetries,
+		backoff:  config.AgentRetryBackoff,
+		breaker:  agentBreaker(baseURL),
+		tokens:   agentTokenCache(baseURL, credential),
+		oauth:    oauth,
+		issuer:   issuerBackend(config.AgentBackend),
+		local:    localSigner,
+
+		responseLimit:  config.AgentMaxResponse,
+		limit:          agentLimit(baseURL),
+		contract:       agentContract,
+		strictContract: config.AgentContract == AgentContractStrict,
+	}
+}
+
+// AgentError is an error response from the agent: a non-2xx status, with
+// the code and message the agent gave, when it gave them, and the raw
+// response body for the logs.
+type AgentError struct {
+	Endpoint   string // the request path, e.g. /agent/credential/sign
+	StatusCode int
+	Code       string
+	Message    string
+	Body       []byte
+}
+
+func (e *AgentError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = truncateBody(e.Body)
+	}
+	if e.Code != "" {
+		return fmt.Sprintf("agent %s returned HTTP %d (%s): %s", e.Endpoint, e.StatusCode, e.Code, msg)
+	}
+	return fmt.Sprintf("agent %s returned HTTP %d: %s", e.Endpoint, e.StatusCode, msg)
+}
+
+// Rejected reports whether the agent refused the request itself (4xx),
+// rather than failing to handle it.
+func (e *AgentError) Rejected() bool {
+	return e.StatusCode >= 400 && e.StatusCode < 500
+}
+
+// agentErrorBody is the error body of the agent's REST API: NestJS-style
+// {statusCode, message, error} or Credo's {message, reason}. message may
+// be a list of validation messages.
+type agentErrorBody struct {
+	Message json.RawMessage `json:"message"`
+	Reason  string          `json:"reason"`
+	Error   json.RawMessage `json:"error"`
+	Code    json.RawMessage `json:"code"`
+}
+
+// newAgentError reads an agent error response.
+func newAgentError(endpoint string, status int, body []byte) *AgentError {
+	e := &AgentError{Endpoint: endpoint, StatusCode: status, Body: body}
+	var eb agentErrorBody
+	if json.Unmarshal(body, &eb) != nil {
+		return e
+	}
+	var messages []string
+	if json.Unmarshal(eb.Message, &e.Message) != nil && json.Unmarshal(eb.Message, &messages) == nil {
+		e.Message = strings.Join(messages, "; ")
+	}
+	if e.Message == "" {
+		e.Message = eb.Reason
+	}
+	var code json.Number
+	if json.Unmarshal(eb.Code, &e.Code) != nil && json.Unmarshal(eb.Code, &code) == nil {
+		e.Code = code.String()
+	}
+	if e.Code == "" {
+		json.Unmarshal(eb.Error, &e.Code)
+	}
+	return e
+}
+
+// truncateBody shortens a response body for error messages.
+func truncateBody(body []byte) string {
+	const max = 200
+	s := strings.TrimSpace(string(body))
+	if len(s) > max {
+		return s[:max] + "…"
+	}
+	return s
+}
+
+// agentFailure logs a failed agent call with the request's log fields,
+// and the agent's response body when it sent one, and returns the message
+// to show the user.
+func agentFailure(ctx context.Context, what string, err error) string {
+	if errors.Is(err, context.Canceled) {
+		slog.InfoContext(ctx, what+": cancelled")
+		return "Cancelled"
+	}
+	if errors.Is(err, errAgentUnavailable) {
+		slog.ErrorContext(ctx, what, "err", err)
+		return "The credential agent is unavailable. Please try again shortly."
+	}
+	var ae *AgentError
+	if errors.As(err, &ae) {
+		slog.ErrorContext(ctx, what, "err", err, "status", ae.StatusCode, "response", string(ae.Body))
+		if ae.StatusCode >= 500 {
+			reportError(ctx, what, err)
+		}
+		if ae.Message != "" {
+			return "The agent could not complete the request: " + ae.Message
+		}
+		return fmt.Sprintf("The agent could not complete the request (HTTP %d)", ae.StatusCode)
+	}
+	slog.ErrorContext(ctx, what, "err", err)
+	reportError(ctx, what, err)
+	return err.Error()
+}
+
+type tokenResponse struct {
+	Token string `json:"token"`
+}
+
+// signedCredentialResponse is the agent's signing and derivation
+// response: the credential, either wrapped or bare.
+type signedCredentialResponse struct {
+	Credential json.RawMessage `json:"credential"`
+	Proof      json.RawMessage `json:"proof"`
+}
+
+// signedCredential returns the signed credential of a signing response,
+// or false when it has no proof.
+func signedCredential(body []byte) (json.RawMessage, bool) {
+	var resp signedCredentialResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, false
+	}
+	if len(resp.Credential) > 0 && string(resp.Credential) != "null" {
+		var inner signedCredentialResponse
+		if err := json.Unmarshal(resp.Credential, &inner); err != nil || !hasValue(inner.Proof) {
+			return nil, false
+		}
+		return resp.Credential, true
+	}
+	return body, hasValue(resp.Proof)
+}
+
+func hasValue(raw json.RawMessage) bool {
+	return len(raw) > 0 && string(raw) != "null"
+}
+
+type rawSignRequest struct {
+	Data    string `json:"data"`
+	KeyType string `json:"keyType"`
+	Method  string `json:"method"`
+}
+
+type verifyRequest struct {
+	Credential json.RawMessage `json:"credential"`
+}
+
+// GetToken returns a token for the agent's API, from the cache when it
+// holds a fresh one.
+func (a *AgentClient) GetToken() (string, error) {
+	if a.local != nil {
+		return localToken, nil
+	}
+	if a.tokens == nil {
+		token, _, err := a.fetchToken()
+		return token, err
+	}
+	return a.tokens.get(a.fetchToken)
+}
+
+// fetchToken gets a new token, from the OAuth2 token endpoint or the
+// backend, and how long it is valid for, if known.
+func (a *AgentClient) fetchToken() (string, time.Duration, error) {
+	if a.oauth != nil {
+		return a.fetchOAuthToken()
+	}
+	return a.backend().GetToken(a)
+}
+
+func (a *AgentClient) SignCredential(token string, payload map[string]interface{}) (json.RawMessage, error) {
+	if a.local != nil {
+		return a.local.SignCredential(payload)
+	}
+	release, err := a.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return a.backend().Sign(a, token, payload)
+}
+
+// SignCredentialJWT has the agent sign the credential as a compact vc-jwt.
+func (a *AgentClient) SignCredentialJWT(token string, payload map[string]interface{}, alg string) (string, error) {
+	if a.local != nil {
+		return a.local.SignCredentialJWT(payload)
+	}
+	credential, _ := payload["credential"].(map[string]interface{})
+	vm, _ := payload["verificationMethod"].(string)
+	issuer, _ := credential["issuer"].(string)
+	if m, ok := credential["issuer"].(map[string]interface{}); ok {
+		issuer, _ = m["id"].(string)
+	}
+	return a.signCompact(token, "JWT", alg, vm, vcJWTClaims(issuer, credential))
+}
+
+// SignSDJWT has the agent sign an SD-JWT VC issuer payload whose
+// selectively disclosable claims have already been replaced by digests.
+// The returned value is the issuer-signed JWT without disclosures.
+func (a *AgentClient) SignSDJWT(token string, claims map[string]interface{}, verificationMethod, alg string) (string, error) {
+	if a.local != nil {
+		return a.local.SignSDJWT(claims)
+	}
+	return a.signCompact(token, "vc+sd-jwt", alg, verificationMethod, claims)
+}
+
+// SignPresentationJWT has the issuer sign vp-jwt claims, for presentations
+// of holders whose key the service does not hold.
+func (a *AgentClient) SignPresentationJWT(token string, claims map[string]interface{}, verificationMethod, alg string) (string, error) {
+	if a.local != nil {
+		return a.local.signJWT("JWT", claims)
+	}
+	return a.signCompact(token, "JWT", alg, verificationMethod, claims)
+}
+
+// agentKeyTypes maps JOSE algorithms to the agent wallet's key types.
+var agentKeyTypes = map[string]string{"EdDSA": "ed25519", "ES256K": "k256", "ES256": "p256"}
+
+// signCompact has the backend sign claims as a compact JWS.
+func (a *AgentClient) signCompact(token, typ, alg, vm string, claims map[string]interface{}) (string, error) {
+	release, err := a.acquire()
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return a.backend().SignJWT(a, token, typ, alg, vm, claims)
+}
+
+// parseRawSignature reads the agent's raw data signature: base64, bare or
+// as a JSON string, or under "signature" or "signedData" in an object.
+// Ed25519 and ECDSA (r‖s) signatures are both 64 bytes.
+func parseRawSignature(body []byte) ([]byte, error) {
+	candidate := strings.TrimSpace(string(body))
+	var s string
+	if err := json.Unmarshal(body, &s); err == nil {
+		candidate = s
+	} else {
+		var wrapper struct {
+			Signature  string `json:"signature"`
+			SignedData string `json:"signedData"`
+		}
+		if err := json.Unmarshal(body, &wrapper); err == nil {
+			if wrapper.Signature != "" {
+				candidate = wrapper.Signature
+			} else if wrapper.SignedData != "" {
+				candidate = wrapper.SignedData
+			}
+		}
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if sig, err := enc.DecodeString(candidate); err == nil && len(sig) == 64 {
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected signing response: %s", truncateBody(body))
+}
+
+// VerifyCredential has the agent check a signed credential. An error means
+// the credential could not be checked, not that it is invalid.
+func (a *AgentClient) VerifyCredential(token string, signedCred json.RawMessage) (*AgentVerification, error) {
+	if a.local != nil {
+		err := verifyLocally(&scannedCredential{Credential: signedCred})
+		if errors.Is(err, errNotLocallyVerifiable) {
+			return nil, err
+		}
+		if err != nil {
+			return &AgentVerification{
+				Checks: []VerificationCheck{{Check: "signature", Status: CheckFailed, Detail: err.Error()}},
+				Errors: []string{err.Error()},
+			}, nil
+		}
+		return &AgentVerification{
+			Verified: true,
+			Checks:   []VerificationCheck{{Check: "signature", Status: CheckPassed, Detail: "verified locally"}},
+		}, nil
+	}
+	release, err := a.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return a.backend().Verify(a, token, signedCred)
+}
+
+// ResolveDID returns the DID document the agent's resolver finds for a DID.
+func (a *AgentClient) ResolveDID(token, did string) ([]byte, error) {
+	body, err := a.getJSON(token, "/dids/"+url.PathEscape(did))
+	if err != nil {
+		return nil, err
+	}
+	return parseResolutionResult(body, did)
+}
+
+// PolygonKeys is a key pair the agent generated for a did:polygon DID.
+type PolygonKeys struct {
+	PrivateKey      string `json:"privateKey"`
+	PublicKeyBase58 string `json:"publicKeyBase58"`
+	Address         string `json:"address"`
+}
+
+// CreatePolygonKeys asks the agent for a new secp256k1 key pair.
+func (a *AgentClient) CreatePolygonKeys(token string) (*PolygonKeys, error) {
+	body, err := a.postJSON(token, "/polygon/create-keys", map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	var keys PolygonKeys
+	if err := json.Unmarshal(body, &keys); err != nil || keys.PrivateKey == "" {
+		return nil, fmt.Errorf("unexpected create-keys response")
+	}
+	return &keys, nil
+}
+
+// WritePolygonDID registers a did:polygon DID for a key on the registry
+// contract, importing the key into the agent's wallet, and returns the DID.
+func (a *AgentClient) WritePolygonDID(token, network, privateKey, endpoint string) (string, error) {
+	payload := map[string]interface{}{
+		"method":     "polygon",
+		"network":    network,
+		"privatekey": privateKey,
+	}
+	if endpoint != "" {
+		payload["endpoint"] = endpoint
+	}
+	body, err := a.postJSON(token, "/dids/write", payload)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		DID string `json:"did"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || !strings.HasPrefix(result.DID, "did:polygon:") {
+		return "", fmt.Errorf("unexpected DID write response")
+	}
+	return result.DID, nil
+}
+
+// PolygonSchema is a document the agent wrote to the Polygon schema
+// registry.
+type PolygonSchema struct {
+	SchemaID string
+	TxHash   string // empty when the agent does not report it
+}
+
+// WritePolygonSchema has the agent write a JSON document to the Polygon
+// schema registry under a did:polygon DID in its wallet, paid by the DID's
+// account and signed with its key. The agent answers once the transaction
+// is mined.
+func (a *AgentClient) WritePolygonSchema(token, did, name string, schema map[string]interface{}) (*PolygonSchema, error) {
+	body, err := a.postJSON(token, "/polygon/create-schema", map[string]interface{}{
+		"did":        did,
+		"schemaName": name,
+		"schema":     schema,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		SchemaID    string `json:"schemaId"`
+		SchemaState struct {
+			State    string `json:"state"`
+			SchemaID string `json:"schemaId"`
+			Reason   string `json:"reason"`
+		} `json:"schemaState"`
+		SchemaTxnHash    string `json:"schemaTxnHash"`
+		SchemaTxnReceipt struct {
+			Hash string `json:"hash"`
+		} `json:"schemaTxnReceipt"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("unexpected create-schema response")
+	}
+	if result.SchemaState.State == "failed" {
+		return nil, fmt.Errorf("writing schema: %s", result.SchemaState.Reason)
+	}
+	s := &PolygonSchema{SchemaID: result.SchemaID, TxHash: result.SchemaTxnHash}
+	if s.SchemaID == "" {
+		s.SchemaID = result.SchemaState.SchemaID
+	}
+	if s.TxHash == "" {
+		s.TxHash = result.SchemaTxnReceipt.Hash
+	}
+	if s.SchemaID == "" {
+		return nil, fmt.Errorf("unexpected create-schema response: no schema ID")
+	}
+	return s, nil
+}
+
+// WriteKeyDID has the agent create an Ed25519 key and its did:key, and
+// returns the DID. The seed is random and not kept.
+func (a *AgentClient) WriteKeyDID(token string) (string, error) {
+	seed := make([]byte, 16)
+	if _, err := rand.Read(seed); err != nil {
+		return "", err
+	}
+	body, err := a.postJSON(token, "/dids/write", map[string]interface{}{
+		"method":  "key",
+		"keyType": "ed25519",
+		"seed":    hex.EncodeToString(seed),
+	})
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		DID string `json:"did"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil || !strings.HasPrefix(result.DID, "did:key:z6Mk") {
+		return "", fmt.Errorf("unexpected DID write response")
+	}
+	return result.DID, nil
+}
+
+// postJSON sends an authenticated JSON request to an agent endpoint and
+// returns the response body, treating non-2xx statuses as errors.
+func (a *AgentClient) postJSON(token, path string, payload interface{}) ([]byte, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling payload: %w", err)
+	}
+	req, err := http.NewRequestWithContext(a.context(), "POST", a.BaseURL+path, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return a.do(token, req)
+}
+
+// getJSON fetches an authenticated agent endpoint.
+func (a *AgentClient) getJSON(token, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(a.context(), "GET", a.BaseURL+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	return a.do(token, req)
+}
+
+func (a *AgentClient) do(token string, req *http.Request) ([]byte, error) {
+	return a.doStream(token, req, nil)
+}
+
+// doStream is do, with a successful response's body passed to decode
+// instead of returned, when decode is set.
+func (a *AgentClient) doStream(token string, req *http.Request, decode func(io.Reader) error) ([]byte, error) {
+	if a.local != nil {
+		return nil, fmt.Errorf("agent %s: %w", req.URL.Path, errAgentDisabled)
+	}
+	a.backend().Authorize(req, a.APIKey, token)
+	body, err := a.stream(req, decode)
+	if !unauthorized(err) || a.tokens == nil || req.GetBody == nil && req.Body != nil {
+		return body, err
+	}
+	// The token expired or the agent restarted: retry once with a new one.
+	a.tokens.invalidate(token)
+	fresh, terr := a.GetToken()
+	if terr != nil || fresh == token {
+		return nil, err
+	}
+	if req.GetBody != nil {
+		if req.Body, terr = req.GetBody(); terr != nil {
+			return nil, err
+		}
+	}
+	a.backend().Authorize(req, a.APIKey, fresh)
+	return a.stream(req, decode)
+}
+
+// send makes an agent request, retrying transient failures and failing
+// over to other agents (agentfailover.go), and returns the response body.
+// Non-2xx statuses are returned as an *AgentError.
+func (a *AgentClient) send(req *http.Request) ([]byte, error) {
+	return a.stream(req, nil)
+}
+
+// stream is send, with a successful response's body passed to decode
+// instead of returned, when decode is set. The request carries the ID of the
+// request it serves, if any, for the agent's logs.
+func (a *AgentClient) stream(req *http.Request, decode func(io.Reader) error) ([]byte, error) {
+	if err := a.checkRequestContract(req); err != nil {
+		return nil, err
+	}
+	if id := requestID(req.Context()); id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	endpoints := a.endpoints()
+	idempotent := idempotentAgentRequest(req)
+	timeout := a.callTimeout(req.URL.Path)
+	var err error
+	for i, attempt := 0, 0; ; {
+		ep := endpoints[i]
+		if berr := ep.breaker.allow(); berr != nil {
+			if i+1 < len(endpoints) {
+				i++
+				continue
+			}
+			if err == nil {
+				err = berr
+			}
+			return nil, err
+		}
+		r, rerr := a.at(req, ep)
+		if rerr != nil {
+			return nil, rerr
+		}
+		var body []byte
+		var retryAfter time.Duration
+		body, retryAfter, err = a.sendOnce(r, timeout, decode)
+		ep.breaker.record(err)
+		if a.failover != nil {
+			ep.report(err)
+		}
+		if err == nil || !retryable(err, idempotent) {
+			return body, err
+		}
+		failover := agentDown(err) && i+1 < len(endpoints)
+		if !failover && (attempt >= a.retries || !ep.breaker.closed()) {
+			return body, err
+		}
+		if req.GetBody != nil {
+			var berr error
+			if req.Body, berr = req.GetBody(); berr != nil {
+				return nil, fmt.Errorf("creating request: %w", berr)
+			}
+		}
+		if failover {
+			i++
+			slog.WarnContext(req.Context(), "agent call failed; failing over", "path", req.URL.Path, "agent", ep.url, "next", endpoints[i].url, "err", err)
+			continue
+		}
+		wait := a.retryDelay(attempt, retryAfter)
+		attempt++
+		slog.WarnContext(req.Context(), "agent call failed; retrying", "path", req.URL.Path, "attempt", attempt, "retry_in", wait.Round(time.Millisecond).String(), "err", err)
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, err
+		}
+	}
+}
+
+// sendOnce makes one attempt at an agent request, bounded by timeout if
+// it is positive. retryAfter is the response's Retry-After, if any. The
+// attempt is traced, measured, and logged with its latency at debug level.
+func (a *AgentClient) sendOnce(req *http.Request, timeout time.Duration, decode func(io.Reader) error) (body []byte, retryAfter time.Duration, err error) {
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	start := time.Now()
+	endpoint := a.metricEndpoint(req.URL.Path)
+	spanCtx, span := startSpan(req.Context(), "agent "+req.Method+" "+endpoint, spanKindClient)
+	defer func() { span.end(err) }()
+	span.set("http.request.method", req.Method)
+	span.set("url.full", req.URL.Scheme+"://"+req.URL.Host+req.URL.Path)
+	injectTrace(spanCtx, req)
+	resp, err := a.client.Do(req)
+	if err != nil {
+		agentRequestSeconds.since(start, endpoint, req.Method, "error")
+		slog.DebugContext(req.Context(), "agent call", "method", req.Method, "path", req.URL.Path, "agent_ms", time.Since(start).Milliseconds(), "err", err)
+		return nil, 0, fmt.Errorf("agent unreachable at %s://%s: %w", req.URL.Scheme, req.URL.Host, err)
+	}
+	defer resp.Body.Close()
+
+	body, err = a.readAgentResponse(req, resp, decode)
+	span.set("http.response.status_code", resp.StatusCode)
+	agentRequestSeconds.since(start, endpoint, req.Method, strconv.Itoa(resp.StatusCode))
+	slog.DebugContext(req.Context(), "agent call", "method", req.Method, "path", req.URL.Path, "status", resp.StatusCode, "agent_ms", time.Since(start).Milliseconds())
+	if _, ok := err.(*AgentError); ok {
+		return nil, parseRetryAfter(resp.Header.Get("Retry-After")), err
+	}
+	return body, 0, err
+}
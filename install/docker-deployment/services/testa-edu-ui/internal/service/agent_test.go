@@ -0,0 +1,161 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestSignCredentialJWTRawData checks that JWTs are signed through the
+// agent's raw data signing and assembled locally.
+func TestSignCredentialJWTRawData(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := didKeyEd25519(priv.Public().(ed25519.PublicKey))
+	vm := did + "#" + strings.TrimPrefix(did, "did:key:")
+
+	responses := map[string]func(sig []byte) string{
+		"JSON string": func(sig []byte) string { return `"` + base64.StdEncoding.EncodeToString(sig) + `"` },
+		"bare":        func(sig []byte) string { return base64.StdEncoding.EncodeToString(sig) },
+		"object":      func(sig []byte) string { return `{"signature":"` + base64.RawURLEncoding.EncodeToString(sig) + `"}` },
+	}
+	for name, respond := range responses {
+		t.Run(name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("dataTypeToSign") != "rawData" {
+					http.Error(w, "dataTypeToSign must be jsonLd or rawData", http.StatusBadRequest)
+					return
+				}
+				var req struct{ Data, KeyType, Method string }
+				if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.KeyType != "ed25519" || req.Method != vm {
+					http.Error(w, "bad raw data request", http.StatusBadRequest)
+					return
+				}
+				w.Write([]byte(respond(ed25519.Sign(priv, []byte(req.Data)))))
+			}))
+			defer srv.Close()
+
+			agent := &AgentClient{BaseURL: srv.URL, client: srv.Client()}
+			jwt, err := agent.SignCredentialJWT("token", map[string]interface{}{
+				"verificationMethod": vm,
+				"credential": map[string]interface{}{
+					"issuer":            map[string]interface{}{"id": did},
+					"issuanceDate":      "2026-01-01T00:00:00Z",
+					"credentialSubject": map[string]interface{}{"id": "did:example:student"},
+				},
+			}, "EdDSA")
+			if err != nil {
+				t.Fatalf("SignCredentialJWT: %v", err)
+			}
+			if err := verifyJWTSignature(jwt, priv.Public()); err != nil {
+				t.Fatalf("signature: %v", err)
+			}
+			header, claims, err := decodeJWT(jwt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header["alg"] != "EdDSA" || header["kid"] != vm {
+				t.Errorf("header %v", header)
+			}
+			if claims["iss"] != did || claims["sub"] != "did:example:student" || claims["nbf"] != float64(1767225600) {
+				t.Errorf("claims %v", claims)
+			}
+		})
+	}
+}
+
+// TestAgentResponses checks signing and verification responses are read
+// by shape, and error responses become an *AgentError.
+func TestAgentResponses(t *testing.T) {
+	var status int
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	agent := &AgentClient{BaseURL: srv.URL, client: srv.Client()}
+
+	status, body = http.StatusCreated, `{"credential":{"id":"urn:uuid:1","proof":{"type":"Ed25519Signature2018"}}}`
+	signed, err := agent.SignCredential("token", map[string]interface{}{})
+	if err != nil || string(signed) != `{"id":"urn:uuid:1","proof":{"type":"Ed25519Signature2018"}}` {
+		t.Errorf("wrapped credential: %s, %v", signed, err)
+	}
+	status, body = http.StatusOK, `{"credential":{"id":"urn:uuid:1"},"note":"the proof was not added"}`
+	if _, err := agent.SignCredential("token", map[string]interface{}{}); err == nil {
+		t.Error("credential without a proof was accepted")
+	}
+
+	status, body = http.StatusOK, `{"isValid":false,"validations":{"vcJs":{"verified":true}}}`
+	if v, err := agent.VerifyCredential("token", json.RawMessage(`{}`)); err != nil || v.Verified {
+		t.Errorf("nested verified counted: %+v, %v", v, err)
+	}
+	status, body = http.StatusOK, `{"isValid":true}`
+	if v, err := agent.VerifyCredential("token", json.RawMessage(`{}`)); err != nil || !v.Verified {
+		t.Errorf("isValid: %+v, %v", v, err)
+	}
+
+	errorBodies := map[string]struct{ body, code, message string }{
+		"NestJS":     {`{"statusCode":400,"message":["issuer must be a DID","credentialSubject is required"],"error":"Bad Request"}`, "Bad Request", "issuer must be a DID; credentialSubject is required"},
+		"Credo":      {`{"message":"Invalid credential","reason":"no proof"}`, "", "Invalid credential"},
+		"code":       {`{"code":4001,"reason":"key not found"}`, "4001", "key not found"},
+		"plain text": {`Internal Server Error`, "", ""},
+	}
+	for name, tc := range errorBodies {
+		status, body = http.StatusBadRequest, tc.body
+		_, err := agent.SignCredential("token", map[string]interface{}{})
+		var ae *AgentError
+		if !errors.As(err, &ae) {
+			t.Errorf("%s: err = %v, want *AgentError", name, err)
+			continue
+		}
+		if ae.StatusCode != http.StatusBadRequest || ae.Endpoint != "/agent/credential/sign" || ae.Code != tc.code || ae.Message != tc.message || string(ae.Body) != tc.body {
+			t.Errorf("%s: %+v", name, ae)
+		}
+		if !ae.Rejected() || !strings.Contains(err.Error(), "HTTP 400") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestParseVerification(t *testing.T) {
+	v, err := parseVerification([]byte(`{
+		"isValid": false,
+		"validations": {
+			"vcJs": {"isValid": true, "results": [{"verified": true}]},
+			"dataModel": {"isValid": true},
+			"issuerIsSigner": {"isValid": false, "error": {"name": "CredoError", "message": "credential proof issuer does not match the issuer"}}
+		},
+		"error": "The credential is not valid"
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []VerificationCheck{
+		{Check: "data model", Status: CheckPassed},
+		{Check: "issuer is signer", Status: CheckFailed, Detail: "credential proof issuer does not match the issuer"},
+		{Check: "signature", Status: CheckPassed},
+	}
+	if v.Verified || !reflect.DeepEqual(v.Checks, want) {
+		t.Errorf("verified %t, checks %+v", v.Verified, v.Checks)
+	}
+	if got := v.Message(); got != "issuer is signer: credential proof issuer does not match the issuer; The credential is not valid" {
+		t.Errorf("message %q", got)
+	}
+
+	// Formatting does not matter, unlike substring matching.
+	if v, err := parseVerification([]byte("{\n  \"verified\" : true\n}")); err != nil || !v.Verified || v.Message() != "verified" {
+		t.Errorf("pretty-printed: %+v, %v", v, err)
+	}
+	if _, err := parseVerification([]byte(`{"status":"ok"}`)); err == nil {
+		t.Error("response without an outcome accepted")
+	}
+}
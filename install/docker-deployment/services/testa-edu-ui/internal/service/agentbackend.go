@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Agent backends. The wizard talks to one agent through AgentClient, and
+// an IssuerBackend is the REST shape of that agent: how tokens are
+// obtained and requests authenticated, and the endpoints that sign and
+// verify. AGENT_BACKEND picks one:
+//
+//   - credo (the default): the CREDEBL agent, a Credo REST controller.
+//   - acapy: an ACA-Py admin API (agentvcapi.go).
+//   - vcapi: a W3C VC-API issuer and verifier (agentvcapi.go).
+//
+// Transport — timeouts, retries, the circuit breaker, failover, TLS and
+// OAuth2 tokens — is AgentClient's and the same for all backends. The
+// features beyond signing and verifying (BBS+ derivation, AnonCreds,
+// did:polygon provisioning and DID resolution) need the CREDEBL agent.
+
+const (
+	AgentBackendCredo = "credo"
+	AgentBackendACAPy = "acapy"
+	AgentBackendVCAPI = "vcapi"
+)
+
+// IssuerBackend is an agent's REST API for signing and verifying.
+type IssuerBackend interface {
+	// GetToken obtains a new API token, and its lifetime if known. A
+	// backend that takes no tokens returns "".
+	GetToken(a *AgentClient) (string, time.Duration, error)
+	// Authorize authenticates a request with the API key or token.
+	Authorize(req *http.Request, apiKey, token string)
+	// Sign adds a Linked Data proof to the credential of a sign request
+	// (see signPayload).
+	Sign(a *AgentClient, token string, payload map[string]interface{}) (json.RawMessage, error)
+	// SignJWT signs claims as a compact JWS with the verification
+	// method's key.
+	SignJWT(a *AgentClient, token, typ, alg, vm string, claims map[string]interface{}) (string, error)
+	// Verify checks a signed credential. An error means the credential
+	// could not be checked, not that it is invalid.
+	Verify(a *AgentClient, token string, credential json.RawMessage) (*AgentVerification, error)
+	// HealthPath is the endpoint health checks request.
+	HealthPath() string
+	// Capabilities asks the agent what it can do (agentcaps.go).
+	Capabilities(a *AgentClient, token string) (*AgentCapabilities, error)
+}
+
+// errBackendUnsupported is returned for operations the configured
+// backend cannot do.
+var errBackendUnsupported = errors.New("not supported by the agent backend")
+
+var issuerBackends = map[string]IssuerBackend{
+	AgentBackendCredo: credoBackend{},
+	AgentBackendACAPy: acapyBackend{vcAPIBackend{prefix: "/vc"}},
+	AgentBackendVCAPI: vcAPIBackend{},
+}
+
+// issuerBackend returns the backend named by AGENT_BACKEND.
+func issuerBackend(name string) IssuerBackend {
+	if b, ok := issuerBackends[name]; ok {
+		return b
+	}
+	return credoBackend{}
+}
+
+// backend returns the client's backend; clients built without one talk to
+// the CREDEBL agent.
+func (a *AgentClient) backend() IssuerBackend {
+	if a.issuer == nil {
+		return credoBackend{}
+	}
+	return a.issuer
+}
+
+// credoBackend is the CREDEBL agent's Credo REST controller.
+type credoBackend struct{}
+
+func (credoBackend) GetToken(a *AgentClient) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(a.context(), "POST", a.BaseURL+"/agent/token", nil)
+	if err != nil {
+		return "", 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Authorization", a.APIKey)
+
+	body, err := a.send(req)
+	if err != nil {
+		return "", 0, err
+	}
+	var result tokenResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", 0, fmt.Errorf("invalid token response: %s", truncateBody(body))
+	}
+	if result.Token == "" {
+		return "", 0, fmt.Errorf("no token in response: %s", truncateBody(body))
+	}
+	return result.Token, 0, nil
+}
+
+func (credoBackend) Authorize(req *http.Request, apiKey, token string) {
+	req.Header.Set("Authorization", "Bearer "+token)
+}
+
+func (credoBackend) Sign(a *AgentClient, token string, payload map[string]interface{}) (json.RawMessage, error) {
+	body, err := a.postJSON(token, "/agent/credential/sign?storeCredential=true&dataTypeToSign=jsonLd", payload)
+	if err != nil {
+		return nil, err
+	}
+	signed, ok := signedCredential(body)
+	if !ok {
+		return nil, fmt.Errorf("signing failed: no proof in response: %s", truncateBody(body))
+	}
+	return signed, nil
+}
+
+// SignJWT builds the JWT and has the agent sign its signing input. The
+// agent's sign endpoint only knows JSON-LD credentials and raw data, so
+// the JWS is assembled here and the agent signs it as raw data with the
+// verification method's key.
+func (credoBackend) SignJWT(a *AgentClient, token, typ, alg, vm string, claims map[string]interface{}) (string, error) {
+	keyType, ok := agentKeyTypes[alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported JWT algorithm %s", alg)
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "typ": typ, "kid": vm})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("marshaling claims: %w", err)
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+
+	respBody, err := a.postJSON(token, "/agent/credential/sign?dataTypeToSign=rawData", rawSignRequest{
+		Data:    input,
+		KeyType: keyType,
+		Method:  vm,
+	})
+	if err != nil {
+		return "", err
+	}
+	sig, err := parseRawSignature(respBody)
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func (credoBackend) Verify(a *AgentClient, token string, credential json.RawMessage) (*AgentVerification, error) {
+	body, err := a.postJSON(token, "/agent/credential/verify", verifyRequest{Credential: credential})
+	if err != nil {
+		return nil, err
+	}
+	return parseVerification(body)
+}
+
+func (credoBackend) HealthPath() string { return "/agent" }
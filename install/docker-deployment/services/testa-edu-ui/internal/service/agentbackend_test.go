@@ -0,0 +1,109 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestVCAPIBackend checks credentials are issued and verified through the
+// VC-API endpoints, with invalid credentials reported, not failed.
+func TestVCAPIBackend(t *testing.T) {
+	var issued vcAPIIssueRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/credentials/issue":
+			json.NewDecoder(r.Body).Decode(&issued)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"verifiableCredential":{"id":"urn:1","proof":{"type":"Ed25519Signature2020"}}}`))
+		case "/credentials/verify":
+			var req vcAPIVerifyRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if string(req.VerifiableCredential) == `{"tampered":true}` {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"checks":["proof"],"errors":[{"message":"proof verification failed"}]}`))
+				return
+			}
+			w.Write([]byte(`{"checks":["proof"],"warnings":[],"errors":[]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	agent := &AgentClient{BaseURL: srv.URL, APIKey: "key", client: srv.Client(), issuer: issuerBackends[AgentBackendVCAPI]}
+
+	token, err := agent.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := agent.SignCredential(token, map[string]interface{}{
+		"credential":         map[string]interface{}{"id": "urn:1"},
+		"verificationMethod": "did:key:z6Mk#z6Mk",
+		"proofType":          "Ed25519Signature2020",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(signed) != `{"id":"urn:1","proof":{"type":"Ed25519Signature2020"}}` {
+		t.Errorf("signed %s", signed)
+	}
+	if issued.Options["verificationMethod"] != "did:key:z6Mk#z6Mk" || issued.Options["proofType"] != "Ed25519Signature2020" {
+		t.Errorf("issue options %v", issued.Options)
+	}
+
+	if v, err := agent.VerifyCredential(token, signed); err != nil || !v.Verified || len(v.Checks) != 1 {
+		t.Errorf("valid credential: %+v, %v", v, err)
+	}
+	v, err := agent.VerifyCredential(token, json.RawMessage(`{"tampered":true}`))
+	if err != nil || v.Verified || v.Message() != "proof verification failed" || v.Checks[0].Status != CheckFailed {
+		t.Errorf("tampered credential: %+v, %v", v, err)
+	}
+	if _, err := agent.SignCredentialJWT(token, map[string]interface{}{}, "EdDSA"); !errors.Is(err, errBackendUnsupported) {
+		t.Errorf("JWT signing: %v", err)
+	}
+}
+
+// TestACAPyBackend checks ACA-Py's admin API key is sent and JWTs are
+// signed by its wallet.
+func TestACAPyBackend(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") != "admin" || r.Header.Get("Authorization") != "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/wallet/jwt/sign":
+			var req acapyJWTRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.VerificationMethod != "did:key:z6Mk#z6Mk" || req.Headers["typ"] != "vc+sd-jwt" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`"eyJhbGciOiJFZERTQSJ9.e30.c2ln"`))
+		case "/vc/credentials/verify":
+			w.Write([]byte(`{"verified":false,"results":[{"verified":false,"error":"invalid signature"}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	agent := &AgentClient{BaseURL: srv.URL, APIKey: "admin", client: srv.Client(), issuer: issuerBackends[AgentBackendACAPy]}
+
+	token, err := agent.GetToken()
+	if err != nil || token != "" {
+		t.Fatalf("token %q, %v", token, err)
+	}
+	jwt, err := agent.SignSDJWT(token, map[string]interface{}{"iss": "did:key:z6Mk"}, "did:key:z6Mk#z6Mk", "EdDSA")
+	if err != nil || jwt != "eyJhbGciOiJFZERTQSJ9.e30.c2ln" {
+		t.Errorf("JWT %q, %v", jwt, err)
+	}
+	if v, err := agent.VerifyCredential(token, json.RawMessage(`{}`)); err != nil || v.Verified || v.Message() != "proof 1: invalid signature" {
+		t.Errorf("verification %+v, %v", v, err)
+	}
+}
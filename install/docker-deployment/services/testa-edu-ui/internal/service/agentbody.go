@@ -0,0 +1,138 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Agent response bodies. The status is checked before anything is read:
+// of an error response only the first maxAgentErrorBody bytes are read,
+// for the agent's code and message. A successful response is read up to
+// AGENT_MAX_RESPONSE bytes (8 MiB by default); past that the call fails
+// with errAgentResponseTooLarge instead of buffering the rest. Responses
+// that can grow with the wallet, such as DID lists, are decoded from the
+// stream (getJSONInto) rather than read into memory first.
+//
+// A response that is too large or is not the JSON expected is an
+// *AgentResponseError: the agent answered, so it is not retried, failed
+// over or counted against the circuit breaker.
+
+// maxAgentErrorBody bounds what is read of an error response.
+const maxAgentErrorBody = 64 << 10
+
+// defaultAgentMaxResponse bounds responses for clients built without
+// AGENT_MAX_RESPONSE.
+const defaultAgentMaxResponse = 8 << 20
+
+var errAgentResponseTooLarge = errors.New("response too large")
+
+// AgentResponseError is a successful response from the agent that could
+// not be read: too large, or not valid JSON.
+type AgentResponseError struct {
+	Endpoint string
+	Err      error
+}
+
+func (e *AgentResponseError) Error() string {
+	return fmt.Sprintf("agent %s: %v", e.Endpoint, e.Err)
+}
+
+func (e *AgentResponseError) Unwrap() error { return e.Err }
+
+// limitedBody reads at most n bytes of r, then fails with
+// errAgentResponseTooLarge if there are more.
+type limitedBody struct {
+	r io.Reader
+	n int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, errAgentResponseTooLarge
+	}
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.n {
+		n, l.n = int(l.n), -1
+		return n, errAgentResponseTooLarge
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+// maxResponse is the largest response body the client reads.
+func (a *AgentClient) maxResponse() int64 {
+	if a.responseLimit > 0 {
+		return a.responseLimit
+	}
+	return defaultAgentMaxResponse
+}
+
+// readAgentResponse reads resp's body: an error status becomes an
+// *AgentError, and a successful body is passed to decode, or read whole
+// when decode is nil.
+func (a *AgentClient) readAgentResponse(req *http.Request, resp *http.Response, decode func(io.Reader) error) ([]byte, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxAgentErrorBody))
+		return nil, newAgentError(req.URL.Path, resp.StatusCode, body)
+	}
+	r := &limitedBody{r: resp.Body, n: a.maxResponse()}
+	if a.contract != nil {
+		return a.readCheckedResponse(req, resp.StatusCode, r, decode)
+	}
+	if decode != nil {
+		return nil, agentBodyError(req.URL.Path, decode(r))
+	}
+	body, err := io.ReadAll(r)
+	return body, agentBodyError(req.URL.Path, err)
+}
+
+// readCheckedResponse reads a successful body whole and checks it against
+// the agent's API before passing it to decode.
+func (a *AgentClient) readCheckedResponse(req *http.Request, status int, r io.Reader, decode func(io.Reader) error) ([]byte, error) {
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, agentBodyError(req.URL.Path, err)
+	}
+	if err := a.checkResponseContract(req, status, body); err != nil {
+		return nil, err
+	}
+	if decode != nil {
+		return nil, agentBodyError(req.URL.Path, decode(bytes.NewReader(body)))
+	}
+	return body, nil
+}
+
+// agentBodyError classifies an error reading a successful response: an
+// oversized or malformed body is an *AgentResponseError, anything else a
+// failure to read it from the agent.
+func agentBodyError(endpoint string, err error) error {
+	var syntax *json.SyntaxError
+	var typ *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errAgentResponseTooLarge), errors.As(err, &syntax), errors.As(err, &typ):
+		return &AgentResponseError{Endpoint: endpoint, Err: err}
+	}
+	return fmt.Errorf("reading response: %w", err)
+}
+
+// getJSONInto fetches an authenticated agent endpoint and decodes its JSON
+// response into v as it arrives.
+func (a *AgentClient) getJSONInto(token, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(a.context(), "GET", a.BaseURL+path, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	_, err = a.doStream(token, req, func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(v)
+	})
+	return err
+}
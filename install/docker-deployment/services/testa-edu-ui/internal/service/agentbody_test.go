@@ -0,0 +1,58 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAgentResponseLimit checks oversized responses fail without being
+// retried or tripping the breaker, that error bodies are read only in part,
+// and that lists are decoded from the stream.
+func TestAgentResponseLimit(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		switch r.URL.Path {
+		case "/big":
+			w.Write([]byte(`{"pad":"` + strings.Repeat("x", 2048) + `"}`))
+		case "/fail":
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"` + strings.Repeat("y", 2*maxAgentErrorBody) + `"}`))
+		case "/dids":
+			w.Write([]byte(`[{"did":"did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"}]`))
+		case "/html":
+			w.Write([]byte(`<html>login</html>`))
+		}
+	}))
+	defer srv.Close()
+	breaker := &circuitBreaker{threshold: 1, cooldown: time.Hour}
+	agent := &AgentClient{BaseURL: srv.URL, client: srv.Client(), retries: 3, breaker: breaker, responseLimit: 1024}
+
+	_, err := agent.getJSON("t", "/big")
+	var re *AgentResponseError
+	if !errors.As(err, &re) || !errors.Is(err, errAgentResponseTooLarge) {
+		t.Fatalf("oversized response: %v", err)
+	}
+	if calls.Load() != 1 || !breaker.closed() {
+		t.Errorf("oversized response retried (%d calls) or tripped the breaker", calls.Load())
+	}
+
+	_, err = agent.getJSON("t", "/fail")
+	var ae *AgentError
+	if !errors.As(err, &ae) || ae.StatusCode != http.StatusBadRequest || len(ae.Body) != maxAgentErrorBody {
+		t.Errorf("error response: %v", err)
+	}
+
+	var dids []credoDID
+	if err := agent.getJSONInto("t", "/dids", &dids); err != nil || len(dids) != 1 {
+		t.Errorf("streamed list: %v, %v", dids, err)
+	}
+	if err := agent.getJSONInto("t", "/html", &dids); !errors.As(err, &re) {
+		t.Errorf("non-JSON response: %v", err)
+	}
+}
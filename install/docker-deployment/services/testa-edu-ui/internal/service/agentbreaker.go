@@ -0,0 +1,126 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Agent circuit breaker. While the agent is down, every wizard step would
+// otherwise wait out its connection attempts and retries, piling requests
+// up. After AGENT_BREAKER_THRESHOLD consecutive failed calls to an agent
+// the breaker opens and calls fail at once with errAgentUnavailable. After
+// AGENT_BREAKER_COOLDOWN one call is let through as a probe: its success
+// closes the breaker, its failure opens it for another cooldown.
+//
+// Only the agent being unreachable or failing (5xx) counts; requests it
+// rejects (4xx) show the agent is up.
+
+var errAgentUnavailable = errors.New("agent unavailable")
+
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+var (
+	agentBreakersMu sync.Mutex
+	agentBreakers   = map[string]*circuitBreaker{}
+)
+
+// agentBreaker returns the breaker shared by clients of an agent URL, or
+// nil when breaking is disabled.
+func agentBreaker(baseURL string) *circuitBreaker {
+	if config.AgentBreakerThreshold <= 0 {
+		return nil
+	}
+	agentBreakersMu.Lock()
+	defer agentBreakersMu.Unlock()
+	b, ok := agentBreakers[baseURL]
+	if !ok {
+		b = &circuitBreaker{threshold: config.AgentBreakerThreshold, cooldown: config.AgentBreakerCooldown}
+		agentBreakers[baseURL] = b
+	}
+	return b
+}
+
+// allow returns an error wrapping errAgentUnavailable if a call must not
+// be made now.
+func (b *circuitBreaker) allow() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 || b.probing {
+		return fmt.Errorf("%w after %d failed calls; retrying in %s", errAgentUnavailable, b.failures, max(wait, 0).Round(time.Second))
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts a call's outcome.
+func (b *circuitBreaker) record(err error) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if !agentDown(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// closed reports whether calls are going through normally.
+func (b *circuitBreaker) closed() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures < b.threshold
+}
+
+// unavailable reports whether every agent the client calls has its
+// breaker open, so that calls would fail at once.
+func (a *AgentClient) unavailable() bool {
+	if a.local != nil {
+		return false
+	}
+	for _, ep := range a.endpoints() {
+		if ep.breaker.closed() {
+			return false
+		}
+	}
+	return true
+}
+
+// agentDown reports whether a call's error means the agent is unreachable
+// or failing.
+func agentDown(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var ae *AgentError
+	if errors.As(err, &ae) {
+		return ae.StatusCode >= 500
+	}
+	var re *AgentResponseError
+	return !errors.As(err, &re)
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestAgentBreaker checks the breaker opens after consecutive agent
+// failures, fails calls fast while open, and closes after a good probe.
+func TestAgentBreaker(t *testing.T) {
+	var calls int
+	status := http.StatusInternalServerError
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(status)
+		w.Write([]byte(`{"token":"t"}`))
+	}))
+	defer srv.Close()
+	breaker := &circuitBreaker{threshold: 3, cooldown: time.Hour}
+	agent := &AgentClient{BaseURL: srv.URL, client: srv.Client(), breaker: breaker}
+
+	for range 3 {
+		if _, err := agent.GetToken(); errors.Is(err, errAgentUnavailable) {
+			t.Fatalf("breaker open before the threshold: %v", err)
+		}
+	}
+	_, err := agent.GetToken()
+	if !errors.Is(err, errAgentUnavailable) || calls != 3 {
+		t.Fatalf("open breaker: %v after %d calls", err, calls)
+	}
+	if msg := agentFailure(context.Background(), "token error", err); msg != "The credential agent is unavailable. Please try again shortly." {
+		t.Errorf("user message %q", msg)
+	}
+
+	// After the cooldown one probe goes through; its failure reopens.
+	breaker.openUntil = time.Now()
+	if _, err := agent.GetToken(); errors.Is(err, errAgentUnavailable) || calls != 4 {
+		t.Fatalf("probe: %v after %d calls", err, calls)
+	}
+	if _, err := agent.GetToken(); !errors.Is(err, errAgentUnavailable) {
+		t.Fatalf("failed probe did not reopen: %v", err)
+	}
+
+	status = http.StatusOK
+	breaker.openUntil = time.Now()
+	if _, err := agent.GetToken(); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if !breaker.closed() {
+		t.Error("good probe did not close the breaker")
+	}
+
+	// Rejected requests show the agent is up.
+	status = http.StatusUnauthorized
+	for range 5 {
+		agent.GetToken()
+	}
+	if !breaker.closed() {
+		t.Error("4xx responses opened the breaker")
+	}
+}
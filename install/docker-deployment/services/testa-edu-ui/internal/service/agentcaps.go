@@ -0,0 +1,329 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Agent capabilities. At startup, and when staff ask from /admin/agent,
+// the agent is asked for its version and the DIDs in its wallet, and from
+// their key types what proof types it can sign. Issuing then refuses a
+// proof type the issuer DID's key cannot sign, when the form is submitted
+// instead of at the signing step, and the index page offers only proof
+// types the agent can sign. Configured issuer DIDs missing from the wallet
+// and default proof types their keys cannot sign are reported as problems
+// in the log and on the admin page.
+//
+// What the agent does not say is not held against it: with its DIDs
+// unknown (a VC-API backend, or an agent without a DID listing), every
+// proof type is allowed, as before.
+
+// AgentDID is a DID in the agent's wallet.
+type AgentDID struct {
+	DID     string `json:"did"`
+	KeyType string `json:"keyType,omitempty"` // ed25519, secp256k1, bls12381g2 or p256
+}
+
+// AgentCapabilities is what was learned about the agent.
+type AgentCapabilities struct {
+	Backend string `json:"backend"`
+	Version string `json:"version,omitempty"`
+	Label   string `json:"label,omitempty"`
+	// DIDs is nil when the agent does not list its DIDs.
+	DIDs      []AgentDID `json:"dids"`
+	Problems  []string   `json:"problems,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	CheckedAt time.Time  `json:"checkedAt"`
+}
+
+// keyProofTypes are the proof types a key type can sign.
+var keyProofTypes = map[string][]string{
+	"ed25519":    {"Ed25519Signature2020", "JsonWebSignature2020"},
+	"secp256k1":  {"EcdsaSecp256k1Signature2019", "EcdsaSecp256k1RecoverySignature2020", "JsonWebSignature2020"},
+	"bls12381g2": {"BbsBlsSignature2020"},
+	"p256":       {"JsonWebSignature2020"},
+}
+
+var (
+	agentCapsMu sync.Mutex
+	agentCaps   *AgentCapabilities
+)
+
+// currentAgentCapabilities returns the last detected capabilities, or nil.
+func currentAgentCapabilities() *AgentCapabilities {
+	agentCapsMu.Lock()
+	defer agentCapsMu.Unlock()
+	return agentCaps
+}
+
+// detectAgentCapabilities asks the agent what it can do, records the
+// answer and logs any problems.
+func detectAgentCapabilities(agent *AgentClient) *AgentCapabilities {
+	caps := &AgentCapabilities{Backend: config.AgentBackend}
+	token, err := agent.GetToken()
+	if err == nil {
+		caps, err = agent.backend().Capabilities(agent, token)
+	}
+	if err != nil {
+		caps = &AgentCapabilities{Backend: config.AgentBackend, Error: err.Error()}
+		slog.Error("agent capabilities", "err", err)
+	}
+	caps.CheckedAt = time.Now()
+	caps.Problems = caps.problems(issuerOptions())
+	for _, p := range caps.Problems {
+		slog.Warn("agent: " + p)
+	}
+	agentCapsMu.Lock()
+	agentCaps = caps
+	agentCapsMu.Unlock()
+	return caps
+}
+
+// startAgentCapabilities detects the agent's capabilities in the
+// background, so an agent that is down does not hold up startup.
+func startAgentCapabilities() {
+	if agentClient.local != nil {
+		return
+	}
+	go detectAgentCapabilities(agentClient)
+}
+
+// did returns the wallet's entry for a DID.
+func (c *AgentCapabilities) did(did string) (AgentDID, bool) {
+	for _, d := range c.DIDs {
+		if d.DID == did {
+			return d, true
+		}
+	}
+	return AgentDID{}, false
+}
+
+// ProofTypes lists the proof types the agent's keys can sign, or nil when
+// unknown.
+func (c *AgentCapabilities) ProofTypes() []string {
+	if c == nil || c.DIDs == nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var types []string
+	for _, d := range c.DIDs {
+		for _, pt := range keyProofTypes[d.KeyType] {
+			if !seen[pt] {
+				seen[pt] = true
+				types = append(types, pt)
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// canSign reports whether the agent can sign proofType as issuerDID, as
+// far as is known.
+func (c *AgentCapabilities) canSign(issuerDID, proofType string) bool {
+	if c == nil || c.DIDs == nil {
+		return true
+	}
+	d, ok := c.did(issuerDID)
+	if !ok || d.KeyType == "" {
+		return true
+	}
+	for _, pt := range keyProofTypes[d.KeyType] {
+		if pt == proofType {
+			return true
+		}
+	}
+	return false
+}
+
+// problems lists the issuers the agent cannot issue as configured.
+func (c *AgentCapabilities) problems(issuers []IssuerOption) []string {
+	if c.Error != "" {
+		return []string{"the agent's capabilities could not be detected: " + c.Error}
+	}
+	if c.DIDs == nil {
+		return nil
+	}
+	var out []string
+	for _, iss := range issuers {
+		d, ok := c.did(iss.DID)
+		switch pt := proofTypeFor(iss.DID); {
+		case !ok:
+			out = append(out, fmt.Sprintf("issuer DID %s is not in the agent's wallet", iss.DID))
+		case d.KeyType != "" && !c.canSign(iss.DID, pt):
+			out = append(out, fmt.Sprintf("issuer DID %s has a %s key, which cannot sign %s proofs", iss.DID, d.KeyType, pt))
+		}
+	}
+	return out
+}
+
+// didKeyType infers a DID's key type from its method or did:key prefix.
+func didKeyType(did string) string {
+	switch {
+	case strings.HasPrefix(did, "did:polygon:"), strings.HasPrefix(did, "did:ethr:"), strings.HasPrefix(did, "did:key:zQ3s"):
+		return "secp256k1"
+	case strings.HasPrefix(did, "did:key:z6Mk"), strings.HasPrefix(did, "did:sov:"), strings.HasPrefix(did, "did:indy:"):
+		return "ed25519"
+	case strings.HasPrefix(did, "did:key:zUC7"):
+		return "bls12381g2"
+	case strings.HasPrefix(did, "did:key:zDn"):
+		return "p256"
+	}
+	return ""
+}
+
+// verificationKeyTypes maps verification method types to key types.
+var verificationKeyTypes = map[string]string{
+	"Ed25519VerificationKey2018":        "ed25519",
+	"Ed25519VerificationKey2020":        "ed25519",
+	"EcdsaSecp256k1VerificationKey2019": "secp256k1",
+	"EcdsaSecp256k1RecoveryMethod2020":  "secp256k1",
+	"Bls12381G2Key2020":                 "bls12381g2",
+}
+
+// jwkKeyTypes maps JWK curves to key types.
+var jwkKeyTypes = map[string]string{"Ed25519": "ed25519", "secp256k1": "secp256k1", "BLS12381_G2": "bls12381g2", "P-256": "p256"}
+
+// credoDID is a DID record of the Credo REST controller's GET /dids.
+type credoDID struct {
+	DID         string `json:"did"`
+	DIDDocument struct {
+		VerificationMethod []struct {
+			Type         string `json:"type"`
+			PublicKeyJwk struct {
+				Crv string `json:"crv"`
+			} `json:"publicKeyJwk"`
+		} `json:"verificationMethod"`
+	} `json:"didDocument"`
+}
+
+func (d credoDID) keyType() string {
+	for _, vm := range d.DIDDocument.VerificationMethod {
+		if kt, ok := verificationKeyTypes[vm.Type]; ok {
+			return kt
+		}
+		if kt, ok := jwkKeyTypes[vm.PublicKeyJwk.Crv]; ok {
+			return kt
+		}
+	}
+	return didKeyType(d.DID)
+}
+
+// Capabilities reads the agent's GET /agent and its wallet's GET /dids.
+func (credoBackend) Capabilities(a *AgentClient, token string) (*AgentCapabilities, error) {
+	caps := &AgentCapabilities{Backend: AgentBackendCredo}
+	body, err := a.getJSON(token, "/agent")
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Label         string `json:"label"`
+		Version       string `json:"version"`
+		IsInitialized *bool  `json:"isInitialized"`
+	}
+	json.Unmarshal(body, &info)
+	if info.IsInitialized != nil && !*info.IsInitialized {
+		return nil, fmt.Errorf("the agent's wallet is not initialized")
+	}
+	caps.Label, caps.Version = info.Label, info.Version
+
+	var dids []credoDID
+	err = a.getJSONInto(token, "/dids", &dids)
+	if notFound(err) {
+		return caps, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	caps.DIDs = []AgentDID{}
+	for _, d := range dids {
+		caps.DIDs = append(caps.DIDs, AgentDID{DID: d.DID, KeyType: d.keyType()})
+	}
+	return caps, nil
+}
+
+// Capabilities reads ACA-Py's GET /status and its wallet's GET
+// /wallet/did.
+func (acapyBackend) Capabilities(a *AgentClient, token string) (*AgentCapabilities, error) {
+	caps := &AgentCapabilities{Backend: AgentBackendACAPy}
+	body, err := a.getJSON(token, "/status")
+	if err != nil {
+		return nil, err
+	}
+	var status struct {
+		Label   string `json:"label"`
+		Version string `json:"version"`
+	}
+	json.Unmarshal(body, &status)
+	caps.Label, caps.Version = status.Label, status.Version
+
+	var list struct {
+		Results []struct {
+			DID     string `json:"did"`
+			KeyType string `json:"key_type"`
+			Method  string `json:"method"`
+		} `json:"results"`
+	}
+	if err := a.getJSONInto(token, "/wallet/did", &list); err != nil {
+		return nil, err
+	}
+	caps.DIDs = []AgentDID{}
+	for _, d := range list.Results {
+		did := d.DID
+		if !strings.HasPrefix(did, "did:") {
+			did = "did:" + d.Method + ":" + did
+		}
+		kt := d.KeyType
+		if kt == "" {
+			kt = didKeyType(did)
+		}
+		caps.DIDs = append(caps.DIDs, AgentDID{DID: did, KeyType: kt})
+	}
+	return caps, nil
+}
+
+// Capabilities reports nothing: the VC-API has no discovery.
+func (vcAPIBackend) Capabilities(a *AgentClient, token string) (*AgentCapabilities, error) {
+	return &AgentCapabilities{Backend: AgentBackendVCAPI}, nil
+}
+
+// notFound reports whether the agent has no such endpoint.
+func notFound(err error) bool {
+	var ae *AgentError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusNotFound
+}
+
+func handleAgentPage(w http.ResponseWriter, r *http.Request) {
+	if err := pages(r).ExecuteTemplate(w, "agent", map[string]interface{}{
+		"AgentURLs": config.AgentURLs,
+		"Backend":   config.AgentBackend,
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handleAgentCapabilities shows the detected capabilities (GET) or
+// detects them again (POST).
+func handleAgentCapabilities(w http.ResponseWriter, r *http.Request) {
+	if agentClient.local != nil {
+		pages(r).ExecuteTemplate(w, "agent-capabilities", map[string]interface{}{"Local": true})
+		return
+	}
+	caps := currentAgentCapabilities()
+	if r.Method == http.MethodPost || caps == nil {
+		caps = detectAgentCapabilities(agentClient.WithContext(r.Context()))
+	}
+	pages(r).ExecuteTemplate(w, "agent-capabilities", map[string]interface{}{
+		"Caps":       caps,
+		"Listed":     caps.DIDs != nil,
+		"ProofTypes": caps.ProofTypes(),
+	})
+}
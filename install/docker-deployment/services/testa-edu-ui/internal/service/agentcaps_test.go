@@ -0,0 +1,76 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestAgentCapabilities checks the agent's DIDs decide the proof types
+// offered and accepted, and that issuers it cannot serve are reported.
+func TestAgentCapabilities(t *testing.T) {
+	const polygonDID = "did:polygon:0xD3A288e4cCeb5ADE57c5B674475d6728Af3bD9Fd"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent/token":
+			w.Write([]byte(`{"token":"t"}`))
+		case "/agent":
+			w.Write([]byte(`{"label":"testa","version":"1.2.0","isInitialized":true}`))
+		case "/dids":
+			w.Write([]byte(`[{"did":"` + polygonDID + `"},{"did":"did:web:testa.example","didDocument":{"verificationMethod":[{"type":"Ed25519VerificationKey2018"}]}}]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	saved, savedCaps, savedLive := config, agentCaps, current.Load()
+	savedClient := agentClient
+	t.Cleanup(func() {
+		config, agentCaps = saved, savedCaps
+		current.Store(savedLive)
+		agentClient = savedClient
+	})
+	config.IssuerDID, config.ProofType = polygonDID, "Ed25519Signature2020"
+	config.IssuerDIDs = []IssuerOption{{Name: "Other", DID: "did:web:other.example"}}
+	config.DefaultLanguage = "en"
+	if err := initLanguages(); err != nil {
+		t.Fatal(err)
+	}
+
+	caps := detectAgentCapabilities(&AgentClient{BaseURL: srv.URL, client: srv.Client()})
+	if caps.Version != "1.2.0" || len(caps.DIDs) != 2 || caps.DIDs[0].KeyType != "secp256k1" || caps.DIDs[1].KeyType != "ed25519" {
+		t.Fatalf("capabilities %+v", caps)
+	}
+	want := []string{
+		"issuer DID " + polygonDID + " has a secp256k1 key, which cannot sign Ed25519Signature2020 proofs",
+		"issuer DID did:web:other.example is not in the agent's wallet",
+	}
+	if !slices.Equal(caps.Problems, want) {
+		t.Errorf("problems %q, want %q", caps.Problems, want)
+	}
+
+	if _, err := resolveProofType("BbsBlsSignature2020", polygonDID); err == nil {
+		t.Error("proof type the issuer's key cannot sign accepted")
+	}
+	if _, err := resolveProofType("EcdsaSecp256k1Signature2019", polygonDID); err != nil {
+		t.Error(err)
+	}
+	if _, err := resolveProofType("BbsBlsSignature2020", "did:web:unknown.example"); err != nil {
+		t.Errorf("unlisted DID refused: %v", err)
+	}
+	if offered := offeredProofTypes(); slices.Contains(offered, "BbsBlsSignature2020") || !slices.Contains(offered, "Ed25519Signature2020") {
+		t.Errorf("offered %v", offered)
+	}
+
+	agentClient = &AgentClient{BaseURL: srv.URL, client: srv.Client()}
+	rec := httptest.NewRecorder()
+	handleAgentCapabilities(rec, httptest.NewRequest("GET", "/api/staff/agent/capabilities", nil))
+	for _, s := range []string{"1.2.0", "did:web:testa.example", "is not in the agent&#39;s wallet"} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Errorf("admin page lacks %q: %s", s, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Agent API contract. templates-data/agent-openapi.json describes the
+// operations of the agent's API the service calls, written from the Credo
+// controller's routes with the members the service sends and reads; it is
+// kept by hand, not checked against the controller's own OpenAPI document.
+// The contract tests run AgentClient against the mock agent and check every
+// request and response against it, and the end-to-end tests do the same
+// against a real agent (E2E_AGENT_URL), so an agent upgrade that renames a
+// member or changes a response shows up in a test run rather than as a
+// wizard step failing in production.
+//
+// AGENT_CONTRACT checks calls at runtime too: "log" logs each request or
+// response that does not match, "strict" also fails the call, a request
+// before it is sent and a response as an *AgentResponseError. It needs
+// AGENT_BACKEND=credo; calls to operations the document does not
+// describe are not checked. Checking a response reads it whole before it
+// is decoded, so streamed lists are buffered, up to AGENT_MAX_RESPONSE.
+
+const (
+	AgentContractOff    = "off"
+	AgentContractLog    = "log"
+	AgentContractStrict = "strict"
+)
+
+// AgentContract is the agent's API as an OpenAPI document describes it.
+// Schemas are checked with the validator of the credential schema
+// (schema.go), so they may only use the keywords it supports, and $ref
+// only to components/schemas.
+type AgentContract struct {
+	Version    string
+	operations []*contractOperation
+}
+
+// contractOperation is one method on one path of the document.
+type contractOperation struct {
+	method string
+	path   string
+	// segments are path's segments; a templated one such as {did}
+	// matches any segment.
+	segments        []string
+	requestRequired bool
+	request         map[string]interface{}
+	responses       map[string]map[string]interface{}
+}
+
+// ContractError lists how a request or response differs from the agent's
+// API.
+type ContractError struct {
+	// Message is "request" or "response".
+	Message  string
+	Method   string
+	Path     string
+	Problems []string
+}
+
+func (e *ContractError) Error() string {
+	return fmt.Sprintf("%s of %s %s does not match the agent's API: %s", e.Message, e.Method, e.Path, strings.Join(e.Problems, "; "))
+}
+
+var agentContract *AgentContract
+
+// LoadAgentContract reads an OpenAPI document.
+func LoadAgentContract(path string) (*AgentContract, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading agent API contract: %w", err)
+	}
+	var doc struct {
+		Info struct {
+			Version string `json:"version"`
+		} `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components struct {
+			Schemas map[string]interface{} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("parsing agent API contract: %w", err)
+	}
+	c := &AgentContract{Version: doc.Info.Version}
+	for path, methods := range doc.Paths {
+		for method, raw := range methods {
+			var op struct {
+				RequestBody *struct {
+					Required bool                              `json:"required"`
+					Content  map[string]map[string]interface{} `json:"content"`
+				} `json:"requestBody"`
+				Responses map[string]struct {
+					Content map[string]map[string]interface{} `json:"content"`
+				} `json:"responses"`
+			}
+			if json.Unmarshal(raw, &op) != nil {
+				continue // parameters and other members of a path item
+			}
+			o := &contractOperation{
+				method:    strings.ToUpper(method),
+				path:      path,
+				segments:  strings.Split(strings.Trim(path, "/"), "/"),
+				responses: map[string]map[string]interface{}{},
+			}
+			if op.RequestBody != nil {
+				o.requestRequired = op.RequestBody.Required
+				if o.request, err = contractSchema(op.RequestBody.Content, doc.Components.Schemas); err != nil {
+					return nil, fmt.Errorf("agent API contract: %s %s request: %w", o.method, path, err)
+				}
+			}
+			for status, resp := range op.Responses {
+				schema, err := contractSchema(resp.Content, doc.Components.Schemas)
+				if err != nil {
+					return nil, fmt.Errorf("agent API contract: %s %s response %s: %w", o.method, path, status, err)
+				}
+				o.responses[strings.ToUpper(status)] = schema
+			}
+			c.operations = append(c.operations, o)
+		}
+	}
+	sort.Slice(c.operations, func(i, j int) bool {
+		return c.operations[i].path+" "+c.operations[i].method < c.operations[j].path+" "+c.operations[j].method
+	})
+	return c, nil
+}
+
+// contractSchema is the JSON schema of a request or response's content,
+// with its references resolved; nil when it has none.
+func contractSchema(content map[string]map[string]interface{}, components map[string]interface{}) (map[string]interface{}, error) {
+	media, ok := content["application/json"]
+	if !ok {
+		return nil, nil
+	}
+	schema, _ := media["schema"].(map[string]interface{})
+	resolved, err := resolveContractRefs(schema, components, 0)
+	if err != nil {
+		return nil, err
+	}
+	m, _ := resolved.(map[string]interface{})
+	return m, nil
+}
+
+// resolveContractRefs replaces each {"$ref": "#/components/schemas/X"}
+// under v with a copy of the schema it names.
+func resolveContractRefs(v interface{}, components map[string]interface{}, depth int) (interface{}, error) {
+	if depth > 32 {
+		return nil, fmt.Errorf("schema references nest too deep")
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if ref, ok := val["$ref"].(string); ok {
+			name, ok := strings.CutPrefix(ref, "#/components/schemas/")
+			target, found := components[name]
+			if !ok || !found {
+				return nil, fmt.Errorf("unresolvable reference %q", ref)
+			}
+			return resolveContractRefs(target, components, depth+1)
+		}
+		out := make(map[string]interface{}, len(val))
+		for k, x := range val {
+			r, err := resolveContractRefs(x, components, depth)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = r
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, x := range val {
+			r, err := resolveContractRefs(x, components, depth)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = r
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+// operation finds the operation a call to path is made on. path may have
+// a base path before the document's paths; the operation matching the
+// most segments wins, literal segments before templated ones.
+func (c *AgentContract) operation(method, path string) *contractOperation {
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	var best *contractOperation
+	bestLiterals := -1
+	for _, o := range c.operations {
+		if o.method != method || len(o.segments) > len(segments) {
+			continue
+		}
+		tail := segments[len(segments)-len(o.segments):]
+		literals := 0
+		matched := true
+		for i, s := range o.segments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+				continue
+			}
+			if s != tail[i] {
+				matched = false
+				break
+			}
+			literals++
+		}
+		if !matched {
+			continue
+		}
+		if best == nil || len(o.segments) > len(best.segments) || len(o.segments) == len(best.segments) && literals > bestLiterals {
+			best, bestLiterals = o, literals
+		}
+	}
+	return best
+}
+
+// CheckRequest checks the body of a call to path; nil when it matches or
+// the contract does not describe the operation.
+func (c *AgentContract) CheckRequest(method, path string, body []byte) error {
+	o := c.operation(method, path)
+	if o == nil || o.request == nil {
+		return nil
+	}
+	if len(body) == 0 {
+		if o.requestRequired {
+			return &ContractError{Message: "request", Method: o.method, Path: o.path, Problems: []string{"$: missing request body"}}
+		}
+		return nil
+	}
+	return checkContractBody("request", o, o.request, body)
+}
+
+// CheckResponse checks the body of a successful response to a call to
+// path; nil when it matches or the contract does not describe the
+// operation.
+func (c *AgentContract) CheckResponse(method, path string, status int, body []byte) error {
+	o := c.operation(method, path)
+	if o == nil {
+		return nil
+	}
+	code := fmt.Sprint(status)
+	schema, ok := o.responses[code]
+	if !ok {
+		schema, ok = o.responses[code[:1]+"XX"]
+	}
+	if !ok {
+		schema, ok = o.responses["DEFAULT"]
+	}
+	if !ok {
+		return &ContractError{Message: "response", Method: o.method, Path: o.path, Problems: []string{fmt.Sprintf("undocumented status %d", status)}}
+	}
+	if schema == nil {
+		return nil
+	}
+	return checkContractBody("response", o, schema, body)
+}
+
+func checkContractBody(message string, o *contractOperation, schema map[string]interface{}, body []byte) error {
+	var doc interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return &ContractError{Message: message, Method: o.method, Path: o.path, Problems: []string{"$: not JSON: " + err.Error()}}
+	}
+	var errs []string
+	validateNode(schema, doc, "$", &errs)
+	if len(errs) > 0 {
+		return &ContractError{Message: message, Method: o.method, Path: o.path, Problems: errs}
+	}
+	return nil
+}
+
+// checkRequestContract checks an agent request against the contract, if
+// the client checks calls: in strict mode a mismatch fails the call.
+func (a *AgentClient) checkRequestContract(req *http.Request) error {
+	if a.contract == nil {
+		return nil
+	}
+	var body []byte
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+		defer r.Close()
+		if body, err = io.ReadAll(r); err != nil {
+			return fmt.Errorf("creating request: %w", err)
+		}
+	} else if req.Body != nil && req.Body != http.NoBody {
+		return nil // a body that can only be read once is left to the agent
+	}
+	return a.contractViolation(a.contract.CheckRequest(req.Method, req.URL.Path, body))
+}
+
+// checkResponseContract checks a successful response's body.
+func (a *AgentClient) checkResponseContract(req *http.Request, status int, body []byte) error {
+	err := a.contractViolation(a.contract.CheckResponse(req.Method, req.URL.Path, status, body))
+	if err != nil {
+		return &AgentResponseError{Endpoint: req.URL.Path, Err: err}
+	}
+	return nil
+}
+
+// contractViolation logs err, and returns it in strict mode.
+func (a *AgentClient) contractViolation(err error) error {
+	if err == nil {
+		return nil
+	}
+	slog.Error("agent contract", "err", err)
+	if a.strictContract {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+func loadTestContract(t *testing.T) *AgentContract {
+	t.Helper()
+	c, err := LoadAgentContract(filepath.Join("templates-data", "agent-openapi.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestAgentContract runs each call the wizard and the setup wizard make
+// against the mock agent, failing on any request or response the agent's
+// OpenAPI document does not allow.
+func TestAgentContract(t *testing.T) {
+	mock := mockagent.New()
+	mock.DIDs = []mockagent.DID{{DID: mockagent.KeyDID, VerificationMethodType: "Ed25519VerificationKey2018"}}
+	agent := &AgentClient{BaseURL: mock.Start(t), client: http.DefaultClient, contract: loadTestContract(t), strictContract: true}
+
+	token, err := agent.GetToken()
+	if err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	if _, err := (credoBackend{}).Capabilities(agent, token); err != nil {
+		t.Errorf("capabilities: %v", err)
+	}
+	did, err := agent.WriteKeyDID(token)
+	if err != nil {
+		t.Fatalf("DID write: %v", err)
+	}
+	form := CredentialForm{StudentName: "Jane Wanjiku", Institution: "Testa University", Degree: "Bachelor of Science", GraduationDate: "2025-07-15"}
+	payload := buildCredentialPayload(form, did, "Ed25519Signature2020")
+	signed, err := agent.SignCredential(token, payload)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	if _, err := agent.VerifyCredential(token, signed); err != nil {
+		t.Errorf("verify: %v", err)
+	}
+	jwt, err := agent.SignCredentialJWT(token, buildCredentialPayload(form, did, "Ed25519Signature2020"), "EdDSA")
+	if err != nil {
+		t.Fatalf("JWT sign: %v", err)
+	}
+	quoted, _ := json.Marshal(jwt)
+	if _, err := agent.VerifyCredential(token, quoted); err != nil {
+		t.Errorf("JWT verify: %v", err)
+	}
+}
+
+// TestAgentContractViolations checks a response the document does not
+// allow fails the call in strict mode, unretried, and only is logged
+// otherwise, and that a request that does not match is not sent.
+func TestAgentContractViolations(t *testing.T) {
+	mock := mockagent.New()
+	url := mock.Start(t)
+	contract := loadTestContract(t)
+	agent := &AgentClient{BaseURL: url, client: http.DefaultClient, retries: 2, contract: contract, strictContract: true}
+
+	mock.Respond("/agent/credential/verify", http.StatusOK, `{"verified":true}`)
+	_, err := agent.VerifyCredential(mock.Token, json.RawMessage(`{"proof":{}}`))
+	var re *AgentResponseError
+	var ce *ContractError
+	if !errors.As(err, &re) || !errors.As(err, &ce) || ce.Message != "response" || ce.Path != "/agent/credential/verify" {
+		t.Fatalf("renamed outcome: %v", err)
+	}
+	if n := mock.Calls("/agent/credential/verify"); n != 1 {
+		t.Errorf("verify called %d times", n)
+	}
+
+	lenient := &AgentClient{BaseURL: url, client: http.DefaultClient, contract: contract}
+	if _, err := lenient.VerifyCredential(mock.Token, json.RawMessage(`{"proof":{}}`)); err != nil {
+		t.Errorf("log mode: %v", err)
+	}
+
+	_, err = agent.SignCredential(mock.Token, map[string]interface{}{"credential": map[string]interface{}{}, "verificationMethod": "did:key:z6Mk#z6Mk"})
+	if !errors.As(err, &ce) || ce.Message != "request" {
+		t.Errorf("sign without a proof type: %v", err)
+	}
+	if n := mock.Calls("/agent/credential/sign"); n != 0 {
+		t.Errorf("a request that does not match was sent")
+	}
+}
+
+func TestAgentContractOperation(t *testing.T) {
+	c := loadTestContract(t)
+	for _, tc := range []struct{ method, path, want string }{
+		{"GET", "/dids/did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK", "/dids/{did}"},
+		{"POST", "/dids/write", "/dids/write"},
+		{"GET", "/dids", "/dids"},
+		{"POST", "/tenant/agent/credential/sign", "/agent/credential/sign"},
+		{"GET", "/credentials/7f0c", "/credentials/{credentialRecordId}"},
+		{"GET", "/status", ""},
+		{"DELETE", "/dids/write", ""},
+	} {
+		var got string
+		if o := c.operation(tc.method, tc.path); o != nil {
+			got = o.path
+		}
+		if got != tc.want {
+			t.Errorf("%s %s matched %q, want %q", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Agent failover. AGENT_URL may list several agents, comma-separated, so
+// that one agent restarting does not halt issuance. They must be replicas
+// of one agent, sharing its wallet: credentials are signed with the
+// issuer's keys, and a token from one is used with the others.
+//
+// Calls go to the first healthy agent in the list, or, with
+// AGENT_BALANCE=round-robin, to each healthy agent in turn. A call that
+// cannot reach its agent, or that the agent fails and that is safe to
+// repeat (see retryable), is made again at once on the next agent; it
+// does not use up AGENT_RETRIES. An agent is marked down when a call to
+// it fails that way and up when one succeeds, and every
+// AGENT_HEALTH_INTERVAL each agent is checked with a GET of the backend's
+// health path (/agent for the CREDEBL agent): any response but a 5xx
+// means it is up. Each agent has its own circuit
+// breaker.
+
+const (
+	AgentBalanceFailover   = "failover"
+	AgentBalanceRoundRobin = "round-robin"
+)
+
+// agentHealthTimeout bounds a health check.
+const agentHealthTimeout = 5 * time.Second
+
+// agentEndpoint is one agent of the pool.
+type agentEndpoint struct {
+	url     string
+	breaker *circuitBreaker
+	down    atomic.Bool
+}
+
+// agentPool is the agents calls can go to.
+type agentPool struct {
+	endpoints  []*agentEndpoint
+	roundRobin bool
+	next       atomic.Uint32
+}
+
+// agentFailover is the pool of the configured agents, or nil when there
+// is only one.
+var agentFailover *agentPool
+
+// parseAgentURLs reads AGENT_URL's comma-separated list.
+func parseAgentURLs(s string) ([]string, error) {
+	var urls []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimRight(strings.TrimSpace(v), "/")
+		if v == "" {
+			continue
+		}
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid agent URL %q", v)
+		}
+		urls = append(urls, v)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no agent URL")
+	}
+	return urls, nil
+}
+
+func newAgentPool(urls []string, balance string) *agentPool {
+	p := &agentPool{roundRobin: balance == AgentBalanceRoundRobin}
+	for _, u := range urls {
+		p.endpoints = append(p.endpoints, &agentEndpoint{url: u, breaker: agentBreaker(u)})
+	}
+	return p
+}
+
+// order returns the agents to try a call on: the healthy ones, starting
+// with the preferred one, then those marked down, as a last resort.
+func (p *agentPool) order() []*agentEndpoint {
+	n := len(p.endpoints)
+	start := 0
+	if p.roundRobin {
+		start = int(p.next.Add(1)-1) % n
+	}
+	up := make([]*agentEndpoint, 0, n)
+	var down []*agentEndpoint
+	for i := range n {
+		ep := p.endpoints[(start+i)%n]
+		if ep.down.Load() {
+			down = append(down, ep)
+		} else {
+			up = append(up, ep)
+		}
+	}
+	return append(up, down...)
+}
+
+// report marks an agent up or down after a call.
+func (ep *agentEndpoint) report(err error) {
+	if agentDown(err) {
+		if !ep.down.Swap(true) {
+			slog.Warn("agent is down", "agent", ep.url, "err", err)
+		}
+	} else if ep.down.Swap(false) {
+		slog.Info("agent is up", "agent", ep.url)
+	}
+}
+
+// check health-checks every agent at path.
+func (p *agentPool) check(ctx context.Context, client *http.Client, path string) {
+	for _, ep := range p.endpoints {
+		ep.report(checkAgent(ctx, client, ep.url, path))
+	}
+}
+
+// checkAgent requests GET path, returning an error if the agent cannot be
+// reached or fails.
+func checkAgent(ctx context.Context, client *http.Client, baseURL, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, agentHealthTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("agent unreachable at %s: %w", baseURL, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return newAgentError(path, resp.StatusCode, nil)
+	}
+	return nil
+}
+
+// startAgentHealthChecks checks the agents of the pool in the background.
+func startAgentHealthChecks() {
+	if agentFailover == nil || config.AgentHealthInterval <= 0 {
+		return
+	}
+	client := &http.Client{Transport: agentTransport()}
+	path := issuerBackend(config.AgentBackend).HealthPath()
+	go func() {
+		for {
+			agentFailover.check(context.Background(), client, path)
+			time.Sleep(config.AgentHealthInterval)
+		}
+	}()
+}
+
+// endpoints returns the agents to try a call on, in order.
+func (a *AgentClient) endpoints() []*agentEndpoint {
+	if a.failover == nil {
+		return []*agentEndpoint{{url: a.BaseURL, breaker: a.breaker}}
+	}
+	return a.failover.order()
+}
+
+// at returns req, made against BaseURL, redirected to the agent at ep.
+func (a *AgentClient) at(req *http.Request, ep *agentEndpoint) (*http.Request, error) {
+	if ep.url == a.BaseURL {
+		return req, nil
+	}
+	u, err := url.Parse(ep.url + strings.TrimPrefix(req.URL.Path, a.basePath))
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	u.RawQuery = req.URL.RawQuery
+	r := req.Clone(req.Context())
+	r.URL, r.Host = u, ""
+	return r, nil
+}
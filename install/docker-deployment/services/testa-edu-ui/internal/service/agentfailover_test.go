@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// TestAgentFailover checks calls move to the next agent when one is down,
+// go back once it recovers, and that non-idempotent calls the agent may
+// have handled are not repeated elsewhere.
+func TestAgentFailover(t *testing.T) {
+	var primaryCalls, secondaryCalls atomic.Int32
+	var primaryStatus atomic.Int32
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls.Add(1)
+		if s := int(primaryStatus.Load()); s != 0 {
+			w.WriteHeader(s)
+			return
+		}
+		w.Write([]byte(`{"token":"primary"}`))
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		secondaryCalls.Add(1)
+		w.Write([]byte(`{"token":"secondary","proof":{"type":"Ed25519Signature2020"}}`))
+	}))
+	defer secondary.Close()
+
+	pool := newAgentPool([]string{primary.URL, secondary.URL}, AgentBalanceFailover)
+	agent := &AgentClient{BaseURL: primary.URL, client: primary.Client(), failover: pool}
+
+	if token, err := agent.GetToken(); err != nil || token != "primary" {
+		t.Fatalf("healthy primary: %q, %v", token, err)
+	}
+
+	primaryStatus.Store(http.StatusServiceUnavailable)
+	if token, err := agent.GetToken(); err != nil || token != "secondary" {
+		t.Fatalf("failover: %q, %v", token, err)
+	}
+	if !pool.endpoints[0].down.Load() {
+		t.Error("failed agent not marked down")
+	}
+	before := primaryCalls.Load()
+	if token, _ := agent.GetToken(); token != "secondary" || primaryCalls.Load() != before {
+		t.Errorf("call went to the agent marked down first: %q", token)
+	}
+
+	// A 502 on signing may follow the agent having signed: not repeated.
+	primaryStatus.Store(http.StatusBadGateway)
+	pool.endpoints[0].down.Store(false)
+	secondaryBefore := secondaryCalls.Load()
+	if _, err := agent.SignCredential("t", map[string]interface{}{}); err == nil || secondaryCalls.Load() != secondaryBefore {
+		t.Errorf("non-idempotent call failed over: %v", err)
+	}
+
+	primaryStatus.Store(0)
+	pool.check(context.Background(), primary.Client(), "/agent")
+	if pool.endpoints[0].down.Load() {
+		t.Error("health check did not mark the agent up")
+	}
+	if token, _ := agent.GetToken(); token != "primary" {
+		t.Errorf("recovered primary not preferred: %q", token)
+	}
+}
+
+func TestAgentRoundRobin(t *testing.T) {
+	var hits [3]atomic.Int32
+	var urls []string
+	for i := range hits {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[i].Add(1)
+			w.Write([]byte(`{"token":"t"}`))
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+	agent := &AgentClient{BaseURL: urls[0], client: http.DefaultClient, failover: newAgentPool(urls, AgentBalanceRoundRobin)}
+	for range 6 {
+		if _, err := agent.GetToken(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range hits {
+		if n := hits[i].Load(); n != 2 {
+			t.Errorf("agent %d got %d calls, want 2", i, n)
+		}
+	}
+}
+
+func TestParseAgentURLs(t *testing.T) {
+	urls, err := parseAgentURLs(" http://a:8004/ , https://b/agent,")
+	if err != nil || len(urls) != 2 || urls[0] != "http://a:8004" || urls[1] != "https://b/agent" {
+		t.Errorf("parsed %q, %v", urls, err)
+	}
+	for _, bad := range []string{"", " , ", "a:8004", "ftp://a"} {
+		if _, err := parseAgentURLs(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
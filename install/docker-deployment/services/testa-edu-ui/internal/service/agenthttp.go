@@ -0,0 +1,78 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Shared agent client. All agent traffic goes through one AgentClient and
+// its pooled transport, so calls reuse kept-alive connections to the agent
+// instead of dialling it for every wizard step. Handlers scope the shared
+// client to their request with WithContext.
+//
+// An agent served over HTTPS with a private CA is trusted with
+// AGENT_CA_FILE, a PEM bundle added to the system roots. An agent that
+// requires mutual TLS gets the client certificate AGENT_CLIENT_CERT with
+// its key AGENT_CLIENT_KEY. AGENT_TLS_INSECURE=true skips verifying the
+// agent's certificate altogether, for lab setups only.
+
+// agentClient is the agent client, set up in main.
+var agentClient *AgentClient
+
+// agentTLS is the TLS configuration for HTTPS agents, set up in main; nil
+// uses the defaults.
+var agentTLS *tls.Config
+
+// agentTLSConfig builds the agent TLS configuration from the config.
+func agentTLSConfig() (*tls.Config, error) {
+	if config.AgentCAFile == "" && config.AgentClientCert == "" && !config.AgentTLSInsecure {
+		return nil, nil
+	}
+	tc := &tls.Config{MinVersion: tls.VersionTLS12, InsecureSkipVerify: config.AgentTLSInsecure}
+	if config.AgentCAFile != "" {
+		pem, err := os.ReadFile(config.AgentCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in AGENT_CA_FILE %s", config.AgentCAFile)
+		}
+		tc.RootCAs = pool
+	}
+	if config.AgentClientCert != "" {
+		cert, err := tls.LoadX509KeyPair(config.AgentClientCert, config.AgentClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// agentTransport keeps enough idle connections to the agent, a single
+// host, for the wizard's steps to run back to back without dialling.
+func agentTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   5 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       agentTLS,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          64,
+		MaxIdleConnsPerHost:   32,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAgentConnectionReuse checks calls through the shared client, and its
+// request-scoped copies, reuse one connection to the agent.
+func TestAgentConnectionReuse(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token":"t"}`))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	shared := NewAgentClient(srv.URL, "key")
+	shared.tokens = nil
+	for range 3 {
+		if _, err := shared.WithContext(context.Background()).GetToken(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Errorf("%d connections to the agent, want 1", n)
+	}
+}
+
+// TestAgentTLS checks the agent's certificate is trusted through
+// AGENT_CA_FILE and the client certificate is presented for mutual TLS.
+func TestAgentTLS(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token":"t"}`))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir := t.TempDir()
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "testa-edu-ui"}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}
+	certDER, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, savedTLS := config, agentTLS
+	t.Cleanup(func() { config, agentTLS = saved, savedTLS })
+	token := func() error {
+		var err error
+		if agentTLS, err = agentTLSConfig(); err != nil {
+			t.Fatal(err)
+		}
+		client := NewAgentClient(srv.URL, "key")
+		client.tokens = nil
+		_, err = client.GetToken()
+		return err
+	}
+
+	if err := token(); err == nil {
+		t.Error("agent with an untrusted certificate accepted")
+	}
+	config.AgentCAFile = writePEM("ca.pem", "CERTIFICATE", srv.Certificate().Raw)
+	var ae *AgentError
+	if err := token(); !errors.As(err, &ae) || ae.StatusCode != http.StatusUnauthorized {
+		t.Errorf("without a client certificate: %v", err)
+	}
+	config.AgentClientCert = writePEM("client.pem", "CERTIFICATE", certDER)
+	config.AgentClientKey = writePEM("client-key.pem", "PRIVATE KEY", keyDER)
+	if err := token(); err != nil {
+		t.Errorf("mutual TLS: %v", err)
+	}
+	config.AgentCAFile = ""
+	config.AgentTLSInsecure = true
+	if err := token(); err != nil {
+		t.Errorf("insecure: %v", err)
+	}
+}
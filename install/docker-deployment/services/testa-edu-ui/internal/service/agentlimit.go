@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Agent concurrency limit. The agent signs and verifies on modest
+// hardware, and a cohort issued in one go or a burst of verifications
+// would otherwise send it every credential at once. At most
+// AGENT_MAX_CONCURRENT sign, verify and derive calls are made to an agent
+// at a time (0 lifts the limit); further calls queue for a slot, and one
+// that has not got a slot within AGENT_QUEUE_TIMEOUT fails with
+// errAgentUnavailable, as when the breaker is open, rather than waiting
+// on. Tokens, DID operations and health checks are not limited.
+
+// agentLimiter is the slots for calls to one agent.
+type agentLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+var (
+	agentLimitersMu sync.Mutex
+	agentLimiters   = map[string]*agentLimiter{}
+)
+
+// agentLimit returns the limiter shared by clients of an agent URL, or nil
+// when calls are not limited.
+func agentLimit(baseURL string) *agentLimiter {
+	if config.AgentMaxConcurrent <= 0 {
+		return nil
+	}
+	agentLimitersMu.Lock()
+	defer agentLimitersMu.Unlock()
+	l, ok := agentLimiters[baseURL]
+	if !ok {
+		l = &agentLimiter{slots: make(chan struct{}, config.AgentMaxConcurrent), timeout: config.AgentQueueTimeout}
+		agentLimiters[baseURL] = l
+	}
+	return l
+}
+
+// acquire waits for a slot and returns the function that frees it.
+func (l *agentLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	default:
+	}
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return l.release, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("%w: %d calls in progress, none finished within %s", errAgentUnavailable, cap(l.slots), l.timeout)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *agentLimiter) release() { <-l.slots }
+
+// acquire waits for a slot for a sign, verify or derive call.
+func (a *AgentClient) acquire() (func(), error) {
+	return a.limit.acquire(a.context())
+}
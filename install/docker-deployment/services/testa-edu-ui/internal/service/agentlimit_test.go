@@ -0,0 +1,58 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestAgentLimit checks no more than the limit of verify calls reach the
+// agent at once, and that a call queued too long fails as unavailable.
+func TestAgentLimit(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+		w.Write([]byte(`{"isValid":true}`))
+	}))
+	defer srv.Close()
+
+	limit := &agentLimiter{slots: make(chan struct{}, 2), timeout: time.Minute}
+	agent := &AgentClient{BaseURL: srv.URL, client: srv.Client(), limit: limit}
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := agent.VerifyCredential("t", json.RawMessage(`{}`)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for len(limit.slots) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	impatient := &AgentClient{BaseURL: srv.URL, client: srv.Client(), limit: &agentLimiter{slots: limit.slots, timeout: 10 * time.Millisecond}}
+	if _, err := impatient.VerifyCredential("t", json.RawMessage(`{}`)); !errors.Is(err, errAgentUnavailable) {
+		t.Errorf("queue timeout: %v", err)
+	}
+	close(release)
+	wg.Wait()
+	if p := peak.Load(); p != 2 {
+		t.Errorf("%d calls at once, want 2", p)
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// OAuth2 agent authentication. Agents behind an API gateway or identity
+// provider take OAuth2 access tokens rather than tokens from the agent's
+// own /agent/token. With AGENT_TOKEN_URL set, tokens are obtained with the
+// client-credentials grant (RFC 6749 §4.4), authenticating as
+// AGENT_CLIENT_ID and AGENT_CLIENT_SECRET and asking for AGENT_TOKEN_SCOPE,
+// if set, and API_KEY is not used. Tokens are cached like agent tokens
+// (agenttoken.go) until shortly before the expires_in the token endpoint
+// gives, and a token the agent rejects is replaced.
+
+// maxOAuthResponse bounds a token endpoint response.
+const maxOAuthResponse = 64 << 10
+
+// oauthClient is the client-credentials grant configuration.
+type oauthClient struct {
+	tokenURL string
+	id       string
+	secret   string
+	scope    string
+}
+
+// agentOAuth returns the configured client-credentials grant, or nil when
+// the agent's own tokens are used.
+func agentOAuth() *oauthClient {
+	if config.AgentTokenURL == "" {
+		return nil
+	}
+	return &oauthClient{
+		tokenURL: config.AgentTokenURL,
+		id:       config.AgentClientID,
+		secret:   config.AgentClientSecret,
+		scope:    config.AgentTokenScope,
+	}
+}
+
+// oauthTokenResponse is a token endpoint response (RFC 6749 §5.1).
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// oauthError is a token endpoint error response (RFC 6749 §5.2).
+type oauthError struct {
+	Status      int
+	Code        string
+	Description string
+}
+
+func (e *oauthError) Error() string {
+	msg := e.Code
+	if e.Description != "" {
+		msg += ": " + e.Description
+	}
+	return fmt.Sprintf("token endpoint returned HTTP %d: %s", e.Status, msg)
+}
+
+// fetchOAuthToken gets an access token with the client-credentials grant,
+// and how long it is valid for, if the token endpoint said.
+func (a *AgentClient) fetchOAuthToken() (string, time.Duration, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if a.oauth.scope != "" {
+		form.Set("scope", a.oauth.scope)
+	}
+	ctx := a.context()
+	if timeout := a.callTimeout("/agent/token"); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", a.oauth.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	// Client credentials are form-encoded before Basic encoding (§2.3.1).
+	req.SetBasicAuth(url.QueryEscape(a.oauth.id), url.QueryEscape(a.oauth.secret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token endpoint unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxOAuthResponse))
+	if err != nil {
+		return "", 0, fmt.Errorf("reading token response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := &oauthError{Status: resp.StatusCode}
+		var eb struct {
+			Error       string `json:"error"`
+			Description string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &eb) == nil && eb.Error != "" {
+			e.Code, e.Description = eb.Error, eb.Description
+		} else {
+			e.Code = truncateBody(body)
+		}
+		return "", 0, e
+	}
+	var result oauthTokenResponse
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", 0, fmt.Errorf("invalid token response: %s", truncateBody(body))
+	}
+	if result.AccessToken == "" {
+		return "", 0, fmt.Errorf("no access_token in token response")
+	}
+	if result.TokenType != "" && !strings.EqualFold(result.TokenType, "Bearer") {
+		return "", 0, fmt.Errorf("unsupported token type %q", result.TokenType)
+	}
+	return result.AccessToken, time.Duration(result.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAgentOAuth checks agent tokens come from the client-credentials
+// grant, are cached for their expires_in and are renewed when rejected.
+func TestAgentOAuth(t *testing.T) {
+	var issued int
+	valid := map[string]bool{}
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "testa%3Aui" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client","error_description":"bad credentials"}`)
+			return
+		}
+		if r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "agent" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_request"}`)
+			return
+		}
+		issued++
+		token := fmt.Sprintf("access-%d", issued)
+		valid[token] = true
+		fmt.Fprintf(w, `{"access_token":%q,"token_type":"Bearer","expires_in":3600}`, token)
+	}))
+	defer idp.Close()
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent/token" {
+			t.Error("agent token endpoint called with OAuth configured")
+		}
+		if !valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+			http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"isValid":true}`))
+	}))
+	defer agent.Close()
+
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.AgentTokenTTL = time.Minute
+	config.AgentTokenURL = idp.URL + "/oauth/token"
+	config.AgentClientID, config.AgentClientSecret = "testa:ui", "s3cret"
+	config.AgentTokenScope = "agent"
+
+	a := NewAgentClient(agent.URL, "unused")
+	a.tokens = &tokenCache{ttl: time.Minute}
+	token, err := a.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := a.GetToken(); again != token || issued != 1 {
+		t.Errorf("token not cached: %s, %s after %d grants", token, again, issued)
+	}
+	if left := time.Until(a.tokens.expires); left < 59*time.Minute {
+		t.Errorf("token cached for %s, want expires_in", left)
+	}
+
+	delete(valid, token)
+	if v, err := a.VerifyCredential(token, []byte(`{}`)); err != nil || !v.Verified {
+		t.Fatalf("verify with a revoked token: %+v, %v", v, err)
+	}
+	if issued != 2 {
+		t.Errorf("%d grants, want a renewal", issued)
+	}
+
+	config.AgentClientSecret = "wrong"
+	a = NewAgentClient(agent.URL, "unused")
+	a.tokens = nil
+	if _, err := a.GetToken(); err == nil || !strings.Contains(err.Error(), "invalid_client: bad credentials") {
+		t.Errorf("bad client secret: %v", err)
+	}
+}
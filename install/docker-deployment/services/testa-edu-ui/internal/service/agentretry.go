@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Agent call retries. A network blip or an agent restarting should not
+// fail the issuance wizard, so failed agent calls are retried up to
+// AGENT_RETRIES times, waiting a jittered, exponentially growing delay
+// from AGENT_RETRY_BACKOFF between attempts (or the agent's Retry-After).
+//
+// Only calls that are safe to repeat are retried after they may have
+// reached the agent: reads, tokens, verification, derivation and raw data
+// signing, which store nothing. Signing a JSON-LD credential stores it in
+// the agent's wallet, so it is retried only when the agent cannot have
+// acted on it: the connection was refused, or the agent answered 429 or
+// 503.
+
+const maxAgentRetryDelay = 5 * time.Second
+
+// idempotentAgentPaths are the POST endpoints safe to repeat.
+var idempotentAgentPaths = []string{"/agent/token", "/agent/credential/verify", "/agent/credential/derive", "/credentials/verify", "/wallet/jwt/sign"}
+
+// idempotentAgentRequest reports whether an agent request may be repeated
+// after it reached the agent.
+func idempotentAgentRequest(req *http.Request) bool {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return true
+	}
+	if strings.HasSuffix(req.URL.Path, "/agent/credential/sign") {
+		return req.URL.Query().Get("dataTypeToSign") == "rawData"
+	}
+	for _, p := range idempotentAgentPaths {
+		if strings.HasSuffix(req.URL.Path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryable reports whether a failed agent call is worth another attempt.
+func retryable(err error, idempotent bool) bool {
+	var re *AgentResponseError
+	if errors.Is(err, context.Canceled) || errors.As(err, &re) {
+		return false
+	}
+	var ae *AgentError
+	if errors.As(err, &ae) {
+		switch ae.StatusCode {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return true
+		case http.StatusBadGateway, http.StatusGatewayTimeout:
+			return idempotent
+		}
+		return false
+	}
+	if idempotent {
+		return true
+	}
+	// The request never left: it is safe to send again.
+	var op *net.OpError
+	return errors.As(err, &op) && op.Op == "dial"
+}
+
+// retryDelay is the wait before retry attempt+1: the agent's Retry-After
+// when it gave one, else exponential backoff with jitter.
+func (a *AgentClient) retryDelay(attempt int, retryAfter time.Duration) time.Duration {
+	if retryAfter > 0 {
+		return min(retryAfter, maxAgentRetryDelay)
+	}
+	ceiling := min(a.backoff<<attempt, maxAgentRetryDelay)
+	return ceiling/2 + rand.N(ceiling/2+1)
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds.
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
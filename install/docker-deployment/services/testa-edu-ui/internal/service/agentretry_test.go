@@ -0,0 +1,78 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestAgentRetries checks idempotent calls are retried through transient
+// failures, with the request body resent, and credential signing only
+// when the agent cannot have acted on it.
+func TestAgentRetries(t *testing.T) {
+	var calls int
+	var statuses []int
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+		status := http.StatusOK
+		if calls < len(statuses) {
+			status = statuses[calls]
+		}
+		calls++
+		w.WriteHeader(status)
+		if status == http.StatusOK {
+			w.Write([]byte(`{"isValid":true,"proof":{},"token":"t"}`))
+		}
+	}))
+	defer srv.Close()
+	agent := &AgentClient{BaseURL: srv.URL, client: srv.Client(), retries: 2, backoff: time.Millisecond}
+
+	calls, statuses, bodies = 0, []int{http.StatusBadGateway, http.StatusServiceUnavailable}, nil
+	v, err := agent.VerifyCredential("token", json.RawMessage(`{"id":"urn:uuid:1"}`))
+	if err != nil || !v.Verified || calls != 3 {
+		t.Fatalf("verify: %v after %d calls", err, calls)
+	}
+	if bodies[2] != bodies[0] || bodies[0] == "" {
+		t.Errorf("retried body %q, first %q", bodies[2], bodies[0])
+	}
+
+	calls, statuses = 0, []int{http.StatusBadGateway}
+	if _, err := agent.SignCredential("token", map[string]interface{}{}); err == nil || calls != 1 {
+		t.Errorf("sign retried after a 502: %v after %d calls", err, calls)
+	}
+	calls, statuses = 0, []int{http.StatusServiceUnavailable}
+	if _, err := agent.SignCredential("token", map[string]interface{}{}); err != nil || calls != 2 {
+		t.Errorf("sign not retried after a 503: %v after %d calls", err, calls)
+	}
+
+	calls, statuses = 0, []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+	if _, err := agent.GetToken(); err == nil || calls != 3 {
+		t.Errorf("token: %v after %d calls, want failure after 3", err, calls)
+	}
+	calls, statuses = 0, []int{http.StatusBadRequest}
+	if _, err := agent.GetToken(); err == nil || calls != 1 {
+		t.Errorf("token retried after a 400: %d calls", calls)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	agent := &AgentClient{backoff: 100 * time.Millisecond}
+	for attempt, ceiling := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond} {
+		for range 20 {
+			if d := agent.retryDelay(attempt, 0); d < ceiling/2 || d > ceiling {
+				t.Errorf("attempt %d: delay %s outside [%s, %s]", attempt, d, ceiling/2, ceiling)
+			}
+		}
+	}
+	if d := agent.retryDelay(20, 0); d > maxAgentRetryDelay {
+		t.Errorf("delay %s over the maximum", d)
+	}
+	if d := agent.retryDelay(0, 3*time.Second); d != 3*time.Second {
+		t.Errorf("Retry-After ignored: %s", d)
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Agent call contexts and timeouts. Agent calls run under the context of
+// the request that makes them (AgentClient.WithContext), or the server's
+// when there is none, so a client disconnecting or the server shutting
+// down cancels them. Each attempt at a call also has a deadline: the
+// longest AGENT_TIMEOUTS prefix of its endpoint path, as
+// "/agent/token=10s,/dids/write=2m", or AGENT_TIMEOUT.
+
+// defaultAgentTimeouts apply unless AGENT_TIMEOUTS overrides them. Tokens
+// are quick; writing a did:polygon DID or an anchor waits for the
+// transaction.
+var defaultAgentTimeouts = map[string]time.Duration{
+	"/agent/token":           10 * time.Second,
+	"/dids/write":            2 * time.Minute,
+	"/polygon/create-schema": 2 * time.Minute,
+}
+
+// parseAgentTimeouts reads AGENT_TIMEOUTS over the defaults.
+func parseAgentTimeouts(s string) (map[string]time.Duration, error) {
+	m := make(map[string]time.Duration, len(defaultAgentTimeouts))
+	for path, d := range defaultAgentTimeouts {
+		m[path] = d
+	}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		path, v, ok := strings.Cut(pair, "=")
+		if !ok || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("invalid AGENT_TIMEOUTS entry %q, want /path=duration", pair)
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid AGENT_TIMEOUTS duration for %s: %q", path, v)
+		}
+		m[path] = d
+	}
+	return m, nil
+}
+
+// WithContext returns a copy of the client whose calls run under ctx.
+func (a *AgentClient) WithContext(ctx context.Context) *AgentClient {
+	c := *a
+	c.ctx = ctx
+	return &c
+}
+
+func (a *AgentClient) context() context.Context {
+	if a.ctx == nil {
+		return serverContext
+	}
+	return a.ctx
+}
+
+// callTimeout is the deadline for one attempt at a call to path; 0 means
+// none.
+func (a *AgentClient) callTimeout(path string) time.Duration {
+	path = strings.TrimPrefix(path, a.basePath)
+	timeout, longest := a.timeout, -1
+	for prefix, d := range a.timeouts {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			timeout, longest = d, len(prefix)
+		}
+	}
+	return timeout
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseAgentTimeouts(t *testing.T) {
+	m, err := parseAgentTimeouts("/agent/credential/sign=45s, /agent/token=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m["/agent/credential/sign"] != 45*time.Second || m["/agent/token"] != 5*time.Second || m["/dids/write"] != 2*time.Minute {
+		t.Errorf("timeouts %v", m)
+	}
+	for _, bad := range []string{"token=5s", "/agent/token", "/agent/token=soon"} {
+		if _, err := parseAgentTimeouts(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+
+	agent := &AgentClient{timeout: time.Minute, timeouts: m, basePath: "/api"}
+	if d := agent.callTimeout("/api/agent/token"); d != 5*time.Second {
+		t.Errorf("token timeout %s", d)
+	}
+	if d := agent.callTimeout("/api/agent/credential/verify"); d != time.Minute {
+		t.Errorf("default timeout %s", d)
+	}
+}
+
+// TestAgentContext checks a call is cut off at its endpoint's timeout and
+// that cancelling the caller's context stops retries.
+func TestAgentContext(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+
+	agent := &AgentClient{BaseURL: srv.URL, client: srv.Client(), timeouts: map[string]time.Duration{"/agent/token": 20 * time.Millisecond}}
+	start := time.Now()
+	if _, err := agent.GetToken(); !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 5*time.Second {
+		t.Errorf("token call: %v after %s", err, time.Since(start))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	agent = (&AgentClient{BaseURL: srv.URL, client: srv.Client(), retries: 5, backoff: time.Hour}).WithContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	calls.Store(0)
+	if _, err := agent.GetToken(); !errors.Is(err, context.Canceled) || calls.Load() != 1 {
+		t.Errorf("cancelled call: %v after %d calls", err, calls.Load())
+	}
+}
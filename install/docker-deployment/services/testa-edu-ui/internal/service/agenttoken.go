@@ -0,0 +1,100 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Agent token cache. Every wizard step builds a new AgentClient, and
+// fetching a token is a round trip to the agent, so tokens are cached
+// process-wide per agent and API key until shortly before they expire:
+// at the JWT's exp, or AGENT_TOKEN_TTL after issue when the token does not
+// say. A token the agent rejects (401) is dropped and the call is made
+// again once with a fresh one, so sessions holding an expired token
+// recover on their own.
+
+// agentTokenMargin is how long before expiry a cached token is replaced.
+const agentTokenMargin = 30 * time.Second
+
+type tokenCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+var (
+	agentTokensMu sync.Mutex
+	agentTokens   = map[string]*tokenCache{}
+)
+
+// agentTokenCache returns the token cache shared by clients of an agent
+// and credential (the API key, or the OAuth2 client), or nil when caching
+// is disabled.
+func agentTokenCache(baseURL, credential string) *tokenCache {
+	if config.AgentTokenTTL <= 0 {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(baseURL + "\x00" + credential))
+	key := hex.EncodeToString(sum[:])
+	agentTokensMu.Lock()
+	defer agentTokensMu.Unlock()
+	c, ok := agentTokens[key]
+	if !ok {
+		c = &tokenCache{ttl: config.AgentTokenTTL}
+		agentTokens[key] = c
+	}
+	return c
+}
+
+// get returns the cached token, fetching a new one when there is none or
+// it is about to expire. fetch returns the token's lifetime when it knows
+// it. Concurrent callers wait for one fetch.
+func (c *tokenCache) get(fetch func() (string, time.Duration, error)) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != "" && time.Now().Add(agentTokenMargin).Before(c.expires) {
+		return c.token, nil
+	}
+	token, lifetime, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	if lifetime > 0 {
+		c.token, c.expires = token, time.Now().Add(lifetime)
+		return token, nil
+	}
+	c.token, c.expires = token, tokenExpiry(token, c.ttl)
+	return token, nil
+}
+
+// invalidate drops token from the cache, if it is still the cached one.
+func (c *tokenCache) invalidate(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token == token {
+		c.token = ""
+	}
+}
+
+// tokenExpiry returns when a token expires: its exp claim, if it is a JWT
+// with one, or ttl from now.
+func tokenExpiry(token string, ttl time.Duration) time.Time {
+	if _, claims, err := decodeJWT(token); err == nil {
+		if exp, ok := claims["exp"].(float64); ok {
+			return time.Unix(int64(exp), 0)
+		}
+	}
+	return time.Now().Add(ttl)
+}
+
+// unauthorized reports whether the agent rejected a call's token.
+func unauthorized(err error) bool {
+	var ae *AgentError
+	return errors.As(err, &ae) && ae.StatusCode == http.StatusUnauthorized
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestAgentTokenCache checks tokens are fetched once and shared, and that
+// a rejected token is replaced and the call made again.
+func TestAgentTokenCache(t *testing.T) {
+	var issued int
+	valid := map[string]bool{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/agent/token":
+			issued++
+			token := fmt.Sprintf("token-%d", issued)
+			valid[token] = true
+			fmt.Fprintf(w, `{"token":%q}`, token)
+		default:
+			if !valid[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+				http.Error(w, `{"message":"Unauthorized"}`, http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"isValid":true}`))
+		}
+	}))
+	defer srv.Close()
+	cache := &tokenCache{ttl: time.Hour}
+	newAgent := func() *AgentClient { return &AgentClient{BaseURL: srv.URL, client: srv.Client(), tokens: cache} }
+
+	first, err := newAgent().GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := newAgent().GetToken(); second != first || issued != 1 {
+		t.Errorf("token not cached: %s, %s after %d fetches", first, second, issued)
+	}
+
+	// The agent restarted and forgot the token.
+	delete(valid, first)
+	if v, err := newAgent().VerifyCredential(first, []byte(`{}`)); err != nil || !v.Verified {
+		t.Fatalf("verify with a stale token: %+v, %v", v, err)
+	}
+	if token, _ := newAgent().GetToken(); token != "token-2" || issued != 2 {
+		t.Errorf("cached %s after %d fetches, want token-2", token, issued)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	exp := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	seg := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	jwt := seg(`{"alg":"HS256"}`) + "." + seg(fmt.Sprintf(`{"exp":%d}`, exp.Unix())) + ".c2ln"
+	if got := tokenExpiry(jwt, time.Hour); !got.Equal(exp) {
+		t.Errorf("JWT expiry %s, want %s", got, exp)
+	}
+	if got := time.Until(tokenExpiry("opaque", time.Hour)); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("opaque token expires in %s, want the TTL", got)
+	}
+}
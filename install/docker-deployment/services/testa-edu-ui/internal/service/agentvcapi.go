@@ -0,0 +1,186 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VC-API and ACA-Py backends (see agentbackend.go).
+//
+// vcapi speaks the W3C VC-API: POST /credentials/issue and
+// /credentials/verify, with the agent's sign request options passed as
+// issue options. It takes the OAuth2 tokens of AGENT_TOKEN_URL, or API_KEY
+// as a static bearer token, and cannot sign JWTs.
+//
+// acapy speaks ACA-Py's admin API, whose /vc endpoints follow the VC-API,
+// authenticated with API_KEY as the admin API key (X-API-Key) and, with
+// AGENT_TOKEN_URL set, a bearer token from it. JWTs are signed by
+// /wallet/jwt/sign.
+
+// vcAPIBackend is a VC-API issuer and verifier at prefix.
+type vcAPIBackend struct {
+	prefix string
+}
+
+// vcAPIIssueRequest is a VC-API issue request.
+type vcAPIIssueRequest struct {
+	Credential interface{}            `json:"credential"`
+	Options    map[string]interface{} `json:"options"`
+}
+
+// vcAPIVerifyRequest is a VC-API verify request.
+type vcAPIVerifyRequest struct {
+	VerifiableCredential json.RawMessage        `json:"verifiableCredential"`
+	Options              map[string]interface{} `json:"options"`
+}
+
+// vcAPIIssueOptions are the sign request's fields passed as issue options.
+var vcAPIIssueOptions = []string{"verificationMethod", "proofType", "cryptosuite"}
+
+func (vcAPIBackend) GetToken(a *AgentClient) (string, time.Duration, error) {
+	return a.APIKey, 0, nil
+}
+
+func (vcAPIBackend) Authorize(req *http.Request, apiKey, token string) {
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+func (b vcAPIBackend) Sign(a *AgentClient, token string, payload map[string]interface{}) (json.RawMessage, error) {
+	issue := vcAPIIssueRequest{Credential: payload["credential"], Options: map[string]interface{}{"proofPurpose": "assertionMethod"}}
+	for _, name := range vcAPIIssueOptions {
+		if v, ok := payload[name]; ok && v != "" {
+			issue.Options[name] = v
+		}
+	}
+	body, err := a.postJSON(token, b.prefix+"/credentials/issue", issue)
+	if err != nil {
+		return nil, err
+	}
+	var wrapped struct {
+		VerifiableCredential json.RawMessage `json:"verifiableCredential"`
+	}
+	if json.Unmarshal(body, &wrapped) == nil && hasValue(wrapped.VerifiableCredential) {
+		body = wrapped.VerifiableCredential
+	}
+	signed, ok := signedCredential(body)
+	if !ok {
+		return nil, fmt.Errorf("signing failed: no proof in response: %s", truncateBody(body))
+	}
+	return signed, nil
+}
+
+func (vcAPIBackend) SignJWT(a *AgentClient, token, typ, alg, vm string, claims map[string]interface{}) (string, error) {
+	return "", fmt.Errorf("signing JWTs: %w", errBackendUnsupported)
+}
+
+func (b vcAPIBackend) Verify(a *AgentClient, token string, credential json.RawMessage) (*AgentVerification, error) {
+	body, err := a.postJSON(token, b.prefix+"/credentials/verify", vcAPIVerifyRequest{
+		VerifiableCredential: credential,
+		Options:              map[string]interface{}{"proofPurpose": "assertionMethod"},
+	})
+	if err != nil {
+		var ae *AgentError
+		// VC-API verifiers answer an invalid credential with 400 and a
+		// verification result.
+		if !errors.As(err, &ae) || ae.StatusCode != http.StatusBadRequest {
+			return nil, err
+		}
+		if v, perr := parseVCAPIVerification(ae.Body); perr == nil {
+			return v, nil
+		}
+		return nil, err
+	}
+	return parseVCAPIVerification(body)
+}
+
+func (vcAPIBackend) HealthPath() string { return "/credentials/verify" }
+
+// vcAPIVerifyResponse is a VC-API verification result: the checks made,
+// and errors if the credential is not valid. ACA-Py's has its verdict.
+type vcAPIVerifyResponse struct {
+	Checks   []string          `json:"checks"`
+	Warnings []json.RawMessage `json:"warnings"`
+	Errors   []json.RawMessage `json:"errors"`
+	Verified *bool             `json:"verified"`
+}
+
+// parseVCAPIVerification reads a VC-API verification result, or one in
+// the agent's shapes (see parseVerification).
+func parseVCAPIVerification(body []byte) (*AgentVerification, error) {
+	var resp vcAPIVerifyResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid verification response: %s", truncateBody(body))
+	}
+	if resp.Verified != nil || resp.Checks == nil && resp.Errors == nil {
+		return parseVerification(body)
+	}
+	result := &AgentVerification{Verified: len(resp.Errors) == 0, Raw: body}
+	for _, e := range resp.Errors {
+		result.Errors = append(result.Errors, agentErrorText(e))
+	}
+	for _, check := range resp.Checks {
+		c := VerificationCheck{Check: check, Status: CheckPassed}
+		for _, e := range result.Errors {
+			if strings.Contains(strings.ToLower(e), strings.ToLower(check)) {
+				c.Status, c.Detail = CheckFailed, e
+				break
+			}
+		}
+		result.Checks = append(result.Checks, c)
+	}
+	if !result.Verified && len(result.Checks) == 0 {
+		result.Checks = append(result.Checks, VerificationCheck{Check: "proof", Status: CheckFailed, Detail: result.Errors[0]})
+	}
+	return result, nil
+}
+
+// acapyBackend is ACA-Py's admin API.
+type acapyBackend struct {
+	vcAPIBackend
+}
+
+func (acapyBackend) GetToken(a *AgentClient) (string, time.Duration, error) {
+	return "", 0, nil
+}
+
+func (acapyBackend) Authorize(req *http.Request, apiKey, token string) {
+	if apiKey != "" {
+		req.Header.Set("X-API-Key", apiKey)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+}
+
+// acapyJWTRequest is ACA-Py's /wallet/jwt/sign request.
+type acapyJWTRequest struct {
+	VerificationMethod string                 `json:"verificationMethod"`
+	Headers            map[string]string      `json:"headers"`
+	Payload            map[string]interface{} `json:"payload"`
+}
+
+// SignJWT has ACA-Py sign the claims with the verification method's key;
+// it sets alg from the key.
+func (acapyBackend) SignJWT(a *AgentClient, token, typ, alg, vm string, claims map[string]interface{}) (string, error) {
+	body, err := a.postJSON(token, "/wallet/jwt/sign", acapyJWTRequest{
+		VerificationMethod: vm,
+		Headers:            map[string]string{"typ": typ},
+		Payload:            claims,
+	})
+	if err != nil {
+		return "", err
+	}
+	var jwt string
+	if err := json.Unmarshal(body, &jwt); err != nil || strings.Count(jwt, ".") != 2 {
+		return "", fmt.Errorf("unexpected JWT signing response: %s", truncateBody(body))
+	}
+	return jwt, nil
+}
+
+func (acapyBackend) HealthPath() string { return "/status/live" }
@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AgentVerification is the agent's verdict on a credential: the overall
+// outcome, the individual validations it ran and why any failed.
+type AgentVerification struct {
+	Verified bool
+	Checks   []VerificationCheck
+	Errors   []string
+	Raw      json.RawMessage
+}
+
+// Message summarizes the verdict for the user.
+func (v *AgentVerification) Message() string {
+	if len(v.Errors) > 0 {
+		return strings.Join(v.Errors, "; ")
+	}
+	if v.Verified {
+		return "verified"
+	}
+	return "not verified"
+}
+
+// verifyResponse is the agent's verification response. Credo reports
+// isValid with a validation per aspect checked; older agents report
+// verified, or valid, with vc.js proof results.
+type verifyResponse struct {
+	IsValid     *bool                      `json:"isValid"`
+	Verified    *bool                      `json:"verified"`
+	Valid       *bool                      `json:"valid"`
+	Validations map[string]agentValidation `json:"validations"`
+	Results     []agentValidation          `json:"results"`
+	Error       json.RawMessage            `json:"error"`
+}
+
+type agentValidation struct {
+	IsValid  *bool           `json:"isValid"`
+	Verified *bool           `json:"verified"`
+	Error    json.RawMessage `json:"error"`
+}
+
+func (v agentValidation) passed() bool {
+	return (v.IsValid != nil && *v.IsValid) || (v.Verified != nil && *v.Verified)
+}
+
+// agentValidationNames names Credo's validations for display.
+var agentValidationNames = map[string]string{
+	"vcJs":             "signature",
+	"dataModel":        "data model",
+	"issuerIsSigner":   "issuer is signer",
+	"credentialStatus": "credential status",
+}
+
+// parseVerification reads the agent's verification response.
+func parseVerification(body []byte) (*AgentVerification, error) {
+	var resp verifyResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid verification response: %s", truncateBody(body))
+	}
+	var outcome *bool
+	for _, v := range []*bool{resp.IsValid, resp.Verified, resp.Valid} {
+		if v != nil {
+			outcome = v
+			break
+		}
+	}
+	if outcome == nil {
+		return nil, fmt.Errorf("verification response has no outcome: %s", truncateBody(body))
+	}
+	result := &AgentVerification{Verified: *outcome, Raw: body}
+
+	names := make([]string, 0, len(resp.Validations))
+	for name := range resp.Validations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		result.add(agentValidationNames[name], name, resp.Validations[name])
+	}
+	for i, r := range resp.Results {
+		result.add("", fmt.Sprintf("proof %d", i+1), r)
+	}
+	if msg := agentErrorText(resp.Error); msg != "" {
+		result.Errors = append(result.Errors, msg)
+	}
+	if !result.Verified && len(result.Errors) == 0 {
+		result.Errors = append(result.Errors, "the agent did not say why")
+	}
+	return result, nil
+}
+
+// add records one of the agent's validations; label is its display name,
+// or empty to use name.
+func (v *AgentVerification) add(label, name string, validation agentValidation) {
+	if label == "" {
+		label = name
+	}
+	check := VerificationCheck{Check: label, Status: CheckPassed}
+	if !validation.passed() {
+		check.Status = CheckFailed
+		check.Detail = agentErrorText(validation.Error)
+		if check.Detail == "" {
+			check.Detail = "failed"
+		}
+		v.Errors = append(v.Errors, label+": "+check.Detail)
+	}
+	v.Checks = append(v.Checks, check)
+}
+
+// agentErrorText reads an error the agent reports as a string or as an
+// object with a message.
+func agentErrorText(raw json.RawMessage) string {
+	if !hasValue(raw) {
+		return ""
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return s
+	}
+	var obj struct {
+		Message string `json:"message"`
+		Name    string `json:"name"`
+	}
+	if json.Unmarshal(raw, &obj) == nil && (obj.Message != "" || obj.Name != "") {
+		if obj.Message == "" {
+			return obj.Name
+		}
+		return obj.Message
+	}
+	return truncateBody(raw)
+}
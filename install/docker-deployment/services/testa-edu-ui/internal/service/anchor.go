@@ -0,0 +1,349 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Blockchain anchoring. With ANCHOR_MODE=each or batch, the SHA-256 of
+// every issued credential (its credentialDigest) is anchored on Polygon:
+// each credential on its own, or the Merkle root of those issued in each
+// ANCHOR_INTERVAL. The agent anchors a root by writing it to the Polygon
+// schema registry under ANCHOR_DID (by default ISSUER_DID), a did:polygon
+// DID in its wallet, so the transaction is paid by the DID's account and
+// signed with its key; no key is held here.
+//
+// Hashes waiting for a root are pending. Once a root is built, its batch
+// is kept in DATA_DIR/anchors.json before it is sent, and it is sent
+// again unchanged until the agent records it: new hashes go into a later
+// batch, so a failed or unconfirmed write never leads to the same
+// credentials being anchored under a second root. Failed batches are
+// retried every ANCHOR_INTERVAL, on the leader (see leader.go).
+//
+// The registry reference and each credential's Merkle path are kept with
+// the anchor, and verification reports include them as an anchor proof
+// anyone can check against the registry.
+
+const (
+	AnchorOff   = "off"
+	AnchorEach  = "each"
+	AnchorBatch = "batch"
+)
+
+// MerkleStep is one sibling on the path from a leaf to the Merkle root.
+type MerkleStep struct {
+	Hash string `json:"hash"`
+	Left bool   `json:"left,omitempty"` // the sibling is hashed before the node
+}
+
+// AnchorProof shows a credential hash is included in an anchored root.
+type AnchorProof struct {
+	Leaf       string       `json:"leaf"`
+	Path       []MerkleStep `json:"path,omitempty"`
+	Root       string       `json:"root"`
+	Network    string       `json:"network"`
+	DID        string       `json:"did"`
+	SchemaID   string       `json:"schemaId"`
+	TxHash     string       `json:"transactionHash,omitempty"`
+	AnchoredAt time.Time    `json:"anchoredAt"`
+}
+
+// Anchor is one anchored root, or while InFlight the batch being sent.
+type Anchor struct {
+	ID         string    `json:"id"`
+	Root       string    `json:"root"`
+	Leaves     []string  `json:"leaves"`
+	Network    string    `json:"network"`
+	DID        string    `json:"did"`
+	SchemaID   string    `json:"schemaId,omitempty"`
+	TxHash     string    `json:"transactionHash,omitempty"`
+	Attempts   int       `json:"attempts,omitempty"`
+	AnchoredAt time.Time `json:"anchoredAt,omitempty"`
+}
+
+type AnchorStore struct {
+	path string
+
+	mu       sync.Mutex
+	flushMu  sync.Mutex // one anchoring write at a time
+	Pending  []string   `json:"pending"`
+	InFlight *Anchor    `json:"inFlight,omitempty"`
+	Anchors  []*Anchor  `json:"anchors"`
+	byLeaf   map[string]*Anchor
+}
+
+var anchors *AnchorStore
+
+func NewAnchorStore(dataDir string) (*AnchorStore, error) {
+	s := &AnchorStore{path: filepath.Join(dataDir, "anchors.json"), byLeaf: make(map[string]*Anchor)}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("parsing anchor store: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading anchor store: %w", err)
+	}
+	for _, a := range s.Anchors {
+		for _, leaf := range a.Leaves {
+			s.byLeaf[leaf] = a
+		}
+	}
+	return s, nil
+}
+
+func (s *AnchorStore) saveLocked() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing anchor store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Add queues a credential hash for anchoring.
+func (s *AnchorStore) Add(leaf string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.byLeaf[leaf] != nil || slices.Contains(s.Pending, leaf) || s.InFlight != nil && slices.Contains(s.InFlight.Leaves, leaf) {
+		return nil
+	}
+	s.Pending = append(s.Pending, leaf)
+	return s.saveLocked()
+}
+
+// Flush anchors the in-flight batch, or else the pending hashes as a new
+// batch.
+func (s *AnchorStore) Flush() error {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	s.mu.Lock()
+	batch := s.InFlight
+	if batch == nil && len(s.Pending) > 0 {
+		b := make([]byte, 8)
+		rand.Read(b)
+		network, _, _ := polygonDIDAccount(config.AnchorDID)
+		batch = &Anchor{ID: hex.EncodeToString(b), Root: merkleRoot(s.Pending), Leaves: s.Pending, Network: network, DID: config.AnchorDID}
+		s.InFlight, s.Pending = batch, nil
+	}
+	if batch == nil {
+		s.mu.Unlock()
+		return nil
+	}
+	batch.Attempts++
+	err := s.saveLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	written, err := writeAnchor(batch)
+	if err != nil {
+		return fmt.Errorf("anchoring batch %s (attempt %d): %w", batch.ID, batch.Attempts, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	batch.SchemaID, batch.TxHash, batch.Attempts, batch.AnchoredAt = written.SchemaID, written.TxHash, 0, time.Now().UTC()
+	s.Anchors = append(s.Anchors, batch)
+	for _, leaf := range batch.Leaves {
+		s.byLeaf[leaf] = batch
+	}
+	s.InFlight = nil
+	slog.Info("anchoring: credentials anchored", "count", len(batch.Leaves), "network", batch.Network, "schema", written.SchemaID, "transaction", written.TxHash)
+	return s.saveLocked()
+}
+
+// writeAnchor has the agent write a batch's root to the schema registry,
+// after checking its DID's account can pay for it.
+func writeAnchor(batch *Anchor) (*PolygonSchema, error) {
+	network, address, err := polygonDIDAccount(batch.DID)
+	if err != nil {
+		return nil, err
+	}
+	pf, err := gasPreflight(network, address, schemaWriteGas)
+	if err != nil {
+		return nil, fmt.Errorf("checking %s: %w", batch.DID, err)
+	}
+	if !pf.Sufficient() {
+		return nil, errors.New(pf.Warning())
+	}
+	token, err := agentClient.GetToken()
+	if err != nil {
+		return nil, err
+	}
+	// The name is the batch's, so a batch sent twice is recognisable in
+	// the registry.
+	return agentClient.WritePolygonSchema(token, batch.DID, "testa-anchor-"+batch.ID, map[string]interface{}{
+		"type":      "MerkleRoot",
+		"algorithm": "sha256",
+		"root":      batch.Root,
+		"leaves":    len(batch.Leaves),
+	})
+}
+
+// Lookup returns the anchor proof of a credential hash, and whether the
+// hash is still waiting to be anchored.
+func (s *AnchorStore) Lookup(leaf string) (*AnchorProof, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a := s.byLeaf[leaf]
+	if a == nil {
+		return nil, slices.Contains(s.Pending, leaf) || s.InFlight != nil && slices.Contains(s.InFlight.Leaves, leaf)
+	}
+	return &AnchorProof{
+		Leaf:       leaf,
+		Path:       merklePath(a.Leaves, slices.Index(a.Leaves, leaf)),
+		Root:       a.Root,
+		Network:    a.Network,
+		DID:        a.DID,
+		SchemaID:   a.SchemaID,
+		TxHash:     a.TxHash,
+		AnchoredAt: a.AnchoredAt,
+	}, false
+}
+
+// merkleLevels hashes leaves pairwise up to the root. A node without a
+// sibling moves up unchanged.
+func merkleLevels(leaves []string) [][][]byte {
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i], _ = hex.DecodeString(leaf)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			sum := sha256.Sum256(append(append([]byte{}, level[i]...), level[i+1]...))
+			next = append(next, sum[:])
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+func merkleRoot(leaves []string) string {
+	levels := merkleLevels(leaves)
+	return hex.EncodeToString(levels[len(levels)-1][0])
+}
+
+func merklePath(leaves []string, i int) []MerkleStep {
+	var path []MerkleStep
+	levels := merkleLevels(leaves)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := i ^ 1
+		if sibling < len(level) {
+			path = append(path, MerkleStep{Hash: hex.EncodeToString(level[sibling]), Left: sibling < i})
+		}
+		i /= 2
+	}
+	return path
+}
+
+// Valid recomputes the root from the leaf and path.
+func (p *AnchorProof) Valid() bool {
+	node, err := hex.DecodeString(p.Leaf)
+	if err != nil {
+		return false
+	}
+	for _, step := range p.Path {
+		sibling, err := hex.DecodeString(step.Hash)
+		if err != nil {
+			return false
+		}
+		var sum [32]byte
+		if step.Left {
+			sum = sha256.Sum256(append(sibling, node...))
+		} else {
+			sum = sha256.Sum256(append(node, sibling...))
+		}
+		node = sum[:]
+	}
+	root, err := hex.DecodeString(p.Root)
+	return err == nil && bytes.Equal(node, root)
+}
+
+// anchorIssued queues a newly signed credential for anchoring.
+func anchorIssued(sess *Session) {
+	if config.AnchorMode == AnchorOff || sess.Format == FormatAnonCreds {
+		return
+	}
+	leaf, err := credentialDigest(sess.SignedCredential)
+	if err == nil {
+		err = anchors.Add(leaf)
+	}
+	if err != nil {
+		slog.Error("anchoring", "err", err)
+		return
+	}
+	if config.AnchorMode == AnchorEach {
+		go func() {
+			if err := anchors.Flush(); err != nil {
+				slog.Error("anchoring", "err", err)
+			}
+		}()
+	}
+}
+
+// startAnchoring anchors pending hashes every ANCHOR_INTERVAL on the
+// leader, which in "each" mode retries batches whose write failed.
+func startAnchoring() {
+	if config.AnchorMode == AnchorOff {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(config.AnchorInterval)
+			if !isLeader() {
+				continue
+			}
+			if err := anchors.Flush(); err != nil {
+				slog.Error("anchoring", "err", err)
+			}
+		}
+	}()
+}
+
+// checkAnchor records whether the credential is anchored on chain.
+func (r *ScanResult) checkAnchor(sc *scannedCredential) {
+	if config.AnchorMode == AnchorOff {
+		r.check("anchor", CheckSkipped, "anchoring is off")
+		return
+	}
+	leaf, err := credentialDigest(sc.Credential)
+	if err != nil {
+		r.check("anchor", CheckSkipped, "not anchored")
+		return
+	}
+	proof, pending := anchors.Lookup(leaf)
+	switch {
+	case proof != nil && proof.Valid():
+		r.Anchor = proof
+		r.check("anchor", CheckPassed, fmt.Sprintf("anchored on Polygon %s as schema %s", proof.Network, proof.SchemaID))
+	case proof != nil:
+		r.check("anchor", CheckFailed, "the anchor proof does not match its root")
+	case pending:
+		r.check("anchor", CheckSkipped, "awaiting anchoring")
+	default:
+		r.check("anchor", CheckSkipped, "not anchored")
+	}
+}
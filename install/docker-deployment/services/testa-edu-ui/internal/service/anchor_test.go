@@ -0,0 +1,124 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestAnchoring anchors two credentials in one batch through the agent and
+// checks each verifies against the root it wrote, and that a failed write
+// is retried as the same batch rather than a new one.
+func TestAnchoring(t *testing.T) {
+	const did = "did:polygon:testnet:0x7e5f4552091a69125d5dfcb7b8c2659029395bdf"
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string `json:"method"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		result := "0xde0b6b3a7640000" // 1 POL
+		if req.Method == "eth_gasPrice" {
+			result = "0x6fc23ac00" // 30 gwei
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "result": result})
+	}))
+	defer node.Close()
+	mock := mockagent.New()
+	url := mock.Start(t)
+
+	saved, savedAnchors, savedClient := config, anchors, agentClient
+	t.Cleanup(func() { config, anchors, agentClient = saved, savedAnchors, savedClient })
+	config.AnchorMode, config.AnchorDID = AnchorBatch, did
+	config.PolygonTestnetRPCURL = node.URL
+	agentClient = &AgentClient{BaseURL: url, client: http.DefaultClient}
+	var err error
+	if anchors, err = NewAnchorStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	creds := []json.RawMessage{json.RawMessage(`{"id":"urn:uuid:1"}`), json.RawMessage(`"eyJhbGciOiJFUzI1NksifQ.e30.c2ln~WyJkIl0~"`)}
+	for _, c := range creds {
+		anchorIssued(&Session{SignedCredential: c})
+	}
+	pending := &ScanResult{}
+	pending.checkAnchor(&scannedCredential{Credential: creds[0]})
+	if c := pending.Checks[0]; c.Status != CheckSkipped || c.Detail != "awaiting anchoring" {
+		t.Errorf("before anchoring: %+v", c)
+	}
+
+	mock.Respond("/polygon/create-schema", http.StatusInternalServerError, `{"message":"insufficient funds"}`)
+	if err := anchors.Flush(); err == nil {
+		t.Fatal("a failed write was reported anchored")
+	}
+	batch := anchors.InFlight
+	if batch == nil || len(batch.Leaves) != 2 || len(anchors.Pending) != 0 {
+		t.Fatalf("after a failed write: in flight %+v, pending %v", batch, anchors.Pending)
+	}
+	// A credential issued meanwhile waits for the next batch.
+	late := json.RawMessage(`{"id":"urn:uuid:3"}`)
+	anchorIssued(&Session{SignedCredential: late})
+
+	mock.Respond("/polygon/create-schema", http.StatusOK, `{"schemaId":"`+did+`/resources/1","schemaTxnHash":"0xabc"}`)
+	if err := anchors.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	var write struct {
+		DID        string            `json:"did"`
+		SchemaName string            `json:"schemaName"`
+		Schema     map[string]string `json:"schema"`
+	}
+	json.Unmarshal(mock.LastBody("/polygon/create-schema"), &write)
+	if write.DID != did || write.SchemaName != "testa-anchor-"+batch.ID || write.Schema["root"] != batch.Root {
+		t.Errorf("schema write %+v, want batch %s root %s", write, batch.ID, batch.Root)
+	}
+	if anchors.InFlight != nil || len(anchors.Anchors) != 1 || anchors.Anchors[0].Root != batch.Root {
+		t.Fatalf("after anchoring: in flight %+v, anchors %+v", anchors.InFlight, anchors.Anchors)
+	}
+
+	for _, c := range creds {
+		result := &ScanResult{}
+		result.checkAnchor(&scannedCredential{Credential: c})
+		if c := result.Checks[0]; c.Status != CheckPassed || result.Anchor == nil || result.Anchor.SchemaID != did+"/resources/1" || result.Anchor.TxHash != "0xabc" {
+			t.Errorf("after anchoring: %+v, %+v", c, result.Anchor)
+		}
+	}
+	if proof, pending := anchors.Lookup(mustDigest(t, late)); proof != nil || !pending {
+		t.Errorf("the late credential: %+v, pending %v", proof, pending)
+	}
+	reopened, _ := NewAnchorStore(filepath.Dir(anchors.path))
+	if proof, _ := reopened.Lookup(anchors.Anchors[0].Leaves[1]); proof == nil || !proof.Valid() {
+		t.Errorf("reopened store's proof %+v", proof)
+	}
+	if p := (AnchorProof{Leaf: anchors.Anchors[0].Leaves[0], Path: []MerkleStep{{Hash: strings.Repeat("00", 32)}}, Root: anchors.Anchors[0].Root}); p.Valid() {
+		t.Error("a proof with the wrong sibling is valid")
+	}
+}
+
+func mustDigest(t *testing.T, credential json.RawMessage) string {
+	t.Helper()
+	d, err := credentialDigest(credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// TestAnchorConfig checks anchoring needs a did:polygon DID.
+func TestAnchorConfig(t *testing.T) {
+	t.Setenv("API_KEY", "agent-key-0123456789")
+	t.Setenv("ANCHOR_MODE", AnchorEach)
+	t.Setenv("ISSUER_DID", "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK")
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "ANCHOR_DID") {
+		t.Errorf("with a did:key issuer: %v", err)
+	}
+	t.Setenv("ANCHOR_DID", "did:polygon:testnet:0x7e5f4552091a69125d5dfcb7b8c2659029395bdf")
+	c, err := parseConfig()
+	if err != nil || c.AnchorDID != "did:polygon:testnet:0x7e5f4552091a69125d5dfcb7b8c2659029395bdf" {
+		t.Errorf("anchor DID %q, %v", c.AnchorDID, err)
+	}
+}
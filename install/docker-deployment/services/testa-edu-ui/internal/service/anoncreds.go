@@ -0,0 +1,195 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// FormatAnonCreds issues the credential as an AnonCreds credential offered
+// over an existing DIDComm connection, for Indy-based wallets. Unlike the
+// W3C formats the signed credential never passes through this service; the
+// session tracks the credential exchange record instead.
+const FormatAnonCreds = "anoncreds"
+
+// anonCredsAttributes is the AnonCreds schema for education credentials.
+// AnonCreds requires every attribute to be present in each offer.
+var anonCredsAttributes = []string{
+	"name", "alumniOf", "degree", "fieldOfStudy", "enrollmentDate",
+	"graduationDate", "studentId", "gpa", "honors",
+}
+
+// anonCredsRegistry caches the schema and credential definition created on
+// first use so they are only registered on the ledger once per process.
+// Operators should copy the logged IDs into ANONCREDS_CRED_DEF_ID to reuse
+// them across restarts.
+var anonCredsRegistry struct {
+	mu        sync.Mutex
+	credDefID string
+}
+
+func ensureCredentialDefinition(agent *AgentClient, token string) (string, error) {
+	anonCredsRegistry.mu.Lock()
+	defer anonCredsRegistry.mu.Unlock()
+
+	if anonCredsRegistry.credDefID == "" {
+		anonCredsRegistry.credDefID = config.AnonCredsCredDefID
+	}
+	if anonCredsRegistry.credDefID != "" {
+		return anonCredsRegistry.credDefID, nil
+	}
+	if config.AnonCredsIssuerID == "" {
+		return "", fmt.Errorf("AnonCreds issuance requires ANONCREDS_ISSUER_ID or ANONCREDS_CRED_DEF_ID")
+	}
+
+	schemaID, err := agent.CreateAnonCredsSchema(token, config.AnonCredsIssuerID,
+		"EducationCredential", "1.0", anonCredsAttributes)
+	if err != nil {
+		return "", err
+	}
+	credDefID, err := agent.CreateCredentialDefinition(token, config.AnonCredsIssuerID, schemaID, "testa-edu")
+	if err != nil {
+		return "", err
+	}
+	slog.Info("AnonCreds schema and credential definition registered", "schema", schemaID, "cred_def", credDefID)
+
+	anonCredsRegistry.credDefID = credDefID
+	return credDefID, nil
+}
+
+func anonCredsValues(form CredentialForm) map[string]string {
+	return map[string]string{
+		"name":           form.StudentName,
+		"alumniOf":       form.Institution,
+		"degree":         form.Degree,
+		"fieldOfStudy":   form.FieldOfStudy,
+		"enrollmentDate": form.EnrollmentDate,
+		"graduationDate": form.GraduationDate,
+		"studentId":      form.StudentID,
+		"gpa":            form.GPA,
+		"honors":         form.Honors,
+	}
+}
+
+// CreateAnonCredsSchema registers a schema and returns its ID.
+func (a *AgentClient) CreateAnonCredsSchema(token, issuerID, name, version string, attributes []string) (string, error) {
+	body, err := a.postJSON(token, "/anoncreds/schema", map[string]interface{}{
+		"issuerId":   issuerID,
+		"name":       name,
+		"version":    version,
+		"attributes": attributes,
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating schema: %w", err)
+	}
+	return extractID(body, "schemaId", "id")
+}
+
+// CreateCredentialDefinition registers a credential definition for a
+// schema and returns its ID.
+func (a *AgentClient) CreateCredentialDefinition(token, issuerID, schemaID, tag string) (string, error) {
+	body, err := a.postJSON(token, "/anoncreds/credential-definition", map[string]interface{}{
+		"issuerId": issuerID,
+		"schemaId": schemaID,
+		"tag":      tag,
+	})
+	if err != nil {
+		return "", fmt.Errorf("creating credential definition: %w", err)
+	}
+	return extractID(body, "credentialDefinitionId", "id")
+}
+
+// OfferAnonCredsCredential sends an issue-credential v2 offer over a
+// connection and returns the credential exchange record.
+func (a *AgentClient) OfferAnonCredsCredential(token, connectionID, credDefID string, values map[string]string) (json.RawMessage, error) {
+	attrs := make([]map[string]string, 0, len(anonCredsAttributes))
+	for _, name := range anonCredsAttributes {
+		attrs = append(attrs, map[string]string{"name": name, "value": values[name]})
+	}
+
+	body, err := a.postJSON(token, "/credentials/offer-credential", map[string]interface{}{
+		"connectionId":         connectionID,
+		"protocolVersion":      "v2",
+		"autoAcceptCredential": "always",
+		"credentialFormats": map[string]interface{}{
+			"anoncreds": map[string]interface{}{
+				"credentialDefinitionId": credDefID,
+				"attributes":             attrs,
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("offering credential: %w", err)
+	}
+	if _, err := extractID(body, "id"); err != nil {
+		return nil, fmt.Errorf("offering credential: %w", err)
+	}
+	return body, nil
+}
+
+// GetCredentialExchange fetches a credential exchange record and returns
+// its protocol state (e.g. "offer-sent", "done").
+func (a *AgentClient) GetCredentialExchange(token, exchangeID string) (string, json.RawMessage, error) {
+	body, err := a.getJSON(token, "/credentials/"+exchangeID)
+	if err != nil {
+		return "", nil, fmt.Errorf("fetching credential exchange: %w", err)
+	}
+	var rec struct {
+		State string `json:"state"`
+	}
+	if err := json.Unmarshal(body, &rec); err != nil {
+		return "", nil, fmt.Errorf("invalid credential exchange record: %s", string(body))
+	}
+	return rec.State, body, nil
+}
+
+func extractID(body []byte, keys ...string) (string, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return "", fmt.Errorf("invalid response: %s", string(body))
+	}
+	for _, k := range keys {
+		if v, ok := m[k].(string); ok && v != "" {
+			return v, nil
+		}
+	}
+	// Some agent versions nest the result under "schemaState" /
+	// "credentialDefinitionState".
+	for _, v := range m {
+		if nested, ok := v.(map[string]interface{}); ok {
+			for _, k := range keys {
+				if id, ok := nested[k].(string); ok && id != "" {
+					return id, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no %s in response: %s", strings.Join(keys, "/"), string(body))
+}
+
+// exchangeID returns the ID of the session's credential exchange record.
+func exchangeID(sess *Session) string {
+	var rec struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(sess.SignedCredential, &rec)
+	return rec.ID
+}
+
+func handleStepOffer(w http.ResponseWriter, r *http.Request, sess *Session) {
+	agent := agentClient.WithContext(r.Context())
+	state, _, err := agent.GetCredentialExchange(sess.Token, exchangeID(sess))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "exchange error", "err", err)
+		renderStepError(w, r, "offer", err.Error())
+		return
+	}
+	renderFragment(w, r, "step-offer", map[string]interface{}{
+		"State":        state,
+		"ConnectionID": sess.ConnectionID,
+		"ExchangeID":   exchangeID(sess),
+	})
+}
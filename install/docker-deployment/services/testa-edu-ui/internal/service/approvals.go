@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Maker-checker. With MAKER_CHECKER=true an issuance is only a request
+// until a second person approves it. The maker submits the issuance form
+// with their staff token, and the wizard waits before the signing step.
+// An approver reviews the request's credential at /admin/approvals and
+// approves or rejects it; approval releases the signing step, which the
+// waiting wizard then runs.
+//
+// Makers and approvers are tenant users with the maker or approver role
+// (see tenants.go); the operator's STAFF_API_TOKEN holds both. Nobody
+// approves a request they submitted, and a tenant's users see and submit
+// only the tenant's requests. Submissions and decisions are appended to
+// DATA_DIR/approval-audit.jsonl, naming the users, never the student.
+//
+// Requests are kept in DATA_DIR/approvals.json with the issuance they
+// hold, so they outlive the wizard's session and restarts: a maker coming
+// back to the wizard picks the issuance up again (getSession). A request
+// not decided within APPROVAL_TTL (72 hours by default) expires, which is
+// audited too, and any request is dropped with its issuance once its TTL
+// is up. The batch command (cli.go) and the LMS connectors (lms.go) are
+// set up by the operator and are not held.
+
+const (
+	RoleMaker    = "maker"
+	RoleApprover = "approver"
+
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+
+	defaultApprovalTTL = 72 * time.Hour
+)
+
+// staffRoles are the roles a tenant user may hold.
+var staffRoles = []string{RoleMaker, RoleApprover}
+
+// Approval is an issuance request's approval state.
+type Approval struct {
+	ID          string    `json:"id"`
+	State       string    `json:"state"`
+	TenantID    string    `json:"tenantId,omitempty"`
+	SubmittedBy string    `json:"submittedBy"`
+	SubmittedAt time.Time `json:"submittedAt"`
+	DecidedBy   string    `json:"decidedBy,omitempty"`
+	DecidedAt   time.Time `json:"decidedAt"`
+	Reason      string    `json:"reason,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// approvalRequest is a stored request: its state, the wizard session it
+// came from and the issuance it holds.
+type approvalRequest struct {
+	Approval
+	SessionID string   `json:"sessionId"`
+	Degree    string   `json:"degree"`
+	Issuance  *Session `json:"issuance,omitempty"` // dropped once signed
+}
+
+// ApprovalStore holds the approval requests.
+type ApprovalStore struct {
+	path string
+
+	mu       sync.Mutex
+	requests map[string]*approvalRequest
+}
+
+var approvals *ApprovalStore
+
+func NewApprovalStore(dataDir string) (*ApprovalStore, error) {
+	s := &ApprovalStore{
+		path:     filepath.Join(dataDir, "approvals.json"),
+		requests: make(map[string]*approvalRequest),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.requests); err != nil {
+			return nil, fmt.Errorf("parsing approvals: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading approvals: %w", err)
+	}
+	return s, nil
+}
+
+func (s *ApprovalStore) saveLocked() error {
+	data, err := json.Marshal(s.requests)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing approvals: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Put stores a request holding the issuance of session sid.
+func (s *ApprovalStore) Put(a *Approval, sid string, sess *Session) error {
+	issuance := *sess
+	issuance.Token = ""
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests[a.ID] = &approvalRequest{Approval: *a, SessionID: sid, Degree: sess.Form.Degree, Issuance: &issuance}
+	return s.saveLocked()
+}
+
+// Get returns a request's approval state, or false once it has expired.
+func (s *ApprovalStore) Get(id string) (Approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	if !ok || time.Now().After(req.ExpiresAt) {
+		return Approval{}, false
+	}
+	return req.Approval, true
+}
+
+// Issuance returns a copy of the issuance held for the wizard session sid,
+// while its request is live and not yet signed.
+func (s *ApprovalStore) Issuance(sid string) (*Session, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for _, req := range s.requests {
+		if req.SessionID == sid && req.Issuance != nil && !now.After(req.ExpiresAt) {
+			sess := *req.Issuance
+			return &sess, true
+		}
+	}
+	return nil, false
+}
+
+// Signed drops the issuance of an approved request once it is signed, so
+// it is neither kept nor picked up again.
+func (s *ApprovalStore) Signed(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	if !ok || req.Issuance == nil {
+		return nil
+	}
+	req.Issuance = nil
+	return s.saveLocked()
+}
+
+// Pending returns the live pending requests of tenantID, or of every
+// tenant with tenantID empty, with their issuances.
+func (s *ApprovalStore) Pending(tenantID string) []approvalRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var list []approvalRequest
+	for _, req := range s.requests {
+		if req.State == ApprovalPending && req.Issuance != nil && !now.After(req.ExpiresAt) && (tenantID == "" || req.TenantID == tenantID) {
+			r := *req
+			issuance := *req.Issuance
+			r.Issuance = &issuance
+			list = append(list, r)
+		}
+	}
+	return list
+}
+
+// Decide records decision on a pending request of tenantID (any tenant's
+// with tenantID empty) by user, returning the request decided or why it
+// may not be.
+func (s *ApprovalStore) Decide(id, tenantID, user, decision, reason string) (approvalRequest, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	req, ok := s.requests[id]
+	switch {
+	case !ok || time.Now().After(req.ExpiresAt) || (tenantID != "" && req.TenantID != tenantID):
+		return approvalRequest{}, "Unknown or expired request", nil
+	case req.State != ApprovalPending:
+		return approvalRequest{}, "The request was already " + req.State, nil
+	case req.SubmittedBy == user:
+		return approvalRequest{}, "You submitted this request; another approver must decide it", nil
+	}
+	req.State, req.DecidedBy, req.DecidedAt, req.Reason = decision, user, time.Now().UTC(), reason
+	if err := s.saveLocked(); err != nil {
+		return approvalRequest{}, "", err
+	}
+	return *req, "", nil
+}
+
+// Expire drops the requests whose TTL is up, auditing those that expired
+// undecided, and returns how many it dropped.
+func (s *ApprovalStore) Expire() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	n := 0
+	for id, req := range s.requests {
+		if !now.After(req.ExpiresAt) {
+			continue
+		}
+		if req.State == ApprovalPending {
+			expired := req.Approval
+			expired.State, expired.DecidedAt = ApprovalExpired, now.UTC()
+			auditApproval(ApprovalExpired, &expired, "", req.Degree)
+			slog.Info("approval: expired", "request", id)
+		}
+		delete(s.requests, id)
+		n++
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// EraseSubject drops the requests holding an issuance to did.
+func (s *ApprovalStore) EraseSubject(did string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, req := range s.requests {
+		if req.Issuance != nil && req.Issuance.Form.SubjectDID == did {
+			delete(s.requests, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// ApprovalAuditEvent is one line of the approval audit log.
+type ApprovalAuditEvent struct {
+	At        time.Time `json:"at"`
+	Event     string    `json:"event"`
+	RequestID string    `json:"requestId"`
+	TenantID  string    `json:"tenantId,omitempty"`
+	User      string    `json:"user"`
+	Degree    string    `json:"degree"`
+	Reason    string    `json:"reason,omitempty"`
+}
+
+// staffUser is a staff member: a tenant user, or the operator.
+type staffUser struct {
+	ID       string
+	Name     string
+	TenantID string
+	Roles    []string
+}
+
+func (u staffUser) has(role string) bool {
+	return slices.Contains(u.Roles, role)
+}
+
+// authenticateStaff returns the staff member a token belongs to.
+func authenticateStaff(token string) (staffUser, bool) {
+	if config.StaffAPIToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(config.StaffAPIToken)) == 1 {
+		return staffUser{ID: "operator", Name: "operator", Roles: staffRoles}, true
+	}
+	if tenants == nil {
+		return staffUser{}, false
+	}
+	t, u, ok := tenants.Authenticate(token)
+	if !ok {
+		return staffUser{}, false
+	}
+	return staffUser{ID: u.ID, Name: u.Name, TenantID: t.ID, Roles: u.Roles}, true
+}
+
+// approvalMaker returns the maker submitting an issuance for tenantID, by
+// the staff token given with the form.
+func approvalMaker(r *http.Request, tenantID string) (staffUser, error) {
+	u, ok := authenticateStaff(r.FormValue("staffToken"))
+	if !ok || !u.has(RoleMaker) || (u.TenantID != "" && u.TenantID != tenantID) {
+		slog.WarnContext(r.Context(), "approval: submission refused", "user", u.ID)
+		return staffUser{}, errors.New("Issuing needs the staff token of a maker for this institution")
+	}
+	return u, nil
+}
+
+// submitApproval opens the approval request of the maker's issuance, held
+// in wizard session sid.
+func submitApproval(ctx context.Context, maker staffUser, sid string, sess *Session) error {
+	id := make([]byte, 8)
+	rand.Read(id)
+	now := time.Now().UTC()
+	a := &Approval{
+		ID:          hex.EncodeToString(id),
+		State:       ApprovalPending,
+		TenantID:    sess.TenantID,
+		SubmittedBy: maker.ID,
+		SubmittedAt: now,
+		ExpiresAt:   now.Add(config.ApprovalTTL),
+	}
+	sess.Approval = a
+	if err := approvals.Put(a, sid, sess); err != nil {
+		return err
+	}
+	auditApproval("submitted", a, maker.ID, sess.Form.Degree)
+	slog.InfoContext(ctx, "approval: submitted", "request", a.ID, "user", maker.ID)
+	return nil
+}
+
+// approvalHold returns why a session may not be signed yet: its pending,
+// rejected or expired approval request, or nil when it may.
+func approvalHold(sess *Session) *Approval {
+	sessionsMu.RLock()
+	submitted := sess.Approval
+	sessionsMu.RUnlock()
+	if submitted == nil {
+		return nil
+	}
+	a, ok := approvals.Get(submitted.ID)
+	if !ok {
+		a = *submitted
+		a.State = ApprovalExpired
+	}
+	if a.State == ApprovalApproved {
+		return nil
+	}
+	return &a
+}
+
+// auditApproval appends an event to the approval audit log.
+func auditApproval(event string, a *Approval, user, degree string) {
+	line, _ := json.Marshal(ApprovalAuditEvent{
+		At:        time.Now().UTC(),
+		Event:     event,
+		RequestID: a.ID,
+		TenantID:  a.TenantID,
+		User:      user,
+		Degree:    degree,
+		Reason:    a.Reason,
+	})
+	f, err := os.OpenFile(filepath.Join(config.DataDir, "approval-audit.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		slog.Error("approval audit", "err", err)
+	}
+}
+
+// requireApprover admits staff with the approver role, passing them on.
+func requireApprover(next func(http.ResponseWriter, *http.Request, staffUser)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		u, ok := authenticateStaff(token)
+		if !strings.EqualFold(scheme, "Bearer") || !ok || !u.has(RoleApprover) {
+			slog.WarnContext(r.Context(), "staff API: unauthorized", "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, u)
+	}
+}
+
+// pendingApproval is a request awaiting a decision, with its credential.
+type pendingApproval struct {
+	Approval
+	Format            string
+	CredentialSubject string
+}
+
+// pendingApprovals lists the pending requests u may decide, oldest first.
+func pendingApprovals(u staffUser) []pendingApproval {
+	if _, err := approvals.Expire(); err != nil {
+		slog.Error("approval expiry", "err", err)
+	}
+	var list []pendingApproval
+	for _, req := range approvals.Pending(u.TenantID) {
+		s := req.Issuance
+		form, err := credentialForm(s)
+		if err != nil {
+			slog.Error("approval preview", "err", err)
+			continue
+		}
+		payload := buildCredentialPayload(form, s.IssuerDID, s.ProofType)
+		subject, _ := json.MarshalIndent(payload["credential"].(map[string]interface{})["credentialSubject"], "", "  ")
+		list = append(list, pendingApproval{Approval: req.Approval, Format: s.Format, CredentialSubject: string(subject)})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SubmittedAt.Before(list[j].SubmittedAt) })
+	return list
+}
+
+func handleApprovalsPage(w http.ResponseWriter, r *http.Request) {
+	if err := pages(r).ExecuteTemplate(w, "approvals", map[string]interface{}{}); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handleApprovalList shows the requests awaiting the approver.
+func handleApprovalList(w http.ResponseWriter, r *http.Request, u staffUser) {
+	pages(r).ExecuteTemplate(w, "approval-list", map[string]interface{}{"Pending": pendingApprovals(u), "User": u.Name})
+}
+
+// handleApprovalDecision approves or rejects a request, then shows the
+// requests still waiting.
+func handleApprovalDecision(w http.ResponseWriter, r *http.Request, u staffUser) {
+	decision := r.FormValue("decision")
+	if decision != ApprovalApproved && decision != ApprovalRejected {
+		http.Error(w, "decision must be approved or rejected", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	decided, problem, err := approvals.Decide(id, u.TenantID, u.ID, decision, strings.TrimSpace(r.FormValue("reason")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "approval decision", "err", err)
+		internalError(w, r)
+		return
+	}
+	if problem != "" {
+		slog.WarnContext(r.Context(), "approval: decision refused", "request", id, "user", u.ID, "problem", problem)
+		pages(r).ExecuteTemplate(w, "approval-list", map[string]interface{}{"Pending": pendingApprovals(u), "User": u.Name, "Error": problem})
+		return
+	}
+	auditApproval(decision, &decided.Approval, u.ID, decided.Degree)
+	slog.InfoContext(r.Context(), "approval: "+decision, "request", id, "user", u.ID)
+	handleApprovalList(w, r, u)
+}
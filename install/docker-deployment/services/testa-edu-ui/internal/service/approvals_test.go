@@ -0,0 +1,130 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestMakerChecker checks an issuance is signed only once a second staff
+// member of its tenant approves it, that requests outlive the wizard's
+// session and a restart, and that the decisions and expiry are audited.
+func TestMakerChecker(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	config.MakerChecker = true
+	if _, err := tenants.Create(Tenant{ID: "testa", Name: "Testa University"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tenants.Create(Tenant{ID: "other", Name: "Other University"}); err != nil {
+		t.Fatal(err)
+	}
+	_, maker, err := tenants.AddUser("testa", "Registrar", "", []string{RoleMaker, RoleApprover})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, approver, _ := tenants.AddUser("testa", "Dean", "", []string{RoleApprover})
+	_, outsider, _ := tenants.AddUser("other", "Other Dean", "", []string{RoleApprover})
+
+	issue := url.Values{
+		"studentName": {"Jane Wanjiku"}, "institution": {"Testa University"}, "degree": {"Bachelor of Science"}, "tenant": {"testa"},
+		"consent_issue": {"on"}, "qrMode": {QRModeCompact},
+	}
+	client := e2eClient(t)
+	issue.Set("staffToken", approver)
+	resp, err := client.PostForm(srv.URL+"/issue", issue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "Issuing needs the staff token of a maker") {
+		t.Errorf("an approver submitted a request: %s", body)
+	}
+	issue.Set("staffToken", maker)
+	e2eStep(t, client, srv.URL+"/issue", issue, "")
+	e2eStep(t, client, srv.URL+"/step/token", nil, "step-success")
+	e2eStep(t, client, srv.URL+"/step/sign", nil, "Waiting for approval")
+	id := e2eSession(t, client, srv.URL).Approval.ID
+
+	staff := func(method, path, token string, form url.Values) string {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: HTTP %d: %s", method, path, resp.StatusCode, body)
+		}
+		return string(body)
+	}
+	if list := staff("GET", "/api/staff/approvals", outsider, nil); strings.Contains(list, id) {
+		t.Errorf("another tenant's approver sees the request: %s", list)
+	}
+	if list := staff("GET", "/api/staff/approvals", approver, nil); !strings.Contains(list, id) || !strings.Contains(list, "Jane Wanjiku") {
+		t.Errorf("approver's list: %s", list)
+	}
+	if body := staff("POST", "/api/staff/approvals/"+id, maker, url.Values{"decision": {ApprovalApproved}}); !strings.Contains(body, "another approver must decide it") {
+		t.Errorf("self-approval: %s", body)
+	}
+	e2eStep(t, client, srv.URL+"/step/sign", nil, "Waiting for approval")
+
+	staff("POST", "/api/staff/approvals/"+id, approver, url.Values{"decision": {ApprovalApproved}})
+	// A restart loses the wizard's session; the approved issuance is picked
+	// up from the approval store and signed.
+	if approvals, err = NewApprovalStore(config.DataDir); err != nil {
+		t.Fatal(err)
+	}
+	sessionsMu.Lock()
+	clear(sessions)
+	sessionsMu.Unlock()
+	e2eStep(t, client, srv.URL+"/step/sign", nil, "step-success")
+	if sess := e2eSession(t, client, srv.URL); sess.SignedCredential == nil || sess.Form.StudentName != "Jane Wanjiku" {
+		t.Errorf("picked up issuance: %+v", sess.Form)
+	}
+	if held, ok := approvals.Issuance(e2eSessionID(t, client, srv.URL)); ok {
+		t.Errorf("a signed issuance is still held: %+v", held.Form)
+	}
+
+	// A request left undecided expires.
+	config.ApprovalTTL = time.Millisecond
+	late := e2eClient(t)
+	e2eStep(t, late, srv.URL+"/issue", issue, "")
+	time.Sleep(5 * time.Millisecond)
+	if list := staff("GET", "/api/staff/approvals", approver, nil); strings.Contains(list, "Jane Wanjiku") {
+		t.Errorf("an expired request is listed: %s", list)
+	}
+	resp, err = late.PostForm(srv.URL+"/step/sign", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "expired before it was approved") {
+		t.Errorf("signing an expired request: %s", body)
+	}
+
+	audit, err := os.ReadFile(filepath.Join(config.DataDir, "approval-audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(audit)), "\n"); len(lines) != 4 ||
+		!strings.Contains(lines[0], `"event":"submitted"`) || !strings.Contains(lines[1], `"event":"approved"`) ||
+		!strings.Contains(lines[2], `"event":"submitted"`) || !strings.Contains(lines[3], `"event":"expired"`) {
+		t.Errorf("audit log:\n%s", audit)
+	}
+	if strings.Contains(string(audit), "Jane Wanjiku") {
+		t.Error("the audit log names the student")
+	}
+}
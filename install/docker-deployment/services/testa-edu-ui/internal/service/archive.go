@@ -0,0 +1,291 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Credential archival. Staff archive old credential records to keep the
+// working set small: an archived record drops out of the staff listing and
+// cohort exports, and its artifacts, the credential and its RFC 3161
+// timestamp, move out of credentials.json into a file of their own under
+// ARCHIVE_DIR (DATA_DIR/archive by default), which can be a cheaper, slower
+// volume. The record itself stays, status and all, so the credential still
+// verifies: status lookups, retrieval links, the holder's portal and
+// lookups by signature read the artifacts back from cold storage when they
+// need them. Unarchiving moves them back.
+//
+// Archiving changes no status and is not revocation. Every archive and
+// unarchive is appended to DATA_DIR/archive-audit.jsonl. Erasure and
+// retention delete archived artifacts with their records. Backups include
+// ARCHIVE_DIR only when it is under DATA_DIR.
+
+var errCredentialErased = errors.New("credential is erased")
+
+// archivedArtifacts is a credential's cold storage file.
+type archivedArtifacts struct {
+	Credential json.RawMessage `json:"credential"`
+	Timestamp  []byte          `json:"timestamp,omitempty"`
+}
+
+// ArchiveAuditEvent is one line of the archive audit log. StaffTenant is
+// the tenant of the staff member who acted, empty for the operator.
+type ArchiveAuditEvent struct {
+	At           time.Time `json:"at"`
+	Event        string    `json:"event"` // archived, unarchived
+	CredentialID string    `json:"credentialId"`
+	TenantID     string    `json:"tenantId,omitempty"`
+	StaffTenant  string    `json:"staffTenant,omitempty"`
+}
+
+func (s *CredentialStore) archivePath(id string) string {
+	return filepath.Join(s.archiveDir, id+".json")
+}
+
+// withArtifacts returns c, or for an archived record a copy with its
+// artifacts read back from cold storage.
+func (s *CredentialStore) withArtifacts(c *StoredCredential) *StoredCredential {
+	if c.ArchivedAt.IsZero() {
+		return c
+	}
+	out := *c
+	data, err := os.ReadFile(s.archivePath(c.ID))
+	var a archivedArtifacts
+	if err == nil {
+		err = json.Unmarshal(data, &a)
+	}
+	if err != nil {
+		slog.Error("reading archived credential", "credential", c.ID, "err", err)
+		return &out
+	}
+	out.Credential, out.Timestamp = a.Credential, a.Timestamp
+	return &out
+}
+
+func (s *CredentialStore) withAllArtifacts(creds []*StoredCredential) []*StoredCredential {
+	for i, c := range creds {
+		creds[i] = s.withArtifacts(c)
+	}
+	return creds
+}
+
+// archiveLocked moves c's artifacts to cold storage.
+func (s *CredentialStore) archiveLocked(c *StoredCredential) error {
+	data, err := json.Marshal(archivedArtifacts{Credential: c.Credential, Timestamp: c.Timestamp})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.archiveDir, 0o700); err != nil {
+		return fmt.Errorf("creating archive dir: %w", err)
+	}
+	path := s.archivePath(c.ID)
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return fmt.Errorf("writing archived credential: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	c.Fingerprint = credentialFingerprint(c.Credential)
+	c.Credential, c.Timestamp = nil, nil
+	c.ArchivedAt = time.Now().UTC()
+	return nil
+}
+
+// archive archives the records selected, all or none, and returns them.
+func (s *CredentialStore) archive(match func(*StoredCredential) bool) ([]*StoredCredential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved := map[string]StoredCredential{}
+	for _, c := range s.items {
+		if !c.ErasedAt.IsZero() || !c.ArchivedAt.IsZero() || !match(c) {
+			continue
+		}
+		saved[c.ID] = *c
+		if err := s.archiveLocked(c); err != nil {
+			s.restoreLocked(saved)
+			return nil, err
+		}
+	}
+	if len(saved) == 0 {
+		return nil, nil
+	}
+	if err := s.saveLocked(); err != nil {
+		s.restoreLocked(saved)
+		return nil, err
+	}
+	var out []*StoredCredential
+	for id := range saved {
+		c := *s.items[id]
+		out = append(out, &c)
+	}
+	return out, nil
+}
+
+// restoreLocked undoes archiveLocked in memory, leaving the cold storage
+// files to be overwritten by the next attempt.
+func (s *CredentialStore) restoreLocked(saved map[string]StoredCredential) {
+	for id, c := range saved {
+		*s.items[id] = c
+	}
+}
+
+// Archive moves a credential's artifacts to cold storage. It reports
+// false if the credential was archived already.
+func (s *CredentialStore) Archive(id string) (bool, error) {
+	s.mu.RLock()
+	c, ok := s.items[id]
+	s.mu.RUnlock()
+	switch {
+	case !ok:
+		return false, os.ErrNotExist
+	case !c.ErasedAt.IsZero():
+		return false, errCredentialErased
+	}
+	archived, err := s.archive(func(c *StoredCredential) bool { return c.ID == id })
+	return len(archived) > 0, err
+}
+
+// ArchiveIssuedBefore archives a tenant's credentials, or every tenant's
+// for "", issued before cutoff, and returns them.
+func (s *CredentialStore) ArchiveIssuedBefore(cutoff time.Time, tenantID string) ([]*StoredCredential, error) {
+	return s.archive(func(c *StoredCredential) bool {
+		return c.IssuedAt.Before(cutoff) && (tenantID == "" || c.TenantID == tenantID)
+	})
+}
+
+// Unarchive moves a credential's artifacts back from cold storage. It
+// reports false if the credential was not archived.
+func (s *CredentialStore) Unarchive(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[id]
+	if !ok {
+		return false, os.ErrNotExist
+	}
+	if c.ArchivedAt.IsZero() {
+		return false, nil
+	}
+	data, err := os.ReadFile(s.archivePath(id))
+	if err != nil {
+		return false, fmt.Errorf("reading archived credential: %w", err)
+	}
+	var a archivedArtifacts
+	if err := json.Unmarshal(data, &a); err != nil {
+		return false, fmt.Errorf("parsing archived credential: %w", err)
+	}
+	saved := *c
+	c.Credential, c.Timestamp = a.Credential, a.Timestamp
+	c.ArchivedAt, c.Fingerprint = time.Time{}, ""
+	if err := s.saveLocked(); err != nil {
+		*c = saved
+		return false, err
+	}
+	return true, s.removeArtifacts([]string{id})
+}
+
+// removeArtifacts deletes archived credentials' cold storage files.
+func (s *CredentialStore) removeArtifacts(ids []string) error {
+	for _, id := range ids {
+		if err := os.Remove(s.archivePath(id)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("removing archived credential: %w", err)
+		}
+	}
+	return nil
+}
+
+// auditArchive appends an event to the archive audit log.
+func auditArchive(r *http.Request, event string, c *StoredCredential) {
+	line, _ := json.Marshal(ArchiveAuditEvent{
+		At:           time.Now().UTC(),
+		Event:        event,
+		CredentialID: c.ID,
+		TenantID:     c.TenantID,
+		StaffTenant:  staffTenant(r),
+	})
+	f, err := os.OpenFile(filepath.Join(config.DataDir, "archive-audit.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "archive audit", "err", err)
+	}
+}
+
+// archiveState is the staff API's view of a credential's archival.
+type archiveState struct {
+	ID         string    `json:"id"`
+	Archived   bool      `json:"archived"`
+	ArchivedAt time.Time `json:"archivedAt,omitempty"`
+}
+
+// handleCredentialArchive archives one credential (POST .../archive) or
+// brings it back (POST .../unarchive).
+func handleCredentialArchive(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := staffCredential(r, id); !ok {
+		http.Error(w, "Unknown credential", http.StatusNotFound)
+		return
+	}
+	event, change := "archived", store.Archive
+	if filepath.Base(r.URL.Path) == "unarchive" {
+		event, change = "unarchived", store.Unarchive
+	}
+	changed, err := change(id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Unknown credential", http.StatusNotFound)
+		return
+	case errors.Is(err, errCredentialErased):
+		http.Error(w, "The credential is erased", http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "credential archive error", "credential", id, "err", err)
+		http.Error(w, "Failed to "+filepath.Base(r.URL.Path)+" the credential", http.StatusInternalServerError)
+		return
+	}
+	cred, _ := store.Get(id)
+	if changed {
+		auditArchive(r, event, cred)
+		slog.InfoContext(r.Context(), "staff API: credential "+event, "credential", id)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(archiveState{ID: id, Archived: !cred.ArchivedAt.IsZero(), ArchivedAt: cred.ArchivedAt})
+}
+
+// handleCredentialArchiveOld archives the credentials issued longer ago
+// than olderThan, a retention period ("730d").
+func handleCredentialArchiveOld(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		OlderThan string `json:"olderThan"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "expected JSON body with olderThan", http.StatusBadRequest)
+		return
+	}
+	age, err := parseRetentionPeriod(req.OlderThan)
+	if err != nil || age <= 0 {
+		http.Error(w, "olderThan must be a duration or a number of days, like 730d", http.StatusBadRequest)
+		return
+	}
+	archived, err := store.ArchiveIssuedBefore(time.Now().UTC().Add(-age), staffTenant(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "credential archive error", "err", err)
+		http.Error(w, "Failed to archive the credentials", http.StatusInternalServerError)
+		return
+	}
+	out := []archiveState{}
+	for _, c := range archived {
+		auditArchive(r, "archived", c)
+		out = append(out, archiveState{ID: c.ID, Archived: true, ArchivedAt: c.ArchivedAt})
+	}
+	slog.InfoContext(r.Context(), "staff API: credentials archived", "count", len(out), "older_than", req.OlderThan)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
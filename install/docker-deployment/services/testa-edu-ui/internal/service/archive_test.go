@@ -0,0 +1,87 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestArchive checks archived credentials leave the staff listings and
+// credentials.json but still verify, come back whole when unarchived, and
+// take their cold storage with them when erased.
+func TestArchive(t *testing.T) {
+	old := cohortCredential("c1", "Jane Wanjiku", "BSc", "2019-07-01", "")
+	old.Credential, _ = json.Marshal(map[string]interface{}{
+		"credentialSubject": map[string]interface{}{"name": "Jane Wanjiku"},
+		"proof":             map[string]interface{}{"proofValue": "z3FXQ"},
+	})
+	old.Timestamp, old.SubjectID = []byte("tsr"), "did:key:z6Mkjane"
+	old.IssuedAt = time.Date(2019, 7, 1, 9, 0, 0, 0, time.UTC)
+	useCohortStore(t, old, cohortCredential("c2", "Amina Otieno", "BSc", "2025-07-01", ""))
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.DataDir = t.TempDir()
+	vc := bytes.Clone(old.Credential)
+
+	archived, err := store.ArchiveIssuedBefore(time.Now().AddDate(-2, 0, 0), "")
+	if err != nil || len(archived) != 1 || archived[0].ID != "c1" {
+		t.Fatalf("archived %v, %v", archived, err)
+	}
+	if list := store.ForTenant(""); len(list) != 1 || list[0].ID != "c2" {
+		t.Errorf("listing %v", list)
+	}
+	if list := store.Archived(""); len(list) != 1 || list[0].Credential != nil {
+		t.Errorf("archived listing %v", list)
+	}
+	if data, _ := os.ReadFile(store.path); bytes.Contains(data, []byte("Jane")) {
+		t.Error("credentials.json still holds the archived credential")
+	}
+	if c, ok := store.Get("c1"); !ok || !bytes.Equal(c.Credential, vc) || string(c.Timestamp) != "tsr" {
+		t.Errorf("archived credential read back as %+v", c)
+	}
+	if c, ok := store.ByFingerprint(credentialFingerprint(vc)); !ok || c.ID != "c1" || c.Credential == nil {
+		t.Errorf("archived credential by fingerprint %+v", c)
+	}
+	if list := store.ForSubject(old.SubjectID); len(list) != 1 || list[0].Credential == nil {
+		t.Errorf("holder's credentials %v", list)
+	}
+
+	req := httptest.NewRequest("POST", "/api/staff/credentials/c1/unarchive", nil)
+	req.SetPathValue("id", "c1")
+	rec := httptest.NewRecorder()
+	handleCredentialArchive(rec, req)
+	if rec.Code != 200 || !strings.Contains(rec.Body.String(), `"archived":false`) {
+		t.Fatalf("unarchive: %d %s", rec.Code, rec.Body)
+	}
+	if c, _ := store.Get("c1"); !c.ArchivedAt.IsZero() || !bytes.Equal(c.Credential, vc) || len(store.ForTenant("")) != 2 {
+		t.Errorf("unarchived credential %+v", c)
+	}
+	if _, err := os.Stat(store.archivePath("c1")); !os.IsNotExist(err) {
+		t.Errorf("cold storage file left behind: %v", err)
+	}
+	audit, _ := os.ReadFile(filepath.Join(config.DataDir, "archive-audit.jsonl"))
+	if !strings.Contains(string(audit), `"event":"unarchived","credentialId":"c1"`) {
+		t.Errorf("audit log %s", audit)
+	}
+
+	if ok, err := store.Archive("c1"); !ok || err != nil {
+		t.Fatalf("archive: %v, %v", ok, err)
+	}
+	if ok, err := store.Archive("c1"); ok || err != nil {
+		t.Errorf("archiving again: %v, %v", ok, err)
+	}
+	if _, err := store.EraseSubject(old.SubjectID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.archivePath("c1")); !os.IsNotExist(err) {
+		t.Errorf("erased credential kept in cold storage: %v", err)
+	}
+	if _, err := store.Archive("c1"); err != errCredentialErased {
+		t.Errorf("archiving an erased credential: %v", err)
+	}
+}
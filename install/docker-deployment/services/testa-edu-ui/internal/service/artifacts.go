@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Artifact pipeline. Once a credential is signed, its QR code (and with it
+// the JSON-XT encoding), certificate PDF and Apple Wallet pass are made in
+// the background, so the QR step and the downloads serve finished results
+// rather than running Node.js or laying out a PDF on each request. The QR
+// code comes first, as the PDF and the pass carry it, then the pass. The
+// PDF, in the language the credential was signed in, is made once the
+// verify step has run, as the certificate says whether it passed; PDFs are
+// kept by language and verification, so one made before is not served
+// after. At most ARTIFACT_WORKERS artifacts are made at a time across
+// sessions (0 turns the pipeline off, and every artifact is made on
+// demand).
+//
+// A request for an artifact still being made waits for it. One the
+// pipeline failed to make, or a PDF in another language, is made on
+// demand, so errors reach the user as they always have, and kept for the
+// next request. Signing again starts a new pipeline.
+
+// artifactJob is the artifacts made for one signed credential.
+type artifactJob struct {
+	lang string // of the PDF made after verification
+
+	qrDone chan struct{} // closed when qr and qrErr are set
+	qr     *QRResult
+	qrErr  error
+
+	mu      sync.Mutex
+	results map[string]*artifactResult // "pkpass", PDFs by pdfArtifactKey
+}
+
+// artifactResult is an artifact being made; data and err are set when done
+// is closed.
+type artifactResult struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func (res *artifactResult) failed() bool {
+	select {
+	case <-res.done:
+		return res.err != nil
+	default:
+		return false
+	}
+}
+
+var (
+	artifactSlotsMu sync.Mutex
+	artifactSlots   chan struct{}
+
+	// artifactPipelines is the pipelines running.
+	artifactPipelines sync.WaitGroup
+)
+
+// artifactSlot waits for one of the ARTIFACT_WORKERS slots and returns
+// the function that frees it.
+func artifactSlot() func() {
+	artifactSlotsMu.Lock()
+	if cap(artifactSlots) != config.ArtifactWorkers {
+		artifactSlots = make(chan struct{}, config.ArtifactWorkers)
+	}
+	slots := artifactSlots
+	artifactSlotsMu.Unlock()
+	slots <- struct{}{}
+	return func() { <-slots }
+}
+
+// startArtifacts starts making a newly signed credential's QR code and
+// pass; lang is the language of the PDF made once it is verified.
+func startArtifacts(sess *Session, lang string) {
+	if config.ArtifactWorkers <= 0 || sess.Format == FormatAnonCreds {
+		return
+	}
+	job := &artifactJob{lang: lang, qrDone: make(chan struct{}), results: map[string]*artifactResult{}}
+	var pass *artifactResult
+	if appleWalletEnabled() {
+		pass = job.pending("pkpass")
+	}
+	sessionsMu.Lock()
+	sess.artifacts = job
+	sessionsMu.Unlock()
+
+	artifactPipelines.Add(1)
+	go func() {
+		defer artifactPipelines.Done()
+		release := artifactSlot()
+		job.qr, job.qrErr = generateQRFor(sess)
+		release()
+		if job.qrErr == nil {
+			sessionsMu.Lock()
+			if sess.artifacts == job {
+				sess.QR = job.qr
+			}
+			sessionsMu.Unlock()
+		} else {
+			slog.Warn("artifact pipeline: QR code", "err", job.qrErr)
+		}
+		close(job.qrDone)
+		if pass != nil {
+			job.make("pkpass", pass, func() ([]byte, error) { return buildPKPass(sess) })
+		}
+	}()
+}
+
+// startVerifiedArtifacts starts making the PDF of a credential that has
+// just been verified.
+func startVerifiedArtifacts(sess *Session) {
+	job := sessionArtifacts(sess)
+	if job == nil {
+		return
+	}
+	key := pdfArtifactKey(sess, job.lang)
+	job.mu.Lock()
+	if _, ok := job.results[key]; ok {
+		job.mu.Unlock()
+		return
+	}
+	pdf := job.pending(key)
+	job.mu.Unlock()
+
+	artifactPipelines.Add(1)
+	go func() {
+		defer artifactPipelines.Done()
+		<-job.qrDone
+		job.make("PDF", pdf, func() ([]byte, error) { return generatePDF(sess, job.lang) })
+	}()
+}
+
+// make makes an artifact that carries the QR code, once a slot is free.
+func (j *artifactJob) make(name string, res *artifactResult, build func() ([]byte, error)) {
+	defer close(res.done)
+	if j.qrErr != nil {
+		res.err = j.qrErr
+		return
+	}
+	release := artifactSlot()
+	defer release()
+	if res.data, res.err = build(); res.err != nil {
+		slog.Warn("artifact pipeline: "+name, "err", res.err)
+	}
+}
+
+// pdfArtifactKey keeps a session's PDFs by language and by whether the
+// credential had been verified when the PDF was made.
+func pdfArtifactKey(sess *Session, lang string) string {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	if sess.Verified {
+		return "pdf:" + lang + ":verified"
+	}
+	return "pdf:" + lang
+}
+
+// pending adds an artifact the pipeline will make.
+func (j *artifactJob) pending(key string) *artifactResult {
+	res := &artifactResult{done: make(chan struct{})}
+	j.results[key] = res
+	return res
+}
+
+// artifact returns the artifact key, waiting for it if it is being made,
+// or makes it and keeps it.
+func (j *artifactJob) artifact(ctx context.Context, key string, build func() ([]byte, error)) ([]byte, error) {
+	j.mu.Lock()
+	res, ok := j.results[key]
+	if !ok || res.failed() {
+		res = j.pending(key)
+		j.mu.Unlock()
+		res.data, res.err = build()
+		close(res.done)
+		return res.data, res.err
+	}
+	j.mu.Unlock()
+	select {
+	case <-res.done:
+		return res.data, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func sessionArtifacts(sess *Session) *artifactJob {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	return sess.artifacts
+}
+
+// sessionQR returns the session's QR code, from the pipeline or else made
+// now.
+func sessionQR(ctx context.Context, sess *Session) (*QRResult, error) {
+	if job := sessionArtifacts(sess); job != nil {
+		select {
+		case <-job.qrDone:
+			if job.qrErr == nil {
+				return job.qr, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return generateQRFor(sess)
+}
+
+// sessionPDF returns the session's certificate PDF in lang.
+func sessionPDF(ctx context.Context, sess *Session, lang string) ([]byte, error) {
+	build := func() ([]byte, error) { return generatePDF(sess, lang) }
+	if job := sessionArtifacts(sess); job != nil {
+		return job.artifact(ctx, pdfArtifactKey(sess, lang), build)
+	}
+	return build()
+}
+
+// sessionPKPass returns the session's Apple Wallet pass.
+func sessionPKPass(ctx context.Context, sess *Session) ([]byte, error) {
+	build := func() ([]byte, error) { return buildPKPass(sess) }
+	if job := sessionArtifacts(sess); job != nil {
+		return job.artifact(ctx, "pkpass", build)
+	}
+	return build()
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestArtifactPipeline checks the QR code is made once, after signing, and
+// the PDF once, after verification, and that the QR step and the download
+// serve them.
+func TestArtifactPipeline(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	config.ArtifactWorkers, config.PDFRenderer = 2, PDFRendererBuiltin
+	qrs, pdfs := histogramCount(qrGenerationSeconds), histogramCount(pdfGenerationSeconds)
+
+	client := e2eClient(t)
+	e2eStep(t, client, srv.URL+"/issue", url.Values{
+		"studentName": {"Jane Wanjiku"}, "institution": {"Testa University"},
+		"degree": {"Bachelor of Science"}, "consent_issue": {"on"}, "qrMode": {QRModeCompact},
+	}, "")
+	for _, step := range []string{"token", "sign"} {
+		e2eStep(t, client, srv.URL+"/step/"+step, nil, "step-success")
+	}
+	artifactPipelines.Wait()
+	if histogramCount(qrGenerationSeconds) != qrs+1 || histogramCount(pdfGenerationSeconds) != pdfs {
+		t.Fatalf("signing made %d QR codes and %d PDFs", histogramCount(qrGenerationSeconds)-qrs, histogramCount(pdfGenerationSeconds)-pdfs)
+	}
+
+	e2eStep(t, client, srv.URL+"/step/verify", nil, "step-success")
+	artifactPipelines.Wait()
+	e2eStep(t, client, srv.URL+"/step/qr", nil, "step-success")
+	u, _ := url.Parse(srv.URL)
+	var sess *Session
+	for _, c := range client.Jar.Cookies(u) {
+		if c.Name == "sid" {
+			sess = sessions[c.Value]
+		}
+	}
+	verified := sess.artifacts.results["pdf:en:verified"]
+	if !sess.Verified || verified == nil || verified.err != nil {
+		t.Fatalf("verified %v, PDF %+v", sess.Verified, verified)
+	}
+	pdf := e2eGet(t, client, srv.URL+"/download/credential.pdf")
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.Equal(pdf, verified.data) {
+		t.Errorf("PDF download %.20q is not the verified certificate", pdf)
+	}
+	if histogramCount(qrGenerationSeconds) != qrs+1 || histogramCount(pdfGenerationSeconds) != pdfs+1 {
+		t.Errorf("made %d QR codes and %d PDFs, want the pipeline's", histogramCount(qrGenerationSeconds)-qrs, histogramCount(pdfGenerationSeconds)-pdfs)
+	}
+
+	req, _ := http.NewRequest("GET", srv.URL+"/download/credential.pdf", nil)
+	req.Header.Set("Accept-Language", "sw")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || histogramCount(pdfGenerationSeconds) != pdfs+2 {
+		t.Error("a PDF in another language was not made on demand")
+	}
+}
+
+func histogramCount(h *histogramVec) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var n uint64
+	for _, s := range h.series {
+		n += s.count
+	}
+	return n
+}
@@ -0,0 +1,507 @@
+package service
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Backup and restore. A backup holds everything the service keeps: every
+// store under DATA_DIR (credentials and their statuses, tenants, consents,
+// shares, keys generated at install, ...) and the files the configuration
+// names (CONFIG_FILE, SCHEMA_FILE, FIELD_MAPPING_FILE, GRADING_SCALES_FILE
+// and LMS_CONFIG_FILE, when set). It is a gzipped tar archive, encrypted
+// with BACKUP_KEY (32 bytes, hex encoded) in AES-GCM chunks so that a
+// changed, reordered or truncated backup is refused.
+//
+//	testa backup [-out file]
+//	testa restore [-force] [-config-dir dir] file
+//
+// Staff export one from a running service with GET /api/staff/backup.
+// Restoring only runs from the command line, with the service stopped:
+// the stores are held in memory, and a running service would write its own
+// over the restored files. restore checks the whole backup before writing
+// anything, and refuses a DATA_DIR that already has credentials or
+// tenants unless -force is given. The configuration files are written to
+// -config-dir (restored-config by default) for the operator to put in
+// place. Secrets set in the environment are not in the backup: restore
+// with the same HOLDER_KEY, LINK_SIGNING_KEY and SIGNING_KEY, or sealed
+// holder keys and signed links will not open.
+
+const (
+	backupMagic     = "TESTABK1"
+	backupChunkSize = 64 << 10
+	backupManifest  = "manifest.json"
+	backupStaging   = ".restore-"
+)
+
+// backupSkipped are the DATA_DIR files a backup leaves out: the leader
+// lock, and caches the service fills again.
+var backupSkipped = map[string]bool{"leader.lock": true, "did-cache.json": true}
+
+var errBackupDamaged = errors.New("backup: wrong BACKUP_KEY, or the backup is damaged")
+
+// BackupManifest lists what a backup holds.
+type BackupManifest struct {
+	Version   int       `json:"version"`
+	Created   time.Time `json:"created"`
+	PublicURL string    `json:"publicUrl"`
+	IssuerDID string    `json:"issuerDid"`
+	// Files are the SHA-256 digests of the archive's files, by name.
+	Files map[string]string `json:"files"`
+	// Config are the archived configuration files, by their settings.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// backupKey is BACKUP_KEY's key.
+func backupKey() ([]byte, error) {
+	if config.BackupKey == "" {
+		return nil, errors.New("backups need BACKUP_KEY")
+	}
+	return hex.DecodeString(config.BackupKey)
+}
+
+// backupConfigFiles are the configuration files to back up, by setting.
+func backupConfigFiles() map[string]string {
+	files := map[string]string{
+		"CONFIG_FILE":         getenv("CONFIG_FILE"),
+		"FIELD_MAPPING_FILE":  config.FieldMappingFile,
+		"GRADING_SCALES_FILE": config.GradingScalesFile,
+		"LMS_CONFIG_FILE":     config.LMSConfigFile,
+	}
+	if getenv("SCHEMA_FILE") != "" {
+		files["SCHEMA_FILE"] = config.SchemaFile
+	}
+	for setting, p := range files {
+		if p == "" {
+			delete(files, setting)
+		}
+	}
+	return files
+}
+
+func backupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// backupNonce is a chunk's nonce: the backup's random prefix, the chunk's
+// number, and whether it is the last.
+func backupNonce(prefix []byte, n uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[7:11], n)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+// backupWriter encrypts a backup in chunks; Close seals the last.
+type backupWriter struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+}
+
+func newBackupWriter(w io.Writer, key []byte) (*backupWriter, error) {
+	aead, err := backupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	b := &backupWriter{w: w, aead: aead, prefix: make([]byte, 7), buf: make([]byte, 0, backupChunkSize)}
+	if _, err := rand.Read(b.prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, backupMagic); err != nil {
+		return nil, err
+	}
+	_, err = w.Write(b.prefix)
+	return b, err
+}
+
+func (b *backupWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := min(backupChunkSize-len(b.buf), len(p))
+		b.buf, p = append(b.buf, p[:n]...), p[n:]
+		if len(b.buf) == backupChunkSize {
+			if err := b.seal(false); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (b *backupWriter) seal(last bool) error {
+	sealed := b.aead.Seal(nil, backupNonce(b.prefix, b.n, last), b.buf, []byte(backupMagic))
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(len(sealed)))
+	if _, err := b.w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := b.w.Write(sealed); err != nil {
+		return err
+	}
+	b.n++
+	b.buf = b.buf[:0]
+	return nil
+}
+
+func (b *backupWriter) Close() error {
+	return b.seal(true)
+}
+
+// backupReader decrypts a backup, failing unless it ends with its last
+// chunk.
+type backupReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	n      uint32
+	buf    []byte
+	done   bool
+}
+
+func newBackupReader(r io.Reader, key []byte) (*backupReader, error) {
+	aead, err := backupAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, len(backupMagic)+7)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(backupMagic)]) != backupMagic {
+		return nil, errors.New("backup: not a backup file")
+	}
+	return &backupReader{r: r, aead: aead, prefix: header[len(backupMagic):]}, nil
+}
+
+func (b *backupReader) Read(p []byte) (int, error) {
+	for len(b.buf) == 0 {
+		if b.done {
+			return 0, io.EOF
+		}
+		if err := b.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+func (b *backupReader) open() error {
+	var size [4]byte
+	if _, err := io.ReadFull(b.r, size[:]); err != nil {
+		return errBackupDamaged
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > backupChunkSize+uint32(b.aead.Overhead()) {
+		return errBackupDamaged
+	}
+	sealed := make([]byte, n)
+	if _, err := io.ReadFull(b.r, sealed); err != nil {
+		return errBackupDamaged
+	}
+	for _, last := range []bool{false, true} {
+		if plain, err := b.aead.Open(nil, backupNonce(b.prefix, b.n, last), sealed, []byte(backupMagic)); err == nil {
+			b.buf, b.done = plain, last
+			b.n++
+			return nil
+		}
+	}
+	return errBackupDamaged
+}
+
+// writeBackup writes an encrypted backup of dataDir and configFiles.
+func writeBackup(w io.Writer, key []byte, dataDir string, configFiles map[string]string) (BackupManifest, error) {
+	manifest := BackupManifest{
+		Version:   1,
+		Created:   time.Now().UTC(),
+		PublicURL: config.PublicURL,
+		IssuerDID: config.IssuerDID,
+		Files:     map[string]string{},
+		Config:    map[string]string{},
+	}
+	enc, err := newBackupWriter(w, key)
+	if err != nil {
+		return manifest, err
+	}
+	gz := gzip.NewWriter(enc)
+	tw := tar.NewWriter(gz)
+
+	add := func(name, file string) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o600, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tw, h), f); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+		manifest.Files[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	}
+
+	err = filepath.WalkDir(dataDir, func(p string, d os.DirEntry, err error) error {
+		switch {
+		case err != nil:
+			return err
+		case d.IsDir() && strings.HasPrefix(d.Name(), backupStaging):
+			return filepath.SkipDir
+		case !d.Type().IsRegular() || backupSkipped[d.Name()] || strings.HasSuffix(d.Name(), ".tmp"):
+			return nil
+		}
+		rel, err := filepath.Rel(dataDir, p)
+		if err != nil {
+			return err
+		}
+		return add("data/"+filepath.ToSlash(rel), p)
+	})
+	if err != nil {
+		return manifest, fmt.Errorf("backing up %s: %w", dataDir, err)
+	}
+	for setting, file := range configFiles {
+		name := "config/" + setting + filepath.Ext(file)
+		if err := add(name, file); err != nil {
+			return manifest, fmt.Errorf("backing up %s: %w", setting, err)
+		}
+		manifest.Config[setting] = name
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: backupManifest, Mode: 0o600, Size: int64(len(data)), ModTime: manifest.Created}); err != nil {
+		return manifest, err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return manifest, err
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	if err := gz.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, enc.Close()
+}
+
+// restoreBackup checks a backup and restores its data into dataDir and its
+// configuration files into configDir. Nothing is written to either unless
+// the whole backup checks out.
+func restoreBackup(r io.Reader, key []byte, dataDir, configDir string) (BackupManifest, error) {
+	var manifest BackupManifest
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		return manifest, err
+	}
+	staging, err := os.MkdirTemp(dataDir, backupStaging)
+	if err != nil {
+		return manifest, err
+	}
+	defer os.RemoveAll(staging)
+
+	dec, err := newBackupReader(r, key)
+	if err != nil {
+		return manifest, err
+	}
+	gz, err := gzip.NewReader(dec)
+	if err != nil {
+		return manifest, errBackupDamaged
+	}
+	tr := tar.NewReader(gz)
+	digests := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, errBackupDamaged
+		}
+		name := hdr.Name
+		if hdr.Typeflag != tar.TypeReg || path.Clean(name) != name ||
+			(name != backupManifest && !strings.HasPrefix(name, "data/") && !strings.HasPrefix(name, "config/")) ||
+			strings.Contains(name, "..") {
+			return manifest, fmt.Errorf("backup: unexpected entry %q", name)
+		}
+		if name == backupManifest {
+			if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+				return manifest, fmt.Errorf("backup: reading the manifest: %w", err)
+			}
+			continue
+		}
+		file := filepath.Join(staging, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+			return manifest, err
+		}
+		f, err := os.OpenFile(file, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err != nil {
+			return manifest, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, h), tr)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("backup: restoring %s: %w", name, err)
+		}
+		digests[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	// Read on to the backup's last chunk, so a truncated one is refused.
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		return manifest, errBackupDamaged
+	}
+	if _, err := io.Copy(io.Discard, dec); err != nil {
+		return manifest, errBackupDamaged
+	}
+	if manifest.Version != 1 {
+		return manifest, errors.New("backup: no manifest, or one of an unknown version")
+	}
+	if len(digests) != len(manifest.Files) {
+		return manifest, errors.New("backup: the files do not match the manifest")
+	}
+	for name, digest := range manifest.Files {
+		if digests[name] != digest {
+			return manifest, fmt.Errorf("backup: %s does not match the manifest", name)
+		}
+	}
+
+	for name := range manifest.Files {
+		var dest string
+		if rel, ok := strings.CutPrefix(name, "data/"); ok {
+			dest = filepath.Join(dataDir, filepath.FromSlash(rel))
+		} else {
+			dest = filepath.Join(configDir, path.Base(name))
+		}
+		if err := os.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+			return manifest, err
+		}
+		if err := os.Rename(filepath.Join(staging, filepath.FromSlash(name)), dest); err != nil {
+			return manifest, fmt.Errorf("restoring %s: %w", name, err)
+		}
+	}
+	return manifest, nil
+}
+
+// dataDirInUse reports whether a data directory has credentials or
+// tenants that a restore would replace.
+func dataDirInUse(dataDir string) bool {
+	for _, name := range []string{"credentials.json", "tenants.json"} {
+		if _, err := os.Stat(filepath.Join(dataDir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// handleBackup streams a backup to staff.
+func handleBackup(w http.ResponseWriter, r *http.Request) {
+	key, err := backupKey()
+	if err != nil {
+		http.Error(w, "Backups need BACKUP_KEY to be set", http.StatusConflict)
+		return
+	}
+	name := "testa-edu-" + time.Now().UTC().Format("20060102-150405") + ".backup"
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+name+"\"")
+	w.Header().Set("Cache-Control", "no-store")
+	manifest, err := writeBackup(w, key, config.DataDir, backupConfigFiles())
+	if err != nil {
+		// Headers are sent; the client gets a backup that will not restore.
+		slog.ErrorContext(r.Context(), "backup: export failed", "err", err)
+		return
+	}
+	slog.InfoContext(r.Context(), "backup: exported", "files", len(manifest.Files))
+}
+
+func cliBackup(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	out := fs.String("out", "testa-edu-"+time.Now().UTC().Format("20060102-150405")+".backup", "file to write the backup to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	key, err := backupKey()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(*out, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	manifest, err := writeBackup(f, key, config.DataDir, backupConfigFiles())
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(*out)
+		return err
+	}
+	fmt.Fprintf(stdout, "backed up %d files to %s\n", len(manifest.Files), *out)
+	return nil
+}
+
+func cliRestore(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	force := fs.Bool("force", false, "restore over a data directory that has credentials or tenants")
+	configDir := fs.String("config-dir", "restored-config", "directory to write the configuration files to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("want one backup file")
+	}
+	key, err := backupKey()
+	if err != nil {
+		return err
+	}
+	if dataDirInUse(config.DataDir) && !*force {
+		return fmt.Errorf("%s already has credentials or tenants; restore into a fresh instance, or give -force", config.DataDir)
+	}
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	manifest, err := restoreBackup(f, key, config.DataDir, *configDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "restored %d files of the backup of %s taken %s\n", len(manifest.Files), manifest.PublicURL, manifest.Created.Format(time.RFC3339))
+	for setting, name := range manifest.Config {
+		fmt.Fprintf(stdout, "%s: %s\n", setting, filepath.Join(*configDir, path.Base(name)))
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestBackupRestore checks a backup restores the data directory and the
+// configuration files into a fresh instance, and that a backup opened with
+// another key, changed or cut short restores nothing.
+func TestBackupRestore(t *testing.T) {
+	src, cfgDir := t.TempDir(), t.TempDir()
+	files := map[string]string{
+		"credentials.json":     `{"c1":{"id":"c1","status":"revoked"}}`,
+		"tenants.json":         `{"testa":{"id":"testa"}}`,
+		"link-signing.key":     "0123456789abcdef",
+		"shares/tok1.json":     `{"token":"tok1"}`,
+		"leader.lock":          "",
+		"did-cache.json":       "{}",
+		"credentials.json.tmp": "partial",
+	}
+	for name, data := range files {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o700)
+		if err := os.WriteFile(p, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mapping := filepath.Join(cfgDir, "mapping.json")
+	os.WriteFile(mapping, []byte(`[]`), 0o600)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	var backup bytes.Buffer
+	manifest, err := writeBackup(&backup, key, src, map[string]string{"FIELD_MAPPING_FILE": mapping})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Files) != 5 || manifest.Config["FIELD_MAPPING_FILE"] != "config/FIELD_MAPPING_FILE.json" {
+		t.Errorf("manifest %+v", manifest)
+	}
+	if bytes.Contains(backup.Bytes(), []byte("revoked")) {
+		t.Error("the backup is not encrypted")
+	}
+
+	restore := func(data []byte, key []byte) (string, error) {
+		dst := t.TempDir()
+		_, err := restoreBackup(bytes.NewReader(data), key, dst, filepath.Join(dst, "config"))
+		return dst, err
+	}
+	dst, err := restore(backup.Bytes(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"credentials.json", "tenants.json", "link-signing.key", "shares/tok1.json"} {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || string(got) != files[name] {
+			t.Errorf("%s restored as %q, %v", name, got, err)
+		}
+	}
+	for _, name := range []string{"leader.lock", "did-cache.json", "credentials.json.tmp"} {
+		if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+			t.Errorf("%s restored", name)
+		}
+	}
+	if got, err := os.ReadFile(filepath.Join(dst, "config", "FIELD_MAPPING_FILE.json")); err != nil || string(got) != "[]" {
+		t.Errorf("configuration restored as %q, %v", got, err)
+	}
+	if !dataDirInUse(dst) || dataDirInUse(t.TempDir()) {
+		t.Error("dataDirInUse")
+	}
+
+	tampered := bytes.Clone(backup.Bytes())
+	tampered[len(tampered)/2] ^= 1
+	for name, tc := range map[string]struct {
+		data []byte
+		key  []byte
+	}{
+		"wrong key": {backup.Bytes(), bytes.Repeat([]byte{8}, 32)},
+		"tampered":  {tampered, key},
+		"truncated": {backup.Bytes()[:backup.Len()-20], key},
+	} {
+		dst, err := restore(tc.data, tc.key)
+		if err == nil || !strings.HasPrefix(err.Error(), "backup:") {
+			t.Errorf("%s: %v", name, err)
+		}
+		if entries, _ := os.ReadDir(dst); len(entries) != 0 {
+			t.Errorf("%s: restored %v", name, entries)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"log/slog"
+	"net/http"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/pdf417"
+)
+
+// Alternative symbologies for employer scanners that cannot read QR. Both
+// carry the compact payload (EDU1: deflate + base45) of the delivered
+// credential whatever the session's QR mode, so one decoder handles every
+// printed form.
+
+const barcodeModuleSize = 4 // pixels per module
+
+type altBarcode struct {
+	Label     string
+	QuietZone int // modules; PDF417 needs 2, Data Matrix 1
+	Encode    func(data string) (barcode.Barcode, error)
+}
+
+var altBarcodes = map[string]altBarcode{
+	"pdf417":     {Label: "PDF417", QuietZone: 2, Encode: encodePDF417},
+	"datamatrix": {Label: "Data Matrix", QuietZone: 1, Encode: datamatrix.Encode},
+}
+
+// encodePDF417 uses the error correction level the spec recommends for
+// payloads of this size, stepping down when the symbol would not fit.
+func encodePDF417(data string) (barcode.Barcode, error) {
+	var err error
+	for level := byte(5); level >= 2; level-- {
+		var code barcode.Barcode
+		if code, err = pdf417.Encode(data, level); err == nil {
+			return code, nil
+		}
+	}
+	return nil, err
+}
+
+func handleDownloadBarcode(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("symbology")
+	symbology, ok := altBarcodes[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+
+	var data string
+	payload, err := deliveryPayload(sess)
+	if err == nil {
+		data, _, err = compactPayload(payload)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "barcode", "barcode", name, "err", err)
+		http.Error(w, "Failed to encode credential", http.StatusInternalServerError)
+		return
+	}
+	code, err := symbology.Encode(data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "barcode", "barcode", name, "err", err)
+		http.Error(w, fmt.Sprintf("This credential (%d chars) is too large for a %s barcode. Use link mode instead.", len(data), symbology.Label), http.StatusUnprocessableEntity)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, drawModules(code, barcodeModuleSize, symbology.QuietZone)); err != nil {
+		http.Error(w, "Failed to render barcode", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"testa-edu-credential-%s.png\"", name))
+	w.Write(buf.Bytes())
+}
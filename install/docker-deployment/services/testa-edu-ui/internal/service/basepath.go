@@ -0,0 +1,49 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Base path. BASE_PATH mounts the app under a path prefix, e.g. /edu-ui
+// behind a proxy routing https://example.ac.ke/edu-ui/ to it. Pages,
+// htmx calls, redirects and cookies all carry the prefix. Requests are
+// served with or without it, so a proxy may pass the prefix through or
+// strip it, and the container's health check keeps working. PUBLIC_URL,
+// which links in QR codes and emails are built from, should include the
+// prefix; it does when it is left to default.
+
+// parseBasePath normalizes BASE_PATH to "" or /prefix.
+func parseBasePath(s string) (string, error) {
+	s = strings.TrimRight(strings.TrimSpace(s), "/")
+	if s == "" {
+		return "", nil
+	}
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") || strings.ContainsAny(s, "?#%\\ ") {
+		return "", fmt.Errorf("invalid BASE_PATH %q, want a path such as /edu-ui", s)
+	}
+	return s, nil
+}
+
+// appPath is the path p of the app, under the base path.
+func appPath(p string) string {
+	return config.BasePath + p
+}
+
+// withBasePath strips the base path from requests that have it.
+func withBasePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		base := config.BasePath
+		switch {
+		case base == "":
+		case r.URL.Path == base:
+			http.Redirect(w, r, base+"/", http.StatusMovedPermanently)
+			return
+		case strings.HasPrefix(r.URL.Path, base+"/"):
+			http.StripPrefix(base, next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+func TestParseBasePath(t *testing.T) {
+	for in, want := range map[string]string{"": "", "/": "", "/edu-ui": "/edu-ui", "/edu-ui/": "/edu-ui", "/apps/edu": "/apps/edu"} {
+		if got, err := parseBasePath(in); err != nil || got != want {
+			t.Errorf("%q: %q, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"edu-ui", "//evil.example", "/edu?x=1"} {
+		if _, err := parseBasePath(in); err == nil {
+			t.Errorf("%q parsed", in)
+		}
+	}
+}
+
+// TestBasePath serves the app under /edu-ui: pages link under the prefix,
+// and requests are served with the prefix or without it.
+func TestBasePath(t *testing.T) {
+	t.Setenv("BASE_PATH", "/edu-ui/")
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	if config.BasePath != "/edu-ui" || !strings.HasSuffix(config.PublicURL, "/edu-ui") {
+		t.Fatalf("base path %q, public URL %q", config.BasePath, config.PublicURL)
+	}
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+
+	resp, err := client.Get(srv.URL + "/edu-ui/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{`href="/edu-ui/static/style.css"`, `hx-post="/edu-ui/issue"`, `src="/edu-ui/static/logo.svg"`} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("page has no %s", want)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/edu-ui":                  http.StatusMovedPermanently,
+		"/edu-ui/static/style.css": http.StatusOK,
+		"/static/style.css":        http.StatusOK,
+		"/health":                  http.StatusOK,
+	} {
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != status {
+			t.Errorf("%s: %d, want %d", path, resp.StatusCode, status)
+		}
+	}
+}
+
+// TestBasePathSignedLink checks a link signed under the base path verifies
+// whether the proxy passes the prefix through or strips it.
+func TestBasePathSignedLink(t *testing.T) {
+	saved, savedKey := config, linkKey
+	t.Cleanup(func() { config, linkKey = saved, savedKey })
+	config.BasePath, linkKey = "/edu-ui", []byte("0123456789abcdef")
+
+	link, err := url.Parse(signLink("https://example.ac.ke/edu-ui/credentials/c1?token=t1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var verified error
+	handler := withBasePath(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { verified = verifyLinkSignature(r) }))
+	for _, path := range []string{link.Path, strings.TrimPrefix(link.Path, "/edu-ui")} {
+		verified = errors.New("not served")
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path+"?"+link.RawQuery, nil))
+		if verified != nil {
+			t.Errorf("%s: %v", path, verified)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const bbsProofType = "BbsBlsSignature2020"
+
+// bbsFrame builds the JSON-LD frame used to derive a BBS+ proof that
+// reveals only the listed credentialSubject properties. Subject id and type
+// are always kept so the derived credential still names its holder.
+func bbsFrame(credential map[string]interface{}, reveal []string) map[string]interface{} {
+	subject := map[string]interface{}{
+		"@explicit": true,
+		"type":      "EducationCredential",
+	}
+	for _, field := range reveal {
+		subject[field] = map[string]interface{}{}
+	}
+
+	frame := map[string]interface{}{
+		"@context":          credential["@context"],
+		"type":              credential["type"],
+		"@explicit":         true,
+		"issuer":            map[string]interface{}{},
+		"credentialSubject": subject,
+	}
+	for _, k := range []string{"issuanceDate", "expirationDate", "validFrom", "validUntil"} {
+		if _, ok := credential[k]; ok {
+			frame[k] = map[string]interface{}{}
+		}
+	}
+	return frame
+}
+
+type deriveRequest struct {
+	Credential json.RawMessage        `json:"credential"`
+	Frame      map[string]interface{} `json:"frame"`
+}
+
+// DeriveProof asks the agent to derive a BbsBlsSignatureProof2020 from a
+// BBS+-signed credential using a JSON-LD frame.
+func (a *AgentClient) DeriveProof(token string, credential json.RawMessage, frame map[string]interface{}) (json.RawMessage, error) {
+	if a.local != nil {
+		return nil, fmt.Errorf("BBS+ derivation: %w", errAgentDisabled)
+	}
+	release, err := a.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	body, err := a.postJSON(token, "/agent/credential/derive", deriveRequest{Credential: credential, Frame: frame})
+	if err != nil {
+		return nil, err
+	}
+	derived, ok := signedCredential(body)
+	if !ok {
+		return nil, fmt.Errorf("proof derivation failed: no proof in response: %s", truncateBody(body))
+	}
+	return derived, nil
+}
+
+// deriveBBS derives a selective-disclosure credential revealing only the
+// requested subject fields.
+func deriveBBS(ctx context.Context, credential json.RawMessage, reveal []string) (json.RawMessage, error) {
+	var cred map[string]interface{}
+	if err := json.Unmarshal(credential, &cred); err != nil {
+		return nil, fmt.Errorf("invalid credential: %w", err)
+	}
+	proof, _ := cred["proof"].(map[string]interface{})
+	if proof["type"] != bbsProofType {
+		return nil, fmt.Errorf("credential is not signed with %s", bbsProofType)
+	}
+
+	agent := agentClient.WithContext(ctx)
+	token, err := agent.GetToken()
+	if err != nil {
+		return nil, err
+	}
+	return agent.DeriveProof(token, credential, bbsFrame(cred, reveal))
+}
+
+// bbsFields lists the subject properties a holder may choose to reveal.
+func bbsFields(credential json.RawMessage) []string {
+	var cred struct {
+		Subject map[string]interface{} `json:"credentialSubject"`
+	}
+	json.Unmarshal(credential, &cred)
+	var fields []string
+	for k := range cred.Subject {
+		if k != "id" && k != "type" {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// deriveLimiter caps the derivations one client can request a minute;
+// each one costs the agent a BBS+ proof.
+var deriveLimiter = newRateLimiter(20, time.Minute)
+
+// handleDeriveAPI lets a holder derive a BBS+ proof for a stored credential,
+// authorized like GET /c/{id} with its claim token or a DID-auth proof.
+// Body: {"id": "...", "reveal": ["name", "degree"]}
+func handleDeriveAPI(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     string   `json:"id"`
+		Reveal []string `json:"reveal"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "expected JSON body with id and reveal", http.StatusBadRequest)
+		return
+	}
+	if !deriveLimiter.Allow(clientIP(r)) {
+		http.Error(w, "Too many requests. Please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	cred, ok := store.Get(req.ID)
+	if !ok || !cred.ErasedAt.IsZero() {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+	if err := authorizeRetrieval(r, cred); err != nil {
+		slog.WarnContext(r.Context(), "credential derivation denied", "credential", cred.ID, "err", err)
+		writeRetrievalChallenge(w, cred, err)
+		return
+	}
+	if cred.Encrypted {
+		http.Error(w, "Proofs cannot be derived from an encrypted credential", http.StatusBadRequest)
+		return
+	}
+
+	derived, err := deriveBBS(r.Context(), cred.Credential, req.Reveal)
+	if err != nil {
+		msg := agentFailure(r.Context(), "derive error", err)
+		var ae *AgentError
+		if errors.As(err, &ae) && ae.Rejected() {
+			http.Error(w, msg, http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, "Failed to derive proof", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(derived)
+}
+
+// handleDownloadDerived derives a proof for the session's credential from
+// the fields ticked on the results page.
+func handleDownloadDerived(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	derived, err := deriveBBS(r.Context(), sess.SignedCredential, r.Form["reveal"])
+	if err != nil {
+		slog.ErrorContext(r.Context(), "derive error", "err", err)
+		http.Error(w, "Failed to derive proof", http.StatusBadGateway)
+		return
+	}
+
+	var pretty bytes.Buffer
+	json.Indent(&pretty, derived, "", "  ")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-derived-credential.json\"")
+	w.Write(pretty.Bytes())
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"strings"
+)
+
+const blockcertsContext = "https://w3id.org/blockcerts/v3"
+
+// buildBlockcerts produces an unsigned Blockcerts v3 certificate for the
+// session's credential. Blockcerts proofs (MerkleProof2019) are created by
+// anchoring a batch on a blockchain with cert-issuer, so the export is meant
+// to be fed into existing Blockcerts tooling rather than verified as-is.
+func buildBlockcerts(sess *Session) ([]byte, error) {
+	form, err := credentialForm(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := map[string]interface{}{
+		"id":       studentDID(form),
+		"name":     form.StudentName,
+		"alumniOf": form.Institution,
+		"degree":   form.Degree,
+	}
+
+	// Blockcerts carries non-JSON-LD attributes as a JSON string with an
+	// accompanying JSON Schema so viewers can label them.
+	metaValues := map[string]string{}
+	metaProps := map[string]interface{}{}
+	for _, f := range []struct{ key, title, value string }{
+		{"fieldOfStudy", "Field of Study", form.FieldOfStudy},
+		{"enrollmentDate", "Enrollment Date", form.EnrollmentDate},
+		{"graduationDate", "Graduation Date", form.GraduationDate},
+		{"studentId", "Student ID", form.StudentID},
+		{"gpa", "GPA", gradeText(form)},
+		{"honors", "Honors", form.Honors},
+	} {
+		if f.value == "" {
+			continue
+		}
+		metaValues[f.key] = f.value
+		metaProps[f.key] = map[string]interface{}{"type": "string", "title": f.title}
+	}
+	metadata, err := json.Marshal(map[string]interface{}{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type":    "object",
+		"properties": map[string]interface{}{
+			"education": map[string]interface{}{
+				"type":       "object",
+				"properties": metaProps,
+			},
+		},
+		"education": metaValues,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("encoding metadata: %w", err)
+	}
+
+	cert := map[string]interface{}{
+		"@context": []interface{}{
+			"https://www.w3.org/2018/credentials/v1",
+			blockcertsContext,
+		},
+		"id":                "urn:uuid:" + newUUID(),
+		"type":              []string{"VerifiableCredential", "BlockcertsCredential"},
+		"issuer":            sess.IssuerDID,
+		"issuanceDate":      credentialTime(issuedAt(form)),
+		"credentialSubject": subject,
+		"metadata":          string(metadata),
+		"display": map[string]interface{}{
+			"contentMediaType": "text/html",
+			"content":          blockcertsDisplayHTML(form),
+		},
+	}
+	return json.Marshal(cert)
+}
+
+// blockcertsDisplayHTML renders the certificate face shown by Blockcerts
+// viewers.
+func blockcertsDisplayHTML(form CredentialForm) string {
+	var b strings.Builder
+	b.WriteString(`<section style="font-family:sans-serif;text-align:center;padding:2em">`)
+	fmt.Fprintf(&b, `<h2>%s</h2>`, html.EscapeString(form.Institution))
+	b.WriteString(`<p>This certifies that</p>`)
+	fmt.Fprintf(&b, `<h1>%s</h1>`, html.EscapeString(form.StudentName))
+	b.WriteString(`<p>has been awarded the degree of</p>`)
+	fmt.Fprintf(&b, `<h2>%s</h2>`, html.EscapeString(form.Degree))
+	if form.FieldOfStudy != "" {
+		fmt.Fprintf(&b, `<p>in %s</p>`, html.EscapeString(form.FieldOfStudy))
+	}
+	if form.Honors != "" {
+		fmt.Fprintf(&b, `<p><em>%s</em></p>`, html.EscapeString(form.Honors))
+	}
+	if form.GraduationDate != "" {
+		fmt.Fprintf(&b, `<p>Conferred %s</p>`, html.EscapeString(form.GraduationDate))
+	}
+	b.WriteString(`</section>`)
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+// Download bundle. /download/bundle.zip packages a session's signed
+// credential (JSON-LD, JWT or SD-JWT, as issued), its JSON-XT encoding,
+// the wallet QR code and the PDF certificate in one archive. The PDF is
+// rendered before anything is sent, so a rendering failure is still an
+// error response; the archive itself is written straight to the client.
+
+// bundleFile is one entry of the download bundle.
+type bundleFile struct {
+	name string
+	data []byte
+	// stored entries are already compressed (PNG, PDF) and are not
+	// deflated again.
+	stored bool
+}
+
+// bundleFiles lists a session's artifacts, with its rendered PDF.
+func bundleFiles(sess *Session, pdf []byte) []bundleFile {
+	var files []bundleFile
+	if jwt, ok := compactJWT(sess.SignedCredential); ok {
+		files = append(files, bundleFile{name: "testa-edu-credential.jwt", data: []byte(jwt)})
+	} else if sdjwt, ok := sdjwtString(sess); ok {
+		files = append(files, bundleFile{name: "testa-edu-credential.sd-jwt", data: []byte(sdjwt)})
+	} else {
+		var pretty bytes.Buffer
+		json.Indent(&pretty, sess.SignedCredential, "", "  ")
+		files = append(files, bundleFile{name: "testa-edu-credential.json", data: pretty.Bytes()})
+	}
+	if sess.QR != nil {
+		if sess.QR.JSONXTUri != "" {
+			files = append(files, bundleFile{name: "testa-edu-credential.jsonxt", data: []byte(sess.QR.JSONXTUri)})
+		}
+		if png, err := base64.StdEncoding.DecodeString(sess.QR.QRPngBase64); err == nil && len(png) > 0 {
+			files = append(files, bundleFile{name: "testa-edu-credential-qr.png", data: png, stored: true})
+		}
+	}
+	if len(pdf) > 0 {
+		files = append(files, bundleFile{name: "testa-edu-credential.pdf", data: pdf, stored: true})
+	}
+	return files
+}
+
+// writeBundle streams files to w as a ZIP archive.
+func writeBundle(w io.Writer, sess *Session, files []bundleFile) error {
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		hdr := &zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: sess.CreatedAt}
+		if file.stored {
+			hdr.Method = zip.Store
+		}
+		f, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := f.Write(file.data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func handleDownloadBundle(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderErrorPage(w, r, http.StatusNotFound, "No credential available. Please issue a credential first.")
+		return
+	}
+
+	pdf, err := sessionPDF(r.Context(), sess, requestLanguage(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "PDF error", "err", err)
+		renderErrorPage(w, r, http.StatusInternalServerError, "Failed to generate PDF")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.zip\"")
+	if err := writeBundle(w, sess, bundleFiles(sess, pdf)); err != nil {
+		// Headers are sent; the client sees a truncated archive.
+		slog.ErrorContext(r.Context(), "bundle error", "err", err)
+	}
+}
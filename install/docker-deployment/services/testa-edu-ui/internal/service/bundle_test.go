@@ -0,0 +1,69 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// TestWriteBundle checks the bundle holds each artifact in the format the
+// credential was issued in, and that it unzips to the original bytes.
+func TestWriteBundle(t *testing.T) {
+	png := append(append([]byte{}, pngSignature...), 1, 2, 3)
+	pdf := []byte("%PDF-1.7\n%%EOF\n")
+	qr := &QRResult{JSONXTUri: "jxt:testa:edu:1:abc", QRPngBase64: base64.StdEncoding.EncodeToString(png)}
+	jwtCred, _ := json.Marshal("eyJhbGciOiJFUzI1NiJ9.eyJ2YyI6e319.c2ln")
+
+	tests := []struct {
+		name string
+		sess *Session
+		want map[string]string
+	}{
+		{"json-ld", &Session{SignedCredential: json.RawMessage(`{"id":"urn:uuid:1"}`), QR: qr}, map[string]string{
+			"testa-edu-credential.json":   "{\n  \"id\": \"urn:uuid:1\"\n}",
+			"testa-edu-credential.jsonxt": qr.JSONXTUri,
+			"testa-edu-credential-qr.png": string(png),
+			"testa-edu-credential.pdf":    string(pdf),
+		}},
+		{"jwt", &Session{SignedCredential: jwtCred}, map[string]string{
+			"testa-edu-credential.jwt": "eyJhbGciOiJFUzI1NiJ9.eyJ2YyI6e319.c2ln",
+			"testa-edu-credential.pdf": string(pdf),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.sess.CreatedAt = time.Date(2025, 7, 1, 9, 0, 0, 0, time.UTC)
+			var buf bytes.Buffer
+			if err := writeBundle(&buf, tt.sess, bundleFiles(tt.sess, pdf)); err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(zr.File) != len(tt.want) {
+				t.Errorf("%d entries, want %d", len(zr.File), len(tt.want))
+			}
+			for _, f := range zr.File {
+				want, ok := tt.want[f.Name]
+				if !ok {
+					t.Errorf("unexpected entry %s", f.Name)
+					continue
+				}
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, _ := io.ReadAll(rc)
+				rc.Close()
+				if string(got) != want {
+					t.Errorf("%s = %q, want %q", f.Name, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Certificate templates. With PDF_RENDERER=html (the default) the PDF
+// certificate is certificate.html in CERTIFICATE_TEMPLATES_DIR, an
+// html/template rendered to HTML and printed to PDF by headless Chromium
+// (scripts/html-to-pdf.js). As with email templates, an institution can
+// override it with a certificate.html in a subdirectory named after its
+// slug or tenant ID. Images and fonts next to the template, such as a seal
+// or a registrar's signature, are inlined with {{asset "seal.png"}}, the
+// institution's file taking precedence. Templates are parsed on every
+// render, so layouts change without a rebuild or restart.
+//
+// PDF_RENDERER=builtin keeps the fpdf layout in pdf.go, which is also used
+// when a template cannot be rendered.
+
+const (
+	PDFRendererHTML    = "html"
+	PDFRendererBuiltin = "builtin"
+)
+
+const (
+	certificateTemplate = "certificate.html"
+	maxAssetSize        = 2 << 20
+	htmlToPDFTimeout    = 30 * time.Second
+)
+
+// CertificateData is what certificate templates can reference. Templates
+// also have the page functions t, date and datetime, in the certificate's
+// language, and asset.
+type CertificateData struct {
+	Form      CredentialForm
+	Theme     Theme
+	Logo      template.URL // the theme's logo, inlined when it is on this site
+	IssuerDID string
+	IssuedAt  time.Time
+	Verified  bool
+	QR        template.URL // data: URI of the verification QR code, if any
+	Lang      string
+
+	// VerifyURL is the public page verifying the credential with
+	// CredentialID, for checking a printed copy; empty when the credential
+	// is not stored here.
+	VerifyURL    string
+	CredentialID string
+}
+
+// certificateTemplatePath returns the institution's copy of a certificate
+// template, falling back to the default.
+func certificateTemplatePath(tenant, name string) string {
+	if tenant != "" {
+		p := filepath.Join(liveConfig().CertificateTemplatesDir, tenant, name)
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return filepath.Join(liveConfig().CertificateTemplatesDir, name)
+}
+
+// certificateAsset inlines a file from the institution's template
+// directory, or the default one, as a data: URI.
+func certificateAsset(tenant, name string) (template.URL, error) {
+	name = filepath.Clean("/" + name)
+	var data []byte
+	var err error
+	dirs := []string{liveConfig().CertificateTemplatesDir}
+	if tenant != "" {
+		dirs = append([]string{filepath.Join(liveConfig().CertificateTemplatesDir, tenant)}, dirs...)
+	}
+	for _, dir := range dirs {
+		if data, err = readAsset(filepath.Join(dir, name)); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("asset %s: %w", strings.TrimPrefix(name, "/"), err)
+	}
+	return dataURI(name, data), nil
+}
+
+func readAsset(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxAssetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxAssetSize {
+		return nil, fmt.Errorf("larger than %d bytes", maxAssetSize)
+	}
+	return data, nil
+}
+
+func dataURI(name string, data []byte) template.URL {
+	kind := mime.TypeByExtension(filepath.Ext(name))
+	if kind == "" {
+		kind = http.DetectContentType(data)
+	}
+	return template.URL("data:" + kind + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+// certificateLogo is the theme's logo for a certificate. Logos under
+// /static are inlined, as Chromium has no access to this server.
+func certificateLogo(t Theme) template.URL {
+	if p, ok := strings.CutPrefix(t.LogoURL, "/static/"); ok {
+		name := filepath.Join("static", filepath.Clean("/"+p))
+		if data, err := readAsset(name); err == nil {
+			return dataURI(name, data)
+		}
+		return ""
+	}
+	return template.URL(t.LogoURL)
+}
+
+// renderCertificateTemplate renders one of a session's certificate
+// templates, such as certificateTemplate.
+func renderCertificateTemplate(sess *Session, name, lang string) ([]byte, error) {
+	theme := themeFor(sess.TenantID)
+	tenant := emailTenant(sess.TenantID, sess.Form.Institution)
+	funcs := languageFuncs(lang)
+	funcs["asset"] = func(asset string) (template.URL, error) { return certificateAsset(tenant, asset) }
+
+	path := certificateTemplatePath(tenant, name)
+	t, err := template.New(filepath.Base(path)).Funcs(funcs).ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	data := CertificateData{
+		Form:      sess.Form,
+		Theme:     theme,
+		Logo:      certificateLogo(theme),
+		IssuerDID: sess.IssuerDID,
+		IssuedAt:  issuedAt(sess.Form),
+		Verified:  sess.Verified,
+		Lang:      lang,
+	}
+	data.VerifyURL, data.CredentialID = certificateVerification(sess)
+	if sess.QR != nil && sess.QR.QRPngBase64 != "" {
+		data.QR = template.URL("data:image/png;base64," + sess.QR.QRPngBase64)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// certificateHTML renders a session's certificate template.
+func certificateHTML(sess *Session, lang string) ([]byte, error) {
+	return renderCertificateTemplate(sess, certificateTemplate, lang)
+}
+
+// templatePDF renders a session's certificate template and prints it.
+func templatePDF(sess *Session, lang string) ([]byte, error) {
+	page, err := certificateHTML(sess, lang)
+	if err != nil {
+		return nil, err
+	}
+	return htmlToPDF(page)
+}
+
+// htmlToPDF prints a self-contained HTML page to PDF.
+func htmlToPDF(page []byte) ([]byte, error) {
+	pdf, err := runChromiumScript("html-to-pdf.js", page)
+	if err != nil {
+		return nil, fmt.Errorf("HTML to PDF failed: %w", err)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		return nil, fmt.Errorf("HTML to PDF failed: output is not a PDF")
+	}
+	return pdf, nil
+}
+
+// runChromiumScript runs one of the headless Chromium scripts with input
+// on stdin and returns its stdout.
+func runChromiumScript(script string, input []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), htmlToPDFTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, config.NodeBin, filepath.Join(config.ScriptsDir, script))
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Dir = config.ScriptsDir
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errMsg := stderr.String()
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return nil, errors.New(errMsg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// sampleCertificateSession is a credential to preview templates with.
+func sampleCertificateSession(tenantID, institution string) *Session {
+	if institution == "" {
+		institution = liveConfig().Theme.Name
+	}
+	sess := &Session{
+		Form: CredentialForm{
+			StudentName:    "Alice Johnson",
+			Institution:    institution,
+			Degree:         "Bachelor of Science",
+			FieldOfStudy:   "Computer Science",
+			EnrollmentDate: "2021-09-01",
+			GraduationDate: "2025-07-01",
+			StudentID:      "STU2024001",
+			GPA:            "3.85",
+			GPAScale:       "4.0",
+			Honors:         "First Class Honours",
+		},
+		IssuerDID: config.IssuerDID,
+		TenantID:  tenantID,
+		Verified:  true,
+	}
+	if png, err := renderQRPNG(config.PublicURL, 320, defaultQROptions()); err == nil {
+		sess.QR = &QRResult{QRPngBase64: base64.StdEncoding.EncodeToString(png)}
+	}
+	return sess
+}
+
+// handleCertificateTemplatePreview renders an institution's certificate
+// template with sample data, as HTML or, with ?format=pdf, printed. With
+// ?format=svg or ?format=png it renders the certificate image instead.
+func handleCertificateTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	institution := strings.TrimSpace(r.URL.Query().Get("institution"))
+	tenantID := ""
+	if t, ok := tenants.Get(institution); ok {
+		tenantID, institution = t.ID, t.Name
+	}
+	sess := sampleCertificateSession(tenantID, institution)
+	lang := requestLanguage(r)
+
+	format := r.URL.Query().Get("format")
+	name := certificateTemplate
+	if format == "svg" || format == "png" {
+		name = certificateImageTemplate
+	}
+	page, err := renderCertificateTemplate(sess, name, lang)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	w.Header().Set("X-Certificate-Template", strings.TrimPrefix(certificateTemplatePath(emailTenant(tenantID, institution), name), liveConfig().CertificateTemplatesDir+string(filepath.Separator)))
+	switch format {
+	case "svg":
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(page)
+		return
+	case "png":
+		png, err := svgToPNG(page)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "certificate image preview", "err", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+		return
+	}
+	if format != "pdf" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+		return
+	}
+	pdf, err := htmlToPDF(page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "certificate preview", "err", err)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Write(pdf)
+}
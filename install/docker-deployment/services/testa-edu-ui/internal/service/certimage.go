@@ -0,0 +1,74 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Certificate images. certificate-image.svg in CERTIFICATE_TEMPLATES_DIR is
+// a 1200×630 SVG template, overridable per institution like
+// certificate.html, showing the key credential fields and the
+// verification QR. Students download it as SVG, or as PNG rasterized by
+// headless Chromium (scripts/svg-to-png.js), to share on social media or
+// in an email signature. Templates see the same CertificateData and
+// functions as certificate.html.
+
+const certificateImageTemplate = "certificate-image.svg"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// certificateSVG renders a session's certificate image template.
+func certificateSVG(sess *Session, lang string) ([]byte, error) {
+	return renderCertificateTemplate(sess, certificateImageTemplate, lang)
+}
+
+// svgToPNG rasterizes a self-contained SVG at its own size.
+func svgToPNG(svg []byte) ([]byte, error) {
+	png, err := runChromiumScript("svg-to-png.js", svg)
+	if err != nil {
+		return nil, fmt.Errorf("SVG to PNG failed: %w", err)
+	}
+	if !bytes.HasPrefix(png, pngSignature) {
+		return nil, fmt.Errorf("SVG to PNG failed: output is not a PNG")
+	}
+	return png, nil
+}
+
+func handleDownloadCertificateSVG(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	svg, err := certificateSVG(sess, requestLanguage(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "certificate image error", "err", err)
+		http.Error(w, "Failed to render certificate image", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-certificate.svg\"")
+	w.Write(svg)
+}
+
+func handleDownloadCertificatePNG(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	svg, err := certificateSVG(sess, requestLanguage(r))
+	if err == nil {
+		var png []byte
+		if png, err = svgToPNG(svg); err == nil {
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-certificate.png\"")
+			w.Write(png)
+			return
+		}
+	}
+	slog.ErrorContext(r.Context(), "certificate image error", "err", err)
+	http.Error(w, "Failed to render certificate image", http.StatusInternalServerError)
+}
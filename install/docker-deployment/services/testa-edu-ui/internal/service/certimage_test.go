@@ -0,0 +1,71 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCertificateImageTemplate checks the shipped certificate image
+// template renders to well-formed SVG, with form values escaped.
+func TestCertificateImageTemplate(t *testing.T) {
+	useCatalogs(t)
+	path := filepath.Join("templates-data", "certificates", certificateImageTemplate)
+	tmpl, err := template.New(filepath.Base(path)).Funcs(languageFuncs("sw")).ParseFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data CertificateData
+	}{
+		{"full", CertificateData{
+			Form: CredentialForm{
+				StudentName:    `Amina "A&B" <Njeri>`,
+				Institution:    "Testa University",
+				Degree:         "Bachelor of Science",
+				FieldOfStudy:   "Computer Science",
+				GraduationDate: "2025-07-01",
+				Honors:         "First Class Honours",
+			},
+			Theme:     defaultTheme,
+			Logo:      template.URL("data:image/svg+xml;base64,PHN2Zy8+"),
+			QR:        template.URL("data:image/png;base64,iVBORw0KGgo="),
+			Verified:  true,
+			VerifyURL: "https://edu.example/verify/abc?x=1&y=2",
+			IssuedAt:  time.Now(),
+		}},
+		{"minimal", CertificateData{Form: CredentialForm{StudentName: "Amina", Degree: "BSc"}, Theme: defaultTheme}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, tt.data); err != nil {
+				t.Fatal(err)
+			}
+			var text strings.Builder
+			d := xml.NewDecoder(&buf)
+			for {
+				tok, err := d.Token()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("not well-formed SVG: %v", err)
+				}
+				if cd, ok := tok.(xml.CharData); ok {
+					text.Write(cd)
+				}
+			}
+			if !strings.Contains(text.String(), tt.data.Form.StudentName) {
+				t.Errorf("student name missing from %q", text.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Claim codes let the registrar hand a student a short code (on paper, by
+// phone) that the student redeems at /claim to download or wallet-import
+// their credential without staff involvement. A code unlocks the stored
+// credential's claim token: the store keeps only an HMAC of the code as
+// the lookup key, and the token sealed with a key derived from the code,
+// so claims.json alone does not give access to any credential.
+//
+// Codes expire after CLAIM_CODE_TTL and burn on first successful use.
+// Every issue, redemption, rejection and regeneration is appended to
+// DATA_DIR/claim-audit.jsonl. Staff can regenerate a lost code, which also
+// rotates the credential's claim token so earlier links stop working.
+
+const (
+	// Crockford base32: no I, L, O or U, so codes survive being read aloud.
+	claimCodeAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+	claimCodeLength   = 12
+	claimCodeGroup    = 4
+)
+
+type ClaimCode struct {
+	CredentialID string    `json:"credentialId"`
+	SubjectID    string    `json:"subjectId,omitempty"`
+	SealedToken  []byte    `json:"sealedToken"`
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+	UsedAt       time.Time `json:"usedAt,omitempty"`
+}
+
+// ClaimAuditEvent is one line of the claim audit log. It names the
+// credential, never the student or the code.
+type ClaimAuditEvent struct {
+	At           time.Time `json:"at"`
+	Event        string    `json:"event"` // issued, redeemed, rejected, regenerated
+	CredentialID string    `json:"credentialId,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+}
+
+type ClaimStore struct {
+	path      string
+	auditPath string
+
+	mu    sync.Mutex
+	codes map[string]*ClaimCode // by claimLookupKey
+}
+
+var claims *ClaimStore
+
+func NewClaimStore(dataDir string) (*ClaimStore, error) {
+	s := &ClaimStore{
+		path:      filepath.Join(dataDir, "claims.json"),
+		auditPath: filepath.Join(dataDir, "claim-audit.jsonl"),
+		codes:     make(map[string]*ClaimCode),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.codes); err != nil {
+			return nil, fmt.Errorf("parsing claim codes: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading claim codes: %w", err)
+	}
+	return s, nil
+}
+
+func (s *ClaimStore) saveLocked() error {
+	data, err := json.Marshal(s.codes)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing claim codes: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// audit appends an event to the claim audit log.
+func (s *ClaimStore) audit(event, credentialID, reason string) {
+	line, _ := json.Marshal(ClaimAuditEvent{
+		At:           time.Now().UTC(),
+		Event:        event,
+		CredentialID: credentialID,
+		Reason:       reason,
+	})
+	f, err := os.OpenFile(s.auditPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err == nil {
+		_, err = f.Write(append(line, '\n'))
+		f.Close()
+	}
+	if err != nil {
+		slog.Error("claim audit", "err", err)
+	}
+}
+
+func randomClaimCode() string {
+	b := make([]byte, claimCodeLength)
+	rand.Read(b)
+	for i := range b {
+		b[i] = claimCodeAlphabet[int(b[i])%len(claimCodeAlphabet)]
+	}
+	return string(b)
+}
+
+// normalizeClaimCode uppercases a typed code, drops separators and maps
+// the letters Crockford base32 treats as digits.
+func normalizeClaimCode(code string) string {
+	var b strings.Builder
+	for _, c := range strings.ToUpper(code) {
+		switch c {
+		case '-', ' ':
+			continue
+		case 'O':
+			c = '0'
+		case 'I', 'L':
+			c = '1'
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// formatClaimCode groups a code for display: XXXX-XXXX-XXXX.
+func formatClaimCode(code string) string {
+	var groups []string
+	for i := 0; i < len(code); i += claimCodeGroup {
+		groups = append(groups, code[i:min(i+claimCodeGroup, len(code))])
+	}
+	return strings.Join(groups, "-")
+}
+
+func claimKey(label, code string) []byte {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte(label + "\x00" + code))
+	return mac.Sum(nil)
+}
+
+func claimLookupKey(code string) string {
+	return hex.EncodeToString(claimKey("claim-lookup", code))
+}
+
+func claimAEAD(code string) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(claimKey("claim-seal", code))
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Create issues a new claim code for a stored credential and its claim
+// token, valid for ttl. The code is returned once and not kept.
+func (s *ClaimStore) Create(credentialID, subjectID, token string, ttl time.Duration) (string, time.Time, error) {
+	code := randomClaimCode()
+	aead, err := claimAEAD(code)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	sealed := aead.Seal(nonce, nonce, []byte(token), []byte(credentialID))
+
+	now := time.Now().UTC()
+	claim := &ClaimCode{
+		CredentialID: credentialID,
+		SubjectID:    subjectID,
+		SealedToken:  sealed,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+	}
+	s.mu.Lock()
+	s.codes[claimLookupKey(code)] = claim
+	err = s.saveLocked()
+	s.mu.Unlock()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	s.audit("issued", credentialID, "")
+	return formatClaimCode(code), claim.ExpiresAt, nil
+}
+
+// Redeem returns the credential ID and claim token a code unlocks, and
+// burns the code.
+func (s *ClaimStore) Redeem(code string) (string, string, error) {
+	id, token, err := s.redeem(normalizeClaimCode(code))
+	if err != nil {
+		s.audit("rejected", id, err.Error())
+		return "", "", err
+	}
+	s.audit("redeemed", id, "")
+	return id, token, nil
+}
+
+func (s *ClaimStore) redeem(code string) (string, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	claim, ok := s.codes[claimLookupKey(code)]
+	if !ok {
+		return "", "", fmt.Errorf("unknown claim code")
+	}
+	if !claim.UsedAt.IsZero() {
+		return claim.CredentialID, "", fmt.Errorf("claim code already used")
+	}
+	if time.Now().After(claim.ExpiresAt) {
+		return claim.CredentialID, "", fmt.Errorf("claim code expired")
+	}
+
+	aead, err := claimAEAD(code)
+	if err != nil {
+		return claim.CredentialID, "", err
+	}
+	n := aead.NonceSize()
+	if len(claim.SealedToken) < n {
+		return claim.CredentialID, "", fmt.Errorf("corrupt claim record")
+	}
+	token, err := aead.Open(nil, claim.SealedToken[:n], claim.SealedToken[n:], []byte(claim.CredentialID))
+	if err != nil {
+		return claim.CredentialID, "", fmt.Errorf("unsealing claim token: %w", err)
+	}
+
+	claim.UsedAt = time.Now().UTC()
+	claim.SealedToken = nil
+	if err := s.saveLocked(); err != nil {
+		return claim.CredentialID, "", err
+	}
+	return claim.CredentialID, string(token), nil
+}
+
+// Revoke deletes the outstanding codes for a credential.
+func (s *ClaimStore) Revoke(credentialID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key, claim := range s.codes {
+		if claim.CredentialID == credentialID && claim.UsedAt.IsZero() {
+			delete(s.codes, key)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// EraseSubject deletes the claim codes issued to subjectID.
+func (s *ClaimStore) EraseSubject(subjectID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key, claim := range s.codes {
+		if claim.SubjectID == subjectID {
+			delete(s.codes, key)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// Expire deletes claim codes created before cutoff.
+func (s *ClaimStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for key, claim := range s.codes {
+		if !claim.CreatedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			delete(s.codes, key)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+func claimPageURL(tenantID string) string {
+	return tenantURL(tenantID) + "/claim"
+}
+
+// handleClaimCodeCreate issues a claim code for the session's credential,
+// storing the credential first.
+func handleClaimCodeCreate(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderFragment(w, r, "claim-code", map[string]interface{}{"Error": "Session expired. Please start over."})
+		return
+	}
+	id, token, err := storeSessionCredential(sess)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "claim code error", "err", err)
+		renderFragment(w, r, "claim-code", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	code, expires, err := claims.Create(id, studentDID(sess.Form), token, liveConfig().ClaimCodeTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "claim code error", "err", err)
+		renderFragment(w, r, "claim-code", map[string]interface{}{"Error": "Failed to create a claim code"})
+		return
+	}
+	slog.InfoContext(r.Context(), "claim code issued", "credential", id)
+	renderFragment(w, r, "claim-code", map[string]interface{}{
+		"Code":      code,
+		"ClaimURL":  claimPageURL(sess.TenantID),
+		"ExpiresAt": expires,
+	})
+}
+
+func handleClaimPage(w http.ResponseWriter, r *http.Request) {
+	renderClaimPage(w, r, map[string]interface{}{"Code": r.URL.Query().Get("code"), "Theme": themeFor(hostTenant(r))})
+}
+
+// handleClaimRedeem shows the student their credential's download link
+// and wallet QR for a valid claim code.
+func handleClaimRedeem(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	data := map[string]interface{}{"Code": code, "Theme": themeFor(hostTenant(r))}
+
+	id, token, err := claims.Redeem(code)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "claim rejected", "err", err)
+		data["Error"] = "That claim code is not valid, has expired or has already been used. Check it and try again, or ask your registrar for a new one."
+		renderClaimPage(w, r, data)
+		return
+	}
+	cred, ok := store.Get(id)
+	if !ok || !cred.ErasedAt.IsZero() {
+		data["Error"] = "This credential is no longer available."
+		renderClaimPage(w, r, data)
+		return
+	}
+
+	link := claimURL(cred.TenantID, id, token)
+	png, err := renderQRPNG(link, 320, defaultQROptions())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "claim QR error", "err", err)
+	} else {
+		data["QRPngBase64"] = base64.StdEncoding.EncodeToString(png)
+	}
+	slog.InfoContext(r.Context(), "claim code redeemed", "credential", id)
+	data["Claimed"] = true
+	data["Theme"] = themeFor(cred.TenantID)
+	data["Format"] = cred.Format
+	data["IssuedAt"] = cred.IssuedAt
+	data["LinkURL"] = link
+	data["DownloadURL"] = signLink(credentialURL(cred.TenantID, id) + "?download=1&token=" + url.QueryEscape(token))
+	if subject, err := credentialSubjectOf(cred); err == nil {
+		data["LinkedInURL"] = linkedInURL(formFromSubject(subject), themeFor(cred.TenantID), cred.IssuedAt, verificationURL(cred.TenantID, id), id)
+	}
+	renderClaimPage(w, r, data)
+}
+
+func renderClaimPage(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := pages(r).ExecuteTemplate(w, "claim", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+type ClaimRegenerateRequest struct {
+	CredentialID string `json:"credentialId"`
+	SubjectDID   string `json:"subjectDid"`
+	Institution  string `json:"institution"`
+	StudentID    string `json:"studentId"`
+}
+
+// handleClaimRegenerate issues a fresh claim code for a student who lost
+// theirs: outstanding codes are revoked and the credential's claim token is
+// rotated. Without a credential ID, the subject's latest credential is
+// used.
+func handleClaimRegenerate(w http.ResponseWriter, r *http.Request) {
+	var req ClaimRegenerateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	id := req.CredentialID
+	if id == "" {
+		did := req.SubjectDID
+		if did == "" {
+			did, _ = subjects.Lookup(CredentialForm{Institution: req.Institution, StudentID: req.StudentID})
+		}
+		if did != "" {
+			id, _ = store.LatestForSubject(did)
+		}
+	}
+	cred, ok := staffCredential(r, id)
+	if id == "" || !ok || !cred.ErasedAt.IsZero() {
+		http.Error(w, "No stored credential found; give credentialId, subjectDid, or institution and studentId", http.StatusNotFound)
+		return
+	}
+
+	if _, err := claims.Revoke(id); err != nil {
+		slog.ErrorContext(r.Context(), "claim regenerate", "err", err)
+		http.Error(w, "Failed to revoke existing codes", http.StatusInternalServerError)
+		return
+	}
+	token := newClaimToken()
+	if err := store.SetTokenHash(id, hashClaimToken(token)); err != nil {
+		slog.ErrorContext(r.Context(), "claim regenerate", "err", err)
+		http.Error(w, "Failed to rotate claim token", http.StatusInternalServerError)
+		return
+	}
+	code, expires, err := claims.Create(id, cred.SubjectID, token, liveConfig().ClaimCodeTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "claim regenerate", "err", err)
+		http.Error(w, "Failed to create a claim code", http.StatusInternalServerError)
+		return
+	}
+	claims.audit("regenerated", id, "")
+	slog.InfoContext(r.Context(), "staff API: claim code regenerated", "credential", id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"credentialId": id,
+		"code":         code,
+		"expiresAt":    expires,
+		"claimUrl":     claimPageURL(cred.TenantID),
+	})
+}
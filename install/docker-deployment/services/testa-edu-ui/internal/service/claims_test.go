@@ -0,0 +1,143 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestClaimCodeFormat checks that codes survive being read aloud and
+// retyped.
+func TestClaimCodeFormat(t *testing.T) {
+	code := randomClaimCode()
+	if len(code) != claimCodeLength || strings.Trim(code, claimCodeAlphabet) != "" {
+		t.Fatalf("randomClaimCode() = %q", code)
+	}
+	if got := formatClaimCode("ABCD0123WXYZ"); got != "ABCD-0123-WXYZ" {
+		t.Errorf("formatClaimCode = %q", got)
+	}
+
+	tests := []struct{ typed, want string }{
+		{"ABCD-0123-WXYZ", "ABCD0123WXYZ"},
+		{"abcd 0123 wxyz", "ABCD0123WXYZ"},
+		{"OOIL-oil", "0011011"},
+	}
+	for _, tt := range tests {
+		if got := normalizeClaimCode(tt.typed); got != tt.want {
+			t.Errorf("normalizeClaimCode(%q) = %q, want %q", tt.typed, got, tt.want)
+		}
+	}
+}
+
+// TestClaimCodeRedeem checks that a code unlocks its credential's claim
+// token and that claims.json holds neither the code nor the token.
+func TestClaimCodeRedeem(t *testing.T) {
+	useKeys(t, bytes.Repeat([]byte{1}, 32), nil)
+	dir := t.TempDir()
+	s, err := NewClaimStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, err := s.Create("cred-1", "did:example:amina", "secret-token", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "claims.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain := strings.ReplaceAll(code, "-", "")
+	if bytes.Contains(data, []byte(plain)) || bytes.Contains(data, []byte("secret-token")) {
+		t.Errorf("claims.json holds the code or token: %s", data)
+	}
+
+	if _, _, err := s.Redeem("0000-0000-0000"); err == nil {
+		t.Error("unknown code redeemed")
+	}
+
+	// Reload from disk and redeem the code as a student might type it.
+	s, err = NewClaimStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, token, err := s.Redeem(strings.ToLower(code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "cred-1" || token != "secret-token" {
+		t.Errorf("Redeem = %q, %q", id, token)
+	}
+}
+
+// TestClaimCodeLifecycle checks that codes burn on first use, expire after
+// their TTL and stop working when revoked, and that every outcome is
+// audited without the student's details.
+func TestClaimCodeLifecycle(t *testing.T) {
+	useKeys(t, bytes.Repeat([]byte{1}, 32), nil)
+	dir := t.TempDir()
+	s, err := NewClaimStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	used, _, err := s.Create("cred-used", "did:example:amina", "token-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Redeem(used); err != nil {
+		t.Fatal(err)
+	}
+	expired, _, err := s.Create("cred-expired", "did:example:amina", "token-2", -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, _, err := s.Create("cred-revoked", "did:example:amina", "token-3", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Revoke("cred-revoked"); err != nil || n != 1 {
+		t.Fatalf("Revoke = %d, %v", n, err)
+	}
+
+	tests := []struct{ name, code, reason string }{
+		{"used", used, "claim code already used"},
+		{"expired", expired, "claim code expired"},
+		{"revoked", revoked, "unknown claim code"},
+	}
+	for _, tt := range tests {
+		if _, _, err := s.Redeem(tt.code); err == nil || err.Error() != tt.reason {
+			t.Errorf("%s: Redeem err = %v, want %q", tt.name, err, tt.reason)
+		}
+	}
+
+	audit, err := os.ReadFile(filepath.Join(dir, "claim-audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(audit)), "\n")
+	events := make([]string, len(lines))
+	for i, line := range lines {
+		var e ClaimAuditEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatal(err)
+		}
+		events[i] = e.Event + " " + e.CredentialID
+	}
+	want := []string{
+		"issued cred-used", "redeemed cred-used", "issued cred-expired", "issued cred-revoked",
+		"rejected cred-used", "rejected cred-expired", "rejected ",
+	}
+	if strings.Join(events, ", ") != strings.Join(want, ", ") {
+		t.Errorf("audit events = %q, want %q", events, want)
+	}
+	for _, secret := range []string{"did:example:amina", "token-", strings.ReplaceAll(used, "-", "")} {
+		if strings.Contains(string(audit), secret) {
+			t.Errorf("audit log contains %q", secret)
+		}
+	}
+}
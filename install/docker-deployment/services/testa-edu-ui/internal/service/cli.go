@@ -0,0 +1,460 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Companion CLI. The testa command (cmd/testa) is a command-line tool for
+// scripting and air-gapped issuance, built from the service's code: it
+// runs its command with the service's configuration — the same
+// environment, issuer, data directory and agent, or no agent at all with
+// SIGNING_MODE=local — and exits.
+//
+//	testa issue [-format ldp_vc|jwt_vc|vc+sd-jwt] [-qr-mode mode] [-pdf] [-out dir] students.csv
+//	testa verify credential.json ...
+//	testa qr [-qr-mode mode] [-pdf] [-out dir] credential.json ...
+//	testa backup [-out file]
+//	testa restore [-force] [-config-dir dir] file
+//
+// issue reads students from a CSV file with a header row, or a JSON array
+// of objects, with the issuance form's field names (studentName,
+// institution, degree, ...). Each is issued as the wizard issues one —
+// built, checked against the schema, signed and verified — with the
+// consent scopes of -consent taken as given, and written to the output
+// directory as <studentId>.json (or .jwt, .sd-jwt), its QR code as .png
+// and, with -pdf, its certificate as .pdf. Slack and Teams are not
+// notified. verify runs the checks of the scan page on credential files,
+// JSON, JWT or QR text, and qr renders the QR code and certificate of
+// credentials already issued. backup and restore are described in
+// backup.go.
+//
+// The commands open DATA_DIR's stores as the service does. Those that
+// write them — issue, qr (in link mode it stores the credential) and
+// restore — take DATA_DIR's lock (leader.go), so they refuse to run while
+// the service does: every store rewrites its whole file from memory, and
+// one process's records would overwrite the other's. verify and backup
+// only read, and run beside the service.
+
+// cliName is the command's name in its messages.
+const cliName = "testa"
+
+type cliCommandFunc func(args []string, stdout io.Writer) error
+
+var cliCommands = map[string]cliCommandFunc{
+	"issue":   cliIssue,
+	"verify":  cliVerify,
+	"qr":      cliQR,
+	"backup":  cliBackup,
+	"restore": cliRestore,
+}
+
+// cliWrites lists the commands that write DATA_DIR.
+var cliWrites = map[string]bool{"issue": true, "qr": true, "restore": true}
+
+// errCLIFailed fails a command whose problems it has already reported.
+var errCLIFailed = errors.New("")
+
+// RunCLI runs the command named by args[0] with the rest of args, and
+// returns the process's exit status.
+func RunCLI(args []string) int {
+	name := "help"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := cliCommands[name]
+	if !ok {
+		if name != "help" && name != "-h" && name != "-help" {
+			fmt.Fprintf(os.Stderr, "%s: unknown command %q\n", cliName, name)
+		}
+		fmt.Fprintf(os.Stderr, "usage: %s issue|verify|qr|backup|restore [flags] file...\n", cliName)
+		return 2
+	}
+	initService()
+	if cliWrites[name] {
+		if err := lockDataDir(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s %s: %v; stop the service first\n", cliName, name, err)
+			return 1
+		}
+	}
+	err := cmd(args, os.Stdout)
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		return 2
+	case err != errCLIFailed:
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", cliName, name, err)
+	}
+	return 1
+}
+
+// cliOutput holds the flags for what the commands write.
+type cliOutput struct {
+	qrMode string
+	pdf    bool
+	dir    string
+}
+
+func (o *cliOutput) flags(fs *flag.FlagSet) {
+	fs.StringVar(&o.qrMode, "qr-mode", liveConfig().QRMode, "QR mode: pixelpass, compact, cbor or link")
+	fs.BoolVar(&o.pdf, "pdf", false, "also write each certificate as PDF")
+	fs.StringVar(&o.dir, "out", ".", "directory to write to")
+}
+
+func (o *cliOutput) check() error {
+	if !validQRMode(o.qrMode) {
+		return fmt.Errorf("unsupported QR mode %q", o.qrMode)
+	}
+	return os.MkdirAll(o.dir, 0o755)
+}
+
+// write writes a session's QR code, and its certificate with -pdf, as
+// name.png and name.pdf.
+func (o *cliOutput) write(sess *Session, name string) error {
+	qr, err := generateQRFor(sess)
+	if err != nil {
+		return fmt.Errorf("QR: %w", err)
+	}
+	sess.QR = qr
+	png, err := base64.StdEncoding.DecodeString(qr.QRPngBase64)
+	if err != nil {
+		return fmt.Errorf("QR: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(o.dir, name+".png"), png, 0o644); err != nil {
+		return err
+	}
+	if !o.pdf {
+		return nil
+	}
+	pdf, err := generatePDF(sess, liveConfig().DefaultLanguage)
+	if err != nil {
+		return fmt.Errorf("PDF: %w", err)
+	}
+	return os.WriteFile(filepath.Join(o.dir, name+".pdf"), pdf, 0o644)
+}
+
+func cliIssue(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("issue", flag.ContinueOnError)
+	format := fs.String("format", FormatLDP, "credential format: ldp_vc, jwt_vc or vc+sd-jwt")
+	issuer := fs.String("issuer", "", "issuer DID (default ISSUER_DID)")
+	proofType := fs.String("proof-type", "", "proof type (default that of the issuer)")
+	scopes := fs.String("consent", ConsentScopeIssue, "consent scopes the students gave, comma-separated")
+	var out cliOutput
+	out.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("want one file of students")
+	}
+	if !validFormat(*format) || *format == FormatAnonCreds {
+		return fmt.Errorf("unsupported credential format %q", *format)
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	given := splitList(*scopes)
+	for _, scope := range given {
+		if !slices.Contains(consentScopes, scope) {
+			return fmt.Errorf("unknown consent scope %q", scope)
+		}
+	}
+	if !slices.Contains(given, ConsentScopeIssue) {
+		return fmt.Errorf("the students' consent to issuance is required")
+	}
+	if out.qrMode == QRModeLink && !slices.Contains(given, ConsentScopeStore) {
+		return fmt.Errorf("link mode keeps the credential on the portal, which needs consent to storage")
+	}
+	issuerDID, err := resolveIssuerDID(*issuer)
+	if err != nil {
+		return err
+	}
+	pt, err := resolveProofType(*proofType, issuerDID)
+	if err != nil {
+		return err
+	}
+	records, err := readStudents(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	token, err := agentClient.GetToken()
+	if err != nil {
+		return fmt.Errorf("authenticating with the agent: %w", err)
+	}
+
+	failed := 0
+	for i, rec := range records {
+		name := cliFileName(rec["studentId"], i)
+		sess := &Session{
+			ProofType: pt,
+			Format:    *format,
+			IssuerDID: issuerDID,
+			QRMode:    out.qrMode,
+			QROptions: defaultQROptions(),
+			Token:     token,
+			CreatedAt: time.Now(),
+		}
+		if err := issueRecord(context.Background(), sess, rec, given); err != nil {
+			failed++
+			fmt.Fprintf(stdout, "%s\tfailed\t%v\n", name, err)
+			continue
+		}
+		if err := writeCredentialFile(filepath.Join(out.dir, name), sess.SignedCredential); err != nil {
+			return err
+		}
+		if err := out.write(sess, name); err != nil {
+			failed++
+			fmt.Fprintf(stdout, "%s\tfailed\t%v\n", name, err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s\tissued\t%s\n", name, sess.Form.SubjectDID)
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%s issue: %d of %d credentials failed\n", cliName, failed, len(records))
+		return errCLIFailed
+	}
+	return nil
+}
+
+// issueRecord issues one student's credential into sess, as the wizard's
+// steps do, with the consent scopes given. The issue command and the LMS
+// connectors (lms.go) issue with it.
+func issueRecord(ctx context.Context, sess *Session, rec map[string]string, scopes []string) error {
+	form, err := issueForm(func(k string) string { return strings.TrimSpace(rec[k]) })
+	if err != nil {
+		return err
+	}
+	if form.SubjectDID, err = subjects.DIDFor(form); err != nil {
+		return fmt.Errorf("creating the student's DID: %w", err)
+	}
+	sess.Form = form
+	sess.Consent = &Consent{
+		SubjectDID: form.SubjectDID,
+		GivenBy:    form.StudentName,
+		Role:       "student",
+		Scopes:     scopes,
+		TermsURL:   liveConfig().ConsentTermsURL,
+		RecordedAt: time.Now().UTC(),
+	}
+	if err := consents.Put(sess.Consent); err != nil {
+		return fmt.Errorf("recording consent: %w", err)
+	}
+
+	form, err = credentialForm(sess)
+	if err != nil {
+		return fmt.Errorf("preparing the credential: %w", err)
+	}
+	payload := buildCredentialPayload(form, sess.IssuerDID, sess.ProofType)
+	addConsentToCredential(payload, sess.Consent)
+	if err := beforeSign(ctx, sess, payload); err != nil {
+		return err
+	}
+	if err := credSchema.Validate(payload["credential"]); err != nil {
+		return err
+	}
+	agent := agentClient.WithContext(ctx)
+	if sess.SignedCredential, err = signSession(agent, sess, form, payload); err != nil {
+		return fmt.Errorf("signing: %w", err)
+	}
+	afterSign(ctx, sess, sess.SignedCredential)
+	sess.Timestamp = timestampIssued(sess)
+
+	verification, err := agent.VerifyCredential(sess.Token, sess.SignedCredential)
+	if err != nil {
+		return fmt.Errorf("verifying: %w", err)
+	}
+	if !verification.Verified {
+		return fmt.Errorf("the signed credential does not verify: %s", verification.Message())
+	}
+	sess.Verified, sess.VerifyMessage = true, verification.Message()
+	return nil
+}
+
+// readStudents reads the records of a CSV file with a header row, or of a
+// JSON array of objects.
+func readStudents(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var records []map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var raw []map[string]interface{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, r := range raw {
+			rec := make(map[string]string, len(r))
+			for k, v := range r {
+				if v != nil {
+					rec[k] = fmt.Sprint(v)
+				}
+			}
+			records = append(records, rec)
+		}
+	} else {
+		rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for _, row := range rows[min(1, len(rows)):] {
+			rec := make(map[string]string, len(row))
+			for i, v := range row {
+				rec[strings.TrimSpace(rows[0][i])] = v
+			}
+			records = append(records, rec)
+		}
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: no students", path)
+	}
+	return records, nil
+}
+
+var unsafeFileName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// cliFileName names a student's files after their student ID, or their
+// place in the file.
+func cliFileName(studentID string, i int) string {
+	name := strings.Trim(unsafeFileName.ReplaceAllString(studentID, "-"), "-.")
+	if name == "" {
+		name = fmt.Sprintf("student-%03d", i+1)
+	}
+	return name
+}
+
+// writeCredentialFile writes a signed credential as base.json, or as
+// base.jwt or base.sd-jwt when it is compact.
+func writeCredentialFile(base string, signed json.RawMessage) error {
+	if compact, ok := compactJWT(signed); ok {
+		ext := ".jwt"
+		if strings.Contains(compact, "~") {
+			ext = ".sd-jwt"
+		}
+		return os.WriteFile(base+ext, []byte(compact), 0o644)
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, signed, "", "  "); err != nil {
+		return err
+	}
+	pretty.WriteByte('\n')
+	return os.WriteFile(base+".json", pretty.Bytes(), 0o644)
+}
+
+// readCredentialFile decodes a credential file: JSON, a JWT or QR text.
+func readCredentialFile(path string) (*scannedCredential, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxScanData {
+		return nil, fmt.Errorf("%s: the credential is too large", path)
+	}
+	sc, err := decodeCredentialText(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return sc, nil
+}
+
+func cliVerify(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("want one or more credential files")
+	}
+	failed := false
+	for _, path := range fs.Args() {
+		sc, err := readCredentialFile(path)
+		if err != nil {
+			return err
+		}
+		result, err := verifyScanned(agentClient.context(), sc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		outcome := "verified"
+		if !result.Verified {
+			outcome, failed = "NOT VERIFIED", true
+		}
+		fmt.Fprintf(stdout, "%s: %s (%s, issued by %s)\n", path, outcome, result.Format, result.Issuer)
+		for _, c := range result.Checks {
+			fmt.Fprintf(stdout, "  %-8s %s", c.Status, c.Check)
+			if c.Detail != "" {
+				fmt.Fprintf(stdout, ": %s", c.Detail)
+			}
+			fmt.Fprintln(stdout)
+		}
+	}
+	if failed {
+		return errCLIFailed
+	}
+	return nil
+}
+
+func cliQR(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("qr", flag.ContinueOnError)
+	var out cliOutput
+	out.flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("want one or more credential files")
+	}
+	if err := out.check(); err != nil {
+		return err
+	}
+	names := map[string]bool{}
+	for _, path := range fs.Args() {
+		sc, err := readCredentialFile(path)
+		if err != nil {
+			return err
+		}
+		subject, err := credentialSubjectOf(&StoredCredential{Format: sc.Format, Credential: sc.Credential})
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		issuer, _ := credentialValidity(sc)
+		sess := &Session{
+			Form:             formFromSubject(subject),
+			Format:           sc.Format,
+			IssuerDID:        issuer,
+			QRMode:           out.qrMode,
+			QROptions:        defaultQROptions(),
+			SignedCredential: sc.Credential,
+			CredentialID:     sc.CredentialID,
+			CreatedAt:        time.Now(),
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if names[name] {
+			return fmt.Errorf("%s: another file is also named %s", path, name)
+		}
+		names[name] = true
+		if err := out.write(sess, name); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		written := name + ".png"
+		if out.pdf {
+			written += ", " + name + ".pdf"
+		}
+		fmt.Fprintf(stdout, "%s: wrote %s\n", path, written)
+	}
+	return nil
+}
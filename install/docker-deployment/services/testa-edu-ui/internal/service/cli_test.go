@@ -0,0 +1,58 @@
+package service
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestRunCLIUsage checks an unknown or missing command prints the usage
+// without starting the service.
+func TestRunCLIUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"help"}, {"frobnicate", "a.json"}} {
+		if status := RunCLI(args); status != 2 {
+			t.Errorf("%v: exit status %d, want 2", args, status)
+		}
+	}
+}
+
+// TestCLI issues from a CSV file, with one bad row, then verifies what was
+// written and renders its QR code again in another mode.
+func TestCLI(t *testing.T) {
+	startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	dir := t.TempDir()
+	students := filepath.Join(dir, "students.csv")
+	os.WriteFile(students, []byte("studentName,institution,degree,graduationDate,studentId\n"+
+		"Jane Wanjiku,Testa University,Bachelor of Science,2025-07-15,TU/2021/0042\n"+
+		"Otieno Baraka,Testa University,,2025-07-15,TU/2021/0043\n"), 0o644)
+
+	var out bytes.Buffer
+	err := cliIssue([]string{"-qr-mode", QRModeCompact, "-pdf", "-out", dir, students}, &out)
+	if err != errCLIFailed || !strings.Contains(out.String(), "TU-2021-0042\tissued\tdid:") || !strings.Contains(out.String(), "TU-2021-0043\tfailed") {
+		t.Fatalf("issue: %v\n%s", err, out.String())
+	}
+	for _, name := range []string{"TU-2021-0042.json", "TU-2021-0042.png", "TU-2021-0042.pdf"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	credential := filepath.Join(dir, "TU-2021-0042.json")
+	out.Reset()
+	if err := cliVerify([]string{credential}, &out); err != nil || !strings.Contains(out.String(), ": verified") {
+		t.Errorf("verify: %v\n%s", err, out.String())
+	}
+
+	out.Reset()
+	qrDir := filepath.Join(dir, "qr")
+	if err := cliQR([]string{"-qr-mode", QRModeCBOR, "-out", qrDir, credential}, &out); err != nil {
+		t.Fatalf("qr: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(qrDir, "TU-2021-0042.png")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"archive/zip"
+	"encoding/base64"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cohort printing. Staff print a whole cohort's certificates at once from
+// the stored credentials: those of their institution that are active and
+// match the degree, field of study, graduation year and issue dates given
+// as query parameters. /api/staff/cohorts/certificates.zip packages each
+// student's certificate PDF, rendered like the student's own download,
+// and wallet QR PNG, with a manifest.csv listing who is in the cohort and
+// any certificate that failed. /api/staff/cohorts/certificates.pdf merges
+// the cohort into one document for the print room, one page per student
+// in the built-in layout, made PDF/A and signed as a whole.
+
+const maxCohortSize = 500
+
+// CohortFilter selects a cohort. Empty fields match everything.
+type CohortFilter struct {
+	Tenant         string
+	Degree         string
+	FieldOfStudy   string
+	GraduationYear string
+	IssuedFrom     time.Time
+	IssuedTo       time.Time // exclusive
+}
+
+func (f CohortFilter) matches(cred *StoredCredential, form CredentialForm) bool {
+	switch {
+	case f.Tenant != "" && cred.TenantID != f.Tenant,
+		f.Degree != "" && !strings.EqualFold(form.Degree, f.Degree),
+		f.FieldOfStudy != "" && !strings.EqualFold(form.FieldOfStudy, f.FieldOfStudy),
+		f.GraduationYear != "" && !strings.HasPrefix(form.GraduationDate, f.GraduationYear),
+		!f.IssuedFrom.IsZero() && cred.IssuedAt.Before(f.IssuedFrom),
+		!f.IssuedTo.IsZero() && !cred.IssuedAt.Before(f.IssuedTo):
+		return false
+	}
+	return true
+}
+
+// parseCohortFilter reads a cohort from query parameters. Dates are
+// YYYY-MM-DD; to is inclusive.
+func parseCohortFilter(q url.Values) (CohortFilter, error) {
+	f := CohortFilter{
+		Tenant:         q.Get("tenant"),
+		Degree:         strings.TrimSpace(q.Get("degree")),
+		FieldOfStudy:   strings.TrimSpace(q.Get("fieldOfStudy")),
+		GraduationYear: strings.TrimSpace(q.Get("graduationYear")),
+	}
+	if v := q.Get("from"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, fmt.Errorf("invalid from date %q", v)
+		}
+		f.IssuedFrom = t
+	}
+	if v := q.Get("to"); v != "" {
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return f, fmt.Errorf("invalid to date %q", v)
+		}
+		f.IssuedTo = t.AddDate(0, 0, 1)
+	}
+	if f.Degree == "" && f.FieldOfStudy == "" && f.GraduationYear == "" && f.IssuedFrom.IsZero() && f.IssuedTo.IsZero() {
+		return f, fmt.Errorf("name the cohort by degree, fieldOfStudy, graduationYear, from or to")
+	}
+	return f, nil
+}
+
+// cohortSessions rebuilds the sessions of a cohort's active credentials,
+// ordered by student name.
+func cohortSessions(f CohortFilter) ([]*Session, error) {
+	var out []*Session
+	for _, cred := range store.ForTenant(f.Tenant) {
+		if cred.Encrypted || cred.Status != "" {
+			continue
+		}
+		subject, err := credentialSubjectOf(cred)
+		if err != nil || !f.matches(cred, formFromSubject(subject)) {
+			continue
+		}
+		if len(out) == maxCohortSize {
+			return nil, fmt.Errorf("the cohort has more than %d credentials; narrow it down", maxCohortSize)
+		}
+		sess, err := storedSession(cred)
+		if err != nil {
+			slog.Error("cohort", "credential", cred.ID, "err", err)
+			continue
+		}
+		out = append(out, sess)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return strings.ToLower(out[i].Form.StudentName) < strings.ToLower(out[j].Form.StudentName)
+	})
+	return out, nil
+}
+
+// cohortFileName is a student's file name stem in the archive.
+func cohortFileName(n int, sess *Session) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == ' ' || r == '-' || r == '_' || r == '.':
+			return '-'
+		}
+		return -1
+	}, sess.Form.StudentName)
+	if name == "" {
+		name = sess.CredentialID
+	}
+	return fmt.Sprintf("%03d-%s", n, name)
+}
+
+// writeCohortZip streams a cohort's certificates, QR codes and manifest.
+// A certificate render fails alone: it is left out and noted in the
+// manifest.
+func writeCohortZip(w io.Writer, sessions []*Session, render func(*Session) ([]byte, error)) error {
+	zw := zip.NewWriter(w)
+	var manifest strings.Builder
+	cw := csv.NewWriter(&manifest)
+	cw.Write([]string{"number", "credentialId", "studentName", "studentId", "certificate", "qr", "error"})
+	for i, sess := range sessions {
+		stem := cohortFileName(i+1, sess)
+		row := []string{fmt.Sprint(i + 1), sess.CredentialID, sess.Form.StudentName, sess.Form.StudentID, "", "", ""}
+		if pdf, err := render(sess); err != nil {
+			slog.Error("cohort certificate", "credential", sess.CredentialID, "err", err)
+			row[6] = "certificate could not be rendered"
+		} else if err := writeStored(zw, stem+".pdf", pdf, sess.CreatedAt); err != nil {
+			return err
+		} else {
+			row[4] = stem + ".pdf"
+		}
+		if sess.QR != nil {
+			if png, err := base64.StdEncoding.DecodeString(sess.QR.QRPngBase64); err == nil && len(png) > 0 {
+				if err := writeStored(zw, stem+"-qr.png", png, sess.CreatedAt); err != nil {
+					return err
+				}
+				row[5] = stem + "-qr.png"
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	f, err := zw.Create("manifest.csv")
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(f, manifest.String()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// writeStored adds an already compressed file to an archive.
+func writeStored(zw *zip.Writer, name string, data []byte, modified time.Time) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// cohortPDF merges a cohort's certificates into one document.
+func cohortPDF(sessions []*Session, tenantID, lang string) ([]byte, error) {
+	archival := pdfArchival(tenantID)
+	pdf, err := builtinCertificates(sessions, lang, archival)
+	if err != nil {
+		return nil, err
+	}
+	title := translator(lang)(themeFor(tenantID).CertificateTitle)
+	return finishPDF(pdf, tenantID, pdfaMeta{Title: title, Created: time.Now()}, archival)
+}
+
+// staffCohort reads the cohort of a staff request, limited to the staff
+// member's institution.
+func staffCohort(w http.ResponseWriter, r *http.Request) (CohortFilter, []*Session, bool) {
+	f, err := parseCohortFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return f, nil, false
+	}
+	if tenant := staffTenant(r); tenant != "" {
+		f.Tenant = tenant
+	}
+	sessions, err := cohortSessions(f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return f, nil, false
+	}
+	if len(sessions) == 0 {
+		http.Error(w, "No active credentials match this cohort", http.StatusNotFound)
+		return f, nil, false
+	}
+	return f, sessions, true
+}
+
+func handleCohortZip(w http.ResponseWriter, r *http.Request) {
+	_, sessions, ok := staffCohort(w, r)
+	if !ok {
+		return
+	}
+	lang := requestLanguage(r)
+	slog.InfoContext(r.Context(), "cohort: packaging certificates", "count", len(sessions))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-cohort.zip\"")
+	w.Header().Set("Cache-Control", "no-store")
+	render := func(sess *Session) ([]byte, error) { return generatePDF(sess, lang) }
+	if err := writeCohortZip(w, sessions, render); err != nil {
+		// Headers are sent; the client sees a truncated archive.
+		slog.ErrorContext(r.Context(), "cohort archive error", "err", err)
+	}
+}
+
+func handleCohortPDF(w http.ResponseWriter, r *http.Request) {
+	f, sessions, ok := staffCohort(w, r)
+	if !ok {
+		return
+	}
+	pdf, err := cohortPDF(sessions, f.Tenant, requestLanguage(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "cohort PDF error", "err", err)
+		http.Error(w, "Failed to generate the cohort PDF", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-cohort.pdf\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pdf)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// useCohortStore gives one test fresh credential and tenant stores holding
+// creds, and the message catalogs.
+func useCohortStore(t *testing.T, creds ...*StoredCredential) {
+	t.Helper()
+	savedStore, savedTenants := store, tenants
+	t.Cleanup(func() { store, tenants = savedStore, savedTenants })
+	useCatalogs(t)
+	var err error
+	if store, err = NewCredentialStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if tenants, err = NewTenantStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range creds {
+		if err := store.Put(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func cohortCredential(id, name, degree, graduated, status string) *StoredCredential {
+	vc, _ := json.Marshal(map[string]interface{}{
+		"credentialSubject": map[string]interface{}{
+			"name":           name,
+			"alumniOf":       "Testa University",
+			"degree":         degree,
+			"graduationDate": graduated,
+			"studentId":      "S-" + id,
+		},
+	})
+	return &StoredCredential{ID: id, Format: FormatLDP, Credential: vc, Status: status, IssuedAt: time.Date(2025, 7, 1, 9, 0, 0, 0, time.UTC)}
+}
+
+// TestCohort checks a cohort holds only the matching active credentials,
+// in name order, and that its archive lists every student, including
+// those whose certificate failed.
+func TestCohort(t *testing.T) {
+	useCohortStore(t,
+		cohortCredential("c1", "Zawadi Otieno", "Bachelor of Science", "2025-07-01", ""),
+		cohortCredential("c2", "Amina Njeri", "Bachelor of Science", "2025-07-01", ""),
+		cohortCredential("c3", "Baraka Mwangi", "Bachelor of Science", "2025-07-01", "revoked"),
+		cohortCredential("c4", "Chebet Kiprop", "Master of Science", "2025-07-01", ""),
+		cohortCredential("c5", "Daudi Kamau", "Bachelor of Science", "2024-07-01", ""),
+	)
+
+	if _, err := parseCohortFilter(url.Values{}); err == nil {
+		t.Error("an empty filter selects the whole store")
+	}
+	f, err := parseCohortFilter(url.Values{"degree": {"bachelor of science"}, "graduationYear": {"2025"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := cohortSessions(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range sessions {
+		names = append(names, s.Form.StudentName)
+	}
+	if len(names) != 2 || names[0] != "Amina Njeri" || names[1] != "Zawadi Otieno" {
+		t.Fatalf("cohort = %q", names)
+	}
+
+	var buf bytes.Buffer
+	render := func(sess *Session) ([]byte, error) {
+		if sess.CredentialID == "c1" {
+			return nil, errors.New("renderer down")
+		}
+		return []byte("%PDF-" + sess.CredentialID), nil
+	}
+	if err := writeCohortZip(&buf, sessions, render); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	if string(files["001-Amina-Njeri.pdf"]) != "%PDF-c2" {
+		t.Errorf("001-Amina-Njeri.pdf = %q", files["001-Amina-Njeri.pdf"])
+	}
+	if _, ok := files["002-Zawadi-Otieno.pdf"]; ok {
+		t.Error("failed certificate is in the archive")
+	}
+	rows, err := csv.NewReader(bytes.NewReader(files["manifest.csv"])).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 || rows[1][1] != "c2" || rows[1][4] != "001-Amina-Njeri.pdf" || rows[2][1] != "c1" || rows[2][6] == "" {
+		t.Errorf("manifest = %q", rows)
+	}
+
+	pdf, err := cohortPDF(sessions, "", "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(pdf, []byte("/Type /Page\n")); n != 2 {
+		t.Errorf("merged PDF has %d pages, want 2", n)
+	}
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Startup validation. loadConfig rejects settings it cannot parse;
+// validateConfig then checks the parsed settings make sense together, so
+// a misconfigured service exits at boot, listing every problem, instead of
+// failing at the first request that needs the setting.
+
+// minSecretLength is the shortest API key or token accepted.
+const minSecretLength = 16
+
+// didSyntax is the DID syntax: did:<method>:<method-specific id>.
+var didSyntax = regexp.MustCompile(`^did:[a-z0-9]+:([A-Za-z0-9._-]|%[0-9A-Fa-f]{2})*(:([A-Za-z0-9._-]|%[0-9A-Fa-f]{2})*)*([A-Za-z0-9._-]|%[0-9A-Fa-f]{2})$`)
+
+// ConfigError lists the problems validateConfig found.
+type ConfigError struct {
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// validateConfig returns the problems of c, each naming the setting and
+// what it should be.
+func validateConfig(c Config) []string {
+	var problems []string
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if !validPort(c.Port) {
+		problem("PORT %q must be a port number, 1-65535", c.Port)
+	}
+	if c.TLSAddr != "" {
+		if _, port, err := net.SplitHostPort(c.TLSAddr); err != nil || !validPort(port) {
+			problem("TLS_ADDR %q must be host:port or :port, e.g. :443", c.TLSAddr)
+		}
+	}
+	if c.ListenSocket != "" && !filepath.IsAbs(c.ListenSocket) {
+		problem("LISTEN_SOCKET %q must be an absolute path", c.ListenSocket)
+	}
+	if c.TLSAddr == "" && (c.ACME || c.TLSRedirect || c.TLSCertFile != "") {
+		problem("TLS_ACME, TLS_REDIRECT and TLS_CERT_FILE need TLS_ADDR, the HTTPS address")
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		problem("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+	if c.ACME && !validHTTPURL(c.ACMEDirectoryURL) {
+		problem("TLS_ACME_DIRECTORY_URL %q must be an http or https URL", c.ACMEDirectoryURL)
+	}
+	if c.SMTPHost != "" && !validPort(c.SMTPPort) {
+		problem("SMTP_PORT %q must be a port number, 1-65535", c.SMTPPort)
+	}
+
+	for _, u := range []struct{ name, value string }{
+		{"PUBLIC_URL", c.PublicURL},
+		{"ISSUER_LOGO_URL", c.IssuerLogoURL},
+		{"OID4VCI_CREDENTIAL_ENDPOINT", c.OID4VCICredentialEndpoint},
+		{"UNIVERSAL_RESOLVER_URL", c.UniversalResolverURL},
+		{"ORCID_URL", c.OrcidURL},
+		{"ORCID_API_URL", c.OrcidAPIURL},
+		{"POLYGON_TESTNET_RPC_URL", c.PolygonTestnetRPCURL},
+		{"POLYGON_MAINNET_RPC_URL", c.PolygonMainnetRPCURL},
+		{"TSA_URL", c.TSAURL},
+		{"SMS_API_URL", c.SMSAPIURL},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", c.OTLPEndpoint},
+	} {
+		if u.value != "" && !validHTTPURL(u.value) {
+			problem("%s %q must be an http or https URL", u.name, u.value)
+		}
+	}
+	for _, hook := range append(append([]string{}, c.SlackWebhooks...), c.TeamsWebhooks...) {
+		if !validHTTPURL(hook) {
+			problem("webhook URL %q must be an http or https URL", hook)
+		}
+	}
+	if c.SentryDSN != "" {
+		if _, _, err := parseSentryDSN(c.SentryDSN); err != nil {
+			problem("SENTRY_DSN must be a DSN, https://<key>@<host>/<project>")
+		}
+	}
+	for _, hook := range c.SignHooks {
+		if name, _, _ := strings.Cut(hook, "="); signHookFactories[name] == nil {
+			problem("SIGN_HOOKS: unknown hook %q", name)
+		}
+	}
+	if u, err := url.Parse(c.PublicURL); err == nil && (u.RawQuery != "" || u.Fragment != "") {
+		problem("PUBLIC_URL %q must not have a query or fragment", c.PublicURL)
+	}
+
+	if c.IssuerDID != "" && !didSyntax.MatchString(c.IssuerDID) {
+		problem("ISSUER_DID %q is not a DID, want did:<method>:<id>", c.IssuerDID)
+	}
+	for _, iss := range c.IssuerDIDs {
+		if !didSyntax.MatchString(iss.DID) {
+			problem("ISSUER_DIDS: %s's %q is not a DID, want did:<method>:<id>", iss.Name, iss.DID)
+		}
+	}
+	if c.AnonCredsCredDefID != "" && c.AnonCredsIssuerID == "" {
+		problem("ANONCREDS_CRED_DEF_ID needs ANONCREDS_ISSUER_ID")
+	}
+
+	// The agent's API key is required unless the agent takes OAuth tokens
+	// or is the demo agent.
+	if c.AgentTokenURL == "" && !c.DemoMode && len(c.APIKey) < minSecretLength {
+		problem("API_KEY must be at least %d characters", minSecretLength)
+	}
+	if c.StaffAPIToken != "" && len(c.StaffAPIToken) < minSecretLength {
+		problem("STAFF_API_TOKEN must be at least %d characters", minSecretLength)
+	}
+	if c.DebugEndpoints && c.StaffAPIToken == "" {
+		problem("DEBUG_ENDPOINTS needs STAFF_API_TOKEN, which guards them")
+	}
+	if c.MakerChecker && c.StaffAPIToken == "" {
+		problem("MAKER_CHECKER needs STAFF_API_TOKEN, the operator's maker and approver token")
+	}
+	if c.MetricsToken != "" && len(c.MetricsToken) < minSecretLength {
+		problem("METRICS_TOKEN must be at least %d characters", minSecretLength)
+	}
+	if c.LinkSigningKey != "" && len(c.LinkSigningKey) < 32 {
+		problem("LINK_SIGNING_KEY must be at least 32 characters; leave it unset to generate one")
+	}
+
+	if c.SMTPUsername != "" && c.SMTPPassword == "" {
+		problem("SMTP_USERNAME needs SMTP_PASSWORD")
+	}
+	if (c.PDFSigningCert == "") != (c.PDFSigningKey == "") {
+		problem("PDF_SIGNING_CERT and PDF_SIGNING_KEY must be set together")
+	}
+	if (c.MdocSignerKey == "") != (c.MdocSignerCert == "") {
+		problem("MDOC_SIGNER_KEY and MDOC_SIGNER_CERT must be set together")
+	}
+	if c.PassTypeID != "" && (c.PassTeamID == "" || c.PassCert == "" || c.PassKey == "" || c.PassWWDRCert == "") {
+		problem("PASS_TYPE_ID needs PASS_TEAM_ID, PASS_CERT, PASS_KEY and PASS_WWDR_CERT")
+	}
+	if c.GoogleWalletIssuerID != "" && c.GoogleWalletServiceAccount == "" {
+		problem("GOOGLE_WALLET_ISSUER_ID needs GOOGLE_WALLET_SERVICE_ACCOUNT")
+	}
+	switch c.SMSProvider {
+	case "":
+	case "twilio":
+		if c.TwilioAccountSID == "" || c.TwilioAuthToken == "" || c.TwilioFrom == "" {
+			problem("SMS_PROVIDER=twilio needs TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM")
+		}
+	case "africastalking":
+		if c.ATUsername == "" || c.ATAPIKey == "" {
+			problem("SMS_PROVIDER=africastalking needs AT_USERNAME and AT_API_KEY")
+		}
+	default:
+		problem("SMS_PROVIDER %q must be twilio or africastalking", c.SMSProvider)
+	}
+	if c.SecureBoot {
+		problems = append(problems, secureBootProblems(c)...)
+	}
+	return problems
+}
+
+func validPort(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 1 && n <= 65535
+}
+
+func validHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	good := Config{
+		Port:       "3002",
+		PublicURL:  "https://credentials.example.ac.ke",
+		APIKey:     "supersecret-that-too-16chars",
+		IssuerDID:  "did:web:example.ac.ke%3A8443:issuers:registry",
+		IssuerDIDs: []IssuerOption{{Name: "Faculty", DID: "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"}},
+		TLSAddr:    ":443",
+	}
+	if problems := validateConfig(good); len(problems) != 0 {
+		t.Errorf("good config: %v", problems)
+	}
+
+	bad := good
+	bad.Port = "70000"
+	bad.TLSAddr = "443"
+	bad.PublicURL = "credentials.example.ac.ke"
+	bad.IssuerDID = "did:web:"
+	bad.APIKey = "short"
+	bad.LinkSigningKey = "tooshort"
+	bad.PDFSigningCert = "cert.pem"
+	bad.SMSProvider = "twilio"
+	problems := validateConfig(bad)
+	for _, want := range []string{"PORT", "TLS_ADDR", "PUBLIC_URL", "ISSUER_DID", "API_KEY", "LINK_SIGNING_KEY", "PDF_SIGNING_CERT", "SMS_PROVIDER=twilio"} {
+		found := false
+		for _, p := range problems {
+			found = found || strings.HasPrefix(p, want+" ")
+		}
+		if !found {
+			t.Errorf("no %s problem in %v", want, problems)
+		}
+	}
+
+	// The demo agent needs no API key.
+	demo := good
+	demo.APIKey, demo.DemoMode = "", true
+	if problems := validateConfig(demo); len(problems) != 0 {
+		t.Errorf("demo config: %v", problems)
+	}
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Configuration file and flags. Every setting is an environment variable
+// (loadConfig), and a TOML file, named by CONFIG_FILE or the -config flag,
+// can give them all in one place, grouped by subsystem:
+//
+//	port = 3002
+//	data_dir = "/var/lib/testa"
+//
+//	[agent]
+//	url = ["http://agent-1:8004", "http://agent-2:8004"]
+//	max_concurrent = 8
+//	queue_timeout = "30s"
+//
+// A key's variable is its table and name, upper-cased and joined with
+// underscores ([agent] max_concurrent is AGENT_MAX_CONCURRENT); arrays
+// become comma-separated lists. Command-line flags name a setting the same
+// way, as a variable or a dotted key (-agent.url=... or -AGENT_URL=...).
+// A flag overrides the environment, which overrides the file, which
+// overrides the defaults. Flags and the file's settings are kept here and
+// read into the Config, not put in the environment, so secrets given
+// either way do not reach the programs the service runs. Keys that name
+// no setting are logged, as they are usually typos. templates-data/config.example.toml lists the
+// settings by subsystem.
+//
+// Only the TOML the settings need is read: tables, bare and dotted keys,
+// strings, numbers, booleans and one-line arrays of them, and comments.
+
+var (
+	configReadMu sync.Mutex
+	// configRead is the settings loadConfig has looked up.
+	configRead = map[string]bool{}
+	// configFlags is the settings the command line gave.
+	configFlags = map[string]string{}
+	// configFromFile is the settings of the config file last read.
+	configFromFile = map[string]string{}
+)
+
+// configReadLater are settings read when they are used, not by loadConfig.
+var configReadLater = []string{"AWS_REGION", "AWS_DEFAULT_REGION", "AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_ENDPOINT_URL", "GOOGLE_APPLICATION_CREDENTIALS"}
+
+// getenv reads a setting, noting that it is one. Secrets given as files
+// or Vault references read as their values; see secrets.go.
+func getenv(key string) string {
+	v := rawenv(key)
+	if secret, ok := secretValue(key); ok {
+		return secret
+	}
+	return v
+}
+
+// rawenv reads a setting as it is given.
+func rawenv(key string) string {
+	configReadMu.Lock()
+	configRead[key] = true
+	configReadMu.Unlock()
+	return lookupSetting(key)
+}
+
+// lookupSetting reads a setting from the command line, the environment or
+// the config file, in that order.
+func lookupSetting(key string) string {
+	configReadMu.Lock()
+	flag, isFlag := configFlags[key]
+	file := configFromFile[key]
+	configReadMu.Unlock()
+	if isFlag {
+		return flag
+	}
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return file
+}
+
+// applyConfigFile reads the settings of the file at path, replacing those
+// of the file read before, and returns them.
+func applyConfigFile(path string) (map[string]string, error) {
+	if path == "" {
+		configReadMu.Lock()
+		configFromFile = map[string]string{}
+		configReadMu.Unlock()
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	settings, err := parseConfigFile(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	configReadMu.Lock()
+	configFromFile = settings
+	configReadMu.Unlock()
+	return settings, nil
+}
+
+// warnUnreadSettings logs the settings of a config file that loadConfig
+// did not look up.
+func warnUnreadSettings(path string, settings map[string]string) {
+	configReadMu.Lock()
+	defer configReadMu.Unlock()
+	var unknown []string
+	for name := range settings {
+		if !configRead[name] && !slices.Contains(configReadLater, name) {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		slog.Warn("config file: not a setting", "file", path, "setting", name)
+	}
+}
+
+// configName is the variable of a dotted or dashed key, e.g. agent.url or
+// agent-url for AGENT_URL.
+func configName(key string) string {
+	return strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+}
+
+// parseConfigFile reads the settings of a TOML file.
+func parseConfigFile(data []byte) (map[string]string, error) {
+	settings := map[string]string{}
+	table := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripTOMLComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header", n)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			if table == "" {
+				return nil, fmt.Errorf("line %d: empty table name", n)
+			}
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("line %d: want key = value", n)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", n, key, err)
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		name := configName(strings.Trim(key, `"`))
+		if _, dup := settings[name]; dup {
+			return nil, fmt.Errorf("line %d: %s is set twice", n, key)
+		}
+		settings[name] = value
+	}
+	return settings, sc.Err()
+}
+
+// stripTOMLComment drops a comment from a line, leaving # in strings.
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue reads a value as its variable's text.
+func parseTOMLValue(raw string) (string, error) {
+	if strings.HasPrefix(raw, "[") {
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		for rest := strings.TrimSpace(raw[1 : len(raw)-1]); rest != ""; {
+			item, tail, err := nextTOMLItem(rest)
+			if err != nil {
+				return "", err
+			}
+			v, err := parseTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, v)
+			rest = tail
+		}
+		return strings.Join(items, ","), nil
+	}
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, `"`):
+		s, err := strconv.Unquote(raw)
+		if err != nil {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return s, nil
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") || strings.Contains(raw[1:len(raw)-1], "'") {
+			return "", fmt.Errorf("invalid string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(raw, "_", ""), 64); err != nil {
+		return "", fmt.Errorf("invalid value %s (quote strings)", raw)
+	}
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// nextTOMLItem splits the first item off an array's contents.
+func nextTOMLItem(s string) (item, rest string, err error) {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0 && c == '\\' && quote == '"':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '[':
+			return "", "", fmt.Errorf("nested arrays are not supported")
+		case quote == 0 && c == ',':
+			return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+		}
+	}
+	if quote != 0 {
+		return "", "", fmt.Errorf("unterminated string")
+	}
+	return strings.TrimSpace(s), "", nil
+}
+
+// applyConfigFlags keeps the settings the command line gives, and
+// CONFIG_FILE from -config.
+func applyConfigFlags(args []string) error {
+	path, overrides, err := parseConfigFlags(args)
+	if err != nil {
+		return err
+	}
+	if path != "" {
+		overrides["CONFIG_FILE"] = path
+	}
+	configReadMu.Lock()
+	configFlags = overrides
+	configReadMu.Unlock()
+	return nil
+}
+
+// parseConfigFlags reads -config and the settings of the command line:
+// -name=value or -name value, with one dash or two.
+func parseConfigFlags(args []string) (string, map[string]string, error) {
+	path := ""
+	overrides := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") || arg == "-" || arg == "--" {
+			return "", nil, fmt.Errorf("unexpected argument %q; settings are given as -name=value", arg)
+		}
+		key, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if key == "h" || key == "help" {
+			return "", nil, fmt.Errorf("usage: testa-edu-ui [-config file.toml] [-setting=value ...]")
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				return "", nil, fmt.Errorf("-%s needs a value", key)
+			}
+			i++
+			value = args[i]
+		}
+		if key == "config" {
+			path = value
+			continue
+		}
+		overrides[configName(key)] = value
+	}
+	return path, overrides, nil
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	settings, err := parseConfigFile([]byte(`
+# the service
+port = 3003
+public_url = "https://example.ac.ke/#top" # a comment after a value
+
+[agent]
+url = ["http://a:8004", 'http://b:8004']
+max_concurrent = 1_000
+tls.insecure = false
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"PORT":                 "3003",
+		"PUBLIC_URL":           "https://example.ac.ke/#top",
+		"AGENT_URL":            "http://a:8004,http://b:8004",
+		"AGENT_MAX_CONCURRENT": "1000",
+		"AGENT_TLS_INSECURE":   "false",
+	}
+	if len(settings) != len(want) {
+		t.Errorf("settings %v", settings)
+	}
+	for k, v := range want {
+		if settings[k] != v {
+			t.Errorf("%s = %q, want %q", k, settings[k], v)
+		}
+	}
+
+	for _, bad := range []string{"port 3003", "port = fast", "[agent", "url = [\"a\"", "a = 1\na = 2", "url = [[\"a\"]]", "name = \"open"} {
+		if _, err := parseConfigFile([]byte(bad)); err == nil {
+			t.Errorf("%q parsed", bad)
+		}
+	}
+}
+
+// TestConfigFile checks the example file only has settings, and that the
+// environment overrides a file.
+func TestConfigFile(t *testing.T) {
+	path := filepath.Join("templates-data", "config.example.toml")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	settings, err := parseConfigFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Clear the file's variables for the test, restoring them after.
+	for name := range settings {
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	saved := config
+	t.Cleanup(func() { config, configFromFile = saved, map[string]string{} })
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "4000")
+	t.Setenv("API_KEY", "agent-key-0123456789")
+	os.Unsetenv("AGENT_URL")
+
+	config = loadConfig()
+	if config.Port != "4000" || config.AgentURL != "http://host.docker.internal:8004" || config.AgentMaxConcurrent != 8 || config.IssuerName != "Testa Edu" {
+		t.Errorf("config %s %s %d %s", config.Port, config.AgentURL, config.AgentMaxConcurrent, config.IssuerName)
+	}
+	if _, set := os.LookupEnv("ISSUER_NAME"); set {
+		t.Error("the file's settings reached the environment")
+	}
+	for name := range settings {
+		if !configRead[name] {
+			t.Errorf("%s in %s is not a setting", name, path)
+		}
+	}
+
+	// The settings commented out are settings too, where they stand.
+	uncommented, err := parseConfigFile(commentedSetting.ReplaceAll(data, []byte("$1")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range uncommented {
+		if !configRead[name] {
+			t.Errorf("commented %s in %s is not a setting", name, path)
+		}
+	}
+}
+
+// commentedSetting is a setting commented out in the example file.
+var commentedSetting = regexp.MustCompile(`(?m)^# ([a-z_]+ = )`)
+
+func TestParseConfigFlags(t *testing.T) {
+	path, overrides, err := parseConfigFlags([]string{"-config", "/etc/testa.toml", "--agent.url=http://a:8004", "-PORT", "3003", "-demo-mode=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/etc/testa.toml" || overrides["AGENT_URL"] != "http://a:8004" || overrides["PORT"] != "3003" || overrides["DEMO_MODE"] != "true" {
+		t.Errorf("%s %v", path, overrides)
+	}
+	saved := configFlags
+	t.Cleanup(func() { configFlags = saved })
+	t.Setenv("API_KEY", "")
+	os.Unsetenv("API_KEY")
+	if err := applyConfigFlags([]string{"-api-key=flag-key-0123456789"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, set := os.LookupEnv("API_KEY"); set || rawenv("API_KEY") != "flag-key-0123456789" {
+		t.Errorf("API_KEY from a flag reads %q; in the environment %v", rawenv("API_KEY"), set)
+	}
+	for _, bad := range [][]string{{"serve"}, {"-port"}} {
+		if _, _, err := parseConfigFlags(bad); err == nil {
+			t.Errorf("%v parsed", bad)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Consent is recorded before issuance: who gave it, when, and for which
+// scopes. "issue" is required; "store" allows keeping the credential for
+// retrieval links, and "share" allows share links. Records are kept in
+// DATA_DIR/consents.json and referenced from stored credentials. With
+// CONSENT_IN_CREDENTIAL set, the record is also emitted in the credential
+// as evidence or termsOfUse, using Data Privacy Vocabulary terms.
+
+const (
+	ConsentScopeIssue = "issue"
+	ConsentScopeStore = "store"
+	ConsentScopeShare = "share"
+)
+
+var consentScopes = []string{ConsentScopeIssue, ConsentScopeStore, ConsentScopeShare}
+
+type Consent struct {
+	ID           string    `json:"id"`
+	SubjectDID   string    `json:"subjectDid"`
+	GivenBy      string    `json:"givenBy"`
+	Role         string    `json:"role"` // student or guardian
+	Scopes       []string  `json:"scopes"`
+	TermsURL     string    `json:"termsUrl,omitempty"`
+	RecordedAt   time.Time `json:"recordedAt"`
+	CredentialID string    `json:"credentialId,omitempty"`
+}
+
+func (c *Consent) Allows(scope string) bool {
+	return c != nil && slices.Contains(c.Scopes, scope)
+}
+
+type ConsentStore struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*Consent
+}
+
+var consents *ConsentStore
+
+func NewConsentStore(dataDir string) (*ConsentStore, error) {
+	s := &ConsentStore{
+		path:  filepath.Join(dataDir, "consents.json"),
+		items: make(map[string]*Consent),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing consents: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading consents: %w", err)
+	}
+	return s, nil
+}
+
+func (s *ConsentStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing consents: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Put records a consent, assigning its ID.
+func (s *ConsentStore) Put(c *Consent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ID == "" {
+		c.ID = newUUID()
+	}
+	s.items[c.ID] = c
+	return s.saveLocked()
+}
+
+func (s *ConsentStore) Get(id string) (*Consent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[id]
+	return c, ok
+}
+
+// LinkCredential records which stored credential a consent covers.
+func (s *ConsentStore) LinkCredential(consentID, credentialID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[consentID]
+	if !ok {
+		return nil
+	}
+	c.CredentialID = credentialID
+	return s.saveLocked()
+}
+
+// EraseSubject deletes the consent records of subjectID, or with anonymize
+// keeps their scopes and dates with the identifying fields cleared.
+func (s *ConsentStore) EraseSubject(subjectID string, anonymize bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, c := range s.items {
+		if c.SubjectDID != subjectID {
+			continue
+		}
+		n++
+		if anonymize {
+			c.SubjectDID, c.GivenBy, c.CredentialID = "", "", ""
+		} else {
+			delete(s.items, id)
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// Expire anonymizes consent records made before cutoff.
+func (s *ConsentStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, c := range s.items {
+		if c.SubjectDID == "" || !c.RecordedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			c.SubjectDID, c.GivenBy, c.CredentialID = "", "", ""
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+// parseConsent reads the consent section of the issuance form.
+func parseConsent(r *http.Request, form CredentialForm) (*Consent, error) {
+	if r.FormValue("consent_issue") == "" {
+		return nil, fmt.Errorf("the student's consent to issuance is required")
+	}
+	c := &Consent{
+		SubjectDID: form.SubjectDID,
+		GivenBy:    strings.TrimSpace(r.FormValue("consentGivenBy")),
+		Role:       r.FormValue("consentRole"),
+		TermsURL:   liveConfig().ConsentTermsURL,
+		RecordedAt: time.Now().UTC(),
+	}
+	if c.GivenBy == "" {
+		c.GivenBy = form.StudentName
+	}
+	if c.Role == "" {
+		c.Role = "student"
+	}
+	if c.Role != "student" && c.Role != "guardian" {
+		return nil, fmt.Errorf("consent must be given by the student or a guardian")
+	}
+	for _, scope := range consentScopes {
+		if r.FormValue("consent_"+scope) != "" {
+			c.Scopes = append(c.Scopes, scope)
+		}
+	}
+	return c, nil
+}
+
+// addConsentToCredential emits the consent record in the credential, as
+// configured by CONSENT_IN_CREDENTIAL. It leaves out who gave consent, which
+// would put the name back into PII-minimized credentials.
+func addConsentToCredential(payload map[string]interface{}, c *Consent) {
+	if c == nil || config.ConsentInCredential == "" {
+		return
+	}
+	credential, _ := payload["credential"].(map[string]interface{})
+	if credential == nil {
+		return
+	}
+	record := map[string]interface{}{
+		"consentScope":   c.Scopes,
+		"consentGivenAt": c.RecordedAt.Format(time.RFC3339),
+		"consentRole":    c.Role,
+	}
+	if c.TermsURL != "" {
+		record["id"] = c.TermsURL
+	}
+	switch config.ConsentInCredential {
+	case "evidence":
+		record["type"] = []string{"ConsentRecord"}
+		credential["evidence"] = []interface{}{record}
+	case "termsOfUse":
+		record["type"] = "ConsentPolicy"
+		credential["termsOfUse"] = []interface{}{record}
+	}
+
+	ctx, _ := credential["@context"].([]interface{})
+	credential["@context"] = append(ctx, map[string]string{
+		"ConsentRecord":  "https://w3id.org/dpv#Consent",
+		"ConsentPolicy":  "https://w3id.org/dpv#ConsentNotice",
+		"consentScope":   "https://w3id.org/dpv#hasPurpose",
+		"consentGivenAt": "https://w3id.org/dpv#hasProvisionTime",
+		"consentRole":    "https://w3id.org/dpv#hasDataSubject",
+	})
+}
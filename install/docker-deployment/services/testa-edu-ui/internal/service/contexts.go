@@ -0,0 +1,211 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ContextCache keeps local, integrity-checked copies of the JSON-LD contexts
+// used by issued credentials so that neither issuance nor verification
+// depends on w3.org or schema.org being reachable.
+//
+// Pins map a context URL to an SRI-style integrity string ("sha256-<b64>"),
+// and usually to a copy of the document vendored next to the pins file:
+//
+//	{"https://www.w3.org/2018/credentials/v1":
+//	  {"file": "contexts/credentials-v1.jsonld", "integrity": "sha256-..."}}
+//
+// A bare integrity string pins a context that is fetched on first use and
+// cached in CONTEXT_CACHE_DIR. Every pin needs an integrity, and a document
+// that does not match it is refused: there is no trust on first use.
+type ContextCache struct {
+	dir    string
+	pins   map[string]contextPin // read-only after NewContextCache
+	client *http.Client
+
+	mu   sync.RWMutex
+	docs map[string][]byte
+}
+
+type contextPin struct {
+	File      string `json:"file,omitempty"`
+	Integrity string `json:"integrity"`
+}
+
+func (p *contextPin) UnmarshalJSON(data []byte) error {
+	if json.Unmarshal(data, &p.Integrity) == nil {
+		return nil
+	}
+	type plain contextPin
+	return json.Unmarshal(data, (*plain)(p))
+}
+
+var contexts *ContextCache
+
+func NewContextCache(dir, pinsFile string) (*ContextCache, error) {
+	c := &ContextCache{
+		dir:    dir,
+		pins:   make(map[string]contextPin),
+		client: &http.Client{Timeout: 15 * time.Second},
+		docs:   make(map[string][]byte),
+	}
+
+	if pinsFile != "" {
+		data, err := os.ReadFile(pinsFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading context pins: %w", err)
+		}
+		if err := json.Unmarshal(data, &c.pins); err != nil {
+			return nil, fmt.Errorf("parsing context pins: %w", err)
+		}
+	}
+	for u, pin := range c.pins {
+		if !strings.HasPrefix(pin.Integrity, "sha256-") {
+			return nil, fmt.Errorf("context %s has no sha256 integrity pin", u)
+		}
+		if pin.File != "" {
+			pin.File = filepath.Join(filepath.Dir(pinsFile), pin.File)
+			c.pins[u] = pin
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating context cache dir: %w", err)
+	}
+	return c, nil
+}
+
+// Prefetch loads every pinned context, from disk when present and from the
+// network otherwise. Failures are logged but not fatal so that the service
+// can still start while offline with a partially populated cache.
+func (c *ContextCache) Prefetch() {
+	c.mu.RLock()
+	urls := make([]string, 0, len(c.pins))
+	for u := range c.pins {
+		urls = append(urls, u)
+	}
+	c.mu.RUnlock()
+	for _, u := range urls {
+		if _, err := c.Load(u); err != nil {
+			slog.Warn("context prefetch", "url", u, "err", err)
+		}
+	}
+}
+
+// Load returns the document for a pinned context URL: the vendored copy,
+// the cached copy, or a fresh fetch, whichever first matches the pin.
+// Unpinned URLs are rejected so the cache cannot be used as an open proxy.
+func (c *ContextCache) Load(rawURL string) ([]byte, error) {
+	c.mu.RLock()
+	pin, pinned := c.pins[rawURL]
+	doc, cached := c.docs[rawURL]
+	c.mu.RUnlock()
+
+	if !pinned {
+		return nil, fmt.Errorf("context %s is not pinned", rawURL)
+	}
+	if cached {
+		return doc, nil
+	}
+
+	var err error
+	if pin.File != "" {
+		if doc, err = os.ReadFile(pin.File); err != nil {
+			return nil, fmt.Errorf("reading vendored context %s: %w", rawURL, err)
+		}
+		if err := checkContext(rawURL, doc, pin.Integrity); err != nil {
+			return nil, err
+		}
+	} else if doc, err = os.ReadFile(c.path(rawURL)); err != nil || checkContext(rawURL, doc, pin.Integrity) != nil {
+		if doc, err = c.fetch(rawURL); err != nil {
+			return nil, err
+		}
+		if err := checkContext(rawURL, doc, pin.Integrity); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(c.path(rawURL), doc, 0o644); err != nil {
+			slog.Error("context cache write", "url", rawURL, "err", err)
+		}
+	}
+
+	c.mu.Lock()
+	c.docs[rawURL] = doc
+	c.mu.Unlock()
+	return doc, nil
+}
+
+func checkContext(rawURL string, doc []byte, integrity string) error {
+	if sum := contextIntegrity(doc); sum != integrity {
+		return fmt.Errorf("context %s failed integrity check: got %s, want %s", rawURL, sum, integrity)
+	}
+	return nil
+}
+
+func (c *ContextCache) fetch(rawURL string) ([]byte, error) {
+	req, err := http.NewRequest("GET", rawURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Accept", "application/ld+json, application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching context %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching context %s: HTTP %d", rawURL, resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, fmt.Errorf("reading context %s: %w", rawURL, err)
+	}
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("context %s is not valid JSON", rawURL)
+	}
+	return body, nil
+}
+
+func (c *ContextCache) path(rawURL string) string {
+	return filepath.Join(c.dir, url.PathEscape(rawURL)+".jsonld")
+}
+
+func contextIntegrity(doc []byte) string {
+	sum := sha256.Sum256(doc)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// handleContext serves a cached context document, for agents configured to
+// resolve JSON-LD contexts through this service instead of the internet.
+// GET /contexts?url=https://www.w3.org/2018/credentials/v1
+func handleContext(w http.ResponseWriter, r *http.Request) {
+	u := r.URL.Query().Get("url")
+	if u == "" {
+		http.Error(w, "missing url parameter", http.StatusBadRequest)
+		return
+	}
+
+	doc, err := contexts.Load(u)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "context error", "err", err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/ld+json")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Write(doc)
+}
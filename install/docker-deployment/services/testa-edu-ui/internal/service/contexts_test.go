@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const pinsFile = "templates-data/context-pins.json"
+
+// useContexts points the global context cache at cache for one test.
+func useContexts(t *testing.T, cache *ContextCache) {
+	t.Helper()
+	saved := contexts
+	contexts = cache
+	t.Cleanup(func() { contexts = saved })
+}
+
+// TestVendoredContexts checks that every shipped pin has a vendored
+// document that loads offline and matches its integrity.
+func TestVendoredContexts(t *testing.T) {
+	cache, err := NewContextCache(t.TempDir(), pinsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Any network fetch fails the test.
+	cache.client.Timeout = time.Nanosecond
+
+	if len(cache.pins) == 0 {
+		t.Fatal("no pinned contexts")
+	}
+	for u, pin := range cache.pins {
+		if pin.File == "" {
+			t.Errorf("%s: not vendored", u)
+			continue
+		}
+		doc, err := cache.Load(u)
+		if err != nil {
+			t.Errorf("%s: %v", u, err)
+			continue
+		}
+		if !json.Valid(doc) {
+			t.Errorf("%s: not valid JSON", u)
+		}
+	}
+}
+
+// TestContextPins checks that pins are mandatory and that documents which
+// do not match them are refused.
+func TestContextPins(t *testing.T) {
+	const u = "https://example.com/ctx/v1"
+	doc := []byte(`{"@context": {"name": "http://schema.org/name"}}`)
+
+	tests := []struct {
+		name    string
+		pins    string
+		file    []byte
+		loadErr string
+		newErr  bool
+	}{
+		{name: "matching", pins: `{"` + u + `": {"file": "ctx.jsonld", "integrity": "` + contextIntegrity(doc) + `"}}`, file: doc},
+		{name: "tampered", pins: `{"` + u + `": {"file": "ctx.jsonld", "integrity": "` + contextIntegrity(doc) + `"}}`,
+			file: []byte(`{"@context": {"name": "http://example.com/evil"}}`), loadErr: "integrity"},
+		{name: "missing file", pins: `{"` + u + `": {"file": "missing.jsonld", "integrity": "` + contextIntegrity(doc) + `"}}`, loadErr: "vendored"},
+		{name: "empty pin", pins: `{"` + u + `": ""}`, newErr: true},
+		{name: "file without integrity", pins: `{"` + u + `": {"file": "ctx.jsonld"}}`, newErr: true},
+		{name: "unpinned", pins: `{}`, loadErr: "not pinned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			pins := filepath.Join(dir, "pins.json")
+			if err := os.WriteFile(pins, []byte(tt.pins), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.file != nil {
+				if err := os.WriteFile(filepath.Join(dir, "ctx.jsonld"), tt.file, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cache, err := NewContextCache(filepath.Join(dir, "cache"), pins)
+			if tt.newErr {
+				if err == nil {
+					t.Fatal("NewContextCache accepted the pins")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cache.Load(u)
+			switch {
+			case tt.loadErr == "" && err != nil:
+				t.Errorf("Load: %v", err)
+			case tt.loadErr == "" && string(got) != string(doc):
+				t.Errorf("Load returned %q", got)
+			case tt.loadErr != "" && (err == nil || !strings.Contains(err.Error(), tt.loadErr)):
+				t.Errorf("Load err = %v, want %q", err, tt.loadErr)
+			}
+		})
+	}
+}
+
+// TestLocalSigningOffline signs and verifies an Ed25519Signature2020
+// credential using only the vendored contexts.
+func TestLocalSigningOffline(t *testing.T) {
+	cache, err := NewContextCache(t.TempDir(), pinsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.client.Timeout = time.Nanosecond
+	useContexts(t, cache)
+
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did := didKeyEd25519(priv.Public().(ed25519.PublicKey))
+	signer := &LocalSigner{did: did, key: ed25519Signer(priv), vm: did + "#" + strings.TrimPrefix(did, "did:key:")}
+
+	signed, err := signer.SignCredential(map[string]interface{}{
+		"proofType": "Ed25519Signature2020",
+		"credential": map[string]interface{}{
+			"@context":          []interface{}{"https://www.w3.org/2018/credentials/v1", "https://w3id.org/security/suites/ed25519-2020/v1"},
+			"type":              []interface{}{"VerifiableCredential"},
+			"issuer":            did,
+			"issuanceDate":      "2026-01-01T00:00:00Z",
+			"credentialSubject": map[string]interface{}{"id": "did:example:student"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("SignCredential: %v", err)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(signed, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyLDProofs(doc, did); err != nil {
+		t.Fatalf("verifyLDProofs: %v", err)
+	}
+
+	doc["issuanceDate"] = "2026-01-02T00:00:00Z"
+	if err := verifyLDProofs(doc, did); err == nil {
+		t.Error("verifyLDProofs accepted a modified credential")
+	}
+}
@@ -0,0 +1,91 @@
+package service
+
+import "time"
+
+type CredentialForm struct {
+	StudentName    string
+	Institution    string
+	Degree         string
+	FieldOfStudy   string
+	EnrollmentDate string
+	GraduationDate string
+	StudentID      string
+	GPA            string
+	Honors         string
+
+	// GPAScale is the grading scale GPA is on, and GPANormalized the GPA as
+	// a percentage when GPA_NORMALIZE is set; see gradingscales.go.
+	GPAScale      string
+	GPANormalized string
+
+	// SubjectDID is the student's own DID, once they have proven control
+	// of it (see holder.go), or one minted for them (see subjects.go).
+	SubjectDID string
+
+	// IssuedAt is the issuance date the operator gave, or zero to date the
+	// credential when it is signed; see issuedates.go.
+	IssuedAt time.Time
+}
+
+// studentDID is the subject identifier used for a student in every
+// credential format issued for them.
+func studentDID(form CredentialForm) string {
+	return form.SubjectDID
+}
+
+func buildCredentialPayload(form CredentialForm, issuerDID, proofType string) map[string]interface{} {
+	subject := map[string]interface{}{
+		"id":   studentDID(form),
+		"type": "EducationCredential",
+	}
+	inlineContext := map[string]string{
+		"EducationCredential": "https://schema.org/EducationalOccupationalCredential",
+	}
+	mappedSubject(form, subject, inlineContext)
+
+	credential := map[string]interface{}{
+		"type":              []string{"VerifiableCredential", "EducationCredential"},
+		"issuer":            issuerDID,
+		"credentialSchema":  credentialSchemaRef(),
+		"credentialSubject": subject,
+	}
+	return signPayload(credential, issuerDID, proofType, issuedAt(form), inlineContext)
+}
+
+// signPayload completes a credential for the configured data model version
+// (base context, validity dates from issued, proof representation) and
+// wraps it in the agent's sign request. extraContexts are appended after the
+// base and suite contexts.
+func signPayload(credential map[string]interface{}, issuerDID, proofType string, issued time.Time, extraContexts ...interface{}) map[string]interface{} {
+	payload := map[string]interface{}{
+		"credential":         credential,
+		"verificationMethod": verificationMethodFor(issuerDID),
+		"proofType":          proofType,
+	}
+
+	var ldContext []interface{}
+	if config.VCVersion == vcdm2 {
+		// VCDM 2.0 replaces issuanceDate/expirationDate with
+		// validFrom/validUntil, and suites with Data Integrity proofs whose
+		// algorithm is named by a cryptosuite.
+		ldContext = []interface{}{"https://www.w3.org/ns/credentials/v2"}
+		credential["validFrom"] = credentialTime(issued)
+		if liveConfig().CredentialValidity > 0 {
+			credential["validUntil"] = credentialTime(issued.Add(liveConfig().CredentialValidity))
+		}
+		payload["proofType"] = "DataIntegrityProof"
+		payload["cryptosuite"] = cryptosuiteFor(proofType)
+	} else {
+		ldContext = []interface{}{"https://www.w3.org/2018/credentials/v1"}
+		if suite := proofSuites[proofType]; suite.Context != "" {
+			ldContext = append(ldContext, suite.Context)
+		}
+		credential["issuanceDate"] = credentialTime(issued)
+		if liveConfig().CredentialValidity > 0 {
+			credential["expirationDate"] = credentialTime(issued.Add(liveConfig().CredentialValidity))
+		}
+	}
+	credential["@context"] = append(ldContext, extraContexts...)
+
+	return payload
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Credential status. Staff revoke or suspend an issued credential with
+// POST /api/staff/credentials/{id}/status; suspension can be lifted, but
+// revocation is final. GET /status/credential/{id} is the public lookup for
+// verifiers that do not process status lists: it answers with the current
+// status (active, revoked, suspended, or expired past the credential's
+// validUntil / expirationDate / exp) as JSON, is cacheable for a few
+// minutes, and is rate limited per client (clientIP, which behind a
+// reverse proxy needs TRUSTED_PROXIES). The lookup never reveals the
+// reason given for a status change.
+
+const (
+	CredentialActive    = "active"
+	CredentialRevoked   = "revoked"
+	CredentialSuspended = "suspended"
+	CredentialExpired   = "expired"
+)
+
+const statusMaxAge = 5 * time.Minute
+
+var errCredentialRevoked = errors.New("credential is revoked")
+
+// statusLimiter caps the lookups one client can make a minute.
+var statusLimiter = newRateLimiter(120, time.Minute)
+
+// CredentialStatus is the /status/credential/{id} response.
+type CredentialStatus struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"`
+	Since      string `json:"since,omitempty"`
+	ValidUntil string `json:"validUntil,omitempty"`
+}
+
+func rfc3339(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// credentialStatus works out the current status of a stored credential.
+func credentialStatus(c *StoredCredential) CredentialStatus {
+	_, expires := credentialValidity(&scannedCredential{Format: c.Format, Credential: c.Credential})
+	st := CredentialStatus{ID: c.ID, Status: CredentialActive, ValidUntil: rfc3339(expires)}
+	switch {
+	case c.Status != "":
+		st.Status, st.Since = c.Status, rfc3339(c.StatusChangedAt)
+	case !expires.IsZero() && time.Now().After(expires):
+		st.Status, st.Since = CredentialExpired, rfc3339(expires)
+	}
+	return st
+}
+
+// storedCredentialFor finds the credential issued here that sc is, by ID
+// or, for encodings that drop the ID, by signature.
+func storedCredentialFor(sc *scannedCredential) (*StoredCredential, bool) {
+	if cred, ok := store.Get(sc.CredentialID); ok {
+		return cred, true
+	}
+	if fp := credentialFingerprint(sc.Credential); fp != "" {
+		return store.ByFingerprint(fp)
+	}
+	return nil, false
+}
+
+func handleCredentialStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if !statusLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	cred, ok := store.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Unknown credential", http.StatusNotFound)
+		return
+	}
+	body, err := json.Marshal(credentialStatus(cred))
+	if err != nil {
+		http.Error(w, "Internal error", 500)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(int(statusMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
+}
+
+// handleCredentialStatusChange sets a credential's status from the JSON
+// body {"status": "revoked"|"suspended"|"active", "reason": "..."}.
+func handleCredentialStatusChange(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status string `json:"status"`
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "expected JSON body with status", http.StatusBadRequest)
+		return
+	}
+	switch req.Status {
+	case CredentialRevoked, CredentialSuspended, CredentialActive:
+	default:
+		http.Error(w, "status must be revoked, suspended or active", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	if _, ok := staffCredential(r, id); !ok {
+		http.Error(w, "Unknown credential", http.StatusNotFound)
+		return
+	}
+	switch err := store.SetStatus(id, req.Status, req.Reason); {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Unknown credential", http.StatusNotFound)
+		return
+	case errors.Is(err, errCredentialRevoked):
+		http.Error(w, "The credential is revoked", http.StatusConflict)
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "credential status error", "err", err)
+		http.Error(w, "Failed to update the status", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: credential status changed", "credential", id, "status", req.Status)
+
+	cred, _ := store.Get(id)
+	if req.Status == CredentialRevoked {
+		e := NotifyEvent{Kind: EventRevoked, Format: cred.Format, ProofType: cred.ProofType, CredentialID: cred.ID}
+		if subject, err := credentialSubjectOf(cred); err == nil {
+			form := formFromSubject(subject)
+			e.Institution, e.Degree = form.Institution, form.Degree
+		}
+		notify(e)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(credentialStatus(cred))
+}
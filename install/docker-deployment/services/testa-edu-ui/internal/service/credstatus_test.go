@@ -0,0 +1,43 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestCredentialStatusLimit checks lookups are limited per client behind
+// a trusted proxy, and per peer when the forwarding header is not trusted.
+func TestCredentialStatusLimit(t *testing.T) {
+	useCohortStore(t, &StoredCredential{ID: "c1", Format: FormatLDP})
+	savedLimiter, savedProxies := statusLimiter, config.TrustedProxies
+	t.Cleanup(func() { statusLimiter, config.TrustedProxies = savedLimiter, savedProxies })
+	statusLimiter = newRateLimiter(1, time.Minute)
+	config.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	lookup := func(peer, forwarded string) int {
+		r := httptest.NewRequest("GET", "/status/credential/c1", nil)
+		r.SetPathValue("id", "c1")
+		r.RemoteAddr = peer
+		r.Header.Set("X-Forwarded-For", forwarded)
+		w := httptest.NewRecorder()
+		handleCredentialStatus(w, r)
+		return w.Code
+	}
+	for _, c := range []struct {
+		peer, forwarded string
+		want            int
+	}{
+		{"10.0.0.2:4000", "198.51.100.1", http.StatusOK},
+		{"10.0.0.2:4000", "198.51.100.2", http.StatusOK},
+		{"10.0.0.3:4000", "198.51.100.1", http.StatusTooManyRequests},
+		{"203.0.113.9:4000", "198.51.100.3", http.StatusOK},
+		{"203.0.113.9:4000", "198.51.100.4", http.StatusTooManyRequests},
+	} {
+		if got := lookup(c.peer, c.forwarded); got != c.want {
+			t.Errorf("from %s for %s: HTTP %d, want %d", c.peer, c.forwarded, got, c.want)
+		}
+	}
+}
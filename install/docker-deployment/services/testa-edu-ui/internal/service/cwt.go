@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBOR serialization of the signed credential for constrained channels
+// (NFC tags, dense QR). The credential is carried unchanged in the "vc"
+// claim of a CWT Claims Set (RFC 8392), so its own proof or JWT signature
+// remains what verifiers check; the CBOR envelope only saves space.
+const cwtClaimVC = "vc"
+
+// CWT claim keys (RFC 8392 section 4).
+const (
+	cwtClaimIss = 1
+	cwtClaimSub = 2
+	cwtClaimIat = 6
+)
+
+// encodeCredentialCBOR returns the CWT Claims Set for a signed credential.
+// JWT and SD-JWT credentials are embedded as their compact serialization.
+func encodeCredentialCBOR(signed json.RawMessage, form CredentialForm) ([]byte, error) {
+	var vc interface{}
+	if err := json.Unmarshal(signed, &vc); err != nil {
+		return nil, fmt.Errorf("parsing credential: %w", err)
+	}
+
+	issuer := config.IssuerDID
+	if cred, ok := vc.(map[string]interface{}); ok {
+		switch iss := cred["issuer"].(type) {
+		case string:
+			issuer = iss
+		case map[string]interface{}:
+			if id, ok := iss["id"].(string); ok {
+				issuer = id
+			}
+		}
+	}
+
+	enc, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	claims := map[interface{}]interface{}{
+		cwtClaimIss: issuer,
+		cwtClaimSub: studentDID(form),
+		cwtClaimIat: time.Now().Unix(),
+		cwtClaimVC:  vc,
+	}
+	data, err := enc.Marshal(claims)
+	if err != nil {
+		return nil, fmt.Errorf("encoding CWT claims: %w", err)
+	}
+	return data, nil
+}
+
+func buildCBORExport(sess *Session) ([]byte, error) {
+	return encodeCredentialCBOR(sess.SignedCredential, sess.Form)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// TestEncodeCredentialCBOR checks the CWT Claims Set carries the issuer,
+// subject and issue time under their RFC 8392 keys and the credential
+// unchanged in the "vc" claim.
+func TestEncodeCredentialCBOR(t *testing.T) {
+	saved := config.IssuerDID
+	config.IssuerDID = "did:example:portal"
+	t.Cleanup(func() { config.IssuerDID = saved })
+
+	form := CredentialForm{SubjectDID: "did:key:z6MkStudent"}
+	tests := []struct {
+		name, credential, issuer string
+	}{
+		{"issuer string", `{"credentialSubject":{"gpa":"3.5","name":"Amina"},"issuer":"did:example:issuer"}`, "did:example:issuer"},
+		{"issuer object", `{"credentialSubject":{"name":"Amina"},"issuer":{"id":"did:example:issuer","name":"Testa"}}`, "did:example:issuer"},
+		{"JWT", `"eyJhbGciOiJFZERTQSJ9.e30.c2ln"`, "did:example:portal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := time.Now().Unix()
+			data, err := encodeCredentialCBOR(json.RawMessage(tt.credential), form)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims map[interface{}]interface{}
+			if err := cbor.Unmarshal(data, &claims); err != nil {
+				t.Fatal(err)
+			}
+			if claims[uint64(cwtClaimIss)] != tt.issuer {
+				t.Errorf("iss = %v, want %s", claims[uint64(cwtClaimIss)], tt.issuer)
+			}
+			if claims[uint64(cwtClaimSub)] != form.SubjectDID {
+				t.Errorf("sub = %v, want %s", claims[uint64(cwtClaimSub)], form.SubjectDID)
+			}
+			if iat, ok := claims[uint64(cwtClaimIat)].(uint64); !ok || int64(iat) < before {
+				t.Errorf("iat = %v", claims[uint64(cwtClaimIat)])
+			}
+
+			var wrapper struct {
+				VC interface{} `cbor:"vc" json:"vc"`
+			}
+			if err := cbor.Unmarshal(data, &wrapper); err != nil {
+				t.Fatal(err)
+			}
+			got, err := json.Marshal(wrapper.VC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.credential {
+				t.Errorf("vc = %s, want %s", got, tt.credential)
+			}
+		})
+	}
+}
+
+// TestEncodeCredentialCBORDeterministic checks the claims set is in core
+// deterministic encoding (RFC 8949 section 4.2), whatever the key order of
+// the signed credential.
+func TestEncodeCredentialCBORDeterministic(t *testing.T) {
+	cred := json.RawMessage(`{"type":["VerifiableCredential"],"issuer":"did:example:issuer","credentialSubject":{"name":"Amina","degree":"BSc"}}`)
+	data, err := encodeCredentialCBOR(cred, CredentialForm{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded interface{}
+	if err := cbor.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	enc, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := enc.Marshal(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, canonical) {
+		t.Errorf("encoding is not deterministic:\n got %x\nwant %x", data, canonical)
+	}
+
+	if _, err := encodeCredentialCBOR(json.RawMessage(`{"issuer":`), CredentialForm{}); err == nil {
+		t.Error("truncated credential accepted")
+	}
+}
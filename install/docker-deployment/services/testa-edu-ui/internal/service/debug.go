@@ -0,0 +1,66 @@
+package service
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"sync"
+)
+
+// Runtime debugging. With DEBUG_ENDPOINTS=true, the Go profiler is served
+// at /debug/pprof/ and runtime variables at /debug/vars, both to staff
+// only (STAFF_API_TOKEN), for diagnosing memory growth in production:
+//
+//	go tool pprof -http=: -H "Authorization: Bearer $TOKEN" \
+//	    https://credentials.example.ac.ke/debug/pprof/heap
+//
+// Besides the memory statistics expvar publishes, /debug/vars has the
+// sessions held in memory and the bytes of credentials and artifacts they
+// hold, the usual suspects of a growing heap.
+
+var publishDebugVars sync.Once
+
+// debugSessionStats counts the sessions and the credential and artifact
+// bytes they hold.
+func debugSessionStats() map[string]int {
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	stats := map[string]int{"count": len(sessions)}
+	for _, s := range sessions {
+		n := len(s.SignedCredential) + len(s.EncryptedPayload) + len(s.Timestamp)
+		for _, b := range s.Exports {
+			n += len(b)
+		}
+		if s.QR != nil {
+			n += len(s.QR.QRData) + len(s.QR.QRPngBase64) + len(s.QR.JSONXTUri) + len(s.QR.AnimatedGIF)
+			for _, f := range s.QR.FramePNGs {
+				n += len(f)
+			}
+		}
+		stats["bytes"] += n
+		if n > stats["largest_bytes"] {
+			stats["largest_bytes"] = n
+		}
+	}
+	return stats
+}
+
+// handleDebugEndpoints adds the debugging endpoints to mux, if enabled.
+func handleDebugEndpoints(mux *http.ServeMux) {
+	if !config.DebugEndpoints {
+		return
+	}
+	publishDebugVars.Do(func() {
+		expvar.Publish("sessions", expvar.Func(func() interface{} { return debugSessionStats() }))
+		expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+		expvar.Publish("delivery_queue", expvar.Func(func() interface{} { return len(deliveryQueue) }))
+	})
+	mux.HandleFunc("GET /debug/vars", requireStaff(expvar.Handler().ServeHTTP))
+	mux.HandleFunc("GET /debug/pprof/", requireStaff(pprof.Index))
+	mux.HandleFunc("GET /debug/pprof/cmdline", requireStaff(pprof.Cmdline))
+	mux.HandleFunc("GET /debug/pprof/profile", requireStaff(pprof.Profile))
+	mux.HandleFunc("GET /debug/pprof/symbol", requireStaff(pprof.Symbol))
+	mux.HandleFunc("POST /debug/pprof/symbol", requireStaff(pprof.Symbol))
+	mux.HandleFunc("GET /debug/pprof/trace", requireStaff(pprof.Trace))
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.StaffAPIToken = "staff-token-0123456789"
+
+	get := func(mux *http.ServeMux, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	mux := http.NewServeMux()
+	handleDebugEndpoints(mux)
+	if rec := get(mux, "/debug/vars", config.StaffAPIToken); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: HTTP %d", rec.Code)
+	}
+
+	config.DebugEndpoints = true
+	mux = http.NewServeMux()
+	handleDebugEndpoints(mux)
+	if rec := get(mux, "/debug/pprof/heap", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no token: HTTP %d", rec.Code)
+	}
+	if rec := get(mux, "/debug/pprof/heap?debug=1", config.StaffAPIToken); rec.Code != http.StatusOK {
+		t.Errorf("heap profile: HTTP %d", rec.Code)
+	}
+
+	sessionsMu.Lock()
+	sessions["debug-test"] = &Session{SignedCredential: []byte(`{"id":"urn:uuid:1"}`), CreatedAt: time.Now()}
+	sessionsMu.Unlock()
+	t.Cleanup(func() {
+		sessionsMu.Lock()
+		delete(sessions, "debug-test")
+		sessionsMu.Unlock()
+	})
+	rec := get(mux, "/debug/vars", config.StaffAPIToken)
+	var vars struct {
+		Sessions map[string]int `json:"sessions"`
+		MemStats struct {
+			HeapAlloc uint64
+		} `json:"memstats"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Sessions["count"] < 1 || vars.Sessions["largest_bytes"] < len(`{"id":"urn:uuid:1"}`) || vars.MemStats.HeapAlloc == 0 {
+		t.Errorf("vars %+v", vars)
+	}
+}
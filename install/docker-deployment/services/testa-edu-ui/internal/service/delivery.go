@@ -0,0 +1,317 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Delivery status tracking for credentials sent to students. Each send is
+// a Delivery in DATA_DIR/deliveries.json that moves from queued through
+// retrying to sent or failed. Recipients are stored masked: the record is
+// for status, not a contact list.
+//
+// Sends of every channel go through one queue and worker, retried with
+// exponential backoff while the channel classes the failure as transient.
+
+const (
+	DeliveryQueued   = "queued"
+	DeliveryRetrying = "retrying"
+	DeliverySent     = "sent"
+	DeliveryFailed   = "failed"
+)
+
+const (
+	deliveryQueueSize    = 100
+	deliveryRetryBackoff = 30 * time.Second
+	deliveryRetryMax     = 30 * time.Minute
+)
+
+type deliveryJob struct {
+	id          string
+	channel     string
+	recipient   string
+	maxAttempts int
+	send        func() error
+	transient   func(error) bool
+	attempt     int
+}
+
+var deliveryQueue = make(chan *deliveryJob, deliveryQueueSize)
+
+// startDeliveries runs the send loop.
+func startDeliveries() {
+	go func() {
+		for job := range deliveryQueue {
+			runDeliveryJob(job)
+		}
+	}()
+}
+
+func runDeliveryJob(job *deliveryJob) {
+	job.attempt++
+	err := job.send()
+	switch {
+	case err == nil:
+		deliveries.Update(job.id, DeliverySent, nil)
+		slog.Info("delivery sent", "delivery", job.id, "channel", job.channel)
+	case job.transient(err) && job.attempt < job.maxAttempts:
+		deliveries.Update(job.id, DeliveryRetrying, err)
+		backoff := min(deliveryRetryBackoff<<(job.attempt-1), deliveryRetryMax)
+		slog.Warn("delivery failed; retrying", "delivery", job.id, "channel", job.channel, "attempt", job.attempt, "retry_in", backoff.String(), "err", err)
+		time.AfterFunc(backoff, func() { deliveryQueue <- job })
+	default:
+		deliveries.Update(job.id, DeliveryFailed, err)
+		slog.Error("delivery failed", "delivery", job.id, "channel", job.channel, "err", err)
+	}
+}
+
+// queueSessionDelivery records a delivery of the session's credential,
+// lets the session poll its status, and queues the job.
+func queueSessionDelivery(sess *Session, job *deliveryJob) (*Delivery, error) {
+	sessionsMu.RLock()
+	credentialID := sess.CredentialID
+	sessionsMu.RUnlock()
+	d, err := queueDelivery(job, credentialID, studentDID(sess.Form))
+	if err != nil {
+		return nil, err
+	}
+	sessionsMu.Lock()
+	if sess.Deliveries == nil {
+		sess.Deliveries = make(map[string]bool)
+	}
+	sess.Deliveries[d.ID] = true
+	sessionsMu.Unlock()
+	return d, nil
+}
+
+// queueDelivery records a delivery and queues the job.
+func queueDelivery(job *deliveryJob, credentialID, subjectID string) (*Delivery, error) {
+	d, err := deliveries.Create(job.channel, job.recipient, credentialID, subjectID)
+	if err != nil {
+		return nil, err
+	}
+	job.id = d.ID
+	select {
+	case deliveryQueue <- job:
+	default:
+		deliveries.Update(d.ID, DeliveryFailed, fmt.Errorf("delivery queue full"))
+		return nil, fmt.Errorf("the delivery queue is full; try again shortly")
+	}
+	return d, nil
+}
+
+type Delivery struct {
+	ID           string    `json:"id"`
+	Channel      string    `json:"channel"`
+	Recipient    string    `json:"recipient"` // masked
+	CredentialID string    `json:"credentialId,omitempty"`
+	SubjectID    string    `json:"subjectId,omitempty"`
+	Status       string    `json:"status"`
+	Attempts     int       `json:"attempts"`
+	LastError    string    `json:"lastError,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+func (d Delivery) Final() bool {
+	return d.Status == DeliverySent || d.Status == DeliveryFailed
+}
+
+type DeliveryStore struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*Delivery
+}
+
+var deliveries *DeliveryStore
+
+func NewDeliveryStore(dataDir string) (*DeliveryStore, error) {
+	s := &DeliveryStore{
+		path:  filepath.Join(dataDir, "deliveries.json"),
+		items: make(map[string]*Delivery),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing deliveries: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading deliveries: %w", err)
+	}
+
+	// Queued messages live in memory and do not survive a restart.
+	interrupted := 0
+	for _, d := range s.items {
+		if !d.Final() {
+			d.Status, d.LastError = DeliveryFailed, "interrupted by restart"
+			interrupted++
+		}
+	}
+	if interrupted > 0 {
+		if err := s.saveLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *DeliveryStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing deliveries: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Create records a queued delivery.
+func (s *DeliveryStore) Create(channel, recipient, credentialID, subjectID string) (*Delivery, error) {
+	b := make([]byte, 12)
+	rand.Read(b)
+	now := time.Now().UTC()
+	d := &Delivery{
+		ID:           hex.EncodeToString(b),
+		Channel:      channel,
+		Recipient:    maskRecipient(recipient),
+		CredentialID: credentialID,
+		SubjectID:    subjectID,
+		Status:       DeliveryQueued,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[d.ID] = d
+	return d, s.saveLocked()
+}
+
+// Update records the outcome of a delivery attempt.
+func (s *DeliveryStore) Update(id, status string, attemptErr error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.items[id]
+	if !ok {
+		return
+	}
+	d.Status = status
+	d.Attempts++
+	d.LastError = ""
+	if attemptErr != nil {
+		d.LastError = attemptErr.Error()
+	}
+	d.UpdatedAt = time.Now().UTC()
+	if err := s.saveLocked(); err != nil {
+		slog.Error("delivery", "delivery", id, "err", err)
+	}
+}
+
+// Get returns a copy of a delivery record.
+func (s *DeliveryStore) Get(id string) (Delivery, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.items[id]
+	if !ok {
+		return Delivery{}, false
+	}
+	return *d, true
+}
+
+// List returns the deliveries for a credential, or all of them.
+func (s *DeliveryStore) List(credentialID string) []Delivery {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Delivery
+	for _, d := range s.items {
+		if credentialID == "" || d.CredentialID == credentialID {
+			out = append(out, *d)
+		}
+	}
+	return out
+}
+
+// EraseSubject deletes the delivery records for subjectID.
+func (s *DeliveryStore) EraseSubject(subjectID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, d := range s.items {
+		if d.SubjectID == subjectID {
+			delete(s.items, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// Expire deletes finished delivery records created before cutoff.
+func (s *DeliveryStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, d := range s.items {
+		if !d.Final() || !d.CreatedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			delete(s.items, id)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+// maskRecipient keeps enough of an address to recognise it:
+// "a***@example.com", "+2547***89".
+func maskRecipient(r string) string {
+	if local, domain, ok := strings.Cut(r, "@"); ok && local != "" {
+		return local[:1] + "***@" + domain
+	}
+	if len(r) > 7 {
+		return r[:5] + "***" + r[len(r)-2:]
+	}
+	return "***"
+}
+
+// handleDeliveryStatus renders a delivery's status for the session that
+// started it. Once the delivery is final it answers 286, which stops the
+// htmx poll.
+func handleDeliveryStatus(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	id := r.PathValue("id")
+	sessionsMu.RLock()
+	owned := sess != nil && sess.Deliveries[id]
+	sessionsMu.RUnlock()
+	d, ok := deliveries.Get(id)
+	if !owned || !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if d.Final() {
+		w.WriteHeader(286)
+	}
+	pages(r).ExecuteTemplate(w, "delivery-status", map[string]interface{}{"Delivery": d})
+}
+
+func handleDeliveryList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(deliveries.List(r.URL.Query().Get("credentialId")))
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// Demo mode. With DEMO_MODE=true the agent is the fake of package
+// mockagent, served in-process, so the UI can be shown and worked on
+// without the CREDEBL stack: the wizard gets tokens from it, has it sign
+// and verify, and goes through AgentClient as it would with a real agent.
+// Its "signatures" are HMACs under a fixed key that is published here, so
+// the same credential always gets the same proof and the fake can check
+// it, but they prove nothing: every page says the service is in demo
+// mode, and what it issues verifies nowhere else. BBS+ derivation,
+// AnonCreds and did:polygon answer 404.
+
+// demoAgentURL is the demo agent's base URL; no request to it leaves the
+// process.
+const demoAgentURL = "http://demo-agent.invalid"
+
+// demoKey is the demo agent's HMAC key. It is not a secret.
+var demoKey = []byte("testa-edu-ui demo agent")
+
+// demoTransport serves requests with the demo agent's handler.
+type demoTransport struct{ h http.Handler }
+
+func (t demoTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil {
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
+	rec := httptest.NewRecorder()
+	t.h.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+// enableDemoAgent points the configuration at the demo agent.
+func enableDemoAgent() {
+	slog.Warn("DEMO_MODE is set; credentials are signed by a built-in fake agent and verify nowhere else")
+	config.AgentURL, config.AgentURLs = demoAgentURL, []string{demoAgentURL}
+	config.AgentBackend = AgentBackendCredo
+	config.AgentTokenURL = ""
+}
+
+// demoAgentClient returns the HTTP client that reaches the demo agent.
+func demoAgentClient() *http.Client {
+	agent := mockagent.New()
+	agent.Token, agent.Label, agent.Version = "demo", "Demo agent", "demo"
+	agent.DIDs, agent.Key = nil, demoKey
+	return &http.Client{Transport: demoTransport{agent}}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestDemoAgent checks the demo agent signs deterministically and that its
+// verification catches a changed credential.
+func TestDemoAgent(t *testing.T) {
+	agent := &AgentClient{BaseURL: demoAgentURL, client: demoAgentClient()}
+	token, err := agent.GetToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := func() map[string]interface{} {
+		return map[string]interface{}{
+			"credential": map[string]interface{}{
+				"type":              []string{"VerifiableCredential"},
+				"issuer":            "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK",
+				"issuanceDate":      "2026-01-01T00:00:00Z",
+				"credentialSubject": map[string]interface{}{"name": "Jane"},
+			},
+			"verificationMethod": "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK#key-1",
+			"proofType":          "Ed25519Signature2020",
+		}
+	}
+	signed, err := agent.SignCredential(token, payload())
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := agent.SignCredential(token, payload())
+	if string(signed) != string(again) {
+		t.Errorf("signing is not deterministic:\n%s\n%s", signed, again)
+	}
+	if v, err := agent.VerifyCredential(token, signed); err != nil || !v.Verified {
+		t.Fatalf("verify: %+v, %v", v, err)
+	}
+
+	var cred map[string]interface{}
+	json.Unmarshal(signed, &cred)
+	cred["credentialSubject"] = map[string]interface{}{"name": "Mallory"}
+	tampered, _ := json.Marshal(cred)
+	if v, err := agent.VerifyCredential(token, tampered); err != nil || v.Verified {
+		t.Errorf("tampered credential: %+v, %v", v, err)
+	}
+
+	jwt, err := agent.SignPresentationJWT(token, map[string]interface{}{"iss": "x"}, "did:key:z6Mk#key-1", "EdDSA")
+	if err != nil || jwt == "" {
+		t.Errorf("raw signing: %q, %v", jwt, err)
+	}
+}
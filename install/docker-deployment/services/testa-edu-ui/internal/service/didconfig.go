@@ -0,0 +1,267 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DID Configuration (DIF Well Known DID Configuration). The deployment
+// serves /.well-known/did-configuration.json with a Domain Linkage
+// Credential, a JWT signed by ISSUER_DID stating that the DID controls the
+// PUBLIC_URL origin. The credential is signed by the agent on first request
+// and kept in DATA_DIR/did-configuration.json until it is close to expiry,
+// or ISSUER_DID or PUBLIC_URL change.
+//
+// Verification checks the reverse for other issuers: when the issuer names
+// a domain (did:web, or an issuer profile URL) the domain's DID
+// configuration must link back to the issuer DID. Fetched configurations
+// are cached for an hour.
+
+const (
+	didConfigurationPath     = "/.well-known/did-configuration.json"
+	didConfigurationContext  = "https://identity.foundation/.well-known/did-configuration/v1"
+	domainLinkageValidity    = 365 * 24 * time.Hour
+	domainLinkageRenewBefore = 30 * 24 * time.Hour
+	domainLinkageCacheTTL    = time.Hour
+	maxDIDConfiguration      = 1 << 20
+)
+
+// DIDConfiguration is the well-known document.
+type DIDConfiguration struct {
+	Context    string            `json:"@context"`
+	LinkedDIDs []json.RawMessage `json:"linked_dids"`
+}
+
+type ownDIDConfiguration struct {
+	DID      string           `json:"did"`
+	Origin   string           `json:"origin"`
+	Expires  time.Time        `json:"expires"`
+	Document DIDConfiguration `json:"document"`
+}
+
+var didConfig struct {
+	mu  sync.Mutex
+	own *ownDIDConfiguration
+}
+
+func publicOrigin() string {
+	u, err := url.Parse(config.PublicURL)
+	if err != nil {
+		return config.PublicURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// currentDIDConfiguration returns the deployment's DID configuration,
+// having the agent sign a new domain linkage credential when needed.
+func currentDIDConfiguration() (*DIDConfiguration, error) {
+	didConfig.mu.Lock()
+	defer didConfig.mu.Unlock()
+	path := filepath.Join(config.DataDir, "did-configuration.json")
+	if didConfig.own == nil {
+		if data, err := os.ReadFile(path); err == nil {
+			var own ownDIDConfiguration
+			if err := json.Unmarshal(data, &own); err != nil {
+				slog.Warn("DID configuration: ignoring a linkage", "path", path, "err", err)
+			} else {
+				didConfig.own = &own
+			}
+		}
+	}
+	origin := publicOrigin()
+	if own := didConfig.own; own != nil && own.DID == config.IssuerDID && own.Origin == origin && time.Until(own.Expires) > domainLinkageRenewBefore {
+		return &own.Document, nil
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(domainLinkageValidity)
+	credential := map[string]interface{}{
+		"@context":       []string{"https://www.w3.org/2018/credentials/v1", didConfigurationContext},
+		"type":           []string{"VerifiableCredential", "DomainLinkageCredential"},
+		"issuer":         config.IssuerDID,
+		"issuanceDate":   credentialTime(now),
+		"expirationDate": credentialTime(expires),
+		"credentialSubject": map[string]string{
+			"id":     config.IssuerDID,
+			"origin": origin,
+		},
+	}
+	proofType := proofTypeFor(config.IssuerDID)
+	token, err := agentClient.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("authenticating with the agent: %w", err)
+	}
+	jwt, err := agentClient.SignCredentialJWT(token, map[string]interface{}{
+		"credential":         credential,
+		"verificationMethod": verificationMethodFor(config.IssuerDID),
+		"proofType":          proofType,
+	}, jwtAlgFor(proofType))
+	if err != nil {
+		return nil, fmt.Errorf("signing domain linkage credential: %w", err)
+	}
+	linked, _ := json.Marshal(jwt)
+	own := &ownDIDConfiguration{
+		DID:     config.IssuerDID,
+		Origin:  origin,
+		Expires: expires,
+		Document: DIDConfiguration{
+			Context:    didConfigurationContext,
+			LinkedDIDs: []json.RawMessage{linked},
+		},
+	}
+	data, err := json.Marshal(own)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("writing DID configuration: %w", err)
+	}
+	didConfig.own = own
+	slog.Info("DID configuration: linked", "did", own.DID, "origin", own.Origin)
+	return &own.Document, nil
+}
+
+func handleDIDConfiguration(w http.ResponseWriter, r *http.Request) {
+	doc, err := currentDIDConfiguration()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "DID configuration error", "err", err)
+		http.Error(w, "DID configuration unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(doc)
+}
+
+// linkedDomain returns the origin an issuer claims: the host of a did:web
+// DID, or the URL of an issuer profile.
+func linkedDomain(sc *scannedCredential, issuer string) string {
+	if host, ok := strings.CutPrefix(issuer, "did:web:"); ok {
+		host, _, _ = strings.Cut(host, ":")
+		host, _, _ = strings.Cut(host, "#")
+		if host, err := url.PathUnescape(host); err == nil && host != "" {
+			return "https://" + host
+		}
+		return ""
+	}
+	_, vc := credentialClaims(sc)
+	if profile, ok := vc["issuer"].(map[string]interface{}); ok {
+		if u, ok := profile["url"].(string); ok {
+			if parsed, err := url.Parse(u); err == nil && parsed.Scheme == "https" && parsed.Host != "" {
+				return "https://" + parsed.Host
+			}
+		}
+	}
+	return ""
+}
+
+var domainLinkage = struct {
+	mu      sync.Mutex
+	fetched map[string]domainLinkageEntry
+	client  *http.Client
+}{
+	fetched: make(map[string]domainLinkageEntry),
+	client:  &http.Client{Timeout: 10 * time.Second},
+}
+
+type domainLinkageEntry struct {
+	doc *DIDConfiguration
+	err error
+	at  time.Time
+}
+
+func fetchDIDConfiguration(origin string) (*DIDConfiguration, error) {
+	domainLinkage.mu.Lock()
+	e, ok := domainLinkage.fetched[origin]
+	domainLinkage.mu.Unlock()
+	if ok && time.Since(e.at) < domainLinkageCacheTTL {
+		return e.doc, e.err
+	}
+
+	e = domainLinkageEntry{at: time.Now()}
+	resp, err := domainLinkage.client.Get(origin + didConfigurationPath)
+	if err == nil {
+		defer resp.Body.Close()
+		var data []byte
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			e.err = fmt.Errorf("%s publishes no DID configuration", origin)
+		case resp.StatusCode != http.StatusOK:
+			e.err = fmt.Errorf("fetching DID configuration from %s: HTTP %d", origin, resp.StatusCode)
+		default:
+			if data, err = io.ReadAll(io.LimitReader(resp.Body, maxDIDConfiguration)); err == nil {
+				e.doc = &DIDConfiguration{}
+				if err = json.Unmarshal(data, e.doc); err != nil {
+					e.doc, e.err = nil, fmt.Errorf("parsing DID configuration from %s: %w", origin, err)
+				}
+			}
+		}
+	}
+	if err != nil && e.err == nil {
+		e.err = fmt.Errorf("fetching DID configuration from %s: %w", origin, err)
+	}
+	domainLinkage.mu.Lock()
+	domainLinkage.fetched[origin] = e
+	domainLinkage.mu.Unlock()
+	return e.doc, e.err
+}
+
+// linkageClaims reads the DID, origin and expiry of a domain linkage
+// credential in JWT or JSON-LD form.
+func linkageClaims(linked json.RawMessage) (did, origin string, expires time.Time) {
+	claims, vc := credentialClaims(&scannedCredential{Credential: linked})
+	_, expires = credentialValidity(&scannedCredential{Credential: linked})
+	subject, _ := vc["credentialSubject"].(map[string]interface{})
+	did, _ = subject["id"].(string)
+	origin, _ = subject["origin"].(string)
+	if sub, ok := claims["sub"].(string); ok && did == "" {
+		did = sub
+	}
+	return did, strings.TrimSuffix(origin, "/"), expires
+}
+
+// checkDomainLinkage records whether the issuer's domain links back to it.
+// A domain that cannot be reached is not held against the credential.
+func (r *ScanResult) checkDomainLinkage(sc *scannedCredential) {
+	issuer, _, _ := strings.Cut(r.Issuer, "#")
+	origin := linkedDomain(sc, issuer)
+	if origin == "" {
+		r.check("domain linkage", CheckSkipped, "the issuer names no domain")
+		return
+	}
+	if issuer == config.IssuerDID && origin == publicOrigin() {
+		r.check("domain linkage", CheckPassed, "linked to "+origin)
+		return
+	}
+	doc, err := fetchDIDConfiguration(origin)
+	if err != nil {
+		r.check("domain linkage", CheckSkipped, err.Error())
+		return
+	}
+	for _, linked := range doc.LinkedDIDs {
+		did, linkedOrigin, expires := linkageClaims(linked)
+		if did != issuer || linkedOrigin != origin || (!expires.IsZero() && time.Now().After(expires)) {
+			continue
+		}
+		token, err := agentClient.GetToken()
+		if err != nil {
+			r.check("domain linkage", CheckSkipped, "could not check the linkage signature")
+			return
+		}
+		if v, err := agentClient.VerifyCredential(token, linked); err == nil && v.Verified {
+			r.check("domain linkage", CheckPassed, "linked to "+origin)
+			return
+		}
+	}
+	r.check("domain linkage", CheckFailed, origin+" does not link to "+issuer)
+}
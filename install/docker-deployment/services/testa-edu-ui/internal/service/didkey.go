@@ -0,0 +1,200 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// did:key support for holder authentication, encryption and local
+// credential verification: Ed25519, P-256 and secp256k1 for signatures,
+// plus X25519 for key agreement.
+
+// Multicodec prefixes (varint-encoded) for did:key public keys.
+var (
+	multicodecEd25519   = []byte{0xed, 0x01}
+	multicodecP256      = []byte{0x80, 0x24}
+	multicodecX25519    = []byte{0xec, 0x01}
+	multicodecSecp256k1 = []byte{0xe7, 0x01}
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Decode(s string) ([]byte, error) {
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		i := strings.IndexRune(base58Alphabet, c)
+		if i < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(i)))
+	}
+	out := n.Bytes()
+	for _, c := range s {
+		if c != '1' {
+			break
+		}
+		out = append([]byte{0}, out...)
+	}
+	return out, nil
+}
+
+func base58Encode(b []byte) string {
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	var out []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, '1')
+	}
+	return string(reverse(out))
+}
+
+// didKeyEd25519 encodes an Ed25519 public key as a did:key.
+func didKeyEd25519(pub ed25519.PublicKey) string {
+	return "did:key:z" + base58Encode(append(append([]byte{}, multicodecEd25519...), pub...))
+}
+
+// parseDIDKey returns the public key encoded in a did:key identifier (any
+// fragment is ignored).
+func parseDIDKey(did string) (crypto.PublicKey, error) {
+	did, _, _ = strings.Cut(did, "#")
+	mb, ok := strings.CutPrefix(did, "did:key:z")
+	if !ok {
+		return nil, fmt.Errorf("not a base58btc did:key: %s", did)
+	}
+	raw, err := base58Decode(mb)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := multicodecPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, did)
+	}
+	return pub, nil
+}
+
+// multicodecPublicKey decodes a multicodec-prefixed public key, as found in
+// did:key identifiers and Multikey verification methods.
+func multicodecPublicKey(raw []byte) (crypto.PublicKey, error) {
+	switch {
+	case len(raw) == 34 && raw[0] == multicodecEd25519[0] && raw[1] == multicodecEd25519[1]:
+		return ed25519.PublicKey(raw[2:]), nil
+	case len(raw) == 35 && raw[0] == multicodecP256[0] && raw[1] == multicodecP256[1]:
+		x, y := elliptic.UnmarshalCompressed(elliptic.P256(), raw[2:])
+		if x == nil {
+			return nil, fmt.Errorf("invalid P-256 key")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	case len(raw) == 34 && raw[0] == multicodecX25519[0] && raw[1] == multicodecX25519[1]:
+		return ecdh.X25519().NewPublicKey(raw[2:])
+	case len(raw) == 35 && raw[0] == multicodecSecp256k1[0] && raw[1] == multicodecSecp256k1[1]:
+		return parseSecp256k1(raw[2:])
+	}
+	return nil, fmt.Errorf("unsupported key type")
+}
+
+// verifyJWTSignature checks a compact JWS against a public key.
+func verifyJWTSignature(compact string, pub crypto.PublicKey) error {
+	i := strings.LastIndex(compact, ".")
+	if i < 0 {
+		return fmt.Errorf("malformed JWT")
+	}
+	signingInput := compact[:i]
+	sig, err := base64.RawURLEncoding.DecodeString(compact[i+1:])
+	if err != nil {
+		return fmt.Errorf("decoding signature: %w", err)
+	}
+
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, []byte(signingInput), sig) {
+			return fmt.Errorf("invalid EdDSA signature")
+		}
+	case *ecdsa.PublicKey:
+		if len(sig) != 64 {
+			return fmt.Errorf("invalid ES256 signature length")
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return fmt.Errorf("invalid ES256 signature")
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256([]byte(signingInput))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return fmt.Errorf("invalid RS256 signature")
+		}
+	case *secp256k1PublicKey:
+		if len(sig) != 64 {
+			return fmt.Errorf("invalid ES256K signature length")
+		}
+		digest := sha256.Sum256([]byte(signingInput))
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !verifySecp256k1(key, digest[:], r, s) {
+			return fmt.Errorf("invalid ES256K signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
+
+// verifyDIDAuth checks a holder's DID-auth JWT: signed by an authentication
+// key of the DID in its iss claim (the one named by the kid header, if
+// any), addressed to aud and bound to nonce.
+// It returns the authenticated holder DID.
+func verifyDIDAuth(compact, aud, nonce string) (string, error) {
+	header, payload, err := decodeJWT(compact)
+	if err != nil {
+		return "", err
+	}
+	holder, _ := payload["iss"].(string)
+	if holder == "" {
+		return "", fmt.Errorf("missing iss")
+	}
+	kid, _ := header["kid"].(string)
+	if kid == "" {
+		kid = holder
+	} else if did, _, _ := strings.Cut(kid, "#"); did != holder {
+		return "", fmt.Errorf("kid does not belong to iss")
+	}
+	if got, _ := payload["aud"].(string); got != aud {
+		return "", fmt.Errorf("aud mismatch")
+	}
+	if got, _ := payload["nonce"].(string); got != nonce {
+		return "", fmt.Errorf("nonce mismatch")
+	}
+	if iat, ok := payload["iat"].(float64); ok && time.Since(time.Unix(int64(iat), 0)) > 5*time.Minute {
+		return "", fmt.Errorf("proof is stale")
+	}
+
+	pub, err := didResolver.Key(kid, "authentication")
+	if err != nil {
+		return "", err
+	}
+	if err := verifyJWTSignature(compact, pub); err != nil {
+		return "", err
+	}
+	return holder, nil
+}
@@ -0,0 +1,363 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Issuer setup. /admin/setup (also /admin/issuer-did) walks staff through
+// making the agent ready to issue, replacing the scripts that used to be
+// run first:
+//
+//  1. checking agent: the agent answers with a token, and its wallet is
+//     open (the agent creates its wallet when it starts) — see
+//     agentcaps.go.
+//  2. the issuer DID and its key:
+//     - key: the agent creates an Ed25519 key and its did:key at once.
+//     - polygon: the agent creates a secp256k1 key pair, the new account
+//     is waited on until it holds enough POL for the registration gas
+//     (see polygon.go), the DID is written to the registry contract and
+//     the agent's resolver is polled until the registration transaction
+//     is confirmed.
+//  3. checking signing: a throwaway credential is signed as the new DID
+//     and verified by the agent, end to end.
+//
+// Private keys go straight to the agent's wallet and are never stored
+// here. The new DID, with the proof type and verification method it
+// signs with, is saved to DATA_DIR/issuer-did.json and, from the next
+// start, replaces ISSUER_DID; delete the file to go back to ISSUER_DID.
+
+const (
+	didConfirmInterval = 5 * time.Second
+	didConfirmTimeout  = 10 * time.Minute
+	didFundTimeout     = 30 * time.Minute
+)
+
+var polygonNetworks = []string{"testnet", "mainnet"}
+
+// DID methods the setup can create.
+const (
+	ProvisionKey     = "key"
+	ProvisionPolygon = "polygon"
+)
+
+// DIDProvisioning is the state of one provisioning run.
+type DIDProvisioning struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Network    string    `json:"network,omitempty"`
+	Step       string    `json:"step"`
+	FailedStep string    `json:"failedStep,omitempty"`
+	Agent      string    `json:"agent,omitempty"`
+	DID        string    `json:"did,omitempty"`
+	Address    string    `json:"address,omitempty"`
+	Warning    string    `json:"warning,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished,omitempty"`
+}
+
+func (p DIDProvisioning) Final() bool { return p.Step == "done" || p.Step == "failed" }
+
+// provisionSteps lists a method's steps, in order.
+func provisionSteps(method string) []string {
+	if method == ProvisionKey {
+		return []string{"checking agent", "creating DID", "checking signing"}
+	}
+	return []string{"checking agent", "creating key", "awaiting funds", "registering", "confirming", "checking signing"}
+}
+
+// ProvisionStep is a step of the setup checklist; State is done, current,
+// failed or pending.
+type ProvisionStep struct {
+	Name  string
+	State string
+}
+
+// Checklist is the run's steps and how far it got.
+func (p DIDProvisioning) Checklist() []ProvisionStep {
+	at, state := p.Step, "done"
+	if p.Step == "failed" {
+		at = p.FailedStep
+	}
+	var out []ProvisionStep
+	for _, name := range provisionSteps(p.Method) {
+		switch {
+		case name != at:
+			out = append(out, ProvisionStep{name, state})
+		case p.Step == "failed":
+			out, state = append(out, ProvisionStep{name, "failed"}), "pending"
+		default:
+			out, state = append(out, ProvisionStep{name, "current"}), "pending"
+		}
+	}
+	return out
+}
+
+var (
+	provisioningsMu sync.Mutex
+	provisionings   = map[string]*DIDProvisioning{}
+)
+
+func getProvisioning(id string) (DIDProvisioning, bool) {
+	provisioningsMu.Lock()
+	defer provisioningsMu.Unlock()
+	p, ok := provisionings[id]
+	if !ok {
+		return DIDProvisioning{}, false
+	}
+	return *p, true
+}
+
+func updateProvisioning(id string, fn func(p *DIDProvisioning)) {
+	provisioningsMu.Lock()
+	defer provisioningsMu.Unlock()
+	fn(provisionings[id])
+}
+
+// provisionIssuerDID runs the setup steps in the background.
+func provisionIssuerDID(id, method, network, endpoint string) {
+	fail := func(err error) {
+		slog.Error("DID provisioning", "provisioning", id, "err", err)
+		updateProvisioning(id, func(p *DIDProvisioning) {
+			p.FailedStep, p.Step, p.Error, p.Finished = p.Step, "failed", err.Error(), time.Now().UTC()
+		})
+	}
+	token, err := agentClient.GetToken()
+	if err != nil {
+		fail(err)
+		return
+	}
+	caps, err := agentClient.backend().Capabilities(agentClient, token)
+	if err != nil {
+		fail(fmt.Errorf("checking the agent: %w", err))
+		return
+	}
+	agent := caps.Backend + " agent"
+	if caps.Version != "" {
+		agent += " " + caps.Version
+	}
+	if caps.DIDs != nil {
+		agent += fmt.Sprintf(", %d DIDs in its wallet", len(caps.DIDs))
+	}
+
+	var did, proofType, vm string
+	if method == ProvisionKey {
+		updateProvisioning(id, func(p *DIDProvisioning) { p.Step, p.Agent = "creating DID", agent })
+		if did, err = agentClient.WriteKeyDID(token); err != nil {
+			fail(fmt.Errorf("creating DID: %w", err))
+			return
+		}
+		proofType, vm = "Ed25519Signature2020", did+"#"+strings.TrimPrefix(did, "did:key:")
+	} else {
+		updateProvisioning(id, func(p *DIDProvisioning) { p.Step, p.Agent = "creating key", agent })
+		if did, err = registerPolygonDID(id, token, network, endpoint); err != nil {
+			fail(err)
+			return
+		}
+		proofType, vm = defaultProofType, did+"#key-1"
+	}
+	updateProvisioning(id, func(p *DIDProvisioning) { p.Step, p.DID = "checking signing", did })
+
+	if err := checkIssuerSigning(token, did, proofType, vm); err != nil {
+		fail(err)
+		return
+	}
+	if err := saveProvisionedDID(did, proofType, vm); err != nil {
+		fail(err)
+		return
+	}
+	slog.Info("DID provisioning: registered", "provisioning", id, "did", did)
+	updateProvisioning(id, func(p *DIDProvisioning) { p.Step, p.Finished = "done", time.Now().UTC() })
+}
+
+// registerPolygonDID creates, funds, registers and confirms a did:polygon
+// DID.
+func registerPolygonDID(id, token, network, endpoint string) (string, error) {
+	keys, err := agentClient.CreatePolygonKeys(token)
+	if err != nil {
+		return "", fmt.Errorf("creating key: %w", err)
+	}
+	updateProvisioning(id, func(p *DIDProvisioning) { p.Step, p.Address = "awaiting funds", keys.Address })
+
+	// The DID's own account pays for its registration.
+	deadline := time.Now().Add(didFundTimeout)
+	for {
+		pf, err := gasPreflight(network, keys.Address, didRegistryGas)
+		if err != nil {
+			return "", fmt.Errorf("checking balance: %w", err)
+		}
+		updateProvisioning(id, func(p *DIDProvisioning) { p.Warning = pf.Warning() })
+		if pf.Sufficient() {
+			break
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("%s was not funded within %s", keys.Address, didFundTimeout)
+		}
+		time.Sleep(didConfirmInterval)
+	}
+	updateProvisioning(id, func(p *DIDProvisioning) { p.Step = "registering" })
+
+	did, err := agentClient.WritePolygonDID(token, network, keys.PrivateKey, endpoint)
+	if err != nil {
+		return "", fmt.Errorf("registering DID: %w", err)
+	}
+	updateProvisioning(id, func(p *DIDProvisioning) { p.Step, p.DID = "confirming", did })
+
+	deadline = time.Now().Add(didConfirmTimeout)
+	for {
+		if _, err := agentClient.ResolveDID(token, did); err == nil {
+			return did, nil
+		} else if time.Now().After(deadline) {
+			return "", fmt.Errorf("%s was not confirmed within %s: %w", did, didConfirmTimeout, err)
+		}
+		time.Sleep(didConfirmInterval)
+	}
+}
+
+// checkIssuerSigning has the agent sign a throwaway credential as did and
+// verify it.
+func checkIssuerSigning(token, did, proofType, vm string) error {
+	payload := signPayload(map[string]interface{}{
+		"id":                "urn:uuid:" + newUUID(),
+		"type":              []string{"VerifiableCredential"},
+		"issuer":            did,
+		"credentialSubject": map[string]interface{}{"id": "urn:uuid:" + newUUID()},
+	}, did, proofType, time.Now())
+	payload["verificationMethod"] = vm
+	signed, err := agentClient.SignCredential(token, payload)
+	if err != nil {
+		return fmt.Errorf("signing a test credential: %w", err)
+	}
+	v, err := agentClient.VerifyCredential(token, signed)
+	if err != nil {
+		return fmt.Errorf("verifying the test credential: %w", err)
+	}
+	if !v.Verified {
+		return fmt.Errorf("the test credential did not verify: %s", v.Message())
+	}
+	return nil
+}
+
+func provisionedDIDPath() string { return filepath.Join(config.DataDir, "issuer-did.json") }
+
+// provisionedDID is DATA_DIR/issuer-did.json.
+type provisionedDID struct {
+	DID                string    `json:"did"`
+	ProofType          string    `json:"proofType,omitempty"`
+	VerificationMethod string    `json:"verificationMethod,omitempty"`
+	Created            time.Time `json:"created"`
+}
+
+func saveProvisionedDID(did, proofType, vm string) error {
+	data, err := json.Marshal(provisionedDID{DID: did, ProofType: proofType, VerificationMethod: vm, Created: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(provisionedDIDPath(), data, 0o600); err != nil {
+		return fmt.Errorf("saving issuer DID: %w", err)
+	}
+	return nil
+}
+
+// loadProvisionedDID makes a provisioned DID the issuer.
+func loadProvisionedDID() error {
+	data, err := os.ReadFile(provisionedDIDPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading issuer DID: %w", err)
+	}
+	var saved provisionedDID
+	if err := json.Unmarshal(data, &saved); err != nil || saved.DID == "" {
+		return fmt.Errorf("parsing %s: no DID", provisionedDIDPath())
+	}
+	if saved.DID != config.IssuerDID {
+		slog.Info("issuer DID: using the provisioned DID instead of ISSUER_DID", "did", saved.DID)
+		config.IssuerDID = saved.DID
+	}
+	// DIDs saved before the proof type was recorded are did:polygon.
+	if _, ok := config.ProofTypes[saved.DID]; !ok && saved.ProofType != "" {
+		if config.ProofTypes == nil {
+			config.ProofTypes = map[string]string{}
+		}
+		config.ProofTypes[saved.DID] = saved.ProofType
+	}
+	if _, ok := config.VerificationMethods[saved.DID]; !ok && saved.VerificationMethod != "" {
+		if config.VerificationMethods == nil {
+			config.VerificationMethods = map[string]string{}
+		}
+		config.VerificationMethods[saved.DID] = saved.VerificationMethod
+	}
+	return nil
+}
+
+func handleIssuerDIDPage(w http.ResponseWriter, r *http.Request) {
+	if err := pages(r).ExecuteTemplate(w, "issuer-did", map[string]interface{}{
+		"IssuerDID": config.IssuerDID,
+		"Networks":  polygonNetworks,
+		"AgentURL":  config.AgentURL,
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handleDIDProvisionStart starts setting up an issuer DID: a did:key, or a
+// did:polygon DID on the form's network. The method defaults to polygon,
+// for /api/staff/issuer-did/polygon.
+func handleDIDProvisionStart(w http.ResponseWriter, r *http.Request) {
+	method, network := r.FormValue("method"), r.FormValue("network")
+	if method == "" {
+		method = ProvisionPolygon
+	}
+	switch {
+	case method != ProvisionKey && method != ProvisionPolygon:
+		pages(r).ExecuteTemplate(w, "did-provision", map[string]interface{}{"Error": "Choose did:key or did:polygon"})
+		return
+	case method == ProvisionPolygon && !slices.Contains(polygonNetworks, network):
+		pages(r).ExecuteTemplate(w, "did-provision", map[string]interface{}{"Error": "Choose testnet or mainnet"})
+		return
+	case localSigner != nil:
+		pages(r).ExecuteTemplate(w, "did-provision", map[string]interface{}{"Error": "Issuer setup needs the agent; SIGNING_MODE is local"})
+		return
+	}
+	if method == ProvisionKey {
+		network = ""
+	}
+
+	b := make([]byte, 8)
+	rand.Read(b)
+	p := &DIDProvisioning{ID: hex.EncodeToString(b), Method: method, Network: network, Step: "checking agent", Started: time.Now().UTC()}
+	provisioningsMu.Lock()
+	provisionings[p.ID] = p
+	provisioningsMu.Unlock()
+	slog.InfoContext(r.Context(), "staff API: provisioning a DID", "method", method, "provisioning", p.ID)
+	go provisionIssuerDID(p.ID, method, network, r.FormValue("endpoint"))
+
+	pages(r).ExecuteTemplate(w, "did-provision", map[string]interface{}{"Provisioning": *p})
+}
+
+func handleDIDProvisionStatus(w http.ResponseWriter, r *http.Request) {
+	p, ok := getProvisioning(r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if p.Final() {
+		// Stops htmx polling.
+		w.WriteHeader(286)
+	}
+	pages(r).ExecuteTemplate(w, "did-provision", map[string]interface{}{"Provisioning": p})
+}
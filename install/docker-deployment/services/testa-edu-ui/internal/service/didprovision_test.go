@@ -0,0 +1,91 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestProvisionKeyDID checks the setup wizard creates a did:key, has a
+// test credential signed and verified with it, and saves it with its proof
+// type and verification method.
+func TestProvisionKeyDID(t *testing.T) {
+	const did = mockagent.KeyDID
+	mock := mockagent.New()
+	url := mock.Start(t)
+
+	saved, savedClient := config, agentClient
+	t.Cleanup(func() { config, agentClient = saved, savedClient })
+	config.DataDir = t.TempDir()
+	config.ProofTypes, config.VerificationMethods = nil, nil
+	agentClient = &AgentClient{BaseURL: url, client: http.DefaultClient}
+
+	provisionings["test"] = &DIDProvisioning{ID: "test", Method: ProvisionKey, Step: "checking agent"}
+	t.Cleanup(func() { delete(provisionings, "test") })
+	provisionIssuerDID("test", ProvisionKey, "", "")
+
+	p, _ := getProvisioning("test")
+	if p.Step != "done" || p.DID != did {
+		t.Fatalf("provisioning ended %q (%s): %s", p.Step, p.DID, p.Error)
+	}
+	if !strings.Contains(p.Agent, "0.5.3") {
+		t.Errorf("agent %q", p.Agent)
+	}
+	for _, s := range p.Checklist() {
+		if s.State != "done" {
+			t.Errorf("step %s %s", s.Name, s.State)
+		}
+	}
+	var write map[string]string
+	json.Unmarshal(mock.LastBody("/dids/write"), &write)
+	if write["method"] != "key" || write["keyType"] != "ed25519" || len(write["seed"]) != 32 {
+		t.Errorf("DID write request %v", write)
+	}
+	var sign map[string]interface{}
+	json.Unmarshal(mock.LastBody("/agent/credential/sign"), &sign)
+	wantVM := did + "#" + strings.TrimPrefix(did, "did:key:")
+	if sign["verificationMethod"] != wantVM {
+		t.Errorf("test credential signed with %v", sign["verificationMethod"])
+	}
+
+	data, err := os.ReadFile(provisionedDIDPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"proofType":"Ed25519Signature2020"`) {
+		t.Errorf("saved %s", data)
+	}
+	if err := loadProvisionedDID(); err != nil {
+		t.Fatal(err)
+	}
+	if config.IssuerDID != did || config.ProofTypes[did] != "Ed25519Signature2020" || config.VerificationMethods[did] != wantVM {
+		t.Errorf("loaded %s, %v, %v", config.IssuerDID, config.ProofTypes, config.VerificationMethods)
+	}
+
+	// A signing check that fails leaves the DID unsaved.
+	os.Remove(provisionedDIDPath())
+	mock.Respond("/agent/credential/verify", http.StatusOK, `{"isValid":false,"error":"bad proof"}`)
+	provisionings["test"] = &DIDProvisioning{ID: "test", Method: ProvisionKey, Step: "checking agent"}
+	provisionIssuerDID("test", ProvisionKey, "", "")
+	if p, _ := getProvisioning("test"); p.Step != "failed" || p.FailedStep != "checking signing" || !strings.Contains(p.Error, "bad proof") {
+		t.Errorf("failed signing check: %q at %q: %s", p.Step, p.FailedStep, p.Error)
+	}
+	if _, err := os.Stat(provisionedDIDPath()); !os.IsNotExist(err) {
+		t.Errorf("DID saved after a failed signing check: %v", err)
+	}
+}
+
+func TestProvisionChecklist(t *testing.T) {
+	p := DIDProvisioning{Method: ProvisionPolygon, Step: "failed", FailedStep: "awaiting funds"}
+	var states []string
+	for _, s := range p.Checklist() {
+		states = append(states, s.State)
+	}
+	if got := strings.Join(states, " "); got != "done done failed pending pending pending" {
+		t.Errorf("checklist %s", got)
+	}
+}
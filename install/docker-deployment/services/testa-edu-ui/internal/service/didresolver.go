@@ -0,0 +1,398 @@
+package service
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DID resolution. Issuer and holder DIDs are resolved to their keys:
+// did:key by decoding the identifier, did:web by fetching its document over
+// HTTPS and did:polygon through the agent's resolver. Other methods go to
+// the Universal Resolver at UNIVERSAL_RESOLVER_URL when one is configured
+// (bounded by UNIVERSAL_RESOLVER_TIMEOUT), and to the agent otherwise.
+// Fetched documents are cached in memory and in
+// DATA_DIR/did-cache.json for DID_CACHE_TTL; when a DID cannot be resolved
+// again its last document is used, for up to DID_CACHE_MAX_STALE, so
+// verification keeps working through agent and network outages. The cache
+// holds at most DID_CACHE_MAX_ENTRIES documents, evicting the oldest.
+//
+// did:web hosts come from the credentials being verified, so the resolver
+// only connects to public addresses, checked after DNS resolution, and
+// refuses documents over maxDIDDocument bytes.
+
+const maxDIDDocument = 1 << 20
+
+var didWebClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: 5 * time.Second, Control: dialPublicOnly}).DialContext,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// sharedAddressSpace is carrier-grade NAT space (RFC 6598), which
+// netip.Addr.IsPrivate does not cover.
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10")
+
+// dialPublicOnly refuses connections to loopback, private, link-local and
+// other non-public addresses.
+func dialPublicOnly(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || sharedAddressSpace.Contains(ip) {
+		return fmt.Errorf("refusing to connect to non-public address %s", ip)
+	}
+	return nil
+}
+
+// errDIDDeactivated is a definite answer, unlike other resolution failures:
+// credentials from a deactivated DID fail verification.
+var errDIDDeactivated = errors.New("DID is deactivated")
+
+type didVerificationMethod struct {
+	ID                 string                 `json:"id"`
+	Controller         string                 `json:"controller"`
+	PublicKeyMultibase string                 `json:"publicKeyMultibase"`
+	PublicKeyBase58    string                 `json:"publicKeyBase58"`
+	PublicKeyJWK       map[string]interface{} `json:"publicKeyJwk"`
+	Type               string                 `json:"type"`
+}
+
+type didDocument struct {
+	ID                 string                  `json:"id"`
+	VerificationMethod []didVerificationMethod `json:"verificationMethod"`
+	AssertionMethod    []json.RawMessage       `json:"assertionMethod"`
+	Authentication     []json.RawMessage       `json:"authentication"`
+}
+
+type cachedDIDDocument struct {
+	Document json.RawMessage `json:"document"`
+	Fetched  time.Time       `json:"fetched"`
+}
+
+type DIDResolver struct {
+	path       string
+	ttl        time.Duration
+	maxStale   time.Duration
+	maxEntries int
+
+	mu   sync.Mutex
+	Docs map[string]*cachedDIDDocument `json:"docs"`
+}
+
+var didResolver *DIDResolver
+
+func NewDIDResolver(dataDir string, ttl, maxStale time.Duration, maxEntries int) (*DIDResolver, error) {
+	r := &DIDResolver{
+		path:       filepath.Join(dataDir, "did-cache.json"),
+		ttl:        ttl,
+		maxStale:   maxStale,
+		maxEntries: maxEntries,
+		Docs:       make(map[string]*cachedDIDDocument),
+	}
+	data, err := os.ReadFile(r.path)
+	if err == nil {
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, fmt.Errorf("parsing DID cache: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading DID cache: %w", err)
+	}
+	r.pruneLocked()
+	return r, nil
+}
+
+// pruneLocked drops documents past the maximum age and then the oldest
+// documents until the cache is within its size.
+func (r *DIDResolver) pruneLocked() {
+	for did, cached := range r.Docs {
+		if time.Since(cached.Fetched) > r.maxStale {
+			delete(r.Docs, did)
+		}
+	}
+	for len(r.Docs) > r.maxEntries {
+		var oldest string
+		for did, cached := range r.Docs {
+			if oldest == "" || cached.Fetched.Before(r.Docs[oldest].Fetched) {
+				oldest = did
+			}
+		}
+		delete(r.Docs, oldest)
+	}
+}
+
+func (r *DIDResolver) saveLocked() error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing DID cache: %w", err)
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Resolve returns the DID document of a did:web or agent-resolvable DID.
+func (r *DIDResolver) Resolve(did string) (*didDocument, error) {
+	r.mu.Lock()
+	cached := r.Docs[did]
+	r.mu.Unlock()
+	if cached != nil && time.Since(cached.Fetched) < r.ttl {
+		return parseDIDDocument(did, cached.Document)
+	}
+
+	data, err := fetchDIDDocument(did)
+	if errors.Is(err, errDIDDeactivated) {
+		r.mu.Lock()
+		delete(r.Docs, did)
+		r.saveLocked()
+		r.mu.Unlock()
+		return nil, err
+	}
+	if err != nil {
+		if cached != nil && time.Since(cached.Fetched) <= r.maxStale {
+			slog.Warn("DID resolver: using the cached document", "did", did, "cached", cached.Fetched.Format(time.RFC3339), "err", err)
+			return parseDIDDocument(did, cached.Document)
+		}
+		return nil, err
+	}
+	doc, err := parseDIDDocument(did, data)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.Docs[did] = &cachedDIDDocument{Document: data, Fetched: time.Now().UTC()}
+	r.pruneLocked()
+	err = r.saveLocked()
+	r.mu.Unlock()
+	if err != nil {
+		slog.Error("DID resolver", "err", err)
+	}
+	return doc, nil
+}
+
+// Key returns the public key of a verification method that its DID
+// authorizes for a verification relationship ("assertionMethod" or
+// "authentication"). A verification method without a fragment names the
+// DID's first key.
+func (r *DIDResolver) Key(vm, relationship string) (crypto.PublicKey, error) {
+	did, _, _ := strings.Cut(vm, "#")
+	if strings.HasPrefix(did, "did:key:") {
+		// A did:key is its only key, whatever fragment names it.
+		pub, err := parseDIDKey(did)
+		if err != nil {
+			return nil, notLocallyVerifiable("%v", err)
+		}
+		return pub, nil
+	}
+	doc, err := r.Resolve(did)
+	if err != nil {
+		return nil, err
+	}
+	return doc.key(vm, relationship)
+}
+
+func parseDIDDocument(did string, data []byte) (*didDocument, error) {
+	var doc didDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing DID document for %s: %w", did, err)
+	}
+	if doc.ID != did {
+		return nil, fmt.Errorf("DID document for %s is for %s", did, doc.ID)
+	}
+	return &doc, nil
+}
+
+// fetchDIDDocument fetches a DID document from whichever resolver handles
+// its method.
+func fetchDIDDocument(did string) ([]byte, error) {
+	method := didMethod(did)
+	if method != "did:web" && method != "did:polygon" && config.UniversalResolverURL != "" {
+		data, err := fetchUniversalResolver(did)
+		if err != nil && !errors.Is(err, errDIDDeactivated) {
+			return nil, notLocallyVerifiable("resolving %s: %v", did, err)
+		}
+		return data, err
+	}
+	if method != "did:web" {
+		token, err := agentClient.GetToken()
+		if err == nil {
+			var data []byte
+			if data, err = agentClient.ResolveDID(token, did); err == nil || errors.Is(err, errDIDDeactivated) {
+				return data, err
+			}
+		}
+		return nil, notLocallyVerifiable("resolving %s: %v", did, err)
+	}
+
+	docURL, err := didWebURL(did)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := didWebClient.Get(docURL)
+	if err != nil {
+		return nil, notLocallyVerifiable("resolving %s: %v", did, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, notLocallyVerifiable("resolving %s: HTTP %d", did, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDIDDocument+1))
+	if err != nil {
+		return nil, notLocallyVerifiable("resolving %s: %v", did, err)
+	}
+	if len(data) > maxDIDDocument {
+		return nil, notLocallyVerifiable("resolving %s: document exceeds %d bytes", did, maxDIDDocument)
+	}
+	return data, nil
+}
+
+// key finds a verification method in the document, checking it is listed
+// under the relationship when the document has one.
+func (doc *didDocument) key(vm, relationship string) (crypto.PublicKey, error) {
+	did := doc.ID
+	abs := func(id string) string {
+		if strings.HasPrefix(id, "#") {
+			return did + id
+		}
+		return id
+	}
+	refs := doc.AssertionMethod
+	if relationship == "authentication" {
+		refs = doc.Authentication
+	}
+	// Methods may be embedded in the relationship or referenced from it.
+	methods := doc.VerificationMethod
+	var allowed []string
+	for _, raw := range refs {
+		var ref string
+		var m didVerificationMethod
+		if json.Unmarshal(raw, &ref) == nil {
+			allowed = append(allowed, abs(ref))
+		} else if json.Unmarshal(raw, &m) == nil {
+			allowed = append(allowed, abs(m.ID))
+			methods = append(methods, m)
+		}
+	}
+	if !strings.Contains(vm, "#") && len(methods) > 0 {
+		vm = abs(methods[0].ID)
+	}
+	if refs != nil && !slices.Contains(allowed, vm) {
+		return nil, fmt.Errorf("%s is not listed under %s in %s", vm, relationship, did)
+	}
+	for _, m := range methods {
+		if abs(m.ID) == vm {
+			pub, err := verificationMethodKey(m)
+			if err != nil {
+				return nil, notLocallyVerifiable("%s: %v", vm, err)
+			}
+			return pub, nil
+		}
+	}
+	return nil, fmt.Errorf("%s is not in the DID document", vm)
+}
+
+func didMethod(did string) string {
+	parts := strings.SplitN(did, ":", 3)
+	if len(parts) < 2 {
+		return did
+	}
+	return parts[0] + ":" + parts[1]
+}
+
+// didWebURL maps a did:web DID to its document URL.
+func didWebURL(did string) (string, error) {
+	parts := strings.Split(strings.TrimPrefix(did, "did:web:"), ":")
+	for i, p := range parts {
+		unescaped, err := url.PathUnescape(p)
+		if err != nil || unescaped == "" {
+			return "", fmt.Errorf("invalid did:web %s", did)
+		}
+		parts[i] = unescaped
+	}
+	if len(parts) == 1 {
+		return "https://" + parts[0] + "/.well-known/did.json", nil
+	}
+	return "https://" + strings.Join(parts, "/") + "/did.json", nil
+}
+
+// fetchUniversalResolver resolves a DID with a Universal Resolver
+// instance's /1.0/identifiers endpoint.
+func fetchUniversalResolver(did string) ([]byte, error) {
+	req, err := http.NewRequest("GET", config.UniversalResolverURL+"/1.0/identifiers/"+url.PathEscape(did), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", `application/ld+json;profile="https://w3id.org/did-resolution"`)
+	client := &http.Client{Timeout: config.UniversalResolverTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("universal resolver: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDIDDocument))
+	if err != nil {
+		return nil, fmt.Errorf("universal resolver: %w", err)
+	}
+	// Errors come back as resolution results too, with a 4xx status.
+	data, err := parseResolutionResult(body, did)
+	if err != nil {
+		return nil, fmt.Errorf("universal resolver: HTTP %d: %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("universal resolver: HTTP %d", resp.StatusCode)
+	}
+	return data, nil
+}
+
+// parseResolutionResult extracts the DID document from a DID resolution
+// result, rejecting failed resolutions and deactivated DIDs.
+func parseResolutionResult(body []byte, did string) ([]byte, error) {
+	var result struct {
+		DIDDocument           json.RawMessage `json:"didDocument"`
+		DIDResolutionMetadata struct {
+			Error        string `json:"error"`
+			Message      string `json:"message"`
+			ErrorMessage string `json:"errorMessage"`
+		} `json:"didResolutionMetadata"`
+		DIDDocumentMetadata struct {
+			Deactivated bool `json:"deactivated"`
+		} `json:"didDocumentMetadata"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("parsing resolution result: %w", err)
+	}
+	if meta := result.DIDResolutionMetadata; meta.Error != "" {
+		return nil, fmt.Errorf("%s: %s %s%s", did, meta.Error, meta.Message, meta.ErrorMessage)
+	}
+	if len(result.DIDDocument) == 0 || string(result.DIDDocument) == "null" {
+		return nil, fmt.Errorf("no DID document for %s", did)
+	}
+	if result.DIDDocumentMetadata.Deactivated {
+		return nil, fmt.Errorf("%w: %s", errDIDDeactivated, did)
+	}
+	return result.DIDDocument, nil
+}
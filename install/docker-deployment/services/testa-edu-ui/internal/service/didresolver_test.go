@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// TestDialPublicOnly checks which resolved addresses did:web may reach.
+func TestDialPublicOnly(t *testing.T) {
+	tests := []struct {
+		addr    string
+		allowed bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:443", false},
+		{"[::1]:443", false},
+		{"10.1.2.3:443", false},
+		{"172.16.0.1:443", false},
+		{"192.168.1.1:443", false},
+		{"169.254.169.254:80", false},
+		{"100.64.0.1:443", false},
+		{"0.0.0.0:443", false},
+		{"[fd00::1]:443", false},
+		{"[fe80::1]:443", false},
+		{"[::ffff:127.0.0.1]:443", false},
+		{"224.0.0.1:443", false},
+	}
+	for _, tt := range tests {
+		err := dialPublicOnly("tcp", tt.addr, nil)
+		if (err == nil) != tt.allowed {
+			t.Errorf("%s: err = %v, want allowed = %t", tt.addr, err, tt.allowed)
+		}
+	}
+}
+
+// TestDIDWebPrivateHost checks that a did:web naming an internal host is
+// not fetched.
+func TestDIDWebPrivateHost(t *testing.T) {
+	for _, did := range []string{"did:web:127.0.0.1", "did:web:localhost%3A8080", "did:web:169.254.169.254:latest"} {
+		if _, err := fetchDIDDocument(did); err == nil {
+			t.Errorf("%s: fetched", did)
+		}
+	}
+}
+
+// TestDIDCachePrune checks that the cache drops documents past the
+// maximum age and keeps only the newest when over size.
+func TestDIDCachePrune(t *testing.T) {
+	r, err := NewDIDResolver(t.TempDir(), time.Hour, 24*time.Hour, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		r.Docs[fmt.Sprintf("did:web:%d.example", i)] = &cachedDIDDocument{Fetched: now.Add(-time.Duration(i) * time.Hour)}
+	}
+	r.Docs["did:web:stale.example"] = &cachedDIDDocument{Fetched: now.Add(-25 * time.Hour)}
+	r.pruneLocked()
+
+	for did, want := range map[string]bool{
+		"did:web:0.example":     true,
+		"did:web:1.example":     true,
+		"did:web:2.example":     true,
+		"did:web:3.example":     false,
+		"did:web:4.example":     false,
+		"did:web:stale.example": false,
+	} {
+		if _, ok := r.Docs[did]; ok != want {
+			t.Errorf("%s cached = %t, want %t", did, ok, want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenant custom domains. A tenant can have domains of its own (say
+// credentials.example.ac.ke) pointed at this server. The credential and
+// claim links of the tenant's credentials are then built on its first
+// domain instead of PUBLIC_URL, and requests arriving with one of its
+// domains in the Host header are served as the tenant: the issuance form
+// opens for it and the claim and scan pages carry its branding. Links
+// stay valid on every domain, as link signatures cover only the path and
+// query.
+//
+// With TLS_ADDR set, HTTPS is also served there, picking the certificate
+// by SNI from TLS_CERT_DIR/<domain>/cert.pem and key.pem (PUBLIC_URL's
+// host included). Certificates are reloaded when their files change, so
+// renewals need no restart. A domain without a certificate there gets one
+// from the ACME CA with TLS_ACME=true (acme.go), or else is served
+// TLS_CERT_FILE and TLS_KEY_FILE, when set: a certificate for all the
+// names, say a wildcard. TLS_REDIRECT=true redirects plain HTTP requests on
+// PORT to HTTPS, apart from the health probes.
+
+var domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+
+// validateDomains normalizes and checks a tenant's domain list.
+func validateDomains(domains []string) ([]string, error) {
+	var out []string
+	for _, d := range domains {
+		d = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(d)), ".")
+		if !domainPattern.MatchString(d) {
+			return nil, fmt.Errorf("invalid domain %q", d)
+		}
+		if d == publicHost() {
+			return nil, fmt.Errorf("%s is PUBLIC_URL's host", d)
+		}
+		for _, seen := range out {
+			if seen == d {
+				return nil, fmt.Errorf("duplicate domain %q", d)
+			}
+		}
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func publicHost() string {
+	u, err := url.Parse(config.PublicURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// tenantURL is the base URL of a tenant's links: its first domain, or
+// PUBLIC_URL.
+func tenantURL(tenantID string) string {
+	if tenantID != "" {
+		if t, ok := tenants.Get(tenantID); ok && len(t.Domains) > 0 {
+			return "https://" + t.Domains[0]
+		}
+	}
+	return config.PublicURL
+}
+
+// ownLink splits a link to this server, on PUBLIC_URL or a tenant domain,
+// into its base URL and path.
+func ownLink(raw string) (base, path string, ok bool) {
+	if path, ok := strings.CutPrefix(raw, config.PublicURL+"/"); ok {
+		return config.PublicURL, "/" + path, true
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return "", "", false
+	}
+	if _, ok := tenants.ByDomain(u.Host); !ok {
+		return "", "", false
+	}
+	base = "https://" + u.Host
+	path, ok = strings.CutPrefix(raw, base+"/")
+	return base, "/" + path, ok
+}
+
+type hostTenantKey struct{}
+
+// tenantHosts serves requests for a tenant domain as that tenant.
+func tenantHosts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if t, ok := tenants.ByDomain(host); ok {
+			r = r.WithContext(context.WithValue(r.Context(), hostTenantKey{}, t.ID))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// hostTenant is the tenant whose domain a request came in on, or "".
+func hostTenant(r *http.Request) string {
+	id, _ := r.Context().Value(hostTenantKey{}).(string)
+	return id
+}
+
+// handleTenantDomains replaces a tenant's domains.
+func handleTenantDomains(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Domains []string `json:"domains"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "expected JSON body with domains", http.StatusBadRequest)
+		return
+	}
+	id := r.PathValue("id")
+	switch err := tenants.SetDomains(id, req.Domains); {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Unknown tenant", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: tenant domains set", "tenant", id, "domains", req.Domains)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type domainCert struct {
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// certStore loads per-domain certificates from TLS_CERT_DIR.
+type certStore struct {
+	dir string
+
+	mu    sync.Mutex
+	certs map[string]*domainCert
+}
+
+func (s *certStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if name == "" {
+		name = publicHost()
+	}
+	if !certAllowed(name) {
+		if config.TLSCertFile != "" {
+			return s.load("*", config.TLSCertFile, config.TLSKeyFile)
+		}
+		return nil, fmt.Errorf("TLS: unknown server name %q", hello.ServerName)
+	}
+	certFile, keyFile := filepath.Join(s.dir, name, "cert.pem"), filepath.Join(s.dir, name, "key.pem")
+	if _, err := os.Stat(certFile); err != nil {
+		switch {
+		case acmeManager != nil:
+			named := *hello
+			named.ServerName = name
+			cert, err := acmeManager.GetCertificate(&named)
+			if err != nil {
+				slog.Error("TLS: obtaining a certificate", "domain", name, "err", err)
+				return nil, fmt.Errorf("TLS: no certificate for %s", name)
+			}
+			return cert, nil
+		case config.TLSCertFile != "":
+			return s.load("*", config.TLSCertFile, config.TLSKeyFile)
+		default:
+			return nil, fmt.Errorf("TLS: no certificate for %s", name)
+		}
+	}
+	return s.load(name, certFile, keyFile)
+}
+
+// load returns the certificate in certFile, loading it again when the file
+// has changed.
+func (s *certStore) load(name, certFile, keyFile string) (*tls.Certificate, error) {
+	info, err := os.Stat(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS: no certificate for %s", name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.certs[name]; c != nil && c.modTime.Equal(info.ModTime()) {
+		return c.cert, nil
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		slog.Error("TLS: loading certificate", "domain", name, "err", err)
+		return nil, fmt.Errorf("TLS: no certificate for %s", name)
+	}
+	s.certs[name] = &domainCert{cert: &cert, modTime: info.ModTime()}
+	slog.Info("TLS: loaded certificate", "domain", name)
+	return &cert, nil
+}
+
+// certAllowed reports whether name is PUBLIC_URL's host or a tenant's
+// domain, the names certificates are served and obtained for.
+func certAllowed(name string) bool {
+	if name == publicHost() {
+		return true
+	}
+	_, ok := tenants.ByDomain(name)
+	return ok
+}
+
+// plainHandler is what PORT serves: with HTTPS, the ACME challenges, and
+// with TLS_REDIRECT a redirect to HTTPS.
+func plainHandler(handler http.Handler) http.Handler {
+	if config.TLSAddr == "" {
+		return handler
+	}
+	if config.TLSRedirect {
+		next := handler
+		_, port, _ := net.SplitHostPort(config.TLSAddr)
+		handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isProbePath(r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			host := r.Host
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			if port != "443" {
+				host = net.JoinHostPort(host, port)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		})
+	}
+	if acmeManager != nil {
+		handler = acmeManager.HTTPHandler(handler)
+	}
+	return handler
+}
+
+// serveTLS serves HTTPS on TLS_ADDR.
+func serveTLS(handler http.Handler) {
+	certs := &certStore{dir: config.TLSCertDir, certs: make(map[string]*domainCert)}
+	srv := &http.Server{
+		Addr:        config.TLSAddr,
+		Handler:     handler,
+		TLSConfig:   &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12},
+		BaseContext: baseContext,
+	}
+	slog.Info("Testa Edu UI serving HTTPS", "addr", config.TLSAddr)
+	fatal("serving HTTPS", "addr", config.TLSAddr, "err", srv.ListenAndServeTLS("", ""))
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// The end-to-end suite drives the issuance wizard over HTTP, as the page
+// does — the form, then each step — and compares the credential and the
+// decoded QR payload with the golden files in testdata/e2e, once what
+// changes from run to run (times, UUIDs, the student's DID and the proof
+// values) has been replaced with placeholders.
+//
+//	go test -run TestE2E -update    rewrites the golden files
+//
+// The wizard talks to a mockagent unless E2E_AGENT_URL names a real
+// agent, with E2E_AGENT_API_KEY and E2E_ISSUER_DID; its credentials are
+// then only checked to verify, as their proofs differ from the mock's.
+// Either way every agent call is checked against the agent's API
+// (AGENT_CONTRACT=strict).
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of the end-to-end tests")
+
+const e2eIssuerDID = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+// e2eCase is one run of the wizard.
+type e2eCase struct {
+	name   string
+	format string
+	qrMode string
+	// node is set for cases that need the Node pipeline's packages.
+	node bool
+}
+
+var e2eCases = []e2eCase{
+	{name: "ldp-compact", format: FormatLDP, qrMode: QRModeCompact},
+	{name: "ldp-cbor", format: FormatLDP, qrMode: QRModeCBOR},
+	{name: "jwt-compact", format: FormatJWT, qrMode: QRModeCompact},
+	{name: "ldp-pixelpass", format: FormatLDP, qrMode: QRModePixelPass, node: true},
+}
+
+func TestE2E(t *testing.T) {
+	issuerDID, agentURL, apiKey := e2eIssuerDID, os.Getenv("E2E_AGENT_URL"), os.Getenv("E2E_AGENT_API_KEY")
+	real := agentURL != ""
+	if real {
+		issuerDID = os.Getenv("E2E_ISSUER_DID")
+	} else {
+		agentURL = mockagent.New().Start(t)
+	}
+	srv := startE2EServer(t, agentURL, apiKey, issuerDID)
+
+	for _, c := range e2eCases {
+		t.Run(c.name, func(t *testing.T) {
+			if c.node {
+				if _, err := os.Stat(filepath.Join(config.ScriptsDir, "node_modules")); err != nil {
+					t.Skip("the Node pipeline's packages are not installed")
+				}
+			}
+			client := e2eClient(t)
+			e2eStep(t, client, srv.URL+"/issue", url.Values{
+				"studentName":    {"Jane Wanjiku"},
+				"institution":    {"Testa University"},
+				"degree":         {"Bachelor of Science"},
+				"fieldOfStudy":   {"Computer Science"},
+				"enrollmentDate": {"2021-09-01"},
+				"graduationDate": {"2025-07-15"},
+				"studentId":      {"TU-2021-0042"},
+				"gpa":            {"3.8"},
+				"consent_issue":  {"on"},
+				"format":         {c.format},
+				"qrMode":         {c.qrMode},
+			}, "")
+			e2eStep(t, client, srv.URL+"/step/token", nil, "step-success")
+			e2eStep(t, client, srv.URL+"/step/sign", nil, "step-success")
+			e2eStep(t, client, srv.URL+"/step/verify", nil, "step-success")
+			e2eStep(t, client, srv.URL+"/step/qr", nil, "step-success")
+
+			sess := e2eSession(t, client, srv.URL)
+			credential := e2eGet(t, client, srv.URL+"/download/credential.json")
+			if c.format == FormatJWT {
+				credential = sess.SignedCredential
+			}
+			scanned, err := decodeScannedQR(sess.QR.QRData)
+			if err != nil {
+				t.Fatalf("decoding the QR: %v", err)
+			}
+			if real {
+				return
+			}
+			replace := map[string]string{sess.Form.SubjectDID: "<subject>"}
+			checkGolden(t, c.name+".credential.json", normalizeE2E(t, credential, replace))
+			checkGolden(t, c.name+".qr.json", normalizeE2E(t, scanned.Credential, replace))
+		})
+	}
+}
+
+// startE2EServer configures the service as main does, from defaults and
+// a few settings, and serves its routes.
+func startE2EServer(t *testing.T, agentURL, apiKey, issuerDID string) *httptest.Server {
+	saved, savedClient, savedLive, savedContract := config, agentClient, current.Load(), agentContract
+	t.Cleanup(func() {
+		config, agentClient, agentContract = saved, savedClient, savedContract
+		current.Store(savedLive)
+	})
+	t.Cleanup(artifactPipelines.Wait)
+	if apiKey == "" {
+		apiKey = "e2e-agent-key-0123456789"
+	}
+	t.Setenv("DATA_DIR", t.TempDir())
+	t.Setenv("API_KEY", apiKey)
+	t.Setenv("ISSUER_DID", issuerDID)
+	t.Setenv("PROOF_TYPE", "Ed25519Signature2020")
+	t.Setenv("DEFAULT_LANGUAGE", "en")
+	t.Setenv("AGENT_CONTRACT", AgentContractStrict)
+	config = loadConfig()
+	if err := initLanguages(); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if contexts, err = NewContextCache(config.ContextCacheDir, config.ContextPinsFile); err != nil {
+		t.Fatal(err)
+	}
+	if credSchema, err = LoadCredentialSchema(config.SchemaFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := openStores(); err != nil {
+		t.Fatal(err)
+	}
+	if agentContract, err = LoadAgentContract(config.AgentContractFile); err != nil {
+		t.Fatal(err)
+	}
+	agentClient = NewAgentClient(agentURL, apiKey)
+	srv := httptest.NewServer(withBasePath(tenantHosts(newMux())))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func e2eClient(t *testing.T) *http.Client {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Client{Jar: jar}
+}
+
+// e2eStep posts a wizard form and fails the test unless the page has
+// want, or, with want empty, has no error.
+func e2eStep(t *testing.T, client *http.Client, target string, form url.Values, want string) {
+	t.Helper()
+	resp, err := client.PostForm(target, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	page := string(body)
+	if resp.StatusCode != http.StatusOK || strings.Contains(page, "step-error") || strings.Contains(page, "error-box") || !strings.Contains(page, want) {
+		t.Fatalf("POST %s: HTTP %d\n%s", target, resp.StatusCode, page)
+	}
+}
+
+func e2eGet(t *testing.T, client *http.Client, target string) []byte {
+	t.Helper()
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: HTTP %d: %s", target, resp.StatusCode, body)
+	}
+	return body
+}
+
+// e2eSession is the wizard session of client's cookie.
+func e2eSession(t *testing.T, client *http.Client, base string) *Session {
+	t.Helper()
+	sid := e2eSessionID(t, client, base)
+	sessionsMu.RLock()
+	defer sessionsMu.RUnlock()
+	if sess := sessions[sid]; sess != nil {
+		return sess
+	}
+	t.Fatal("no wizard session")
+	return nil
+}
+
+// e2eSessionID returns the client's wizard session ID.
+func e2eSessionID(t *testing.T, client *http.Client, base string) string {
+	t.Helper()
+	u, _ := url.Parse(base)
+	for _, c := range client.Jar.Cookies(u) {
+		if c.Name == "sid" {
+			return c.Value
+		}
+	}
+	t.Fatal("no wizard session")
+	return ""
+}
+
+var (
+	e2eTimes = regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(\.\d+)?Z`)
+	e2eUUIDs = regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	// e2eVolatile are the members whose values change from run to run.
+	e2eVolatile = map[string]bool{"proofValue": true, "jws": true, "iat": true, "nbf": true, "exp": true, "jti": true}
+)
+
+// normalizeE2E replaces what changes from run to run in a credential,
+// JSON or a compact JWT, with placeholders, and indents it.
+func normalizeE2E(t *testing.T, credential []byte, replace map[string]string) []byte {
+	t.Helper()
+	var jwt string
+	if json.Unmarshal(credential, &jwt) == nil {
+		credential = []byte(jwt)
+	}
+	var v interface{}
+	if strings.Count(string(credential), ".") == 2 && !bytes.HasPrefix(credential, []byte("{")) {
+		header, claims, err := decodeJWT(string(credential))
+		if err != nil {
+			t.Fatalf("invalid JWT: %v", err)
+		}
+		v = map[string]interface{}{"header": header, "claims": claims}
+	} else if err := json.Unmarshal(credential, &v); err != nil {
+		t.Fatalf("invalid credential: %v\n%s", err, credential)
+	}
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	enc.Encode(normalizeValue(v, replace))
+	return out.Bytes()
+}
+
+func normalizeValue(v interface{}, replace map[string]string) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, val := range v {
+			if e2eVolatile[k] {
+				v[k] = "<" + k + ">"
+			} else {
+				v[k] = normalizeValue(val, replace)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeValue(v[i], replace)
+		}
+	case string:
+		for old, placeholder := range replace {
+			v = strings.ReplaceAll(v, old, placeholder)
+		}
+		v = e2eTimes.ReplaceAllString(v, "<time>")
+		return e2eUUIDs.ReplaceAllString(v, "<uuid>")
+	}
+	return v
+}
+
+// checkGolden compares got with testdata/e2e/name, or rewrites it with
+// -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", "e2e", name)
+	if *updateGolden {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s", name, got)
+	}
+}
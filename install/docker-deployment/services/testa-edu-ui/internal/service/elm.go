@@ -0,0 +1,106 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const elmContext = "http://data.europa.eu/snb/model/context/edc-ap"
+
+// buildELM serializes the credential following the European Learning Model
+// (ELM 3) application profile used by Europass European Digital
+// Credentials. EDCs are sealed by the issuing institution's qualified
+// e-seal when uploaded to Europass, so the export is unsigned.
+func buildELM(sess *Session) ([]byte, error) {
+	form, err := credentialForm(sess)
+	if err != nil {
+		return nil, err
+	}
+	lang := func(s string) map[string]string { return map[string]string{"en": s} }
+
+	issuer := map[string]interface{}{
+		"id":        sess.IssuerDID,
+		"type":      "Organisation",
+		"legalName": lang(form.Institution),
+	}
+
+	awarding := map[string]interface{}{
+		"id":           "urn:epass:awardingProcess:" + newUUID(),
+		"type":         "AwardingProcess",
+		"awardingBody": []interface{}{issuer},
+	}
+	if graduated, err := time.ParseInLocation("2006-01-02", form.GraduationDate, localZone()); err == nil {
+		awarding["awardingDate"] = credentialTime(graduated)
+	}
+
+	qualification := map[string]interface{}{
+		"id":    "urn:epass:qualification:" + newUUID(),
+		"type":  "Qualification",
+		"title": lang(form.Degree),
+	}
+	if form.FieldOfStudy != "" {
+		qualification["description"] = lang(form.FieldOfStudy)
+	}
+
+	achievement := map[string]interface{}{
+		"id":          "urn:epass:learningAchievement:" + newUUID(),
+		"type":        "LearningAchievement",
+		"title":       lang(form.Degree),
+		"awardedBy":   awarding,
+		"specifiedBy": qualification,
+	}
+	if form.GPA != "" || form.Honors != "" {
+		grade := gradeText(form)
+		if form.Honors != "" {
+			if grade != "" {
+				grade += ", "
+			}
+			grade += form.Honors
+		}
+		achievement["wasDerivedFrom"] = []interface{}{map[string]interface{}{
+			"id":    "urn:epass:assessment:" + newUUID(),
+			"type":  "LearningAssessment",
+			"title": lang("Final assessment"),
+			"grade": map[string]interface{}{
+				"type":        "Note",
+				"noteLiteral": lang(grade),
+			},
+		}}
+	}
+
+	person := map[string]interface{}{
+		"id":       studentDID(form),
+		"type":     "Person",
+		"fullName": lang(form.StudentName),
+		"hasClaim": []interface{}{achievement},
+	}
+	if form.StudentID != "" {
+		person["identifier"] = []interface{}{map[string]interface{}{
+			"id":           "urn:epass:identifier:" + newUUID(),
+			"type":         "Identifier",
+			"notation":     form.StudentID,
+			"schemeName":   "Student ID",
+			"schemeAgency": lang(form.Institution),
+		}}
+	}
+
+	now := credentialTime(issuedAt(form))
+	cred := map[string]interface{}{
+		"@context": []interface{}{
+			"https://www.w3.org/2018/credentials/v1",
+			elmContext,
+		},
+		"id":                "urn:credential:" + newUUID(),
+		"type":              []string{"VerifiableCredential", "EuropeanDigitalCredential"},
+		"issuer":            issuer,
+		"issuanceDate":      now,
+		"issued":            now,
+		"credentialSubject": person,
+		"credentialProfiles": []interface{}{map[string]interface{}{
+			"id":        "http://data.europa.eu/snb/credential/e34929035b",
+			"type":      "Concept",
+			"prefLabel": lang("Generic"),
+		}},
+	}
+	return json.Marshal(cred)
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Email templates. Each kind of email is one html/template file in
+// EMAIL_TEMPLATES_DIR defining "subject", "html" and "text" blocks. An
+// institution can override any kind by placing a file of the same name in
+// a subdirectory named after its slug, e.g. "testa-edu/claim.html"; for a
+// tenant the slug is its ID. Emails carry the tenant's colour and logo.
+// Files are parsed on every render, so edits show up in the staff preview
+// without a restart.
+
+const (
+	EmailClaim          = "claim"
+	EmailRevocation     = "revocation"
+	EmailExpiryReminder = "expiry-reminder"
+	EmailLogin          = "login"
+	EmailVerifyRequest  = "verification-request"
+	EmailVerifyDecision = "verification-decision"
+)
+
+var emailKinds = []string{EmailClaim, EmailRevocation, EmailExpiryReminder, EmailLogin, EmailVerifyRequest, EmailVerifyDecision}
+
+// EmailData is what email templates can reference.
+type EmailData struct {
+	StudentName  string
+	Institution  string
+	Degree       string
+	IssuedAt     string
+	ExpiresAt    string
+	ClaimURL     string
+	ClaimExpires string
+	Reason       string
+	PortalURL    string
+	LoginURL     string
+	LoginExpires string
+	Employer     string
+	Purpose      string
+	Requested    string
+	Decision     string
+	RequestURL   string
+
+	// Branding, filled in from the tenant's theme.
+	PrimaryColor string
+	LogoURL      string
+}
+
+type RenderedEmail struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// emailTenant is the template directory of a credential's institution.
+func emailTenant(tenantID, institution string) string {
+	if tenantID != "" {
+		return tenantID
+	}
+	return tenantSlug(institution)
+}
+
+// tenantSlug maps an institution name to its template directory name.
+func tenantSlug(institution string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(strings.TrimSpace(institution)) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	return strings.TrimSuffix(b.String(), "-")
+}
+
+// emailTemplatePath returns the tenant's template for kind, falling back
+// to the default.
+func emailTemplatePath(kind, tenant string) string {
+	if tenant != "" {
+		p := filepath.Join(liveConfig().EmailTemplatesDir, tenant, kind+".html")
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return filepath.Join(liveConfig().EmailTemplatesDir, kind+".html")
+}
+
+// renderEmail renders kind for the tenant. The subject and text parts go
+// through html/template too, so they are unescaped afterwards.
+func renderEmail(kind, tenant string, data EmailData) (*RenderedEmail, error) {
+	if !slices.Contains(emailKinds, kind) {
+		return nil, fmt.Errorf("unknown email template %q", kind)
+	}
+	if data.PortalURL == "" {
+		data.PortalURL = config.PublicURL
+	}
+	if data.Institution == "" {
+		data.Institution = themeFor(tenant).Name
+	}
+	if data.PrimaryColor == "" {
+		theme := themeFor(tenant)
+		data.PrimaryColor = theme.PrimaryColor
+		if theme.LogoURL != defaultTheme.LogoURL {
+			data.LogoURL = theme.AbsoluteLogoURL()
+		}
+	}
+	t, err := template.ParseFiles(emailTemplatePath(kind, tenant))
+	if err != nil {
+		return nil, fmt.Errorf("email template: %w", err)
+	}
+
+	part := func(name string) (string, error) {
+		var buf bytes.Buffer
+		if err := t.ExecuteTemplate(&buf, name, data); err != nil {
+			return "", fmt.Errorf("email template %s/%s: %w", kind, name, err)
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+	var out RenderedEmail
+	if out.Subject, err = part("subject"); err != nil {
+		return nil, err
+	}
+	if out.HTML, err = part("html"); err != nil {
+		return nil, err
+	}
+	if out.Text, err = part("text"); err != nil {
+		return nil, err
+	}
+	out.Subject = strings.Join(strings.Fields(html.UnescapeString(out.Subject)), " ")
+	out.Text = html.UnescapeString(out.Text) + "\n"
+	return &out, nil
+}
+
+// sampleEmailData fills a preview with placeholder values.
+func sampleEmailData(institution string) EmailData {
+	if institution == "" {
+		institution = liveConfig().Theme.Name
+	}
+	return EmailData{
+		StudentName:  "Alice Johnson",
+		Institution:  institution,
+		Degree:       "Bachelor of Science",
+		IssuedAt:     "1 July 2025",
+		ExpiresAt:    "1 July 2035",
+		ClaimURL:     claimPageURL("") + "?code=ABCD-EFGH-JKMN",
+		ClaimExpires: "31 July 2025",
+		Reason:       "issued in error",
+		LoginURL:     config.PublicURL + "/portal/magic?token=sample",
+		LoginExpires: "15 minutes",
+		Employer:     "Acme Ltd",
+		Purpose:      "Pre-employment screening",
+		Requested:    "Bachelor of Science",
+		Decision:     VerifyRequestApproved,
+		RequestURL:   config.PublicURL + "/portal",
+	}
+}
+
+func handleEmailTemplatesPage(w http.ResponseWriter, r *http.Request) {
+	if err := pages(r).ExecuteTemplate(w, "email-templates", map[string]interface{}{"Kinds": emailKinds}); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handleEmailTemplatePreview renders a template with sample data.
+func handleEmailTemplatePreview(w http.ResponseWriter, r *http.Request) {
+	institution := strings.TrimSpace(r.URL.Query().Get("institution"))
+	tenant := tenantSlug(institution)
+	if t, ok := tenants.Get(institution); ok {
+		tenant, institution = t.ID, t.Name
+	}
+	rendered, err := renderEmail(r.URL.Query().Get("kind"), tenant, sampleEmailData(institution))
+	if err != nil {
+		pages(r).ExecuteTemplate(w, "email-preview", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	pages(r).ExecuteTemplate(w, "email-preview", map[string]interface{}{
+		"Email":    rendered,
+		"Template": strings.TrimPrefix(emailTemplatePath(r.URL.Query().Get("kind"), tenant), liveConfig().EmailTemplatesDir+string(filepath.Separator)),
+	})
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Embeddable verification widget. Third-party sites, e.g. an alumni page,
+// add a placeholder element and the widget script:
+//
+//	<div data-testa-verify></div>
+//	<script src="https://issuer.example/static/widget.js" async></script>
+//
+// The script fills each placeholder with an iframe of /embed/verify, a
+// compact form that takes a credential file, pasted credential or QR data
+// and verifies it against this deployment as the scan page does. Only the
+// origins in EMBED_ORIGINS may frame it (CSP frame-ancestors); with none
+// configured the widget is off.
+
+// parseEmbedOrigins validates a comma-separated list of web origins.
+func parseEmbedOrigins(s string) ([]string, error) {
+	var origins []string
+	for _, o := range splitList(s) {
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" || strings.TrimSuffix(u.Path, "/") != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid EMBED_ORIGINS entry %q: want an origin like https://alumni.example.edu", o)
+		}
+		origins = append(origins, u.Scheme+"://"+u.Host)
+	}
+	return origins, nil
+}
+
+// embedHeaders allows the configured origins to frame the response. It
+// reports false, having answered 404, when embedding is off.
+func embedHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if len(liveConfig().EmbedOrigins) == 0 {
+		http.NotFound(w, r)
+		return false
+	}
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'self' "+strings.Join(liveConfig().EmbedOrigins, " "))
+	w.Header().Set("Cache-Control", "no-store")
+	return true
+}
+
+func handleEmbedPage(w http.ResponseWriter, r *http.Request) {
+	if !embedHeaders(w, r) {
+		return
+	}
+	// The page embedding the widget is recorded as the verifier.
+	origin := ""
+	if u, err := url.Parse(r.Referer()); err == nil && u.Host != "" {
+		origin = u.Scheme + "://" + u.Host
+	}
+	if err := pages(r).ExecuteTemplate(w, "embed-verify", map[string]interface{}{"Origin": origin}); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handleEmbedVerify verifies a credential submitted from the widget, as an
+// uploaded file or pasted text.
+func handleEmbedVerify(w http.ResponseWriter, r *http.Request) {
+	if !embedHeaders(w, r) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxScanData)
+	if err := r.ParseMultipartForm(maxScanData); err != nil && err != http.ErrNotMultipart {
+		pages(r).ExecuteTemplate(w, "embed-result", map[string]interface{}{"Error": "The credential is too large"})
+		return
+	}
+	text := r.FormValue("credential")
+	if file, _, err := r.FormFile("file"); err == nil {
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			pages(r).ExecuteTemplate(w, "embed-result", map[string]interface{}{"Error": "Could not read the file"})
+			return
+		}
+		text = string(data)
+	}
+	if strings.TrimSpace(text) == "" {
+		pages(r).ExecuteTemplate(w, "embed-result", map[string]interface{}{"Error": "Choose a credential file or paste a credential"})
+		return
+	}
+
+	origin := r.FormValue("origin")
+	if !slices.Contains(liveConfig().EmbedOrigins, origin) {
+		origin = ""
+	}
+
+	sc, err := decodeCredentialText(text)
+	if err != nil {
+		recordVerification(VerifyChannelEmbed, origin, nil, nil, err)
+		pages(r).ExecuteTemplate(w, "embed-result", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	result, err := verifyScanned(r.Context(), sc)
+	recordVerification(VerifyChannelEmbed, origin, sc, result, err)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "embed verify error", "err", err)
+		pages(r).ExecuteTemplate(w, "embed-result", map[string]interface{}{"Error": "Verification is unavailable right now"})
+		return
+	}
+	slog.InfoContext(r.Context(), "embedded widget verified a credential", "format", result.Format, "verified", result.Verified)
+	pages(r).ExecuteTemplate(w, "embed-result", map[string]interface{}{"Result": result})
+}
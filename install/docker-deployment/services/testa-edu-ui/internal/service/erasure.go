@@ -0,0 +1,221 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Data subject erasure (GDPR Art. 17). Staff name a student by DID, or by
+// institution and student ID, and every store holding their personal data
+// is purged: stored credentials, share links and snapshots, short links
+// pointing at them, cloud wallet items and presentation links, ORCID
+// authorizations, employer verification requests and logged
+// verifications, consent records, the PII lookup index, the minted subject
+// key, any live issuance and portal sessions and issuances held for
+// approval.
+//
+// In anonymize mode (the default) credential and consent records are kept
+// without identifying fields so issuance statistics stay intact; erase
+// mode deletes them outright. Each run returns a deletion report, and a
+// copy without the subject's identifiers is appended to erasures.json.
+
+type ErasureRequest struct {
+	SubjectDID  string `json:"subjectDid"`
+	Institution string `json:"institution"`
+	StudentID   string `json:"studentId"`
+	Mode        string `json:"mode"` // anonymize or erase
+}
+
+type ErasureReport struct {
+	ID          string    `json:"id"`
+	SubjectDID  string    `json:"subjectDid,omitempty"`
+	Mode        string    `json:"mode"`
+	CompletedAt time.Time `json:"completedAt"`
+
+	Credentials     int  `json:"credentials"`
+	ShareLinks      int  `json:"shareLinks"`
+	ShortLinks      int  `json:"shortLinks"`
+	ClaimCodes      int  `json:"claimCodes"`
+	WalletItems     int  `json:"walletItems"`
+	ORCIDTokens     int  `json:"orcidTokens"`
+	VerifyRequests  int  `json:"verifyRequests"`
+	Verifications   int  `json:"verifications"`
+	Deliveries      int  `json:"deliveries"`
+	Consents        int  `json:"consents"`
+	PIIIndexEntries int  `json:"piiIndexEntries"`
+	SubjectKey      bool `json:"subjectKey"`
+	Sessions        int  `json:"sessions"`
+	Approvals       int  `json:"approvals"`
+
+	Retained []string `json:"retained,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+var erasureLogMu sync.Mutex
+
+// eraseSubject purges did from every store. Failures in one store are
+// reported and do not stop the others.
+func eraseSubject(did, mode string) *ErasureReport {
+	anonymize := mode != "erase"
+	report := &ErasureReport{ID: newUUID(), SubjectDID: did, Mode: mode}
+	fail := func(what string, err error) {
+		slog.Error("erasure", "erasure", report.ID, "stage", what, "err", err)
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", what, err))
+	}
+
+	credIDs, err := store.EraseSubject(did, anonymize)
+	if err != nil {
+		fail("credentials", err)
+	}
+	report.Credentials = len(credIDs)
+	for _, id := range credIDs {
+		forgetShareCards(id)
+	}
+
+	tokens, err := shares.EraseSubject(did)
+	if err != nil {
+		fail("share links", err)
+	}
+	report.ShareLinks = len(tokens)
+
+	var paths []string
+	for _, id := range credIDs {
+		paths = append(paths, "/c/"+id)
+	}
+	for _, token := range tokens {
+		paths = append(paths, "/share/"+token)
+	}
+	if report.ShortLinks, err = shortLinks.RemoveTargets(func(target string) bool {
+		for _, p := range paths {
+			if strings.HasSuffix(target, p) || strings.Contains(target, p+"?") {
+				return true
+			}
+		}
+		return false
+	}); err != nil {
+		fail("short links", err)
+	}
+
+	if report.ClaimCodes, err = claims.EraseSubject(did); err != nil {
+		fail("claim codes", err)
+	}
+	if report.WalletItems, err = wallets.EraseSubject(did); err != nil {
+		fail("cloud wallet", err)
+	}
+	if report.ORCIDTokens, err = orcid.EraseSubject(did); err != nil {
+		fail("ORCID tokens", err)
+	}
+	if report.VerifyRequests, err = verifyRequests.EraseSubject(did); err != nil {
+		fail("verification requests", err)
+	}
+	if report.Verifications, err = verifications.EraseSubject(did); err != nil {
+		fail("verification log", err)
+	}
+	if report.Deliveries, err = deliveries.EraseSubject(did); err != nil {
+		fail("deliveries", err)
+	}
+	if report.Consents, err = consents.EraseSubject(did, anonymize); err != nil {
+		fail("consents", err)
+	}
+	if report.PIIIndexEntries, err = pii.EraseSubject(did); err != nil {
+		fail("PII index", err)
+	}
+	if report.SubjectKey, err = subjects.Erase(did); err != nil {
+		fail("subject key", err)
+	}
+
+	sessionsMu.Lock()
+	for id, sess := range sessions {
+		if sess.Form.SubjectDID == did {
+			delete(sessions, id)
+			report.Sessions++
+		}
+	}
+	sessionsMu.Unlock()
+	report.Sessions += endPortalSessions(did)
+	if report.Approvals, err = approvals.EraseSubject(did); err != nil {
+		fail("approval requests", err)
+	}
+
+	if anonymize {
+		report.Retained = []string{
+			"credential records: format, proof type and issue date",
+			"consent records: scopes, role and date",
+		}
+	}
+	report.CompletedAt = time.Now().UTC()
+	return report
+}
+
+// logErasure appends the report, minus the subject's DID, to erasures.json.
+func logErasure(report ErasureReport) error {
+	report.SubjectDID = ""
+	erasureLogMu.Lock()
+	defer erasureLogMu.Unlock()
+
+	path := filepath.Join(config.DataDir, "erasures.json")
+	var reports []ErasureReport
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &reports); err != nil {
+			return fmt.Errorf("parsing erasure log: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	data, err := json.Marshal(append(reports, report))
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing erasure log: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func handleSubjectErase(w http.ResponseWriter, r *http.Request) {
+	var req ErasureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Mode == "" {
+		req.Mode = "anonymize"
+	}
+	if req.Mode != "anonymize" && req.Mode != "erase" {
+		http.Error(w, "mode must be anonymize or erase", http.StatusBadRequest)
+		return
+	}
+
+	did := strings.TrimSpace(req.SubjectDID)
+	if did == "" {
+		var ok bool
+		did, ok = subjects.Lookup(CredentialForm{Institution: req.Institution, StudentID: req.StudentID})
+		if !ok {
+			http.Error(w, "Subject not found; give subjectDid, or institution and studentId", http.StatusNotFound)
+			return
+		}
+	}
+
+	report := eraseSubject(did, req.Mode)
+	slog.InfoContext(r.Context(), "erasure completed", "erasure", report.ID, "mode", report.Mode,
+		"credentials", report.Credentials, "share_links", report.ShareLinks, "consents", report.Consents)
+	if err := logErasure(*report); err != nil {
+		slog.ErrorContext(r.Context(), "erasure log", "erasure", report.ID, "err", err)
+		report.Errors = append(report.Errors, "erasure log: "+err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if len(report.Errors) > 0 {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	json.NewEncoder(w).Encode(report)
+}
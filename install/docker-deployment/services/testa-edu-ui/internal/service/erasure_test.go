@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestErasure checks erasing a student removes their records, archived
+// artifacts, shared PDFs, links and sessions, anonymizes when asked to,
+// leaves other students alone, and logs the run without naming them.
+func TestErasure(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	config.StaffAPIToken = "staff-token-0123456789"
+	const (
+		jane  = "did:key:z6MkjaneJaneJaneJaneJaneJaneJaneJaneJaneJaneJan"
+		other = "did:key:z6MkotherOtherOtherOtherOtherOtherOtherOtherOt"
+	)
+	credential := func(id, subject, name string) *StoredCredential {
+		vc, _ := json.Marshal(map[string]interface{}{"credentialSubject": map[string]string{"id": subject, "name": name}})
+		return &StoredCredential{ID: id, Format: FormatLDP, Credential: vc, SubjectID: subject, IssuedAt: time.Now().UTC()}
+	}
+	for _, c := range []*StoredCredential{credential("c1", jane, "Jane Wanjiku"), credential("c2", jane, "Jane Wanjiku"), credential("c3", other, "Otieno Kamau")} {
+		if err := store.Put(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.Archive("c2"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := shares.Create("pdf", jane, []byte("%PDF-1.7 Jane Wanjiku"), time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	short, err := shortLinks.Shorten(config.PublicURL+"/share/"+link.Token, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := short[strings.LastIndex(short, "/")+1:]
+	sessionsMu.Lock()
+	sessions["jane-session"] = &Session{Form: CredentialForm{StudentName: "Jane Wanjiku", SubjectDID: jane}, Exports: map[string][]byte{"pdf": []byte("%PDF")}}
+	sessionsMu.Unlock()
+
+	erase := func(body string) ErasureReport {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/api/staff/subjects/erase", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+config.StaffAPIToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var report ErasureReport
+		json.NewDecoder(resp.Body).Decode(&report)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("erasure: HTTP %d: %+v", resp.StatusCode, report)
+		}
+		return report
+	}
+
+	report := erase(`{"subjectDid":"` + jane + `","mode":"erase"}`)
+	if report.Credentials != 2 || report.ShareLinks != 1 || report.ShortLinks != 1 || report.Sessions != 1 {
+		t.Errorf("report %+v", report)
+	}
+	for _, id := range []string{"c1", "c2"} {
+		if _, ok := store.Get(id); ok {
+			t.Errorf("%s is still stored", id)
+		}
+	}
+	if _, err := os.Stat(store.archivePath("c2")); !os.IsNotExist(err) {
+		t.Errorf("the archived artifacts are still in cold storage: %v", err)
+	}
+	if _, err := os.Stat(shares.contentPath(link.Token)); !os.IsNotExist(err) {
+		t.Errorf("the shared PDF is still stored: %v", err)
+	}
+	if _, ok := shortLinks.Resolve(code); ok {
+		t.Error("the short link to the share still resolves")
+	}
+	sessionsMu.RLock()
+	_, live := sessions["jane-session"]
+	sessionsMu.RUnlock()
+	if live {
+		t.Error("the issuance session is still live")
+	}
+	if c, ok := store.Get("c3"); !ok || c.SubjectID != other {
+		t.Errorf("another student's credential: %+v", c)
+	}
+
+	// Anonymizing keeps the record without who it was for.
+	erase(`{"subjectDid":"` + other + `"}`)
+	if c, ok := store.Get("c3"); !ok || c.ErasedAt.IsZero() || c.SubjectID != "" || c.Credential != nil {
+		t.Errorf("anonymized credential: %+v", c)
+	}
+
+	audit, err := os.ReadFile(filepath.Join(config.DataDir, "erasures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged []ErasureReport
+	if err := json.Unmarshal(audit, &logged); err != nil || len(logged) != 2 {
+		t.Fatalf("erasure log %s: %v", audit, err)
+	}
+	for _, pii := range []string{jane, other, "Jane Wanjiku", "Otieno Kamau"} {
+		if strings.Contains(string(audit), pii) {
+			t.Errorf("the erasure log holds %s", pii)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Error pages. Browsers are shown pages in the site's layout, theme and
+// language for a page that does not exist, an internal error and an agent
+// that is down, from templates/error-page.html. API clients, which do not
+// accept HTML, and htmx requests, which swap in a fragment, keep plain text.
+//
+// MAINTENANCE_MODE degrades the service for an agent upgrade or a data
+// migration: every page carries a banner, the issuance form is hidden, and
+// requests that would change anything are answered with a maintenance page
+// (503, Retry-After). Reads carry on, and so does verification: the verify
+// and scan pages, the embedded verifier and the verifier API. It can be
+// switched on and off with a reload (SIGHUP).
+
+// maintenanceRetryAfter is the Retry-After of maintenance responses, in
+// seconds.
+const maintenanceRetryAfter = "300"
+
+// maintenanceAllowed are the requests, besides reads, served in
+// maintenance mode: verification's.
+var maintenanceAllowed = map[string]bool{
+	"POST /scan/verify":   true,
+	"POST /embed/verify":  true,
+	"POST /api/v1/verify": true,
+}
+
+// errorTitles are the error pages' headings, by status.
+var errorTitles = map[int]string{
+	http.StatusNotFound:            "Page Not Found",
+	http.StatusGone:                "Link Expired",
+	http.StatusInternalServerError: "Something Went Wrong",
+	http.StatusServiceUnavailable:  "Temporarily Unavailable",
+}
+
+// wantsPage reports whether a request is a browser's for a whole page.
+func wantsPage(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "" && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// renderErrorPage answers with an error: a page for a browser, the message
+// as plain text otherwise.
+func renderErrorPage(w http.ResponseWriter, r *http.Request, status int, message string) {
+	if !wantsPage(r) {
+		http.Error(w, message, status)
+		return
+	}
+	title, ok := errorTitles[status]
+	if !ok {
+		title = "Error"
+	}
+	data := map[string]interface{}{"Status": status, "Title": title, "Message": message}
+	if id := hostTenant(r); id != "" {
+		data["Theme"] = themeFor(id)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := pages(r).ExecuteTemplate(w, "error-page", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+	}
+}
+
+// pageNotFound answers that the page does not exist.
+func pageNotFound(w http.ResponseWriter, r *http.Request) {
+	renderErrorPage(w, r, http.StatusNotFound, "The page you are looking for does not exist. Check the address, or start again from the home page.")
+}
+
+// internalError answers that the request failed on our side.
+func internalError(w http.ResponseWriter, r *http.Request) {
+	renderErrorPage(w, r, http.StatusInternalServerError, "An unexpected error occurred. Please try again in a few minutes.")
+}
+
+// agentUnavailable answers that the credential agent is down.
+func agentUnavailable(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "60")
+	renderErrorPage(w, r, http.StatusServiceUnavailable, "Credential issuance is unavailable while the credential agent is down. Please try again shortly; certificates can still be verified.")
+}
+
+// maintenanceGate refuses, in maintenance mode, the requests that would
+// change anything.
+func maintenanceGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		safe := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+		if !liveConfig().MaintenanceMode || safe || maintenanceAllowed[r.Method+" "+r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Retry-After", maintenanceRetryAfter)
+		renderErrorPage(w, r, http.StatusServiceUnavailable, "The service is down for maintenance. Certificates can still be verified; please come back later to issue or change anything.")
+	})
+}
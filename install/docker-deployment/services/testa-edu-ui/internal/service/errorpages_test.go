@@ -0,0 +1,76 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestErrorPages checks browsers get error pages and API clients plain
+// text, and that maintenance mode refuses changes but not verification.
+func TestErrorPages(t *testing.T) {
+	startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	srv := httptest.NewServer(maintenanceGate(newMux()))
+	t.Cleanup(srv.Close)
+
+	get := func(method, path, accept string, form url.Values) (*http.Response, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+	const html = "text/html,application/xhtml+xml"
+
+	resp, body := get("GET", "/no-such-page", html, nil)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "Page Not Found") || !strings.Contains(body, "<html") {
+		t.Errorf("404 page: HTTP %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := get("GET", "/no-such-page", "application/json", nil); resp.StatusCode != http.StatusNotFound || strings.Contains(body, "<html") {
+		t.Errorf("API 404: HTTP %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := get("GET", "/download/credential.pdf", html, nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "<html") || !strings.Contains(body, "Please issue a credential first.") {
+		t.Errorf("download without a credential: HTTP %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := get("GET", "/s/unknown", html, nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "This link has expired or does not exist.") {
+		t.Errorf("unknown short link: HTTP %d: %s", resp.StatusCode, body)
+	}
+
+	saved := agentClient.breaker
+	agentClient.breaker = &circuitBreaker{threshold: 1, failures: 1, openUntil: time.Now().Add(time.Hour)}
+	resp, body = get("GET", "/", html, nil)
+	agentClient.breaker = saved
+	if resp.StatusCode != http.StatusServiceUnavailable || !strings.Contains(body, "credential agent is down") {
+		t.Errorf("agent down: HTTP %d: %s", resp.StatusCode, body)
+	}
+
+	config.MaintenanceMode = true
+	resp, body = get("GET", "/", html, nil)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "maintenance-banner") || strings.Contains(body, `name="studentName"`) {
+		t.Errorf("maintenance index: HTTP %d: %s", resp.StatusCode, body)
+	}
+	resp, body = get("POST", "/issue", html, url.Values{"studentName": {"Jane Wanjiku"}})
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get("Retry-After") == "" || !strings.Contains(body, "down for maintenance") {
+		t.Errorf("issuance in maintenance: HTTP %d: %s", resp.StatusCode, body)
+	}
+	if resp, body := get("POST", "/scan/verify", html, url.Values{"id": {"unknown"}}); resp.StatusCode == http.StatusServiceUnavailable {
+		t.Errorf("verification in maintenance: HTTP %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := get("GET", "/verify", html, nil); resp.StatusCode != http.StatusOK {
+		t.Errorf("verify page in maintenance: HTTP %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,278 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Error reporting. With SENTRY_DSN set, handler panics and unexpected
+// agent errors (server errors and responses that break the agent's
+// contract, not an agent that is down or a step the user cancelled) are
+// reported to Sentry, or any service that takes Sentry's store API, with
+// the request's method, path, ID, trace and wizard step.
+//
+// Reports are scrubbed before they leave: the request's body, query,
+// cookies and headers are left out, and the event passes through the log
+// redactor, which masks the LOG_REDACT_FIELDS values, credentials and the
+// configured secrets. SENTRY_ENVIRONMENT and SENTRY_RELEASE label the
+// events; the release defaults to the build's VCS revision.
+
+const (
+	errorReportTimeout = 10 * time.Second
+	// errorReportsInFlight bounds the reports sent at once; more are
+	// dropped, so an error storm does not pile up goroutines.
+	errorReportsInFlight = 4
+)
+
+// errorReporter sends the reports; nil when reporting is off.
+var errorReporter *sentryReporter
+
+type sentryReporter struct {
+	storeURL    string
+	auth        string
+	environment string
+	release     string
+	client      *http.Client
+	redactor    *logRedactor
+	inFlight    chan struct{}
+}
+
+// startErrorReporting sets up the reporter, if a DSN is configured.
+func startErrorReporting(redactor *logRedactor) error {
+	if config.SentryDSN == "" {
+		return nil
+	}
+	storeURL, key, err := parseSentryDSN(config.SentryDSN)
+	if err != nil {
+		return err
+	}
+	release := config.SentryRelease
+	if info, ok := debug.ReadBuildInfo(); ok && release == "" {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				release = s.Value
+			}
+		}
+	}
+	errorReporter = &sentryReporter{
+		storeURL:    storeURL,
+		auth:        "Sentry sentry_version=7, sentry_client=testa-edu-ui/1.0, sentry_key=" + key,
+		environment: config.SentryEnvironment,
+		release:     release,
+		client:      &http.Client{Timeout: errorReportTimeout},
+		redactor:    redactor,
+		inFlight:    make(chan struct{}, errorReportsInFlight),
+	}
+	return nil
+}
+
+// parseSentryDSN returns the store endpoint and public key of a DSN,
+// <scheme>://<key>@<host>[/<path>]/<project>.
+func parseSentryDSN(dsn string) (storeURL, key string, err error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.User == nil || u.User.Username() == "" {
+		return "", "", errors.New("invalid SENTRY_DSN, want https://<key>@<host>/<project>")
+	}
+	path, project := "", strings.Trim(u.Path, "/")
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		path, project = "/"+project[:i], project[i+1:]
+	}
+	if project == "" {
+		return "", "", errors.New("invalid SENTRY_DSN: no project ID")
+	}
+	return u.Scheme + "://" + u.Host + path + "/api/" + project + "/store/", u.User.Username(), nil
+}
+
+// reportError reports an error met serving the request of ctx.
+func reportError(ctx context.Context, what string, err error) {
+	if errorReporter == nil {
+		return
+	}
+	errorReporter.send(ctx, sentryEvent{
+		Level:   "error",
+		Message: what + ": " + err.Error(),
+		Exception: &sentryExceptions{Values: []sentryException{{
+			Type:  fmt.Sprintf("%T", err),
+			Value: err.Error(),
+		}}},
+	})
+}
+
+// reportPanic reports a handler panic, with the stack it happened on.
+func reportPanic(ctx context.Context, v interface{}, stack []uintptr) {
+	if errorReporter == nil {
+		return
+	}
+	errorReporter.send(ctx, sentryEvent{
+		Level:   "fatal",
+		Message: fmt.Sprintf("panic: %v", v),
+		Exception: &sentryExceptions{Values: []sentryException{{
+			Type:       "panic",
+			Value:      fmt.Sprint(v),
+			Stacktrace: sentryStacktrace(stack),
+		}}},
+	})
+}
+
+// reportedRequestKey is the context key of the request errors are
+// reported with.
+type reportedRequestKey struct{}
+
+// recoverPanics reports a handler's panic and answers with a server
+// error, instead of the connection being dropped. It also notes the
+// request in its context, for the errors reported serving it.
+func recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The path only: the query may carry claim codes and link tokens.
+		r = r.WithContext(context.WithValue(r.Context(), reportedRequestKey{}, &sentryRequest{
+			Method: r.Method,
+			URL:    config.PublicURL + r.URL.Path,
+		}))
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := make([]uintptr, 64)
+			stack = stack[:runtime.Callers(3, stack)]
+			slog.ErrorContext(r.Context(), "panic", "panic", fmt.Sprint(v), "stack", string(debug.Stack()))
+			reportPanic(r.Context(), v, stack)
+			internalError(w, r)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sentryEvent is an event of Sentry's store API.
+type sentryEvent struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	Logger      string            `json:"logger"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Message     string            `json:"message"`
+	Exception   *sentryExceptions `json:"exception,omitempty"`
+	Request     *sentryRequest    `json:"request,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string            `json:"type"`
+	Value      string            `json:"value"`
+	Stacktrace *sentryStackTrace `json:"stacktrace,omitempty"`
+}
+
+type sentryStackTrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	File     string `json:"filename"`
+	Line     int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// sentryStacktrace converts a stack to Sentry frames, the outermost first.
+func sentryStacktrace(stack []uintptr) *sentryStackTrace {
+	var frames []sentryFrame
+	it := runtime.CallersFrames(stack)
+	for {
+		f, more := it.Next()
+		frames = append([]sentryFrame{{
+			Function: f.Function,
+			File:     f.File,
+			Line:     f.Line,
+			InApp:    strings.HasPrefix(f.Function, "main."),
+		}}, frames...)
+		if !more {
+			break
+		}
+	}
+	return &sentryStackTrace{Frames: frames}
+}
+
+func (s *sentryReporter) send(ctx context.Context, e sentryEvent) {
+	id := make([]byte, 16)
+	rand.Read(id)
+	e.EventID = hex.EncodeToString(id)
+	e.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	e.Platform, e.Logger = "go", "testa-edu-ui"
+	e.ServerName, _ = os.Hostname()
+	e.Environment, e.Release = s.environment, s.release
+	e.Tags = map[string]string{}
+	e.Request, _ = ctx.Value(reportedRequestKey{}).(*sentryRequest)
+	// The request's log fields: its ID, trace, session hash, step and
+	// credential.
+	if fields, ok := ctx.Value(logFieldsKey{}).([]slog.Attr); ok {
+		for _, f := range fields {
+			e.Tags[f.Key] = f.Value.String()
+		}
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("error report", "err", err)
+		return
+	}
+	// Scrub before the event leaves the service.
+	body = []byte(s.redactor.Redact(string(body)))
+
+	select {
+	case s.inFlight <- struct{}{}:
+	default:
+		slog.Warn("error report dropped; too many in flight")
+		return
+	}
+	go func() {
+		defer func() { <-s.inFlight }()
+		if err := s.post(body); err != nil {
+			slog.Warn("error report", "err", err)
+		}
+	}()
+}
+
+func (s *sentryReporter) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", s.storeURL, resp.Status)
+	}
+	return nil
+}
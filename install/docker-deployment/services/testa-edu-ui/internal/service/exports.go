@@ -0,0 +1,121 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Export is an alternative serialization of an issued credential, offered
+// as an extra download on the results page. Exports are built on first
+// request and cached on the session.
+type Export struct {
+	Name        string // URL segment under /download/export/
+	Label       string // download button text
+	Filename    string
+	ContentType string
+	Build       func(sess *Session) ([]byte, error)
+}
+
+var exports = []Export{
+	{
+		Name:        "openbadge",
+		Label:       "Open Badge 3.0",
+		Filename:    "testa-edu-openbadge.json",
+		ContentType: "application/vc+ld+json",
+		Build:       buildOpenBadgeExport,
+	},
+	{
+		Name:        "blockcerts",
+		Label:       "Blockcerts (unsigned)",
+		Filename:    "testa-edu-blockcerts.json",
+		ContentType: "application/json",
+		Build:       buildBlockcerts,
+	},
+	{
+		Name:        "europass",
+		Label:       "Europass (ELM)",
+		Filename:    "testa-edu-europass-elm.jsonld",
+		ContentType: "application/ld+json",
+		Build:       buildELM,
+	},
+	{
+		Name:        "mdoc",
+		Label:       "mdoc (ISO 18013-5)",
+		Filename:    "testa-edu-credential.mdoc",
+		ContentType: "application/cbor",
+		Build:       buildMdocExport,
+	},
+	{
+		Name:        "cbor",
+		Label:       "CBOR (CWT claims)",
+		Filename:    "testa-edu-credential.cbor",
+		ContentType: "application/cbor",
+		Build:       buildCBORExport,
+	},
+}
+
+func findExport(name string) (Export, bool) {
+	for _, e := range exports {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return Export{}, false
+}
+
+// signExport has the agent sign an export credential with the session's
+// issuer key and proof suite.
+func signExport(sess *Session, payload map[string]interface{}) ([]byte, error) {
+	if sess.Token == "" {
+		return nil, fmt.Errorf("no agent token; issue the credential first")
+	}
+	return agentClient.SignCredential(sess.Token, payload)
+}
+
+func handleDownloadExport(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderErrorPage(w, r, http.StatusNotFound, "No credential available. Please issue a credential first.")
+		return
+	}
+	export, ok := findExport(r.PathValue("name"))
+	if !ok {
+		renderErrorPage(w, r, http.StatusNotFound, "Unknown export format")
+		return
+	}
+
+	sessionsMu.RLock()
+	doc, cached := sess.Exports[export.Name]
+	sessionsMu.RUnlock()
+
+	if !cached {
+		var err error
+		doc, err = export.Build(sess)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "export", "export", export.Name, "err", err)
+			renderErrorPage(w, r, http.StatusBadGateway, "Failed to build "+export.Label+" export: "+err.Error())
+			return
+		}
+		sessionsMu.Lock()
+		if sess.Exports == nil {
+			sess.Exports = make(map[string][]byte)
+		}
+		sess.Exports[export.Name] = doc
+		sessionsMu.Unlock()
+	}
+
+	if strings.Contains(export.ContentType, "json") {
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, doc, "", "  "); err == nil {
+			doc = pretty.Bytes()
+		}
+	}
+
+	w.Header().Set("Content-Type", export.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", export.Filename))
+	w.Write(doc)
+}
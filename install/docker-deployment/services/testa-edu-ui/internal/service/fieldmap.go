@@ -0,0 +1,137 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Field mapping. FIELD_MAPPING_FILE says how the issuance fields become
+// the credential subject: for each input field, the subject property it
+// fills, the IRI defining that property in the credential's inline
+// @context, and the other names the field may arrive under in a CSV file
+// or a staff API record, such as a registry export's "Full Name" column.
+// The file is a JSON array:
+//
+//	[{"field": "studentName", "property": "name",
+//	  "iri": "https://schema.org/name", "aliases": ["Full Name"]}, ...]
+//
+// The fields are the form's (see formFieldPointers); a field the file
+// leaves out is neither read nor issued, except that studentName,
+// institution and degree are required; gpaNormalized is worked out from
+// the GPA rather than read (see gradingscales.go). Renamed properties must
+// satisfy SCHEMA_FILE. Without the file, defaultFieldMapping applies.
+
+// FieldMapping maps an input field to a credential subject property.
+type FieldMapping struct {
+	Field    string   `json:"field"`
+	Property string   `json:"property"`
+	IRI      string   `json:"iri"`
+	Aliases  []string `json:"aliases,omitempty"`
+}
+
+var defaultFieldMapping = []FieldMapping{
+	{Field: "studentName", Property: "name", IRI: "https://schema.org/name"},
+	{Field: "institution", Property: "alumniOf", IRI: "https://schema.org/alumniOf"},
+	{Field: "degree", Property: "degree", IRI: "https://schema.org/educationalCredentialAwarded"},
+	{Field: "fieldOfStudy", Property: "fieldOfStudy", IRI: "https://schema.org/programName"},
+	{Field: "enrollmentDate", Property: "enrollmentDate", IRI: "https://schema.org/startDate"},
+	{Field: "graduationDate", Property: "graduationDate", IRI: "https://schema.org/endDate"},
+	{Field: "studentId", Property: "studentId", IRI: "https://schema.org/identifier"},
+	{Field: "gpa", Property: "gpa", IRI: "https://schema.org/ratingValue"},
+	{Field: "honors", Property: "honors", IRI: "https://schema.org/honorificSuffix"},
+	{Field: "gpaScale", Property: "gpaScale", IRI: "http://data.europa.eu/snb/model/elm/gradingScheme"},
+	{Field: "gpaNormalized", Property: "gpaNormalized", IRI: "https://schema.org/value"},
+}
+
+// fieldMapping is the mapping in use.
+var fieldMapping = defaultFieldMapping
+
+// LoadFieldMapping reads a mapping file, or returns the default for "".
+func LoadFieldMapping(path string) ([]FieldMapping, error) {
+	if path == "" {
+		return defaultFieldMapping, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading field mapping: %w", err)
+	}
+	var mapping []FieldMapping
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, fmt.Errorf("parsing field mapping: %w", err)
+	}
+	fields := formFieldPointers(&CredentialForm{})
+	seen := map[string]bool{}
+	for _, m := range mapping {
+		if _, ok := fields[m.Field]; !ok {
+			return nil, fmt.Errorf("field mapping: unknown field %q", m.Field)
+		}
+		if m.Property == "" || m.Property == "id" || m.Property == "type" || !validHTTPURL(m.IRI) {
+			return nil, fmt.Errorf("field mapping: %s needs a property, other than id and type, and an http(s) IRI", m.Field)
+		}
+		if seen["field "+m.Field] || seen["property "+m.Property] {
+			return nil, fmt.Errorf("field mapping: %s or %s mapped twice", m.Field, m.Property)
+		}
+		seen["field "+m.Field], seen["property "+m.Property] = true, true
+	}
+	for _, required := range []string{"studentName", "institution", "degree"} {
+		if !seen["field "+required] {
+			return nil, fmt.Errorf("field mapping: the required field %s is not mapped", required)
+		}
+	}
+	return mapping, nil
+}
+
+// formFieldPointers are the form's fields by their form and API names.
+func formFieldPointers(form *CredentialForm) map[string]*string {
+	return map[string]*string{
+		"studentName":    &form.StudentName,
+		"institution":    &form.Institution,
+		"degree":         &form.Degree,
+		"fieldOfStudy":   &form.FieldOfStudy,
+		"enrollmentDate": &form.EnrollmentDate,
+		"graduationDate": &form.GraduationDate,
+		"studentId":      &form.StudentID,
+		"gpa":            &form.GPA,
+		"honors":         &form.Honors,
+		"gpaScale":       &form.GPAScale,
+		"gpaNormalized":  &form.GPANormalized,
+	}
+}
+
+// formFields are the values of the form's fields by their names.
+func formFields(form CredentialForm) map[string]string {
+	fields := map[string]string{}
+	for name, p := range formFieldPointers(&form) {
+		fields[name] = *p
+	}
+	return fields
+}
+
+// mappedSubject fills the subject's properties from the form, and the
+// inline context's terms for them.
+func mappedSubject(form CredentialForm, subject map[string]interface{}, context map[string]string) {
+	fields := formFields(form)
+	for _, m := range fieldMapping {
+		if v := fields[m.Field]; v != "" {
+			subject[m.Property] = v
+		}
+		context[m.Property] = m.IRI
+	}
+}
+
+// readMappedFields reads the mapped fields into form, each by its name or
+// else the first of its aliases with a value.
+func readMappedFields(form *CredentialForm, value func(string) string) {
+	fields := formFieldPointers(form)
+	for _, m := range fieldMapping {
+		v := value(m.Field)
+		for _, alias := range m.Aliases {
+			if v != "" {
+				break
+			}
+			v = value(alias)
+		}
+		*fields[m.Field] = v
+	}
+}
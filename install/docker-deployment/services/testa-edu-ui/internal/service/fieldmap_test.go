@@ -0,0 +1,63 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestFieldMapping checks a mapping renames properties and their IRIs,
+// reads fields by their aliases and leaves unmapped fields out.
+func TestFieldMapping(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mapping.json")
+	err := os.WriteFile(path, []byte(`[
+		{"field": "studentName", "property": "name", "iri": "https://schema.org/name", "aliases": ["Full Name"]},
+		{"field": "institution", "property": "alumniOf", "iri": "https://schema.org/alumniOf"},
+		{"field": "degree", "property": "degree", "iri": "https://schema.org/educationalCredentialAwarded", "aliases": ["Programme"]},
+		{"field": "studentId", "property": "registrationNumber", "iri": "https://example.ac.ke/terms#registrationNumber", "aliases": ["Reg No"]}
+	]`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapping, err := LoadFieldMapping(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fieldMapping = defaultFieldMapping })
+	fieldMapping = mapping
+
+	record := map[string]string{"Full Name": "Jane Wanjiku", "institution": "Testa University", "Programme": "BSc", "Reg No": "S123", "gpa": "3.9"}
+	form, err := issueForm(func(k string) string { return record[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if form.StudentName != "Jane Wanjiku" || form.Degree != "BSc" || form.StudentID != "S123" || form.GPA != "" {
+		t.Errorf("form %+v", form)
+	}
+
+	form.SubjectDID = "did:key:z6Mkjane"
+	payload := buildCredentialPayload(form, e2eIssuerDID, "Ed25519Signature2020")
+	credential := payload["credential"].(map[string]interface{})
+	subject := credential["credentialSubject"].(map[string]interface{})
+	if subject["registrationNumber"] != "S123" || subject["name"] != "Jane Wanjiku" || subject["studentId"] != nil {
+		t.Errorf("subject %v", subject)
+	}
+	contexts := credential["@context"].([]interface{})
+	inline := contexts[len(contexts)-1].(map[string]string)
+	if inline["registrationNumber"] != "https://example.ac.ke/terms#registrationNumber" || inline["gpa"] != "" {
+		t.Errorf("inline context %v", inline)
+	}
+
+	for name, bad := range map[string]string{
+		"unknown field":    `[{"field": "nickname", "property": "nickname", "iri": "https://schema.org/alternateName"}]`,
+		"no IRI":           `[{"field": "studentName", "property": "name"}]`,
+		"missing required": `[{"field": "studentName", "property": "name", "iri": "https://schema.org/name"}]`,
+		"mapped twice":     `[{"field": "studentName", "property": "name", "iri": "https://schema.org/name"}, {"field": "honors", "property": "name", "iri": "https://schema.org/name"}]`,
+	} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := LoadFieldMapping(path); err == nil || !strings.HasPrefix(err.Error(), "field mapping:") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
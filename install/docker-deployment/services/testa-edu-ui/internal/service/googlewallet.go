@@ -0,0 +1,177 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Google Wallet "Save to Google Wallet" links carry the pass as a JWT signed
+// by an issuer service account. The generic class is embedded in the JWT, so
+// no Wallet API calls are needed beforehand. Enabled by setting
+// GOOGLE_WALLET_ISSUER_ID and GOOGLE_WALLET_SERVICE_ACCOUNT (path to the
+// service account JSON key).
+const googleWalletSaveURL = "https://pay.google.com/gp/v/save/"
+
+type googleServiceAccount struct {
+	ClientEmail  string `json:"client_email"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+
+	key *rsa.PrivateKey
+}
+
+var (
+	googleAccountOnce sync.Once
+	googleAccountVal  *googleServiceAccount
+	googleAccountErr  error
+)
+
+func googleWalletEnabled() bool {
+	return config.GoogleWalletIssuerID != "" && config.GoogleWalletServiceAccount != ""
+}
+
+func loadGoogleServiceAccount() (*googleServiceAccount, error) {
+	googleAccountOnce.Do(func() {
+		googleAccountVal, googleAccountErr = readGoogleServiceAccount(config.GoogleWalletServiceAccount)
+	})
+	return googleAccountVal, googleAccountErr
+}
+
+func readGoogleServiceAccount(path string) (*googleServiceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading service account: %w", err)
+	}
+	var sa googleServiceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("parsing service account: %w", err)
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("service account private_key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing service account key: %w", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account key must be RSA")
+	}
+	sa.key = key
+	return &sa, nil
+}
+
+// googleWalletObjects builds the generic class and object for the session's
+// credential. Object IDs must be unique per issuer, so each save link gets
+// a fresh one.
+func googleWalletObjects(sess *Session) (class, object map[string]interface{}) {
+	form := sess.Form
+	classID := config.GoogleWalletIssuerID + "." + config.GoogleWalletClassID
+
+	class = map[string]interface{}{"id": classID}
+
+	lang := func(s string) map[string]interface{} {
+		return map[string]interface{}{"defaultValue": map[string]string{"language": "en", "value": s}}
+	}
+	var modules []map[string]string
+	for _, f := range []struct{ id, header, body string }{
+		{"field_of_study", "Field of Study", form.FieldOfStudy},
+		{"graduation_date", "Graduated", form.GraduationDate},
+		{"gpa", "GPA", gradeText(form)},
+		{"honors", "Honors", form.Honors},
+		{"student_id", "Student ID", form.StudentID},
+	} {
+		if f.body != "" {
+			modules = append(modules, map[string]string{"id": f.id, "header": f.header, "body": f.body})
+		}
+	}
+
+	object = map[string]interface{}{
+		"id":                 config.GoogleWalletIssuerID + "." + newUUID(),
+		"classId":            classID,
+		"state":              "ACTIVE",
+		"cardTitle":          lang(form.Institution),
+		"header":             lang(form.StudentName),
+		"subheader":          lang(form.Degree),
+		"hexBackgroundColor": "#4338ca",
+		"textModulesData":    modules,
+		"barcode": map[string]string{
+			"type":          "QR_CODE",
+			"value":         sess.QR.QRData,
+			"alternateText": "Scan with Inji Verify",
+		},
+	}
+	return class, object
+}
+
+// googleWalletSaveLink signs the save JWT and returns the link a student
+// opens to add the pass.
+func googleWalletSaveLink(sess *Session) (string, error) {
+	sa, err := loadGoogleServiceAccount()
+	if err != nil {
+		return "", err
+	}
+	class, object := googleWalletObjects(sess)
+
+	header := map[string]string{"alg": "RS256", "typ": "JWT", "kid": sa.PrivateKeyID}
+	claims := map[string]interface{}{
+		"iss":     sa.ClientEmail,
+		"aud":     "google",
+		"typ":     "savetowallet",
+		"iat":     time.Now().Unix(),
+		"origins": []string{config.PublicURL},
+		"payload": map[string]interface{}{
+			"genericClasses": []interface{}{class},
+			"genericObjects": []interface{}{object},
+		},
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing save JWT: %w", err)
+	}
+	return googleWalletSaveURL + signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+func handleGoogleWallet(w http.ResponseWriter, r *http.Request) {
+	if !googleWalletEnabled() {
+		http.Error(w, "Google Wallet passes are not configured", http.StatusNotFound)
+		return
+	}
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil {
+		http.Error(w, "No QR code available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+
+	link, err := googleWalletSaveLink(sess)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "google wallet error", "err", err)
+		http.Error(w, "Failed to create Google Wallet pass", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, link, http.StatusFound)
+}
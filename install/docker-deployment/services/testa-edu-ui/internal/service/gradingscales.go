@@ -0,0 +1,203 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Grading scales. A GPA of "3.7" means little to a verifier in a country
+// that grades out of 5, in percentages or in classes of honours, so every
+// credential with a GPA records the scale it is on (the subject's
+// gpaScale, the scale's ID) and, with GPA_NORMALIZE, the GPA as a
+// percentage of the scale (gpaNormalized). The scales are published at
+// gradingScalesPath for verifiers to look IDs up.
+//
+// GRADING_SCALES_FILE replaces the built-in scales with a JSON array:
+//
+//	[{"id": "10.0", "name": "GPA out of 10", "min": 0, "max": 10},
+//	 {"id": "class-honours", "name": "Class honours", "grades": [
+//	   {"label": "First Class Honours", "percent": 85, "aliases": ["First"]}, ...]}]
+//
+// A numeric scale runs from min to max, its grades written with unit
+// ("%") if it has one, or out of max; an ordinal scale lists its grades,
+// best first, each with the percentage it is normalized to and the other
+// names it may be entered under. DEFAULT_GRADING_SCALE (4.0 unless set) is
+// the scale of a GPA issued without one.
+
+const gradingScalesPath = "/schemas/grading-scales.json"
+
+// GradingScale is a scale GPAs are given on.
+type GradingScale struct {
+	ID     string       `json:"id"`
+	Name   string       `json:"name"`
+	Min    float64      `json:"min,omitempty"`
+	Max    float64      `json:"max,omitempty"`
+	Unit   string       `json:"unit,omitempty"`
+	Grades []ScaleGrade `json:"grades,omitempty"`
+}
+
+// ScaleGrade is a grade of an ordinal scale.
+type ScaleGrade struct {
+	Label   string   `json:"label"`
+	Percent float64  `json:"percent"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+var defaultGradingScales = []GradingScale{
+	{ID: "4.0", Name: "GPA out of 4.0", Min: 0, Max: 4},
+	{ID: "5.0", Name: "GPA out of 5.0", Min: 0, Max: 5},
+	{ID: "percentage", Name: "Percentage", Min: 0, Max: 100, Unit: "%"},
+	{ID: "class-honours", Name: "Class honours", Grades: []ScaleGrade{
+		{Label: "First Class Honours", Percent: 85, Aliases: []string{"First", "1st"}},
+		{Label: "Second Class Honours (Upper Division)", Percent: 65, Aliases: []string{"Upper Second", "2:1", "2.1"}},
+		{Label: "Second Class Honours (Lower Division)", Percent: 55, Aliases: []string{"Lower Second", "2:2", "2.2"}},
+		{Label: "Third Class Honours", Percent: 45, Aliases: []string{"Third", "3rd"}},
+		{Label: "Pass", Percent: 40},
+	}},
+}
+
+// gradingScales are the scales in use.
+var gradingScales = defaultGradingScales
+
+// numericGrade is the form of a grade on a numeric scale.
+var numericGrade = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// LoadGradingScales reads a scales file, or returns the built-in scales for
+// "", and checks the default scale is among them.
+func LoadGradingScales(path, defaultScale string) ([]GradingScale, error) {
+	scales := defaultGradingScales
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading grading scales: %w", err)
+		}
+		scales = nil
+		if err := json.Unmarshal(data, &scales); err != nil {
+			return nil, fmt.Errorf("parsing grading scales: %w", err)
+		}
+	}
+	seen := map[string]bool{}
+	for _, s := range scales {
+		if s.ID == "" || s.Name == "" || seen[s.ID] {
+			return nil, fmt.Errorf("grading scales: every scale needs a name and an ID of its own (%q)", s.ID)
+		}
+		seen[s.ID] = true
+		if len(s.Grades) == 0 && s.Max <= s.Min {
+			return nil, fmt.Errorf("grading scales: %s needs grades, or a max above its min", s.ID)
+		}
+		for _, g := range s.Grades {
+			if g.Label == "" || g.Percent < 0 || g.Percent > 100 {
+				return nil, fmt.Errorf("grading scales: %s's grades need a label and a percent from 0 to 100", s.ID)
+			}
+		}
+	}
+	if !seen[defaultScale] {
+		return nil, fmt.Errorf("grading scales: DEFAULT_GRADING_SCALE %q is not one of them", defaultScale)
+	}
+	return scales, nil
+}
+
+// gradingScale looks a scale up by its ID.
+func gradingScale(id string) (GradingScale, bool) {
+	for _, s := range gradingScales {
+		if s.ID == id {
+			return s, true
+		}
+	}
+	return GradingScale{}, false
+}
+
+// grade reads a GPA on the scale: its value as issued, and as a percentage.
+func (s GradingScale) grade(gpa string) (string, float64, error) {
+	if len(s.Grades) > 0 {
+		for _, g := range s.Grades {
+			for _, name := range append([]string{g.Label}, g.Aliases...) {
+				if strings.EqualFold(gpa, name) {
+					return g.Label, g.Percent, nil
+				}
+			}
+		}
+		labels := make([]string, len(s.Grades))
+		for i, g := range s.Grades {
+			labels[i] = g.Label
+		}
+		return "", 0, fmt.Errorf("The GPA must be one of %s", strings.Join(labels, ", "))
+	}
+	v, err := strconv.ParseFloat(gpa, 64)
+	if err != nil || !numericGrade.MatchString(gpa) || v < s.Min || v > s.Max {
+		return "", 0, fmt.Errorf("The GPA must be a number from %s to %s", formatGrade(s.Min), formatGrade(s.Max))
+	}
+	return gpa, (v - s.Min) / (s.Max - s.Min) * 100, nil
+}
+
+// gradeForm checks the form's GPA against its scale (the default scale if
+// it names none), and records the scale and the normalized GPA. Without a
+// GPA there is nothing to record.
+func gradeForm(form *CredentialForm) error {
+	form.GPA, form.GPANormalized = strings.TrimSpace(form.GPA), ""
+	if form.GPA == "" {
+		form.GPAScale = ""
+		return nil
+	}
+	if form.GPAScale == "" {
+		form.GPAScale = config.DefaultGradingScale
+	}
+	scale, ok := gradingScale(form.GPAScale)
+	if !ok {
+		return fmt.Errorf("Unknown grading scale %q", form.GPAScale)
+	}
+	gpa, percent, err := scale.grade(form.GPA)
+	if err != nil {
+		return err
+	}
+	form.GPA = gpa
+	if config.GPANormalize {
+		form.GPANormalized = formatGrade(math.Round(percent*10) / 10)
+	}
+	return nil
+}
+
+// formatGrade writes a number without trailing zeros.
+func formatGrade(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// gradeText is a GPA as certificates and wallet passes show it: "3.7 / 4"
+// or "72%" on a numeric scale, the grade on an ordinal one.
+func gradeText(form CredentialForm) string {
+	scale, ok := gradingScale(form.GPAScale)
+	if form.GPA == "" || !ok || len(scale.Grades) > 0 {
+		return form.GPA
+	}
+	if scale.Unit != "" {
+		return form.GPA + scale.Unit
+	}
+	return form.GPA + " / " + formatGrade(scale.Max)
+}
+
+// ordinalGrades are the grades of the ordinal scales, offered as the
+// issuance form's GPA suggestions.
+func ordinalGrades() []string {
+	var labels []string
+	for _, s := range gradingScales {
+		for _, g := range s.Grades {
+			labels = append(labels, g.Label)
+		}
+	}
+	return labels
+}
+
+func handleGradingScales(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(gradingScales); err != nil {
+		slog.ErrorContext(r.Context(), "grading scales write", "err", err)
+	}
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestGradingScales checks GPAs are read on their scale, recorded with it
+// and normalized, and that bad scales files are refused.
+func TestGradingScales(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.DefaultGradingScale, config.GPANormalize = "4.0", true
+
+	for _, tc := range []struct {
+		gpa, scale         string
+		want, normalized   string
+		text, errSubstring string
+	}{
+		{gpa: "3.7", want: "3.7", normalized: "92.5", text: "3.7 / 4"},
+		{gpa: "4.2", scale: "5.0", want: "4.2", normalized: "84", text: "4.2 / 5"},
+		{gpa: "72", scale: "percentage", want: "72", normalized: "72", text: "72%"},
+		{gpa: "2:1", scale: "class-honours", want: "Second Class Honours (Upper Division)", normalized: "65", text: "Second Class Honours (Upper Division)"},
+		{gpa: "4.3", errSubstring: "from 0 to 4"},
+		{gpa: "-1", scale: "5.0", errSubstring: "from 0 to 5"},
+		{gpa: "Distinction", scale: "class-honours", errSubstring: "must be one of First Class Honours"},
+		{gpa: "3.0", scale: "10.0", errSubstring: "Unknown grading scale"},
+	} {
+		form := CredentialForm{GPA: tc.gpa, GPAScale: tc.scale}
+		err := gradeForm(&form)
+		if tc.errSubstring != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errSubstring) {
+				t.Errorf("%s on %q: error %v, want %q", tc.gpa, tc.scale, err, tc.errSubstring)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s on %q: %v", tc.gpa, tc.scale, err)
+			continue
+		}
+		if form.GPA != tc.want || form.GPANormalized != tc.normalized || gradeText(form) != tc.text {
+			t.Errorf("%s on %q: GPA %q normalized %q text %q", tc.gpa, tc.scale, form.GPA, form.GPANormalized, gradeText(form))
+		}
+	}
+
+	record := map[string]string{"studentName": "Jane Wanjiku", "institution": "Testa University", "degree": "BSc", "gpa": "3.9", "gpaNormalized": "100"}
+	form, err := issueForm(func(k string) string { return record[k] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.SubjectDID = "did:key:z6Mkjane"
+	credential := buildCredentialPayload(form, e2eIssuerDID, "Ed25519Signature2020")["credential"].(map[string]interface{})
+	subject := credential["credentialSubject"].(map[string]interface{})
+	if subject["gpa"] != "3.9" || subject["gpaScale"] != "4.0" || subject["gpaNormalized"] != "97.5" {
+		t.Errorf("subject %v", subject)
+	}
+
+	config.GPANormalize = false
+	form = CredentialForm{}
+	if err := gradeForm(&form); err != nil || form.GPAScale != "" {
+		t.Errorf("no GPA: scale %q, %v", form.GPAScale, err)
+	}
+	form = CredentialForm{GPA: "3.9"}
+	if err := gradeForm(&form); err != nil || form.GPANormalized != "" {
+		t.Errorf("normalized %q without GPA_NORMALIZE, %v", form.GPANormalized, err)
+	}
+
+	path := filepath.Join(t.TempDir(), "scales.json")
+	for name, bad := range map[string]string{
+		"default missing": `[{"id": "10.0", "name": "GPA out of 10", "max": 10}]`,
+		"empty range":     `[{"id": "4.0", "name": "GPA out of 4", "min": 4, "max": 4}]`,
+		"duplicate ID":    `[{"id": "4.0", "name": "GPA", "max": 4}, {"id": "4.0", "name": "GPA", "max": 4}]`,
+		"bad percent":     `[{"id": "4.0", "name": "GPA", "max": 4}, {"id": "letters", "name": "Letters", "grades": [{"label": "A", "percent": 120}]}]`,
+	} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := LoadGradingScales(path, "4.0"); err == nil || !strings.HasPrefix(err.Error(), "grading scales:") {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	os.WriteFile(path, []byte(`[{"id": "10.0", "name": "GPA out of 10", "max": 10}]`), 0o600)
+	if scales, err := LoadGradingScales(path, "10.0"); err != nil || len(scales) != 1 {
+		t.Errorf("scales %v, %v", scales, err)
+	}
+}
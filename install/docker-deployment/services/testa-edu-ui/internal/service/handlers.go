@@ -0,0 +1,734 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Session struct {
+	Form             CredentialForm
+	ProofType        string
+	Format           string
+	IssuerDID        string
+	TenantID         string
+	QRMode           string
+	QROptions        QROptions
+	ConnectionID     string
+	EncryptTo        string
+	Consent          *Consent
+	EncryptedPayload json.RawMessage
+	Token            string
+	SignedCredential json.RawMessage
+	Timestamp        []byte // RFC 3161 timestamp token of SignedCredential
+	Verified         bool
+	VerifyMessage    string
+	QR               *QRResult
+	CredentialID     string
+	ClaimToken       string
+	LinkURL          string
+	Exports          map[string][]byte
+	MdocEngagement   string
+	Email            string
+	Emailed          bool
+	EmailDeliveryID  string
+	Phone            string
+	Texted           bool
+	SMSDeliveryID    string
+	Deliveries       map[string]bool
+	Approval         *Approval // as submitted, with MAKER_CHECKER; see approvals.go
+	CreatedAt        time.Time
+
+	step      *stepCall    // the running sign or verify step
+	artifacts *artifactJob // made after signing; see artifacts.go
+}
+
+var (
+	sessions   = make(map[string]*Session)
+	sessionsMu sync.RWMutex
+)
+
+// expireSessions drops sessions started before cutoff. Sessions are purged
+// by the retention engine (see retention.go).
+func expireSessions(cutoff time.Time, dryRun bool) (int, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	n := 0
+	for id, s := range sessions {
+		if s.CreatedAt.Before(cutoff) {
+			n++
+			if !dryRun {
+				delete(sessions, id)
+			}
+		}
+	}
+	return n, nil
+}
+
+func newSessionID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// getSession returns the request's wizard session, picking up an issuance
+// held for approval whose session has since expired (see approvals.go).
+func getSession(r *http.Request) *Session {
+	cookie, err := r.Cookie("sid")
+	if err != nil {
+		return nil
+	}
+	sessionsMu.RLock()
+	sess := sessions[cookie.Value]
+	sessionsMu.RUnlock()
+	if sess != nil || approvals == nil {
+		return sess
+	}
+	held, ok := approvals.Issuance(cookie.Value)
+	if !ok {
+		return nil
+	}
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	if sess := sessions[cookie.Value]; sess != nil {
+		return sess
+	}
+	sessions[cookie.Value] = held
+	return held
+}
+
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("tenant")
+	if id == "" {
+		id = hostTenant(r)
+	}
+	renderIndex(w, r, id, nil)
+}
+
+// renderIndex renders the issuance form of a tenant, or of the deployment
+// with tenantID empty, with the fields of prefill filled in.
+func renderIndex(w http.ResponseWriter, r *http.Request, tenantID string, prefill map[string]string) {
+	if prefill == nil {
+		prefill = map[string]string{}
+	}
+	if !liveConfig().MaintenanceMode && agentClient.unavailable() {
+		agentUnavailable(w, r)
+		return
+	}
+	data := map[string]interface{}{
+		"ProofTypes":       offeredProofTypes(),
+		"DefaultProofType": proofTypeFor(config.IssuerDID),
+		"Issuers":          issuerOptions(),
+		"DefaultQRMode":    liveConfig().QRMode,
+		"QROptions":        defaultQROptions(),
+		"QRLevels":         []string{"L", "M", "Q", "H"},
+		"ConsentTermsURL":  liveConfig().ConsentTermsURL,
+		"MakerChecker":     config.MakerChecker,
+		"Backdating":       config.MaxBackdate > 0,
+		"GradingScales":    gradingScales,
+		"DefaultScale":     config.DefaultGradingScale,
+		"OrdinalGrades":    ordinalGrades(),
+		"Prefill":          prefill,
+	}
+	if tenantID != "" {
+		t, ok := tenants.Get(tenantID)
+		if !ok {
+			pageNotFound(w, r)
+			return
+		}
+		data["Tenant"] = t
+		data["Theme"] = themeFor(t.ID)
+		data["Issuers"] = []IssuerOption{{Name: t.Name, DID: t.Issuer()}}
+		data["DefaultProofType"] = proofTypeFor(t.Issuer())
+	}
+	if err := pages(r).ExecuteTemplate(w, "layout", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// issueForm reads the credential fields of the issuance form, or of a
+// record with the form's field names or their aliases (see fieldmap.go).
+func issueForm(value func(string) string) (CredentialForm, error) {
+	var form CredentialForm
+	readMappedFields(&form, value)
+	if form.StudentName == "" || form.Institution == "" || form.Degree == "" {
+		return form, errors.New("Student name, institution, and degree are required")
+	}
+	if err := gradeForm(&form); err != nil {
+		return form, err
+	}
+	if v := value("issuanceDate"); v != "" {
+		issued, err := parseIssuanceDate(v, form.EnrollmentDate, time.Now())
+		if err != nil {
+			return form, err
+		}
+		form.IssuedAt = issued
+	}
+	return form, nil
+}
+
+func handleIssueStart(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		renderFragment(w, r, "error", "Invalid form data")
+		return
+	}
+
+	form, err := issueForm(r.FormValue)
+	if err != nil {
+		renderFragment(w, r, "error", err.Error())
+		return
+	}
+
+	if holderDID := strings.TrimSpace(r.FormValue("holderDid")); holderDID != "" {
+		if err := bindHolder(holderDID, r.FormValue("holderNonce"), strings.TrimSpace(r.FormValue("holderProof"))); err != nil {
+			renderFragment(w, r, "error", "Holder DID: "+err.Error())
+			return
+		}
+		form.SubjectDID = holderDID
+	}
+
+	consent, err := parseConsent(r, form)
+	if err != nil {
+		renderFragment(w, r, "error", err.Error())
+		return
+	}
+
+	tenant, err := formTenant(r)
+	if err != nil {
+		renderFragment(w, r, "error", err.Error())
+		return
+	}
+	var issuerDID, tenantID string
+	if tenant != nil {
+		if !tenant.Issues(form.Degree) {
+			renderFragment(w, r, "error", tenant.Name+" does not issue "+form.Degree)
+			return
+		}
+		issuerDID, tenantID = tenant.Issuer(), tenant.ID
+		form.Institution = tenant.Name
+	} else if issuerDID, err = resolveIssuerDID(r.FormValue("issuerDid")); err != nil {
+		renderFragment(w, r, "error", err.Error())
+		return
+	}
+	proofType, err := resolveProofType(r.FormValue("proofType"), issuerDID)
+	if err != nil {
+		renderFragment(w, r, "error", err.Error())
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = FormatLDP
+	}
+	if !validFormat(format) {
+		renderFragment(w, r, "error", "Unsupported credential format")
+		return
+	}
+	qrMode := r.FormValue("qrMode")
+	if qrMode == "" {
+		qrMode = liveConfig().QRMode
+	}
+	if !validQRMode(qrMode) {
+		renderFragment(w, r, "error", "Unsupported QR mode")
+		return
+	}
+	if qrMode == QRModeLink && !consent.Allows(ConsentScopeStore) {
+		renderFragment(w, r, "error", "Link mode keeps the credential on this portal, which needs the student's consent to storage")
+		return
+	}
+	qrOpts, err := parseQROptions(r.FormValue("qrErrorCorrection"), r.FormValue("qrModuleSize"),
+		r.FormValue("qrQuietZone"), r.FormValue("qrLogo") != "", defaultQROptions())
+	if err != nil {
+		renderFragment(w, r, "error", err.Error())
+		return
+	}
+	connectionID := r.FormValue("connectionId")
+	if format == FormatAnonCreds && connectionID == "" {
+		renderFragment(w, r, "error", "AnonCreds issuance requires the holder's DIDComm connection ID")
+		return
+	}
+	encryptTo := strings.TrimSpace(r.FormValue("encryptTo"))
+	if encryptTo != "" {
+		if _, err := holderAgreementKey(encryptTo); err != nil {
+			renderFragment(w, r, "error", "Holder encryption key: "+err.Error())
+			return
+		}
+		if qrMode == QRModeCBOR {
+			renderFragment(w, r, "error", "Encrypted credentials cannot use CBOR QR mode; choose compact or link")
+			return
+		}
+	}
+
+	email := strings.TrimSpace(r.FormValue("studentEmail"))
+	if email != "" {
+		if _, err := mail.ParseAddress(email); err != nil {
+			renderFragment(w, r, "error", "Invalid student email address")
+			return
+		}
+	}
+
+	phone := normalizePhone(r.FormValue("studentPhone"))
+	if phone != "" && !e164.MatchString(phone) {
+		renderFragment(w, r, "error", "Student phone numbers must be in international format, e.g. +254712345678")
+		return
+	}
+
+	var maker staffUser
+	if config.MakerChecker {
+		if maker, err = approvalMaker(r, tenantID); err != nil {
+			renderFragment(w, r, "error", err.Error())
+			return
+		}
+	}
+
+	// Only mint a DID for the student once the request is known to be good,
+	// so rejected forms leave nothing behind in the subject store.
+	if form.SubjectDID == "" {
+		did, err := subjects.DIDFor(form)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "subject DID error", "err", err)
+			renderFragment(w, r, "error", "Failed to create the student's DID")
+			return
+		}
+		form.SubjectDID = did
+		consent.SubjectDID = did
+	}
+
+	if err := consents.Put(consent); err != nil {
+		slog.ErrorContext(r.Context(), "consent error", "err", err)
+		renderFragment(w, r, "error", "Failed to record consent")
+		return
+	}
+
+	sid := newSessionID()
+	sess := &Session{
+		Form:         form,
+		ProofType:    proofType,
+		Format:       format,
+		IssuerDID:    issuerDID,
+		TenantID:     tenantID,
+		QRMode:       qrMode,
+		QROptions:    qrOpts,
+		ConnectionID: connectionID,
+		EncryptTo:    encryptTo,
+		Consent:      consent,
+		Email:        email,
+		Phone:        phone,
+		CreatedAt:    time.Now(),
+	}
+	if config.MakerChecker {
+		if err := submitApproval(r.Context(), maker, sid, sess); err != nil {
+			slog.ErrorContext(r.Context(), "approval error", "err", err)
+			renderFragment(w, r, "error", "Failed to submit the issuance for approval")
+			return
+		}
+	}
+	sessionsMu.Lock()
+	sessions[sid] = sess
+	sessionsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     "sid",
+		Value:    sid,
+		Path:     appPath("/"),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	data := map[string]interface{}{"Form": form, "ProofType": proofType, "Format": format}
+	renderFragment(w, r, "progress", data)
+}
+
+// renderStepError shows a wizard step's error, counting the failure.
+func renderStepError(w http.ResponseWriter, r *http.Request, step, message string) {
+	stepFailuresTotal.inc(step)
+	renderFragment(w, r, "step-"+step, map[string]interface{}{"Error": message})
+}
+
+func handleStepToken(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil {
+		renderStepError(w, r, "token", "Session expired. Please start over.")
+		return
+	}
+
+	agent := agentClient.WithContext(r.Context())
+	token, err := agent.GetToken()
+	if err != nil {
+		renderStepError(w, r, "token", agentFailure(r.Context(), "token error", err))
+		return
+	}
+
+	sessionsMu.Lock()
+	sess.Token = token
+	sessionsMu.Unlock()
+
+	renderFragment(w, r, "step-token", map[string]interface{}{"Success": true})
+}
+
+func handleStepSign(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil {
+		renderStepError(w, r, "sign", "Session expired. Please start over.")
+		return
+	}
+	if a := approvalHold(sess); a != nil {
+		switch a.State {
+		case ApprovalRejected:
+			renderStepError(w, r, "sign", "The issuance request was rejected: "+a.Reason)
+		case ApprovalExpired:
+			renderStepError(w, r, "sign", "The issuance request expired before it was approved. Please start over.")
+		default:
+			renderFragment(w, r, "step-sign", map[string]interface{}{"Pending": a.ID})
+		}
+		return
+	}
+	if sess.Token == "" {
+		// An issuance picked up after approval has no token of its own.
+		token, err := agentClient.WithContext(r.Context()).GetToken()
+		if err != nil {
+			renderStepError(w, r, "sign", agentFailure(r.Context(), "token error", err))
+			return
+		}
+		sessionsMu.Lock()
+		sess.Token = token
+		sessionsMu.Unlock()
+	}
+
+	form, err := credentialForm(sess)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "PII hashing", "err", err)
+		renderStepError(w, r, "sign", "Failed to prepare credential")
+		return
+	}
+	payload := buildCredentialPayload(form, sess.IssuerDID, sess.ProofType)
+	addConsentToCredential(payload, sess.Consent)
+	if err := beforeSign(r.Context(), sess, payload); err != nil {
+		slog.ErrorContext(r.Context(), "sign hook", "err", err)
+		renderStepError(w, r, "sign", err.Error())
+		return
+	}
+	err = credSchema.Validate(payload["credential"])
+	if err != nil {
+		slog.ErrorContext(r.Context(), "schema validation", "err", err)
+		renderStepError(w, r, "sign", err.Error())
+		return
+	}
+
+	ctx, done := stepContext(r, sess)
+	defer done()
+	signed, err := signSession(agentClient.WithContext(ctx), sess, form, payload)
+	if err != nil {
+		renderStepError(w, r, "sign", agentFailure(r.Context(), "sign error", err))
+		return
+	}
+
+	sessionsMu.Lock()
+	sess.SignedCredential = signed
+	sessionsMu.Unlock()
+	if sess.Approval != nil {
+		if err := approvals.Signed(sess.Approval.ID); err != nil {
+			slog.ErrorContext(r.Context(), "approval", "err", err)
+		}
+	}
+	afterSign(r.Context(), sess, signed)
+	issuancesTotal.inc(sess.Format)
+	timestamp := timestampIssued(sess)
+	sessionsMu.Lock()
+	sess.Timestamp = timestamp
+	sessionsMu.Unlock()
+	notifyIssued(sess)
+	anchorIssued(sess)
+	startArtifacts(sess, requestLanguage(r))
+
+	renderFragment(w, r, "step-sign", map[string]interface{}{"Success": true})
+}
+
+func handleStepVerify(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil {
+		renderStepError(w, r, "verify", "Session expired. Please start over.")
+		return
+	}
+
+	if sdjwt, ok := sdjwtString(sess); ok {
+		if err := checkDisclosures(sdjwt); err != nil {
+			slog.ErrorContext(r.Context(), "verify error", "err", err)
+			renderStepError(w, r, "verify", err.Error())
+			return
+		}
+	}
+
+	// AnonCreds credentials are held only by the wallet; there is nothing
+	// to verify here, so report the exchange state instead.
+	if sess.Format == FormatAnonCreds {
+		renderFragment(w, r, "step-verify", map[string]interface{}{
+			"Verified": true,
+			"Message":  "credential offered over DIDComm",
+		})
+		return
+	}
+
+	ctx, done := stepContext(r, sess)
+	defer done()
+	agent := agentClient.WithContext(ctx)
+	verification, err := agent.VerifyCredential(sess.Token, sess.SignedCredential)
+	if err != nil {
+		renderStepError(w, r, "verify", agentFailure(r.Context(), "verify error", err))
+		return
+	}
+
+	sessionsMu.Lock()
+	sess.Verified = verification.Verified
+	sess.VerifyMessage = verification.Message()
+	sessionsMu.Unlock()
+	startVerifiedArtifacts(sess)
+
+	renderFragment(w, r, "step-verify", map[string]interface{}{
+		"Verified": verification.Verified,
+		"Message":  verification.Message(),
+		"Checks":   verification.Checks,
+	})
+}
+
+func handleStepQR(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil {
+		renderStepError(w, r, "qr", "Session expired. Please start over.")
+		return
+	}
+
+	if sess.Format == FormatAnonCreds {
+		handleStepOffer(w, r, sess)
+		return
+	}
+
+	_, span := startSpan(r.Context(), "generate QR", spanKindInternal)
+	span.set("qr.mode", sess.QRMode)
+	qr, err := sessionQR(r.Context(), sess)
+	span.end(err)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "QR error", "err", err)
+		renderStepError(w, r, "qr", err.Error())
+		return
+	}
+
+	sessionsMu.Lock()
+	if sess.QR != qr { // the pipeline's is set already, and being read
+		sess.QR = qr
+	}
+	autoEmail := sess.Email != "" && !sess.Emailed && mailEnabled()
+	sess.Emailed = sess.Emailed || autoEmail
+	emailDeliveryID := sess.EmailDeliveryID
+	autoSMS := sess.Phone != "" && !sess.Texted && smsEnabled() && sess.Consent.Allows(ConsentScopeStore)
+	sess.Texted = sess.Texted || autoSMS
+	smsDeliveryID := sess.SMSDeliveryID
+	sessionsMu.Unlock()
+
+	// Email the student once, if they gave an address.
+	var emailDelivery map[string]interface{}
+	if autoEmail {
+		if d, err := queueCredentialEmail(sess, sess.Email); err != nil {
+			slog.ErrorContext(sessionLogContext(r, sess), "email delivery error", "err", err)
+			emailDelivery = map[string]interface{}{"Error": "Email not sent: " + err.Error()}
+		} else {
+			sessionsMu.Lock()
+			sess.EmailDeliveryID = d.ID
+			sessionsMu.Unlock()
+			emailDelivery = map[string]interface{}{"Delivery": d}
+		}
+	} else if d, ok := deliveries.Get(emailDeliveryID); ok {
+		emailDelivery = map[string]interface{}{"Delivery": d}
+	}
+
+	// Text a claim link once, if they gave a number.
+	var smsDelivery map[string]interface{}
+	if autoSMS {
+		if d, err := queueClaimSMS(sess, sess.Phone); err != nil {
+			slog.ErrorContext(sessionLogContext(r, sess), "SMS delivery error", "err", err)
+			smsDelivery = map[string]interface{}{"Error": "SMS not sent: " + err.Error()}
+		} else {
+			sessionsMu.Lock()
+			sess.SMSDeliveryID = d.ID
+			sessionsMu.Unlock()
+			smsDelivery = map[string]interface{}{"Delivery": d}
+		}
+	} else if d, ok := deliveries.Get(smsDeliveryID); ok {
+		smsDelivery = map[string]interface{}{"Delivery": d}
+	}
+
+	var disclosures []Disclosure
+	if sdjwt, ok := sdjwtString(sess); ok {
+		_, disclosures, _ = splitSDJWT(sdjwt)
+	}
+	var bbsRevealable []string
+	if sess.Format == FormatLDP && sess.ProofType == bbsProofType {
+		bbsRevealable = bbsFields(sess.SignedCredential)
+	}
+
+	renderFragment(w, r, "step-qr", map[string]interface{}{
+		"QRPngBase64":    qr.QRPngBase64,
+		"AnimatedGIF":    base64.StdEncoding.EncodeToString(qr.AnimatedGIF),
+		"FrameCount":     len(qr.Frames),
+		"CredentialJSON": prettyCredential(sess.SignedCredential),
+		"IsJWT":          sess.Format == FormatJWT,
+		"IsSDJWT":        sess.Format == FormatSDJWT,
+		"IsCompact":      sess.QRMode == QRModeCompact,
+		"IsCBOR":         sess.QRMode == QRModeCBOR,
+		"IsLink":         sess.QRMode == QRModeLink,
+		"LinkURL":        sess.LinkURL,
+		"EncryptedFor":   sess.EncryptTo,
+		"Disclosures":    disclosures,
+		"BBSFields":      bbsRevealable,
+		"Exports":        exports,
+		"AppleWallet":    appleWalletEnabled(),
+		"GoogleWallet":   googleWalletEnabled(),
+		"ORCID":          orcidEnabled(),
+		"ShareLinkTTL":   liveConfig().ShareLinkTTL,
+		"MailEnabled":    mailEnabled(),
+		"StudentEmail":   sess.Email,
+		"EmailDelivery":  emailDelivery,
+		"SMSEnabled":     smsEnabled(),
+		"StudentPhone":   sess.Phone,
+		"SMSDelivery":    smsDelivery,
+		"CanStore":       sess.Consent.Allows(ConsentScopeStore),
+		"Sizes": map[string]int{
+			"JSONLD": qr.Sizes.JSONLD,
+			"JSONXT": qr.Sizes.JSONXT,
+			"QRData": qr.Sizes.QRData,
+		},
+	})
+}
+
+func handleDownloadQRPNG(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil {
+		renderErrorPage(w, r, http.StatusNotFound, "No QR code available. Please issue a credential first.")
+		return
+	}
+
+	pngData, err := base64.StdEncoding.DecodeString(sess.QR.QRPngBase64)
+	if err != nil {
+		renderErrorPage(w, r, http.StatusInternalServerError, "Failed to decode QR image")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential-qr.png\"")
+	w.Write(pngData)
+}
+
+func handleDownloadJSON(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderErrorPage(w, r, http.StatusNotFound, "No credential available. Please issue a credential first.")
+		return
+	}
+
+	var prettyJSON bytes.Buffer
+	json.Indent(&prettyJSON, sess.SignedCredential, "", "  ")
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.json\"")
+	w.Write(prettyJSON.Bytes())
+}
+
+func handleDownloadJWT(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderErrorPage(w, r, http.StatusNotFound, "No credential available. Please issue a credential first.")
+		return
+	}
+	jwt, ok := compactJWT(sess.SignedCredential)
+	if !ok {
+		renderErrorPage(w, r, http.StatusNotFound, "This credential was not issued as a JWT.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/jwt")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.jwt\"")
+	w.Write([]byte(jwt))
+}
+
+func handleDownloadJSONXT(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil || sess.QR.JSONXTUri == "" {
+		renderErrorPage(w, r, http.StatusNotFound, "No JSON-XT encoding available for this credential.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.jsonxt\"")
+	w.Write([]byte(sess.QR.JSONXTUri))
+}
+
+func handleDownloadPDF(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderErrorPage(w, r, http.StatusNotFound, "No credential available. Please issue a credential first.")
+		return
+	}
+
+	_, span := startSpan(r.Context(), "generate PDF", spanKindInternal)
+	pdfBytes, err := sessionPDF(r.Context(), sess, requestLanguage(r))
+	span.end(err)
+	if err != nil {
+		slog.ErrorContext(sessionLogContext(r, sess), "PDF error", "err", err)
+		renderErrorPage(w, r, http.StatusInternalServerError, "Failed to generate PDF")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.pdf\"")
+	w.Write(pdfBytes)
+}
+
+// signSession has the agent sign a session's credential payload in the
+// session's format, or offer it over DIDComm for AnonCreds.
+func signSession(agent *AgentClient, sess *Session, form CredentialForm, payload map[string]interface{}) (json.RawMessage, error) {
+	switch sess.Format {
+	case FormatJWT:
+		jwt, err := agent.SignCredentialJWT(sess.Token, payload, jwtAlgFor(sess.ProofType))
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(jwt)
+	case FormatSDJWT:
+		return signSDJWT(agent, sess.Token, payload, sess.ProofType)
+	case FormatAnonCreds:
+		credDefID, err := ensureCredentialDefinition(agent, sess.Token)
+		if err != nil {
+			return nil, err
+		}
+		return agent.OfferAnonCredsCredential(sess.Token, sess.ConnectionID, credDefID, anonCredsValues(form))
+	}
+	return agent.SignCredential(sess.Token, payload)
+}
+
+// signSDJWT turns the built credential into SD-JWT VC claims, has the agent
+// sign the issuer JWT and appends the disclosures.
+func signSDJWT(agent *AgentClient, token string, payload map[string]interface{}, proofType string) (json.RawMessage, error) {
+	credential, _ := payload["credential"].(map[string]interface{})
+	claims, disclosures, err := buildSDJWTClaims(credential, liveConfig().SDClaims)
+	if err != nil {
+		return nil, err
+	}
+	vm, _ := payload["verificationMethod"].(string)
+	issuerJWT, err := agent.SignSDJWT(token, claims, vm, jwtAlgFor(proofType))
+	if err != nil {
+		return nil, err
+	}
+	// Some agents echo the combined format; keep only the issuer JWT.
+	issuerJWT, _, _ = strings.Cut(issuerJWT, "~")
+	return json.Marshal(assembleSDJWT(issuerJWT, disclosures))
+}
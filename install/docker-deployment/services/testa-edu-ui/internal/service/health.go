@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Health. GET /health answers {"status":"ok"} while the service runs, for
+// load balancers and container health checks. GET /health?deep=true also
+// checks what issuance depends on, each with its status and latency:
+//
+//   - agent: a token can be had from the agent's token endpoint (or the
+//     OAuth2 token endpoint); skipped when signing locally.
+//   - node: NODE_BIN runs, for the QR and certificate scripts.
+//   - store: DATA_DIR, where the stores are kept, can be written.
+//
+// A failed check makes the answer 503, with status "fail". Why a check
+// failed is logged, not answered: /health is open to anyone. Deep results
+// are cached for a few seconds, so polling does not hammer the agent.
+//
+// Orchestrators get a probe for each question they ask. GET /livez answers
+// 200 while the process serves at all: failing it means restart me. GET
+// /readyz answers 200 only once the templates are loaded and the workers
+// started, while the deep checks pass and this process leads (leader.go)
+// and until shutdown begins: failing it means route no traffic here yet,
+// or any more.
+
+const (
+	healthCheckTimeout = 5 * time.Second
+	healthCacheTTL     = 10 * time.Second
+)
+
+// healthCheck is a dependency's status in a deep health check.
+type healthCheck struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+type healthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]healthCheck `json:"checks,omitempty"`
+}
+
+// ready is set once the service has started, and cleared as it shuts
+// down.
+var ready atomic.Bool
+
+var (
+	healthMu sync.Mutex
+	// lastHealth is the last deep report, made at lastHealthAt.
+	lastHealth   healthReport
+	lastHealthAt time.Time
+)
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	report := healthReport{Status: "ok"}
+	if r.URL.Query().Get("deep") == "true" {
+		report = deepHealth(r.Context())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+func handleLivez(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report := deepHealth(r.Context())
+	checks := map[string]healthCheck{}
+	for name, c := range report.Checks {
+		checks[name] = c
+	}
+	report.Checks = checks
+	for name, ok := range map[string]bool{
+		"templates": len(live().pageSets) > 0,
+		"workers":   ready.Load(),
+		"leader":    isLeader(),
+	} {
+		if ok {
+			checks[name] = healthCheck{Status: "ok"}
+		} else {
+			checks[name] = healthCheck{Status: "fail"}
+			report.Status = "fail"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// isProbePath reports whether path is one of the health probes, which are
+// polled, and served over plain HTTP even when it redirects to HTTPS.
+func isProbePath(path string) bool {
+	return path == "/health" || path == "/livez" || path == "/readyz"
+}
+
+// deepHealth checks the dependencies, or returns the last report while it
+// is fresh.
+func deepHealth(ctx context.Context) healthReport {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if time.Since(lastHealthAt) < healthCacheTTL {
+		return lastHealth
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	checks := map[string]func(context.Context) error{
+		"node":  checkNode,
+		"store": checkStore,
+	}
+	if agentClient != nil && agentClient.local == nil {
+		checks["agent"] = checkAgentToken
+	}
+	report := healthReport{Status: "ok", Checks: map[string]healthCheck{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			c := healthCheck{Status: "ok", LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				c.Status = "fail"
+				slog.WarnContext(ctx, "health check failed", "check", name, "err", err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[name] = c
+			if err != nil {
+				report.Status = "fail"
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	lastHealth, lastHealthAt = report, time.Now()
+	return report
+}
+
+// checkAgentToken gets a fresh token from the agent, bypassing the cache.
+func checkAgentToken(ctx context.Context) error {
+	_, _, err := agentClient.WithContext(ctx).fetchToken()
+	return err
+}
+
+// checkNode runs NODE_BIN --version.
+func checkNode(ctx context.Context) error {
+	return exec.CommandContext(ctx, config.NodeBin, "--version").Run()
+}
+
+// checkStore writes and removes a file in DATA_DIR.
+func checkStore(ctx context.Context) error {
+	f, err := os.CreateTemp(config.DataDir, ".health-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString("ok"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,103 @@
+package service
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestDeepHealth checks each dependency is reported, and a failing one
+// fails the check.
+func TestDeepHealth(t *testing.T) {
+	mock := mockagent.New()
+	url := mock.Start(t)
+	saved, savedClient := config, agentClient
+	t.Cleanup(func() {
+		config, agentClient = saved, savedClient
+		lastHealthAt = time.Time{}
+	})
+	config.DataDir = t.TempDir()
+	config.NodeBin = "true"
+	agentClient = &AgentClient{BaseURL: url, client: http.DefaultClient}
+
+	get := func(target string) (int, healthReport) {
+		t.Helper()
+		lastHealthAt = time.Time{}
+		rec := httptest.NewRecorder()
+		handleHealth(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var report healthReport
+		if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: %v", rec.Body, err)
+		}
+		return rec.Code, report
+	}
+
+	if code, report := get("/health"); code != http.StatusOK || report.Status != "ok" || report.Checks != nil {
+		t.Errorf("shallow health %d %+v", code, report)
+	}
+	code, report := get("/health?deep=true")
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("deep health %d %+v", code, report)
+	}
+	for _, name := range []string{"agent", "node", "store"} {
+		if c, ok := report.Checks[name]; !ok || c.Status != "ok" {
+			t.Errorf("%s check %+v", name, c)
+		}
+	}
+
+	mock.Fail("/agent/token", http.StatusServiceUnavailable, 1)
+	config.NodeBin = "false"
+	code, report = get("/health?deep=true")
+	if code != http.StatusServiceUnavailable || report.Status != "fail" {
+		t.Errorf("failing health %d %+v", code, report)
+	}
+	if report.Checks["agent"].Status != "fail" || report.Checks["node"].Status != "fail" || report.Checks["store"].Status != "ok" {
+		t.Errorf("checks %+v", report.Checks)
+	}
+}
+
+// TestReadyz checks the service is live throughout, but ready only once
+// started and until it shuts down.
+func TestReadyz(t *testing.T) {
+	saved, savedClient, savedLive := config, agentClient, current.Load()
+	t.Cleanup(func() {
+		config, agentClient = saved, savedClient
+		current.Store(savedLive)
+		lastHealthAt = time.Time{}
+		ready.Store(false)
+	})
+	config.DataDir = t.TempDir()
+	config.NodeBin = "true"
+	agentClient = &AgentClient{local: &LocalSigner{}}
+	current.Store(&snapshot{config: &config})
+
+	probe := func(h http.HandlerFunc) int {
+		lastHealthAt = time.Time{}
+		rec := httptest.NewRecorder()
+		h(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	if code := probe(handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("ready before starting: %d", code)
+	}
+	ready.Store(true)
+	if code := probe(handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("ready without templates: %d", code)
+	}
+	current.Store(&snapshot{config: &config, pageSets: map[string]*template.Template{"en": template.New("layout")}})
+	if code := probe(handleReadyz); code != http.StatusOK {
+		t.Errorf("not ready once started: %d", code)
+	}
+	config.NodeBin = "false"
+	if code := probe(handleReadyz); code != http.StatusServiceUnavailable {
+		t.Errorf("ready with a failing dependency: %d", code)
+	}
+	if code := probe(handleLivez); code != http.StatusOK {
+		t.Errorf("not live: %d", code)
+	}
+}
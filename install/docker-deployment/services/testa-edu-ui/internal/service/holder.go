@@ -0,0 +1,205 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Holder binding: the student may supply their own DID instead of having
+// one assigned. Before issuing to it, the portal checks the student
+// controls it with a signed challenge (the same DID-auth JWT used for
+// credential retrieval). The proof can be pasted into the issuance form,
+// or a wallet can scan the challenge QR and post its proof to /holder/proof.
+//
+// Challenges are stateless, like retrieval challenges: the nonce carries
+// its expiry and a MAC, so asking for one grows no server state. A nonce is
+// remembered only once a valid proof has used it, until it expires, which
+// keeps it single-use and lets the form claim a proof a wallet posted.
+// Posted proofs are rate limited per client, and at most holderProofsMax
+// nonces are remembered at once.
+
+const (
+	holderChallengeTTL = 10 * time.Minute
+	holderProofsMax    = 10000
+)
+
+type holderProof struct {
+	expires time.Time
+	holder  string // the DID a wallet proved control of
+	spent   bool   // consumed by an issuance form
+}
+
+var (
+	holderProofs       = make(map[string]*holderProof) // nonce → proof
+	holderProofsMu     sync.Mutex
+	holderProofLimiter = newRateLimiter(30, time.Minute)
+)
+
+var errHolderChallengeUsed = errors.New("the challenge has already been used; request a new one")
+
+func holderAudience() string {
+	return config.PublicURL + "/holder"
+}
+
+// newHolderChallenge returns expiry ‖ random ‖ MAC, base64url encoded.
+func newHolderChallenge() string {
+	b := make([]byte, 8+16, 8+16+16)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().Add(holderChallengeTTL).Unix()))
+	rand.Read(b[8:])
+	return base64.RawURLEncoding.EncodeToString(append(b, holderChallengeMAC(b)...))
+}
+
+func holderChallengeMAC(b []byte) []byte {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte("holder-challenge\x00"))
+	mac.Write(b)
+	return mac.Sum(nil)[:16]
+}
+
+// checkHolderChallenge reports whether nonce was issued here and is
+// unexpired, and when it expires.
+func checkHolderChallenge(nonce string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+16+16 {
+		return time.Time{}, false
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	if !hmac.Equal(b[24:], holderChallengeMAC(b[:24])) || !time.Now().Before(expires) {
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// verifyHolderProof checks a DID-auth JWT against an unexpired challenge
+// and returns the proven holder DID and the challenge.
+func verifyHolderProof(proof string) (holder, nonce string, expires time.Time, err error) {
+	_, payload, err := decodeJWT(proof)
+	if err != nil {
+		return "", "", time.Time{}, err
+	}
+	nonce, _ = payload["nonce"].(string)
+	expires, ok := checkHolderChallenge(nonce)
+	if !ok {
+		return "", "", time.Time{}, fmt.Errorf("unknown or expired challenge")
+	}
+	if holder, err = verifyDIDAuth(proof, holderAudience(), nonce); err != nil {
+		return "", "", time.Time{}, err
+	}
+	return holder, nonce, expires, nil
+}
+
+// rememberHolderProof records a proof for nonce, refusing a nonce a proof
+// has already used (unless the form is claiming a wallet's proof) and new
+// nonces while holderProofsMax are remembered.
+func rememberHolderProof(nonce string, p *holderProof) error {
+	holderProofsMu.Lock()
+	defer holderProofsMu.Unlock()
+	now := time.Now()
+	for n, old := range holderProofs {
+		if now.After(old.expires) {
+			delete(holderProofs, n)
+		}
+	}
+	if old, ok := holderProofs[nonce]; ok {
+		if old.spent || !p.spent {
+			return errHolderChallengeUsed
+		}
+	} else if len(holderProofs) >= holderProofsMax {
+		return fmt.Errorf("too many outstanding challenges; try again shortly")
+	}
+	holderProofs[nonce] = p
+	return nil
+}
+
+// bindHolder confirms the student controls holderDID, from either a proof
+// submitted with the form or one a wallet posted for nonce. The challenge
+// is consumed.
+func bindHolder(holderDID, nonce, proof string) error {
+	if proof != "" {
+		holder, proofNonce, expires, err := verifyHolderProof(proof)
+		if err != nil {
+			return err
+		}
+		if holder != holderDID {
+			return fmt.Errorf("proof is signed by %s, not %s", holder, holderDID)
+		}
+		return rememberHolderProof(proofNonce, &holderProof{expires: expires, holder: holder, spent: true})
+	}
+
+	if _, ok := checkHolderChallenge(nonce); !ok {
+		return fmt.Errorf("the challenge has expired; request a new one")
+	}
+	holderProofsMu.Lock()
+	defer holderProofsMu.Unlock()
+	p, ok := holderProofs[nonce]
+	switch {
+	case ok && p.spent:
+		return errHolderChallengeUsed
+	case !ok || p.holder != holderDID:
+		return fmt.Errorf("no proof of control received for %s", holderDID)
+	}
+	p.spent = true
+	return nil
+}
+
+func handleHolderChallenge(w http.ResponseWriter, r *http.Request) {
+	nonce := newHolderChallenge()
+	request, _ := json.Marshal(map[string]string{
+		"type":     "DIDAuthRequest",
+		"aud":      holderAudience(),
+		"nonce":    nonce,
+		"response": config.PublicURL + "/holder/proof",
+	})
+	data := map[string]interface{}{
+		"Nonce":    nonce,
+		"Audience": holderAudience(),
+		"Minutes":  int(holderChallengeTTL.Minutes()),
+	}
+	if png, err := renderQRPNG(string(request), 384, QROptions{ErrorCorrection: "M", QuietZone: liveConfig().QRQuietZone}); err == nil {
+		data["QR"] = base64.StdEncoding.EncodeToString(png)
+	} else {
+		slog.ErrorContext(r.Context(), "holder challenge QR error", "err", err)
+	}
+	pages(r).ExecuteTemplate(w, "holder-challenge", data)
+}
+
+// handleHolderProof accepts a wallet's DID-auth JWT for a challenge, as a
+// raw body or a "proof" form field.
+func handleHolderProof(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !holderProofLimiter.Allow(clientIP(r)) {
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]string{"error": "too many proofs; try again in a minute"})
+		return
+	}
+	var proof string
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		proof = r.FormValue("proof")
+	} else {
+		body, _ := io.ReadAll(io.LimitReader(r.Body, 8<<10))
+		proof = strings.TrimSpace(string(body))
+	}
+	holder, nonce, expires, err := verifyHolderProof(proof)
+	if err == nil {
+		err = rememberHolderProof(nonce, &holderProof{expires: expires, holder: holder})
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "holder proof rejected", "err", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "holder": holder})
+}
@@ -0,0 +1,170 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useHolderProofs gives a test its own remembered proofs and a public URL
+// for the challenge audience.
+func useHolderProofs(t *testing.T) {
+	t.Helper()
+	useKeys(t, bytes.Repeat([]byte{1}, 32), nil)
+	saved, savedProofs := config, holderProofs
+	config.PublicURL = "https://testa.example"
+	holderProofs = make(map[string]*holderProof)
+	t.Cleanup(func() { config, holderProofs = saved, savedProofs })
+}
+
+// didAuthProof signs a DID-auth JWT for the holder challenge nonce.
+func didAuthProof(t *testing.T, priv ed25519.PrivateKey, nonce string) string {
+	t.Helper()
+	did := didKeyEd25519(priv.Public().(ed25519.PublicKey))
+	header, _ := json.Marshal(map[string]string{"alg": "EdDSA", "kid": did + "#" + strings.TrimPrefix(did, "did:key:")})
+	payload, _ := json.Marshal(map[string]interface{}{"iss": did, "aud": holderAudience(), "nonce": nonce, "iat": time.Now().Unix()})
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return input + "." + base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, []byte(input)))
+}
+
+// TestHolderChallenge checks that stateless challenges are bound to their
+// expiry and MAC, and that asking for them remembers nothing.
+func TestHolderChallenge(t *testing.T) {
+	useHolderProofs(t)
+	nonce := newHolderChallenge()
+
+	expired := make([]byte, 24)
+	binary.BigEndian.PutUint64(expired, uint64(time.Now().Add(-time.Second).Unix()))
+	expired = append(expired, holderChallengeMAC(expired)...)
+
+	raw, _ := base64.RawURLEncoding.DecodeString(nonce)
+	raw[0] ^= 1 // moves the expiry
+
+	tests := []struct {
+		name, nonce string
+		valid       bool
+	}{
+		{"issued", nonce, true},
+		{"tampered expiry", base64.RawURLEncoding.EncodeToString(raw), false},
+		{"expired", base64.RawURLEncoding.EncodeToString(expired), false},
+		{"malformed", "not-a-nonce", false},
+	}
+	for _, tt := range tests {
+		if _, ok := checkHolderChallenge(tt.nonce); ok != tt.valid {
+			t.Errorf("%s: valid = %t, want %t", tt.name, ok, tt.valid)
+		}
+	}
+
+	for range 100 {
+		newHolderChallenge()
+	}
+	if len(holderProofs) != 0 {
+		t.Errorf("%d challenges remembered before any proof", len(holderProofs))
+	}
+}
+
+// TestHolderProofRoundTrip checks a wallet's posted proof binds the holder
+// once, a pasted proof binds once, and expired or foreign proofs do not.
+func TestHolderProofRoundTrip(t *testing.T) {
+	useHolderProofs(t)
+	_, priv, _ := ed25519.GenerateKey(nil)
+	_, other, _ := ed25519.GenerateKey(nil)
+	holder := didKeyEd25519(priv.Public().(ed25519.PublicKey))
+
+	post := func(proof string) int {
+		req := httptest.NewRequest("POST", "/holder/proof", strings.NewReader(proof))
+		rec := httptest.NewRecorder()
+		handleHolderProof(rec, req)
+		return rec.Code
+	}
+
+	t.Run("wallet", func(t *testing.T) {
+		nonce := newHolderChallenge()
+		if err := bindHolder(holder, nonce, ""); err == nil {
+			t.Fatal("bound before the wallet posted a proof")
+		}
+		proof := didAuthProof(t, priv, nonce)
+		if code := post(proof); code != http.StatusOK {
+			t.Fatalf("posting the proof: %d", code)
+		}
+		if code := post(proof); code != http.StatusBadRequest {
+			t.Errorf("replaying the proof: %d", code)
+		}
+		if err := bindHolder(didKeyEd25519(other.Public().(ed25519.PublicKey)), nonce, ""); err == nil {
+			t.Error("bound a DID the wallet did not prove")
+		}
+		if err := bindHolder(holder, nonce, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := bindHolder(holder, nonce, ""); !errors.Is(err, errHolderChallengeUsed) {
+			t.Errorf("second bind: %v", err)
+		}
+	})
+
+	t.Run("pasted", func(t *testing.T) {
+		proof := didAuthProof(t, priv, newHolderChallenge())
+		if err := bindHolder(didKeyEd25519(other.Public().(ed25519.PublicKey)), "", proof); err == nil {
+			t.Error("bound a proof signed by another DID")
+		}
+		if err := bindHolder(holder, "", proof); err != nil {
+			t.Fatal(err)
+		}
+		if err := bindHolder(holder, "", proof); !errors.Is(err, errHolderChallengeUsed) {
+			t.Errorf("replayed proof: %v", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		b := make([]byte, 24)
+		binary.BigEndian.PutUint64(b, uint64(time.Now().Add(-time.Second).Unix()))
+		nonce := base64.RawURLEncoding.EncodeToString(append(b, holderChallengeMAC(b)...))
+		proof := didAuthProof(t, priv, nonce)
+		if code := post(proof); code != http.StatusBadRequest {
+			t.Errorf("posting an expired proof: %d", code)
+		}
+		if err := bindHolder(holder, "", proof); err == nil {
+			t.Error("bound an expired proof")
+		}
+		if err := bindHolder(holder, nonce, ""); err == nil {
+			t.Error("bound an expired challenge")
+		}
+	})
+
+	t.Run("forged challenge", func(t *testing.T) {
+		b := make([]byte, 40)
+		binary.BigEndian.PutUint64(b, uint64(time.Now().Add(time.Minute).Unix()))
+		proof := didAuthProof(t, priv, base64.RawURLEncoding.EncodeToString(b))
+		if code := post(proof); code != http.StatusBadRequest {
+			t.Errorf("posting a proof for a forged challenge: %d", code)
+		}
+	})
+}
+
+// TestHolderProofsBounded checks remembered proofs are dropped when their
+// challenge expires and capped while outstanding.
+func TestHolderProofsBounded(t *testing.T) {
+	useHolderProofs(t)
+	holderProofs["old"] = &holderProof{expires: time.Now().Add(-time.Second)}
+	if err := rememberHolderProof("new", &holderProof{expires: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := holderProofs["old"]; ok {
+		t.Error("expired proof kept")
+	}
+
+	for i := len(holderProofs); i < holderProofsMax; i++ {
+		holderProofs[strconv.Itoa(i)] = &holderProof{expires: time.Now().Add(time.Minute)}
+	}
+	if err := rememberHolderProof("one-too-many", &holderProof{expires: time.Now().Add(time.Minute)}); err == nil {
+		t.Error("remembered a proof past the cap")
+	}
+}
@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Sign hooks. A SignHook sees every credential the wizard and the batch
+// command issue: BeforeSign may change the payload before it is checked
+// against the schema and signed, AfterSign is told of the signed
+// credential. SIGN_HOOKS lists the hooks to run, in order, as name=arg
+// entries ("claims=/app/config/claims.json,webhook=https://..."). The
+// built-in hooks are:
+//
+//   - claims=<file>: merges the JSON object in file into the credential
+//     subject, e.g. details every credential of the institution carries.
+//     SCHEMA_FILE must allow the claims.
+//   - script=<dir>: maps the form into the credential subject with the
+//     institution's script for the qualification (subjectscript.go).
+//   - webhook=<url>: posts the signed credential, its format and ID to
+//     url, to notify a student system or anchor it in a ledger. The
+//     credential names the student: use a URL the institution controls.
+//
+// An integrator's hook is one more factory in signHookFactories, added
+// with registerSignHook from a file of their own, so the handlers need no
+// changes.
+
+// SignHook is a pre- and post-signing extension point.
+type SignHook interface {
+	// BeforeSign may change the payload (see buildCredentialPayload). An
+	// error stops the issuance and is shown to the user.
+	BeforeSign(ctx context.Context, sess *Session, payload map[string]interface{}) error
+	// AfterSign is told of the signed credential. It runs in the request,
+	// so anything slow belongs in the background; it cannot fail the
+	// issuance, only log.
+	AfterSign(ctx context.Context, sess *Session, signed json.RawMessage)
+}
+
+// signHookFactories make the hooks SIGN_HOOKS names, from their argument.
+var signHookFactories = map[string]func(arg string) (SignHook, error){
+	"claims":  newClaimsHook,
+	"script":  newScriptHook,
+	"webhook": newWebhookHook,
+}
+
+// signHooks are the configured hooks, in order.
+var signHooks []SignHook
+
+// registerSignHook adds a hook factory under name.
+func registerSignHook(name string, factory func(arg string) (SignHook, error)) {
+	signHookFactories[name] = factory
+}
+
+// initSignHooks makes the hooks SIGN_HOOKS names.
+func initSignHooks() error {
+	signHooks = nil
+	for _, entry := range config.SignHooks {
+		name, arg, _ := strings.Cut(entry, "=")
+		factory, ok := signHookFactories[name]
+		if !ok {
+			return fmt.Errorf("SIGN_HOOKS: unknown hook %q", name)
+		}
+		hook, err := factory(arg)
+		if err != nil {
+			return fmt.Errorf("SIGN_HOOKS: %s: %w", name, err)
+		}
+		signHooks = append(signHooks, hook)
+	}
+	return nil
+}
+
+// beforeSign runs the hooks' BeforeSign, stopping at the first error.
+func beforeSign(ctx context.Context, sess *Session, payload map[string]interface{}) error {
+	for _, h := range signHooks {
+		if err := h.BeforeSign(ctx, sess, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// afterSign runs the hooks' AfterSign.
+func afterSign(ctx context.Context, sess *Session, signed json.RawMessage) {
+	for _, h := range signHooks {
+		h.AfterSign(ctx, sess, signed)
+	}
+}
+
+// claimsHook merges fixed claims into the credential subject.
+type claimsHook struct {
+	claims map[string]interface{}
+}
+
+func newClaimsHook(path string) (SignHook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, fmt.Errorf("%s: want a JSON object: %w", path, err)
+	}
+	return claimsHook{claims}, nil
+}
+
+func (h claimsHook) BeforeSign(ctx context.Context, sess *Session, payload map[string]interface{}) error {
+	credential, _ := payload["credential"].(map[string]interface{})
+	subject, ok := credential["credentialSubject"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("claims hook: the credential has no subject")
+	}
+	for k, v := range h.claims {
+		subject[k] = v
+	}
+	return nil
+}
+
+func (claimsHook) AfterSign(context.Context, *Session, json.RawMessage) {}
+
+// webhookHook posts each signed credential to a URL.
+type webhookHook struct {
+	url string
+}
+
+func newWebhookHook(url string) (SignHook, error) {
+	if !validHTTPURL(url) {
+		return nil, fmt.Errorf("%q must be an http or https URL", url)
+	}
+	return webhookHook{url}, nil
+}
+
+func (webhookHook) BeforeSign(context.Context, *Session, map[string]interface{}) error {
+	return nil
+}
+
+func (h webhookHook) AfterSign(ctx context.Context, sess *Session, signed json.RawMessage) {
+	sessionsMu.RLock()
+	payload := map[string]interface{}{
+		"event":        EventIssued,
+		"format":       sess.Format,
+		"issuer":       sess.IssuerDID,
+		"credentialId": sess.CredentialID,
+		"credential":   signed,
+	}
+	sessionsMu.RUnlock()
+	slog.DebugContext(ctx, "sign hook webhook", "url", h.url)
+	go postWebhook("sign hook", h.url, payload)
+}
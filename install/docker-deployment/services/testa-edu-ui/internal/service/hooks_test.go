@@ -0,0 +1,66 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestSignHooks checks the claims hook's claims are signed, and the
+// webhook hook is posted the signed credential.
+func TestSignHooks(t *testing.T) {
+	posted := make(chan map[string]interface{}, 1)
+	hookSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload map[string]interface{}
+		json.Unmarshal(body, &payload)
+		posted <- payload
+	}))
+	defer hookSrv.Close()
+	claims := filepath.Join(t.TempDir(), "claims.json")
+	if err := os.WriteFile(claims, []byte(`{"fieldOfStudy":"Computer Science"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	mock := mockagent.New()
+	srv := startE2EServer(t, mock.Start(t), "", e2eIssuerDID)
+	t.Cleanup(func() { signHooks = nil })
+	config.SignHooks = []string{"claims=" + claims, "webhook=" + hookSrv.URL}
+	if err := initSignHooks(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := e2eClient(t)
+	e2eStep(t, client, srv.URL+"/issue", url.Values{
+		"studentName": {"Jane Wanjiku"}, "institution": {"Testa University"},
+		"degree": {"Bachelor of Science"}, "consent_issue": {"on"}, "qrMode": {QRModeCompact},
+	}, "")
+	for _, step := range []string{"token", "sign"} {
+		e2eStep(t, client, srv.URL+"/step/"+step, nil, "step-success")
+	}
+	if !strings.Contains(string(mock.LastBody("/agent/credential/sign")), `"fieldOfStudy":"Computer Science"`) {
+		t.Errorf("claims not signed: %s", mock.LastBody("/agent/credential/sign"))
+	}
+	select {
+	case payload := <-posted:
+		if payload["event"] != EventIssued || payload["format"] != FormatLDP || payload["credential"] == nil {
+			t.Errorf("webhook payload %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("webhook not posted")
+	}
+
+	config.SignHooks = []string{"nosuchhook=x"}
+	if err := initSignHooks(); err == nil {
+		t.Error("unknown hook accepted")
+	}
+}
@@ -0,0 +1,283 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Internationalization. The wizard, error and verification pages are
+// written in English and translated through the message catalogs in
+// locales/<language>.json, keyed by the English text (with fmt verbs for
+// values). Templates translate with {{t "Text"}} or {{t "%d of %d" .A .B}};
+// text missing from a catalog is shown in English.
+//
+// A page's language is the one picked with the language switcher (the
+// "lang" cookie set by /lang/{code}), else the best match for the
+// browser's Accept-Language, else DEFAULT_LANGUAGE. Each language has its
+// own parsed template set.
+//
+// Dates are written with the catalog's translation of the layouts
+// "2 January 2006" and "2 January 2006 15:04 MST", month names included;
+// templates use {{date .When}} and {{datetime .When}}. The PDF certificate
+// is rendered in the same language, its field labels and degree and honours
+// wording translated through the catalog too.
+
+const langCookie = "lang"
+
+// Catalog is one language's translations.
+type Catalog struct {
+	Name     string            `json:"name"` // the language's own name, for the switcher
+	Messages map[string]string `json:"messages"`
+}
+
+// Language is an entry in the language switcher.
+type Language struct {
+	Code string
+	Name string
+}
+
+func loadCatalogs(dir string) (map[string]*Catalog, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]*Catalog)
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		c := &Catalog{}
+		if err := json.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", f, err)
+		}
+		code := strings.TrimSuffix(filepath.Base(f), ".json")
+		if c.Name == "" {
+			c.Name = code
+		}
+		out[code] = c
+	}
+	if out["en"] == nil {
+		return nil, fmt.Errorf("no English catalog in %s", dir)
+	}
+	return out, nil
+}
+
+// translator returns the "t" template function for a language.
+func translator(lang string) func(string, ...interface{}) string {
+	return live().translator(lang)
+}
+
+func (s *snapshot) translator(lang string) func(string, ...interface{}) string {
+	c := s.catalogs[lang]
+	return func(msg string, args ...interface{}) string {
+		if s, ok := c.Messages[msg]; ok && s != "" {
+			msg = s
+		}
+		if len(args) > 0 {
+			return fmt.Sprintf(msg, args...)
+		}
+		return msg
+	}
+}
+
+const (
+	dateLayout     = "2 January 2006"
+	dateTimeLayout = "2 January 2006 15:04 MST"
+)
+
+// localTime formats a time in a language, with an English layout that the
+// catalog may reorder.
+func localTime(lang, layout string, t time.Time) string {
+	return live().localTime(lang, layout, t)
+}
+
+func (s *snapshot) localTime(lang, layout string, t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	t = t.In(localZone())
+	tr := s.translator(lang)
+	out := t.Format(tr(layout))
+	if month := t.Month().String(); strings.Contains(out, month) {
+		out = strings.Replace(out, month, tr(month), 1)
+	}
+	return out
+}
+
+// localDate formats a date in a language. It takes a time.Time or a form
+// date (YYYY-MM-DD); other strings are returned as they are.
+func localDate(lang string, v interface{}) string {
+	return live().localDate(lang, v)
+}
+
+func (s *snapshot) localDate(lang string, v interface{}) string {
+	switch d := v.(type) {
+	case time.Time:
+		return s.localTime(lang, dateLayout, d)
+	case string:
+		t, err := time.ParseInLocation("2006-01-02", d, localZone())
+		if err != nil {
+			return d
+		}
+		return s.localTime(lang, dateLayout, t)
+	}
+	return fmt.Sprint(v)
+}
+
+// languageFuncs are the template functions that depend on the page's
+// language.
+func languageFuncs(lang string) template.FuncMap {
+	return live().languageFuncs(lang)
+}
+
+func (s *snapshot) languageFuncs(lang string) template.FuncMap {
+	return template.FuncMap{
+		"t":        s.translator(lang),
+		"lang":     func() string { return lang },
+		"date":     func(v interface{}) string { return s.localDate(lang, v) },
+		"datetime": func(t time.Time) string { return s.localTime(lang, dateTimeLayout, t) },
+	}
+}
+
+// parsePages parses the page templates once per language.
+func (s *snapshot) parsePages() (map[string]*template.Template, error) {
+	base, err := template.New("").Funcs(template.FuncMap{
+		"theme":       pageTheme,
+		"openGraph":   pageOpenGraph,
+		"languages":   func() []Language { return s.languages },
+		"demoMode":    func() bool { return config.DemoMode },
+		"maintenance": func() bool { return s.config.MaintenanceMode },
+		"base":        func() string { return config.BasePath },
+	}).Funcs(s.languageFuncs("en")).ParseGlob(filepath.Join("templates", "*.html"))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := base.ParseGlob(filepath.Join("templates", "partials", "*.html")); err != nil {
+		return nil, err
+	}
+	sets := make(map[string]*template.Template)
+	for code := range s.catalogs {
+		set, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		sets[code] = set.Funcs(s.languageFuncs(code))
+	}
+	return sets, nil
+}
+
+// initLanguages loads the catalogs and page templates for config and
+// publishes them.
+func initLanguages() error {
+	s, err := loadTemplates(&config)
+	if err != nil {
+		return err
+	}
+	current.Store(s)
+	return nil
+}
+
+// loadTemplates loads the catalogs and page templates into a snapshot of
+// cfg.
+func loadTemplates(cfg *Config) (*snapshot, error) {
+	s := &snapshot{config: cfg}
+	var err error
+	if s.catalogs, err = loadCatalogs("locales"); err != nil {
+		return nil, err
+	}
+	if s.catalogs[cfg.DefaultLanguage] == nil {
+		return nil, fmt.Errorf("DEFAULT_LANGUAGE %q has no catalog in locales/", cfg.DefaultLanguage)
+	}
+	for code, c := range s.catalogs {
+		s.languages = append(s.languages, Language{Code: code, Name: c.Name})
+	}
+	sort.Slice(s.languages, func(i, j int) bool { return s.languages[i].Code < s.languages[j].Code })
+	if s.pageSets, err = s.parsePages(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// pages returns the page templates in the request's language.
+func pages(r *http.Request) *template.Template {
+	return snapshotFor(r).pageSets[requestLanguage(r)]
+}
+
+func requestLanguage(r *http.Request) string {
+	s := snapshotFor(r)
+	if c, err := r.Cookie(langCookie); err == nil && s.catalogs[c.Value] != nil {
+		return c.Value
+	}
+	for _, tag := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		if s.catalogs[base] != nil {
+			return base
+		}
+	}
+	return s.config.DefaultLanguage
+}
+
+// acceptedLanguages lists an Accept-Language header's tags, most preferred
+// first.
+func acceptedLanguages(header string) []string {
+	type tag struct {
+		name string
+		q    float64
+	}
+	var tags []tag
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if name == "" || name == "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			tags = append(tags, tag{name, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	out := make([]string, len(tags))
+	for i, t := range tags {
+		out[i] = t.name
+	}
+	return out
+}
+
+// handleLanguage switches the UI language and returns to the page the
+// switcher was on.
+func handleLanguage(w http.ResponseWriter, r *http.Request) {
+	code := r.PathValue("code")
+	if snapshotFor(r).catalogs[code] == nil {
+		pageNotFound(w, r)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     langCookie,
+		Value:    code,
+		Path:     appPath("/"),
+		MaxAge:   int((365 * 24 * time.Hour).Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	back := "/"
+	if ref, err := url.Parse(r.Referer()); err == nil && ref.Host == r.Host && strings.HasPrefix(ref.Path, "/") && !strings.HasPrefix(ref.Path, "//") {
+		back = ref.RequestURI()
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
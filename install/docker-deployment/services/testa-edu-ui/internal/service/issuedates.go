@@ -0,0 +1,86 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	// The image has no zone database; TIMEZONE is looked up in this one.
+	_ "time/tzdata"
+)
+
+// Issuance dates. Credentials' dates (issuanceDate and expirationDate, or
+// validFrom and validUntil) are RFC 3339 date-times in UTC: JSON-XT packs
+// them as seconds and unpacks them with Z, so any other offset would no
+// longer match the signature. TIMEZONE, an IANA zone such as
+// Africa/Nairobi (UTC unless set), is the zone certificates and pages show
+// dates in, and the one operators' dates are read in.
+//
+// A credential is dated when it is signed, unless the operator gives the
+// date it takes effect, as when backdating a degree to its conferral: the
+// issuance form's issuanceDate, or a CSV or JSON record's. Backdating is
+// off unless MAX_BACKDATE bounds how far back a date may go. A date must
+// not be in the future, or before the student's enrollment; a date
+// without a time is midnight in TIMEZONE.
+
+// issuanceDateLayouts are the layouts an operator's issuance date is read
+// in: a date, the date and time of a datetime-local input, or RFC 3339.
+var issuanceDateLayouts = []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339}
+
+// localZone is TIMEZONE's location.
+func localZone() *time.Location {
+	if config.Timezone == nil {
+		return time.UTC
+	}
+	return config.Timezone
+}
+
+// credentialTime formats a credential's date.
+func credentialTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseIssuanceDate reads and checks an operator's issuance date, for a
+// credential of a student enrolled on enrollment (a form date, or "").
+func parseIssuanceDate(s, enrollment string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	var t time.Time
+	var err error
+	for _, layout := range issuanceDateLayouts {
+		if t, err = time.ParseInLocation(layout, s, localZone()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("The issuance date %q must be a date, YYYY-MM-DD, or an RFC 3339 date-time", s)
+	}
+	switch {
+	case config.MaxBackdate <= 0:
+		return time.Time{}, errors.New("Issuance dates cannot be set here; credentials are dated when they are signed")
+	case t.After(now):
+		return time.Time{}, errors.New("The issuance date cannot be in the future")
+	case now.Sub(t) > config.MaxBackdate:
+		return time.Time{}, fmt.Errorf("The issuance date cannot be more than %s in the past", formatBackdate(config.MaxBackdate))
+	}
+	if enrolled, err := time.ParseInLocation("2006-01-02", enrollment, localZone()); err == nil && t.Before(enrolled) {
+		return time.Time{}, errors.New("The issuance date cannot be before the enrollment date")
+	}
+	return t.Truncate(time.Second), nil
+}
+
+// formatBackdate writes MAX_BACKDATE in days, as operators set it.
+func formatBackdate(d time.Duration) string {
+	if days := int(d / (24 * time.Hour)); days > 0 && d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%d days", days)
+	}
+	return d.String()
+}
+
+// issuedAt is when a credential takes effect: the operator's issuance
+// date, or now.
+func issuedAt(form CredentialForm) time.Time {
+	if form.IssuedAt.IsZero() {
+		return time.Now()
+	}
+	return form.IssuedAt
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIssuanceDates(t *testing.T) {
+	saved := config
+	t.Cleanup(func() { config = saved })
+	nairobi, err := time.LoadLocation("Africa/Nairobi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Timezone = nairobi
+	now := time.Date(2026, 7, 1, 9, 30, 0, 0, time.UTC)
+
+	if got := credentialTime(now); got != "2026-07-01T09:30:00Z" {
+		t.Errorf("credentialTime = %s", got)
+	}
+	if _, err := parseIssuanceDate("2026-06-20", "", now); err == nil {
+		t.Error("backdated with MAX_BACKDATE unset")
+	}
+
+	config.MaxBackdate = 365 * 24 * time.Hour
+	for _, tt := range []struct {
+		in, enrolled, want, err string
+	}{
+		{in: "2026-06-20", want: "2026-06-19T21:00:00Z"},
+		{in: "2026-06-20T14:00", want: "2026-06-20T11:00:00Z"},
+		{in: "2026-06-20T14:00:00+01:00", want: "2026-06-20T13:00:00Z"},
+		{in: "20/06/2026", err: "must be a date"},
+		{in: "2026-07-02", err: "in the future"},
+		{in: "2025-06-30", err: "365 days in the past"},
+		{in: "2026-06-20", enrolled: "2026-06-21", err: "before the enrollment date"},
+	} {
+		got, err := parseIssuanceDate(tt.in, tt.enrolled, now)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: err = %v, want %q", tt.in, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || credentialTime(got) != tt.want {
+			t.Errorf("%s = %s, %v; want %s", tt.in, credentialTime(got), err, tt.want)
+		}
+	}
+
+	config.VCVersion, config.CredentialValidity = "1.1", 48*time.Hour
+	form := CredentialForm{StudentName: "Jane Wanjiku", IssuedAt: time.Date(2026, 6, 20, 0, 0, 0, 0, nairobi)}
+	credential := buildCredentialPayload(form, e2eIssuerDID, "Ed25519Signature2020")["credential"].(map[string]interface{})
+	if credential["issuanceDate"] != "2026-06-19T21:00:00Z" || credential["expirationDate"] != "2026-06-21T21:00:00Z" {
+		t.Errorf("dates: %v, %v", credential["issuanceDate"], credential["expirationDate"])
+	}
+	if claims := vcJWTClaims(e2eIssuerDID, credential); claims["nbf"] != form.IssuedAt.Unix() {
+		t.Errorf("nbf = %v", claims["nbf"])
+	}
+}
@@ -0,0 +1,154 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Issuer metadata. /.well-known/openid-credential-issuer describes the
+// issuer to OpenID for Verifiable Credential Issuance wallets: its display
+// name (ISSUER_NAME) and logo (ISSUER_LOGO_URL, by default the portal
+// logo), and the credential configurations it offers in each format, with
+// the signing algorithms, types and display styling wallets use to render
+// offered and held credentials, labelled in each language. The credential
+// endpoint is advertised when OID4VCI_CREDENTIAL_ENDPOINT points at the
+// agent's OID4VCI service.
+
+const (
+	credentialBackgroundColor = "#4338ca"
+	credentialTextColor       = "#ffffff"
+)
+
+type issuerDisplay struct {
+	Name            string       `json:"name"`
+	Locale          string       `json:"locale,omitempty"`
+	Logo            *displayLogo `json:"logo,omitempty"`
+	Description     string       `json:"description,omitempty"`
+	BackgroundColor string       `json:"background_color,omitempty"`
+	TextColor       string       `json:"text_color,omitempty"`
+}
+
+type displayLogo struct {
+	URI     string `json:"uri"`
+	AltText string `json:"alt_text,omitempty"`
+}
+
+type credentialConfiguration struct {
+	Format               string                 `json:"format"`
+	Scope                string                 `json:"scope,omitempty"`
+	BindingMethods       []string               `json:"cryptographic_binding_methods_supported,omitempty"`
+	SigningAlgs          []string               `json:"credential_signing_alg_values_supported,omitempty"`
+	CredentialDefinition map[string]interface{} `json:"credential_definition,omitempty"`
+	VCT                  string                 `json:"vct,omitempty"`
+	Display              []issuerDisplay        `json:"display"`
+	Claims               map[string]interface{} `json:"claims,omitempty"`
+}
+
+type issuerMetadata struct {
+	CredentialIssuer   string                             `json:"credential_issuer"`
+	CredentialEndpoint string                             `json:"credential_endpoint,omitempty"`
+	Display            []issuerDisplay                    `json:"display"`
+	Configurations     map[string]credentialConfiguration `json:"credential_configurations_supported"`
+}
+
+func issuerLogo() *displayLogo {
+	uri := liveConfig().IssuerLogoURL
+	if uri == "" {
+		uri = strings.TrimRight(config.PublicURL, "/") + "/static/logo.svg"
+	}
+	return &displayLogo{URI: uri, AltText: liveConfig().IssuerName + " logo"}
+}
+
+// credentialClaimDisplay names the subject claims for wallets, in every
+// display language (see walletdisplay.go).
+func credentialClaimDisplay() map[string]interface{} {
+	claims := make(map[string]interface{}, len(fieldMapping))
+	for _, m := range fieldMapping {
+		v, ok := fieldVocabularyFor(m.Property, fieldMapping)
+		if !ok {
+			continue
+		}
+		var display []issuerDisplay
+		for _, lang := range displayLanguages() {
+			display = append(display, issuerDisplay{Name: displayText(lang, v.Label), Locale: lang})
+		}
+		claims[m.Property] = map[string]interface{}{"display": display}
+	}
+	return claims
+}
+
+func buildIssuerMetadata() issuerMetadata {
+	var display []issuerDisplay
+	for _, lang := range displayLanguages() {
+		display = append(display, issuerDisplay{
+			Name:            displayText(lang, credentialDisplayName),
+			Locale:          lang,
+			Logo:            issuerLogo(),
+			Description:     displayText(lang, credentialDisplayDescription, liveConfig().IssuerName),
+			BackgroundColor: credentialBackgroundColor,
+			TextColor:       credentialTextColor,
+		})
+	}
+	types := []string{"VerifiableCredential", "EducationCredential"}
+	claims := credentialClaimDisplay()
+
+	ldContext := []string{"https://www.w3.org/2018/credentials/v1"}
+	if config.VCVersion == vcdm2 {
+		ldContext = []string{"https://www.w3.org/ns/credentials/v2"}
+	}
+	jwtAlgs := map[string]bool{}
+	for _, pt := range supportedProofTypes() {
+		jwtAlgs[jwtAlgFor(pt)] = true
+	}
+	var algs []string
+	for alg := range jwtAlgs {
+		algs = append(algs, alg)
+	}
+	sort.Strings(algs)
+
+	return issuerMetadata{
+		CredentialIssuer:   strings.TrimRight(config.PublicURL, "/"),
+		CredentialEndpoint: config.OID4VCICredentialEndpoint,
+		Display:            []issuerDisplay{{Name: liveConfig().IssuerName, Locale: "en", Logo: issuerLogo()}},
+		Configurations: map[string]credentialConfiguration{
+			"EducationCredential_ldp_vc": {
+				Format:         "ldp_vc",
+				BindingMethods: []string{"did:key"},
+				SigningAlgs:    supportedProofTypes(),
+				CredentialDefinition: map[string]interface{}{
+					"@context":          ldContext,
+					"type":              types,
+					"credentialSubject": claims,
+				},
+				Display: display,
+			},
+			"EducationCredential_jwt_vc_json": {
+				Format:         "jwt_vc_json",
+				BindingMethods: []string{"did:key"},
+				SigningAlgs:    algs,
+				CredentialDefinition: map[string]interface{}{
+					"type":              types,
+					"credentialSubject": claims,
+				},
+				Display: display,
+			},
+			"EducationCredential_vc+sd-jwt": {
+				Format:         FormatSDJWT,
+				BindingMethods: []string{"did:key", "jwk"},
+				SigningAlgs:    algs,
+				VCT:            "EducationCredential",
+				Display:        display,
+				Claims:         claims,
+			},
+		},
+	}
+}
+
+func handleIssuerMetadata(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	json.NewEncoder(w).Encode(buildIssuerMetadata())
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Issuer DIDs. Besides ISSUER_DID, credentials can be issued as the DIDs
+// in ISSUER_DIDS, a comma-separated list of "<name>=<DID>" pairs (say a
+// faculty alongside the university). The issuance form offers all of them;
+// each signs with its own proof type (PROOF_TYPES) and verification method
+// (VERIFICATION_METHODS). In local signing mode only the local DID signs.
+
+// IssuerOption is a DID credentials can be issued as.
+type IssuerOption struct {
+	Name string `json:"name"`
+	DID  string `json:"did"`
+}
+
+func parseIssuerDIDs(s string) ([]IssuerOption, error) {
+	var issuers []IssuerOption
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// DIDs never contain "=", names might.
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 || !strings.HasPrefix(pair[i+1:], "did:") {
+			return nil, fmt.Errorf("invalid ISSUER_DIDS entry %q, want name=did", pair)
+		}
+		issuers = append(issuers, IssuerOption{Name: strings.TrimSpace(pair[:i]), DID: pair[i+1:]})
+	}
+	return issuers, nil
+}
+
+// issuerOptions lists the DIDs new credentials can be issued as, the
+// default first.
+func issuerOptions() []IssuerOption {
+	issuers := []IssuerOption{{Name: liveConfig().IssuerName, DID: config.IssuerDID}}
+	if localSigner != nil {
+		return issuers
+	}
+	for _, iss := range config.IssuerDIDs {
+		if iss.DID != config.IssuerDID {
+			issuers = append(issuers, iss)
+		}
+	}
+	return issuers
+}
+
+// ownIssuer reports whether credentials are issued as did here.
+func ownIssuer(did string) (IssuerOption, bool) {
+	for _, iss := range issuerOptions() {
+		if iss.DID == did {
+			return iss, true
+		}
+	}
+	if t, ok := tenants.ByIssuer(did); ok {
+		return IssuerOption{Name: t.Name, DID: did}, true
+	}
+	return IssuerOption{}, false
+}
+
+// resolveIssuerDID validates an issuer DID chosen by the user, using
+// ISSUER_DID when none was chosen.
+func resolveIssuerDID(requested string) (string, error) {
+	if requested == "" {
+		if config.IssuerDID == "" {
+			return "", fmt.Errorf("no issuer DID: set ISSUER_DID or provision one in the DID setup wizard")
+		}
+		return config.IssuerDID, nil
+	}
+	for _, iss := range issuerOptions() {
+		if iss.DID == requested {
+			return requested, nil
+		}
+	}
+	return "", fmt.Errorf("credentials cannot be issued as %s", requested)
+}
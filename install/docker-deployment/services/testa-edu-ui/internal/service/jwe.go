@@ -0,0 +1,171 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// Optional JWE encryption of the credential for QR and link delivery, so
+// an intercepted QR image or link does not leak the student's details. The
+// payload is encrypted to the holder's did:key with ECDH-ES (direct key
+// agreement) and A256GCM. Ed25519 keys are converted to X25519, as
+// did:key resolvers do for keyAgreement.
+
+const jweEnc = "A256GCM"
+
+// holderAgreementKey returns the ECDH public key for a holder did:key.
+func holderAgreementKey(did string) (*ecdh.PublicKey, error) {
+	pub, err := parseDIDKey(did)
+	if err != nil {
+		return nil, err
+	}
+	switch key := pub.(type) {
+	case *ecdh.PublicKey:
+		return key, nil
+	case *ecdsa.PublicKey:
+		return key.ECDH()
+	case ed25519.PublicKey:
+		return ecdh.X25519().NewPublicKey(ed25519ToX25519(key))
+	}
+	return nil, fmt.Errorf("%s has no key agreement key", did)
+}
+
+// ed25519ToX25519 maps an Edwards point to its Montgomery u-coordinate,
+// u = (1 + y) / (1 - y) mod p.
+func ed25519ToX25519(pub ed25519.PublicKey) []byte {
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+	le := make([]byte, 32)
+	copy(le, pub)
+	le[31] &= 0x7f // drop the x sign bit
+	y := new(big.Int).SetBytes(reverse(le))
+
+	num := new(big.Int).Add(big.NewInt(1), y)
+	den := new(big.Int).Sub(big.NewInt(1), y)
+	den.Mod(den, p)
+	den.ModInverse(den, p)
+	u := num.Mul(num, den)
+	u.Mod(u, p)
+
+	out := make([]byte, 32)
+	u.FillBytes(out)
+	return reverse(out)
+}
+
+func reverse(b []byte) []byte {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
+
+// epkJWK is the ephemeral public key in JWK form.
+func epkJWK(pub *ecdh.PublicKey) map[string]string {
+	raw := pub.Bytes()
+	b64 := base64.RawURLEncoding.EncodeToString
+	if pub.Curve() == ecdh.X25519() {
+		return map[string]string{"kty": "OKP", "crv": "X25519", "x": b64(raw)}
+	}
+	// Uncompressed point: 0x04 || X || Y.
+	return map[string]string{"kty": "EC", "crv": "P-256", "x": b64(raw[1:33]), "y": b64(raw[33:])}
+}
+
+// concatKDF derives a 256-bit content key per RFC 7518 section 4.6.2, with
+// empty PartyUInfo and PartyVInfo.
+func concatKDF(z []byte, alg string) []byte {
+	h := sha256.New()
+	binary.Write(h, binary.BigEndian, uint32(1))
+	h.Write(z)
+	binary.Write(h, binary.BigEndian, uint32(len(alg)))
+	h.Write([]byte(alg))
+	binary.Write(h, binary.BigEndian, uint32(0)) // apu
+	binary.Write(h, binary.BigEndian, uint32(0)) // apv
+	binary.Write(h, binary.BigEndian, uint32(256))
+	return h.Sum(nil)
+}
+
+// encryptJWE encrypts plaintext to the holder DID as a compact JWE.
+func encryptJWE(plaintext []byte, holderDID, contentType string) (string, error) {
+	recipient, err := holderAgreementKey(holderDID)
+	if err != nil {
+		return "", err
+	}
+	ephemeral, err := recipient.Curve().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	z, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return "", fmt.Errorf("key agreement: %w", err)
+	}
+
+	header, err := json.Marshal(map[string]interface{}{
+		"alg": "ECDH-ES",
+		"enc": jweEnc,
+		"cty": contentType,
+		"kid": holderDID,
+		"epk": epkJWK(ephemeral.PublicKey()),
+	})
+	if err != nil {
+		return "", err
+	}
+	protected := base64.RawURLEncoding.EncodeToString(header)
+
+	block, err := aes.NewCipher(concatKDF(z, jweEnc))
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	rand.Read(iv)
+	sealed := gcm.Seal(nil, iv, plaintext, []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	b64 := base64.RawURLEncoding.EncodeToString
+	return protected + ".." + b64(iv) + "." + b64(ciphertext) + "." + b64(tag), nil
+}
+
+// deliveryPayload is what QR codes and retrieval links carry: the signed
+// credential, or a JWE of it when the session names a holder key. The JWE
+// is a JSON string, like JWT credentials, so the QR pipelines treat it as
+// compact serialization.
+func deliveryPayload(sess *Session) (json.RawMessage, error) {
+	if sess.EncryptTo == "" {
+		return sess.SignedCredential, nil
+	}
+	sessionsMu.RLock()
+	cached := sess.EncryptedPayload
+	sessionsMu.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	plaintext := []byte(sess.SignedCredential)
+	var compact string
+	if err := json.Unmarshal(sess.SignedCredential, &compact); err == nil {
+		plaintext = []byte(compact)
+	}
+	jwe, err := encryptJWE(plaintext, sess.EncryptTo, credentialMediaType(sess.Format))
+	if err != nil {
+		return nil, fmt.Errorf("encrypting credential: %w", err)
+	}
+	payload, _ := json.Marshal(jwe)
+
+	sessionsMu.Lock()
+	sess.EncryptedPayload = payload
+	sessionsMu.Unlock()
+	return payload, nil
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestEd25519ToX25519 checks the public key conversion against the X25519
+// key derived from the same seed, as RFC 8032 section 5.1.5 hashes it.
+func TestEd25519ToX25519(t *testing.T) {
+	rfc8032, _ := hex.DecodeString("9d61b19deffd5a60ba844af492ec2cc44449c5697b326919703bac031cae7f60")
+	seeds := [][]byte{rfc8032, bytes.Repeat([]byte{0}, 32), bytes.Repeat([]byte{0xff}, 32)}
+	for i := 0; i < 5; i++ {
+		seed := make([]byte, 32)
+		rand.Read(seed)
+		seeds = append(seeds, seed)
+	}
+	for _, seed := range seeds {
+		h := sha512.Sum512(seed)
+		priv, err := ecdh.X25519().NewPrivateKey(h[:32])
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+		if got, want := ed25519ToX25519(pub), priv.PublicKey().Bytes(); !bytes.Equal(got, want) {
+			t.Errorf("seed %x: got %x, want %x", seed, got, want)
+		}
+	}
+}
+
+// TestConcatKDF checks the A256GCM content key derived from the RFC 7748
+// section 6.1 shared secret.
+func TestConcatKDF(t *testing.T) {
+	alicePriv, _ := hex.DecodeString("77076d0a7318a57d3c16c17251b26645df4c2f87ebc0992ab177fba51db92c2a")
+	bobPub, _ := hex.DecodeString("de9edb7d7b7dc1b4d35b61c2ece435373f8343c85b78674dadfc7e146f882b4f")
+	alice, err := ecdh.X25519().NewPrivateKey(alicePriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := ecdh.X25519().NewPublicKey(bobPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := alice.ECDH(bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := hex.EncodeToString(z); got != "4a5d9d5ba4ce2de1728e3bf480350f25e07e21c947d19e3376f09b3c1e161742" {
+		t.Fatalf("shared secret = %s", got)
+	}
+	if got := hex.EncodeToString(concatKDF(z, jweEnc)); got != "d3f1a933aa331e5d3204beab09cddb96dbdaba47a555530b70fa4af7187c6edd" {
+		t.Errorf("content key = %s", got)
+	}
+}
+
+// decryptTestJWE opens a compact ECDH-ES/A256GCM JWE with the recipient's
+// private key, returning the protected header and plaintext.
+func decryptTestJWE(t *testing.T, jwe string, priv *ecdh.PrivateKey) (map[string]interface{}, []byte) {
+	t.Helper()
+	parts := strings.Split(jwe, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		t.Fatalf("not a compact direct-agreement JWE: %s", jwe)
+	}
+	b64 := base64.RawURLEncoding.DecodeString
+	rawHeader, err := b64(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		t.Fatal(err)
+	}
+
+	epk, _ := header["epk"].(map[string]interface{})
+	x, _ := b64(epk["x"].(string))
+	raw := x
+	if epk["kty"] == "EC" {
+		y, _ := b64(epk["y"].(string))
+		raw = append(append([]byte{4}, x...), y...)
+	}
+	ephemeral, err := priv.Curve().NewPublicKey(raw)
+	if err != nil {
+		t.Fatalf("epk: %v", err)
+	}
+	z, err := priv.ECDH(ephemeral)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := aes.NewCipher(concatKDF(z, jweEnc))
+	gcm, _ := cipher.NewGCM(block)
+	iv, _ := b64(parts[2])
+	ciphertext, _ := b64(parts[3])
+	tag, _ := b64(parts[4])
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		t.Fatalf("decrypting: %v", err)
+	}
+	return header, plaintext
+}
+
+// TestEncryptJWE checks that a credential encrypted to each supported
+// holder did:key type decrypts with the holder's private key.
+func TestEncryptJWE(t *testing.T) {
+	seed := bytes.Repeat([]byte{9}, 32)
+	h := sha512.Sum512(seed)
+	edPriv, _ := ecdh.X25519().NewPrivateKey(h[:32])
+	edDID := didKeyEd25519(ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey))
+
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256, err := p256Key.ECDH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p256DID, err := didKeyFor(&p256Key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x25519, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	x25519DID := "did:key:z" + base58Encode(append(append([]byte{}, multicodecX25519...), x25519.PublicKey().Bytes()...))
+
+	tests := []struct {
+		name, did, crv string
+		priv           *ecdh.PrivateKey
+	}{
+		{"Ed25519", edDID, "X25519", edPriv},
+		{"P-256", p256DID, "P-256", p256},
+		{"X25519", x25519DID, "X25519", x25519},
+	}
+	plaintext := []byte(`{"type":["VerifiableCredential"],"credentialSubject":{"name":"Amina"}}`)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jwe, err := encryptJWE(plaintext, tt.did, "application/vc+ld+json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			header, got := decryptTestJWE(t, jwe, tt.priv)
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("plaintext = %s", got)
+			}
+			epk, _ := header["epk"].(map[string]interface{})
+			if header["alg"] != "ECDH-ES" || header["enc"] != jweEnc || header["kid"] != tt.did ||
+				header["cty"] != "application/vc+ld+json" || epk["crv"] != tt.crv {
+				t.Errorf("header %v", header)
+			}
+		})
+	}
+
+	for _, did := range []string{"did:web:example.com", "did:key:z6Mk"} {
+		if _, err := encryptJWE(plaintext, did, "application/vc+ld+json"); err == nil {
+			t.Errorf("encrypted to %s", did)
+		}
+	}
+}
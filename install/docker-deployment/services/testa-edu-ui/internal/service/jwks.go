@@ -0,0 +1,85 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// JWKS. /.well-known/jwks.json publishes the issuer's public keys for
+// JWT-VC and SD-JWT verifiers that look keys up by "kid" rather than
+// resolving DIDs. Each key's kid is the verification method JWTs carry in
+// their header. In local signing mode every key in the key ring is listed,
+// retired ones included, so older credentials still verify; otherwise the
+// keys of the configured issuer DIDs are resolved where that can be done
+// locally (did:key and did:web).
+
+const jwksPath = "/.well-known/jwks.json"
+
+func handleJWKS(w http.ResponseWriter, r *http.Request) {
+	keys := []map[string]interface{}{}
+	add := func(vm string, jwk map[string]interface{}) {
+		pub, err := jwkPublicKey(jwk)
+		if err != nil {
+			return
+		}
+		key := map[string]interface{}{"kid": vm, "alg": keyAlg(pub), "use": "sig"}
+		for k, v := range jwk {
+			key[k] = v
+		}
+		keys = append(keys, key)
+	}
+
+	if localSigner != nil {
+		for _, rec := range signingKeys.List() {
+			vm := localSigner.did + "#" + rec.ID
+			if !strings.HasPrefix(localSigner.did, "did:web:") {
+				// A did:key verification method is named by its key.
+				pub, err := jwkPublicKey(rec.PublicJWK)
+				if err != nil {
+					continue
+				}
+				did, err := didKeyFor(pub)
+				if err != nil {
+					continue
+				}
+				vm = did + "#" + strings.TrimPrefix(did, "did:key:")
+			}
+			add(vm, rec.PublicJWK)
+		}
+	} else {
+		issuers := map[string]bool{}
+		for _, iss := range issuerOptions() {
+			issuers[iss.DID] = true
+		}
+		for _, t := range tenants.List() {
+			issuers[t.Issuer()] = true
+		}
+		for did := range config.ProofTypes {
+			issuers[did] = true
+		}
+		for did := range config.VerificationMethods {
+			issuers[did] = true
+		}
+		dids := make([]string, 0, len(issuers))
+		for did := range issuers {
+			dids = append(dids, did)
+		}
+		sort.Strings(dids)
+		for _, did := range dids {
+			vm := verificationMethodFor(did)
+			pub, err := resolveVerificationMethod(vm, did)
+			if err != nil {
+				continue
+			}
+			if jwk, err := publicJWK(pub); err == nil {
+				add(vm, jwk)
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Credential formats a session can be issued in.
+const (
+	FormatLDP = "ldp_vc" // JSON-LD with an embedded Linked Data proof
+	FormatJWT = "jwt_vc" // compact JWT (vc-jwt)
+)
+
+func validFormat(f string) bool {
+	return f == FormatLDP || f == FormatJWT || f == FormatSDJWT || f == FormatAnonCreds
+}
+
+// jwtAlgFor maps the issuer's proof suite to the JOSE algorithm the agent
+// should use when signing the same key as a JWT.
+func jwtAlgFor(proofType string) string {
+	switch proofType {
+	case "Ed25519Signature2020":
+		return "EdDSA"
+	default:
+		return "ES256K"
+	}
+}
+
+// vcJWTClaims wraps a credential in vc-jwt claims, mapping its subject, id
+// and validity period to the registered claims.
+func vcJWTClaims(issuer string, credential map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{"iss": issuer, "vc": credential}
+	if subject, ok := credential["credentialSubject"].(map[string]interface{}); ok && subject["id"] != nil {
+		claims["sub"] = subject["id"]
+	}
+	if id, ok := credential["id"].(string); ok {
+		claims["jti"] = id
+	}
+	for claim, keys := range map[string][]string{"nbf": {"validFrom", "issuanceDate"}, "exp": {"validUntil", "expirationDate"}} {
+		for _, k := range keys {
+			if v, ok := credential[k].(string); ok {
+				if t, err := time.Parse(time.RFC3339, v); err == nil {
+					claims[claim] = t.Unix()
+				}
+			}
+		}
+	}
+	return claims
+}
+
+// compactJWT reports whether a stored credential is a JWT, which sessions
+// keep as a JSON string so it can be handed to the agent's verify endpoint
+// unchanged.
+func compactJWT(signed json.RawMessage) (string, bool) {
+	trimmed := bytes.TrimSpace(signed)
+	if len(trimmed) == 0 || trimmed[0] != '"' {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(trimmed, &s); err != nil {
+		return "", false
+	}
+	return s, strings.Count(s, ".") == 2 && !strings.Contains(s, "~")
+}
+
+// decodeJWT returns the decoded header and payload of a compact JWT
+// without checking its signature.
+func decodeJWT(compact string) (header, payload map[string]interface{}, err error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 3 {
+		return nil, nil, fmt.Errorf("malformed JWT: expected 3 parts, got %d", len(parts))
+	}
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, nil, fmt.Errorf("decoding JWT header: %w", err)
+	}
+	if err := decodeJWTSegment(parts[1], &payload); err != nil {
+		return nil, nil, fmt.Errorf("decoding JWT payload: %w", err)
+	}
+	return header, payload, nil
+}
+
+func decodeJWTSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// prettyCredential renders a stored credential for display: indented JSON
+// for JSON-LD, and the decoded header and payload (plus disclosures for
+// SD-JWT) for JWTs.
+func prettyCredential(signed json.RawMessage) string {
+	var buf bytes.Buffer
+	var s string
+	if json.Unmarshal(signed, &s) == nil && strings.Contains(s, "~") {
+		issuerJWT, disclosures, err := splitSDJWT(s)
+		if err != nil {
+			return s
+		}
+		header, payload, err := decodeJWT(issuerJWT)
+		if err != nil {
+			return s
+		}
+		disclosed := make([]interface{}, 0, len(disclosures))
+		for _, d := range disclosures {
+			disclosed = append(disclosed, []interface{}{d.Salt, d.Claim, d.Value})
+		}
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"header":      header,
+			"payload":     payload,
+			"disclosures": disclosed,
+		}, "", "  ")
+		return string(out)
+	}
+	if jwt, ok := compactJWT(signed); ok {
+		header, payload, err := decodeJWT(jwt)
+		if err != nil {
+			return jwt
+		}
+		out, _ := json.MarshalIndent(map[string]interface{}{
+			"header":  header,
+			"payload": payload,
+		}, "", "  ")
+		return string(out)
+	}
+	json.Indent(&buf, signed, "", "  ")
+	return buf.String()
+}
@@ -0,0 +1,222 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// Signing key rotation. In local signing mode every key the issuer has
+// signed with is recorded in DATA_DIR/signing-keys.json as a verification
+// method ("key-1", "key-2", ...). New credentials are signed with the
+// active key; retired keys keep only their public half, which stays in the
+// issuer's DID document so credentials they signed remain verifiable.
+//
+// A key given in SIGNING_KEY or SIGNING_KEY_URI is rotated by changing the
+// variable and restarting: an unknown key becomes the next verification
+// method. A generated key is rotated through the staff API.
+
+// SigningKeyRecord is one of the issuer's verification methods.
+type SigningKeyRecord struct {
+	ID        string                 `json:"id"`
+	PublicJWK map[string]interface{} `json:"publicJwk"`
+	Created   time.Time              `json:"created"`
+	Retired   *time.Time             `json:"retired,omitempty"`
+}
+
+type SigningKeyRing struct {
+	path string
+
+	mu     sync.Mutex
+	Active string              `json:"active"`
+	Keys   []*SigningKeyRecord `json:"keys"`
+}
+
+var signingKeys *SigningKeyRing
+
+func NewSigningKeyRing(dataDir string) (*SigningKeyRing, error) {
+	r := &SigningKeyRing{path: filepath.Join(dataDir, "signing-keys.json")}
+	data, err := os.ReadFile(r.path)
+	if err == nil {
+		if err := json.Unmarshal(data, r); err != nil {
+			return nil, fmt.Errorf("parsing signing keys: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading signing keys: %w", err)
+	}
+	return r, nil
+}
+
+func (r *SigningKeyRing) saveLocked() error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing signing keys: %w", err)
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// Use makes a public key the active verification method, recording it as
+// the next one when it is new, and returns its record.
+func (r *SigningKeyRing) Use(pub crypto.PublicKey) (*SigningKeyRecord, error) {
+	jwk, err := publicJWK(pub)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var rec *SigningKeyRecord
+	for _, k := range r.Keys {
+		if reflect.DeepEqual(k.PublicJWK, jwk) {
+			rec = k
+		}
+	}
+	if rec != nil && rec.ID == r.Active {
+		return rec, nil
+	}
+	now := time.Now().UTC()
+	for _, k := range r.Keys {
+		if k.ID == r.Active && k.Retired == nil {
+			k.Retired = &now
+		}
+	}
+	if rec == nil {
+		rec = &SigningKeyRecord{ID: fmt.Sprintf("key-%d", len(r.Keys)+1), PublicJWK: jwk, Created: now}
+		r.Keys = append(r.Keys, rec)
+	}
+	rec.Retired = nil
+	r.Active = rec.ID
+	return rec, r.saveLocked()
+}
+
+// List returns a copy of the records, oldest first.
+func (r *SigningKeyRing) List() []SigningKeyRecord {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]SigningKeyRecord, len(r.Keys))
+	for i, k := range r.Keys {
+		out[i] = *k
+	}
+	return out
+}
+
+// Lookup returns the public key of a verification method by ID.
+func (r *SigningKeyRing) Lookup(id string) (crypto.PublicKey, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, k := range r.Keys {
+		if k.ID == id {
+			pub, err := jwkPublicKey(k.PublicJWK)
+			return pub, err == nil
+		}
+	}
+	return nil, false
+}
+
+// publicJWK encodes a signing public key as a JWK.
+func publicJWK(pub crypto.PublicKey) (map[string]interface{}, error) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		return map[string]interface{}{"kty": "OKP", "crv": "Ed25519", "x": b64(key)}, nil
+	case *secp256k1PublicKey:
+		return map[string]interface{}{
+			"kty": "EC", "crv": "secp256k1",
+			"x": b64(key.X.FillBytes(make([]byte, 32))),
+			"y": b64(key.Y.FillBytes(make([]byte, 32))),
+		}, nil
+	case *ecdsa.PublicKey:
+		return map[string]interface{}{
+			"kty": "EC", "crv": "P-256",
+			"x": b64(key.X.FillBytes(make([]byte, 32))),
+			"y": b64(key.Y.FillBytes(make([]byte, 32))),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T", pub)
+}
+
+// localDIDDocumentPath is where a did:web issuer's document is served, or
+// "" when the issuer is not a did:web.
+func localDIDDocumentPath(did string) string {
+	docURL, err := didWebURL(did)
+	if err != nil {
+		return ""
+	}
+	u, err := url.Parse(docURL)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// handleDIDDocument serves the did:web document of a locally signing
+// issuer, listing every verification method it has used.
+func handleDIDDocument(w http.ResponseWriter, r *http.Request) {
+	did := localSigner.did
+	var methods []map[string]interface{}
+	var assertion []string
+	for _, k := range signingKeys.List() {
+		id := did + "#" + k.ID
+		methods = append(methods, map[string]interface{}{
+			"id":           id,
+			"type":         "JsonWebKey2020",
+			"controller":   did,
+			"publicKeyJwk": k.PublicJWK,
+		})
+		assertion = append(assertion, id)
+	}
+	w.Header().Set("Content-Type", "application/did+json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"@context":           []string{"https://www.w3.org/ns/did/v1", "https://w3id.org/security/suites/jws-2020/v1"},
+		"id":                 did,
+		"verificationMethod": methods,
+		"assertionMethod":    assertion,
+	})
+}
+
+// handleSigningKeyList lists the issuer's verification methods.
+func handleSigningKeyList(w http.ResponseWriter, r *http.Request) {
+	if localSigner == nil {
+		http.Error(w, "Signing keys are held by the agent", http.StatusConflict)
+		return
+	}
+	_, active := localSigner.current()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer": localSigner.did,
+		"active": active,
+		"keys":   signingKeys.List(),
+	})
+}
+
+// handleSigningKeyRotate replaces a generated signing key with a new one.
+func handleSigningKeyRotate(w http.ResponseWriter, r *http.Request) {
+	if localSigner == nil {
+		http.Error(w, "Signing keys are held by the agent", http.StatusConflict)
+		return
+	}
+	vm, err := localSigner.rotate()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "signing key rotation error", "err", err)
+		http.Error(w, "Failed to rotate signing key: "+err.Error(), http.StatusConflict)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: signing key rotated", "verification_method", vm)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"active": vm})
+}
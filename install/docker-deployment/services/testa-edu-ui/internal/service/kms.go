@@ -0,0 +1,530 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key management backends for local signing. SIGNING_KEY_URI names a key
+// held by a KMS or HSM, which signs on the service's behalf so the private
+// key never touches the filesystem:
+//
+//	awskms:<key ID, ARN or alias>
+//	gcpkms:projects/<p>/locations/<l>/keyRings/<r>/cryptoKeys/<k>/cryptoKeyVersions/<v>
+//	pkcs11:token=<label>;id=<id>?module-path=<library>&pin-source=<file>
+//
+// AWS KMS uses the standard AWS_REGION, AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN variables (and
+// AWS_ENDPOINT_URL, for testing against a local endpoint). Cloud KMS uses
+// the service account in GOOGLE_APPLICATION_CREDENTIALS, or the metadata
+// server when running on Google Cloud. PKCS#11 tokens are driven through
+// OpenSC's pkcs11-tool, following RFC 7512 key URIs.
+//
+// Keys may be secp256k1 or P-256 (ECDSA over SHA-256), or Ed25519 where the
+// backend supports it.
+
+const kmsTimeout = 30 * time.Second
+
+var kmsClient = &http.Client{Timeout: kmsTimeout}
+
+// newKMSSigner connects to the key named by a SIGNING_KEY_URI and fetches
+// its public key.
+func newKMSSigner(uri string) (keySigner, error) {
+	scheme, rest, _ := strings.Cut(uri, ":")
+	switch scheme {
+	case "awskms":
+		return newAWSKMSSigner(strings.TrimPrefix(rest, "//"))
+	case "gcpkms":
+		return newGCPKMSSigner(strings.TrimPrefix(rest, "//"))
+	case "pkcs11":
+		return newPKCS11Signer(rest)
+	}
+	return nil, fmt.Errorf("unsupported SIGNING_KEY_URI scheme %q, want awskms, gcpkms or pkcs11", scheme)
+}
+
+var oidSecp256k1 = asn1.ObjectIdentifier{1, 3, 132, 0, 10}
+
+// parseSPKI decodes a DER SubjectPublicKeyInfo holding a key local signing
+// supports.
+func parseSPKI(der []byte) (crypto.PublicKey, error) {
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		switch key := pub.(type) {
+		case ed25519.PublicKey:
+			return key, nil
+		case *ecdsa.PublicKey:
+			if key.Curve == elliptic.P256() {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unsupported public key %T", pub)
+	}
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(der, &spki); err != nil {
+		return nil, fmt.Errorf("parsing public key: %w", err)
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(spki.Algorithm.Parameters.FullBytes, &curve); err != nil || !curve.Equal(oidSecp256k1) {
+		return nil, fmt.Errorf("unsupported public key algorithm")
+	}
+	return parseSecp256k1(spki.PublicKey.Bytes)
+}
+
+// rawECDSASignature converts a DER ECDSA signature to r‖s, with S
+// normalized low for secp256k1.
+func rawECDSASignature(der []byte, pub crypto.PublicKey) ([]byte, error) {
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("parsing ECDSA signature: %w", err)
+	}
+	if _, ok := pub.(*secp256k1PublicKey); ok && sig.S.Cmp(new(big.Int).Rsh(secp256k1N, 1)) > 0 {
+		sig.S.Sub(secp256k1N, sig.S)
+	}
+	raw := make([]byte, 64)
+	sig.R.FillBytes(raw[:32])
+	sig.S.FillBytes(raw[32:])
+	return raw, nil
+}
+
+// AWS KMS, through its JSON API with Signature Version 4 request signing.
+
+type awsKMSSigner struct {
+	keyID, region, endpoint string
+	pub                     crypto.PublicKey
+}
+
+func newAWSKMSSigner(keyID string) (*awsKMSSigner, error) {
+	region := lookupSetting("AWS_REGION")
+	if region == "" {
+		region = lookupSetting("AWS_DEFAULT_REGION")
+	}
+	if parts := strings.Split(keyID, ":"); len(parts) > 3 && strings.HasPrefix(keyID, "arn:") {
+		region = parts[3]
+	}
+	if region == "" {
+		return nil, fmt.Errorf("AWS KMS needs AWS_REGION or a key ARN")
+	}
+	if lookupSetting("AWS_ACCESS_KEY_ID") == "" || lookupSetting("AWS_SECRET_ACCESS_KEY") == "" {
+		return nil, fmt.Errorf("AWS KMS needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	endpoint := envOr("AWS_ENDPOINT_URL", "https://kms."+region+".amazonaws.com")
+	k := &awsKMSSigner{keyID: keyID, region: region, endpoint: strings.TrimRight(endpoint, "/")}
+
+	var out struct {
+		PublicKey []byte
+		KeySpec   string
+	}
+	if err := k.call("GetPublicKey", map[string]string{"KeyId": keyID}, &out); err != nil {
+		return nil, err
+	}
+	pub, err := parseSPKI(out.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("AWS KMS key %s (%s): %w", keyID, out.KeySpec, err)
+	}
+	k.pub = pub
+	return k, nil
+}
+
+func (k *awsKMSSigner) Public() crypto.PublicKey { return k.pub }
+
+func (k *awsKMSSigner) Sign(msg []byte) ([]byte, error) {
+	if _, ok := k.pub.(ed25519.PublicKey); ok {
+		return nil, fmt.Errorf("AWS KMS Ed25519 keys are not supported")
+	}
+	digest := sha256.Sum256(msg)
+	var out struct{ Signature []byte }
+	err := k.call("Sign", map[string]interface{}{
+		"KeyId":            k.keyID,
+		"Message":          digest[:],
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": "ECDSA_SHA_256",
+	}, &out)
+	if err != nil {
+		return nil, err
+	}
+	return rawECDSASignature(out.Signature, k.pub)
+}
+
+func (k *awsKMSSigner) call(action string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", k.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	signAWSRequest(req, body, k.region, "kms", time.Now().UTC())
+
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("AWS KMS %s: %w", action, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("AWS KMS %s: %w", action, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("AWS KMS %s: HTTP %d: %s", action, resp.StatusCode, data)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// signAWSRequest adds SigV4 headers for the environment's credentials.
+func signAWSRequest(req *http.Request, body []byte, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if token := lookupSetting("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+
+	var names []string
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery,
+		canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+lookupSetting("AWS_SECRET_ACCESS_KEY")), date)
+	key = mac(key, region)
+	key = mac(key, service)
+	key = mac(key, "aws4_request")
+	signature := hex.EncodeToString(mac(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+lookupSetting("AWS_ACCESS_KEY_ID")+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Google Cloud KMS, through its REST API.
+
+const (
+	gcpKMSEndpoint   = "https://cloudkms.googleapis.com/v1/"
+	gcpKMSScope      = "https://www.googleapis.com/auth/cloudkms"
+	gcpTokenURL      = "https://oauth2.googleapis.com/token"
+	gcpMetadataToken = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+type gcpKMSSigner struct {
+	name string
+	pub  crypto.PublicKey
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newGCPKMSSigner(name string) (*gcpKMSSigner, error) {
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/cryptoKeyVersions/") {
+		return nil, fmt.Errorf("Cloud KMS keys are named projects/.../cryptoKeyVersions/N, got %q", name)
+	}
+	k := &gcpKMSSigner{name: name}
+	var out struct {
+		PEM       string `json:"pem"`
+		Algorithm string `json:"algorithm"`
+	}
+	if err := k.call("GET", name+"/publicKey", nil, &out); err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode([]byte(out.PEM))
+	if block == nil {
+		return nil, fmt.Errorf("Cloud KMS key %s: public key is not PEM", name)
+	}
+	pub, err := parseSPKI(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("Cloud KMS key %s (%s): %w", name, out.Algorithm, err)
+	}
+	k.pub = pub
+	return k, nil
+}
+
+func (k *gcpKMSSigner) Public() crypto.PublicKey { return k.pub }
+
+func (k *gcpKMSSigner) Sign(msg []byte) ([]byte, error) {
+	in := map[string]interface{}{"data": msg}
+	_, isEd25519 := k.pub.(ed25519.PublicKey)
+	if !isEd25519 {
+		digest := sha256.Sum256(msg)
+		in = map[string]interface{}{"digest": map[string][]byte{"sha256": digest[:]}}
+	}
+	var out struct {
+		Signature []byte `json:"signature"`
+	}
+	if err := k.call("POST", k.name+":asymmetricSign", in, &out); err != nil {
+		return nil, err
+	}
+	if isEd25519 {
+		return out.Signature, nil
+	}
+	return rawECDSASignature(out.Signature, k.pub)
+}
+
+func (k *gcpKMSSigner) call(method, path string, in, out interface{}) error {
+	token, err := k.accessToken()
+	if err != nil {
+		return fmt.Errorf("Cloud KMS credentials: %w", err)
+	}
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, gcpKMSEndpoint+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Cloud KMS: %w", err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("Cloud KMS: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Cloud KMS %s: HTTP %d: %s", path, resp.StatusCode, data)
+	}
+	return json.Unmarshal(data, out)
+}
+
+// accessToken returns a cached OAuth2 token for the service account in
+// GOOGLE_APPLICATION_CREDENTIALS, or from the metadata server.
+func (k *gcpKMSSigner) accessToken() (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.token != "" && time.Until(k.expires) > time.Minute {
+		return k.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if path := lookupSetting("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		sa, err := readGoogleServiceAccount(path)
+		if err != nil {
+			return "", err
+		}
+		assertion, err := gcpTokenAssertion(sa)
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		req, err = http.NewRequest("POST", gcpTokenURL, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req, err = http.NewRequest("GET", gcpMetadataToken, nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+	resp, err := kmsClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request: HTTP %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil || tok.AccessToken == "" {
+		return "", fmt.Errorf("token request: no access token")
+	}
+	k.token, k.expires = tok.AccessToken, time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second)
+	return k.token, nil
+}
+
+// gcpTokenAssertion signs the JWT a service account exchanges for a token.
+func gcpTokenAssertion(sa *googleServiceAccount) (string, error) {
+	now := time.Now().Unix()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": sa.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": gcpKMSScope,
+		"aud":   gcpTokenURL,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(input))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// PKCS#11 tokens, through pkcs11-tool. The service is built without cgo,
+// so the HSM's library is loaded by OpenSC's tool rather than in-process.
+// The PIN reaches the tool through its environment, which unlike its
+// command line is not visible to other users of the host.
+
+type pkcs11Signer struct {
+	args []string // selects the module, token and key
+	pin  string
+	pub  crypto.PublicKey
+}
+
+// newPKCS11Signer parses an RFC 7512 URI (without the "pkcs11:" scheme).
+// The key is chosen by id or object label, the library by module-path and
+// the PIN by pin-source (a file) or pin-value.
+func newPKCS11Signer(uri string) (*pkcs11Signer, error) {
+	path, query, _ := strings.Cut(uri, "?")
+	attr := map[string]string{}
+	for _, part := range strings.Split(path, ";") {
+		if k, v, ok := strings.Cut(part, "="); ok {
+			value, err := url.PathUnescape(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid PKCS#11 URI attribute %s", k)
+			}
+			attr[k] = value
+		}
+	}
+	q, err := url.ParseQuery(strings.ReplaceAll(query, ";", "&"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid PKCS#11 URI query: %w", err)
+	}
+
+	var args []string
+	if m := q.Get("module-path"); m != "" {
+		args = append(args, "--module", m)
+	}
+	if t := attr["token"]; t != "" {
+		args = append(args, "--token-label", t)
+	}
+	switch {
+	case attr["id"] != "":
+		args = append(args, "--id", hex.EncodeToString([]byte(attr["id"])))
+	case attr["object"] != "":
+		args = append(args, "--label", attr["object"])
+	default:
+		return nil, fmt.Errorf("the PKCS#11 URI must name the key by id or object")
+	}
+	pin := q.Get("pin-value")
+	if src := q.Get("pin-source"); src != "" {
+		data, err := os.ReadFile(strings.TrimPrefix(src, "file:"))
+		if err != nil {
+			return nil, fmt.Errorf("reading PKCS#11 PIN: %w", err)
+		}
+		pin = strings.TrimSpace(string(data))
+	}
+	if pin != "" {
+		args = append(args, "--login", "--pin", "env:PKCS11_PIN")
+	}
+
+	k := &pkcs11Signer{args: args, pin: pin}
+	der, err := k.run(nil, "--read-object", "--type", "pubkey")
+	if err != nil {
+		return nil, err
+	}
+	if k.pub, err = parseSPKI(der); err != nil {
+		return nil, fmt.Errorf("PKCS#11 key: %w", err)
+	}
+	return k, nil
+}
+
+func (k *pkcs11Signer) Public() crypto.PublicKey { return k.pub }
+
+func (k *pkcs11Signer) Sign(msg []byte) ([]byte, error) {
+	if _, ok := k.pub.(ed25519.PublicKey); ok {
+		return k.run(msg, "--sign", "--mechanism", "EDDSA")
+	}
+	digest := sha256.Sum256(msg)
+	der, err := k.run(digest[:], "--sign", "--mechanism", "ECDSA", "--signature-format", "openssl")
+	if err != nil {
+		return nil, err
+	}
+	return rawECDSASignature(der, k.pub)
+}
+
+func (k *pkcs11Signer) run(input []byte, op ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "pkcs11-tool", append(append([]string{}, k.args...), op...)...)
+	cmd.Stdin = bytes.NewReader(input)
+	if k.pin != "" {
+		cmd.Env = append(os.Environ(), "PKCS11_PIN="+k.pin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("pkcs11-tool %s: %s", op[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, fmt.Errorf("pkcs11-tool: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,230 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSignAWSRequest checks requests are signed as in AWS's published
+// Signature Version 4 examples: the test suite's cases, whose credentials
+// are AKIDEXAMPLE, and the IAM ListUsers request of the SigV4 guide.
+func TestSignAWSRequest(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		name, method, url, service string
+		headers                    map[string]string
+		want                       string
+	}{
+		{
+			name: "get-vanilla", method: "GET", url: "https://example.amazonaws.com/", service: "service",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name: "post-vanilla", method: "POST", url: "https://example.amazonaws.com/", service: "service",
+			want: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name: "post-header-key-sort", method: "POST", url: "https://example.amazonaws.com/", service: "service",
+			headers: map[string]string{"My-Header1": "value1"},
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;my-header1;x-amz-date, Signature=c5410059b04c1ee005303aed430f6e6645f61f4dc9e1461ec8f8916fdf18852c",
+		},
+		{
+			name: "iam-list-users", method: "GET", url: "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", service: "iam",
+			headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	} {
+		req, _ := http.NewRequest(c.method, c.url, nil)
+		for name, value := range c.headers {
+			req.Header.Set(name, value)
+		}
+		signAWSRequest(req, nil, "us-east-1", c.service, now)
+		if got := req.Header.Get("Authorization"); got != c.want {
+			t.Errorf("%s:\n got %s\nwant %s", c.name, got, c.want)
+		}
+		if got := req.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+			t.Errorf("%s: X-Amz-Date %s", c.name, got)
+		}
+	}
+
+	t.Setenv("AWS_SESSION_TOKEN", "session-token")
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signAWSRequest(req, nil, "us-east-1", "service", now)
+	if req.Header.Get("X-Amz-Security-Token") != "session-token" || !strings.Contains(req.Header.Get("Authorization"), "x-amz-security-token") {
+		t.Errorf("session token not signed: %v", req.Header)
+	}
+}
+
+// redirectTransport sends every request to a test server, keeping its path.
+type redirectTransport struct{ target *url.URL }
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = rt.target.Scheme, rt.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// useKMSServer sends the KMS clients' requests to srv for one test.
+func useKMSServer(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	target, _ := url.Parse(srv.URL)
+	saved := kmsClient
+	kmsClient = &http.Client{Transport: redirectTransport{target}}
+	t.Cleanup(func() { kmsClient = saved })
+}
+
+// TestGCPKMSSigner checks a service account's JWT is exchanged for a token,
+// once, and that the token signs with the Cloud KMS key.
+func TestGCPKMSSigner(t *testing.T) {
+	account, _ := rsa.GenerateKey(rand.Reader, 2048)
+	accountDER, _ := x509.MarshalPKCS8PrivateKey(account)
+	saPath := filepath.Join(t.TempDir(), "sa.json")
+	sa, _ := json.Marshal(map[string]string{
+		"client_email":   "signer@testa.iam.gserviceaccount.com",
+		"private_key_id": "key-1",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: accountDER})),
+	})
+	if err := os.WriteFile(saPath, sa, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", saPath)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keyDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	const name = "projects/testa/locations/global/keyRings/issuer/cryptoKeys/vc/cryptoKeyVersions/1"
+	exchanges := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			exchanges++
+			r.ParseForm()
+			if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+				http.Error(w, "grant type", http.StatusBadRequest)
+				return
+			}
+			parts := strings.Split(r.Form.Get("assertion"), ".")
+			if len(parts) != 3 {
+				http.Error(w, "invalid assertion", http.StatusUnauthorized)
+				return
+			}
+			sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+			digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+			var claims struct {
+				Iss, Scope, Aud string
+			}
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			json.Unmarshal(payload, &claims)
+			if rsa.VerifyPKCS1v15(&account.PublicKey, crypto.SHA256, digest[:], sig) != nil ||
+				claims.Iss != "signer@testa.iam.gserviceaccount.com" || claims.Scope != gcpKMSScope || claims.Aud != gcpTokenURL {
+				http.Error(w, "invalid assertion", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "ya29.test", "expires_in": 3600, "token_type": "Bearer"})
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer ya29.test" {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/" + name + "/publicKey":
+			json.NewEncoder(w).Encode(map[string]string{
+				"pem":       string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: keyDER})),
+				"algorithm": "EC_SIGN_P256_SHA256",
+			})
+		case "/v1/" + name + ":asymmetricSign":
+			var in struct {
+				Digest struct{ SHA256 []byte } `json:"digest"`
+			}
+			json.NewDecoder(r.Body).Decode(&in)
+			sig, _ := ecdsa.SignASN1(rand.Reader, key, in.Digest.SHA256)
+			json.NewEncoder(w).Encode(map[string][]byte{"signature": sig})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	useKMSServer(t, srv)
+
+	signer, err := newKMSSigner("gcpkms://" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("credential")
+	sig, err := signer.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	if len(sig) != 64 || !ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Errorf("signature %x does not verify", sig)
+	}
+	if exchanges != 1 {
+		t.Errorf("%d token exchanges, want 1", exchanges)
+	}
+}
+
+// TestAWSKMSSigner checks the AWS KMS calls are signed for the key's
+// region and their signature is converted for the proof.
+func TestAWSKMSSigner(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	keyDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/kms/aws4_request") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			json.NewEncoder(w).Encode(map[string]interface{}{"PublicKey": keyDER, "KeySpec": "ECC_NIST_P256"})
+		case "TrentService.Sign":
+			var in struct{ Message []byte }
+			json.Unmarshal(body, &in)
+			sig, _ := ecdsa.SignASN1(rand.Reader, key, in.Message)
+			json.NewEncoder(w).Encode(map[string][]byte{"Signature": sig})
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	t.Setenv("AWS_ENDPOINT_URL", srv.URL)
+
+	signer, err := newKMSSigner("awskms:arn:aws:kms:eu-west-1:111122223333:key/1234abcd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("credential")
+	sig, err := signer.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(msg)
+	if len(sig) != 64 || !ecdsa.Verify(&key.PublicKey, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Errorf("signature %x does not verify", sig)
+	}
+}
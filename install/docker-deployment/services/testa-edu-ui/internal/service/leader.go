@@ -0,0 +1,118 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Leader election. A DATA_DIR has one writer: each store is held in
+// memory and rewrites its whole file on every change, so two processes
+// serving from one DATA_DIR would overwrite each other's records. Replicas
+// that share load need a DATA_DIR each.
+//
+// LEADER_ELECTION=true runs a standby beside the writer, as during a
+// rolling restart or on a failover node mounting the same volume. Each
+// process tries for an exclusive lock on DATA_DIR/leader.lock; the one
+// holding it is the leader and serves, and the others fail /readyz, run no
+// jobs and try again every leaderRetryInterval. The lock is the kernel's,
+// so it is released as the leader exits, however it exits; the standby
+// that takes it reopens the stores from DATA_DIR, so it starts from what
+// the leader last wrote, and only then reports ready. The lock needs a
+// Unix system (leader_unix.go).
+//
+// Without LEADER_ELECTION, a single process is its own leader: it takes
+// the lock all the same and will not start while another process holds
+// it. The CLI's commands that write DATA_DIR take it too (cli.go), so they
+// refuse to run beside the service.
+
+const leaderRetryInterval = 15 * time.Second
+
+// leading is set while this process holds the leader lock.
+var leading atomic.Bool
+
+// leaderLock is the held lock file, kept open to hold the lock.
+var leaderLock *os.File
+
+// isLeader reports whether this process writes DATA_DIR and runs the
+// maintenance jobs.
+func isLeader() bool {
+	return !config.LeaderElection || leading.Load()
+}
+
+// startLeaderElection tries for the leader lock now and, failing that,
+// until it is had. A single process takes it or exits.
+func startLeaderElection() {
+	if !config.LeaderElection {
+		if err := lockDataDir(); err != nil {
+			fatal("DATA_DIR", "err", err)
+		}
+		return
+	}
+	if tryLead() {
+		leading.Store(true)
+		slog.Info("leader: this process writes DATA_DIR")
+		return
+	}
+	slog.Info("leader: another process leads; standing by")
+	go func() {
+		for !tryLead() {
+			time.Sleep(leaderRetryInterval)
+		}
+		// The stores were read before the old leader last wrote them.
+		if err := openStores(); err != nil {
+			fatal("leader: reopening stores", "err", err)
+		}
+		leading.Store(true)
+		slog.Info("leader: took over from the previous leader")
+	}()
+}
+
+// tryLead takes the leader lock if it is free. At startup the caller is
+// leader as soon as it has the lock; on a takeover, once it has reopened
+// the stores.
+func tryLead() bool {
+	held, err := takeDataDirLock()
+	if err != nil {
+		slog.Error("leader: locking", "err", err)
+	}
+	return held
+}
+
+// lockDataDir takes the lock for a process that writes DATA_DIR without
+// an election, failing while another process holds it. Systems without
+// flock(2) are not locked.
+func lockDataDir() error {
+	if !leaderElectionSupported {
+		return nil
+	}
+	held, err := takeDataDirLock()
+	switch {
+	case err != nil:
+		return fmt.Errorf("locking %s: %w", config.DataDir, err)
+	case !held:
+		return fmt.Errorf("%s is in use by another process", config.DataDir)
+	}
+	return nil
+}
+
+// takeDataDirLock takes DATA_DIR/leader.lock if it is free, and keeps it.
+func takeDataDirLock() (bool, error) {
+	f, err := os.OpenFile(filepath.Join(config.DataDir, "leader.lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return false, err
+	}
+	if held, err := lockFile(f); !held {
+		f.Close()
+		return false, err
+	}
+	// The holder, for whoever looks.
+	host, _ := os.Hostname()
+	f.Truncate(0)
+	f.WriteAt([]byte(fmt.Sprintf("%s %d %s\n", host, os.Getpid(), time.Now().UTC().Format(time.RFC3339))), 0)
+	leaderLock = f
+	return true, nil
+}
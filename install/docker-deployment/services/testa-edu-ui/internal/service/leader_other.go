@@ -0,0 +1,16 @@
+//go:build !unix
+
+package service
+
+import (
+	"errors"
+	"os"
+)
+
+// Leader election locks with flock(2), which other systems lack;
+// parseConfig refuses LEADER_ELECTION there.
+const leaderElectionSupported = false
+
+func lockFile(f *os.File) (bool, error) {
+	return false, errors.New("leader election needs a Unix system")
+}
@@ -0,0 +1,96 @@
+//go:build unix
+
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestLeaderElection checks one replica leads at a time, and another takes
+// over once the leader lets the lock go.
+func TestLeaderElection(t *testing.T) {
+	saved := config
+	t.Cleanup(func() {
+		config = saved
+		leading.Store(false)
+		if leaderLock != nil {
+			leaderLock.Close()
+			leaderLock = nil
+		}
+	})
+	config.DataDir = t.TempDir()
+	config.LeaderElection = true
+	if isLeader() {
+		t.Fatal("leading before the election")
+	}
+
+	// Another replica holds the lock.
+	other, err := os.OpenFile(filepath.Join(config.DataDir, "leader.lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := syscall.Flock(int(other.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if tryLead() || isLeader() {
+		t.Fatal("led while another process holds the lock")
+	}
+	rec := httptest.NewRecorder()
+	handleReadyz(rec, httptest.NewRequest("GET", "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"leader":{"status":"fail"`) {
+		t.Errorf("a standby's /readyz: %d %s", rec.Code, rec.Body)
+	}
+
+	other.Close()
+	if !tryLead() {
+		t.Fatal("did not take the lock from a leader that exited")
+	}
+	// Another process cannot take it now.
+	again, _ := os.OpenFile(filepath.Join(config.DataDir, "leader.lock"), os.O_RDWR, 0o600)
+	defer again.Close()
+	if held, _ := lockFile(again); held {
+		t.Error("a second process took the held lock")
+	}
+
+	config.LeaderElection = false
+	leading.Store(false)
+	if !isLeader() {
+		t.Error("a single process does not lead")
+	}
+}
+
+// TestLockDataDir checks a process without an election, the service or a
+// writing CLI command, will not share DATA_DIR with another holding it.
+func TestLockDataDir(t *testing.T) {
+	saved := config
+	t.Cleanup(func() {
+		config = saved
+		if leaderLock != nil {
+			leaderLock.Close()
+			leaderLock = nil
+		}
+	})
+	config.DataDir = t.TempDir()
+
+	service, err := os.OpenFile(filepath.Join(config.DataDir, "leader.lock"), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if held, err := lockFile(service); !held {
+		t.Fatal(err)
+	}
+	if err := lockDataDir(); err == nil || !strings.Contains(err.Error(), "in use by another process") {
+		t.Errorf("beside the running service: %v", err)
+	}
+	service.Close()
+	if err := lockDataDir(); err != nil {
+		t.Errorf("with the service stopped: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package service
+
+import (
+	"os"
+	"syscall"
+)
+
+const leaderElectionSupported = true
+
+// lockFile takes an exclusive lock on f without waiting, reporting whether
+// it is held; another holder is not an error.
+func lockFile(f *os.File) (bool, error) {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
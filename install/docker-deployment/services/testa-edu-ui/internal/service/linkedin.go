@@ -0,0 +1,67 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// LinkedIn "Add to profile". LinkedIn has no API for adding
+// certifications; instead a prefilled link opens the student's
+// "Add license or certification" form with the credential's name,
+// issuing organization, issue date and verification URL filled in.
+// LINKEDIN_ORGANIZATION_ID, the institution's LinkedIn company page ID,
+// links the certification to that page instead of naming it as text.
+
+const linkedInAddURL = "https://www.linkedin.com/profile/add"
+
+// linkedInURL returns the prefilled LinkedIn link for a credential.
+// verifyURL and id are empty when the credential is not stored here.
+func linkedInURL(form CredentialForm, theme Theme, issued time.Time, verifyURL, id string) string {
+	q := url.Values{}
+	q.Set("startTask", "CERTIFICATION_NAME")
+	name := form.Degree
+	if form.FieldOfStudy != "" {
+		name += ", " + form.FieldOfStudy
+	}
+	if name == "" {
+		name = theme.CertificateTitle
+	}
+	q.Set("name", name)
+	if config.LinkedInOrganizationID != "" {
+		q.Set("organizationId", config.LinkedInOrganizationID)
+	} else if form.Institution != "" {
+		q.Set("organizationName", form.Institution)
+	} else {
+		q.Set("organizationName", theme.Name)
+	}
+	if !issued.IsZero() {
+		q.Set("issueYear", strconv.Itoa(issued.Year()))
+		q.Set("issueMonth", strconv.Itoa(int(issued.Month())))
+	}
+	if verifyURL != "" {
+		q.Set("certUrl", verifyURL)
+	}
+	if id != "" {
+		q.Set("certId", id)
+	}
+	return linkedInAddURL + "?" + q.Encode()
+}
+
+// handleLinkedIn sends the student to LinkedIn with their session's
+// credential prefilled. The credential is stored, if consent allows, so
+// the certification can link to its verification page.
+func handleLinkedIn(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	verifyURL, id := certificateVerification(sess)
+	issued := sess.CreatedAt
+	if cred, ok := store.Get(id); ok {
+		issued = cred.IssuedAt
+	}
+	http.Redirect(w, r, linkedInURL(sess.Form, themeFor(sess.TenantID), issued, verifyURL, id), http.StatusFound)
+}
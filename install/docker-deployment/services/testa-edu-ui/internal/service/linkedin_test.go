@@ -0,0 +1,68 @@
+package service
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLinkedInURL checks the prefilled certification link carries the
+// credential's details, and the organization ID when one is configured.
+func TestLinkedInURL(t *testing.T) {
+	saved := config.LinkedInOrganizationID
+	t.Cleanup(func() { config.LinkedInOrganizationID = saved })
+
+	form := CredentialForm{Institution: "Testa University", Degree: "Bachelor of Science", FieldOfStudy: "Computer Science"}
+	issued := time.Date(2025, time.July, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		orgID string
+		form  CredentialForm
+		id    string
+		want  map[string]string
+	}{
+		{"stored", "", form, "abc", map[string]string{
+			"startTask":        "CERTIFICATION_NAME",
+			"name":             "Bachelor of Science, Computer Science",
+			"organizationName": "Testa University",
+			"issueYear":        "2025",
+			"issueMonth":       "7",
+			"certUrl":          "https://edu.example/verify/abc",
+			"certId":           "abc",
+		}},
+		{"organization ID", "1234", form, "abc", map[string]string{
+			"organizationId":   "1234",
+			"organizationName": "",
+		}},
+		{"not stored", "", CredentialForm{}, "", map[string]string{
+			"name":             defaultTheme.CertificateTitle,
+			"organizationName": defaultTheme.Name,
+			"certUrl":          "",
+			"certId":           "",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.LinkedInOrganizationID = tt.orgID
+			verify := ""
+			if tt.id != "" {
+				verify = "https://edu.example/verify/" + tt.id
+			}
+			link := linkedInURL(tt.form, defaultTheme, issued, verify, tt.id)
+			if !strings.HasPrefix(link, linkedInAddURL+"?") {
+				t.Fatalf("link = %q", link)
+			}
+			u, err := url.Parse(link)
+			if err != nil {
+				t.Fatal(err)
+			}
+			q := u.Query()
+			for k, want := range tt.want {
+				if got := q.Get(k); got != want {
+					t.Errorf("%s = %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+)
+
+// Listeners. Besides PORT, the service can listen on a Unix domain socket,
+// LISTEN_SOCKET, for a reverse proxy on the same host; LISTEN_SOCKET_MODE
+// sets its permissions (0660 by default, so the proxy's group can connect).
+// A stale socket left by a previous run is replaced.
+//
+// Started by systemd with socket activation (LISTEN_FDS and LISTEN_PID),
+// the service serves the sockets systemd passes instead of opening PORT,
+// which systemd then owns; LISTEN_SOCKET is opened still. Socket
+// activation is read on Unix systems only (listeners_unix.go).
+
+// systemdListenFDsStart is the first file descriptor systemd passes.
+const systemdListenFDsStart = 3
+
+// openListeners opens the listeners the plain HTTP server serves.
+func openListeners() ([]net.Listener, error) {
+	listeners, err := systemdListeners()
+	if err != nil {
+		return nil, err
+	}
+	if len(listeners) == 0 {
+		l, err := net.Listen("tcp", ":"+config.Port)
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	if config.ListenSocket != "" {
+		l, err := listenUnix(config.ListenSocket, config.ListenSocketMode)
+		if err != nil {
+			for _, open := range listeners {
+				open.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// listenUnix listens on a Unix socket at path, replacing a stale one.
+func listenUnix(path string, mode fs.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&fs.ModeSocket == 0 {
+			return nil, fmt.Errorf("LISTEN_SOCKET %s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("LISTEN_SOCKET %s is in use", path)
+		}
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
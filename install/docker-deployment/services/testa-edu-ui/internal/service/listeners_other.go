@@ -0,0 +1,10 @@
+//go:build !unix
+
+package service
+
+import "net"
+
+// systemdListeners returns no sockets: systemd runs on Unix systems only.
+func systemdListeners() ([]net.Listener, error) {
+	return nil, nil
+}
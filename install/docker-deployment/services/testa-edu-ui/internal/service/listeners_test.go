@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestListenUnix(t *testing.T) {
+	// A short directory: socket paths are limited to about 100 bytes.
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "http.sock")
+
+	l, err := listenUnix(path, 0o660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o660 {
+		t.Errorf("socket mode %v, %v", info.Mode(), err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	go srv.Serve(l)
+
+	// A socket in use is not taken over.
+	if _, err := listenUnix(path, 0o660); err == nil {
+		t.Error("listened on a socket in use")
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Errorf("body %q", body)
+	}
+
+	// A stale socket, left by a server that did not clean up, is replaced.
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	srv.Close()
+	l, err = listenUnix(path, fs.FileMode(0o600))
+	if err != nil {
+		t.Fatalf("stale socket: %v", err)
+	}
+	l.Close()
+
+	// Anything else at the path is left alone.
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listenUnix(path, 0o660); err == nil {
+		t.Error("replaced a regular file")
+	}
+}
+
+func TestSystemdListeners(t *testing.T) {
+	// Not started by systemd: LISTEN_PID names another process.
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if listeners, err := systemdListeners(); err != nil || listeners != nil {
+		t.Errorf("listeners for another process: %v, %v", listeners, err)
+	}
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "0")
+	if listeners, err := systemdListeners(); err != nil || listeners != nil {
+		t.Errorf("listeners with no sockets: %v, %v", listeners, err)
+	}
+	if os.Getenv("LISTEN_PID") == "" {
+		t.Error("LISTEN_PID cleared with no sockets passed")
+	}
+}
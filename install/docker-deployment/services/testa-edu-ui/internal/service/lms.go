@@ -0,0 +1,643 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LMS connectors. LMS_CONFIG_FILE names a JSON array of connectors, each
+// a Moodle or Canvas site whose course completions are issued the
+// credential mapped to the course:
+//
+//	[{"name": "moodle", "kind": "moodle", "url": "https://lms.example.ac.ke",
+//	  "token": "<web service token>", "secret": "<webhook secret>",
+//	  "tenant": "testa", "consent": ["issue", "store"], "poll": "1h",
+//	  "courses": {"42": {"degree": "Certificate in Data Science",
+//	                     "fieldOfStudy": "Data Science"}}}]
+//
+// A course's mapping holds issuance form fields (degree at least); the
+// student's name, number and completion date come from the LMS, and the
+// institution is the tenant's, or the connector's "institution". The
+// students' consent scopes are those the LMS's terms gave, ["issue"]
+// unless "consent" says more; with "store", the emailed credential
+// carries a claim link.
+//
+// The LMS posts its events to /api/lms/<name>/events with the secret as
+// bearer token: from Moodle, a webhook plugin forwarding
+// \core\event\course_completed; from Canvas, the course_completed live
+// event. With "poll", the courses' completions are also listed from the
+// LMS's API every interval, by the leader (see leader.go), to catch
+// events that were lost. Moodle's token needs core_user_get_users_by_field,
+// core_enrol_get_enrolled_users and core_completion_get_course_completion_status;
+// Canvas's is an access token of an account admin.
+//
+// Each completion is issued once: DATA_DIR/lms-issued.json records those
+// issued, by connector, course and LMS user. The credential is emailed to
+// the student when mail is set up. LMS_CONFIG_FILE holds the LMS tokens
+// and secrets and should be readable by the service alone.
+//
+// A connector with an "lti" platform also makes the issuance wizard an LTI
+// tool of its LMS, and needs no token if that is all it does; see lti.go.
+
+// lmsTimeout bounds a call to an LMS's API.
+const lmsTimeout = 30 * time.Second
+
+// LMSConnector is an LMS site whose course completions are issued.
+type LMSConnector struct {
+	Name        string                       `json:"name"`
+	Kind        string                       `json:"kind"` // moodle or canvas
+	URL         string                       `json:"url"`
+	Token       string                       `json:"token"`
+	Secret      string                       `json:"secret"`
+	Tenant      string                       `json:"tenant,omitempty"`
+	Institution string                       `json:"institution,omitempty"`
+	Format      string                       `json:"format,omitempty"`
+	Consent     []string                     `json:"consent,omitempty"`
+	Poll        string                       `json:"poll,omitempty"`
+	Courses     map[string]map[string]string `json:"courses"`
+	LTI         *LTIPlatform                 `json:"lti,omitempty"` // see lti.go
+
+	platform     lmsPlatform
+	pollInterval time.Duration
+}
+
+// lmsStudent is a student who completed a course.
+type lmsStudent struct {
+	ID          string
+	Name        string
+	Email       string
+	Number      string // the institution's student number, if the LMS has it
+	CompletedAt time.Time
+}
+
+// lmsPlatform is the API of a kind of LMS.
+type lmsPlatform interface {
+	// completion reads a webhook event, returning the course and student
+	// of a course completion, or ok false for other events.
+	completion(ctx context.Context, body []byte) (course string, s lmsStudent, ok bool, err error)
+	// completed lists the students who completed a course.
+	completed(ctx context.Context, course string) ([]lmsStudent, error)
+}
+
+// lmsConnectors are the connectors, by name.
+var lmsConnectors map[string]*LMSConnector
+
+var lmsPlatforms = map[string]func(c *LMSConnector) lmsPlatform{
+	"moodle": func(c *LMSConnector) lmsPlatform { return &moodlePlatform{c: c} },
+	"canvas": func(c *LMSConnector) lmsPlatform { return &canvasPlatform{c: c} },
+}
+
+// LoadLMSConnectors reads the connectors of LMS_CONFIG_FILE, if set.
+func LoadLMSConnectors(path string) (map[string]*LMSConnector, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading LMS connectors: %w", err)
+	}
+	var list []*LMSConnector
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("parsing LMS connectors: %w", err)
+	}
+	connectors := make(map[string]*LMSConnector, len(list))
+	for _, c := range list {
+		if err := c.init(); err != nil {
+			return nil, fmt.Errorf("LMS connector %q: %w", c.Name, err)
+		}
+		if connectors[c.Name] != nil {
+			return nil, fmt.Errorf("LMS connector %q: defined twice", c.Name)
+		}
+		connectors[c.Name] = c
+	}
+	return connectors, nil
+}
+
+// init checks a connector and sets it up.
+func (c *LMSConnector) init() error {
+	if !tenantIDPattern.MatchString(c.Name) {
+		return errors.New("names are lowercase letters, digits and dashes")
+	}
+	newPlatform := lmsPlatforms[c.Kind]
+	if newPlatform == nil {
+		return fmt.Errorf("unknown kind %q, want moodle or canvas", c.Kind)
+	}
+	c.platform = newPlatform(c)
+	c.URL = strings.TrimRight(c.URL, "/")
+	if !validHTTPURL(c.URL) {
+		return fmt.Errorf("url %q must be an http or https URL", c.URL)
+	}
+	if c.LTI == nil && c.Token == "" {
+		return errors.New("needs a token, or an lti platform")
+	}
+	if c.Token != "" && len(c.Secret) < minSecretLength {
+		return fmt.Errorf("needs a webhook secret of at least %d characters", minSecretLength)
+	}
+	if c.LTI != nil {
+		if err := c.LTI.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Tenant == "" && c.Institution == "" {
+		return errors.New("needs a tenant or an institution")
+	}
+	if c.Format == "" {
+		c.Format = FormatLDP
+	}
+	if !validFormat(c.Format) || c.Format == FormatAnonCreds {
+		return fmt.Errorf("unsupported credential format %q", c.Format)
+	}
+	if len(c.Consent) == 0 {
+		c.Consent = []string{ConsentScopeIssue}
+	}
+	for _, scope := range c.Consent {
+		if !slices.Contains(consentScopes, scope) {
+			return fmt.Errorf("unknown consent scope %q", scope)
+		}
+	}
+	if !slices.Contains(c.Consent, ConsentScopeIssue) {
+		return errors.New("the students' consent to issuance is required")
+	}
+	if c.Poll != "" {
+		d, err := time.ParseDuration(c.Poll)
+		if err != nil || d < time.Minute {
+			return fmt.Errorf("poll %q must be a duration of a minute or more", c.Poll)
+		}
+		if c.Token == "" {
+			return errors.New("polling needs a token")
+		}
+		c.pollInterval = d
+	}
+	if c.Token != "" && len(c.Courses) == 0 {
+		return errors.New("maps no courses")
+	}
+	for course, fields := range c.Courses {
+		if fields["degree"] == "" {
+			return fmt.Errorf("course %s maps no degree", course)
+		}
+	}
+	return nil
+}
+
+// lmsLedger records the completions issued, so each is issued once.
+type lmsLedger struct {
+	path string
+
+	mu     sync.Mutex
+	issued map[string]lmsIssue
+	// pending are the completions being issued.
+	pending map[string]bool
+}
+
+type lmsIssue struct {
+	SubjectID    string    `json:"subjectId"`
+	CredentialID string    `json:"credentialId,omitempty"`
+	IssuedAt     time.Time `json:"issuedAt"`
+}
+
+var lmsIssued *lmsLedger
+
+func openLMSLedger(dataDir string) (*lmsLedger, error) {
+	l := &lmsLedger{
+		path:    filepath.Join(dataDir, "lms-issued.json"),
+		issued:  make(map[string]lmsIssue),
+		pending: make(map[string]bool),
+	}
+	data, err := os.ReadFile(l.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &l.issued); err != nil {
+			return nil, fmt.Errorf("parsing LMS ledger: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading LMS ledger: %w", err)
+	}
+	return l, nil
+}
+
+func lmsKey(connector, course, user string) string {
+	return connector + "/" + course + "/" + user
+}
+
+// claim reserves a completion for issuing, reporting false if it was
+// issued, or is being issued, already.
+func (l *lmsLedger) claim(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.issued[key]; ok || l.pending[key] {
+		return false
+	}
+	l.pending[key] = true
+	return true
+}
+
+// done records the outcome of a claimed completion: issued unless issue
+// is nil, when it may be tried again.
+func (l *lmsLedger) done(key string, issue *lmsIssue) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.pending, key)
+	if issue == nil {
+		return nil
+	}
+	l.issued[key] = *issue
+	data, err := json.Marshal(l.issued)
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing LMS ledger: %w", err)
+	}
+	return os.Rename(tmp, l.path)
+}
+
+// errLMSIssued is returned for completions already issued.
+var errLMSIssued = errors.New("already issued")
+
+// issueCompletion issues a course completion's credential, once.
+func (c *LMSConnector) issueCompletion(ctx context.Context, course string, s lmsStudent) (*Session, error) {
+	mapping, ok := c.Courses[course]
+	if !ok {
+		return nil, fmt.Errorf("course %s is not mapped", course)
+	}
+	key := lmsKey(c.Name, course, s.ID)
+	if !lmsIssued.claim(key) {
+		return nil, errLMSIssued
+	}
+	var issued *lmsIssue
+	defer func() {
+		if err := lmsIssued.done(key, issued); err != nil {
+			slog.ErrorContext(ctx, "LMS ledger", "err", err)
+		}
+	}()
+
+	rec := make(map[string]string, len(mapping)+4)
+	for k, v := range mapping {
+		rec[k] = v
+	}
+	rec["studentName"] = s.Name
+	rec["studentId"] = s.Number
+	if s.Number == "" {
+		rec["studentId"] = c.Name + ":" + s.ID
+	}
+	if rec["graduationDate"] == "" && !s.CompletedAt.IsZero() {
+		rec["graduationDate"] = s.CompletedAt.Format("2006-01-02")
+	}
+	sess := &Session{Format: c.Format, QRMode: liveConfig().QRMode, QROptions: defaultQROptions(), CreatedAt: time.Now()}
+	if c.Tenant != "" {
+		t, ok := tenants.Get(c.Tenant)
+		if !ok {
+			return nil, fmt.Errorf("unknown tenant %q", c.Tenant)
+		}
+		if !t.Issues(rec["degree"]) {
+			return nil, fmt.Errorf("%s does not issue %s", t.Name, rec["degree"])
+		}
+		rec["institution"], sess.IssuerDID, sess.TenantID = t.Name, t.Issuer(), t.ID
+	} else {
+		rec["institution"], sess.IssuerDID = c.Institution, config.IssuerDID
+	}
+	var err error
+	if sess.ProofType, err = resolveProofType("", sess.IssuerDID); err != nil {
+		return nil, err
+	}
+	if sess.QRMode == QRModeLink && !slices.Contains(c.Consent, ConsentScopeStore) {
+		sess.QRMode = QRModeCompact
+	}
+	if sess.Token, err = agentClient.WithContext(ctx).GetToken(); err != nil {
+		return nil, fmt.Errorf("authenticating with the agent: %w", err)
+	}
+	if err := issueRecord(ctx, sess, rec, c.Consent); err != nil {
+		return nil, err
+	}
+	issuancesTotal.inc(sess.Format)
+	issued = &lmsIssue{SubjectID: sess.Form.SubjectDID, IssuedAt: time.Now().UTC()}
+	if slices.Contains(c.Consent, ConsentScopeStore) {
+		if issued.CredentialID, _, err = storeSessionCredential(sess); err != nil {
+			slog.ErrorContext(ctx, "LMS: storing credential", "err", err)
+		}
+	}
+	if sess.QR, err = generateQRFor(sess); err != nil {
+		slog.ErrorContext(ctx, "LMS: QR error", "err", err)
+	}
+	if s.Email != "" && mailEnabled() {
+		sess.Email, sess.Emailed = s.Email, true
+		if d, err := queueCredentialEmail(sess, s.Email); err != nil {
+			slog.ErrorContext(ctx, "LMS: email delivery error", "err", err)
+		} else {
+			sess.EmailDeliveryID = d.ID
+		}
+	}
+	notifyIssued(sess)
+	anchorIssued(sess)
+	return sess, nil
+}
+
+// handleLMSEvent issues the credential of a course completion an LMS
+// posts. Other events are acknowledged and ignored.
+func handleLMSEvent(w http.ResponseWriter, r *http.Request) {
+	c := lmsConnectors[r.PathValue("connector")]
+	scheme, secret, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if c == nil || c.Secret == "" || !strings.EqualFold(scheme, "Bearer") || subtle.ConstantTimeCompare([]byte(secret), []byte(c.Secret)) != 1 {
+		slog.WarnContext(r.Context(), "LMS: unauthorized event", "connector", r.PathValue("connector"))
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*lmsTimeout)
+	defer cancel()
+	course, student, ok, err := c.platform.completion(ctx, body)
+	if err != nil {
+		slog.ErrorContext(ctx, "LMS event", "connector", c.Name, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	status := "ignored"
+	var credentialID string
+	if _, mapped := c.Courses[course]; ok && mapped {
+		ctx = withLogFields(ctx, slog.String("lms", c.Name), slog.String("course", course))
+		sess, err := c.issueCompletion(ctx, course, student)
+		switch {
+		case errors.Is(err, errLMSIssued):
+			status = "already issued"
+		case err != nil:
+			slog.ErrorContext(ctx, "LMS: issuing", "err", err)
+			http.Error(w, "Failed to issue the credential", http.StatusBadGateway)
+			return
+		default:
+			status, credentialID = "issued", sess.CredentialID
+			slog.InfoContext(ctx, "LMS: credential issued", "subject", sess.Form.SubjectDID)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status, "credentialId": credentialID})
+}
+
+// pollLMS issues the completions of a connector's courses not issued yet.
+func pollLMS(ctx context.Context, c *LMSConnector) (issued int) {
+	for course := range c.Courses {
+		students, err := c.platform.completed(ctx, course)
+		if err != nil {
+			slog.ErrorContext(ctx, "LMS: polling", "connector", c.Name, "course", course, "err", err)
+			continue
+		}
+		for _, s := range students {
+			_, err := c.issueCompletion(withLogFields(ctx, slog.String("lms", c.Name), slog.String("course", course)), course, s)
+			switch {
+			case errors.Is(err, errLMSIssued):
+			case err != nil:
+				slog.ErrorContext(ctx, "LMS: issuing", "connector", c.Name, "course", course, "err", err)
+			default:
+				issued++
+			}
+		}
+	}
+	return issued
+}
+
+// startLMSPolling polls the connectors with a poll interval while this
+// process leads.
+func startLMSPolling() {
+	for _, c := range lmsConnectors {
+		if c.pollInterval == 0 {
+			continue
+		}
+		go func(c *LMSConnector) {
+			for {
+				time.Sleep(c.pollInterval)
+				if !isLeader() {
+					continue
+				}
+				if n := pollLMS(serverContext, c); n > 0 {
+					slog.Info("LMS: issued polled completions", "connector", c.Name, "issued", n)
+				}
+			}
+		}(c)
+	}
+}
+
+// lmsGet fetches an LMS API URL into out, returning the response's next
+// page link, if any.
+func lmsGet(ctx context.Context, req *http.Request, out interface{}) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, lmsTimeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, resp.Status)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 16<<20)).Decode(out); err != nil {
+		return "", fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return nextLink(resp.Header.Get("Link")), nil
+}
+
+var linkNext = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextLink is the next page of a Link header (RFC 8288).
+func nextLink(header string) string {
+	if m := linkNext.FindStringSubmatch(header); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// moodlePlatform is Moodle's REST web service API.
+type moodlePlatform struct {
+	c *LMSConnector
+}
+
+type moodleUser struct {
+	ID       int    `json:"id"`
+	FullName string `json:"fullname"`
+	Email    string `json:"email"`
+	IDNumber string `json:"idnumber"`
+}
+
+func (u moodleUser) student() lmsStudent {
+	return lmsStudent{ID: strconv.Itoa(u.ID), Name: u.FullName, Email: u.Email, Number: u.IDNumber}
+}
+
+// call calls a web service function. The token goes in the POST body,
+// so it is in no URL an error might print.
+func (m *moodlePlatform) call(ctx context.Context, function string, params url.Values, out interface{}) error {
+	params.Set("wstoken", m.c.Token)
+	params.Set("wsfunction", function)
+	params.Set("moodlewsrestformat", "json")
+	req, err := http.NewRequest(http.MethodPost, m.c.URL+"/webservice/rest/server.php", strings.NewReader(params.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var raw json.RawMessage
+	if _, err := lmsGet(ctx, req, &raw); err != nil {
+		return err
+	}
+	// Moodle answers failures with 200 and an exception document.
+	var exception struct {
+		Exception string `json:"exception"`
+		Message   string `json:"message"`
+	}
+	if json.Unmarshal(raw, &exception) == nil && exception.Exception != "" {
+		return fmt.Errorf("moodle %s: %s", function, exception.Message)
+	}
+	return json.Unmarshal(raw, out)
+}
+
+func (m *moodlePlatform) completion(ctx context.Context, body []byte) (string, lmsStudent, bool, error) {
+	var event struct {
+		EventName     string `json:"eventname"`
+		CourseID      int    `json:"courseid"`
+		RelatedUserID int    `json:"relateduserid"`
+		TimeCreated   int64  `json:"timecreated"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return "", lmsStudent{}, false, fmt.Errorf("expected a Moodle event: %w", err)
+	}
+	if event.EventName != `\core\event\course_completed` {
+		return "", lmsStudent{}, false, nil
+	}
+	course := strconv.Itoa(event.CourseID)
+	if _, mapped := m.c.Courses[course]; !mapped {
+		return course, lmsStudent{}, false, nil
+	}
+	var users []moodleUser
+	if err := m.call(ctx, "core_user_get_users_by_field", url.Values{"field": {"id"}, "values[0]": {strconv.Itoa(event.RelatedUserID)}}, &users); err != nil {
+		return "", lmsStudent{}, false, err
+	}
+	if len(users) != 1 {
+		return "", lmsStudent{}, false, fmt.Errorf("moodle has no user %d", event.RelatedUserID)
+	}
+	s := users[0].student()
+	s.CompletedAt = time.Unix(event.TimeCreated, 0).UTC()
+	return course, s, true, nil
+}
+
+func (m *moodlePlatform) completed(ctx context.Context, course string) ([]lmsStudent, error) {
+	var users []moodleUser
+	if err := m.call(ctx, "core_enrol_get_enrolled_users", url.Values{"courseid": {course}}, &users); err != nil {
+		return nil, err
+	}
+	var students []lmsStudent
+	for _, u := range users {
+		var status struct {
+			CompletionStatus struct {
+				Completed bool `json:"completed"`
+			} `json:"completionstatus"`
+		}
+		if err := m.call(ctx, "core_completion_get_course_completion_status", url.Values{"courseid": {course}, "userid": {strconv.Itoa(u.ID)}}, &status); err != nil {
+			return nil, err
+		}
+		if status.CompletionStatus.Completed {
+			students = append(students, u.student())
+		}
+	}
+	return students, nil
+}
+
+// canvasPlatform is Canvas's REST API.
+type canvasPlatform struct {
+	c *LMSConnector
+}
+
+type canvasUser struct {
+	ID        json.Number `json:"id"`
+	Name      string      `json:"name"`
+	Email     string      `json:"email"`
+	SISUserID string      `json:"sis_user_id"`
+}
+
+func (u canvasUser) student() lmsStudent {
+	return lmsStudent{ID: u.ID.String(), Name: u.Name, Email: u.Email, Number: u.SISUserID}
+}
+
+func (p *canvasPlatform) get(ctx context.Context, target string, out interface{}) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.c.Token)
+	return lmsGet(ctx, req, out)
+}
+
+func (p *canvasPlatform) completion(ctx context.Context, body []byte) (string, lmsStudent, bool, error) {
+	var event struct {
+		Metadata struct {
+			EventName string `json:"event_name"`
+		} `json:"metadata"`
+		Body struct {
+			User   canvasUser `json:"user"`
+			Course struct {
+				ID json.Number `json:"id"`
+			} `json:"course"`
+			Progress struct {
+				CompletedAt time.Time `json:"completed_at"`
+			} `json:"progress"`
+		} `json:"body"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return "", lmsStudent{}, false, fmt.Errorf("expected a Canvas live event: %w", err)
+	}
+	if event.Metadata.EventName != "course_completed" {
+		return "", lmsStudent{}, false, nil
+	}
+	course := event.Body.Course.ID.String()
+	s := event.Body.User.student()
+	if s.ID == "" {
+		return "", lmsStudent{}, false, errors.New("the event names no user")
+	}
+	// Live events may leave out the user's name and email; the profile
+	// has them.
+	if s.Name == "" || s.Email == "" {
+		var profile struct {
+			Name         string `json:"name"`
+			PrimaryEmail string `json:"primary_email"`
+			SISUserID    string `json:"sis_user_id"`
+		}
+		if _, err := p.get(ctx, p.c.URL+"/api/v1/users/"+url.PathEscape(s.ID)+"/profile", &profile); err != nil {
+			return "", lmsStudent{}, false, err
+		}
+		s.Name, s.Email, s.Number = profile.Name, profile.PrimaryEmail, profile.SISUserID
+	}
+	s.CompletedAt = event.Body.Progress.CompletedAt
+	return course, s, true, nil
+}
+
+// completed lists the course's students whose enrollment is completed.
+func (p *canvasPlatform) completed(ctx context.Context, course string) ([]lmsStudent, error) {
+	next := p.c.URL + "/api/v1/courses/" + url.PathEscape(course) +
+		"/users?enrollment_type[]=student&enrollment_state[]=completed&include[]=email&per_page=100"
+	var students []lmsStudent
+	for next != "" {
+		var users []canvasUser
+		var err error
+		if next, err = p.get(ctx, next, &users); err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			students = append(students, u.student())
+		}
+	}
+	return students, nil
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+const lmsTestSecret = "lms-webhook-secret-0123"
+
+// TestLMSMoodleEvent checks a Moodle course completion is issued its
+// course's credential, once, and other events are ignored.
+func TestLMSMoodleEvent(t *testing.T) {
+	moodle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.URL.Path != "/webservice/rest/server.php" || r.PostForm.Get("wstoken") != "ws-token" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		switch r.PostForm.Get("wsfunction") {
+		case "core_user_get_users_by_field":
+			fmt.Fprint(w, `[{"id": 7, "fullname": "Jane Wanjiku", "email": "jane@example.ac.ke", "idnumber": "TU-2021-0042"}]`)
+		default:
+			fmt.Fprint(w, `{"exception": "invalid_parameter_exception", "message": "Invalid parameter"}`)
+		}
+	}))
+	defer moodle.Close()
+
+	mock := mockagent.New()
+	srv := startE2EServer(t, mock.Start(t), "", e2eIssuerDID)
+	startLMSTest(t, fmt.Sprintf(`[{"name": "moodle", "kind": "moodle", "url": %q, "token": "ws-token",
+		"secret": %q, "institution": "Testa University",
+		"courses": {"42": {"degree": "Certificate", "fieldOfStudy": "Data Science"}}}]`, moodle.URL, lmsTestSecret))
+
+	post := func(secret, event string) (int, map[string]string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/api/lms/moodle/events", strings.NewReader(event))
+		req.Header.Set("Authorization", "Bearer "+secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var out map[string]string
+		json.NewDecoder(resp.Body).Decode(&out)
+		return resp.StatusCode, out
+	}
+	completed := `{"eventname": "\\core\\event\\course_completed", "courseid": 42, "relateduserid": 7, "timecreated": 1752537600}`
+	if status, _ := post("wrong-secret", completed); status != http.StatusUnauthorized {
+		t.Errorf("wrong secret: HTTP %d", status)
+	}
+	if _, out := post(lmsTestSecret, `{"eventname": "\\core\\event\\course_viewed", "courseid": 42}`); out["status"] != "ignored" {
+		t.Errorf("course viewed: %v", out)
+	}
+	if _, out := post(lmsTestSecret, strings.Replace(completed, `"courseid": 42`, `"courseid": 43`, 1)); out["status"] != "ignored" {
+		t.Errorf("unmapped course: %v", out)
+	}
+	if status, out := post(lmsTestSecret, completed); status != http.StatusOK || out["status"] != "issued" {
+		t.Fatalf("course completed: HTTP %d %v", status, out)
+	}
+	signed := string(mock.LastBody("/agent/credential/sign"))
+	for _, want := range []string{`"Certificate"`, `"Data Science"`, `"TU-2021-0042"`, `"2025-07-15`} {
+		if !strings.Contains(signed, want) {
+			t.Errorf("signed credential has no %s: %s", want, signed)
+		}
+	}
+	if _, out := post(lmsTestSecret, completed); out["status"] != "already issued" {
+		t.Errorf("repeated event: %v", out)
+	}
+}
+
+// TestLMSCanvasPoll checks polling issues every completed student of a
+// course once, across pages.
+func TestLMSCanvasPoll(t *testing.T) {
+	var canvas *httptest.Server
+	canvas = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer canvas-token" || r.URL.Path != "/api/v1/courses/9/users" ||
+			r.URL.Query().Get("enrollment_state[]") != "completed" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", fmt.Sprintf(`<%s/api/v1/courses/9/users?enrollment_state[]=completed&page=2>; rel="next"`, canvas.URL))
+			fmt.Fprint(w, `[{"id": 1, "name": "Jane Wanjiku", "sis_user_id": "TU-1"}]`)
+			return
+		}
+		fmt.Fprint(w, `[{"id": 2, "name": "John Otieno", "sis_user_id": "TU-2"}]`)
+	}))
+	defer canvas.Close()
+
+	startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	startLMSTest(t, fmt.Sprintf(`[{"name": "canvas", "kind": "canvas", "url": %q, "token": "canvas-token",
+		"secret": %q, "institution": "Testa University", "poll": "1h",
+		"courses": {"9": {"degree": "Diploma"}}}]`, canvas.URL, lmsTestSecret))
+
+	c := lmsConnectors["canvas"]
+	if n := pollLMS(context.Background(), c); n != 2 {
+		t.Errorf("first poll issued %d, want 2", n)
+	}
+	if n := pollLMS(context.Background(), c); n != 0 {
+		t.Errorf("second poll issued %d, want 0", n)
+	}
+	ledger, _ := os.ReadFile(filepath.Join(config.DataDir, "lms-issued.json"))
+	if !strings.Contains(string(ledger), `"canvas/9/1"`) || !strings.Contains(string(ledger), `"canvas/9/2"`) {
+		t.Errorf("ledger: %s", ledger)
+	}
+}
+
+func startLMSTest(t *testing.T, connectors string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "lms.json")
+	if err := os.WriteFile(path, []byte(connectors), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if lmsConnectors, err = LoadLMSConnectors(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lmsConnectors = nil })
+}
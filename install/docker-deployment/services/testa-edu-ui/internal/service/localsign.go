@@ -0,0 +1,404 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Local signing. With SIGNING_MODE=local the service signs credentials
+// itself instead of asking the agent, so demos and small deployments can
+// issue without running CREDEBL. The key is an Ed25519 or secp256k1 private
+// JWK, given in SIGNING_KEY or kept in DATA_DIR/signing-key.jwk, where one
+// is generated on first start to suit PROOF_TYPE; or a KMS or HSM key named
+// by SIGNING_KEY_URI (see kms.go). Credentials are issued by
+// the key's did:key, unless ISSUER_DID is a did:web, whose document the
+// service then publishes with every key it has signed with (see keyring.go).
+//
+// Linked Data proofs, JWT and SD-JWT credentials are signed in-process and
+// verified with the local verifier; AnonCreds, BBS+ derivation and other
+// agent features are unavailable in this mode.
+
+const (
+	SigningModeAgent = "agent"
+	SigningModeLocal = "local"
+)
+
+// localToken stands in for an agent token in local signing mode.
+const localToken = "local"
+
+var errAgentDisabled = errors.New("not available with SIGNING_MODE=local")
+
+// keySigner makes raw signatures: Ed25519 over the message, ECDSA as r‖s
+// over its SHA-256 digest.
+type keySigner interface {
+	Public() crypto.PublicKey
+	Sign(msg []byte) ([]byte, error)
+}
+
+type ed25519Signer ed25519.PrivateKey
+
+func (k ed25519Signer) Public() crypto.PublicKey { return ed25519.PrivateKey(k).Public() }
+
+func (k ed25519Signer) Sign(msg []byte) ([]byte, error) {
+	return ed25519.Sign(ed25519.PrivateKey(k), msg), nil
+}
+
+type secp256k1Signer struct{ *secp256k1PrivateKey }
+
+func (k secp256k1Signer) Public() crypto.PublicKey { return &k.secp256k1PublicKey }
+
+func (k secp256k1Signer) Sign(msg []byte) ([]byte, error) {
+	digest := sha256.Sum256(msg)
+	return k.sign(digest[:]), nil
+}
+
+// LocalSigner signs credentials as one issuer DID.
+type LocalSigner struct {
+	did string
+
+	mu  sync.RWMutex
+	key keySigner
+	vm  string
+}
+
+var localSigner *LocalSigner
+
+// current returns the active key and its verification method.
+func (s *LocalSigner) current() (keySigner, string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.key, s.vm
+}
+
+// signingAlg is the JOSE algorithm of the key.
+func (s *LocalSigner) signingAlg() string {
+	key, _ := s.current()
+	return keyAlg(key.Public())
+}
+
+// keyAlg is the JOSE algorithm of a signing public key.
+func keyAlg(pub crypto.PublicKey) string {
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		return "EdDSA"
+	case *ecdsa.PublicKey:
+		return "ES256"
+	}
+	return "ES256K"
+}
+
+// didKeyFor encodes a public key as a did:key.
+func didKeyFor(pub crypto.PublicKey) (string, error) {
+	switch key := pub.(type) {
+	case ed25519.PublicKey:
+		return didKeyEd25519(key), nil
+	case *secp256k1PublicKey:
+		return "did:key:z" + base58Encode(append(append([]byte{}, multicodecSecp256k1...), key.compressed()...)), nil
+	case *ecdsa.PublicKey:
+		return "did:key:z" + base58Encode(append(append([]byte{}, multicodecP256...), elliptic.MarshalCompressed(key.Curve, key.X, key.Y)...)), nil
+	}
+	return "", fmt.Errorf("unsupported key type %T", pub)
+}
+
+// parsePrivateJWK decodes an Ed25519 (OKP) or secp256k1 (EC) private JWK.
+func parsePrivateJWK(data []byte) (keySigner, error) {
+	var jwk struct {
+		Kty string `json:"kty"`
+		Crv string `json:"crv"`
+		D   string `json:"d"`
+	}
+	if err := json.Unmarshal(data, &jwk); err != nil {
+		return nil, fmt.Errorf("parsing JWK: %w", err)
+	}
+	d, err := base64.RawURLEncoding.DecodeString(jwk.D)
+	if err != nil || len(d) != 32 {
+		return nil, fmt.Errorf("JWK has no valid private key")
+	}
+	switch {
+	case jwk.Kty == "OKP" && jwk.Crv == "Ed25519":
+		return ed25519Signer(ed25519.NewKeyFromSeed(d)), nil
+	case jwk.Kty == "EC" && jwk.Crv == "secp256k1":
+		k, err := newSecp256k1PrivateKey(d)
+		if err != nil {
+			return nil, err
+		}
+		return secp256k1Signer{k}, nil
+	}
+	return nil, fmt.Errorf("unsupported JWK %s %s, want OKP Ed25519 or EC secp256k1", jwk.Kty, jwk.Crv)
+}
+
+// privateJWK encodes a generated key for DATA_DIR/signing-key.jwk.
+func privateJWK(key keySigner) ([]byte, error) {
+	jwk, err := publicJWK(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519Signer:
+		jwk["d"] = base64.RawURLEncoding.EncodeToString(ed25519.PrivateKey(k).Seed())
+	case secp256k1Signer:
+		jwk["d"] = base64.RawURLEncoding.EncodeToString(k.bytes())
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return json.Marshal(jwk)
+}
+
+// generateSigningKey makes a new Ed25519 or secp256k1 key and saves it as
+// DATA_DIR/signing-key.jwk.
+func generateSigningKey(dataDir string, ed bool) (keySigner, error) {
+	var key keySigner
+	if ed {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key = ed25519Signer(priv)
+	} else {
+		k, err := generateSecp256k1Key()
+		if err != nil {
+			return nil, err
+		}
+		key = secp256k1Signer{k}
+	}
+	data, err := privateJWK(key)
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dataDir, "signing-key.jwk")
+	if err := os.WriteFile(path+".tmp", data, 0o600); err != nil {
+		return nil, fmt.Errorf("writing signing key: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return nil, fmt.Errorf("writing signing key: %w", err)
+	}
+	slog.Info("local signing: key generated", "path", path)
+	return key, nil
+}
+
+// loadSigningKey connects to SIGNING_KEY_URI, or reads SIGNING_KEY or
+// DATA_DIR/signing-key.jwk, generating the latter when missing.
+func loadSigningKey(dataDir string) (keySigner, error) {
+	if uri := config.SigningKeyURI; uri != "" {
+		return newKMSSigner(uri)
+	}
+	if k := config.SigningKey; k != "" {
+		return parsePrivateJWK([]byte(k))
+	}
+	path := filepath.Join(dataDir, "signing-key.jwk")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return parsePrivateJWK(data)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading signing key: %w", err)
+	}
+	return generateSigningKey(dataDir, strings.HasPrefix(config.ProofType, "Ed25519"))
+}
+
+// enableLocalSigning loads the signing key and makes its DID the issuer.
+func enableLocalSigning() error {
+	key, err := loadSigningKey(config.DataDir)
+	if err != nil {
+		return err
+	}
+	if signingKeys, err = NewSigningKeyRing(config.DataDir); err != nil {
+		return err
+	}
+	rec, err := signingKeys.Use(key.Public())
+	if err != nil {
+		return err
+	}
+	s := &LocalSigner{key: key}
+	didKey, err := didKeyFor(key.Public())
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(config.IssuerDID, "did:web:") {
+		s.did, s.vm = config.IssuerDID, config.IssuerDID+"#"+rec.ID
+	} else {
+		if config.IssuerDID != didKey {
+			slog.Warn("local signing: ISSUER_DID needs the agent; issuing as a did:key", "issuer", config.IssuerDID, "did", didKey)
+		}
+		s.did, s.vm = didKey, didKey+"#"+strings.TrimPrefix(didKey, "did:key:")
+	}
+
+	// Sign with a suite that suits the key when the configured one doesn't.
+	if !s.supports(proofTypeFor(s.did)) {
+		switch s.signingAlg() {
+		case "EdDSA":
+			config.ProofTypes[s.did] = "Ed25519Signature2020"
+		case "ES256K":
+			config.ProofTypes[s.did] = "EcdsaSecp256k1Signature2019"
+		default:
+			config.ProofTypes[s.did] = "JsonWebSignature2020"
+		}
+	}
+	config.IssuerDID = s.did
+	localSigner = s
+	slog.Info("local signing", "verification_method", s.vm, "proof_type", proofTypeFor(s.did))
+	return nil
+}
+
+// rotate replaces a generated signing key with a new key of the same type
+// and returns its verification method. A did:key issuer's DID is its key,
+// so only did:web issuers rotate in place.
+func (s *LocalSigner) rotate() (string, error) {
+	if config.SigningKey != "" || config.SigningKeyURI != "" {
+		return "", fmt.Errorf("the key is configured by SIGNING_KEY or SIGNING_KEY_URI; change it there and restart")
+	}
+	if !strings.HasPrefix(s.did, "did:web:") {
+		return "", fmt.Errorf("%s is derived from its key and cannot rotate; use a did:web ISSUER_DID", didMethod(s.did))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, err := generateSigningKey(config.DataDir, keyAlg(s.key.Public()) == "EdDSA")
+	if err != nil {
+		return "", err
+	}
+	rec, err := signingKeys.Use(key.Public())
+	if err != nil {
+		return "", err
+	}
+	s.key, s.vm = key, s.did+"#"+rec.ID
+	return s.vm, nil
+}
+
+// supports reports whether the key can sign a proof type.
+func (s *LocalSigner) supports(proofType string) bool {
+	switch proofType {
+	case "JsonWebSignature2020":
+		return true
+	case "Ed25519Signature2020", "Ed25519Signature2018":
+		return s.signingAlg() == "EdDSA"
+	case "EcdsaSecp256k1Signature2019":
+		return s.signingAlg() == "ES256K"
+	}
+	return false
+}
+
+func (s *LocalSigner) checkIssuer(issuer interface{}) error {
+	id, _ := issuer.(string)
+	if m, ok := issuer.(map[string]interface{}); ok {
+		id, _ = m["id"].(string)
+	}
+	if id != s.did {
+		return fmt.Errorf("the local signing key belongs to %s, not %s", s.did, id)
+	}
+	return nil
+}
+
+// SignCredential adds a Linked Data proof to the credential of an agent
+// sign request.
+func (s *LocalSigner) SignCredential(payload map[string]interface{}) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	// Round-trip so canonicalization sees decoded JSON values.
+	data, err := json.Marshal(payload["credential"])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if err := s.checkIssuer(doc["issuer"]); err != nil {
+		return nil, err
+	}
+
+	key, vm := s.current()
+	alg := keyAlg(key.Public())
+	proofType, _ := payload["proofType"].(string)
+	proof := map[string]interface{}{
+		"type":               proofType,
+		"created":            credentialTime(time.Now()),
+		"verificationMethod": vm,
+		"proofPurpose":       "assertionMethod",
+	}
+	jws := false
+	switch suite, _ := payload["cryptosuite"].(string); {
+	case proofType == "DataIntegrityProof" && suite == "eddsa-rdfc-2022" && alg == "EdDSA",
+		proofType == "DataIntegrityProof" && suite == "ecdsa-rdfc-2019" && alg != "EdDSA":
+		proof["cryptosuite"] = suite
+	case proofType == "Ed25519Signature2020" && s.supports(proofType):
+	case s.supports(proofType):
+		jws = true
+	default:
+		if suite != "" {
+			proofType += " " + suite
+		}
+		return nil, fmt.Errorf("the local %s key cannot sign %s proofs", alg, proofType)
+	}
+
+	verifyData, err := ldVerifyData(doc, proof)
+	if err != nil {
+		return nil, err
+	}
+	if jws {
+		// Detached JWS with an unencoded payload (RFC 7797).
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg + `","b64":false,"crit":["b64"]}`))
+		sig, err := key.Sign(append([]byte(header+"."), verifyData...))
+		if err != nil {
+			return nil, err
+		}
+		proof["jws"] = header + ".." + base64.RawURLEncoding.EncodeToString(sig)
+	} else {
+		sig, err := key.Sign(verifyData)
+		if err != nil {
+			return nil, err
+		}
+		proof["proofValue"] = "z" + base58Encode(sig)
+	}
+	doc["proof"] = proof
+	return json.Marshal(doc)
+}
+
+// SignCredentialJWT signs the credential of an agent sign request as a
+// vc-jwt.
+func (s *LocalSigner) SignCredentialJWT(payload map[string]interface{}) (string, error) {
+	credential, _ := payload["credential"].(map[string]interface{})
+	if err := s.checkIssuer(credential["issuer"]); err != nil {
+		return "", err
+	}
+	return s.signJWT("JWT", vcJWTClaims(s.did, credential))
+}
+
+// SignSDJWT signs SD-JWT VC issuer claims.
+func (s *LocalSigner) SignSDJWT(claims map[string]interface{}) (string, error) {
+	if err := s.checkIssuer(claims["iss"]); err != nil {
+		return "", err
+	}
+	return s.signJWT("vc+sd-jwt", claims)
+}
+
+// signJWT signs with the key's own algorithm, whatever the proof type
+// suggested.
+func (s *LocalSigner) signJWT(typ string, claims map[string]interface{}) (string, error) {
+	key, vm := s.current()
+	header, err := json.Marshal(map[string]string{"alg": keyAlg(key.Public()), "typ": typ, "kid": vm})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	sig, err := key.Sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
@@ -0,0 +1,375 @@
+package service
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/piprate/json-gold/ld"
+)
+
+// Local verification. The agent is the primary verifier; when it cannot be
+// reached, credentials are verified in-process instead, so that scanning a
+// certificate still gives an answer during an agent outage.
+//
+// Supported locally: JWT and SD-JWT credentials signed with EdDSA, ES256 or
+// ES256K, and Linked Data proofs of type Ed25519Signature2020,
+// Ed25519Signature2018, EcdsaSecp256k1Signature2019, JsonWebSignature2020
+// and DataIntegrityProof with the eddsa-rdfc-2022 and ecdsa-rdfc-2019
+// cryptosuites. Linked Data proofs are checked over the URDNA2015
+// canonical form of the credential (json-gold), expanded with pinned
+// contexts only.
+// Issuer keys come from the DID resolver, whose cache keeps did:web and
+// did:polygon documents available while the agent is down. BBS+ proofs,
+// and keys a DID document only gives as a blockchain account, still need
+// the agent.
+
+// errNotLocallyVerifiable marks credentials local verification cannot
+// judge either way.
+var errNotLocallyVerifiable = errors.New("cannot be verified without the agent")
+
+func notLocallyVerifiable(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errNotLocallyVerifiable, fmt.Sprintf(format, args...))
+}
+
+// verifyLocally checks a credential's signature without the agent. A nil
+// error means the signature is valid; errors wrapping
+// errNotLocallyVerifiable mean it could not be checked.
+func verifyLocally(sc *scannedCredential) error {
+	issuer, _ := credentialValidity(sc)
+	var compact string
+	if json.Unmarshal(sc.Credential, &compact) == nil {
+		jwt, _, _ := strings.Cut(compact, "~")
+		return verifyJWTLocally(jwt, issuer)
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(sc.Credential, &doc); err != nil {
+		return notLocallyVerifiable("unrecognized credential")
+	}
+	return verifyLDProofs(doc, issuer)
+}
+
+func verifyJWTLocally(compact, issuer string) error {
+	header, _, err := decodeJWT(compact)
+	if err != nil {
+		return err
+	}
+	vm, _ := header["kid"].(string)
+	switch {
+	case vm == "":
+		vm = issuer
+	case strings.HasPrefix(vm, "#"):
+		vm = issuer + vm
+	}
+	if !strings.HasPrefix(vm, "did:") {
+		return notLocallyVerifiable("the JWT names no issuer DID")
+	}
+	pub, err := resolveVerificationMethod(vm, issuer)
+	if err != nil {
+		return err
+	}
+	return verifyJWTSignature(compact, pub)
+}
+
+// verifyLDProofs checks every proof on a JSON-LD credential.
+func verifyLDProofs(doc map[string]interface{}, issuer string) error {
+	var proofs []map[string]interface{}
+	for _, p := range ld.Arrayify(doc["proof"]) {
+		if m, ok := p.(map[string]interface{}); ok {
+			proofs = append(proofs, m)
+		}
+	}
+	if len(proofs) == 0 {
+		return fmt.Errorf("the credential has no proof")
+	}
+	unsigned := make(map[string]interface{}, len(doc))
+	for k, v := range doc {
+		if k != "proof" {
+			unsigned[k] = v
+		}
+	}
+	for _, proof := range proofs {
+		if err := verifyLDProof(unsigned, proof, issuer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func verifyLDProof(unsigned, proof map[string]interface{}, issuer string) error {
+	proofType, _ := proof["type"].(string)
+	suite, _ := proof["cryptosuite"].(string)
+	if proofType == "DataIntegrityProof" {
+		proofType += " " + suite
+	}
+	switch proofType {
+	case "Ed25519Signature2020", "Ed25519Signature2018", "EcdsaSecp256k1Signature2019",
+		"JsonWebSignature2020", "DataIntegrityProof eddsa-rdfc-2022", "DataIntegrityProof ecdsa-rdfc-2019":
+	default:
+		return notLocallyVerifiable("%s proofs are not supported", strings.TrimSpace(proofType))
+	}
+	if purpose, _ := proof["proofPurpose"].(string); purpose != "assertionMethod" {
+		return fmt.Errorf("unexpected proof purpose %q", purpose)
+	}
+	vm, _ := proof["verificationMethod"].(string)
+	pub, err := resolveVerificationMethod(vm, issuer)
+	if err != nil {
+		return err
+	}
+
+	verifyData, err := ldVerifyData(unsigned, proof)
+	if err != nil {
+		return err
+	}
+
+	if jws, ok := proof["jws"].(string); ok {
+		header, sig, ok := strings.Cut(jws, "..")
+		if !ok {
+			return fmt.Errorf("malformed detached JWS")
+		}
+		var h struct {
+			B64 *bool `json:"b64"`
+		}
+		if err := decodeJWTSegment(header, &h); err != nil {
+			return fmt.Errorf("decoding JWS header: %w", err)
+		}
+		if h.B64 == nil || *h.B64 {
+			return notLocallyVerifiable("only unencoded-payload JWS proofs are supported")
+		}
+		if err := verifyJWTSignature(header+"."+string(verifyData)+"."+sig, pub); err != nil {
+			return fmt.Errorf("proof signature: %w", err)
+		}
+		return nil
+	}
+
+	value, _ := proof["proofValue"].(string)
+	mb, ok := strings.CutPrefix(value, "z")
+	if !ok {
+		return fmt.Errorf("proofValue is not base58btc multibase")
+	}
+	sig, err := base58Decode(mb)
+	if err != nil {
+		return fmt.Errorf("decoding proofValue: %w", err)
+	}
+	if err := verifyDetached(pub, verifyData, sig); err != nil {
+		return fmt.Errorf("proof signature: %w", err)
+	}
+	return nil
+}
+
+// verifyDetached checks a raw signature over data: Ed25519 directly, ECDSA
+// over its SHA-256 digest with r‖s encoding.
+func verifyDetached(pub crypto.PublicKey, data, sig []byte) error {
+	if key, ok := pub.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(key, data, sig) {
+			return fmt.Errorf("invalid Ed25519 signature")
+		}
+		return nil
+	}
+	if len(sig) != 64 {
+		return fmt.Errorf("invalid ECDSA signature length")
+	}
+	digest := sha256.Sum256(data)
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	var ok bool
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.Verify(key, digest[:], r, s)
+	case *secp256k1PublicKey:
+		ok = verifySecp256k1(key, digest[:], r, s)
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	if !ok {
+		return fmt.Errorf("invalid ECDSA signature")
+	}
+	return nil
+}
+
+// ldVerifyData is what a Linked Data proof signs: the hash of the
+// canonical proof options followed by the hash of the canonical credential.
+func ldVerifyData(unsigned, proof map[string]interface{}) ([]byte, error) {
+	options := make(map[string]interface{}, len(proof))
+	for k, v := range proof {
+		switch k {
+		case "proofValue", "jws", "signatureValue":
+		default:
+			options[k] = v
+		}
+	}
+	options["@context"] = unsigned["@context"]
+	optionsHash, err := canonicalHash(options)
+	if err != nil {
+		return nil, err
+	}
+	docHash, err := canonicalHash(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	return append(optionsHash, docHash...), nil
+}
+
+// canonicalHash is the SHA-256 of a JSON-LD document's URDNA2015 canonical
+// N-Quads, computed with json-gold. The document is expanded in safe mode
+// first, which rejects terms the contexts do not define rather than
+// dropping them from what is signed. json-gold's processor drops SafeMode
+// when it copies its options, so expansion goes through JsonLdApi.
+func canonicalHash(doc map[string]interface{}) ([]byte, error) {
+	opts := ld.NewJsonLdOptions("")
+	opts.DocumentLoader = pinnedContextLoader{}
+	opts.SafeMode = true
+	expanded, err := ld.NewJsonLdApi().Expand(ld.NewContext(nil, opts), "", doc, opts, false, nil)
+	if err != nil {
+		return nil, notLocallyVerifiable("expanding JSON-LD: %v", err)
+	}
+	opts.Algorithm = ld.AlgorithmURDNA2015
+	opts.Format = "application/n-quads"
+	normalized, err := ld.NewJsonLdProcessor().Normalize(expanded, opts)
+	if err != nil {
+		return nil, notLocallyVerifiable("canonicalizing JSON-LD: %v", err)
+	}
+	nquads, ok := normalized.(string)
+	if !ok {
+		return nil, notLocallyVerifiable("canonicalizing JSON-LD: unexpected %T", normalized)
+	}
+	sum := sha256.Sum256([]byte(nquads))
+	return sum[:], nil
+}
+
+// pinnedContextLoader reads remote contexts from the pinned context cache
+// only, so expanding a credential never fetches an arbitrary URL.
+type pinnedContextLoader struct{}
+
+func (pinnedContextLoader) LoadDocument(u string) (*ld.RemoteDocument, error) {
+	data, err := contexts.Load(u)
+	if err != nil {
+		return nil, ld.NewJsonLdError(ld.LoadingDocumentFailed, err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, ld.NewJsonLdError(ld.LoadingDocumentFailed, err)
+	}
+	return &ld.RemoteDocument{DocumentURL: u, Document: doc}, nil
+}
+
+// resolveVerificationMethod returns the public key of a verification
+// method, which must belong to the issuer's DID.
+func resolveVerificationMethod(vm, issuer string) (crypto.PublicKey, error) {
+	did, _, _ := strings.Cut(vm, "#")
+	if issuer != "" && did != issuer {
+		return nil, fmt.Errorf("verification method %s does not belong to the issuer %s", vm, issuer)
+	}
+	if localSigner != nil && did == localSigner.did && strings.HasPrefix(did, "did:web:") {
+		// Our own document lists every key we have signed with.
+		_, fragment, _ := strings.Cut(vm, "#")
+		if pub, ok := signingKeys.Lookup(fragment); ok {
+			return pub, nil
+		}
+		return nil, fmt.Errorf("%s is not one of the issuer's keys", vm)
+	}
+	return didResolver.Key(vm, "assertionMethod")
+}
+
+// verificationMethodKey decodes the public key of a DID document method.
+func verificationMethodKey(m didVerificationMethod) (crypto.PublicKey, error) {
+	switch {
+	case m.PublicKeyMultibase != "":
+		mb, ok := strings.CutPrefix(m.PublicKeyMultibase, "z")
+		if !ok {
+			return nil, fmt.Errorf("publicKeyMultibase is not base58btc")
+		}
+		raw, err := base58Decode(mb)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) == ed25519.PublicKeySize && strings.HasPrefix(m.Type, "Ed25519") {
+			return ed25519.PublicKey(raw), nil
+		}
+		return multicodecPublicKey(raw)
+	case m.PublicKeyBase58 != "":
+		raw, err := base58Decode(m.PublicKeyBase58)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(m.Type, "Ed25519") && len(raw) == ed25519.PublicKeySize {
+			return ed25519.PublicKey(raw), nil
+		}
+		if strings.HasPrefix(m.Type, "EcdsaSecp256k1") {
+			return parseSecp256k1(raw)
+		}
+	case m.PublicKeyJWK != nil:
+		return jwkPublicKey(m.PublicKeyJWK)
+	}
+	return nil, fmt.Errorf("unsupported verification method type %s", m.Type)
+}
+
+// jwkPublicKey decodes an OKP Ed25519, EC P-256/secp256k1 or RSA public
+// JWK.
+func jwkPublicKey(jwk map[string]interface{}) (crypto.PublicKey, error) {
+	kty, _ := jwk["kty"].(string)
+	crv, _ := jwk["crv"].(string)
+	coord := func(name string) ([]byte, error) {
+		s, _ := jwk[name].(string)
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid JWK %s", name)
+		}
+		return b, nil
+	}
+	switch {
+	case kty == "OKP" && crv == "Ed25519":
+		x, err := coord("x")
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 JWK")
+		}
+		return ed25519.PublicKey(x), nil
+	case kty == "EC" && (crv == "P-256" || crv == "secp256k1"):
+		x, err := coord("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := coord("y")
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid %s JWK", crv)
+		}
+		point := append(append([]byte{4}, x...), y...)
+		if crv == "secp256k1" {
+			return parseSecp256k1(point)
+		}
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return nil, fmt.Errorf("invalid P-256 JWK")
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case kty == "RSA":
+		n, err := coord("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := coord("e")
+		if err != nil {
+			return nil, err
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < 2048 || key.E < 3 {
+			return nil, fmt.Errorf("invalid RSA JWK")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported JWK %s %s", kty, crv)
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func mustDoc(t *testing.T, src string) map[string]interface{} {
+	t.Helper()
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(src), &doc); err != nil {
+		t.Fatalf("test document: %v", err)
+	}
+	return doc
+}
+
+// TestCanonicalHashKnownAnswers checks canonicalHash against N-Quads
+// derived by hand from the JSON-LD to RDF and URDNA2015 rules, for
+// documents without blank nodes.
+func TestCanonicalHashKnownAnswers(t *testing.T) {
+	tests := []struct {
+		name   string
+		doc    string
+		nquads string
+	}{
+		{
+			name:   "plain literal",
+			doc:    `{"@context": {"name": "http://schema.org/name"}, "@id": "http://example.com/a", "name": "Alice"}`,
+			nquads: "<http://example.com/a> <http://schema.org/name> \"Alice\" .\n",
+		},
+		{
+			name: "typed literals",
+			doc: `{"@context": {"@vocab": "http://example.com/v#"}, "@id": "http://example.com/a",
+				"age": 42, "active": true, "gpa": {"@value": "3.85", "@type": "http://www.w3.org/2001/XMLSchema#decimal"}}`,
+			nquads: "<http://example.com/a> <http://example.com/v#active> \"true\"^^<http://www.w3.org/2001/XMLSchema#boolean> .\n" +
+				"<http://example.com/a> <http://example.com/v#age> \"42\"^^<http://www.w3.org/2001/XMLSchema#integer> .\n" +
+				"<http://example.com/a> <http://example.com/v#gpa> \"3.85\"^^<http://www.w3.org/2001/XMLSchema#decimal> .\n",
+		},
+		{
+			name: "type and language",
+			doc: `{"@context": {"@vocab": "http://example.com/v#", "@language": "sw"}, "@id": "http://example.com/a",
+				"@type": "Degree", "title": "Shahada"}`,
+			nquads: "<http://example.com/a> <http://example.com/v#title> \"Shahada\"@sw .\n" +
+				"<http://example.com/a> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://example.com/v#Degree> .\n",
+		},
+		{
+			name:   "escaped literal",
+			doc:    `{"@context": {"note": "http://example.com/note"}, "@id": "http://example.com/a", "note": "line\n\"quoted\""}`,
+			nquads: "<http://example.com/a> <http://example.com/note> \"line\\n\\\"quoted\\\"\" .\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := canonicalHash(mustDoc(t, tt.doc))
+			if err != nil {
+				t.Fatalf("canonicalHash: %v", err)
+			}
+			want := sha256.Sum256([]byte(tt.nquads))
+			if !bytes.Equal(got, want[:]) {
+				t.Errorf("hash %x, want %x (of %q)", got, want, tt.nquads)
+			}
+		})
+	}
+}
+
+// TestCanonicalHashBlankNodes checks that blank node labels and the order
+// of unordered values do not change the hash, and that content does.
+func TestCanonicalHashBlankNodes(t *testing.T) {
+	const ctx = `"@context": {"@vocab": "http://example.com/v#"}`
+	tests := []struct {
+		name  string
+		a, b  string
+		equal bool
+	}{
+		{
+			name:  "set order",
+			a:     `{` + ctx + `, "knows": [{"name": "B"}, {"name": "C"}]}`,
+			b:     `{` + ctx + `, "knows": [{"name": "C"}, {"name": "B"}]}`,
+			equal: true,
+		},
+		{
+			name:  "explicit labels",
+			a:     `{` + ctx + `, "@id": "_:x", "knows": {"@id": "_:y", "name": "B"}}`,
+			b:     `{` + ctx + `, "@id": "_:b7", "knows": {"@id": "_:a1", "name": "B"}}`,
+			equal: true,
+		},
+		{
+			name:  "changed value",
+			a:     `{` + ctx + `, "knows": {"name": "B"}}`,
+			b:     `{` + ctx + `, "knows": {"name": "b"}}`,
+			equal: false,
+		},
+		{
+			name:  "list order is significant",
+			a:     `{` + ctx + `, "steps": {"@list": ["one", "two"]}}`,
+			b:     `{` + ctx + `, "steps": {"@list": ["two", "one"]}}`,
+			equal: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := canonicalHash(mustDoc(t, tt.a))
+			if err != nil {
+				t.Fatalf("canonicalHash(a): %v", err)
+			}
+			b, err := canonicalHash(mustDoc(t, tt.b))
+			if err != nil {
+				t.Fatalf("canonicalHash(b): %v", err)
+			}
+			if bytes.Equal(a, b) != tt.equal {
+				t.Errorf("hashes equal = %t, want %t", bytes.Equal(a, b), tt.equal)
+			}
+		})
+	}
+}
+
+// TestCanonicalHashRejects checks that documents whose signed content is
+// not fully determined cannot be verified locally.
+func TestCanonicalHashRejects(t *testing.T) {
+	cache, err := NewContextCache(t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := contexts
+	contexts = cache
+	t.Cleanup(func() { contexts = saved })
+
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{"undefined term", `{"@context": {"name": "http://schema.org/name"}, "name": "Alice", "gpa": "4.0"}`},
+		{"unpinned context", `{"@context": "https://example.com/unpinned.jsonld", "name": "Alice"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := canonicalHash(mustDoc(t, tt.doc))
+			if !errors.Is(err, errNotLocallyVerifiable) {
+				t.Errorf("err = %v, want errNotLocallyVerifiable", err)
+			}
+		})
+	}
+}
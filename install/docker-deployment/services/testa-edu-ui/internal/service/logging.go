@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Logging. Log lines are structured, written with log/slog: key=value text
+// by default, or one JSON object a line with LOG_FORMAT=json, for a log
+// pipeline. LOG_LEVEL is the least severe level written: debug, info (the
+// default), warn or error; debug adds every agent call with its latency.
+// A reload applies a changed LOG_LEVEL. Lines still written with the log
+// package are logged at info level.
+//
+// A request's log fields ride in its context, so every line it logs, its
+// agent calls' included, carries the same fields: logRequests adds the
+// session and the wizard step, handlers the credential once it is known.
+// The session field is a hash of the session cookie: the cookie itself
+// would let whoever reads the logs take the session over.
+
+// logLevel is the level written, set from LOG_LEVEL.
+var logLevel = new(slog.LevelVar)
+
+// initLogging sends the log, the log package's included, to w in format.
+func initLogging(format string, w io.Writer) {
+	slog.SetDefault(slog.New(newLogHandler(format, w)))
+}
+
+// fatal logs why the service cannot run, and exits.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func newLogHandler(format string, w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	if format == "json" {
+		return contextHandler{slog.NewJSONHandler(w, opts)}
+	}
+	return contextHandler{slog.NewTextHandler(w, opts)}
+}
+
+// logFieldsKey is the context key of a request's log fields.
+type logFieldsKey struct{}
+
+// withLogFields returns ctx with attrs added to the lines logged with it.
+func withLogFields(ctx context.Context, attrs ...slog.Attr) context.Context {
+	fields, _ := ctx.Value(logFieldsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, logFieldsKey{}, append(slices.Clip(fields), attrs...))
+}
+
+// contextHandler adds the log fields of the context to each line.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if ctx != nil {
+		if fields, ok := ctx.Value(logFieldsKey{}).([]slog.Attr); ok {
+			r.AddAttrs(fields...)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// logRequests adds a request's session and wizard step to its log fields.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var fields []slog.Attr
+		if cookie, err := r.Cookie("sid"); err == nil {
+			fields = append(fields, slog.String("session", logSessionID(cookie.Value)))
+		}
+		if step, ok := strings.CutPrefix(r.URL.Path, "/step/"); ok {
+			fields = append(fields, slog.String("step", step))
+		}
+		if len(fields) > 0 {
+			r = r.WithContext(withLogFields(r.Context(), fields...))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logSessionID identifies a session in the log without giving it away.
+func logSessionID(sid string) string {
+	sum := sha256.Sum256([]byte(sid))
+	return hex.EncodeToString(sum[:6])
+}
+
+// sessionLogContext is the request's context with the session's credential
+// added to its log fields, once the session has one.
+func sessionLogContext(r *http.Request, sess *Session) context.Context {
+	sessionsMu.RLock()
+	id := sess.CredentialID
+	sessionsMu.RUnlock()
+	if id == "" {
+		return r.Context()
+	}
+	return withLogFields(r.Context(), slog.String("credential", id))
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogRequests(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler("json", &redactingWriter{out: &buf, redactor: newLogRedactor([]string{"studentName"}, nil)}))
+	handler := logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := withLogFields(r.Context(), slog.String("credential", "urn:uuid:1"))
+		logger.ErrorContext(ctx, "sign error", "response", `{"studentName":"Jane Doe"}`)
+	}))
+	req := httptest.NewRequest("POST", "/step/sign", nil)
+	req.AddCookie(&http.Cookie{Name: "sid", Value: "0123456789abcdef"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	want := map[string]interface{}{
+		"level":      "ERROR",
+		"msg":        "sign error",
+		"session":    logSessionID("0123456789abcdef"),
+		"step":       "sign",
+		"credential": "urn:uuid:1",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+	if strings.Contains(buf.String(), "0123456789abcdef") || strings.Contains(buf.String(), "Jane Doe") {
+		t.Errorf("log line gives away the session or a redacted field: %s", buf.Bytes())
+	}
+}
+
+// TestLogRedactText checks the text handler's quoted values are redacted
+// whole, as are bare ones and fields in a quoted JSON value.
+func TestLogRedactText(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler("text", &redactingWriter{out: &buf, redactor: newLogRedactor([]string{"studentName", "studentId", "gpa"}, nil)}))
+	logger.Info("issued", "studentName", "Jane Wanjiku", "studentId", "ADM-2021-0042", "gpa", "3.8", "response", `{"studentName":"Jane Wanjiku"}`, "step", "sign")
+
+	line := buf.String()
+	for _, leak := range []string{"Jane", "Wanjiku", "ADM-2021-0042", "3.8"} {
+		if strings.Contains(line, leak) {
+			t.Errorf("log line gives away %q: %s", leak, line)
+		}
+	}
+	for _, want := range []string{`studentName="[REDACTED]"`, "studentId=[REDACTED]", "step=sign"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("log line lacks %s: %s", want, line)
+		}
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	saved := logLevel.Level()
+	t.Cleanup(func() { logLevel.Set(saved) })
+
+	var buf bytes.Buffer
+	logger := slog.New(newLogHandler("text", &buf))
+	logLevel.Set(slog.LevelWarn)
+	logger.Info("agent call")
+	logger.Warn("agent is down", "agent", "http://agent")
+	if got := buf.String(); strings.Contains(got, "agent call") || !strings.Contains(got, `level=WARN msg="agent is down" agent=http://agent`) {
+		t.Errorf("log %q", got)
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Log sanitization. Agent responses and errors are logged as returned, and
+// they carry student names, IDs and grades; the agent API key can also
+// appear in echoed requests. Every log line passes through a redactor that
+// masks the values of the LOG_REDACT_FIELDS keys (in JSON, escaped JSON,
+// form or query syntax and the text handler's key="value"), bearer and
+// DID-auth credentials, claim tokens, and the configured secrets.
+
+const redacted = "[REDACTED]"
+
+const defaultLogRedactFields = "name,studentName,studentId,givenBy,gpa,honors,email,studentEmail,phone,studentPhone"
+
+type logRedactor struct {
+	fields  *regexp.Regexp
+	params  *regexp.Regexp
+	auth    *regexp.Regexp
+	secrets []string
+}
+
+func newLogRedactor(fields, secrets []string) *logRedactor {
+	quoted := make([]string, len(fields))
+	for i, f := range fields {
+		quoted[i] = regexp.QuoteMeta(f)
+	}
+	names := strings.Join(append(quoted, "token", "sig"), "|")
+
+	r := &logRedactor{
+		auth: regexp.MustCompile(`(?i)\b(Bearer|DIDAuth)\s+[A-Za-z0-9._~+/=-]+`),
+	}
+	if len(fields) > 0 {
+		// "field": "value" or "field": 3.5, also with escaped quotes.
+		r.fields = regexp.MustCompile(`(\\?"(?:` + strings.Join(quoted, "|") + `)\\?"\s*:\s*)("[^"\\]*(?:\\.[^"\\]*)*"|\\"[^"\\]*\\"|-?[0-9.]+)`)
+	}
+	// field=value in a query, or field="value" as the text handler quotes
+	// values with spaces.
+	r.params = regexp.MustCompile(`\b((?:` + names + `)=)("(?:[^"\\]|\\.)*"|[^&\s"']+)`)
+	for _, s := range secrets {
+		// Short values would mask unrelated text.
+		if len(s) >= 8 {
+			r.secrets = append(r.secrets, s)
+		}
+	}
+	return r
+}
+
+func (r *logRedactor) Redact(s string) string {
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	s = r.auth.ReplaceAllString(s, "$1 "+redacted)
+	if r.fields != nil {
+		s = r.fields.ReplaceAllStringFunc(s, func(m string) string {
+			sub := r.fields.FindStringSubmatch(m)
+			if strings.HasPrefix(sub[2], `\"`) {
+				return sub[1] + `\"` + redacted + `\"`
+			}
+			return sub[1] + `"` + redacted + `"`
+		})
+	}
+	return r.params.ReplaceAllStringFunc(s, func(m string) string {
+		sub := r.params.FindStringSubmatch(m)
+		if strings.HasPrefix(sub[2], `"`) {
+			return sub[1] + `"` + redacted + `"`
+		}
+		return sub[1] + redacted
+	})
+}
+
+// redactingWriter is the output of the log handler (see logging.go); the
+// handlers make one Write per record, so each call holds a whole line.
+type redactingWriter struct {
+	out      io.Writer
+	redactor *logRedactor
+}
+
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.out, w.redactor.Redact(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
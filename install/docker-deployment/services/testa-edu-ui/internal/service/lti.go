@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LTI 1.3. An LMS connector (see lms.go) with an "lti" platform makes the
+// issuance wizard an LTI 1.3 tool of the LMS:
+//
+//	"lti": {"issuer": "https://lms.example.ac.ke", "clientId": "Yk3Zr0xXq1",
+//	        "authUrl": "https://lms.example.ac.ke/mod/lti/auth.php",
+//	        "jwksUrl": "https://lms.example.ac.ke/mod/lti/certs.php",
+//	        "deployments": ["1"]}
+//
+// The tool is registered in the LMS with the login URL <URL>/lti/<name>/login
+// and the redirect URL <URL>/lti/<name>/launch, <URL> being the tenant's
+// domain or PUBLIC_URL. A resource link launch opens the issuance form
+// filled in from the launch's claims: the course's fields as the connector
+// maps them (the course being the custom parameter course_id, or the
+// context ID), or else the course title as the degree; and the student,
+// who is the user a teacher launched for (the for_user claim) or the
+// learner launching.
+//
+// Launches follow the OIDC flow of LTI 1.3 Core: the login redirects to
+// the platform with a nonce and a signed state, and the platform posts
+// back an id_token, checked against its JWKS, carrying both. The wizard
+// keeps its session in a cookie, which browsers hold back from cross-site
+// frames, so the LMS should open the tool in a new window. The tool calls
+// no LTI services and needs no key of its own.
+
+const (
+	ltiClaim       = "https://purl.imsglobal.org/spec/lti/claim/"
+	ltiMembership  = "http://purl.imsglobal.org/vocab/lis/v2/membership#"
+	ltiStateTTL    = 10 * time.Minute
+	ltiClockLeeway = time.Minute
+	// ltiKeysTTL is how long a platform's JWKS is cached; a key ID not in
+	// it fetches it again, at most every ltiKeysRefetch.
+	ltiKeysTTL     = time.Hour
+	ltiKeysRefetch = time.Minute
+)
+
+// LTIPlatform is the LMS an LTI tool is registered with.
+type LTIPlatform struct {
+	Issuer      string   `json:"issuer"`
+	ClientID    string   `json:"clientId"`
+	AuthURL     string   `json:"authUrl"`
+	JWKSURL     string   `json:"jwksUrl"`
+	Deployments []string `json:"deployments,omitempty"` // any when empty
+}
+
+func (p *LTIPlatform) validate() error {
+	if p.Issuer == "" || p.ClientID == "" {
+		return errors.New("lti needs the platform's issuer and the tool's clientId")
+	}
+	if !validHTTPURL(p.AuthURL) || !validHTTPURL(p.JWKSURL) {
+		return errors.New("lti authUrl and jwksUrl must be http or https URLs")
+	}
+	return nil
+}
+
+type ltiKeySet struct {
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+var (
+	ltiMu sync.Mutex
+	// ltiNonces are the nonces launched with, until their state expires,
+	// so an id_token launches once.
+	ltiNonces = map[string]time.Time{}
+	// ltiKeys are the platforms' keys, by JWKS URL.
+	ltiKeys = map[string]*ltiKeySet{}
+)
+
+// ltiState signs a login's connector, nonce and expiry into its state, so
+// the launch needs nothing kept between the two.
+func ltiState(connector, nonce string, expires time.Time) string {
+	payload := connector + "|" + nonce + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(ltiStateMAC(payload))
+}
+
+func ltiStateMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte("lti-state|" + payload))
+	return mac.Sum(nil)
+}
+
+// checkLTIState returns the nonce and expiry of a connector's state.
+func checkLTIState(connector, state string) (string, time.Time, error) {
+	enc, sig, _ := strings.Cut(state, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", time.Time{}, errors.New("malformed state")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(got, ltiStateMAC(string(payload))) {
+		return "", time.Time{}, errors.New("state signature mismatch")
+	}
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 3 || parts[0] != connector {
+		return "", time.Time{}, errors.New("state is for another platform")
+	}
+	unix, _ := strconv.ParseInt(parts[2], 10, 64)
+	expires := time.Unix(unix, 0)
+	if time.Now().After(expires) {
+		return "", time.Time{}, errors.New("the login has expired")
+	}
+	return parts[1], expires, nil
+}
+
+// useLTINonce reports whether a nonce is launched with for the first time.
+func useLTINonce(nonce string, expires time.Time) bool {
+	ltiMu.Lock()
+	defer ltiMu.Unlock()
+	now := time.Now()
+	for n, exp := range ltiNonces {
+		if now.After(exp) {
+			delete(ltiNonces, n)
+		}
+	}
+	if _, used := ltiNonces[nonce]; used {
+		return false
+	}
+	ltiNonces[nonce] = expires
+	return true
+}
+
+// ltiConnector is the connector of a request's LTI platform, or nil.
+func ltiConnector(r *http.Request) *LMSConnector {
+	c := lmsConnectors[r.PathValue("connector")]
+	if c == nil || c.LTI == nil {
+		return nil
+	}
+	return c
+}
+
+func ltiLaunchURL(c *LMSConnector) string {
+	return tenantURL(c.Tenant) + "/lti/" + c.Name + "/launch"
+}
+
+// handleLTILogin answers a platform's third-party login initiation,
+// sending the browser to the platform's authorization endpoint.
+func handleLTILogin(w http.ResponseWriter, r *http.Request) {
+	c := ltiConnector(r)
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	r.ParseForm()
+	if r.FormValue("iss") != c.LTI.Issuer || r.FormValue("login_hint") == "" ||
+		(r.FormValue("client_id") != "" && r.FormValue("client_id") != c.LTI.ClientID) {
+		slog.WarnContext(r.Context(), "LTI: login refused", "connector", c.Name, "iss", r.FormValue("iss"))
+		http.Error(w, "Unknown platform or missing login_hint", http.StatusBadRequest)
+		return
+	}
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	q := url.Values{
+		"scope":         {"openid"},
+		"response_type": {"id_token"},
+		"response_mode": {"form_post"},
+		"prompt":        {"none"},
+		"client_id":     {c.LTI.ClientID},
+		"redirect_uri":  {ltiLaunchURL(c)},
+		"login_hint":    {r.FormValue("login_hint")},
+		"nonce":         {base64.RawURLEncoding.EncodeToString(nonce)},
+	}
+	q.Set("state", ltiState(c.Name, q.Get("nonce"), time.Now().Add(ltiStateTTL)))
+	if hint := r.FormValue("lti_message_hint"); hint != "" {
+		q.Set("lti_message_hint", hint)
+	}
+	sep := "?"
+	if strings.Contains(c.LTI.AuthURL, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, c.LTI.AuthURL+sep+q.Encode(), http.StatusFound)
+}
+
+// handleLTILaunch checks a platform's launch and answers it with the
+// issuance form, filled in from the launch.
+func handleLTILaunch(w http.ResponseWriter, r *http.Request) {
+	c := ltiConnector(r)
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if e := r.FormValue("error"); e != "" {
+		slog.WarnContext(r.Context(), "LTI: platform error", "connector", c.Name, "error", e, "description", r.FormValue("error_description"))
+		http.Error(w, "The LMS did not authorize the launch", http.StatusUnauthorized)
+		return
+	}
+	nonce, expires, err := checkLTIState(c.Name, r.FormValue("state"))
+	var claims map[string]interface{}
+	if err == nil {
+		claims, err = c.LTI.verify(r.Context(), r.FormValue("id_token"), nonce)
+	}
+	if err == nil && !useLTINonce(nonce, expires) {
+		err = errors.New("the launch was replayed")
+	}
+	if err != nil {
+		slog.WarnContext(r.Context(), "LTI: launch refused", "connector", c.Name, "err", err)
+		http.Error(w, "Invalid LTI launch", http.StatusUnauthorized)
+		return
+	}
+	slog.InfoContext(r.Context(), "LTI: launch", "connector", c.Name, "user", claims["sub"])
+	renderIndex(w, r, c.Tenant, c.ltiPrefill(claims))
+}
+
+// verify checks a launch's id_token and returns its claims.
+func (p *LTIPlatform) verify(ctx context.Context, idToken, nonce string) (map[string]interface{}, error) {
+	header, claims, err := decodeJWT(idToken)
+	if err != nil {
+		return nil, err
+	}
+	if header["alg"] != "RS256" {
+		return nil, fmt.Errorf("unsupported alg %v", header["alg"])
+	}
+	kid, _ := header["kid"].(string)
+	key, err := p.key(ctx, kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyJWTSignature(idToken, key); err != nil {
+		return nil, err
+	}
+
+	if claims["iss"] != p.Issuer {
+		return nil, fmt.Errorf("issuer %v is not the platform's", claims["iss"])
+	}
+	var aud []string
+	switch a := claims["aud"].(type) {
+	case string:
+		aud = []string{a}
+	case []interface{}:
+		for _, v := range a {
+			s, _ := v.(string)
+			aud = append(aud, s)
+		}
+	}
+	if !slices.Contains(aud, p.ClientID) || (len(aud) > 1 && claims["azp"] != p.ClientID) {
+		return nil, errors.New("the token is not for this tool")
+	}
+	now := time.Now()
+	exp, _ := claims["exp"].(float64)
+	iat, _ := claims["iat"].(float64)
+	if now.After(time.Unix(int64(exp), 0).Add(ltiClockLeeway)) || time.Unix(int64(iat), 0).After(now.Add(ltiClockLeeway)) {
+		return nil, errors.New("the token has expired")
+	}
+	if claims["nonce"] != nonce {
+		return nil, errors.New("nonce mismatch")
+	}
+	deployment, _ := claims[ltiClaim+"deployment_id"].(string)
+	if len(p.Deployments) > 0 && !slices.Contains(p.Deployments, deployment) {
+		return nil, fmt.Errorf("unknown deployment %q", deployment)
+	}
+	if claims[ltiClaim+"message_type"] != "LtiResourceLinkRequest" || claims[ltiClaim+"version"] != "1.3.0" {
+		return nil, fmt.Errorf("unsupported message %v, version %v", claims[ltiClaim+"message_type"], claims[ltiClaim+"version"])
+	}
+	return claims, nil
+}
+
+// key returns the platform's signing key kid, from its JWKS.
+func (p *LTIPlatform) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	ltiMu.Lock()
+	set := ltiKeys[p.JWKSURL]
+	ltiMu.Unlock()
+	if set != nil {
+		key, ok := set.keys[kid]
+		age := time.Since(set.fetched)
+		if ok && age < ltiKeysTTL {
+			return key, nil
+		}
+		if !ok && age < ltiKeysRefetch {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+	}
+	set, err := fetchLTIKeys(ctx, p.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	ltiMu.Lock()
+	ltiKeys[p.JWKSURL] = set
+	ltiMu.Unlock()
+	key, ok := set.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+func fetchLTIKeys(ctx context.Context, jwksURL string) (*ltiKeySet, error) {
+	ctx, cancel := context.WithTimeout(ctx, lmsTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching the platform's JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching the platform's JWKS: %s", resp.Status)
+	}
+	var jwks struct {
+		Keys []map[string]interface{} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("decoding the platform's JWKS: %w", err)
+	}
+	set := &ltiKeySet{keys: map[string]crypto.PublicKey{}, fetched: time.Now()}
+	for _, jwk := range jwks.Keys {
+		kid, _ := jwk["kid"].(string)
+		if key, err := jwkPublicKey(jwk); err == nil {
+			set.keys[kid] = key
+		}
+	}
+	return set, nil
+}
+
+// ltiPrefill fills in the issuance form from a launch's claims.
+func (c *LMSConnector) ltiPrefill(claims map[string]interface{}) map[string]string {
+	prefill := map[string]string{}
+	course, _ := claims[ltiClaim+"context"].(map[string]interface{})
+	custom, _ := claims[ltiClaim+"custom"].(map[string]interface{})
+	id, _ := custom["course_id"].(string)
+	if id == "" {
+		id, _ = course["id"].(string)
+	}
+	if mapping, ok := c.Courses[id]; ok {
+		for k, v := range mapping {
+			prefill[k] = v
+		}
+	} else if title, _ := course["title"].(string); title != "" {
+		prefill["degree"] = title
+	}
+
+	student, ok := claims[ltiClaim+"for_user"].(map[string]interface{})
+	if !ok {
+		if !ltiLearner(claims) {
+			return prefill
+		}
+		lis, _ := claims[ltiClaim+"lis"].(map[string]interface{})
+		student = map[string]interface{}{"name": claims["name"], "email": claims["email"], "person_sourcedid": lis["person_sourcedid"]}
+	}
+	prefill["studentName"], _ = student["name"].(string)
+	prefill["studentEmail"], _ = student["email"].(string)
+	prefill["studentId"], _ = student["person_sourcedid"].(string)
+	return prefill
+}
+
+// ltiLearner reports whether the launching user is a learner, not a
+// teacher, of the course.
+func ltiLearner(claims map[string]interface{}) bool {
+	roles, _ := claims[ltiClaim+"roles"].([]interface{})
+	learner := false
+	for _, r := range roles {
+		switch r {
+		case ltiMembership + "Instructor", ltiMembership + "Administrator":
+			return false
+		case ltiMembership + "Learner":
+			learner = true
+		}
+	}
+	return learner
+}
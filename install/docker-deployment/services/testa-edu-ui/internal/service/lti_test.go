@@ -0,0 +1,129 @@
+package service
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestLTILaunch checks an LTI 1.3 launch opens the issuance form filled
+// in from its claims, once, and that forged launches are refused.
+func TestLTILaunch(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k1", "alg": "RS256",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer platform.Close()
+
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	startLMSTest(t, fmt.Sprintf(`[{"name": "moodle", "kind": "moodle", "url": %[1]q, "institution": "Testa University",
+		"lti": {"issuer": %[1]q, "clientId": "tool-1", "authUrl": "%[1]s/auth", "jwksUrl": "%[1]s/certs", "deployments": ["1"]},
+		"courses": {"42": {"degree": "Certificate in Data Science", "fieldOfStudy": "Data Science"}}}]`, platform.URL))
+
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	login := func() url.Values {
+		t.Helper()
+		resp, err := noRedirect.PostForm(srv.URL+"/lti/moodle/login", url.Values{
+			"iss": {platform.URL}, "login_hint": {"7"}, "lti_message_hint": {"launch-1"}, "client_id": {"tool-1"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		to, err := url.Parse(resp.Header.Get("Location"))
+		if resp.StatusCode != http.StatusFound || err != nil || to.Path != "/auth" {
+			t.Fatalf("login: HTTP %d to %s", resp.StatusCode, resp.Header.Get("Location"))
+		}
+		return to.Query()
+	}
+	sign := func(claims map[string]interface{}) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(input))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return input + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	launch := func(auth url.Values, claims map[string]interface{}) (int, string) {
+		t.Helper()
+		resp, err := http.PostForm(srv.URL+"/lti/moodle/launch", url.Values{"state": {auth.Get("state")}, "id_token": {sign(claims)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+	claimsFor := func(auth url.Values) map[string]interface{} {
+		return map[string]interface{}{
+			"iss": platform.URL, "aud": "tool-1", "sub": "7", "nonce": auth.Get("nonce"),
+			"iat": time.Now().Unix(), "exp": time.Now().Add(time.Minute).Unix(),
+			"name": "Jane Wanjiku", "email": "jane@example.ac.ke",
+			ltiClaim + "message_type":  "LtiResourceLinkRequest",
+			ltiClaim + "version":       "1.3.0",
+			ltiClaim + "deployment_id": "1",
+			ltiClaim + "roles":         []string{ltiMembership + "Learner"},
+			ltiClaim + "context":       map[string]string{"id": "42", "title": "Data Science 101"},
+			ltiClaim + "lis":           map[string]string{"person_sourcedid": "TU-2021-0042"},
+		}
+	}
+
+	auth := login()
+	if auth.Get("redirect_uri") != config.PublicURL+"/lti/moodle/launch" || auth.Get("lti_message_hint") != "launch-1" || auth.Get("client_id") != "tool-1" {
+		t.Errorf("authorization request: %v", auth)
+	}
+	status, page := launch(auth, claimsFor(auth))
+	if status != http.StatusOK {
+		t.Fatalf("launch: HTTP %d %s", status, page)
+	}
+	for _, want := range []string{`value="Jane Wanjiku"`, `value="Certificate in Data Science"`, `value="Data Science"`, `value="TU-2021-0042"`, `value="jane@example.ac.ke"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("launch page has no %s", want)
+		}
+	}
+	if status, _ := launch(auth, claimsFor(auth)); status != http.StatusUnauthorized {
+		t.Errorf("replayed launch: HTTP %d", status)
+	}
+
+	auth = login()
+	forged := claimsFor(auth)
+	forged["nonce"] = "another"
+	if status, _ := launch(auth, forged); status != http.StatusUnauthorized {
+		t.Errorf("launch with another nonce: HTTP %d", status)
+	}
+	wrong := claimsFor(auth)
+	wrong["aud"] = "another-tool"
+	if status, _ := launch(auth, wrong); status != http.StatusUnauthorized {
+		t.Errorf("launch for another tool: HTTP %d", status)
+	}
+
+	// A teacher's launch fills in the course only.
+	auth = login()
+	teacher := claimsFor(auth)
+	teacher[ltiClaim+"roles"] = []string{ltiMembership + "Instructor"}
+	teacher[ltiClaim+"context"] = map[string]string{"id": "77", "title": "Data Science 101"}
+	if _, page := launch(auth, teacher); strings.Contains(page, `value="Jane Wanjiku"`) || !strings.Contains(page, `value="Data Science 101"`) {
+		t.Errorf("teacher's launch page:\n%s", page)
+	}
+}
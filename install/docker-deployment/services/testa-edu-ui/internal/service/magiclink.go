@@ -0,0 +1,312 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Magic-link sign-in. A student enters the address their credential was
+// emailed to and receives a one-time link into the portal, or from the
+// claim page if they lost their claim code. Tokens are HMAC-signed with
+// the link signing key, expire after MAGIC_LINK_TTL and are burned on first
+// use. Requests are limited to MAGIC_LINK_RATE_LIMIT an hour per address
+// (four times that per client), and the reply is the same whether or not
+// the address is known, so the form cannot be used to probe for students.
+// Opening the link shows a confirmation button rather than signing in, so
+// mail scanners that prefetch links do not burn the token.
+
+const magicLinkWindow = time.Hour
+
+type magicToken struct {
+	EmailHash string `json:"e"`
+	Masked    string `json:"m"`
+	Nonce     string `json:"n"`
+	Expires   int64  `json:"x"`
+}
+
+var (
+	usedMagicNonces   = make(map[string]time.Time)
+	usedMagicNoncesMu sync.Mutex
+
+	magicLinkEmailLimiter  *rateLimiter
+	magicLinkClientLimiter *rateLimiter
+)
+
+func initMagicLinks() {
+	magicLinkEmailLimiter = newRateLimiter(config.MagicLinkLimit, magicLinkWindow)
+	magicLinkClientLimiter = newRateLimiter(4*config.MagicLinkLimit, magicLinkWindow)
+}
+
+// emailLookupHash is the key under which credentials record the addresses
+// they were emailed to. It is keyed so the store does not hold addresses.
+func emailLookupHash(email string) string {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte("email-lookup\x00" + strings.ToLower(strings.TrimSpace(email))))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func magicTokenMAC(payload string) []byte {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte("magic-link\x00" + payload))
+	return mac.Sum(nil)
+}
+
+// newMagicToken returns a signed sign-in token for the address.
+func newMagicToken(email string) (string, time.Time) {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	expires := time.Now().Add(liveConfig().MagicLinkTTL)
+	data, _ := json.Marshal(magicToken{
+		EmailHash: emailLookupHash(email),
+		Masked:    maskRecipient(email),
+		Nonce:     base64.RawURLEncoding.EncodeToString(nonce),
+		Expires:   expires.Unix(),
+	})
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(magicTokenMAC(payload)), expires
+}
+
+// parseMagicToken checks a token's signature and expiry. With burn it also
+// marks the token used, failing if it already was.
+func parseMagicToken(token string, burn bool) (*magicToken, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if !ok || err != nil || !hmac.Equal(mac, magicTokenMAC(payload)) {
+		return nil, fmt.Errorf("invalid sign-in link")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sign-in link")
+	}
+	var t magicToken
+	if err := json.Unmarshal(data, &t); err != nil {
+		return nil, fmt.Errorf("invalid sign-in link")
+	}
+	expires := time.Unix(t.Expires, 0)
+	now := time.Now()
+	if now.After(expires) {
+		return nil, fmt.Errorf("this sign-in link has expired")
+	}
+
+	usedMagicNoncesMu.Lock()
+	defer usedMagicNoncesMu.Unlock()
+	if _, used := usedMagicNonces[t.Nonce]; used {
+		return nil, fmt.Errorf("this sign-in link has already been used")
+	}
+	if burn {
+		for n, exp := range usedMagicNonces {
+			if now.After(exp) {
+				delete(usedMagicNonces, n)
+			}
+		}
+		usedMagicNonces[t.Nonce] = expires
+	}
+	return &t, nil
+}
+
+// rateLimiter allows limit events per key in a sliding window.
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu   sync.Mutex
+	hits map[string][]time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, hits: make(map[string][]time.Time)}
+}
+
+// Allow records an event for key and reports whether it is within limit.
+func (l *rateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cutoff := time.Now().Add(-l.window)
+	prune := func(hits []time.Time) []time.Time {
+		i := 0
+		for i < len(hits) && hits[i].Before(cutoff) {
+			i++
+		}
+		return hits[i:]
+	}
+	if len(l.hits) > 1024 {
+		for k, hits := range l.hits {
+			if len(prune(hits)) == 0 {
+				delete(l.hits, k)
+			}
+		}
+	}
+	hits := prune(l.hits[key])
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false
+	}
+	l.hits[key] = append(hits, time.Now())
+	return true
+}
+
+// clientIP is the address a request came from, which rate limits are
+// kept by: its peer's, or, when the peer is one of TRUSTED_PROXIES, the
+// last address in X-Forwarded-For that is not a trusted proxy's, or
+// X-Real-IP. Any client can send the headers, so they are read only from
+// the proxies configured.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if _, err := netip.ParseAddr(hop); err != nil {
+				break
+			}
+			if !trustedProxy(hop) {
+				return hop
+			}
+			host = hop
+		}
+		return host
+	}
+	if real := strings.TrimSpace(r.Header.Get("X-Real-IP")); real != "" {
+		if _, err := netip.ParseAddr(real); err == nil {
+			return real
+		}
+	}
+	return host
+}
+
+// trustedProxy reports whether addr is one of TRUSTED_PROXIES.
+func trustedProxy(addr string) bool {
+	ip, err := netip.ParseAddr(addr)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range config.TrustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies reads TRUSTED_PROXIES: addresses and CIDR ranges.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, v := range splitList(s) {
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			ip, ipErr := netip.ParseAddr(v)
+			if ipErr != nil {
+				return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: want an address or a CIDR range", v)
+			}
+			p = netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen())
+		}
+		proxies = append(proxies, p.Masked())
+	}
+	return proxies, nil
+}
+
+// sendMagicLink emails a sign-in link if any credential was sent to the
+// address. It reports nothing about whether one was.
+func sendMagicLink(email string) error {
+	creds := store.ForEmail(emailLookupHash(email))
+	if len(creds) == 0 {
+		slog.Info("magic link requested for an address with no credentials")
+		return nil
+	}
+
+	token, expires := newMagicToken(email)
+	institution := ""
+	if subject, err := credentialSubjectOf(creds[0]); err == nil {
+		institution = formFromSubject(subject).Institution
+	}
+	rendered, err := renderEmail(EmailLogin, emailTenant(creds[0].TenantID, institution), EmailData{
+		Institution:  institution,
+		LoginURL:     config.PublicURL + "/portal/magic?token=" + token,
+		LoginExpires: fmt.Sprintf("%d minutes", int(time.Until(expires).Round(time.Minute).Minutes())),
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := buildMail(email, rendered, nil)
+	if err != nil {
+		return err
+	}
+	_, err = queueDelivery(&deliveryJob{
+		channel:     "email",
+		recipient:   email,
+		maxAttempts: config.MailMaxAttempts,
+		send:        func() error { return sendSMTP(email, msg) },
+		transient:   transientMailError,
+	}, "", creds[0].SubjectID)
+	return err
+}
+
+// handleMagicLinkRequest emails a sign-in link to a student.
+func handleMagicLinkRequest(w http.ResponseWriter, r *http.Request) {
+	if !mailEnabled() {
+		pages(r).ExecuteTemplate(w, "magic-link", map[string]interface{}{"Error": "Email sign-in is not available"})
+		return
+	}
+	addr, err := mail.ParseAddress(strings.TrimSpace(r.FormValue("email")))
+	if err != nil {
+		pages(r).ExecuteTemplate(w, "magic-link", map[string]interface{}{"Error": "Enter a valid email address"})
+		return
+	}
+	email := strings.ToLower(addr.Address)
+	if !magicLinkClientLimiter.Allow(clientIP(r)) || !magicLinkEmailLimiter.Allow(emailLookupHash(email)) {
+		slog.WarnContext(r.Context(), "magic link request rate limited")
+		pages(r).ExecuteTemplate(w, "magic-link", map[string]interface{}{"Error": "Too many sign-in requests. Please try again later."})
+		return
+	}
+	if err := sendMagicLink(email); err != nil {
+		slog.ErrorContext(r.Context(), "magic link error", "err", err)
+		pages(r).ExecuteTemplate(w, "magic-link", map[string]interface{}{"Error": "Failed to send the sign-in link"})
+		return
+	}
+	pages(r).ExecuteTemplate(w, "magic-link", map[string]interface{}{
+		"Sent":    true,
+		"Minutes": int(liveConfig().MagicLinkTTL.Minutes()),
+	})
+}
+
+// handleMagicLinkOpen asks the student to confirm signing in.
+func handleMagicLinkOpen(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if _, err := parseMagicToken(token, false); err != nil {
+		renderPortal(w, r, map[string]interface{}{"Error": "Sign-in failed: " + err.Error() + ". Request a new link below."})
+		return
+	}
+	renderPortal(w, r, map[string]interface{}{"MagicToken": token})
+}
+
+// handleMagicLinkSignIn burns the token and signs the student in.
+func handleMagicLinkSignIn(w http.ResponseWriter, r *http.Request) {
+	t, err := parseMagicToken(r.FormValue("token"), true)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "magic link rejected", "err", err)
+		renderPortal(w, r, map[string]interface{}{"Error": "Sign-in failed: " + err.Error() + ". Request a new link below."})
+		return
+	}
+	slog.InfoContext(r.Context(), "student signed in by magic link")
+	startPortalSession(w, &portalSession{emailHash: t.EmailHash, label: t.Masked})
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMagicLinkTTL sets MAGIC_LINK_TTL for one test.
+func useMagicLinkTTL(t *testing.T, ttl time.Duration) {
+	t.Helper()
+	saved := config.MagicLinkTTL
+	config.MagicLinkTTL = ttl
+	t.Cleanup(func() { config.MagicLinkTTL = saved })
+}
+
+// TestMagicToken checks that sign-in tokens are bound to their address,
+// rejected when altered or expired, and burn only on sign-in.
+func TestMagicToken(t *testing.T) {
+	useKeys(t, bytes.Repeat([]byte{1}, 32), nil)
+	useMagicLinkTTL(t, 15*time.Minute)
+
+	token, _ := newMagicToken("Amina@Example.com")
+	payload, sig, _ := strings.Cut(token, ".")
+	other, _ := newMagicToken("someone@example.com")
+	otherPayload, _, _ := strings.Cut(other, ".")
+
+	useMagicLinkTTL(t, -time.Second)
+	expired, _ := newMagicToken("amina@example.com")
+
+	tests := []struct {
+		name, token string
+		valid       bool
+	}{
+		{"issued", token, true},
+		{"other payload", otherPayload + "." + sig, false},
+		{"no signature", payload, false},
+		{"expired", expired, false},
+		{"malformed", "not.a-token", false},
+	}
+	for _, tt := range tests {
+		if _, err := parseMagicToken(tt.token, false); (err == nil) != tt.valid {
+			t.Errorf("%s: err = %v, want valid = %t", tt.name, err, tt.valid)
+		}
+	}
+
+	// Opening the link, as a mail scanner would, leaves it usable.
+	for i := 0; i < 2; i++ {
+		if _, err := parseMagicToken(token, false); err != nil {
+			t.Fatalf("open %d: %v", i, err)
+		}
+	}
+	got, err := parseMagicToken(token, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.EmailHash != emailLookupHash(" amina@example.COM ") {
+		t.Error("token not bound to the normalized address")
+	}
+	if _, err := parseMagicToken(token, true); err == nil {
+		t.Error("token used twice")
+	}
+	if _, err := parseMagicToken(token, false); err == nil {
+		t.Error("burned token still opens")
+	}
+}
+
+// TestRateLimiter checks the sliding window is counted per key.
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(2, 50*time.Millisecond)
+	for i, want := range []bool{true, true, false} {
+		if got := l.Allow("a"); got != want {
+			t.Errorf("Allow(a) #%d = %t, want %t", i+1, got, want)
+		}
+	}
+	if !l.Allow("b") {
+		t.Error("limit shared between keys")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Error("window did not slide")
+	}
+}
+
+// TestClientIP checks forwarding headers name the client only when a
+// trusted proxy sends them.
+func TestClientIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := config.TrustedProxies
+	config.TrustedProxies = proxies
+	t.Cleanup(func() { config.TrustedProxies = saved })
+
+	for _, c := range []struct {
+		peer, forwarded, real, want string
+	}{
+		{"203.0.113.9:5000", "198.51.100.1", "", "203.0.113.9"},
+		{"203.0.113.9:5000", "", "198.51.100.1", "203.0.113.9"},
+		{"192.0.2.1:5000", "198.51.100.1", "", "198.51.100.1"},
+		{"10.1.2.3:5000", "198.51.100.7, 198.51.100.1, 10.0.0.5", "", "198.51.100.1"},
+		{"10.1.2.3:5000", "10.0.0.6, 10.0.0.5", "", "10.0.0.6"},
+		{"10.1.2.3:5000", "nonsense, 10.0.0.5", "", "10.0.0.5"},
+		{"10.1.2.3:5000", "", "198.51.100.1", "198.51.100.1"},
+		{"10.1.2.3:5000", "", "nonsense", "10.1.2.3"},
+		{"[::ffff:10.1.2.3]:5000", "198.51.100.1", "", "198.51.100.1"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.peer
+		if c.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if c.real != "" {
+			r.Header.Set("X-Real-IP", c.real)
+		}
+		if got := clientIP(r); got != c.want {
+			t.Errorf("peer %s, X-Forwarded-For %q, X-Real-IP %q: %s, want %s", c.peer, c.forwarded, c.real, got, c.want)
+		}
+	}
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("an invalid range parsed")
+	}
+}
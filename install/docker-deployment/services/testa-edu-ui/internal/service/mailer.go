@@ -0,0 +1,266 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// Email delivery. When SMTP_HOST is set the portal emails the student
+// their certificate PDF and, if they consented to storage, a claim link
+// with a fresh claim code. Sends are queued and retried with backoff on
+// transient failures (network errors and 4xx replies) up to
+// MAIL_MAX_ATTEMPTS; progress is tracked in the delivery store.
+
+const mailDialTimeout = 15 * time.Second
+
+func mailEnabled() bool {
+	return config.SMTPHost != ""
+}
+
+// transientMailError reports whether a send is worth retrying: 4xx SMTP
+// replies and connection errors are; 5xx replies are permanent.
+func transientMailError(err error) bool {
+	var reply *textproto.Error
+	if errors.As(err, &reply) {
+		return reply.Code >= 400 && reply.Code < 500
+	}
+	return true
+}
+
+// sendSMTP delivers one message. SMTP_TLS selects implicit TLS ("tls",
+// usually port 465), STARTTLS ("starttls", the default) or plaintext
+// ("none", for local relays only).
+func sendSMTP(to string, msg []byte) error {
+	addr := net.JoinHostPort(config.SMTPHost, config.SMTPPort)
+	tlsConfig := &tls.Config{ServerName: config.SMTPHost}
+
+	var conn net.Conn
+	var err error
+	if config.SMTPTLS == "tls" {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: mailDialTimeout}, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, mailDialTimeout)
+	}
+	if err != nil {
+		return err
+	}
+	c, err := smtp.NewClient(conn, config.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if config.SMTPTLS == "starttls" {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("STARTTLS: %w", err)
+		}
+	}
+	if config.SMTPUsername != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.SMTPUsername, config.SMTPPassword, config.SMTPHost)); err != nil {
+			return fmt.Errorf("auth: %w", err)
+		}
+	}
+	if err := c.Mail(config.SMTPFrom); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+type mailAttachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// buildMail assembles a multipart/mixed message: the text and HTML bodies
+// as multipart/alternative, then the attachments.
+func buildMail(to string, email *RenderedEmail, attachments []mailAttachment) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	id := make([]byte, 12)
+	rand.Read(id)
+	domain := "localhost"
+	if _, d, ok := strings.Cut(config.SMTPFrom, "@"); ok {
+		domain = strings.TrimSuffix(d, ">")
+	}
+	fmt.Fprintf(&buf, "From: %s\r\n", config.SMTPFrom)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	boundary := multipart.NewWriter(nil).Boundary()
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + boundary},
+	})
+	if err != nil {
+		return nil, err
+	}
+	alt := multipart.NewWriter(part)
+	alt.SetBoundary(boundary)
+	for _, body := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", email.Text},
+		{"text/html; charset=utf-8", email.HTML},
+	} {
+		if body.content == "" {
+			continue
+		}
+		p, err := alt.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {body.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(p)
+		qp.Write([]byte(body.content))
+		qp.Close()
+	}
+	if err := alt.Close(); err != nil {
+		return nil, err
+	}
+
+	for _, a := range attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded + "\r\n"))
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sessionClaimLink stores the session's credential and returns a claim
+// page link with a fresh code and its expiry, or "" if the student did not
+// consent to storage.
+func sessionClaimLink(sess *Session) (string, time.Time, error) {
+	if !sess.Consent.Allows(ConsentScopeStore) {
+		return "", time.Time{}, nil
+	}
+	id, token, err := storeSessionCredential(sess)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	code, expires, err := claims.Create(id, studentDID(sess.Form), token, liveConfig().ClaimCodeTTL)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return claimPageURL(sess.TenantID) + "?code=" + code, expires, nil
+}
+
+// queueCredentialEmail builds the student's email and queues it.
+func queueCredentialEmail(sess *Session, to string) (*Delivery, error) {
+	addr, err := mail.ParseAddress(to)
+	if err != nil {
+		return nil, fmt.Errorf("invalid email address")
+	}
+	pdf, err := sessionPDF(context.Background(), sess, liveConfig().DefaultLanguage)
+	if err != nil {
+		return nil, fmt.Errorf("generating PDF: %w", err)
+	}
+	link, linkExpires, err := sessionClaimLink(sess)
+	if err != nil {
+		return nil, fmt.Errorf("creating claim link: %w", err)
+	}
+	if link != "" {
+		if err := store.AddEmail(sess.CredentialID, emailLookupHash(addr.Address)); err != nil {
+			slog.Error("credential email record error", "err", err)
+		}
+	}
+
+	data := EmailData{
+		StudentName: sess.Form.StudentName,
+		Institution: sess.Form.Institution,
+		Degree:      sess.Form.Degree,
+		IssuedAt:    sess.CreatedAt.Format("2 January 2006"),
+		ClaimURL:    link,
+	}
+	if link != "" {
+		data.ClaimExpires = linkExpires.Format("2 January 2006")
+	}
+	email, err := renderEmail(EmailClaim, emailTenant(sess.TenantID, sess.Form.Institution), data)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := buildMail(addr.Address, email, []mailAttachment{
+		{Name: "testa-edu-credential.pdf", ContentType: "application/pdf", Data: pdf},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return queueSessionDelivery(sess, &deliveryJob{
+		channel:     "email",
+		recipient:   addr.Address,
+		maxAttempts: config.MailMaxAttempts,
+		send:        func() error { return sendSMTP(addr.Address, msg) },
+		transient:   transientMailError,
+	})
+}
+
+// handleDeliverEmail emails the session's credential to the given address.
+func handleDeliverEmail(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderFragment(w, r, "delivery-status", map[string]interface{}{"Error": "Session expired. Please start over."})
+		return
+	}
+	if !mailEnabled() {
+		renderFragment(w, r, "delivery-status", map[string]interface{}{"Error": "Email delivery is not configured"})
+		return
+	}
+	d, err := queueCredentialEmail(sess, strings.TrimSpace(r.FormValue("email")))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "email delivery error", "err", err)
+		renderFragment(w, r, "delivery-status", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	renderFragment(w, r, "delivery-status", map[string]interface{}{"Delivery": d})
+}
@@ -0,0 +1,1318 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+type Config struct {
+	Port       string
+	AgentURL   string
+	APIKey     string
+	IssuerDID  string
+	IssuerDIDs []IssuerOption
+	NodeBin    string
+	ScriptsDir string
+
+	// AgentRetries is how many times a failed agent call is retried.
+	AgentRetries      int
+	AgentRetryBackoff time.Duration
+	// AgentBreakerThreshold consecutive failures open the circuit breaker
+	// for AgentBreakerCooldown; 0 disables it.
+	AgentBreakerThreshold int
+	AgentBreakerCooldown  time.Duration
+	// AgentTimeout bounds each agent call attempt, unless AgentTimeouts
+	// has one for its endpoint.
+	AgentTimeout  time.Duration
+	AgentTimeouts map[string]time.Duration
+	// AgentCAFile, AgentClientCert and AgentClientKey configure TLS to
+	// the agent; see agenthttp.go.
+	AgentCAFile      string
+	AgentClientCert  string
+	AgentClientKey   string
+	AgentTLSInsecure bool
+	// AgentTokenTTL is how long an agent token without an expiry is
+	// cached; 0 disables caching.
+	AgentTokenTTL time.Duration
+	// AgentTokenURL, when set, has agent tokens obtained with the OAuth2
+	// client-credentials grant instead; see agentoauth.go.
+	AgentTokenURL     string
+	AgentClientID     string
+	AgentClientSecret string
+	AgentTokenScope   string
+	// AgentURLs lists AGENT_URL's agents, AgentURL being the first;
+	// with more than one, calls fail over (agentfailover.go).
+	AgentURLs           []string
+	AgentBalance        string
+	AgentHealthInterval time.Duration
+	// AgentBackend is the agent's REST API; see agentbackend.go.
+	AgentBackend string
+	// AgentMaxResponse bounds agent response bodies, in bytes; see
+	// agentbody.go.
+	AgentMaxResponse int64
+	// AgentMaxConcurrent bounds concurrent sign and verify calls, which
+	// wait up to AgentQueueTimeout for a slot; see agentlimit.go.
+	AgentMaxConcurrent int
+	AgentQueueTimeout  time.Duration
+	// AgentContract checks calls against the agent's API as
+	// AgentContractFile describes it; see agentcontract.go.
+	AgentContract     string
+	AgentContractFile string
+
+	PublicURL  string
+	SchemaFile string
+	// FieldMappingFile maps the form to the subject; see fieldmap.go.
+	FieldMappingFile string
+	// GradingScalesFile, DefaultGradingScale and GPANormalize say how GPAs
+	// are graded; see gradingscales.go.
+	GradingScalesFile   string
+	DefaultGradingScale string
+	GPANormalize        bool
+	// BasePath mounts the app under a path prefix; see basepath.go.
+	BasePath   string
+	ProofType  string
+	ProofTypes map[string]string
+	SDClaims   []string
+	QRMode     string
+	QRMaxChars int
+
+	QRErrorCorrection string
+	QRModuleSize      int
+	QRQuietZone       int
+	QRLogo            string
+
+	VCVersion          string
+	CredentialValidity time.Duration
+	// Timezone is the zone dates are shown and entered in, and MaxBackdate how
+	// far back an operator may date them; see issuedates.go.
+	Timezone    *time.Location
+	MaxBackdate time.Duration
+
+	IssuerName                string
+	IssuerLogoURL             string
+	OID4VCICredentialEndpoint string
+
+	SigningMode         string
+	SigningKey          string
+	SigningKeyURI       string
+	VerificationMethods map[string]string
+	// DemoMode has a built-in fake agent sign and verify; see
+	// demoagent.go.
+	DemoMode bool
+	// SecureBoot refuses sample secrets and generates per-install keys;
+	// see secureboot.go.
+	SecureBoot bool
+
+	AnonCredsIssuerID  string
+	AnonCredsCredDefID string
+
+	MdocSignerKey  string
+	MdocSignerCert string
+
+	PassTypeID   string
+	PassTeamID   string
+	PassCert     string
+	PassKey      string
+	PassWWDRCert string
+
+	GoogleWalletIssuerID       string
+	GoogleWalletClassID        string
+	GoogleWalletServiceAccount string
+
+	LinkedInOrganizationID string
+
+	OrcidClientID            string
+	OrcidClientSecret        string
+	OrcidURL                 string
+	OrcidAPIURL              string
+	OrcidSection             string
+	OrcidOrganizationCity    string
+	OrcidOrganizationCountry string
+	OrcidOrganizationROR     string
+
+	ContextCacheDir string
+	ContextPinsFile string
+	DataDir         string
+	ArchiveDir      string // cold storage for archived credentials; see archive.go
+	ShareLinkTTL    time.Duration
+	ClaimCodeTTL    time.Duration
+	LinkSigningKey  string
+	HolderKey       string
+	BackupKey       string // encrypts backups; see backup.go
+	PIIMode         string
+	StaffAPIToken   string
+	MetricsToken    string
+	DebugEndpoints  bool
+
+	ConsentTermsURL     string
+	ConsentInCredential string
+
+	Retention         map[string]time.Duration
+	RetentionInterval time.Duration
+	RetentionDryRun   bool
+	LeaderElection    bool
+
+	LogRedactFields []string
+	LogFormat       string
+	LogLevel        slog.Level
+
+	// OTLPEndpoint is the OpenTelemetry collector spans are exported to;
+	// see tracing.go.
+	OTLPEndpoint     string
+	OTLPHeaders      map[string]string
+	TraceServiceName string
+
+	// SentryDSN is where errors are reported; see errorreport.go.
+	SentryDSN         string
+	SentryEnvironment string
+	SentryRelease     string
+
+	SMTPHost        string
+	SMTPPort        string
+	SMTPUsername    string
+	SMTPPassword    string
+	SMTPFrom        string
+	SMTPTLS         string
+	MailMaxAttempts int
+
+	EmailTemplatesDir string
+
+	PDFRenderer             string
+	CertificateTemplatesDir string
+	PDFSigningCert          string
+	PDFSigningKey           string
+	PDFArchival             bool
+	PDFFont                 string
+	PDFFontBold             string
+	// ArtifactWorkers bounds the QR codes, PDFs and passes made at once
+	// after signing; see artifacts.go.
+	ArtifactWorkers int
+
+	MagicLinkTTL   time.Duration
+	MagicLinkLimit int
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP name the client; see clientIP.
+	TrustedProxies []netip.Prefix
+
+	VerifyRequestTTL time.Duration
+
+	EmbedOrigins []string
+
+	TrustRegistry        []string
+	TrustRegistryRefresh time.Duration
+
+	DIDCacheTTL              time.Duration
+	DIDCacheMaxStale         time.Duration
+	DIDCacheMaxEntries       int
+	UniversalResolverURL     string
+	UniversalResolverTimeout time.Duration
+
+	PolygonNetwork       string
+	PolygonWalletAddress string
+	PolygonTestnetRPCURL string
+	PolygonMainnetRPCURL string
+
+	AnchorMode     string
+	AnchorInterval time.Duration
+	AnchorDID      string
+
+	TSAURL string
+
+	// ListenSocket is a Unix socket served besides PORT; see listeners.go.
+	ListenSocket     string
+	ListenSocketMode fs.FileMode
+
+	TLSAddr    string
+	TLSCertDir string
+	// TLSCertFile and TLSKeyFile are the certificate served for names
+	// without one of their own; with ACME, certificates are obtained from
+	// ACMEDirectoryURL. See domains.go and acme.go.
+	TLSCertFile      string
+	TLSKeyFile       string
+	TLSRedirect      bool
+	ACME             bool
+	ACMEEmail        string
+	ACMEDirectoryURL string
+
+	DefaultLanguage string
+
+	Theme Theme
+
+	SMSProvider      string
+	SMSMaxAttempts   int
+	SMSAPIURL        string
+	TwilioAccountSID string
+	TwilioAuthToken  string
+	TwilioFrom       string
+	ATUsername       string
+	ATAPIKey         string
+	ATSenderID       string
+
+	SlackWebhooks []string
+	TeamsWebhooks []string
+
+	// SignHooks are the hooks run around signing; see hooks.go.
+	SignHooks []string
+
+	// LMSConfigFile lists the LMS connectors; see lms.go.
+	LMSConfigFile string
+
+	// MakerChecker holds each issuance for a second person's approval; see
+	// approvals.go.
+	MakerChecker bool
+	// ApprovalTTL is how long a request waits for a decision.
+	ApprovalTTL time.Duration
+
+	// MaintenanceMode serves reads and verification only; see
+	// errorpages.go.
+	MaintenanceMode bool
+}
+
+var config Config
+
+// serverContext is cancelled when the server shuts down.
+var serverContext = context.Background()
+
+// Main runs the service; see cmd/testa for the command-line tool.
+func Main() {
+	if err := applyConfigFlags(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	initService()
+	if agentFailover != nil {
+		startAgentHealthChecks()
+	}
+	startAgentCapabilities()
+	startLeaderElection()
+	startRetention()
+	startAnchoring()
+	startLMSPolling()
+	startTrustRegistry()
+	var err error
+	smsProvider, err = newSMSProvider()
+	if err != nil {
+		fatal("SMS", "err", err)
+	}
+	startDeliveries()
+	initMagicLinks()
+	startTracing()
+
+	mux := newMux()
+
+	// Shutting down cancels serverContext, and with it the agent calls of
+	// requests in flight, then waits for the requests to finish.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	serverContext = ctx
+
+	startReloads(ctx)
+
+	handler := accessLog(recoverPanics(withSnapshot(withBasePath(tenantHosts(maintenanceGate(logRequests(traceRequests(mux))))))))
+	if config.TLSAddr != "" {
+		if config.ACME {
+			acmeManager = newACMEManager(&config)
+		}
+		go serveTLS(handler)
+	}
+	listeners, err := openListeners()
+	if err != nil {
+		fatal("listening", "err", err)
+	}
+	srv := &http.Server{Handler: plainHandler(handler), BaseContext: baseContext}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-ctx.Done()
+		slog.Info("shutting down")
+		ready.Store(false)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("shutdown", "err", err)
+		}
+		stopTracing(shutdownCtx)
+	}()
+	for _, l := range listeners[1:] {
+		go func(l net.Listener) {
+			slog.Info("Testa Edu UI serving", "addr", l.Addr().String())
+			if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+				fatal("serving", "addr", l.Addr().String(), "err", err)
+			}
+		}(l)
+	}
+	ready.Store(true)
+	slog.Info("Testa Edu UI starting", "addr", listeners[0].Addr().String())
+	if err := srv.Serve(listeners[0]); !errors.Is(err, http.ErrServerClosed) {
+		fatal("serving", "addr", listeners[0].Addr().String(), "err", err)
+	}
+	<-done
+}
+
+// initService loads the configuration and opens what the service runs on:
+// its templates, stores and agent client. It exits on any error.
+func initService() {
+	config = loadConfig()
+	logLevel.Set(config.LogLevel)
+	secrets := []string{config.APIKey, config.StaffAPIToken, config.MetricsToken, config.LinkSigningKey, config.HolderKey, config.BackupKey, config.SigningKey, config.SMTPPassword, config.TwilioAuthToken, config.ATAPIKey, config.OrcidClientSecret, config.AgentClientSecret, config.SentryDSN}
+	for _, v := range config.OTLPHeaders {
+		secrets = append(secrets, v)
+	}
+	redactor := newLogRedactor(config.LogRedactFields, secrets)
+	initLogging(config.LogFormat, &redactingWriter{out: os.Stderr, redactor: redactor})
+	if err := startErrorReporting(redactor); err != nil {
+		fatal("config", "err", err)
+	}
+
+	if err := initLanguages(); err != nil {
+		fatal("templates", "err", err)
+	}
+	if err := loadPDFSigner(); err != nil {
+		fatal("config", "err", err)
+	}
+	if err := initSignHooks(); err != nil {
+		fatal("config", "err", err)
+	}
+
+	var err error
+	contexts, err = NewContextCache(config.ContextCacheDir, config.ContextPinsFile)
+	if err != nil {
+		fatal("context cache", "err", err)
+	}
+	go contexts.Prefetch()
+
+	credSchema, err = LoadCredentialSchema(config.SchemaFile)
+	if err != nil {
+		fatal("credential schema", "err", err)
+	}
+	if fieldMapping, err = LoadFieldMapping(config.FieldMappingFile); err != nil {
+		fatal("field mapping", "err", err)
+	}
+	if gradingScales, err = LoadGradingScales(config.GradingScalesFile, config.DefaultGradingScale); err != nil {
+		fatal("grading scales", "err", err)
+	}
+	if lmsConnectors, err = LoadLMSConnectors(config.LMSConfigFile); err != nil {
+		fatal("LMS connectors", "err", err)
+	}
+
+	if err := openStores(); err != nil {
+		fatal("stores", "err", err)
+	}
+	if err := loadProvisionedDID(); err != nil {
+		fatal("issuer DID", "err", err)
+	}
+	if config.SigningMode == SigningModeLocal {
+		if err := enableLocalSigning(); err != nil {
+			fatal("local signing", "err", err)
+		}
+	}
+	if agentTLS, err = agentTLSConfig(); err != nil {
+		fatal("agent TLS", "err", err)
+	}
+	if config.AgentTLSInsecure {
+		slog.Warn("AGENT_TLS_INSECURE is set; the agent's certificate is not verified")
+	}
+	if config.DemoMode {
+		enableDemoAgent()
+	}
+	if config.AgentContract != AgentContractOff {
+		if agentContract, err = LoadAgentContract(config.AgentContractFile); err != nil {
+			fatal("agent contract", "err", err)
+		}
+	}
+	agentClient = NewAgentClient(config.AgentURL, config.APIKey)
+	if config.DemoMode {
+		agentClient.client = demoAgentClient()
+	}
+	if len(config.AgentURLs) > 1 {
+		agentFailover = newAgentPool(config.AgentURLs, config.AgentBalance)
+		agentClient.failover = agentFailover
+	}
+}
+
+// openStores opens the stores kept in DATA_DIR.
+func openStores() error {
+	var err error
+	store, err = NewCredentialStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("credential store: %w", err)
+	}
+	store.archiveDir = config.ArchiveDir
+	anchors, err = NewAnchorStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("anchor store: %w", err)
+	}
+	shares, err = NewShareStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("share store: %w", err)
+	}
+	shortLinks, err = NewShortLinkStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("short link store: %w", err)
+	}
+	pii, err = NewPIIStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("PII store: %w", err)
+	}
+	consents, err = NewConsentStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("consent store: %w", err)
+	}
+	if config.HolderKey != "" {
+		holderKey, _ = hex.DecodeString(config.HolderKey)
+	} else if config.SecureBoot {
+		if holderKey, err = loadInstallKey(config.DataDir, "holder.key"); err != nil {
+			return fmt.Errorf("holder key: %w", err)
+		}
+	}
+	subjects, err = NewSubjectStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("subject store: %w", err)
+	}
+	claims, err = NewClaimStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("claim store: %w", err)
+	}
+	orcid, err = NewOrcidStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("ORCID store: %w", err)
+	}
+	wallets, err = NewWalletStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("wallet store: %w", err)
+	}
+	verifyRequests, err = NewVerificationRequestStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("verification request store: %w", err)
+	}
+	verifierKeys, err = NewVerifierKeyStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("verifier key store: %w", err)
+	}
+	verifications, err = NewVerificationLog(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("verification log: %w", err)
+	}
+	didResolver, err = NewDIDResolver(config.DataDir, config.DIDCacheTTL, config.DIDCacheMaxStale, config.DIDCacheMaxEntries)
+	if err != nil {
+		return fmt.Errorf("DID resolver: %w", err)
+	}
+	trustRegistry, err = NewTrustRegistry(config.TrustRegistry)
+	if err != nil {
+		return fmt.Errorf("trust registry: %w", err)
+	}
+	linkKey, err = loadLinkKey(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("link signing: %w", err)
+	}
+	deliveries, err = NewDeliveryStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("delivery store: %w", err)
+	}
+	tenants, err = NewTenantStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("tenant store: %w", err)
+	}
+	approvals, err = NewApprovalStore(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("approval store: %w", err)
+	}
+	lmsIssued, err = openLMSLedger(config.DataDir)
+	if err != nil {
+		return fmt.Errorf("LMS ledger: %w", err)
+	}
+	return nil
+}
+
+// newMux returns the service's routes.
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	mux.HandleFunc("/", pageNotFound)
+	mux.HandleFunc("GET /{$}", handleIndex)
+	mux.HandleFunc("GET /health", handleHealth)
+	mux.HandleFunc("GET /livez", handleLivez)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	mux.HandleFunc("GET /metrics", handleMetrics)
+	mux.HandleFunc("GET /lang/{code}", handleLanguage)
+	mux.HandleFunc("GET /contexts", handleContext)
+	mux.HandleFunc("GET "+didConfigurationPath, handleDIDConfiguration)
+	mux.HandleFunc("GET /.well-known/openid-credential-issuer", handleIssuerMetadata)
+	mux.HandleFunc("GET "+ocaBundlePath, handleOCABundle)
+	mux.HandleFunc("GET "+jwksPath, handleJWKS)
+	if localSigner != nil {
+		if path := localDIDDocumentPath(localSigner.did); path != "" {
+			mux.HandleFunc("GET "+path, handleDIDDocument)
+		}
+	}
+	mux.HandleFunc("GET "+credentialSchemaPath, handleCredentialSchema)
+	mux.HandleFunc("GET "+gradingScalesPath, handleGradingScales)
+	mux.HandleFunc("GET /api/proof-types", handleProofTypes)
+
+	mux.HandleFunc("GET /c/{id}", handleCredentialRetrieve)
+	mux.HandleFunc("GET /share/{token}", handleShareOpen)
+	mux.HandleFunc("GET /s/{code}", handleShortLink)
+	mux.HandleFunc("GET /claim", handleClaimPage)
+	mux.HandleFunc("POST /claim", handleClaimRedeem)
+	mux.HandleFunc("GET /portal", handlePortal)
+	mux.HandleFunc("POST /portal/login", handlePortalLogin)
+	mux.HandleFunc("POST /portal/logout", handlePortalLogout)
+	mux.HandleFunc("POST /portal/magic-link", handleMagicLinkRequest)
+	mux.HandleFunc("GET /portal/magic", handleMagicLinkOpen)
+	mux.HandleFunc("POST /portal/magic", handleMagicLinkSignIn)
+	mux.HandleFunc("POST /portal/credentials/{id}/wallet", handleWalletAdd)
+	mux.HandleFunc("POST /portal/wallet/{item}/present", handleWalletPresent)
+	mux.HandleFunc("POST /portal/wallet/{item}/remove", handleWalletRemove)
+	mux.HandleFunc("GET /portal/wallet/export", handleWalletExport)
+	mux.HandleFunc("GET /vp/{token}", handlePresentationOpen)
+	mux.HandleFunc("GET /status/credential/{id}", handleCredentialStatus)
+	mux.HandleFunc("GET /scan", handleScanPage)
+	mux.HandleFunc("POST /scan/verify", handleScanVerify)
+	mux.HandleFunc("GET /verify", handleVerifyPage)
+	mux.HandleFunc("GET /verify/{id}", handleVerifyPage)
+	mux.HandleFunc("GET /verify/{id}/card.png", handleShareCard)
+	mux.HandleFunc("GET /embed/verify", handleEmbedPage)
+	mux.HandleFunc("POST /embed/verify", handleEmbedVerify)
+	mux.HandleFunc("GET /portal/credentials/{id}/download", handlePortalDownload)
+	mux.HandleFunc("POST /portal/credentials/{id}/share", handlePortalShare)
+	mux.HandleFunc("POST /portal/verify-requests/{id}/approve", handleVerifyRequestApprove)
+	mux.HandleFunc("POST /portal/verify-requests/{id}/decline", handleVerifyRequestDecline)
+	mux.HandleFunc("GET /verify-requests/new", handleVerifyRequestForm)
+	mux.HandleFunc("POST /verify-requests", handleVerifyRequestCreate)
+	mux.HandleFunc("GET /verify-requests/{id}", handleVerifyRequestStatus)
+	mux.HandleFunc("GET /verify-requests/{id}/presentation", handleVerifyRequestPresentation)
+
+	mux.HandleFunc("POST /holder/challenge", handleHolderChallenge)
+	mux.HandleFunc("POST /holder/proof", handleHolderProof)
+	mux.HandleFunc("GET /api/staff/pii/{hash}", requireStaff(handlePIILookup))
+	mux.HandleFunc("GET /api/staff/pii", requireStaff(handlePIIHashes))
+	mux.HandleFunc("POST /api/staff/pii/salts", requireStaff(handlePIIRotateSalt))
+	mux.HandleFunc("GET /api/staff/signing-keys", requireStaff(handleSigningKeyList))
+	mux.HandleFunc("GET /api/staff/backup", requireStaff(handleBackup))
+	mux.HandleFunc("POST /api/staff/signing-keys/rotate", requireStaff(handleSigningKeyRotate))
+	mux.HandleFunc("POST /api/staff/subjects/erase", requireStaff(handleSubjectErase))
+	mux.HandleFunc("POST /api/staff/claims/regenerate", requireTenantStaff(handleClaimRegenerate))
+	mux.HandleFunc("GET /api/staff/deliveries", requireStaff(handleDeliveryList))
+	mux.HandleFunc("GET /api/staff/credentials", requireTenantStaff(handleCredentialList))
+	mux.HandleFunc("GET /api/staff/cohorts/certificates.zip", requireTenantStaff(handleCohortZip))
+	mux.HandleFunc("GET /api/staff/cohorts/certificates.pdf", requireTenantStaff(handleCohortPDF))
+	mux.HandleFunc("GET /api/staff/cohorts/qr-sheet.pdf", requireTenantStaff(handleCohortQRSheet))
+	mux.HandleFunc("POST /api/staff/credentials/{id}/status", requireTenantStaff(handleCredentialStatusChange))
+	mux.HandleFunc("GET /api/staff/credentials/{id}/timestamp.tsr", requireTenantStaff(handleTimestampDownload))
+	mux.HandleFunc("POST /api/staff/credentials/{id}/archive", requireTenantStaff(handleCredentialArchive))
+	mux.HandleFunc("POST /api/staff/credentials/{id}/unarchive", requireTenantStaff(handleCredentialArchive))
+	mux.HandleFunc("POST /api/staff/credentials/archive", requireTenantStaff(handleCredentialArchiveOld))
+	mux.HandleFunc("GET /api/staff/tenants", requireStaff(handleTenantList))
+	mux.HandleFunc("POST /api/staff/tenants", requireStaff(handleTenantCreate))
+	mux.HandleFunc("POST /api/staff/tenants/{id}/users", requireStaff(handleTenantUserCreate))
+	mux.HandleFunc("POST /api/staff/tenants/{id}/users/{user}/token", requireStaff(handleTenantUserToken))
+	mux.HandleFunc("POST /api/staff/tenants/{id}/scim-token", requireStaff(handleSCIMToken))
+	mux.HandleFunc("GET /scim/v2/Users", requireSCIM(handleSCIMUsers))
+	mux.HandleFunc("POST /scim/v2/Users", requireSCIM(handleSCIMUserPut))
+	mux.HandleFunc("GET /scim/v2/Users/{id}", requireSCIM(handleSCIMUserGet))
+	mux.HandleFunc("PUT /scim/v2/Users/{id}", requireSCIM(handleSCIMUserPut))
+	mux.HandleFunc("PATCH /scim/v2/Users/{id}", requireSCIM(handleSCIMUserPatch))
+	mux.HandleFunc("DELETE /scim/v2/Users/{id}", requireSCIM(handleSCIMUserDelete))
+	mux.HandleFunc("GET /scim/v2/ServiceProviderConfig", requireSCIM(handleSCIMServiceProviderConfig))
+	mux.HandleFunc("GET /scim/v2/ResourceTypes", requireSCIM(handleSCIMResourceTypes))
+	mux.HandleFunc("PUT /api/staff/tenants/{id}/branding", requireTenantStaff(handleTenantBranding))
+	mux.HandleFunc("PUT /api/staff/tenants/{id}/domains", requireStaff(handleTenantDomains))
+	mux.HandleFunc("GET /api/staff/verification-requests", requireStaff(handleVerifyRequestList))
+	mux.HandleFunc("GET /api/staff/verifier-keys", requireStaff(handleVerifierKeyList))
+	mux.HandleFunc("GET /api/staff/trust-registry", requireStaff(handleTrustRegistry))
+	mux.HandleFunc("GET /api/staff/verifications", requireStaff(handleVerificationList))
+	mux.HandleFunc("GET /api/staff/verifications/report", requireStaff(handleVerificationReport))
+	mux.HandleFunc("POST /api/staff/verifier-keys", requireStaff(handleVerifierKeyCreate))
+	mux.HandleFunc("DELETE /api/staff/verifier-keys/{id}", requireStaff(handleVerifierKeyRevoke))
+	mux.HandleFunc("GET /api/staff/email-templates/preview", requireStaff(handleEmailTemplatePreview))
+	mux.HandleFunc("GET /api/staff/certificate-templates/preview", requireStaff(handleCertificateTemplatePreview))
+	mux.HandleFunc("POST /api/staff/issuer-did", requireStaff(handleDIDProvisionStart))
+	mux.HandleFunc("GET /api/staff/issuer-did/{id}", requireStaff(handleDIDProvisionStatus))
+	mux.HandleFunc("POST /api/staff/issuer-did/polygon", requireStaff(handleDIDProvisionStart))
+	mux.HandleFunc("GET /api/staff/issuer-did/polygon/{id}", requireStaff(handleDIDProvisionStatus))
+	mux.HandleFunc("GET /api/staff/polygon/wallet", requireStaff(handlePolygonWallet))
+	mux.HandleFunc("GET /admin/email-templates", handleEmailTemplatesPage)
+	mux.HandleFunc("GET /admin/issuer-did", handleIssuerDIDPage)
+	mux.HandleFunc("GET /admin/setup", handleIssuerDIDPage)
+	mux.HandleFunc("GET /admin/agent", handleAgentPage)
+	mux.HandleFunc("GET /api/staff/agent/capabilities", requireStaff(handleAgentCapabilities))
+	mux.HandleFunc("POST /api/staff/agent/capabilities", requireStaff(handleAgentCapabilities))
+	mux.HandleFunc("GET /admin/approvals", handleApprovalsPage)
+	mux.HandleFunc("GET /api/staff/approvals", requireApprover(handleApprovalList))
+	mux.HandleFunc("POST /api/staff/approvals/{id}", requireApprover(handleApprovalDecision))
+	mux.HandleFunc("GET /api/staff/retention", requireStaff(handleRetention))
+	mux.HandleFunc("POST /api/staff/retention", requireStaff(handleRetention))
+
+	mux.HandleFunc("POST /api/lms/{connector}/events", handleLMSEvent)
+	mux.HandleFunc("GET /lti/{connector}/login", handleLTILogin)
+	mux.HandleFunc("POST /lti/{connector}/login", handleLTILogin)
+	mux.HandleFunc("POST /lti/{connector}/launch", handleLTILaunch)
+
+	mux.HandleFunc("POST /issue", handleIssueStart)
+	mux.HandleFunc("POST /step/token", handleStepToken)
+	mux.HandleFunc("POST /step/sign", handleStepSign)
+	mux.HandleFunc("POST /step/verify", handleStepVerify)
+	mux.HandleFunc("POST /step/qr", handleStepQR)
+	mux.HandleFunc("POST /step/cancel", handleStepCancel)
+	mux.HandleFunc("POST /preview/certificate", handleCertificatePreview)
+	mux.HandleFunc("GET /preview/{file}", handlePreviewFile)
+
+	mux.HandleFunc("GET /download/qr.png", handleDownloadQRPNG)
+	mux.HandleFunc("GET /download/qr.svg", handleDownloadQRSVG)
+	mux.HandleFunc("GET /download/qr.eps", handleDownloadQREPS)
+	mux.HandleFunc("GET /download/qr.gif", handleDownloadQRGIF)
+	mux.HandleFunc("GET /download/barcode/{symbology}", handleDownloadBarcode)
+	mux.HandleFunc("GET /download/qr-frames.zip", handleDownloadQRFrames)
+	mux.HandleFunc("GET /download/bundle.zip", handleDownloadBundle)
+	mux.HandleFunc("GET /download/credential.pdf", handleDownloadPDF)
+	mux.HandleFunc("GET /download/certificate.png", handleDownloadCertificatePNG)
+	mux.HandleFunc("GET /download/certificate.svg", handleDownloadCertificateSVG)
+	mux.HandleFunc("GET /download/credential.json", handleDownloadJSON)
+	mux.HandleFunc("GET /download/credential.jsonxt", handleDownloadJSONXT)
+	mux.HandleFunc("GET /download/credential.jwt", handleDownloadJWT)
+	mux.HandleFunc("GET /download/credential.sd-jwt", handleDownloadSDJWT)
+	mux.HandleFunc("GET /download/presentation.jwt", handleDownloadPresentation)
+	mux.HandleFunc("POST /download/presentation.sd-jwt", handleSDJWTPresent)
+	mux.HandleFunc("POST /download/derived.json", handleDownloadDerived)
+	mux.HandleFunc("GET /download/export/{name}", handleDownloadExport)
+	mux.HandleFunc("GET /download/mdoc-engagement.png", handleDownloadMdocEngagement)
+	mux.HandleFunc("GET /download/credential.pkpass", handleDownloadPKPass)
+	mux.HandleFunc("GET /wallet/google", handleGoogleWallet)
+	mux.HandleFunc("GET /linkedin", handleLinkedIn)
+	mux.HandleFunc("GET /orcid/connect", handleOrcidConnect)
+	mux.HandleFunc("GET /orcid/callback", handleOrcidCallback)
+	mux.HandleFunc("POST /api/derive", handleDeriveAPI)
+	mux.HandleFunc("POST /api/v1/verify", requireVerifier(handleVerifyAPI))
+	mux.HandleFunc("GET /api/v1/policy", requireVerifier(handlePolicyGet))
+	mux.HandleFunc("PUT /api/v1/policy", requireVerifier(handlePolicyPut))
+	mux.HandleFunc("POST /share", handleShareCreate)
+	mux.HandleFunc("POST /claim-code", handleClaimCodeCreate)
+	mux.HandleFunc("POST /deliver/email", handleDeliverEmail)
+	mux.HandleFunc("POST /deliver/sms", handleDeliverSMS)
+	mux.HandleFunc("GET /delivery/{id}", handleDeliveryStatus)
+	handleDebugEndpoints(mux)
+	return mux
+}
+
+// shutdownGrace is how long requests in flight get to finish on shutdown.
+const shutdownGrace = 10 * time.Second
+
+// baseContext is the servers' base request context.
+func baseContext(net.Listener) context.Context {
+	return serverContext
+}
+
+// loadConfig parses the configuration, exiting if it is invalid.
+func loadConfig() Config {
+	c, err := parseConfig()
+	var invalid *ConfigError
+	if errors.As(err, &invalid) {
+		for _, p := range invalid.Problems {
+			log.Printf("config: %s", p)
+		}
+		log.Fatalf("config: %d invalid settings; fix them and restart", len(invalid.Problems))
+	}
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	return c
+}
+
+// parseConfig reads the configuration from the environment and the config
+// file it names.
+func parseConfig() (Config, error) {
+	configFile := getenv("CONFIG_FILE")
+	settings, err := applyConfigFile(configFile)
+	if err != nil {
+		return Config{}, err
+	}
+	defer warnUnreadSettings(configFile, settings)
+	if err := resolveSecrets(); err != nil {
+		return Config{}, err
+	}
+
+	proofTypes, err := parseProofTypes(getenv("PROOF_TYPES"))
+	if err != nil {
+		return Config{}, err
+	}
+	verificationMethods, err := parseVerificationMethods(getenv("VERIFICATION_METHODS"))
+	if err != nil {
+		return Config{}, err
+	}
+	issuerDIDs, err := parseIssuerDIDs(getenv("ISSUER_DIDS"))
+	if err != nil {
+		return Config{}, err
+	}
+	holderKeyHex := getenv("HOLDER_KEY")
+	if k, err := hex.DecodeString(holderKeyHex); err != nil || (holderKeyHex != "" && len(k) != 32) {
+		return Config{}, fmt.Errorf("HOLDER_KEY must be 32 bytes, hex encoded")
+	}
+	backupKeyHex := getenv("BACKUP_KEY")
+	if k, err := hex.DecodeString(backupKeyHex); err != nil || (backupKeyHex != "" && len(k) != 32) {
+		return Config{}, fmt.Errorf("BACKUP_KEY must be 32 bytes, hex encoded")
+	}
+	proofType := envOr("PROOF_TYPE", defaultProofType)
+	if _, ok := proofSuites[proofType]; !ok {
+		return Config{}, fmt.Errorf("unsupported PROOF_TYPE %q", proofType)
+	}
+
+	qrMode := envOr("QR_MODE", QRModePixelPass)
+	if !validQRMode(qrMode) {
+		return Config{}, fmt.Errorf("QR_MODE must be one of %s, %s, %s, %s", QRModePixelPass, QRModeCompact, QRModeCBOR, QRModeLink)
+	}
+
+	qrMaxChars, err := strconv.Atoi(envOr("QR_MAX_CHARS", "1800"))
+	if err != nil || qrMaxChars <= 0 {
+		return Config{}, fmt.Errorf("invalid QR_MAX_CHARS %q", getenv("QR_MAX_CHARS"))
+	}
+
+	qrModuleSize, err := strconv.Atoi(envOr("QR_MODULE_SIZE", "0"))
+	if err != nil || qrModuleSize < 0 || qrModuleSize > 40 {
+		return Config{}, fmt.Errorf("invalid QR_MODULE_SIZE %q", getenv("QR_MODULE_SIZE"))
+	}
+	qrQuietZone, err := strconv.Atoi(envOr("QR_QUIET_ZONE", "4"))
+	if err != nil || qrQuietZone < 0 || qrQuietZone > 20 {
+		return Config{}, fmt.Errorf("invalid QR_QUIET_ZONE %q", getenv("QR_QUIET_ZONE"))
+	}
+	qrErrorCorrection := strings.ToUpper(envOr("QR_ERROR_CORRECTION", "H"))
+	if _, ok := qrLevels[qrErrorCorrection]; !ok {
+		return Config{}, fmt.Errorf("QR_ERROR_CORRECTION must be L, M, Q or H")
+	}
+
+	piiMode := envOr("PII_MODE", PIIModePlain)
+	if piiMode != PIIModePlain && piiMode != PIIModeHashed {
+		return Config{}, fmt.Errorf("PII_MODE must be %s or %s", PIIModePlain, PIIModeHashed)
+	}
+
+	consentInCredential := getenv("CONSENT_IN_CREDENTIAL")
+	if consentInCredential != "" && consentInCredential != "evidence" && consentInCredential != "termsOfUse" {
+		return Config{}, fmt.Errorf("CONSENT_IN_CREDENTIAL must be evidence or termsOfUse")
+	}
+
+	vcVersion := envOr("VC_VERSION", vcdm1)
+	if vcVersion != vcdm1 && vcVersion != vcdm2 {
+		return Config{}, fmt.Errorf("VC_VERSION must be %s or %s", vcdm1, vcdm2)
+	}
+	signingMode := envOr("SIGNING_MODE", SigningModeAgent)
+	if signingMode != SigningModeAgent && signingMode != SigningModeLocal {
+		return Config{}, fmt.Errorf("SIGNING_MODE must be %s or %s", SigningModeAgent, SigningModeLocal)
+	}
+	demoMode := getenv("DEMO_MODE") == "true"
+	if demoMode && signingMode == SigningModeLocal {
+		return Config{}, fmt.Errorf("DEMO_MODE and SIGNING_MODE=%s cannot be combined", SigningModeLocal)
+	}
+	secureBoot := defaultSecureBoot
+	if v := getenv("SECURE_BOOT"); v != "" {
+		secureBoot = v == "true"
+	}
+	var validity time.Duration
+	if v := getenv("CREDENTIAL_VALIDITY"); v != "" {
+		if validity, err = time.ParseDuration(v); err != nil {
+			return Config{}, fmt.Errorf("invalid CREDENTIAL_VALIDITY: %v", err)
+		}
+	}
+	timezone, err := time.LoadLocation(envOr("TIMEZONE", "UTC"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid TIMEZONE, want an IANA zone such as Africa/Nairobi: %v", err)
+	}
+	var maxBackdate time.Duration
+	if v := getenv("MAX_BACKDATE"); v != "" {
+		if maxBackdate, err = time.ParseDuration(v); err != nil || maxBackdate < 0 {
+			return Config{}, fmt.Errorf("invalid MAX_BACKDATE %q, want a duration such as 8760h", v)
+		}
+	}
+
+	shareTTL, err := time.ParseDuration(envOr("SHARE_LINK_TTL", "72h"))
+	if err != nil || shareTTL <= 0 {
+		return Config{}, fmt.Errorf("invalid SHARE_LINK_TTL %q", getenv("SHARE_LINK_TTL"))
+	}
+
+	retention, err := parseRetention(getenv("RETENTION"))
+	if err != nil {
+		return Config{}, err
+	}
+	retentionInterval, err := time.ParseDuration(envOr("RETENTION_INTERVAL", "30m"))
+	if err != nil || retentionInterval <= 0 {
+		return Config{}, fmt.Errorf("invalid RETENTION_INTERVAL %q", getenv("RETENTION_INTERVAL"))
+	}
+	if getenv("LEADER_ELECTION") == "true" && !leaderElectionSupported {
+		return Config{}, fmt.Errorf("LEADER_ELECTION needs a Unix system")
+	}
+
+	smtpTLS := envOr("SMTP_TLS", "starttls")
+	if smtpTLS != "starttls" && smtpTLS != "tls" && smtpTLS != "none" {
+		return Config{}, fmt.Errorf("SMTP_TLS must be starttls, tls or none")
+	}
+	mailMaxAttempts, err := strconv.Atoi(envOr("MAIL_MAX_ATTEMPTS", "5"))
+	if err != nil || mailMaxAttempts < 1 {
+		return Config{}, fmt.Errorf("invalid MAIL_MAX_ATTEMPTS %q", getenv("MAIL_MAX_ATTEMPTS"))
+	}
+	if getenv("SMTP_HOST") != "" && getenv("SMTP_FROM") == "" {
+		return Config{}, fmt.Errorf("SMTP_FROM is required with SMTP_HOST")
+	}
+
+	agentRetries, err := strconv.Atoi(envOr("AGENT_RETRIES", "2"))
+	if err != nil || agentRetries < 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_RETRIES %q", getenv("AGENT_RETRIES"))
+	}
+	agentRetryBackoff, err := time.ParseDuration(envOr("AGENT_RETRY_BACKOFF", "250ms"))
+	if err != nil || agentRetryBackoff <= 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_RETRY_BACKOFF %q", getenv("AGENT_RETRY_BACKOFF"))
+	}
+	agentBreakerThreshold, err := strconv.Atoi(envOr("AGENT_BREAKER_THRESHOLD", "5"))
+	if err != nil || agentBreakerThreshold < 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_BREAKER_THRESHOLD %q", getenv("AGENT_BREAKER_THRESHOLD"))
+	}
+	agentBreakerCooldown, err := time.ParseDuration(envOr("AGENT_BREAKER_COOLDOWN", "30s"))
+	if err != nil || agentBreakerCooldown <= 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_BREAKER_COOLDOWN %q", getenv("AGENT_BREAKER_COOLDOWN"))
+	}
+	agentTimeout, err := time.ParseDuration(envOr("AGENT_TIMEOUT", "30s"))
+	if err != nil || agentTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_TIMEOUT %q", getenv("AGENT_TIMEOUT"))
+	}
+	agentTimeouts, err := parseAgentTimeouts(getenv("AGENT_TIMEOUTS"))
+	if err != nil {
+		return Config{}, err
+	}
+	if (getenv("AGENT_CLIENT_CERT") == "") != (getenv("AGENT_CLIENT_KEY") == "") {
+		return Config{}, fmt.Errorf("AGENT_CLIENT_CERT and AGENT_CLIENT_KEY must be set together")
+	}
+	if v := getenv("AGENT_TOKEN_URL"); v != "" {
+		if u, err := url.Parse(v); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return Config{}, fmt.Errorf("invalid AGENT_TOKEN_URL %q", v)
+		}
+		if getenv("AGENT_CLIENT_ID") == "" || getenv("AGENT_CLIENT_SECRET") == "" {
+			return Config{}, fmt.Errorf("AGENT_TOKEN_URL needs AGENT_CLIENT_ID and AGENT_CLIENT_SECRET")
+		}
+	}
+	agentURLs, err := parseAgentURLs(envOr("AGENT_URL", "http://host.docker.internal:8004"))
+	if err != nil {
+		return Config{}, fmt.Errorf("AGENT_URL: %v", err)
+	}
+	agentBalance := envOr("AGENT_BALANCE", AgentBalanceFailover)
+	if agentBalance != AgentBalanceFailover && agentBalance != AgentBalanceRoundRobin {
+		return Config{}, fmt.Errorf("invalid AGENT_BALANCE %q", agentBalance)
+	}
+	agentHealthInterval, err := time.ParseDuration(envOr("AGENT_HEALTH_INTERVAL", "10s"))
+	if err != nil || agentHealthInterval < 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_HEALTH_INTERVAL %q", getenv("AGENT_HEALTH_INTERVAL"))
+	}
+	agentBackend := envOr("AGENT_BACKEND", AgentBackendCredo)
+	if _, ok := issuerBackends[agentBackend]; !ok {
+		return Config{}, fmt.Errorf("invalid AGENT_BACKEND %q", agentBackend)
+	}
+	agentMaxResponse, err := strconv.ParseInt(envOr("AGENT_MAX_RESPONSE", strconv.Itoa(defaultAgentMaxResponse)), 10, 64)
+	if err != nil || agentMaxResponse < 1 {
+		return Config{}, fmt.Errorf("invalid AGENT_MAX_RESPONSE %q", getenv("AGENT_MAX_RESPONSE"))
+	}
+	agentMaxConcurrent, err := strconv.Atoi(envOr("AGENT_MAX_CONCURRENT", "8"))
+	if err != nil || agentMaxConcurrent < 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_MAX_CONCURRENT %q", getenv("AGENT_MAX_CONCURRENT"))
+	}
+	agentQueueTimeout, err := time.ParseDuration(envOr("AGENT_QUEUE_TIMEOUT", "30s"))
+	if err != nil || agentQueueTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_QUEUE_TIMEOUT %q", getenv("AGENT_QUEUE_TIMEOUT"))
+	}
+	agentContract := envOr("AGENT_CONTRACT", AgentContractOff)
+	if agentContract != AgentContractOff && agentContract != AgentContractLog && agentContract != AgentContractStrict {
+		return Config{}, fmt.Errorf("invalid AGENT_CONTRACT %q", agentContract)
+	}
+	if agentContract != AgentContractOff && agentBackend != AgentBackendCredo {
+		return Config{}, fmt.Errorf("AGENT_CONTRACT describes the CREDEBL agent's API; AGENT_BACKEND is %s", agentBackend)
+	}
+	agentTokenTTL, err := time.ParseDuration(envOr("AGENT_TOKEN_TTL", "10m"))
+	if err != nil || agentTokenTTL < 0 {
+		return Config{}, fmt.Errorf("invalid AGENT_TOKEN_TTL %q", getenv("AGENT_TOKEN_TTL"))
+	}
+
+	smsMaxAttempts, err := strconv.Atoi(envOr("SMS_MAX_ATTEMPTS", "3"))
+	if err != nil || smsMaxAttempts < 1 {
+		return Config{}, fmt.Errorf("invalid SMS_MAX_ATTEMPTS %q", getenv("SMS_MAX_ATTEMPTS"))
+	}
+
+	claimTTL, err := time.ParseDuration(envOr("CLAIM_CODE_TTL", "720h"))
+	if err != nil || claimTTL <= 0 {
+		return Config{}, fmt.Errorf("invalid CLAIM_CODE_TTL %q", getenv("CLAIM_CODE_TTL"))
+	}
+
+	magicLinkTTL, err := time.ParseDuration(envOr("MAGIC_LINK_TTL", "15m"))
+	if err != nil || magicLinkTTL <= 0 || magicLinkTTL > 24*time.Hour {
+		return Config{}, fmt.Errorf("invalid MAGIC_LINK_TTL %q", getenv("MAGIC_LINK_TTL"))
+	}
+	magicLinkLimit, err := strconv.Atoi(envOr("MAGIC_LINK_RATE_LIMIT", "5"))
+	if err != nil || magicLinkLimit < 1 {
+		return Config{}, fmt.Errorf("invalid MAGIC_LINK_RATE_LIMIT %q", getenv("MAGIC_LINK_RATE_LIMIT"))
+	}
+
+	approvalTTL, err := time.ParseDuration(envOr("APPROVAL_TTL", defaultApprovalTTL.String()))
+	if err != nil || approvalTTL <= 0 {
+		return Config{}, fmt.Errorf("invalid APPROVAL_TTL %q", getenv("APPROVAL_TTL"))
+	}
+
+	trustedProxies, err := parseTrustedProxies(getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	verifyRequestTTL, err := time.ParseDuration(envOr("VERIFY_REQUEST_TTL", "168h"))
+	if err != nil || verifyRequestTTL <= 0 {
+		return Config{}, fmt.Errorf("invalid VERIFY_REQUEST_TTL %q", getenv("VERIFY_REQUEST_TTL"))
+	}
+
+	embedOrigins, err := parseEmbedOrigins(getenv("EMBED_ORIGINS"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	trustRefresh, err := time.ParseDuration(envOr("TRUST_REGISTRY_REFRESH", "6h"))
+	if err != nil || trustRefresh < time.Minute {
+		return Config{}, fmt.Errorf("invalid TRUST_REGISTRY_REFRESH %q", getenv("TRUST_REGISTRY_REFRESH"))
+	}
+
+	didCacheTTL, err := time.ParseDuration(envOr("DID_CACHE_TTL", "1h"))
+	if err != nil || didCacheTTL < 0 {
+		return Config{}, fmt.Errorf("invalid DID_CACHE_TTL %q", getenv("DID_CACHE_TTL"))
+	}
+	didCacheMaxStale, err := time.ParseDuration(envOr("DID_CACHE_MAX_STALE", "168h"))
+	if err != nil || didCacheMaxStale < didCacheTTL {
+		return Config{}, fmt.Errorf("invalid DID_CACHE_MAX_STALE %q, want at least DID_CACHE_TTL", getenv("DID_CACHE_MAX_STALE"))
+	}
+	didCacheMaxEntries, err := strconv.Atoi(envOr("DID_CACHE_MAX_ENTRIES", "1000"))
+	if err != nil || didCacheMaxEntries < 1 {
+		return Config{}, fmt.Errorf("invalid DID_CACHE_MAX_ENTRIES %q", getenv("DID_CACHE_MAX_ENTRIES"))
+	}
+	uniResolverTimeout, err := time.ParseDuration(envOr("UNIVERSAL_RESOLVER_TIMEOUT", "10s"))
+	if err != nil || uniResolverTimeout <= 0 {
+		return Config{}, fmt.Errorf("invalid UNIVERSAL_RESOLVER_TIMEOUT %q", getenv("UNIVERSAL_RESOLVER_TIMEOUT"))
+	}
+
+	polygonNetwork := envOr("POLYGON_NETWORK", "testnet")
+	if polygonNetwork != "testnet" && polygonNetwork != "mainnet" {
+		return Config{}, fmt.Errorf("POLYGON_NETWORK must be testnet or mainnet")
+	}
+	anchorMode := envOr("ANCHOR_MODE", AnchorOff)
+	if anchorMode != AnchorOff && anchorMode != AnchorEach && anchorMode != AnchorBatch {
+		return Config{}, fmt.Errorf("ANCHOR_MODE must be off, each or batch")
+	}
+	anchorInterval, err := time.ParseDuration(envOr("ANCHOR_INTERVAL", "1h"))
+	if err != nil || anchorInterval <= 0 {
+		return Config{}, fmt.Errorf("invalid ANCHOR_INTERVAL %q", getenv("ANCHOR_INTERVAL"))
+	}
+	anchorDID := envOr("ANCHOR_DID", getenv("ISSUER_DID"))
+	if anchorMode != AnchorOff {
+		if _, _, err := polygonDIDAccount(anchorDID); err != nil {
+			return Config{}, fmt.Errorf("ANCHOR_MODE=%s needs ANCHOR_DID, a did:polygon DID in the agent's wallet: %v", anchorMode, err)
+		}
+	}
+	artifactWorkers, err := strconv.Atoi(envOr("ARTIFACT_WORKERS", "4"))
+	if err != nil || artifactWorkers < 0 {
+		return Config{}, fmt.Errorf("invalid ARTIFACT_WORKERS %q", getenv("ARTIFACT_WORKERS"))
+	}
+	pdfRenderer := envOr("PDF_RENDERER", PDFRendererHTML)
+	if pdfRenderer != PDFRendererHTML && pdfRenderer != PDFRendererBuiltin {
+		return Config{}, fmt.Errorf("PDF_RENDERER must be html or builtin")
+	}
+	orcidSection := envOr("ORCID_SECTION", "education")
+	if orcidSection != "education" && orcidSection != "qualification" {
+		return Config{}, fmt.Errorf("ORCID_SECTION must be education or qualification")
+	}
+	if getenv("ORCID_CLIENT_ID") != "" && (getenv("ORCID_CLIENT_SECRET") == "" || getenv("ORCID_ORGANIZATION_CITY") == "" || len(getenv("ORCID_ORGANIZATION_COUNTRY")) != 2) {
+		return Config{}, fmt.Errorf("ORCID_CLIENT_ID needs ORCID_CLIENT_SECRET, ORCID_ORGANIZATION_CITY and a two-letter ORCID_ORGANIZATION_COUNTRY")
+	}
+	if getenv("ORCID_CLIENT_ID") != "" && holderKeyHex == "" && !secureBoot {
+		return Config{}, fmt.Errorf("ORCID_CLIENT_ID needs HOLDER_KEY (or SECURE_BOOT) to seal the students' ORCID tokens")
+	}
+	listenSocketMode, err := strconv.ParseUint(envOr("LISTEN_SOCKET_MODE", "0660"), 8, 32)
+	if err != nil || listenSocketMode > 0o777 {
+		return Config{}, fmt.Errorf("invalid LISTEN_SOCKET_MODE %q, want octal permissions such as 0660", getenv("LISTEN_SOCKET_MODE"))
+	}
+	logFormat := envOr("LOG_FORMAT", "text")
+	if logFormat != "text" && logFormat != "json" {
+		return Config{}, fmt.Errorf("invalid LOG_FORMAT %q, want text or json", logFormat)
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envOr("LOG_LEVEL", "info"))); err != nil {
+		return Config{}, fmt.Errorf("invalid LOG_LEVEL %q, want debug, info, warn or error", getenv("LOG_LEVEL"))
+	}
+	otlpHeaders, err := parseOTLPHeaders(getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return Config{}, err
+	}
+	basePath, err := parseBasePath(getenv("BASE_PATH"))
+	if err != nil {
+		return Config{}, err
+	}
+	theme, err := loadTheme(envOr("ISSUER_NAME", "Testa Edu"))
+	if err != nil {
+		return Config{}, err
+	}
+
+	// There is no default issuer: a DID is the institution's own, set here
+	// or provisioned by the DID setup wizard. The demo agent has one.
+	issuerDID := getenv("ISSUER_DID")
+	if issuerDID == "" && demoMode {
+		issuerDID = mockagent.KeyDID
+	}
+
+	cfg := Config{
+		Port:       envOr("PORT", "3002"),
+		AgentURL:   agentURLs[0],
+		APIKey:     getenv("API_KEY"),
+		IssuerDID:  issuerDID,
+		IssuerDIDs: issuerDIDs,
+		NodeBin:    envOr("NODE_BIN", "node"),
+		ScriptsDir: envOr("SCRIPTS_DIR", "./scripts"),
+
+		AgentRetries:          agentRetries,
+		AgentRetryBackoff:     agentRetryBackoff,
+		AgentBreakerThreshold: agentBreakerThreshold,
+		AgentBreakerCooldown:  agentBreakerCooldown,
+		AgentTimeout:          agentTimeout,
+		AgentTimeouts:         agentTimeouts,
+		AgentTokenTTL:         agentTokenTTL,
+		AgentCAFile:           getenv("AGENT_CA_FILE"),
+		AgentClientCert:       getenv("AGENT_CLIENT_CERT"),
+		AgentClientKey:        getenv("AGENT_CLIENT_KEY"),
+		AgentTLSInsecure:      getenv("AGENT_TLS_INSECURE") == "true",
+		AgentTokenURL:         getenv("AGENT_TOKEN_URL"),
+		AgentClientID:         getenv("AGENT_CLIENT_ID"),
+		AgentClientSecret:     getenv("AGENT_CLIENT_SECRET"),
+		AgentTokenScope:       getenv("AGENT_TOKEN_SCOPE"),
+		AgentURLs:             agentURLs,
+		AgentBalance:          agentBalance,
+		AgentHealthInterval:   agentHealthInterval,
+		AgentBackend:          agentBackend,
+		AgentMaxResponse:      agentMaxResponse,
+		AgentMaxConcurrent:    agentMaxConcurrent,
+		AgentQueueTimeout:     agentQueueTimeout,
+		AgentContract:         agentContract,
+		AgentContractFile:     envOr("AGENT_CONTRACT_FILE", filepath.Join("templates-data", "agent-openapi.json")),
+
+		PublicURL:        envOr("PUBLIC_URL", "http://localhost:"+envOr("PORT", "3002")+basePath),
+		SchemaFile:       envOr("SCHEMA_FILE", filepath.Join("templates-data", "education-credential.schema.json")),
+		FieldMappingFile: getenv("FIELD_MAPPING_FILE"),
+		BasePath:         basePath,
+		ProofType:        proofType,
+		ProofTypes:       proofTypes,
+		SDClaims:         splitList(envOr("SD_CLAIMS", "gpa,studentId")),
+		QRMode:           qrMode,
+		QRMaxChars:       qrMaxChars,
+
+		QRErrorCorrection: qrErrorCorrection,
+		QRModuleSize:      qrModuleSize,
+		QRQuietZone:       qrQuietZone,
+		QRLogo:            getenv("QR_LOGO"),
+
+		VCVersion:          vcVersion,
+		CredentialValidity: validity,
+		Timezone:           timezone,
+		MaxBackdate:        maxBackdate,
+
+		GradingScalesFile:   getenv("GRADING_SCALES_FILE"),
+		DefaultGradingScale: envOr("DEFAULT_GRADING_SCALE", "4.0"),
+		GPANormalize:        getenv("GPA_NORMALIZE") == "true",
+
+		IssuerName:                envOr("ISSUER_NAME", "Testa Edu"),
+		IssuerLogoURL:             getenv("ISSUER_LOGO_URL"),
+		OID4VCICredentialEndpoint: getenv("OID4VCI_CREDENTIAL_ENDPOINT"),
+
+		SigningMode:         signingMode,
+		DemoMode:            demoMode,
+		SecureBoot:          secureBoot,
+		SigningKey:          getenv("SIGNING_KEY"),
+		SigningKeyURI:       getenv("SIGNING_KEY_URI"),
+		VerificationMethods: verificationMethods,
+
+		AnonCredsIssuerID:  getenv("ANONCREDS_ISSUER_ID"),
+		AnonCredsCredDefID: getenv("ANONCREDS_CRED_DEF_ID"),
+
+		MdocSignerKey:  getenv("MDOC_SIGNER_KEY"),
+		MdocSignerCert: getenv("MDOC_SIGNER_CERT"),
+
+		PassTypeID:   getenv("PASS_TYPE_ID"),
+		PassTeamID:   getenv("PASS_TEAM_ID"),
+		PassCert:     getenv("PASS_CERT"),
+		PassKey:      getenv("PASS_KEY"),
+		PassWWDRCert: getenv("PASS_WWDR_CERT"),
+
+		GoogleWalletIssuerID:       getenv("GOOGLE_WALLET_ISSUER_ID"),
+		GoogleWalletClassID:        envOr("GOOGLE_WALLET_CLASS_ID", "testa_edu_credential"),
+		GoogleWalletServiceAccount: getenv("GOOGLE_WALLET_SERVICE_ACCOUNT"),
+
+		LinkedInOrganizationID: getenv("LINKEDIN_ORGANIZATION_ID"),
+
+		OrcidClientID:            getenv("ORCID_CLIENT_ID"),
+		OrcidClientSecret:        getenv("ORCID_CLIENT_SECRET"),
+		OrcidURL:                 strings.TrimRight(envOr("ORCID_URL", "https://orcid.org"), "/"),
+		OrcidAPIURL:              strings.TrimRight(envOr("ORCID_API_URL", "https://api.orcid.org/v3.0"), "/"),
+		OrcidSection:             orcidSection,
+		OrcidOrganizationCity:    getenv("ORCID_ORGANIZATION_CITY"),
+		OrcidOrganizationCountry: strings.ToUpper(getenv("ORCID_ORGANIZATION_COUNTRY")),
+		OrcidOrganizationROR:     getenv("ORCID_ORGANIZATION_ROR"),
+
+		ContextCacheDir: envOr("CONTEXT_CACHE_DIR", "./contexts-cache"),
+		ContextPinsFile: envOr("CONTEXT_PINS_FILE", filepath.Join("templates-data", "context-pins.json")),
+		DataDir:         envOr("DATA_DIR", "./data"),
+		ArchiveDir:      envOr("ARCHIVE_DIR", filepath.Join(envOr("DATA_DIR", "./data"), "archive")),
+		ShareLinkTTL:    shareTTL,
+		ClaimCodeTTL:    claimTTL,
+		LinkSigningKey:  getenv("LINK_SIGNING_KEY"),
+		HolderKey:       holderKeyHex,
+		BackupKey:       backupKeyHex,
+		PIIMode:         piiMode,
+		StaffAPIToken:   getenv("STAFF_API_TOKEN"),
+		MetricsToken:    getenv("METRICS_TOKEN"),
+		DebugEndpoints:  getenv("DEBUG_ENDPOINTS") == "true",
+
+		ConsentTermsURL:     getenv("CONSENT_TERMS_URL"),
+		ConsentInCredential: consentInCredential,
+
+		Retention:         retention,
+		RetentionInterval: retentionInterval,
+		RetentionDryRun:   getenv("RETENTION_DRY_RUN") == "true",
+		LeaderElection:    getenv("LEADER_ELECTION") == "true",
+
+		LogRedactFields: splitList(envOr("LOG_REDACT_FIELDS", defaultLogRedactFields)),
+		LogFormat:       logFormat,
+		LogLevel:        level,
+
+		OTLPEndpoint:     getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		OTLPHeaders:      otlpHeaders,
+		TraceServiceName: envOr("OTEL_SERVICE_NAME", "testa-edu-ui"),
+
+		SentryDSN:         getenv("SENTRY_DSN"),
+		SentryEnvironment: getenv("SENTRY_ENVIRONMENT"),
+		SentryRelease:     getenv("SENTRY_RELEASE"),
+
+		SMTPHost:        getenv("SMTP_HOST"),
+		SMTPPort:        envOr("SMTP_PORT", "587"),
+		SMTPUsername:    getenv("SMTP_USERNAME"),
+		SMTPPassword:    getenv("SMTP_PASSWORD"),
+		SMTPFrom:        getenv("SMTP_FROM"),
+		SMTPTLS:         smtpTLS,
+		MailMaxAttempts: mailMaxAttempts,
+
+		EmailTemplatesDir: envOr("EMAIL_TEMPLATES_DIR", filepath.Join("templates-data", "email")),
+
+		PDFRenderer:             pdfRenderer,
+		CertificateTemplatesDir: envOr("CERTIFICATE_TEMPLATES_DIR", filepath.Join("templates-data", "certificates")),
+		PDFSigningCert:          getenv("PDF_SIGNING_CERT"),
+		PDFSigningKey:           getenv("PDF_SIGNING_KEY"),
+		PDFArchival:             getenv("PDF_ARCHIVAL") == "true",
+		PDFFont:                 envOr("PDF_FONT", "/usr/share/fonts/noto/NotoSans-Regular.ttf"),
+		PDFFontBold:             envOr("PDF_FONT_BOLD", "/usr/share/fonts/noto/NotoSans-Bold.ttf"),
+		ArtifactWorkers:         artifactWorkers,
+
+		MagicLinkTTL:   magicLinkTTL,
+		MagicLinkLimit: magicLinkLimit,
+
+		TrustedProxies: trustedProxies,
+
+		VerifyRequestTTL: verifyRequestTTL,
+
+		EmbedOrigins: embedOrigins,
+
+		TrustRegistry:        splitList(getenv("TRUST_REGISTRY")),
+		TrustRegistryRefresh: trustRefresh,
+
+		DIDCacheTTL:              didCacheTTL,
+		DIDCacheMaxStale:         didCacheMaxStale,
+		DIDCacheMaxEntries:       didCacheMaxEntries,
+		UniversalResolverURL:     strings.TrimRight(getenv("UNIVERSAL_RESOLVER_URL"), "/"),
+		UniversalResolverTimeout: uniResolverTimeout,
+
+		PolygonNetwork:       polygonNetwork,
+		PolygonWalletAddress: getenv("POLYGON_WALLET_ADDRESS"),
+		PolygonTestnetRPCURL: envOr("POLYGON_TESTNET_RPC_URL", "https://rpc-amoy.polygon.technology"),
+		PolygonMainnetRPCURL: envOr("POLYGON_MAINNET_RPC_URL", "https://polygon-rpc.com"),
+
+		AnchorMode:     anchorMode,
+		AnchorInterval: anchorInterval,
+		AnchorDID:      anchorDID,
+
+		TSAURL: getenv("TSA_URL"),
+
+		ListenSocket:     getenv("LISTEN_SOCKET"),
+		ListenSocketMode: fs.FileMode(listenSocketMode),
+
+		TLSAddr:    getenv("TLS_ADDR"),
+		TLSCertDir: envOr("TLS_CERT_DIR", filepath.Join(envOr("DATA_DIR", "./data"), "certs")),
+
+		TLSCertFile:      getenv("TLS_CERT_FILE"),
+		TLSKeyFile:       getenv("TLS_KEY_FILE"),
+		TLSRedirect:      getenv("TLS_REDIRECT") == "true",
+		ACME:             getenv("TLS_ACME") == "true",
+		ACMEEmail:        getenv("TLS_ACME_EMAIL"),
+		ACMEDirectoryURL: envOr("TLS_ACME_DIRECTORY_URL", letsEncryptDirectory),
+
+		Theme: theme,
+
+		DefaultLanguage: envOr("DEFAULT_LANGUAGE", "en"),
+
+		SMSProvider:      getenv("SMS_PROVIDER"),
+		SMSMaxAttempts:   smsMaxAttempts,
+		SMSAPIURL:        getenv("SMS_API_URL"),
+		TwilioAccountSID: getenv("TWILIO_ACCOUNT_SID"),
+		TwilioAuthToken:  getenv("TWILIO_AUTH_TOKEN"),
+		TwilioFrom:       getenv("TWILIO_FROM"),
+		ATUsername:       getenv("AT_USERNAME"),
+		ATAPIKey:         getenv("AT_API_KEY"),
+		ATSenderID:       getenv("AT_SENDER_ID"),
+
+		SlackWebhooks: splitList(getenv("SLACK_WEBHOOK_URLS")),
+		TeamsWebhooks: splitList(getenv("TEAMS_WEBHOOK_URLS")),
+
+		SignHooks: splitList(getenv("SIGN_HOOKS")),
+
+		LMSConfigFile: getenv("LMS_CONFIG_FILE"),
+
+		MakerChecker: getenv("MAKER_CHECKER") == "true",
+		ApprovalTTL:  approvalTTL,
+
+		MaintenanceMode: getenv("MAINTENANCE_MODE") == "true",
+	}
+	if problems := validateConfig(cfg); len(problems) > 0 {
+		return Config{}, &ConfigError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+func envOr(key, fallback string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// splitList parses a comma-separated config value, dropping empty entries.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"os"
+	"testing"
+)
+
+// The service reads its templates, static files, locales and scripts from
+// the working directory, the service's root (/app in the image); the tests
+// run from there too.
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
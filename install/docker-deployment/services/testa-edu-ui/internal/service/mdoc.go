@@ -0,0 +1,350 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// ISO/IEC 18013-5 mdoc encoding of the education credential. The mdoc is
+// signed in-process by a document signer key (MDOC_SIGNER_KEY/CERT) since
+// the agent does not issue mdocs.
+const (
+	mdocDocType   = "org.testa.edu.credential.1"
+	mdocNamespace = "org.testa.edu.credential.1"
+)
+
+// COSE header and key parameters (RFC 9052/9053).
+const (
+	coseAlgES256  = -7
+	coseHeaderAlg = 1
+	coseHeaderX5  = 33
+)
+
+type mdocSigner struct {
+	key  *ecdsa.PrivateKey
+	cert []byte // DER
+}
+
+var (
+	mdocSignerOnce sync.Once
+	mdocSignerVal  *mdocSigner
+	mdocSignerErr  error
+)
+
+// loadMdocSigner loads the document signer from MDOC_SIGNER_KEY and
+// MDOC_SIGNER_CERT (PEM). Without them an ephemeral self-signed signer is
+// generated, which is only useful for development.
+func loadMdocSigner() (*mdocSigner, error) {
+	mdocSignerOnce.Do(func() {
+		if config.MdocSignerKey == "" {
+			slog.Warn("MDOC_SIGNER_KEY not set; generating an ephemeral mdoc document signer")
+			mdocSignerVal, mdocSignerErr = ephemeralMdocSigner()
+			return
+		}
+		mdocSignerVal, mdocSignerErr = readMdocSigner(config.MdocSignerKey, config.MdocSignerCert)
+	})
+	return mdocSignerVal, mdocSignerErr
+}
+
+func readMdocSigner(keyPath, certPath string) (*mdocSigner, error) {
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading mdoc signer key: %w", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("mdoc signer key is not PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("parsing mdoc signer key: %w", err)
+		}
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("mdoc signer key must be an ECDSA P-256 key")
+	}
+
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading mdoc signer certificate: %w", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("mdoc signer certificate is not PEM")
+	}
+	return &mdocSigner{key: key, cert: certBlock.Bytes}, nil
+}
+
+func ephemeralMdocSigner() (*mdocSigner, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "Testa Edu mdoc document signer (ephemeral)"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &mdocSigner{key: key, cert: der}, nil
+}
+
+// coseKey encodes an EC2 P-256 public key as a COSE_Key map.
+func coseKey(pub *ecdsa.PublicKey) map[int]interface{} {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	pub.X.FillBytes(x)
+	pub.Y.FillBytes(y)
+	return map[int]interface{}{1: 2, -1: 1, -2: x, -3: y} // kty EC2, crv P-256
+}
+
+type issuerSignedItem struct {
+	DigestID          uint        `cbor:"digestID"`
+	Random            []byte      `cbor:"random"`
+	ElementIdentifier string      `cbor:"elementIdentifier"`
+	ElementValue      interface{} `cbor:"elementValue"`
+}
+
+// tdate is a CBOR tag 0 date-time string.
+func tdate(t time.Time) cbor.Tag {
+	return cbor.Tag{Number: 0, Content: t.UTC().Format(time.RFC3339)}
+}
+
+// buildMdoc returns the CBOR-encoded mdoc Document (docType +
+// IssuerSigned) and the device-engagement string for the holder's QR.
+func buildMdoc(form CredentialForm) ([]byte, string, error) {
+	signer, err := loadMdocSigner()
+	if err != nil {
+		return nil, "", err
+	}
+	enc, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Without a holder-supplied device key the mdoc carries a fresh one,
+	// so device authentication is not meaningful for these documents.
+	deviceKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	elements := []struct {
+		id    string
+		value string
+	}{
+		{"name", form.StudentName},
+		{"alumni_of", form.Institution},
+		{"degree", form.Degree},
+		{"field_of_study", form.FieldOfStudy},
+		{"enrollment_date", form.EnrollmentDate},
+		{"graduation_date", form.GraduationDate},
+		{"student_id", form.StudentID},
+		{"gpa", form.GPA},
+		{"honors", form.Honors},
+	}
+
+	var items []cbor.Tag
+	digests := map[uint][]byte{}
+	for _, el := range elements {
+		if el.value == "" {
+			continue
+		}
+		random := make([]byte, 16)
+		rand.Read(random)
+		id := uint(len(items))
+		itemBytes, err := enc.Marshal(issuerSignedItem{
+			DigestID:          id,
+			Random:            random,
+			ElementIdentifier: el.id,
+			ElementValue:      el.value,
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("encoding %s: %w", el.id, err)
+		}
+		tagged := cbor.Tag{Number: 24, Content: itemBytes}
+		taggedBytes, err := enc.Marshal(tagged)
+		if err != nil {
+			return nil, "", err
+		}
+		sum := sha256.Sum256(taggedBytes)
+		digests[id] = sum[:]
+		items = append(items, tagged)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	validUntil := now.AddDate(10, 0, 0)
+	if liveConfig().CredentialValidity > 0 {
+		validUntil = now.Add(liveConfig().CredentialValidity)
+	}
+	mso := map[string]interface{}{
+		"version":         "1.0",
+		"digestAlgorithm": "SHA-256",
+		"valueDigests":    map[string]interface{}{mdocNamespace: digests},
+		"deviceKeyInfo":   map[string]interface{}{"deviceKey": coseKey(&deviceKey.PublicKey)},
+		"docType":         mdocDocType,
+		"validityInfo": map[string]interface{}{
+			"signed":     tdate(now),
+			"validFrom":  tdate(now),
+			"validUntil": tdate(validUntil),
+		},
+	}
+	msoBytes, err := enc.Marshal(mso)
+	if err != nil {
+		return nil, "", fmt.Errorf("encoding MSO: %w", err)
+	}
+	payload, err := enc.Marshal(cbor.Tag{Number: 24, Content: msoBytes})
+	if err != nil {
+		return nil, "", err
+	}
+
+	issuerAuth, err := coseSign1(enc, signer, payload)
+	if err != nil {
+		return nil, "", err
+	}
+
+	doc, err := enc.Marshal(map[string]interface{}{
+		"docType": mdocDocType,
+		"issuerSigned": map[string]interface{}{
+			"nameSpaces": map[string]interface{}{mdocNamespace: items},
+			"issuerAuth": issuerAuth,
+		},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("encoding mdoc: %w", err)
+	}
+
+	engagement, err := deviceEngagement(enc, &deviceKey.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return doc, engagement, nil
+}
+
+// coseSign1 signs payload as an untagged COSE_Sign1 array with the
+// document signer certificate in the x5chain header.
+func coseSign1(enc cbor.EncMode, signer *mdocSigner, payload []byte) ([]interface{}, error) {
+	protected, err := enc.Marshal(map[int]interface{}{coseHeaderAlg: coseAlgES256})
+	if err != nil {
+		return nil, err
+	}
+	toBeSigned, err := enc.Marshal([]interface{}{"Signature1", protected, []byte{}, payload})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(toBeSigned)
+	r, s, err := ecdsa.Sign(rand.Reader, signer.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("signing MSO: %w", err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+
+	return []interface{}{
+		protected,
+		map[int]interface{}{coseHeaderX5: signer.cert},
+		payload,
+		sig,
+	}, nil
+}
+
+// deviceEngagement encodes the ISO 18013-5 DeviceEngagement structure as
+// the "mdoc:" URI carried in the engagement QR code.
+func deviceEngagement(enc cbor.EncMode, deviceKey *ecdsa.PublicKey) (string, error) {
+	keyBytes, err := enc.Marshal(coseKey(deviceKey))
+	if err != nil {
+		return "", err
+	}
+	de, err := enc.Marshal(map[int]interface{}{
+		0: "1.0",
+		1: []interface{}{1, cbor.Tag{Number: 24, Content: keyBytes}}, // Security: cipher suite 1, EDeviceKeyBytes
+	})
+	if err != nil {
+		return "", err
+	}
+	return "mdoc:" + base64.RawURLEncoding.EncodeToString(de), nil
+}
+
+func buildMdocExport(sess *Session) ([]byte, error) {
+	form, err := credentialForm(sess)
+	if err != nil {
+		return nil, err
+	}
+	doc, engagement, err := buildMdoc(form)
+	if err != nil {
+		return nil, err
+	}
+	sessionsMu.Lock()
+	sess.MdocEngagement = engagement
+	sessionsMu.Unlock()
+	return doc, nil
+}
+
+// handleDownloadMdocEngagement renders the device-engagement QR for the
+// session's mdoc, building the mdoc first if needed.
+func handleDownloadMdocEngagement(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+
+	sessionsMu.RLock()
+	engagement := sess.MdocEngagement
+	sessionsMu.RUnlock()
+	if engagement == "" {
+		form, err := credentialForm(sess)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "mdoc error", "err", err)
+			http.Error(w, "Failed to build mdoc", http.StatusInternalServerError)
+			return
+		}
+		doc, eng, err := buildMdoc(form)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "mdoc error", "err", err)
+			http.Error(w, "Failed to build mdoc", http.StatusInternalServerError)
+			return
+		}
+		sessionsMu.Lock()
+		if sess.Exports == nil {
+			sess.Exports = make(map[string][]byte)
+		}
+		sess.Exports["mdoc"] = doc
+		sess.MdocEngagement = eng
+		sessionsMu.Unlock()
+		engagement = eng
+	}
+
+	png, err := renderQRPNG(engagement, 512, QROptions{ErrorCorrection: "M", QuietZone: liveConfig().QRQuietZone})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "mdoc QR error", "err", err)
+		http.Error(w, "Failed to render QR", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-mdoc-engagement.png\"")
+	w.Write(png)
+}
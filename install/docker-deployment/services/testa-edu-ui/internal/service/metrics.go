@@ -0,0 +1,233 @@
+package service
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics. GET /metrics serves the service's metrics in the Prometheus
+// text format: issuances and wizard step failures, agent call latency,
+// QR and PDF generation time, and, read as they are scraped, the active
+// sessions and the depth of the delivery queue. With METRICS_TOKEN set,
+// scrapes must send it as a bearer token.
+//
+// The metrics are kept here rather than with a client library: counters
+// and histograms by label values are all the service needs.
+
+// metricBuckets are the histogram buckets, in seconds.
+var metricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+var (
+	issuancesTotal = newCounterVec("testa_issuances_total",
+		"Credentials signed, by format.", "format")
+	stepFailuresTotal = newCounterVec("testa_step_failures_total",
+		"Issuance wizard steps that failed, by step.", "step")
+	agentRequestSeconds = newHistogramVec("testa_agent_request_duration_seconds",
+		"Agent call latency, by endpoint and response code.", "endpoint", "method", "code")
+	qrGenerationSeconds = newHistogramVec("testa_qr_generation_duration_seconds",
+		"Time to generate a credential's QR code.")
+	pdfGenerationSeconds = newHistogramVec("testa_pdf_generation_duration_seconds",
+		"Time to generate a certificate PDF.")
+	_ = newGaugeFunc("testa_active_sessions",
+		"Issuance sessions held in memory.", func() float64 {
+			sessionsMu.RLock()
+			defer sessionsMu.RUnlock()
+			return float64(len(sessions))
+		})
+	_ = newGaugeFunc("testa_delivery_queue_depth",
+		"Email and SMS sends waiting for the delivery worker.", func() float64 {
+			return float64(len(deliveryQueue))
+		})
+)
+
+// metric is a metric family written on a scrape.
+type metric interface {
+	write(w io.Writer)
+}
+
+// metrics are the registered metrics, in the order they are written.
+var metrics []metric
+
+// counterVec is a counter for each combination of label values.
+type counterVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	c := &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+	metrics = append(metrics, c)
+	return c
+}
+
+// inc adds one to the counter of the label values.
+func (c *counterVec) inc(values ...string) {
+	c.mu.Lock()
+	c.values[metricKey(values)]++
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, metricLabels(c.labels, key, ""), formatMetric(c.values[key]))
+	}
+}
+
+// histogramVec is a histogram for each combination of label values.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	series     map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	h := &histogramVec{name: name, help: help, labels: labels, series: map[string]*histogram{}}
+	metrics = append(metrics, h)
+	return h
+}
+
+// observe records a duration for the label values.
+func (h *histogramVec) observe(d time.Duration, values ...string) {
+	v := d.Seconds()
+	key := metricKey(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogram{counts: make([]uint64, len(metricBuckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(metricBuckets, v); i < len(metricBuckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// since observes the time since start, for deferring.
+func (h *histogramVec) since(start time.Time, values ...string) {
+	h.observe(time.Since(start), values...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range metricBuckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, metricLabels(h.labels, key, formatMetric(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, metricLabels(h.labels, key, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, metricLabels(h.labels, key, ""), formatMetric(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, metricLabels(h.labels, key, ""), s.count)
+	}
+}
+
+// gaugeFunc is a gauge read when scraped.
+type gaugeFunc struct {
+	name, help string
+	value      func() float64
+}
+
+func newGaugeFunc(name, help string, value func() float64) *gaugeFunc {
+	g := &gaugeFunc{name: name, help: help, value: value}
+	metrics = append(metrics, g)
+	return g
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, g.help, g.name, g.name, formatMetric(g.value()))
+}
+
+// metricKey joins label values into a map key.
+func metricKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// metricLabels formats the label set of key, with le for a histogram
+// bucket.
+func metricLabels(names []string, key, le string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, v := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+`="`+escapeLabel(v)+`"`)
+		}
+	}
+	if le != "" {
+		pairs = append(pairs, `le="`+le+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatMetric(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricEndpoint is the endpoint label of an agent call: its path below
+// the agent's base path, with identifiers (DIDs and the like) replaced, so
+// the label has few values.
+func (a *AgentClient) metricEndpoint(path string) string {
+	segments := strings.Split(strings.TrimPrefix(path, a.basePath), "/")
+	for i, s := range segments {
+		if strings.Contains(s, ":") || strings.Contains(s, "%") || len(s) > 40 {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if config.MetricsToken != "" {
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.MetricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metrics {
+		m.write(w)
+	}
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+func TestMetricsFormat(t *testing.T) {
+	c := &counterVec{name: "c_total", help: "A counter.", labels: []string{"step"}, values: map[string]float64{}}
+	c.inc(`sign "x"`)
+	c.inc("qr")
+	c.inc("qr")
+	h := &histogramVec{name: "h_seconds", help: "A histogram.", series: map[string]*histogram{}}
+	h.observe(30 * time.Millisecond)
+	h.observe(time.Minute)
+
+	var b strings.Builder
+	c.write(&b)
+	h.write(&b)
+	for _, want := range []string{
+		"# TYPE c_total counter\n",
+		"c_total{step=\"qr\"} 2\n",
+		`c_total{step="sign \"x\""} 1` + "\n",
+		"# TYPE h_seconds histogram\n",
+		"h_seconds_bucket{le=\"0.025\"} 0\n",
+		"h_seconds_bucket{le=\"0.05\"} 1\n",
+		"h_seconds_bucket{le=\"30\"} 1\n",
+		"h_seconds_bucket{le=\"+Inf\"} 2\n",
+		"h_seconds_sum 60.03\n",
+		"h_seconds_count 2\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("no %q in\n%s", want, b.String())
+		}
+	}
+}
+
+// TestMetrics checks an issuance, and a failed step, show in /metrics.
+func TestMetrics(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	issued := counterValue(issuancesTotal, FormatLDP)
+	failed := counterValue(stepFailuresTotal, "sign")
+
+	// No session: the step fails.
+	if resp, err := http.PostForm(srv.URL+"/step/sign", nil); err == nil {
+		resp.Body.Close()
+	}
+	client := e2eClient(t)
+	e2eStep(t, client, srv.URL+"/issue", url.Values{
+		"studentName": {"Jane Wanjiku"}, "institution": {"Testa University"},
+		"degree": {"Bachelor of Science"}, "consent_issue": {"on"}, "qrMode": {QRModeCompact},
+	}, "")
+	for _, step := range []string{"token", "sign", "verify", "qr"} {
+		e2eStep(t, client, srv.URL+"/step/"+step, nil, "step-success")
+	}
+	if got := counterValue(issuancesTotal, FormatLDP); got != issued+1 {
+		t.Errorf("issuances %v, want %v", got, issued+1)
+	}
+	if got := counterValue(stepFailuresTotal, "sign"); got != failed+1 {
+		t.Errorf("sign failures %v, want %v", got, failed+1)
+	}
+
+	page := string(e2eGet(t, client, srv.URL+"/metrics"))
+	for _, want := range []string{
+		`testa_agent_request_duration_seconds_count{endpoint="/agent/credential/sign",method="POST",code="200"}`,
+		"testa_qr_generation_duration_seconds_count ",
+		"# TYPE testa_active_sessions gauge\n",
+		"testa_delivery_queue_depth 0\n",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("no %q in /metrics", want)
+		}
+	}
+
+	config.MetricsToken = "metrics-token-0123456789"
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("scrape without the token: HTTP %d", resp.StatusCode)
+	}
+	req, _ := http.NewRequest("GET", srv.URL+"/metrics", nil)
+	req.Header.Set("Authorization", "Bearer "+config.MetricsToken)
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("scrape with the token: HTTP %d", resp.StatusCode)
+	}
+}
+
+func counterValue(c *counterVec, values ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[metricKey(values)]
+}
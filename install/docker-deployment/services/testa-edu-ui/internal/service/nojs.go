@@ -0,0 +1,55 @@
+package service
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// Progressive enhancement. The issuance wizard is written for htmx, which
+// posts each step and swaps the fragment it gets into the page, but it
+// works without scripts too, as on campus kiosks that block them: its
+// forms post normally as well, and the same handlers answer a request htmx
+// did not send (no HX-Request header) with a whole page around the
+// fragment. On those pages a step that would load the next by itself
+// offers a Continue button instead, and the results of the final step's
+// forms (share links, claim codes, deliveries) lead back to it.
+
+// resultFragments are the fragments the final step's forms answer with.
+var resultFragments = map[string]bool{"share-link": true, "claim-code": true, "delivery-status": true}
+
+// renderFragment renders a fragment of the wizard: as is for htmx, and
+// within a page for a browser posting the forms itself.
+func renderFragment(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	if r.Header.Get("HX-Request") != "" {
+		if err := pages(r).ExecuteTemplate(w, name, data); err != nil {
+			slog.ErrorContext(r.Context(), "template error", "err", err)
+		}
+		return
+	}
+	if m, ok := data.(map[string]interface{}); ok {
+		m["Page"] = true
+	}
+	var buf bytes.Buffer
+	if err := pages(r).ExecuteTemplate(&buf, name, data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+		return
+	}
+	page := map[string]interface{}{
+		"Content": template.HTML(buf.String()),
+		"Card":    strings.HasPrefix(name, "step-") || resultFragments[name],
+		"Back":    resultFragments[name],
+	}
+	if sess := getSession(r); sess != nil && sess.TenantID != "" {
+		page["Theme"] = themeFor(sess.TenantID)
+	} else if id := hostTenant(r); id != "" {
+		page["Theme"] = themeFor(id)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if err := pages(r).ExecuteTemplate(w, "wizard-page", page); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestWizardWithoutScripts checks the wizard can be walked by posting its
+// forms, page by page, and that htmx still gets fragments.
+func TestWizardWithoutScripts(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	client := e2eClient(t)
+	post := func(path string, form url.Values, htmx bool) string {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || strings.Contains(string(body), "step-error") {
+			t.Fatalf("POST %s: HTTP %d: %s", path, resp.StatusCode, body)
+		}
+		return string(body)
+	}
+	issue := url.Values{
+		"studentName": {"Jane Wanjiku"}, "institution": {"Testa University"}, "degree": {"Bachelor of Science"},
+		"consent_issue": {"on"}, "consent_store": {"on"}, "qrMode": {QRModeCompact},
+	}
+
+	if page := post("/issue", issue, true); strings.Contains(page, "<html") || !strings.Contains(page, `hx-trigger="load"`) {
+		t.Errorf("htmx got a page: %s", page)
+	}
+
+	page := post("/issue", issue, false)
+	for _, next := range []string{"token", "sign", "verify", "qr"} {
+		if !strings.Contains(page, "<html") || !strings.Contains(page, `action="/step/`+next+`"`) {
+			t.Fatalf("no Continue to %s: %s", next, page)
+		}
+		page = post("/step/"+next, nil, false)
+	}
+	if !strings.Contains(page, "<html") || !strings.Contains(page, "/download/qr.png") {
+		t.Fatalf("final step: %s", page)
+	}
+
+	page = post("/claim-code", nil, false)
+	if !strings.Contains(page, `class="claim-code"`) || !strings.Contains(page, "Back to the credential") {
+		t.Errorf("claim code page: %s", page)
+	}
+}
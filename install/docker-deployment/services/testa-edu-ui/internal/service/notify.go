@@ -0,0 +1,160 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Operational notifications for registrar teams. Issuance and revocation
+// events are posted to the Slack and Microsoft Teams incoming webhooks in
+// SLACK_WEBHOOK_URLS and TEAMS_WEBHOOK_URLS (comma-separated). Messages
+// name the qualification and institution but never the student. Posting is
+// asynchronous and retried briefly; a failed webhook is only logged.
+
+const (
+	EventIssued  = "issued"
+	EventRevoked = "revoked"
+
+	notifyAttempts = 3
+)
+
+type NotifyEvent struct {
+	Kind         string
+	Institution  string
+	Degree       string
+	Format       string
+	ProofType    string
+	CredentialID string
+	At           time.Time
+}
+
+var notifyHTTP = &http.Client{Timeout: 10 * time.Second}
+
+func (e NotifyEvent) title() string {
+	switch e.Kind {
+	case EventIssued:
+		return "Credential issued"
+	case EventRevoked:
+		return "Credential revoked"
+	}
+	return "Credential " + e.Kind
+}
+
+// facts are the event details shown in both card formats.
+func (e NotifyEvent) facts() [][2]string {
+	facts := [][2]string{
+		{"Institution", e.Institution},
+		{"Qualification", e.Degree},
+		{"Format", e.Format},
+	}
+	if e.ProofType != "" {
+		facts = append(facts, [2]string{"Proof", e.ProofType})
+	}
+	if e.CredentialID != "" {
+		facts = append(facts, [2]string{"Credential ID", e.CredentialID})
+	}
+	return append(facts, [2]string{"Time", e.At.UTC().Format(time.RFC3339)})
+}
+
+func slackPayload(e NotifyEvent) map[string]interface{} {
+	var fields []map[string]string
+	for _, f := range e.facts() {
+		fields = append(fields, map[string]string{"type": "mrkdwn", "text": fmt.Sprintf("*%s*\n%s", f[0], f[1])})
+	}
+	return map[string]interface{}{
+		"text": fmt.Sprintf("%s: %s, %s", e.title(), e.Degree, e.Institution),
+		"blocks": []map[string]interface{}{
+			{"type": "header", "text": map[string]string{"type": "plain_text", "text": e.title()}},
+			{"type": "section", "fields": fields},
+		},
+	}
+}
+
+// teamsPayload wraps an Adaptive Card in the message envelope that Teams
+// incoming webhooks and Workflows both accept.
+func teamsPayload(e NotifyEvent) map[string]interface{} {
+	var facts []map[string]string
+	for _, f := range e.facts() {
+		facts = append(facts, map[string]string{"title": f[0], "value": f[1]})
+	}
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]interface{}{
+					{"type": "TextBlock", "text": e.title(), "weight": "Bolder", "size": "Medium"},
+					{"type": "FactSet", "facts": facts},
+				},
+			},
+		}},
+	}
+}
+
+// notify posts the event to every configured webhook in the background.
+func notify(e NotifyEvent) {
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	for _, u := range config.SlackWebhooks {
+		go postWebhook("slack", u, slackPayload(e))
+	}
+	for _, u := range config.TeamsWebhooks {
+		go postWebhook("teams", u, teamsPayload(e))
+	}
+}
+
+func postWebhook(kind, url string, payload interface{}) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("webhook", "kind", kind, "err", err)
+		return
+	}
+	for attempt := 1; ; attempt++ {
+		err = sendWebhook(url, body)
+		if err == nil || attempt == notifyAttempts {
+			break
+		}
+		time.Sleep(time.Duration(attempt) * 2 * time.Second)
+	}
+	if err != nil {
+		slog.Error("webhook", "kind", kind, "err", err)
+	}
+}
+
+func sendWebhook(url string, body []byte) error {
+	resp, err := notifyHTTP.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// notifyIssued reports a newly signed credential.
+func notifyIssued(sess *Session) {
+	sessionsMu.RLock()
+	e := NotifyEvent{
+		Kind:         EventIssued,
+		Institution:  sess.Form.Institution,
+		Degree:       sess.Form.Degree,
+		Format:       sess.Format,
+		ProofType:    sess.ProofType,
+		CredentialID: sess.CredentialID,
+	}
+	sessionsMu.RUnlock()
+	if sess.Format == FormatAnonCreds {
+		e.ProofType = ""
+	}
+	notify(e)
+}
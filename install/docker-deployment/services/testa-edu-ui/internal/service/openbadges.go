@@ -0,0 +1,131 @@
+package service
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+)
+
+// Open Badges 3.0 contexts. 3.0.3 is defined on top of VCDM 2.0; the
+// original 3.0 context targets VCDM 1.1.
+const (
+	openBadgesContextV1 = "https://purl.imsglobal.org/spec/ob/v3p0/context.json"
+	openBadgesContextV2 = "https://purl.imsglobal.org/spec/ob/v3p0/context-3.0.3.json"
+)
+
+// buildOpenBadge wraps the education achievement as an Open Badges 3.0
+// OpenBadgeCredential with an AchievementSubject, so it can be imported into
+// badge backpacks and LMS platforms.
+func buildOpenBadge(form CredentialForm, issuerDID string) map[string]interface{} {
+	achievement := map[string]interface{}{
+		"id":              "urn:uuid:" + newUUID(),
+		"type":            []string{"Achievement"},
+		"achievementType": "Degree",
+		"name":            form.Degree,
+		"description":     achievementDescription(form),
+		"criteria": map[string]interface{}{
+			"narrative": fmt.Sprintf("Awarded by %s on completion of the %s programme.", form.Institution, form.Degree),
+		},
+	}
+	if form.FieldOfStudy != "" {
+		achievement["fieldOfStudy"] = form.FieldOfStudy
+	}
+
+	subject := map[string]interface{}{
+		"id":          studentDID(form),
+		"type":        []string{"AchievementSubject"},
+		"achievement": achievement,
+	}
+	if form.StudentID != "" {
+		subject["identifier"] = []map[string]interface{}{{
+			"type":         "IdentityObject",
+			"identityHash": form.StudentID,
+			"identityType": "studentId",
+			"hashed":       false,
+		}}
+	}
+	if form.GraduationDate != "" {
+		subject["activityEndDate"] = form.GraduationDate + "T00:00:00Z"
+	}
+	if form.EnrollmentDate != "" {
+		subject["activityStartDate"] = form.EnrollmentDate + "T00:00:00Z"
+	}
+	if form.GPA != "" {
+		result := map[string]interface{}{
+			"type":  []string{"Result"},
+			"value": form.GPA,
+		}
+		if scale, ok := gradingScale(form.GPAScale); ok {
+			// The achievement describes the result's scale, so backpacks
+			// can tell a GPA out of 4 from one out of 5.
+			description := map[string]interface{}{
+				"id":   "urn:uuid:" + newUUID(),
+				"type": []string{"ResultDescription"},
+				"name": scale.Name,
+			}
+			if len(scale.Grades) > 0 {
+				allowed := make([]string, len(scale.Grades))
+				for i, g := range scale.Grades {
+					allowed[i] = g.Label
+				}
+				description["resultType"] = "LetterGrade"
+				description["allowedValue"] = allowed
+			} else {
+				description["resultType"] = "GradePointAverage"
+				if scale.Unit == "%" {
+					description["resultType"] = "Percent"
+				}
+				description["valueMin"] = formatGrade(scale.Min)
+				description["valueMax"] = formatGrade(scale.Max)
+			}
+			achievement["resultDescription"] = []map[string]interface{}{description}
+			result["resultDescription"] = description["id"]
+		}
+		subject["result"] = []map[string]interface{}{result}
+	}
+
+	return map[string]interface{}{
+		"id":   "urn:uuid:" + newUUID(),
+		"type": []string{"VerifiableCredential", "OpenBadgeCredential"},
+		"name": form.Degree,
+		"issuer": map[string]interface{}{
+			"id":   issuerDID,
+			"type": []string{"Profile"},
+			"name": form.Institution,
+		},
+		"credentialSubject": subject,
+	}
+}
+
+func achievementDescription(form CredentialForm) string {
+	parts := []string{form.Degree}
+	if form.FieldOfStudy != "" {
+		parts = append(parts, "in "+form.FieldOfStudy)
+	}
+	if form.Honors != "" {
+		parts = append(parts, "("+form.Honors+")")
+	}
+	return strings.Join(parts, " ") + " from " + form.Institution
+}
+
+func buildOpenBadgeExport(sess *Session) ([]byte, error) {
+	obContext := openBadgesContextV1
+	if config.VCVersion == vcdm2 {
+		obContext = openBadgesContextV2
+	}
+	form, err := credentialForm(sess)
+	if err != nil {
+		return nil, err
+	}
+	badge := buildOpenBadge(form, sess.IssuerDID)
+	return signExport(sess, signPayload(badge, sess.IssuerDID, sess.ProofType, issuedAt(form), obContext))
+}
+
+// newUUID returns a random (version 4) UUID string.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
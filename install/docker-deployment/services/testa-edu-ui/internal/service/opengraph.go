@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bytes"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenGraph share cards. Public verification pages (/verify/{id}) carry
+// OpenGraph and Twitter card tags, so a shared link previews with the
+// institution and credential title. The preview image,
+// /verify/{id}/card.png, is og-image.svg from CERTIFICATE_TEMPLATES_DIR
+// (overridable per institution like certificate.html) rasterized by
+// headless Chromium. Link previews are fetched and cached by third
+// parties, so cards never show the student's name, ID, dates or grades.
+
+const (
+	shareCardTemplate = "og-image.svg"
+	shareCardTTL      = time.Hour
+	maxShareCards     = 256
+)
+
+// ShareCardData is what og-image.svg can reference. Templates also have
+// the page functions t, date and datetime, and asset.
+type ShareCardData struct {
+	Theme        Theme
+	Logo         template.URL
+	Institution  string
+	Title        string // the theme's certificate title, translated
+	Degree       string
+	FieldOfStudy string
+	Lang         string
+}
+
+// OpenGraph is the link preview metadata of a page.
+type OpenGraph struct {
+	Title       string
+	Description string
+	URL         string
+	Image       string
+}
+
+type shareCard struct {
+	png     []byte
+	created time.Time
+}
+
+var (
+	shareCardsMu sync.Mutex
+	shareCards   = make(map[string]*shareCard) // by credential ID and language
+)
+
+// pageOpenGraph returns the page data's OpenGraph metadata, if any.
+func pageOpenGraph(data interface{}) *OpenGraph {
+	if m, ok := data.(map[string]interface{}); ok {
+		if og, ok := m["OpenGraph"].(*OpenGraph); ok {
+			return og
+		}
+	}
+	return nil
+}
+
+// shareCardFor returns the public details of a stored credential for its
+// share card, or false when it has none to show.
+func shareCardFor(id, lang string) (ShareCardData, *StoredCredential, bool) {
+	cred, ok := store.Get(id)
+	if !ok || !cred.ErasedAt.IsZero() || cred.Encrypted {
+		return ShareCardData{}, nil, false
+	}
+	subject, err := credentialSubjectOf(cred)
+	if err != nil {
+		return ShareCardData{}, nil, false
+	}
+	form := formFromSubject(subject)
+	theme := themeFor(cred.TenantID)
+	t := translator(lang)
+	return ShareCardData{
+		Theme:        theme,
+		Logo:         certificateLogo(theme),
+		Institution:  form.Institution,
+		Title:        t(theme.CertificateTitle),
+		Degree:       t(form.Degree),
+		FieldOfStudy: form.FieldOfStudy,
+		Lang:         lang,
+	}, cred, true
+}
+
+// credentialOpenGraph is the link preview of a credential's verification
+// page.
+func credentialOpenGraph(id, lang string) *OpenGraph {
+	card, cred, ok := shareCardFor(id, lang)
+	if !ok {
+		return nil
+	}
+	t := translator(lang)
+	issuer := card.Institution
+	if issuer == "" {
+		issuer = card.Theme.Name
+	}
+	page := verificationURL(cred.TenantID, id)
+	return &OpenGraph{
+		Title:       strings.TrimSpace(card.Degree + " · " + issuer),
+		Description: t("%s issued by %s. Open the link to verify it.", card.Title, issuer),
+		URL:         page,
+		Image:       page + "/card.png",
+	}
+}
+
+// renderShareCard renders a credential's share card as SVG.
+func renderShareCard(card ShareCardData, tenant string) ([]byte, error) {
+	funcs := languageFuncs(card.Lang)
+	funcs["asset"] = func(asset string) (template.URL, error) { return certificateAsset(tenant, asset) }
+	path := certificateTemplatePath(tenant, shareCardTemplate)
+	t, err := template.New(filepath.Base(path)).Funcs(funcs).ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, card); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// shareCardPNG returns a credential's share card, rendering it on first
+// request and caching it for shareCardTTL.
+func shareCardPNG(id, lang string) ([]byte, bool, error) {
+	key := id + "\x00" + lang
+	now := time.Now()
+	shareCardsMu.Lock()
+	if c, ok := shareCards[key]; ok && now.Sub(c.created) < shareCardTTL {
+		shareCardsMu.Unlock()
+		return c.png, true, nil
+	}
+	shareCardsMu.Unlock()
+
+	card, cred, ok := shareCardFor(id, lang)
+	if !ok {
+		return nil, false, nil
+	}
+	svg, err := renderShareCard(card, emailTenant(cred.TenantID, card.Institution))
+	if err != nil {
+		return nil, true, err
+	}
+	png, err := svgToPNG(svg)
+	if err != nil {
+		return nil, true, err
+	}
+
+	shareCardsMu.Lock()
+	defer shareCardsMu.Unlock()
+	shareCards[key] = &shareCard{png: png, created: now}
+	for len(shareCards) > maxShareCards {
+		oldest := ""
+		for k, c := range shareCards {
+			if oldest == "" || c.created.Before(shareCards[oldest].created) {
+				oldest = k
+			}
+		}
+		delete(shareCards, oldest)
+	}
+	return png, true, nil
+}
+
+// forgetShareCards drops the cached cards of a credential, after it is
+// erased.
+func forgetShareCards(id string) {
+	shareCardsMu.Lock()
+	defer shareCardsMu.Unlock()
+	for k := range shareCards {
+		if strings.HasPrefix(k, id+"\x00") {
+			delete(shareCards, k)
+		}
+	}
+}
+
+func handleShareCard(w http.ResponseWriter, r *http.Request) {
+	if !statusLimiter.Allow(clientIP(r)) {
+		w.Header().Set("Retry-After", "60")
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return
+	}
+	png, found, err := shareCardPNG(r.PathValue("id"), requestLanguage(r))
+	if !found {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "share card error", "err", err)
+		http.Error(w, "Failed to render share card", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	w.Write(png)
+}
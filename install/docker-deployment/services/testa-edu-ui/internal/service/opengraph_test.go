@@ -0,0 +1,33 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"html/template"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// TestShareCardTemplate checks the shipped share card template renders to
+// well-formed SVG.
+func TestShareCardTemplate(t *testing.T) {
+	useCatalogs(t)
+	path := filepath.Join("templates-data", "certificates", shareCardTemplate)
+	tmpl, err := template.New(filepath.Base(path)).Funcs(languageFuncs("en")).ParseFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ShareCardData{Theme: defaultTheme, Institution: "A&B <University>", Title: "Verifiable Education Credential", Degree: "BSc"}); err != nil {
+		t.Fatal(err)
+	}
+	d := xml.NewDecoder(&buf)
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("not well-formed SVG: %v", err)
+		}
+	}
+}
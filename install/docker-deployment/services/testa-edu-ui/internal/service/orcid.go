@@ -0,0 +1,621 @@
+package service
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ORCID. A student with an ORCID iD can add their credential to their
+// ORCID record as an education (or, with ORCID_SECTION=qualification, a
+// qualification) entry. "Add to ORCID record" on the results page sends
+// them through ORCID's three-legged OAuth flow for the /activities/update
+// scope; the callback exchanges the code for a token and posts the entry
+// through the member API. The institution needs ORCID member API
+// credentials (ORCID_CLIENT_ID, ORCID_CLIENT_SECRET) with
+// <base URL>/orcid/callback registered as a redirect URI. ORCID_URL and
+// ORCID_API_URL point at the sandbox for testing.
+//
+// Tokens are kept in DATA_DIR/orcid.json, sealed under a key derived from
+// the holder key (HOLDER_KEY, or the per-install key under SECURE_BOOT),
+// which ORCID linking requires; a student adding a later credential is not
+// asked again. Tokens sealed under the link signing key by earlier releases
+// no longer open, and their students are asked to authorize again. Entries
+// are remembered by credential ID and updated rather than duplicated. A
+// token ORCID rejects is forgotten and the student is asked to authorize
+// again.
+
+const (
+	orcidScope       = "/activities/update"
+	orcidStateTTL    = 10 * time.Minute
+	orcidMediaType   = "application/vnd.orcid+json"
+	maxOrcidResponse = 1 << 20
+)
+
+var orcidHTTP = &http.Client{Timeout: 15 * time.Second}
+
+// OrcidToken is a student's stored authorization.
+type OrcidToken struct {
+	ORCID     string            `json:"orcid"`
+	Name      string            `json:"name,omitempty"`
+	SubjectID string            `json:"subjectId,omitempty"`
+	Sealed    []byte            `json:"sealed"` // orcidSecrets
+	ExpiresAt time.Time         `json:"expiresAt"`
+	CreatedAt time.Time         `json:"createdAt"`
+	PutCodes  map[string]string `json:"putCodes,omitempty"` // by credential ID
+}
+
+// orcidSecrets is the sealed part of an OrcidToken.
+type orcidSecrets struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+type OrcidStore struct {
+	path string
+
+	mu     sync.Mutex
+	tokens map[string]*OrcidToken // by ORCID iD
+}
+
+var orcid *OrcidStore
+
+// orcidPending is an authorization in progress, keyed by its OAuth state.
+type orcidPending struct {
+	sessionID string
+	created   time.Time
+}
+
+var (
+	orcidPendingMu sync.Mutex
+	orcidStates    = make(map[string]orcidPending)
+)
+
+func orcidEnabled() bool {
+	return config.OrcidClientID != ""
+}
+
+func NewOrcidStore(dataDir string) (*OrcidStore, error) {
+	s := &OrcidStore{
+		path:   filepath.Join(dataDir, "orcid.json"),
+		tokens: make(map[string]*OrcidToken),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.tokens); err != nil {
+			return nil, fmt.Errorf("parsing ORCID tokens: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading ORCID tokens: %w", err)
+	}
+	return s, nil
+}
+
+func (s *OrcidStore) saveLocked() error {
+	data, err := json.Marshal(s.tokens)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing ORCID tokens: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func orcidAEAD() (cipher.AEAD, error) {
+	key, err := sealKey("orcid-seal", "")
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealOrcidSecrets(orcidID string, secrets orcidSecrets) ([]byte, error) {
+	aead, err := orcidAEAD()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plain, []byte(orcidID)), nil
+}
+
+func openOrcidSecrets(tok *OrcidToken) (orcidSecrets, error) {
+	var secrets orcidSecrets
+	aead, err := orcidAEAD()
+	if err != nil {
+		return secrets, err
+	}
+	if len(tok.Sealed) < aead.NonceSize() {
+		return secrets, fmt.Errorf("sealed ORCID token too short")
+	}
+	n := aead.NonceSize()
+	plain, err := aead.Open(nil, tok.Sealed[:n], tok.Sealed[n:], []byte(tok.ORCID))
+	if err != nil {
+		return secrets, fmt.Errorf("opening ORCID token: %w", err)
+	}
+	return secrets, json.Unmarshal(plain, &secrets)
+}
+
+// Put stores a token response for a student, keeping the entries already
+// added under an earlier token.
+func (s *OrcidStore) Put(resp *orcidTokenResponse, subjectID string) error {
+	sealed, err := sealOrcidSecrets(resp.ORCID, orcidSecrets{AccessToken: resp.AccessToken, RefreshToken: resp.RefreshToken})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok := &OrcidToken{
+		ORCID:     resp.ORCID,
+		Name:      resp.Name,
+		SubjectID: subjectID,
+		Sealed:    sealed,
+		ExpiresAt: time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second),
+		CreatedAt: time.Now(),
+	}
+	if old, ok := s.tokens[resp.ORCID]; ok {
+		tok.PutCodes = old.PutCodes
+		if subjectID == "" {
+			tok.SubjectID = old.SubjectID
+		}
+	}
+	s.tokens[resp.ORCID] = tok
+	return s.saveLocked()
+}
+
+// ForSubject returns the ORCID iD a student has authorized, if any.
+func (s *OrcidStore) ForSubject(subjectID string) (string, bool) {
+	if subjectID == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, tok := range s.tokens {
+		if tok.SubjectID == subjectID {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// Get returns a copy of a stored token and its secrets.
+func (s *OrcidStore) Get(orcidID string) (OrcidToken, orcidSecrets, bool) {
+	s.mu.Lock()
+	tok, ok := s.tokens[orcidID]
+	var c OrcidToken
+	if ok {
+		c = *tok
+	}
+	s.mu.Unlock()
+	if !ok {
+		return OrcidToken{}, orcidSecrets{}, false
+	}
+	secrets, err := openOrcidSecrets(&c)
+	if err != nil {
+		slog.Error("ORCID token", "orcid", orcidID, "err", err)
+		return OrcidToken{}, orcidSecrets{}, false
+	}
+	return c, secrets, true
+}
+
+// SetPutCode records the entry made on a record for a credential.
+func (s *OrcidStore) SetPutCode(orcidID, credentialID, putCode string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, ok := s.tokens[orcidID]
+	if !ok {
+		return nil
+	}
+	if tok.PutCodes == nil {
+		tok.PutCodes = make(map[string]string)
+	}
+	tok.PutCodes[credentialID] = putCode
+	return s.saveLocked()
+}
+
+// Remove forgets a token ORCID no longer accepts.
+func (s *OrcidStore) Remove(orcidID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tokens[orcidID]; !ok {
+		return nil
+	}
+	delete(s.tokens, orcidID)
+	return s.saveLocked()
+}
+
+// EraseSubject deletes a student's tokens. Entries already on their ORCID
+// record are theirs and stay there.
+func (s *OrcidStore) EraseSubject(subjectID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, tok := range s.tokens {
+		if tok.SubjectID == subjectID {
+			delete(s.tokens, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// orcidTokenResponse is ORCID's OAuth token endpoint response.
+type orcidTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Scope        string `json:"scope"`
+	Name         string `json:"name"`
+	ORCID        string `json:"orcid"`
+}
+
+// orcidAPIError is an error response from ORCID.
+type orcidAPIError struct {
+	Status  int
+	Message string
+}
+
+func (e *orcidAPIError) Error() string {
+	return fmt.Sprintf("ORCID returned %d: %s", e.Status, e.Message)
+}
+
+// orcidUnauthorized reports whether ORCID rejected the token itself.
+func orcidUnauthorized(err error) bool {
+	var e *orcidAPIError
+	return errors.As(err, &e) && (e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden)
+}
+
+func readOrcidError(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxOrcidResponse))
+	var msg struct {
+		Developer string `json:"developer-message"`
+		User      string `json:"user-message"`
+		OAuth     string `json:"error_description"`
+	}
+	json.Unmarshal(body, &msg)
+	text := msg.Developer
+	if text == "" {
+		text = msg.OAuth
+	}
+	if text == "" {
+		text = msg.User
+	}
+	if text == "" {
+		text = strings.TrimSpace(string(body))
+	}
+	return &orcidAPIError{Status: resp.StatusCode, Message: text}
+}
+
+func orcidRedirectURI(tenantID string) string {
+	return tenantURL(tenantID) + "/orcid/callback"
+}
+
+// orcidTokenRequest posts to the OAuth token endpoint.
+func orcidTokenRequest(form url.Values) (*orcidTokenResponse, error) {
+	form.Set("client_id", config.OrcidClientID)
+	form.Set("client_secret", config.OrcidClientSecret)
+	req, err := http.NewRequest("POST", config.OrcidURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := orcidHTTP.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ORCID unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, readOrcidError(resp)
+	}
+	var tok orcidTokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxOrcidResponse)).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("parsing ORCID token: %w", err)
+	}
+	if tok.AccessToken == "" || tok.ORCID == "" {
+		return nil, fmt.Errorf("ORCID token response has no access token or iD")
+	}
+	return &tok, nil
+}
+
+// orcidAccessToken returns a usable access token for a stored iD,
+// refreshing it once it has expired.
+func orcidAccessToken(orcidID string) (string, bool, error) {
+	tok, secrets, ok := orcid.Get(orcidID)
+	if !ok {
+		return "", false, nil
+	}
+	if time.Now().Before(tok.ExpiresAt) {
+		return secrets.AccessToken, true, nil
+	}
+	if secrets.RefreshToken == "" {
+		return "", false, orcid.Remove(orcidID)
+	}
+	resp, err := orcidTokenRequest(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {secrets.RefreshToken},
+	})
+	if err != nil {
+		if orcidUnauthorized(err) {
+			return "", false, orcid.Remove(orcidID)
+		}
+		return "", true, err
+	}
+	if err := orcid.Put(resp, tok.SubjectID); err != nil {
+		return "", true, err
+	}
+	return resp.AccessToken, true, nil
+}
+
+// orcidDate is an ORCID fuzzy date from a form date (YYYY-MM-DD), or nil.
+func orcidDate(s string) map[string]interface{} {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil
+	}
+	value := func(v string) map[string]string { return map[string]string{"value": v} }
+	return map[string]interface{}{
+		"year":  value(t.Format("2006")),
+		"month": value(t.Format("01")),
+		"day":   value(t.Format("02")),
+	}
+}
+
+// orcidAffiliation is the education or qualification entry for a
+// credential. putCode is set when updating an earlier entry.
+func orcidAffiliation(form CredentialForm, theme Theme, verifyURL, putCode string) map[string]interface{} {
+	institution := form.Institution
+	if institution == "" {
+		institution = theme.Name
+	}
+	org := map[string]interface{}{
+		"name": institution,
+		"address": map[string]string{
+			"city":    config.OrcidOrganizationCity,
+			"country": config.OrcidOrganizationCountry,
+		},
+	}
+	if config.OrcidOrganizationROR != "" {
+		org["disambiguated-organization"] = map[string]string{
+			"disambiguated-organization-identifier": config.OrcidOrganizationROR,
+			"disambiguation-source":                 "ROR",
+		}
+	}
+	entry := map[string]interface{}{
+		"role-title":   form.Degree,
+		"organization": org,
+	}
+	if form.FieldOfStudy != "" {
+		entry["department-name"] = form.FieldOfStudy
+	}
+	if d := orcidDate(form.EnrollmentDate); d != nil {
+		entry["start-date"] = d
+	}
+	if d := orcidDate(form.GraduationDate); d != nil {
+		entry["end-date"] = d
+	}
+	if verifyURL != "" {
+		entry["url"] = map[string]string{"value": verifyURL}
+		entry["external-ids"] = map[string]interface{}{
+			"external-id": []map[string]interface{}{{
+				"external-id-type":         "uri",
+				"external-id-value":        verifyURL,
+				"external-id-url":          map[string]string{"value": verifyURL},
+				"external-id-relationship": "self",
+			}},
+		}
+	}
+	if putCode != "" {
+		entry["put-code"] = putCode
+	}
+	return entry
+}
+
+// pushOrcidAffiliation adds a session's credential to an ORCID record, or
+// updates the entry added for it before.
+func pushOrcidAffiliation(sess *Session, orcidID, accessToken string) error {
+	verifyURL, credID := certificateVerification(sess)
+	putCode := ""
+	if credID != "" {
+		tok, _, _ := orcid.Get(orcidID)
+		putCode = tok.PutCodes[credID]
+	}
+	body, err := json.Marshal(orcidAffiliation(sess.Form, themeFor(sess.TenantID), verifyURL, putCode))
+	if err != nil {
+		return err
+	}
+	endpoint := config.OrcidAPIURL + "/" + url.PathEscape(orcidID) + "/" + config.OrcidSection
+	method := "POST"
+	if putCode != "" {
+		endpoint += "/" + url.PathEscape(putCode)
+		method = "PUT"
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", orcidMediaType)
+	req.Header.Set("Accept", orcidMediaType)
+	resp, err := orcidHTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("ORCID unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound && putCode != "":
+		// The student deleted the entry on ORCID; add it again.
+		if err := orcid.SetPutCode(orcidID, credID, ""); err != nil {
+			return err
+		}
+		return pushOrcidAffiliation(sess, orcidID, accessToken)
+	case resp.StatusCode == http.StatusConflict:
+		// An entry with this verification URL is already on the record.
+		return nil
+	case resp.StatusCode >= 300:
+		return readOrcidError(resp)
+	}
+	if method == "POST" && credID != "" {
+		if loc := resp.Header.Get("Location"); loc != "" {
+			return orcid.SetPutCode(orcidID, credID, path.Base(loc))
+		}
+	}
+	return nil
+}
+
+// orcidAuthorizeURL starts the OAuth flow for a session.
+func orcidAuthorizeURL(sess *Session, sessionID string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	state := hex.EncodeToString(b)
+	now := time.Now()
+	orcidPendingMu.Lock()
+	for s, p := range orcidStates {
+		if now.Sub(p.created) > orcidStateTTL {
+			delete(orcidStates, s)
+		}
+	}
+	orcidStates[state] = orcidPending{sessionID: sessionID, created: now}
+	orcidPendingMu.Unlock()
+
+	q := url.Values{}
+	q.Set("client_id", config.OrcidClientID)
+	q.Set("response_type", "code")
+	q.Set("scope", orcidScope)
+	q.Set("redirect_uri", orcidRedirectURI(sess.TenantID))
+	q.Set("state", state)
+	return config.OrcidURL + "/oauth/authorize?" + q.Encode()
+}
+
+// takeOrcidState consumes an OAuth state issued to sessionID.
+func takeOrcidState(state, sessionID string) bool {
+	orcidPendingMu.Lock()
+	defer orcidPendingMu.Unlock()
+	p, ok := orcidStates[state]
+	if !ok {
+		return false
+	}
+	delete(orcidStates, state)
+	return p.sessionID == sessionID && time.Since(p.created) <= orcidStateTTL
+}
+
+// finishOrcid pushes the credential with a stored token and shows the
+// outcome. It reports false when the token is gone and the student must
+// authorize again.
+func finishOrcid(w http.ResponseWriter, r *http.Request, sess *Session, orcidID string) bool {
+	data := map[string]interface{}{"Theme": themeFor(sess.TenantID)}
+	token, ok, err := orcidAccessToken(orcidID)
+	if err == nil && !ok {
+		return false
+	}
+	if err == nil {
+		if err = pushOrcidAffiliation(sess, orcidID, token); orcidUnauthorized(err) {
+			if err := orcid.Remove(orcidID); err != nil {
+				slog.ErrorContext(r.Context(), "ORCID", "err", err)
+			}
+			return false
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ORCID update failed", "err", err)
+		data["Error"] = "Your credential could not be added to your ORCID record. Please try again later."
+	} else {
+		slog.InfoContext(r.Context(), "credential added to ORCID record", "orcid", orcidID)
+		data["ORCID"] = orcidID
+		data["RecordURL"] = config.OrcidURL + "/" + orcidID
+	}
+	renderOrcidPage(w, r, data)
+	return true
+}
+
+func handleOrcidConnect(w http.ResponseWriter, r *http.Request) {
+	if !orcidEnabled() {
+		http.Error(w, "ORCID is not configured", http.StatusNotFound)
+		return
+	}
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	if id, ok := orcid.ForSubject(sess.Form.SubjectDID); ok && finishOrcid(w, r, sess, id) {
+		return
+	}
+	cookie, _ := r.Cookie("sid")
+	http.Redirect(w, r, orcidAuthorizeURL(sess, cookie.Value), http.StatusFound)
+}
+
+func handleOrcidCallback(w http.ResponseWriter, r *http.Request) {
+	if !orcidEnabled() {
+		http.Error(w, "ORCID is not configured", http.StatusNotFound)
+		return
+	}
+	sess := getSession(r)
+	cookie, _ := r.Cookie("sid")
+	q := r.URL.Query()
+	if sess == nil || sess.SignedCredential == nil || !takeOrcidState(q.Get("state"), cookie.Value) {
+		http.Error(w, "This ORCID authorization has expired. Please start again from your credential.", http.StatusBadRequest)
+		return
+	}
+	data := map[string]interface{}{"Theme": themeFor(sess.TenantID)}
+	if e := q.Get("error"); e != "" {
+		slog.InfoContext(r.Context(), "ORCID authorization declined", "reason", e)
+		data["Error"] = "ORCID access was not granted, so nothing was added to your record."
+		renderOrcidPage(w, r, data)
+		return
+	}
+	resp, err := orcidTokenRequest(url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {q.Get("code")},
+		"redirect_uri": {orcidRedirectURI(sess.TenantID)},
+	})
+	if err == nil {
+		err = orcid.Put(resp, sess.Form.SubjectDID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "ORCID token exchange failed", "err", err)
+		data["Error"] = "Your credential could not be added to your ORCID record. Please try again later."
+		renderOrcidPage(w, r, data)
+		return
+	}
+	if !finishOrcid(w, r, sess, resp.ORCID) {
+		data["Error"] = "ORCID did not accept the authorization. Please try again."
+		renderOrcidPage(w, r, data)
+	}
+}
+
+func renderOrcidPage(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := pages(r).ExecuteTemplate(w, "orcid", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// useOrcid points the ORCID client at srv, with fresh token and
+// credential stores, for one test.
+func useOrcid(t *testing.T, srv *httptest.Server) {
+	t.Helper()
+	useKeys(t, bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32))
+	savedConfig, savedOrcid, savedStore, savedHTTP := config, orcid, store, orcidHTTP
+	t.Cleanup(func() { config, orcid, store, orcidHTTP = savedConfig, savedOrcid, savedStore, savedHTTP })
+	config.OrcidAPIURL = srv.URL
+	config.OrcidSection = "education"
+	config.OrcidOrganizationCity = "Nairobi"
+	config.OrcidOrganizationCountry = "KE"
+	orcidHTTP = srv.Client()
+	var err error
+	if orcid, err = NewOrcidStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if store, err = NewCredentialStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOrcidTokenSealing checks tokens are stored sealed under the holder
+// key, not the link signing key kept beside them, and found again by
+// subject.
+func TestOrcidTokenSealing(t *testing.T) {
+	link, holder := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	useKeys(t, link, nil)
+	dir := t.TempDir()
+	s, err := NewOrcidStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &orcidTokenResponse{AccessToken: "access-secret", RefreshToken: "refresh-secret", ExpiresIn: 3600, ORCID: "0000-0002-1825-0097"}
+	if err := s.Put(resp, "did:example:amina"); !errors.Is(err, errNoHolderKey) {
+		t.Fatalf("sealed without a holder key: %v", err)
+	}
+	useKeys(t, link, holder)
+	if err := s.Put(resp, "did:example:amina"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "orcid.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) {
+		t.Errorf("orcid.json holds a token in the clear: %s", data)
+	}
+
+	if s, err = NewOrcidStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	id, ok := s.ForSubject("did:example:amina")
+	if !ok || id != resp.ORCID {
+		t.Fatalf("ForSubject = %q, %v", id, ok)
+	}
+	useKeys(t, bytes.Repeat([]byte{3}, 32), holder)
+	_, secrets, ok := s.Get(id)
+	if !ok || secrets.AccessToken != "access-secret" || secrets.RefreshToken != "refresh-secret" {
+		t.Errorf("Get = %+v, %v", secrets, ok)
+	}
+	useKeys(t, link, bytes.Repeat([]byte{4}, 32))
+	if _, _, ok := s.Get(id); ok {
+		t.Error("the token opened under another holder key")
+	}
+	if n, err := s.EraseSubject("did:example:amina"); err != nil || n != 1 {
+		t.Errorf("EraseSubject = %d, %v", n, err)
+	}
+	if _, ok := s.ForSubject("did:example:amina"); ok {
+		t.Error("token survived erasure")
+	}
+}
+
+// TestOrcidPush checks a credential is posted to the record once and
+// updated in place after that, and that a rejected token is reported.
+func TestOrcidPush(t *testing.T) {
+	const orcidID = "0000-0002-1825-0097"
+	var requests []string
+	var last map[string]interface{}
+	reject := false
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if reject || r.Header.Get("Authorization") != "Bearer access-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"developer-message":"invalid token"}`))
+			return
+		}
+		last = nil
+		json.NewDecoder(r.Body).Decode(&last)
+		if r.Method == "POST" {
+			w.Header().Set("Location", "https://api.orcid.org/v3.0/"+orcidID+"/education/4242")
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+	useOrcid(t, srv)
+
+	if err := orcid.Put(&orcidTokenResponse{AccessToken: "access-token", ExpiresIn: 3600, ORCID: orcidID}, "did:example:amina"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(&StoredCredential{ID: "cred-1", Format: FormatLDP}); err != nil {
+		t.Fatal(err)
+	}
+	sess := &Session{
+		CredentialID: "cred-1",
+		Form: CredentialForm{
+			Institution:    "Testa University",
+			Degree:         "Bachelor of Science",
+			FieldOfStudy:   "Computer Science",
+			EnrollmentDate: "2021-09-06",
+			GraduationDate: "2025-07-01",
+			SubjectDID:     "did:example:amina",
+		},
+	}
+
+	if err := pushOrcidAffiliation(sess, orcidID, "access-token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pushOrcidAffiliation(sess, orcidID, "access-token"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"POST /" + orcidID + "/education", "PUT /" + orcidID + "/education/4242"}
+	if len(requests) != 2 || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+	if last["put-code"] != "4242" || last["role-title"] != "Bachelor of Science" || last["department-name"] != "Computer Science" {
+		t.Errorf("entry = %v", last)
+	}
+	end, _ := last["end-date"].(map[string]interface{})
+	if year, _ := end["year"].(map[string]interface{}); year["value"] != "2025" {
+		t.Errorf("end-date = %v", last["end-date"])
+	}
+
+	reject = true
+	if err := pushOrcidAffiliation(sess, orcidID, "access-token"); !orcidUnauthorized(err) {
+		t.Errorf("err = %v, want an unauthorized error", err)
+	}
+}
+
+// TestOrcidConfig checks ORCID linking needs a holder key to seal tokens.
+func TestOrcidConfig(t *testing.T) {
+	t.Setenv("SECURE_BOOT", "false")
+	t.Setenv("API_KEY", "agent-key-0123456789")
+	t.Setenv("ISSUER_DID", e2eIssuerDID)
+	t.Setenv("ORCID_CLIENT_ID", "APP-TESTA")
+	t.Setenv("ORCID_CLIENT_SECRET", "orcid-secret")
+	t.Setenv("ORCID_ORGANIZATION_CITY", "Nairobi")
+	t.Setenv("ORCID_ORGANIZATION_COUNTRY", "KE")
+	if _, err := parseConfig(); err == nil || !strings.Contains(err.Error(), "HOLDER_KEY") {
+		t.Errorf("without HOLDER_KEY: %v", err)
+	}
+	t.Setenv("HOLDER_KEY", strings.Repeat("ab", 32))
+	if _, err := parseConfig(); err != nil {
+		t.Errorf("with HOLDER_KEY: %v", err)
+	}
+}
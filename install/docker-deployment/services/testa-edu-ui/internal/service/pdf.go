@@ -0,0 +1,293 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// generatePDF renders a session's credential as a certificate in a
+// language (see i18n.go): from the institution's certificate template
+// (see certificates.go), or with the built-in layout. The certificate is
+// PDF/A for institutions that archive (see pdfa.go) and signed when PDF
+// signing is configured (see pdfsign.go).
+func generatePDF(sess *Session, lang string) ([]byte, error) {
+	defer pdfGenerationSeconds.since(time.Now())
+	var pdf []byte
+	var err error
+	archival := pdfArchival(sess.TenantID)
+	if liveConfig().PDFRenderer == PDFRendererHTML {
+		if pdf, err = templatePDF(sess, lang); err != nil {
+			slog.Warn("certificate template; using the built-in layout", "err", err)
+		}
+	}
+	if pdf == nil {
+		if pdf, err = builtinPDF(sess, lang, archival); err != nil {
+			return nil, err
+		}
+	}
+	title := translator(lang)(themeFor(sess.TenantID).CertificateTitle) + ": " + sess.Form.StudentName
+	return finishPDF(pdf, sess.TenantID, pdfaMeta{Title: title, CredentialID: sess.CredentialID, Created: time.Now()}, archival)
+}
+
+// finishPDF makes a rendered certificate document PDF/A, when archival,
+// and signs it, when PDF signing is configured.
+func finishPDF(pdf []byte, tenantID string, meta pdfaMeta, archival bool) ([]byte, error) {
+	var err error
+	if archival {
+		if pdf, err = archivePDF(pdf, meta); err != nil {
+			return nil, fmt.Errorf("PDF/A: %w", err)
+		}
+	}
+	if pdfSignerVal == nil {
+		return pdf, nil
+	}
+	return signPDF(pdf, "Issued by "+themeFor(tenantID).Name)
+}
+
+// certificateVerification returns the verification URL and credential ID
+// printed on a certificate. The credential is stored, if it is not yet and
+// the student consented, so that the URL works. Credentials encrypted for
+// their holder cannot be checked from a URL and get none.
+func certificateVerification(sess *Session) (verifyURL, id string) {
+	if sess.EncryptTo != "" {
+		return "", ""
+	}
+	sessionsMu.RLock()
+	id = sess.CredentialID
+	sessionsMu.RUnlock()
+	if id == "" && sess.Consent.Allows(ConsentScopeStore) {
+		var err error
+		if id, _, err = storeSessionCredential(sess); err != nil {
+			slog.Error("certificate verification URL", "err", err)
+			return "", ""
+		}
+	}
+	if cred, ok := store.Get(id); !ok || cred.Encrypted {
+		return "", ""
+	}
+	return verificationURL(sess.TenantID, id), id
+}
+
+// builtinPDF lays the certificate out with fpdf.
+func builtinPDF(sess *Session, lang string, archival bool) ([]byte, error) {
+	return builtinCertificates([]*Session{sess}, lang, archival)
+}
+
+// builtinCertificates lays certificates out with fpdf, one per page.
+func builtinCertificates(sessions []*Session, lang string, archival bool) ([]byte, error) {
+	pdf, err := layoutCertificates(sessions, lang, archival)
+	if err != nil {
+		return nil, err
+	}
+	return outputPDF(pdf)
+}
+
+// layoutCertificates lays certificates out in an fpdf document, one per
+// page, for adding to before output.
+func layoutCertificates(sessions []*Session, lang string, archival bool) (*fpdf.Fpdf, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+
+	// The core fonts are cp1252; catalog text and form values are UTF-8.
+	// PDF/A embeds Unicode TrueType fonts instead.
+	sans, mono := "Helvetica", "Courier"
+	enc := pdf.UnicodeTranslatorFromDescriptor("")
+	if archival {
+		regular, bold, err := pdfFonts()
+		if err != nil {
+			return nil, err
+		}
+		pdf.AddUTF8FontFromBytes("Sans", "", regular)
+		pdf.AddUTF8FontFromBytes("Sans", "B", bold)
+		sans, mono = "Sans", "Sans"
+		enc = func(s string) string { return s }
+	}
+	for i, sess := range sessions {
+		builtinPage(pdf, sess, lang, i, sans, mono, enc)
+	}
+	return pdf, nil
+}
+
+func outputPDF(pdf *fpdf.Fpdf) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("generating PDF: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// builtinPage adds the n-th page of a document, one session's
+// certificate, in the given fonts.
+func builtinPage(pdf *fpdf.Fpdf, sess *Session, lang string, n int, sans, mono string, enc func(string) string) {
+	pdf.SetAutoPageBreak(true, 20)
+	pdf.AddPage()
+	theme := themeFor(sess.TenantID)
+	t := translator(lang)
+	tr := func(msg string, args ...interface{}) string { return enc(t(msg, args...)) }
+
+	// Header bar
+	pdf.SetFillColor(theme.rgb())
+	pdf.Rect(0, 0, 210, 35, "F")
+	textX := 15.0
+	if logo, kind, err := theme.logoImage(); err == nil {
+		name := "logo-" + sess.TenantID
+		pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: kind}, bytes.NewReader(logo))
+		pdf.ImageOptions(name, 15, 7.5, 0, 20, false, fpdf.ImageOptions{ImageType: kind}, 0, "")
+		if pdf.Ok() {
+			textX = 15 + pdf.GetImageInfo(name).Width()*20/pdf.GetImageInfo(name).Height() + 6
+		} else {
+			pdf.ClearError()
+		}
+	} else if theme.LogoURL != defaultTheme.LogoURL {
+		slog.Error("PDF logo", "err", err)
+	}
+	pdf.SetTextColor(255, 255, 255)
+	pdf.SetFont(sans, "B", 20)
+	pdf.SetXY(textX, 10)
+	pdf.Cell(0, 10, enc(theme.Name))
+	pdf.SetFont(sans, "", 10)
+	pdf.SetXY(textX, 20)
+	pdf.Cell(0, 8, tr(theme.Tagline))
+
+	// Title
+	pdf.SetTextColor(31, 41, 55)
+	pdf.SetFont(sans, "B", 16)
+	pdf.SetXY(15, 45)
+	pdf.Cell(0, 10, tr(theme.CertificateTitle))
+
+	// Credential details
+	pdf.SetFont(sans, "", 10)
+	y := 60.0
+	if theme.CertificateText != "" {
+		pdf.SetXY(15, y)
+		pdf.MultiCell(180, 6, enc(theme.CertificateText), "", "L", false)
+		y = pdf.GetY() + 4
+	}
+
+	fields := []struct {
+		Label string
+		Value string
+	}{
+		{"Student Name", sess.Form.StudentName},
+		{"Institution", sess.Form.Institution},
+		{"Degree", t(sess.Form.Degree)},
+		{"Field of Study", sess.Form.FieldOfStudy},
+		{"Enrollment Date", localDate(lang, sess.Form.EnrollmentDate)},
+		{"Graduation Date", localDate(lang, sess.Form.GraduationDate)},
+		{"Student ID", sess.Form.StudentID},
+		{"GPA", gradeText(sess.Form)},
+		{"Honors", t(sess.Form.Honors)},
+	}
+
+	for _, f := range fields {
+		if f.Value == "" {
+			continue
+		}
+		pdf.SetFont(sans, "B", 10)
+		pdf.SetXY(15, y)
+		pdf.Cell(50, 7, tr(f.Label)+":")
+		pdf.SetFont(sans, "", 10)
+		pdf.SetXY(65, y)
+		pdf.Cell(0, 7, enc(f.Value))
+		y += 8
+	}
+
+	// Issuer DID
+	y += 4
+	pdf.SetDrawColor(200, 200, 200)
+	pdf.Line(15, y, 195, y)
+	y += 4
+	pdf.SetFont(sans, "B", 9)
+	pdf.SetXY(15, y)
+	pdf.Cell(50, 6, tr("Issuer DID")+":")
+	pdf.SetFont(mono, "", 7)
+	pdf.SetXY(65, y)
+	pdf.Cell(0, 6, sess.IssuerDID)
+	y += 8
+
+	pdf.SetFont(sans, "B", 9)
+	pdf.SetXY(15, y)
+	pdf.Cell(50, 6, tr("Issued")+":")
+	pdf.SetFont(sans, "", 9)
+	pdf.SetXY(65, y)
+	pdf.Cell(0, 6, enc(localTime(lang, dateTimeLayout, time.Now().UTC())))
+	y += 8
+
+	if sess.Verified {
+		pdf.SetFont(sans, "B", 9)
+		pdf.SetXY(15, y)
+		pdf.Cell(50, 6, tr("Verification")+":")
+		pdf.SetTextColor(5, 150, 105)
+		pdf.SetFont(sans, "B", 9)
+		pdf.SetXY(65, y)
+		pdf.Cell(0, 6, tr("PASSED"))
+		pdf.SetTextColor(31, 41, 55)
+		y += 8
+	}
+
+	// Verification: the QR code, with the URL and credential ID beside it
+	// for checking a printed copy by hand.
+	verifyURL, credentialID := certificateVerification(sess)
+	hasQR := sess.QR != nil && sess.QR.QRPngBase64 != ""
+	if hasQR || verifyURL != "" {
+		y += 6
+		pdf.SetDrawColor(200, 200, 200)
+		pdf.Line(15, y, 195, y)
+		y += 6
+
+		pdf.SetFont(sans, "B", 12)
+		pdf.SetXY(15, y)
+		pdf.Cell(0, 8, tr("Verification QR Code"))
+		y += 12
+
+		textX, textY := 15.0, y
+		if hasQR {
+			// Decode base64 PNG and register as image
+			pngData, err := base64.StdEncoding.DecodeString(sess.QR.QRPngBase64)
+			if err == nil {
+				name := fmt.Sprintf("qr-%d", n)
+				pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(pngData))
+				pdf.ImageOptions(name, 15, y, 70, 70, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+				textX = 92
+
+				pdf.SetFont(sans, "", 9)
+				pdf.SetTextColor(107, 114, 128)
+				pdf.SetXY(textX, textY)
+				pdf.Cell(0, 6, tr("Scan with Inji Verify"))
+				pdf.SetTextColor(31, 41, 55)
+				textY += 10
+			}
+		}
+		if verifyURL != "" {
+			pdf.SetFont(sans, "B", 9)
+			pdf.SetXY(textX, textY)
+			pdf.Cell(0, 6, tr("Verify online at")+":")
+			pdf.SetFont(mono, "", 8)
+			pdf.SetXY(textX, textY+6)
+			pdf.MultiCell(195-textX, 5, verifyURL, "", "L", false)
+			textY = pdf.GetY() + 4
+
+			pdf.SetFont(sans, "B", 9)
+			pdf.SetXY(textX, textY)
+			pdf.Cell(0, 6, tr("Credential ID")+":")
+			pdf.SetFont(mono, "", 11)
+			pdf.SetXY(textX, textY+6)
+			pdf.Cell(0, 7, credentialID)
+		}
+	}
+
+	// Footer, in the bottom margin: without turning off page breaks it
+	// would start a page of its own.
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.SetY(-15)
+	pdf.SetFont(sans, "", 7)
+	pdf.SetTextColor(156, 163, 175)
+	pdf.CellFormat(0, 10,
+		tr("Generated by %s Credential Issuance Portal | Powered by CREDEBL | %s",
+			theme.Name, localDate(lang, time.Now().UTC())),
+		"", 0, "C", false, 0, "")
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PDF/A. Institutions with archival mandates get PDF/A-2b certificates:
+// the whole deployment with PDF_ARCHIVAL=true, or a tenant created with
+// "pdfArchival": true. The built-in layout then embeds the TrueType fonts
+// PDF_FONT and PDF_FONT_BOLD instead of the standard PDF fonts (Chromium
+// embeds the fonts of certificate templates itself), and the document is
+// rewritten with the binary header comment, an sRGB output intent, a
+// trailer ID, and XMP metadata declaring PDF/A-2b that matches the Info
+// dictionary and carries the credential ID as dc:identifier.
+
+const pdfaProducer = "Testa Edu Credential Issuance Portal"
+
+// pdfArchival reports whether a tenant's certificates are PDF/A.
+func pdfArchival(tenantID string) bool {
+	if config.PDFArchival {
+		return true
+	}
+	t, ok := tenants.Get(tenantID)
+	return ok && t.PDFArchival
+}
+
+var (
+	pdfFontsOnce    sync.Once
+	pdfFontsRegular []byte
+	pdfFontsBold    []byte
+	pdfFontsErr     error
+)
+
+// pdfFonts loads the TrueType fonts the built-in layout embeds.
+func pdfFonts() (regular, bold []byte, err error) {
+	pdfFontsOnce.Do(func() {
+		if pdfFontsRegular, pdfFontsErr = os.ReadFile(config.PDFFont); pdfFontsErr != nil {
+			pdfFontsErr = fmt.Errorf("PDF_FONT: %w", pdfFontsErr)
+			return
+		}
+		if pdfFontsBold, pdfFontsErr = os.ReadFile(config.PDFFontBold); pdfFontsErr != nil {
+			pdfFontsErr = fmt.Errorf("PDF_FONT_BOLD: %w", pdfFontsErr)
+		}
+	})
+	return pdfFontsRegular, pdfFontsBold, pdfFontsErr
+}
+
+// pdfaMeta is the document metadata written to both the Info dictionary
+// and the XMP packet.
+type pdfaMeta struct {
+	Title        string
+	CredentialID string
+	Created      time.Time
+}
+
+// xrefEntries reads a single-revision cross-reference table into object
+// offsets by number; free entries are left out.
+func (f *pdfFile) xrefEntries() (map[int]pdfObject, map[int]int, error) {
+	if _, ok := pdfDictValue(f.trailer, "Prev"); ok {
+		return nil, nil, fmt.Errorf("incrementally updated PDFs are not supported")
+	}
+	section := string(f.data[f.startxref+len("xref"):])
+	section = section[:strings.Index(section, "trailer")]
+	fields := strings.Fields(section)
+	gens := map[int]pdfObject{}
+	offsets := map[int]int{}
+	for i := 0; i+1 < len(fields); {
+		start, err1 := strconv.Atoi(fields[i])
+		count, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || i+2+3*count > len(fields) {
+			return nil, nil, fmt.Errorf("malformed cross-reference table")
+		}
+		i += 2
+		for n := start; n < start+count; n, i = n+1, i+3 {
+			if fields[i+2] != "n" {
+				continue
+			}
+			off, _ := strconv.Atoi(fields[i])
+			gen, _ := strconv.Atoi(fields[i+1])
+			gens[n] = pdfObject{n, gen}
+			offsets[n] = off
+		}
+	}
+	return gens, offsets, nil
+}
+
+// archivePDF rewrites a PDF as PDF/A-2b.
+func archivePDF(data []byte, meta pdfaMeta) ([]byte, error) {
+	f, err := parsePDFFile(data)
+	if err != nil {
+		return nil, err
+	}
+	objects, offsets, err := f.xrefEntries()
+	if err != nil {
+		return nil, err
+	}
+	rootRef, ok := pdfDictValue(f.trailer, "Root")
+	if !ok {
+		return nil, fmt.Errorf("trailer has no /Root")
+	}
+	rootObj, _ := parseRef(rootRef)
+	root, err := f.object(rootObj)
+	if err != nil {
+		return nil, err
+	}
+	root = regexp.MustCompile(`/Metadata\s+\d+\s+\d+\s+R|/OutputIntents\s*\[[^\]]*\]`).ReplaceAllString(root, "")
+
+	// The header line is followed by a comment of binary bytes, shifting
+	// every object.
+	eol := bytes.IndexAny(data, "\r\n")
+	if eol < 0 {
+		return nil, fmt.Errorf("no header")
+	}
+	rest := bytes.TrimLeft(data[eol:], "\r\n")
+	var buf bytes.Buffer
+	buf.Write(data[:eol])
+	buf.WriteString("\n%\xE2\xE3\xCF\xD3\n")
+	delta := buf.Len() - (len(data) - len(rest))
+	buf.Write(rest[:f.startxref-(len(data)-len(rest))])
+	for n := range offsets {
+		offsets[n] += delta
+	}
+
+	next := f.size
+	newObj := func(body string) pdfObject {
+		o := pdfObject{next, 0}
+		next++
+		objects[o.num] = o
+		offsets[o.num] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", o.num, body)
+		return o
+	}
+	stream := func(dict string, content []byte) string {
+		return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(content), content)
+	}
+
+	xmp := pdfaXMP(meta)
+	metaObj := newObj(stream("/Type /Metadata /Subtype /XML", xmp))
+	iccObj := newObj(stream("/N 3", srgbProfile()))
+	intentObj := newObj(fmt.Sprintf("<< /Type /OutputIntent /S /GTS_PDFA1 /OutputConditionIdentifier (sRGB IEC61966-2.1) /Info (sRGB IEC61966-2.1) /DestOutputProfile %s >>", iccObj.ref()))
+	infoObj := newObj(fmt.Sprintf("<< /Title %s /Producer %s /CreationDate %s /ModDate %s >>",
+		pdfTextString(meta.Title), pdfTextString(pdfaProducer), pdfDate(meta.Created), pdfDate(meta.Created)))
+
+	offsets[rootObj.num] = buf.Len()
+	fmt.Fprintf(&buf, "%d %d obj\n<<%s /Metadata %s /OutputIntents [%s]>>\nendobj\n", rootObj.num, rootObj.gen, root, metaObj.ref(), intentObj.ref())
+
+	xrefAt := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", next)
+	for n := 1; n < next; n++ {
+		if o, ok := objects[n]; ok {
+			fmt.Fprintf(&buf, "%010d %05d n \n", offsets[n], o.gen)
+		} else {
+			buf.WriteString("0000000000 65535 f \n")
+		}
+	}
+	id := sha256.Sum256(data)
+	fileID := strings.ToUpper(hex.EncodeToString(id[:16]))
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root %s /Info %s /ID [<%s> <%s>] >>\nstartxref\n%d\n%%%%EOF\n",
+		next, rootObj.ref(), infoObj.ref(), fileID, fileID, xrefAt)
+	return buf.Bytes(), nil
+}
+
+// pdfaXMP is the XMP packet of a PDF/A-2b document.
+func pdfaXMP(meta pdfaMeta) []byte {
+	esc := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	date := meta.Created.UTC().Format("2006-01-02T15:04:05Z")
+	var b strings.Builder
+	b.WriteString("<?xpacket begin=\"\uFEFF\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	b.WriteString(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about=""
+ xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/"
+ xmlns:dc="http://purl.org/dc/elements/1.1/"
+ xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+ xmlns:pdf="http://ns.adobe.com/pdf/1.3/">
+<pdfaid:part>2</pdfaid:part>
+<pdfaid:conformance>B</pdfaid:conformance>
+`)
+	fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", esc(meta.Title))
+	if meta.CredentialID != "" {
+		fmt.Fprintf(&b, "<dc:identifier>%s</dc:identifier>\n", esc(meta.CredentialID))
+	}
+	fmt.Fprintf(&b, "<xmp:CreateDate>%s</xmp:CreateDate>\n<xmp:ModifyDate>%s</xmp:ModifyDate>\n", date, date)
+	fmt.Fprintf(&b, "<pdf:Producer>%s</pdf:Producer>\n", esc(pdfaProducer))
+	b.WriteString("</rdf:Description>\n</rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return []byte(b.String())
+}
+
+var (
+	srgbOnce sync.Once
+	srgbICC  []byte
+)
+
+// srgbProfile builds an ICC v2 display profile for sRGB: the D50-adapted
+// sRGB primaries and white point, and the sRGB tone curve sampled at 1024
+// points.
+func srgbProfile() []byte {
+	srgbOnce.Do(func() { srgbICC = buildSRGBProfile() })
+	return srgbICC
+}
+
+func buildSRGBProfile() []byte {
+	be := binary.BigEndian
+	s15 := func(v float64) []byte {
+		b := make([]byte, 4)
+		be.PutUint32(b, uint32(int32(math.Round(v*65536))))
+		return b
+	}
+	xyz := func(x, y, z float64) []byte {
+		b := append([]byte("XYZ \x00\x00\x00\x00"), s15(x)...)
+		return append(append(b, s15(y)...), s15(z)...)
+	}
+	text := func(s string) []byte {
+		return append([]byte("text\x00\x00\x00\x00"), append([]byte(s), 0)...)
+	}
+	desc := func(s string) []byte {
+		b := []byte("desc\x00\x00\x00\x00")
+		b = be.AppendUint32(b, uint32(len(s)+1))
+		b = append(append(b, s...), 0)
+		b = be.AppendUint32(b, 0) // Unicode language
+		b = be.AppendUint32(b, 0) // Unicode count
+		b = be.AppendUint16(b, 0) // ScriptCode code
+		b = append(b, 0)          // ScriptCode count
+		return append(b, make([]byte, 67)...)
+	}
+	curve := []byte("curv\x00\x00\x00\x00")
+	curve = be.AppendUint32(curve, 1024)
+	for i := 0; i < 1024; i++ {
+		v := float64(i) / 1023
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		curve = be.AppendUint16(curve, uint16(math.Round(v*65535)))
+	}
+
+	tags := []struct {
+		sig  string
+		data []byte
+	}{
+		{"desc", desc("sRGB IEC61966-2.1")},
+		{"cprt", text("No copyright, use freely")},
+		{"wtpt", xyz(0.9642, 1.0, 0.8249)},
+		{"rXYZ", xyz(0.4361, 0.2225, 0.0139)},
+		{"gXYZ", xyz(0.3851, 0.7169, 0.0971)},
+		{"bXYZ", xyz(0.1431, 0.0606, 0.7141)},
+		{"rTRC", curve},
+		{"gTRC", curve},
+		{"bTRC", curve},
+	}
+
+	// Tag data follows the header and tag table; the three TRC tags share
+	// one copy of the curve.
+	table := be.AppendUint32(nil, uint32(len(tags)))
+	var body []byte
+	offset := 128 + 4 + 12*len(tags)
+	curveAt := 0
+	for _, t := range tags {
+		at := offset + len(body)
+		if strings.HasSuffix(t.sig, "TRC") && curveAt != 0 {
+			at = curveAt
+		} else {
+			if strings.HasSuffix(t.sig, "TRC") {
+				curveAt = at
+			}
+			body = append(body, t.data...)
+			for len(body)%4 != 0 {
+				body = append(body, 0)
+			}
+		}
+		table = append(table, t.sig...)
+		table = be.AppendUint32(table, uint32(at))
+		table = be.AppendUint32(table, uint32(len(t.data)))
+	}
+
+	header := make([]byte, 128)
+	be.PutUint32(header[0:], uint32(128+len(table)+len(body)))
+	be.PutUint32(header[8:], 0x02100000) // version 2.1
+	copy(header[12:], "mntrRGB XYZ ")
+	be.PutUint16(header[24:], 2000) // creation date: 2000-01-01
+	be.PutUint16(header[26:], 1)
+	be.PutUint16(header[28:], 1)
+	copy(header[36:], "acsp")
+	copy(header[68:], append(append(s15(0.9642), s15(1.0)...), s15(0.8249)...))
+	return append(append(header, table...), body...)
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestArchivePDF checks the PDF/A-2b rewrite: the binary header comment,
+// a cross-reference table that still points at every object, and the
+// catalog, trailer and XMP entries PDF/A requires.
+func TestArchivePDF(t *testing.T) {
+	meta := pdfaMeta{Title: "BSc <Honours> & Merit", CredentialID: "urn:uuid:1234", Created: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	out, err := archivePDF(testPDF(""), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte("%PDF-1.7\n%\xE2\xE3\xCF\xD3\n1 0 obj")) {
+		t.Errorf("header %q", out[:32])
+	}
+
+	f, err := parsePDFFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, offsets, err := f.xrefEntries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != f.size-1 {
+		t.Errorf("%d objects in use, /Size %d", len(objects), f.size)
+	}
+	for n, o := range objects {
+		if want := fmt.Sprintf("%d %d obj", n, o.gen); !bytes.HasPrefix(out[offsets[n]:], []byte(want)) {
+			t.Errorf("xref offset of object %d points at %q", n, out[offsets[n]:offsets[n]+12])
+		}
+	}
+
+	root, err := f.object(pdfObject{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"Metadata", "Pages"} {
+		if _, ok := pdfDictValue(root, key); !ok {
+			t.Errorf("catalog %q has no /%s", root, key)
+		}
+	}
+	if !strings.Contains(root, "/OutputIntents [") {
+		t.Errorf("catalog %q has no output intent", root)
+	}
+	if _, ok := pdfDictValue(f.trailer, "Info"); !ok || !strings.Contains(f.trailer, "/ID [<") {
+		t.Errorf("trailer %q lacks /Info or /ID", f.trailer)
+	}
+	if !bytes.Contains(out, []byte("/Producer "+pdfTextString(pdfaProducer))) {
+		t.Error("Info dictionary has no producer")
+	}
+
+	// Archiving twice replaces the metadata rather than adding another.
+	again, err := archivePDF(out, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err = parsePDFFile(again); err != nil {
+		t.Fatal(err)
+	}
+	if root, _ = f.object(pdfObject{1, 0}); strings.Count(root, "/Metadata") != 1 || strings.Count(root, "/OutputIntents") != 1 {
+		t.Errorf("rearchived catalog %q", root)
+	}
+
+	signed := testPDF("")
+	usePDFSigner(t)
+	if signed, err = signPDF(signed, "Testa University"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archivePDF(signed, meta); err == nil {
+		t.Error("incrementally updated PDF archived")
+	}
+}
+
+// TestPDFAXMP checks the XMP packet is well-formed and declares PDF/A-2b
+// with the document's metadata.
+func TestPDFAXMP(t *testing.T) {
+	meta := pdfaMeta{Title: "BSc <Honours> & Merit", CredentialID: "urn:uuid:1234", Created: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	packet := pdfaXMP(meta)
+
+	values := map[string]string{}
+	d := xml.NewDecoder(bytes.NewReader(packet))
+	var path []string
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("XMP is not well-formed: %v", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			if s := strings.TrimSpace(string(tok)); s != "" && len(path) > 0 {
+				values[path[len(path)-1]] = s
+			}
+		}
+	}
+
+	for name, want := range map[string]string{
+		"part":        "2",
+		"conformance": "B",
+		"li":          meta.Title,
+		"identifier":  meta.CredentialID,
+		"CreateDate":  "2026-01-02T03:04:05Z",
+		"Producer":    pdfaProducer,
+	} {
+		if values[name] != want {
+			t.Errorf("%s = %q, want %q", name, values[name], want)
+		}
+	}
+}
+
+// TestSRGBProfile checks the ICC profile header and tag table.
+func TestSRGBProfile(t *testing.T) {
+	icc := srgbProfile()
+	be := binary.BigEndian
+	if int(be.Uint32(icc)) != len(icc) {
+		t.Errorf("profile size %d, have %d bytes", be.Uint32(icc), len(icc))
+	}
+	if string(icc[12:24]) != "mntrRGB XYZ " || string(icc[36:40]) != "acsp" {
+		t.Errorf("header %q", icc[:40])
+	}
+
+	types := map[string]string{
+		"desc": "desc", "cprt": "text",
+		"wtpt": "XYZ ", "rXYZ": "XYZ ", "gXYZ": "XYZ ", "bXYZ": "XYZ ",
+		"rTRC": "curv", "gTRC": "curv", "bTRC": "curv",
+	}
+	tags := map[string]uint32{}
+	for i, n := 0, int(be.Uint32(icc[128:])); i < n; i++ {
+		entry := icc[132+12*i:]
+		sig, at, size := string(entry[:4]), be.Uint32(entry[4:]), be.Uint32(entry[8:])
+		if at%4 != 0 || int(at+size) > len(icc) {
+			t.Errorf("%s at %d size %d", sig, at, size)
+			continue
+		}
+		if got := string(icc[at : at+4]); got != types[sig] {
+			t.Errorf("%s holds a %q element, want %q", sig, got, types[sig])
+		}
+		tags[sig] = at
+	}
+	for sig := range types {
+		if _, ok := tags[sig]; !ok {
+			t.Errorf("no %s tag", sig)
+		}
+	}
+	if tags["rTRC"] != tags["gTRC"] || tags["gTRC"] != tags["bTRC"] {
+		t.Error("TRC tags do not share one curve")
+	}
+}
@@ -0,0 +1,412 @@
+package service
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/digitorus/pkcs7"
+)
+
+// PDF signatures. With PDF_SIGNING_CERT and PDF_SIGNING_KEY set, every
+// certificate PDF carries an invisible PAdES-B signature (SubFilter
+// ETSI.CAdES.detached) by that X.509 certificate, so Adobe Reader and other
+// PDF viewers can check the document itself, independently of the embedded
+// credential. PDF_SIGNING_CERT is a PEM file with the signing certificate
+// first, followed by any intermediates to embed.
+//
+// The signature is added as an incremental update: a signature field on
+// the first page, its signature dictionary, and new versions of the
+// catalog and page. Both renderers write classic cross-reference tables,
+// which is all signPDF reads.
+
+type pdfSigner struct {
+	cert  *x509.Certificate
+	chain []*x509.Certificate
+	key   crypto.PrivateKey
+}
+
+var pdfSignerVal *pdfSigner
+
+var (
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+
+	pdfObjRef    = regexp.MustCompile(`(\d+)\s+(\d+)\s+R`)
+	pdfStartXref = regexp.MustCompile(`startxref\s+(\d+)\s*%%EOF\s*$`)
+)
+
+// essCertIDv2 is an ESSCertIDv2 (RFC 5035) with the default SHA-256 hash.
+type essCertIDv2 struct {
+	CertHash []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// loadPDFSigner reads the PDF signing certificate and key, if configured.
+func loadPDFSigner() error {
+	if config.PDFSigningCert == "" && config.PDFSigningKey == "" {
+		return nil
+	}
+	if config.PDFSigningCert == "" || config.PDFSigningKey == "" {
+		return fmt.Errorf("PDF_SIGNING_CERT and PDF_SIGNING_KEY must be set together")
+	}
+	certs, err := readPEMCertificates(config.PDFSigningCert)
+	if err != nil {
+		return fmt.Errorf("PDF_SIGNING_CERT: %w", err)
+	}
+	key, err := readPEMPrivateKey(config.PDFSigningKey)
+	if err != nil {
+		return fmt.Errorf("PDF_SIGNING_KEY: %w", err)
+	}
+	pub, ok := key.(crypto.Signer)
+	if !ok {
+		return fmt.Errorf("PDF_SIGNING_KEY: unsupported key type")
+	}
+	if !publicKeysEqual(pub.Public(), certs[0].PublicKey) {
+		return fmt.Errorf("PDF_SIGNING_KEY does not match PDF_SIGNING_CERT")
+	}
+	pdfSignerVal = &pdfSigner{cert: certs[0], chain: certs[1:], key: key}
+	return nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	k, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && k.Equal(b)
+}
+
+func readPEMCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		if block, data = pem.Decode(data); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s has no PEM certificates", path)
+	}
+	return certs, nil
+}
+
+// readPEMPrivateKey reads a PKCS#8, PKCS#1 or SEC 1 private key.
+func readPEMPrivateKey(path string) (crypto.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM", path)
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("parsing %s: not a PKCS#8, PKCS#1 or EC private key", path)
+}
+
+// pdfObject is an object of the signed PDF, by number and generation.
+type pdfObject struct {
+	num, gen int
+}
+
+func (o pdfObject) ref() string { return fmt.Sprintf("%d %d R", o.num, o.gen) }
+
+func parseRef(s string) (pdfObject, bool) {
+	m := pdfObjRef.FindStringSubmatch(s)
+	if m == nil {
+		return pdfObject{}, false
+	}
+	num, _ := strconv.Atoi(m[1])
+	gen, _ := strconv.Atoi(m[2])
+	return pdfObject{num, gen}, true
+}
+
+// pdfFile is what signPDF needs of the document being signed.
+type pdfFile struct {
+	data      []byte
+	startxref int
+	trailer   string
+	size      int
+}
+
+func parsePDFFile(data []byte) (*pdfFile, error) {
+	m := pdfStartXref.FindSubmatch(data)
+	if m == nil {
+		return nil, fmt.Errorf("no startxref")
+	}
+	f := &pdfFile{data: data}
+	f.startxref, _ = strconv.Atoi(string(m[1]))
+	if f.startxref >= len(data) || !bytes.HasPrefix(data[f.startxref:], []byte("xref")) {
+		return nil, fmt.Errorf("cross-reference streams are not supported")
+	}
+	rest := string(data[f.startxref:])
+	i := strings.Index(rest, "trailer")
+	if i < 0 {
+		return nil, fmt.Errorf("no trailer")
+	}
+	trailer, ok := pdfDict(rest[i+len("trailer"):])
+	if !ok {
+		return nil, fmt.Errorf("malformed trailer")
+	}
+	f.trailer = trailer
+	size, ok := pdfDictValue(trailer, "Size")
+	if !ok {
+		return nil, fmt.Errorf("trailer has no /Size")
+	}
+	if f.size, _ = strconv.Atoi(size); f.size == 0 {
+		return nil, fmt.Errorf("trailer /Size %q", size)
+	}
+	return f, nil
+}
+
+// object returns the dictionary of the latest version of an object.
+func (f *pdfFile) object(o pdfObject) (string, error) {
+	header := regexp.MustCompile(fmt.Sprintf(`(?:^|\s)%d\s+%d\s+obj\b`, o.num, o.gen))
+	all := header.FindAllIndex(f.data, -1)
+	if all == nil {
+		return "", fmt.Errorf("object %d %d not found", o.num, o.gen)
+	}
+	dict, ok := pdfDict(string(f.data[all[len(all)-1][1]:]))
+	if !ok {
+		return "", fmt.Errorf("object %d %d is not a dictionary", o.num, o.gen)
+	}
+	return dict, nil
+}
+
+// pdfDict returns the body of the dictionary s starts with, between its
+// outer << and >>.
+func pdfDict(s string) (string, bool) {
+	s = strings.TrimLeft(s, " \t\r\n")
+	if !strings.HasPrefix(s, "<<") {
+		return "", false
+	}
+	depth := 0
+	for i := 0; i < len(s)-1; i++ {
+		switch {
+		case s[i] == '(':
+			// Skip literal strings, which may contain unbalanced brackets.
+			for n := 0; i < len(s); i++ {
+				if s[i] == '\\' {
+					i++
+				} else if s[i] == '(' {
+					n++
+				} else if s[i] == ')' {
+					if n--; n == 0 {
+						break
+					}
+				}
+			}
+		case s[i] == '<' && s[i+1] == '<':
+			depth++
+			i++
+		case s[i] == '>' && s[i+1] == '>':
+			if depth--; depth == 0 {
+				return s[2:i], true
+			}
+			i++
+		}
+	}
+	return "", false
+}
+
+// pdfDictValue returns the first value of a key in a dictionary body: a
+// reference, a name or a number.
+func pdfDictValue(dict, key string) (string, bool) {
+	re := regexp.MustCompile(`/` + key + `(?:\s+(\d+\s+\d+\s+R)|\s*(/[^\s/<>\[\]()]+)|\s+(\d+))`)
+	m := re.FindStringSubmatch(dict)
+	if m == nil {
+		return "", false
+	}
+	for _, v := range m[1:] {
+		if v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// firstPage walks the page tree to its first leaf.
+func (f *pdfFile) firstPage(root string) (pdfObject, string, error) {
+	ref, ok := pdfDictValue(root, "Pages")
+	for depth := 0; ok && depth < 32; depth++ {
+		node, _ := parseRef(ref)
+		dict, err := f.object(node)
+		if err != nil {
+			return pdfObject{}, "", err
+		}
+		if t, _ := pdfDictValue(dict, "Type"); t == "/Page" {
+			return node, dict, nil
+		}
+		kids := regexp.MustCompile(`/Kids\s*\[\s*(\d+\s+\d+\s+R)`).FindStringSubmatch(dict)
+		if kids == nil {
+			break
+		}
+		ref = kids[1]
+	}
+	return pdfObject{}, "", fmt.Errorf("no pages")
+}
+
+// pdfTextString encodes s as a UTF-16BE PDF text string.
+func pdfTextString(s string) string {
+	var b strings.Builder
+	b.WriteString("<FEFF")
+	for _, u := range utf16.Encode([]rune(s)) {
+		fmt.Fprintf(&b, "%04X", u)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+// pdfDate formats a time as a PDF date string.
+func pdfDate(t time.Time) string {
+	return "(D:" + t.UTC().Format("20060102150405") + "+00'00')"
+}
+
+const byteRangePlaceholder = "[0 0000000000 0000000000 0000000000]"
+
+// signPDF signs a PDF for the institution named in reason.
+func signPDF(data []byte, reason string) ([]byte, error) {
+	s := pdfSignerVal
+	f, err := parsePDFFile(data)
+	if err != nil {
+		return nil, err
+	}
+	rootRef, ok := pdfDictValue(f.trailer, "Root")
+	if !ok {
+		return nil, fmt.Errorf("trailer has no /Root")
+	}
+	rootObj, _ := parseRef(rootRef)
+	root, err := f.object(rootObj)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(root, "/AcroForm") {
+		return nil, fmt.Errorf("documents with forms are not supported")
+	}
+	pageObj, page, err := f.firstPage(root)
+	if err != nil {
+		return nil, err
+	}
+	if regexp.MustCompile(`/Annots\s+\d+\s+\d+\s+R`).MatchString(page) {
+		return nil, fmt.Errorf("pages with indirect annotation arrays are not supported")
+	}
+
+	sigObj := pdfObject{f.size, 0}
+	fieldObj := pdfObject{f.size + 1, 0}
+	if loc := regexp.MustCompile(`/Annots\s*\[`).FindStringIndex(page); loc != nil {
+		page = page[:loc[1]] + fieldObj.ref() + " " + page[loc[1]:]
+	} else {
+		page += " /Annots [" + fieldObj.ref() + "]"
+	}
+	root += " /AcroForm << /Fields [" + fieldObj.ref() + "] /SigFlags 3 >>"
+
+	// The signature goes into a hex string of its own reserved size; the
+	// ByteRange covers everything else.
+	reserve := 8192
+	for _, c := range append([]*x509.Certificate{s.cert}, s.chain...) {
+		reserve += len(c.Raw)
+	}
+	var buf bytes.Buffer
+	buf.Write(data)
+	if !bytes.HasSuffix(data, []byte("\n")) {
+		buf.WriteByte('\n')
+	}
+	offsets := map[int]int{}
+	writeObj := func(o pdfObject, body string) {
+		offsets[o.num] = buf.Len()
+		fmt.Fprintf(&buf, "%d %d obj\n%s\nendobj\n", o.num, o.gen, body)
+	}
+	writeObj(rootObj, "<<"+root+">>")
+	writeObj(pageObj, "<<"+page+">>")
+	writeObj(fieldObj, fmt.Sprintf("<< /Type /Annot /Subtype /Widget /FT /Sig /T %s /V %s /F 132 /Rect [0 0 0 0] /P %s >>",
+		pdfTextString("Signature1"), sigObj.ref(), pageObj.ref()))
+
+	offsets[sigObj.num] = buf.Len()
+	fmt.Fprintf(&buf, "%d 0 obj\n<< /Type /Sig /Filter /Adobe.PPKLite /SubFilter /ETSI.CAdES.detached /ByteRange ", sigObj.num)
+	byteRangeAt := buf.Len()
+	buf.WriteString(byteRangePlaceholder + " /Contents ")
+	contentsAt := buf.Len()
+	buf.WriteString("<" + strings.Repeat("0", 2*reserve) + ">")
+	contentsEnd := buf.Len()
+	fmt.Fprintf(&buf, " /M %s /Name %s /Reason %s >>\nendobj\n", pdfDate(time.Now()), pdfTextString(s.cert.Subject.CommonName), pdfTextString(reason))
+
+	xrefAt := buf.Len()
+	buf.WriteString("xref\n")
+	for _, o := range []pdfObject{rootObj, pageObj, fieldObj, sigObj} {
+		fmt.Fprintf(&buf, "%d 1\n%010d %05d n \n", o.num, offsets[o.num], o.gen)
+	}
+	trailer := fmt.Sprintf("/Size %d /Root %s /Prev %d", f.size+2, rootObj.ref(), f.startxref)
+	if info, ok := pdfDictValue(f.trailer, "Info"); ok {
+		trailer += " /Info " + info
+	}
+	if id := regexp.MustCompile(`/ID\s*\[[^\]]*\]`).FindString(f.trailer); id != "" {
+		trailer += " " + id
+	}
+	fmt.Fprintf(&buf, "trailer\n<< %s >>\nstartxref\n%d\n%%%%EOF\n", trailer, xrefAt)
+
+	out := buf.Bytes()
+	byteRange := fmt.Sprintf("[0 %010d %010d %010d]", contentsAt, contentsEnd, len(out)-contentsEnd)
+	copy(out[byteRangeAt:], byteRange)
+
+	signed := make([]byte, 0, len(out)-(contentsEnd-contentsAt))
+	signed = append(append(signed, out[:contentsAt]...), out[contentsEnd:]...)
+	signature, err := s.sign(signed)
+	if err != nil {
+		return nil, err
+	}
+	if len(signature) > reserve {
+		return nil, fmt.Errorf("signature of %d bytes does not fit in %d", len(signature), reserve)
+	}
+	copy(out[contentsAt+1:], strings.ToUpper(hex.EncodeToString(signature)))
+	return out, nil
+}
+
+// sign makes the detached CAdES signature of the signed byte ranges.
+func (s *pdfSigner) sign(content []byte) ([]byte, error) {
+	sd, err := pkcs7.NewSignedData(content)
+	if err != nil {
+		return nil, err
+	}
+	sd.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	certHash := sha256.Sum256(s.cert.Raw)
+	attr := pkcs7.Attribute{
+		Type:  oidSigningCertificateV2,
+		Value: signingCertificateV2{Certs: []essCertIDv2{{CertHash: certHash[:]}}},
+	}
+	if err := sd.AddSignerChain(s.cert, s.key, s.chain, pkcs7.SignerInfoConfig{ExtraSignedAttributes: []pkcs7.Attribute{attr}}); err != nil {
+		return nil, fmt.Errorf("signing PDF: %w", err)
+	}
+	sd.Detach()
+	return sd.Finish()
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+)
+
+// testPDF builds a one-page PDF with a classic cross-reference table.
+// Extra entries are appended to the catalog dictionary.
+func testPDF(catalogExtra string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R" + catalogExtra + " >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Annots [] /Contents 4 0 R >>",
+		"<< /Length 0 >>\nstream\n\nendstream",
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, body := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, body)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /ID [<AB> <AB>] >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// usePDFSigner installs a self-signed P-256 PDF signer for one test.
+func usePDFSigner(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Testa University Registrar"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := pdfSignerVal
+	pdfSignerVal = &pdfSigner{cert: cert, key: key}
+	t.Cleanup(func() { pdfSignerVal = saved })
+	return cert
+}
+
+// TestSignPDF checks that the incremental update leaves the original bytes
+// intact and that the CAdES signature verifies over the ByteRange.
+func TestSignPDF(t *testing.T) {
+	cert := usePDFSigner(t)
+	original := testPDF("")
+	out, err := signPDF(original, "Testa University")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, original) {
+		t.Fatal("original revision altered")
+	}
+
+	f, err := parsePDFFile(out)
+	if err != nil {
+		t.Fatalf("signed PDF: %v", err)
+	}
+	if prev, _ := pdfDictValue(f.trailer, "Prev"); prev == "" {
+		t.Errorf("trailer %q has no /Prev", f.trailer)
+	}
+	root, err := f.object(pdfObject{1, 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`/AcroForm\s*<<\s*/Fields \[6 0 R\] /SigFlags 3`).MatchString(root) {
+		t.Errorf("catalog %q has no signature field", root)
+	}
+	if page, _ := f.object(pdfObject{3, 0}); !regexp.MustCompile(`/Annots \[6 0 R ?\]`).MatchString(page) {
+		t.Errorf("page %q does not annotate the field", page)
+	}
+
+	m := regexp.MustCompile(`/ByteRange \[0 (\d+) (\d+) (\d+)\] /Contents <([0-9A-F]+)>`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("no signature dictionary")
+	}
+	a, _ := strconv.Atoi(string(m[1]))
+	b, _ := strconv.Atoi(string(m[2]))
+	c, _ := strconv.Atoi(string(m[3]))
+	if b+c != len(out) || out[a] != '<' || out[b-1] != '>' {
+		t.Fatalf("ByteRange [0 %d %d %d] does not cover the file around /Contents", a, b, c)
+	}
+	der, err := hex.DecodeString(string(m[4]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// /Contents is zero-padded past the signature; cut it at the DER
+	// length, since the signature itself may end in zero bytes.
+	var sig asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		t.Fatal(err)
+	}
+	p7, err := pkcs7.Parse(sig.FullBytes)
+	if err != nil {
+		t.Fatalf("parsing signature: %v", err)
+	}
+	p7.Content = append(append([]byte{}, out[:a]...), out[b:]...)
+	if err := p7.Verify(); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	if signer := p7.GetOnlySigner(); signer == nil || !signer.Equal(cert) {
+		t.Error("signed by another certificate")
+	}
+
+	tampered := bytes.Replace(out, []byte("/MediaBox [0 0 612 792]"), []byte("/MediaBox [0 0 612 793]"), 1)
+	p7.Content = append(append([]byte{}, tampered[:a]...), tampered[b:]...)
+	if err := p7.Verify(); err == nil {
+		t.Error("signature verifies over a tampered document")
+	}
+}
+
+// TestSignPDFUnsupported checks that documents signPDF cannot update
+// safely are refused rather than corrupted.
+func TestSignPDFUnsupported(t *testing.T) {
+	usePDFSigner(t)
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"form", testPDF(" /AcroForm << /Fields [] >>")},
+		{"no startxref", []byte("%PDF-1.7\n")},
+		{"xref stream", bytes.Replace(testPDF(""), []byte("\nxref\n"), []byte("\nXREF\n"), 1)},
+	}
+	for _, tt := range tests {
+		if _, err := signPDF(tt.data, "Testa University"); err == nil {
+			t.Errorf("%s: signed", tt.name)
+		}
+	}
+}
+
+// TestPDFDict checks that dictionary bodies are cut at the matching
+// brackets, ignoring brackets inside literal strings.
+func TestPDFDict(t *testing.T) {
+	tests := []struct {
+		in, want string
+		ok       bool
+	}{
+		{"<< /A 1 >> rest", " /A 1 ", true},
+		{"\n<< /A << /B 2 >> >>", " /A << /B 2 >> ", true},
+		{"<< /T (a >> b) /C 3 >>", " /T (a >> b) /C 3 ", true},
+		{`<< /T (a \) >> b) >>`, ` /T (a \) >> b) `, true},
+		{"<< /A 1", "", false},
+		{"[1 2]", "", false},
+	}
+	for _, tt := range tests {
+		if got, ok := pdfDict(tt.in); got != tt.want || ok != tt.ok {
+			t.Errorf("pdfDict(%q) = %q, %t, want %q, %t", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
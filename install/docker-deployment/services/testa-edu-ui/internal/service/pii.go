@@ -0,0 +1,267 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PII-minimizing mode (PII_MODE=hashed): the student's name and student ID
+// are replaced in issued credentials by salted hashes, "<salt id>.<hmac>".
+// Human-readable documents (PDF certificate, wallet passes) keep the real
+// values. Salts are kept in DATA_DIR/pii.json together with an index from
+// hash to original value, which authorized staff can query through the
+// /api/staff/pii endpoints (STAFF_API_TOKEN).
+
+const (
+	PIIModePlain  = "plain"
+	PIIModeHashed = "hashed"
+)
+
+type PIIEntry struct {
+	Field     string    `json:"field"`
+	Value     string    `json:"value"`
+	SaltID    string    `json:"saltId"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	// Subjects are the DIDs of the students the hash was issued for;
+	// students can share a name.
+	Subjects []string `json:"subjects,omitempty"`
+}
+
+type PIIStore struct {
+	path string
+
+	mu      sync.Mutex
+	Current string               `json:"current"`
+	Salts   map[string][]byte    `json:"salts"`
+	Index   map[string]*PIIEntry `json:"index"`
+}
+
+var pii *PIIStore
+
+func NewPIIStore(dataDir string) (*PIIStore, error) {
+	s := &PIIStore{
+		path:  filepath.Join(dataDir, "pii.json"),
+		Salts: make(map[string][]byte),
+		Index: make(map[string]*PIIEntry),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("parsing PII store: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading PII store: %w", err)
+	}
+	if s.Current == "" {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, err := s.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *PIIStore) saveLocked() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing PII store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// rotateLocked adds a new salt and makes it current. Old salts are kept so
+// hashes in credentials already issued can still be looked up.
+func (s *PIIStore) rotateLocked() (string, error) {
+	salt := make([]byte, 32)
+	rand.Read(salt)
+	id := fmt.Sprintf("s%d", len(s.Salts)+1)
+	s.Salts[id] = salt
+	s.Current = id
+	return id, s.saveLocked()
+}
+
+func normalizePII(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+func piiDigest(salt []byte, field, value string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(field + "\x00" + normalizePII(value)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// Hash returns the salted hash for a subject's field value under the
+// current salt and records it in the lookup index.
+func (s *PIIStore) Hash(subjectID, field, value string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := s.Current + "." + piiDigest(s.Salts[s.Current], field, value)
+	entry, ok := s.Index[h]
+	if !ok {
+		entry = &PIIEntry{Field: field, Value: value, SaltID: s.Current, CreatedAt: time.Now().UTC()}
+		s.Index[h] = entry
+	} else if slices.Contains(entry.Subjects, subjectID) {
+		return h, nil
+	}
+	entry.Subjects = append(entry.Subjects, subjectID)
+	return h, s.saveLocked()
+}
+
+// EraseSubject removes subjectID from the lookup index, dropping entries
+// no other subject uses. It returns how many entries were dropped.
+func (s *PIIStore) EraseSubject(subjectID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed, dropped := false, 0
+	for h, entry := range s.Index {
+		i := slices.Index(entry.Subjects, subjectID)
+		if i < 0 {
+			continue
+		}
+		changed = true
+		entry.Subjects = slices.Delete(entry.Subjects, i, i+1)
+		if len(entry.Subjects) == 0 {
+			delete(s.Index, h)
+			dropped++
+		}
+	}
+	if !changed {
+		return 0, nil
+	}
+	return dropped, s.saveLocked()
+}
+
+// Expire drops lookup entries created before cutoff. The hashes in issued
+// credentials then no longer resolve to a name.
+func (s *PIIStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for h, entry := range s.Index {
+		if !entry.CreatedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			delete(s.Index, h)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+// Candidates returns the hashes a value would have under every salt.
+func (s *PIIStore) Candidates(field, value string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for id, salt := range s.Salts {
+		out = append(out, id+"."+piiDigest(salt, field, value))
+	}
+	return out
+}
+
+func (s *PIIStore) Lookup(h string) (*PIIEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.Index[h]
+	return e, ok
+}
+
+func (s *PIIStore) Rotate() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rotateLocked()
+}
+
+// credentialForm is the form as it goes into issued credentials: with the
+// name and student ID hashed in PII-minimizing mode.
+func credentialForm(sess *Session) (CredentialForm, error) {
+	form := sess.Form
+	if config.PIIMode != PIIModeHashed {
+		return form, nil
+	}
+	var err error
+	if form.StudentName, err = pii.Hash(form.SubjectDID, "name", form.StudentName); err != nil {
+		return form, err
+	}
+	if form.StudentID != "" {
+		if form.StudentID, err = pii.Hash(form.SubjectDID, "studentId", form.StudentID); err != nil {
+			return form, err
+		}
+	}
+	return form, nil
+}
+
+// requireStaff guards staff-only endpoints with STAFF_API_TOKEN; they are
+// disabled when no token is configured.
+func requireStaff(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if config.StaffAPIToken == "" || !strings.EqualFold(scheme, "Bearer") ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(config.StaffAPIToken)) != 1 {
+			slog.WarnContext(r.Context(), "staff API: unauthorized", "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handlePIILookup resolves a hashed identifier to its original value.
+func handlePIILookup(w http.ResponseWriter, r *http.Request) {
+	entry, ok := pii.Lookup(r.PathValue("hash"))
+	if !ok {
+		http.Error(w, "Unknown identifier", http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: resolved identifier", "field", entry.Field)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(entry)
+}
+
+// handlePIIHashes returns the hashes a known value would carry in
+// credentials, e.g. to find a student's credentials by student ID.
+func handlePIIHashes(w http.ResponseWriter, r *http.Request) {
+	field, value := r.URL.Query().Get("field"), r.URL.Query().Get("value")
+	if (field != "name" && field != "studentId") || value == "" {
+		http.Error(w, "field (name or studentId) and value are required", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"field": field, "hashes": pii.Candidates(field, value)})
+}
+
+func handlePIIRotateSalt(w http.ResponseWriter, r *http.Request) {
+	id, err := pii.Rotate()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "PII salt rotation error", "err", err)
+		http.Error(w, "Failed to rotate salt", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: PII salt rotated", "salt", id)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"current": id})
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+)
+
+// usePIIStore gives a test its own PII store in hashed mode.
+func usePIIStore(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	saved, savedPII := config, pii
+	t.Cleanup(func() { config, pii = saved, savedPII })
+	var err error
+	if pii, err = NewPIIStore(dir); err != nil {
+		t.Fatal(err)
+	}
+	config.PIIMode, config.StaffAPIToken = PIIModeHashed, "staff-token"
+	return dir
+}
+
+// TestPIIHash checks hashes ignore case and spacing, differ by field, and
+// resolve to the value after the store is reopened.
+func TestPIIHash(t *testing.T) {
+	dir := usePIIStore(t)
+	h, err := pii.Hash("did:example:amina", "name", "Amina Odhiambo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := pii.Hash("did:example:amina", "name", "  amina   ODHIAMBO "); again != h {
+		t.Errorf("normalized value hashed to %s, want %s", again, h)
+	}
+	if id, _ := pii.Hash("did:example:amina", "studentId", "Amina Odhiambo"); id == h {
+		t.Error("the same value hashed alike for two fields")
+	}
+
+	reopened, err := NewPIIStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, ok := reopened.Lookup(h)
+	if !ok || entry.Field != "name" || entry.Value != "Amina Odhiambo" || !slices.Equal(entry.Subjects, []string{"did:example:amina"}) {
+		t.Errorf("Lookup = %+v, %t", entry, ok)
+	}
+}
+
+// TestPIIRotate checks a new salt changes new hashes while the old ones
+// still resolve and are among the candidates.
+func TestPIIRotate(t *testing.T) {
+	usePIIStore(t)
+	old, _ := pii.Hash("did:example:amina", "studentId", "ADM-2021-0042")
+	if _, err := pii.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	current, _ := pii.Hash("did:example:amina", "studentId", "ADM-2021-0042")
+	if current == old {
+		t.Fatal("hash unchanged by rotation")
+	}
+	if _, ok := pii.Lookup(old); !ok {
+		t.Error("hash under the old salt no longer resolves")
+	}
+	candidates := pii.Candidates("studentId", "adm-2021-0042")
+	if len(candidates) != 2 || !slices.Contains(candidates, old) || !slices.Contains(candidates, current) {
+		t.Errorf("Candidates = %v, want %s and %s", candidates, old, current)
+	}
+}
+
+// TestPIIErase checks a hash two students share outlives one being
+// erased, and that expiry respects dry runs.
+func TestPIIErase(t *testing.T) {
+	usePIIStore(t)
+	shared, _ := pii.Hash("did:example:amina", "name", "Amina Odhiambo")
+	pii.Hash("did:example:other", "name", "Amina Odhiambo")
+	own, _ := pii.Hash("did:example:amina", "studentId", "ADM-2021-0042")
+
+	if n, err := pii.EraseSubject("did:example:amina"); err != nil || n != 1 {
+		t.Fatalf("EraseSubject = %d, %v; want 1 dropped", n, err)
+	}
+	if _, ok := pii.Lookup(own); ok {
+		t.Error("the erased student's own entry kept")
+	}
+	if entry, ok := pii.Lookup(shared); !ok || !slices.Equal(entry.Subjects, []string{"did:example:other"}) {
+		t.Errorf("shared entry = %+v, %t", entry, ok)
+	}
+
+	if n, _ := pii.Expire(time.Now().Add(time.Minute), true); n != 1 {
+		t.Errorf("dry run expired %d, want 1", n)
+	}
+	if _, ok := pii.Lookup(shared); !ok {
+		t.Error("dry run dropped the entry")
+	}
+	if n, err := pii.Expire(time.Now().Add(-time.Minute), false); err != nil || n != 0 {
+		t.Errorf("Expire before the entry = %d, %v", n, err)
+	}
+	if n, err := pii.Expire(time.Now().Add(time.Minute), false); err != nil || n != 1 {
+		t.Errorf("Expire = %d, %v", n, err)
+	}
+	if _, ok := pii.Lookup(shared); ok {
+		t.Error("expired entry kept")
+	}
+}
+
+// TestCredentialFormPII checks hashed mode replaces the name and student
+// ID in the credential form and plain mode keeps them.
+func TestCredentialFormPII(t *testing.T) {
+	usePIIStore(t)
+	sess := &Session{Form: CredentialForm{SubjectDID: "did:example:amina", StudentName: "Amina Odhiambo", StudentID: "ADM-2021-0042"}}
+	form, err := credentialForm(sess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry, ok := pii.Lookup(form.StudentName); !ok || entry.Value != "Amina Odhiambo" {
+		t.Errorf("StudentName = %q, not a hash of the name", form.StudentName)
+	}
+	if entry, ok := pii.Lookup(form.StudentID); !ok || entry.Value != "ADM-2021-0042" {
+		t.Errorf("StudentID = %q, not a hash of the student ID", form.StudentID)
+	}
+	if sess.Form.StudentName != "Amina Odhiambo" {
+		t.Error("the session's form was changed")
+	}
+
+	config.PIIMode = PIIModePlain
+	if form, _ := credentialForm(sess); form.StudentName != "Amina Odhiambo" || form.StudentID != "ADM-2021-0042" {
+		t.Errorf("plain mode form = %+v", form)
+	}
+}
+
+// TestPIIStaffAPI checks the lookup needs the staff token and resolves a
+// hash the hashes endpoint returns.
+func TestPIIStaffAPI(t *testing.T) {
+	usePIIStore(t)
+	h, _ := pii.Hash("did:example:amina", "studentId", "ADM-2021-0042")
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/staff/pii/{hash}", requireStaff(handlePIILookup))
+	mux.HandleFunc("GET /api/staff/pii", requireStaff(handlePIIHashes))
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, token := range []string{"", "wrong-token"} {
+		if rec := get("/api/staff/pii/"+h, token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: %d, want 401", token, rec.Code)
+		}
+	}
+	if rec := get("/api/staff/pii/s1.unknown", "staff-token"); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown hash: %d, want 404", rec.Code)
+	}
+	if rec := get("/api/staff/pii?field=email&value=x", "staff-token"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown field: %d, want 400", rec.Code)
+	}
+
+	rec := get("/api/staff/pii?field=studentId&value=ADM-2021-0042", "staff-token")
+	var hashes struct{ Hashes []string }
+	if err := json.NewDecoder(rec.Body).Decode(&hashes); err != nil || !slices.Contains(hashes.Hashes, h) {
+		t.Fatalf("hashes = %v, %v; want %s among them", hashes.Hashes, err, h)
+	}
+	rec = get("/api/staff/pii/"+h, "staff-token")
+	var entry PIIEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entry); err != nil || entry.Value != "ADM-2021-0042" {
+		t.Errorf("lookup = %+v, %v", entry, err)
+	}
+	if rec.Header().Get("Cache-Control") != "no-store" {
+		t.Error("lookup may be cached")
+	}
+}
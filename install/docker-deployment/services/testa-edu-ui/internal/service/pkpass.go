@@ -0,0 +1,231 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/digitorus/pkcs7"
+)
+
+// Apple Wallet passes are zip bundles whose manifest is signed with a Pass
+// Type ID certificate issued under Apple's WWDR intermediate. Pass signing
+// is enabled by setting PASS_TYPE_ID, PASS_TEAM_ID, PASS_CERT, PASS_KEY and
+// PASS_WWDR_CERT.
+
+type passSigner struct {
+	cert *x509.Certificate
+	key  crypto.PrivateKey
+	wwdr *x509.Certificate
+}
+
+var (
+	passSignerOnce sync.Once
+	passSignerVal  *passSigner
+	passSignerErr  error
+)
+
+func appleWalletEnabled() bool {
+	return config.PassTypeID != "" && config.PassCert != ""
+}
+
+func loadPassSigner() (*passSigner, error) {
+	passSignerOnce.Do(func() {
+		passSignerVal, passSignerErr = readPassSigner(config.PassCert, config.PassKey, config.PassWWDRCert)
+	})
+	return passSignerVal, passSignerErr
+}
+
+func readPassSigner(certPath, keyPath, wwdrPath string) (*passSigner, error) {
+	cert, err := readPEMCertificate(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("pass certificate: %w", err)
+	}
+	wwdr, err := readPEMCertificate(wwdrPath)
+	if err != nil {
+		return nil, fmt.Errorf("WWDR certificate: %w", err)
+	}
+
+	key, err := readPEMPrivateKey(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("pass key: %w", err)
+	}
+	return &passSigner{cert: cert, key: key, wwdr: wwdr}, nil
+}
+
+func readPEMCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not PEM", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+type passField struct {
+	Key   string `json:"key"`
+	Label string `json:"label"`
+	Value string `json:"value"`
+}
+
+// buildPassJSON describes a generic pass carrying the credential summary
+// on its face and the verification QR as its barcode.
+func buildPassJSON(sess *Session) ([]byte, error) {
+	form := sess.Form
+	secondary := []passField{{"name", "STUDENT", form.StudentName}}
+	auxiliary := []passField{}
+	var back []passField
+	if form.FieldOfStudy != "" {
+		secondary = append(secondary, passField{"fieldOfStudy", "FIELD OF STUDY", form.FieldOfStudy})
+	}
+	if form.GraduationDate != "" {
+		secondary = append(secondary, passField{"graduationDate", "GRADUATED", form.GraduationDate})
+	}
+	if form.Honors != "" {
+		auxiliary = append(auxiliary, passField{"honors", "HONORS", form.Honors})
+	}
+	if form.GPA != "" {
+		auxiliary = append(auxiliary, passField{"gpa", "GPA", gradeText(form)})
+	}
+	if form.StudentID != "" {
+		back = append(back, passField{"studentId", "Student ID", form.StudentID})
+	}
+	back = append(back, passField{"issuer", "Issuer", sess.IssuerDID})
+
+	pass := map[string]interface{}{
+		"formatVersion":      1,
+		"passTypeIdentifier": config.PassTypeID,
+		"teamIdentifier":     config.PassTeamID,
+		"serialNumber":       newUUID(),
+		"organizationName":   form.Institution,
+		"description":        form.Degree + " — " + form.Institution,
+		"logoText":           form.Institution,
+		"foregroundColor":    "rgb(255, 255, 255)",
+		"backgroundColor":    "rgb(67, 56, 202)",
+		"labelColor":         "rgb(199, 210, 254)",
+		"generic": map[string]interface{}{
+			"primaryFields":   []passField{{"degree", "DEGREE", form.Degree}},
+			"secondaryFields": secondary,
+			"auxiliaryFields": auxiliary,
+			"backFields":      back,
+		},
+		"barcodes": []map[string]string{{
+			"format":          "PKBarcodeFormatQR",
+			"message":         sess.QR.QRData,
+			"messageEncoding": "iso-8859-1",
+			"altText":         "Scan with Inji Verify",
+		}},
+	}
+	return json.Marshal(pass)
+}
+
+// passIcon renders a plain brand-coloured square; Wallet refuses passes
+// without icon.png.
+func passIcon(size int) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{67, 56, 202, 255}}, image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// buildPKPass assembles and signs the .pkpass bundle.
+func buildPKPass(sess *Session) ([]byte, error) {
+	signer, err := loadPassSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	passJSON, err := buildPassJSON(sess)
+	if err != nil {
+		return nil, fmt.Errorf("encoding pass.json: %w", err)
+	}
+	files := map[string][]byte{"pass.json": passJSON}
+	for name, size := range map[string]int{"icon.png": 29, "icon@2x.png": 58, "logo.png": 50, "logo@2x.png": 100} {
+		if files[name], err = passIcon(size); err != nil {
+			return nil, fmt.Errorf("rendering %s: %w", name, err)
+		}
+	}
+
+	manifest := map[string]string{}
+	for name, data := range files {
+		sum := sha1.Sum(data)
+		manifest[name] = hex.EncodeToString(sum[:])
+	}
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := pkcs7.NewSignedData(manifestJSON)
+	if err != nil {
+		return nil, fmt.Errorf("creating manifest signature: %w", err)
+	}
+	if err := sd.AddSignerChain(signer.cert, signer.key, []*x509.Certificate{signer.wwdr}, pkcs7.SignerInfoConfig{}); err != nil {
+		return nil, fmt.Errorf("signing manifest: %w", err)
+	}
+	sd.Detach()
+	signature, err := sd.Finish()
+	if err != nil {
+		return nil, fmt.Errorf("finishing manifest signature: %w", err)
+	}
+	files["manifest.json"] = manifestJSON
+	files["signature"] = signature
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range files {
+		f, err := zw.Create(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(data); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func handleDownloadPKPass(w http.ResponseWriter, r *http.Request) {
+	if !appleWalletEnabled() {
+		http.Error(w, "Apple Wallet passes are not configured", http.StatusNotFound)
+		return
+	}
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil {
+		http.Error(w, "No QR code available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+
+	pass, err := sessionPKPass(r.Context(), sess)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "pkpass error", "err", err)
+		http.Error(w, "Failed to build Apple Wallet pass", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.apple.pkpass")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.pkpass\"")
+	w.Write(pass)
+}
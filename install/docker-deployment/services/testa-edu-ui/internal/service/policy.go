@@ -0,0 +1,184 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Verifier acceptance policies. A verifier states what it accepts beyond a
+// valid signature: the issuers it recognizes, the credential types it
+// needs, how old a credential may be and which subject fields must be
+// present. A verifier saves its policy with PUT /api/v1/policy, or sends
+// one along with a single request as the "policy" member of the
+// /api/v1/verify body. The rules are evaluated into the verification
+// report, which then says whether the credential is accepted, not only
+// whether it verified.
+
+type VerificationPolicy struct {
+	AllowedIssuers []string `json:"allowedIssuers,omitempty"`
+	RequiredTypes  []string `json:"requiredTypes,omitempty"`
+	MaxAge         string   `json:"maxAge,omitempty"` // Go duration or days, e.g. "365d"
+	RequiredFields []string `json:"requiredFields,omitempty"`
+}
+
+// PolicyResult is the outcome of evaluating a policy.
+type PolicyResult struct {
+	Accepted bool                `json:"accepted"`
+	Checks   []VerificationCheck `json:"checks"`
+}
+
+func (p *VerificationPolicy) validate() error {
+	for _, iss := range p.AllowedIssuers {
+		if !strings.HasPrefix(iss, "did:") {
+			return fmt.Errorf("allowedIssuers entry %q is not a DID", iss)
+		}
+	}
+	if p.MaxAge != "" {
+		if d, err := parseRetentionPeriod(p.MaxAge); err != nil || d <= 0 {
+			return fmt.Errorf("invalid maxAge %q", p.MaxAge)
+		}
+	}
+	return nil
+}
+
+func (p *VerificationPolicy) empty() bool {
+	return len(p.AllowedIssuers) == 0 && len(p.RequiredTypes) == 0 && p.MaxAge == "" && len(p.RequiredFields) == 0
+}
+
+// credentialTypes lists a credential's types: the VC "type" and, for an
+// SD-JWT VC, its "vct".
+func credentialTypes(claims, vc map[string]interface{}) []string {
+	var types []string
+	switch t := vc["type"].(type) {
+	case string:
+		types = append(types, t)
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+	if vct, ok := claims["vct"].(string); ok {
+		types = append(types, vct)
+	}
+	return types
+}
+
+// credentialIssued returns when a credential was issued, or the zero time.
+func credentialIssued(claims, vc map[string]interface{}) time.Time {
+	for _, k := range []string{"validFrom", "issuanceDate"} {
+		if s, ok := vc[k].(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				return t
+			}
+		}
+	}
+	for _, k := range []string{"iat", "nbf"} {
+		if n, ok := claims[k].(float64); ok {
+			return time.Unix(int64(n), 0)
+		}
+	}
+	return time.Time{}
+}
+
+// evaluatePolicy applies p to a verified credential.
+func evaluatePolicy(p *VerificationPolicy, sc *scannedCredential, result *ScanResult) *PolicyResult {
+	res := &PolicyResult{Accepted: true, Checks: []VerificationCheck{}}
+	rule := func(name string, ok bool, detail string) {
+		status := CheckPassed
+		if !ok {
+			status, res.Accepted = CheckFailed, false
+		}
+		res.Checks = append(res.Checks, VerificationCheck{Check: name, Status: status, Detail: detail})
+	}
+	claims, vc := credentialClaims(sc)
+
+	if len(p.AllowedIssuers) > 0 {
+		issuer, _, _ := strings.Cut(result.Issuer, "#")
+		if slices.Contains(p.AllowedIssuers, issuer) {
+			rule("allowedIssuers", true, "")
+		} else {
+			rule("allowedIssuers", false, "issuer "+issuer+" is not allowed")
+		}
+	}
+	if len(p.RequiredTypes) > 0 {
+		types := credentialTypes(claims, vc)
+		var missing []string
+		for _, t := range p.RequiredTypes {
+			if !slices.Contains(types, t) {
+				missing = append(missing, t)
+			}
+		}
+		rule("requiredTypes", len(missing) == 0, missingDetail("type", missing))
+	}
+	if p.MaxAge != "" {
+		maxAge, _ := parseRetentionPeriod(p.MaxAge)
+		switch issued := credentialIssued(claims, vc); {
+		case issued.IsZero():
+			rule("maxAge", false, "the credential has no issuance date")
+		case time.Since(issued) > maxAge:
+			rule("maxAge", false, "issued on "+issued.Format("2 January 2006")+", older than "+p.MaxAge)
+		default:
+			rule("maxAge", true, "issued on "+issued.Format("2 January 2006"))
+		}
+	}
+	if len(p.RequiredFields) > 0 {
+		subject, _ := credentialSubjectOf(&StoredCredential{Format: sc.Format, Credential: sc.Credential})
+		var missing []string
+		for _, f := range p.RequiredFields {
+			if v, ok := subject[f]; !ok || v == nil || v == "" {
+				missing = append(missing, f)
+			}
+		}
+		rule("requiredFields", len(missing) == 0, missingDetail("field", missing))
+	}
+	return res
+}
+
+func missingDetail(what string, missing []string) string {
+	switch len(missing) {
+	case 0:
+		return ""
+	case 1:
+		return "missing " + what + " " + missing[0]
+	}
+	return "missing " + what + "s " + strings.Join(missing, ", ")
+}
+
+func handlePolicyGet(w http.ResponseWriter, r *http.Request) {
+	k, _ := requestVerifier(r)
+	p := k.Policy
+	if p == nil {
+		p = &VerificationPolicy{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
+
+// handlePolicyPut saves the verifier's policy; an empty policy clears it.
+func handlePolicyPut(w http.ResponseWriter, r *http.Request) {
+	var p VerificationPolicy
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		http.Error(w, "expected JSON policy", http.StatusBadRequest)
+		return
+	}
+	if err := p.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	k, _ := requestVerifier(r)
+	if err := verifierKeys.SetPolicy(k.ID, &p); err != nil {
+		slog.ErrorContext(r.Context(), "verifier policy error", "err", err)
+		http.Error(w, "Failed to save the policy", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "verifier API: policy updated", "verifier_key", k.ID)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(p)
+}
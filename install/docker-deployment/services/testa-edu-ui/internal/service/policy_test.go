@@ -0,0 +1,93 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestEvaluatePolicy checks each rule accepts and rejects a JSON-LD and an
+// SD-JWT credential.
+func TestEvaluatePolicy(t *testing.T) {
+	issued := time.Now().AddDate(0, -2, 0)
+	jsonLD, _ := json.Marshal(map[string]interface{}{
+		"type":              []string{"VerifiableCredential", "EducationCredential"},
+		"issuer":            "did:web:uonbi.ac.ke",
+		"issuanceDate":      issued.UTC().Format(time.RFC3339),
+		"credentialSubject": map[string]interface{}{"studentName": "Amina Odhiambo", "degree": "BSc Computer Science", "gpa": ""},
+	})
+	name, _ := newDisclosure("studentName", "Amina Odhiambo")
+	sdjwt, _ := json.Marshal(assembleSDJWT(testIssuerJWT(t, map[string]interface{}{
+		"iss":    "did:web:uonbi.ac.ke",
+		"iat":    issued.Unix(),
+		"vct":    "EducationCredential",
+		"degree": "BSc Computer Science",
+		"_sd":    []string{name.Digest},
+	}), []Disclosure{name}))
+	undated, _ := json.Marshal(map[string]interface{}{"type": "VerifiableCredential", "credentialSubject": map[string]interface{}{}})
+
+	credentials := map[string]*scannedCredential{
+		"json-ld": {Format: "json-ld", Credential: jsonLD},
+		"sd-jwt":  {Format: FormatSDJWT, Credential: sdjwt},
+		"undated": {Format: "json-ld", Credential: undated},
+	}
+	tests := []struct {
+		name, credential string
+		issuer           string
+		policy           VerificationPolicy
+		accepted         bool
+		detail           string
+	}{
+		{"empty policy", "json-ld", "did:web:uonbi.ac.ke", VerificationPolicy{}, true, ""},
+		{"allowed issuer", "json-ld", "did:web:uonbi.ac.ke#key-1", VerificationPolicy{AllowedIssuers: []string{"did:web:ku.ac.ke", "did:web:uonbi.ac.ke"}}, true, ""},
+		{"other issuer", "sd-jwt", "did:web:diploma-mill.example", VerificationPolicy{AllowedIssuers: []string{"did:web:uonbi.ac.ke"}}, false, "issuer did:web:diploma-mill.example is not allowed"},
+		{"required type", "json-ld", "", VerificationPolicy{RequiredTypes: []string{"EducationCredential"}}, true, ""},
+		{"required vct", "sd-jwt", "", VerificationPolicy{RequiredTypes: []string{"EducationCredential"}}, true, ""},
+		{"missing types", "json-ld", "", VerificationPolicy{RequiredTypes: []string{"EducationCredential", "DiplomaCredential", "TranscriptCredential"}}, false, "missing types DiplomaCredential, TranscriptCredential"},
+		{"recent enough", "json-ld", "", VerificationPolicy{MaxAge: "365d"}, true, ""},
+		{"too old", "sd-jwt", "", VerificationPolicy{MaxAge: "30d"}, false, "older than 30d"},
+		{"no issuance date", "undated", "", VerificationPolicy{MaxAge: "365d"}, false, "the credential has no issuance date"},
+		{"required fields", "json-ld", "", VerificationPolicy{RequiredFields: []string{"studentName", "degree"}}, true, ""},
+		{"disclosed field", "sd-jwt", "", VerificationPolicy{RequiredFields: []string{"studentName"}}, true, ""},
+		{"empty field", "json-ld", "", VerificationPolicy{RequiredFields: []string{"gpa"}}, false, "missing field gpa"},
+		{"one rule fails", "json-ld", "did:web:uonbi.ac.ke", VerificationPolicy{AllowedIssuers: []string{"did:web:uonbi.ac.ke"}, RequiredFields: []string{"graduationDate"}}, false, "missing field graduationDate"},
+	}
+	for _, tt := range tests {
+		res := evaluatePolicy(&tt.policy, credentials[tt.credential], &ScanResult{Issuer: tt.issuer})
+		if res.Accepted != tt.accepted {
+			t.Errorf("%s: accepted = %t, want %t (%+v)", tt.name, res.Accepted, tt.accepted, res.Checks)
+			continue
+		}
+		if tt.detail == "" {
+			continue
+		}
+		found := false
+		for _, c := range res.Checks {
+			found = found || (c.Status == CheckFailed && strings.Contains(c.Detail, tt.detail))
+		}
+		if !found {
+			t.Errorf("%s: no failed check saying %q in %+v", tt.name, tt.detail, res.Checks)
+		}
+	}
+}
+
+func TestVerificationPolicyValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy VerificationPolicy
+		valid  bool
+	}{
+		{"empty", VerificationPolicy{}, true},
+		{"days", VerificationPolicy{AllowedIssuers: []string{"did:web:uonbi.ac.ke"}, MaxAge: "365d"}, true},
+		{"duration", VerificationPolicy{MaxAge: "720h"}, true},
+		{"issuer not a DID", VerificationPolicy{AllowedIssuers: []string{"uonbi.ac.ke"}}, false},
+		{"bad max age", VerificationPolicy{MaxAge: "a year"}, false},
+		{"zero max age", VerificationPolicy{MaxAge: "0d"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.validate(); (err == nil) != tt.valid {
+			t.Errorf("%s: err = %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Polygon preflight checks. Registering a did:polygon DID and anchoring
+// credentials (anchor.go) are transactions paid by the DID's own account,
+// so before either the account's balance is read from the chain over
+// JSON-RPC (POLYGON_TESTNET_RPC_URL, POLYGON_MAINNET_RPC_URL) and compared
+// with the estimated cost: the operation's gas limit at the current gas
+// price, with headroom for price movement.
+
+const (
+	didRegistryGas = 500_000
+	schemaWriteGas = 1_000_000
+	gasHeadroom    = 2 // multiple of the estimated cost a balance must cover
+)
+
+var polygonClient = &http.Client{Timeout: 15 * time.Second}
+
+func polygonRPCURL(network string) (string, error) {
+	switch network {
+	case "testnet":
+		return config.PolygonTestnetRPCURL, nil
+	case "mainnet":
+		return config.PolygonMainnetRPCURL, nil
+	}
+	return "", fmt.Errorf("unknown Polygon network %q", network)
+}
+
+// polygonDIDAccount returns the network and account address of a
+// did:polygon DID, did:polygon:0x... on mainnet or
+// did:polygon:testnet:0x....
+func polygonDIDAccount(did string) (network, address string, err error) {
+	rest, ok := strings.CutPrefix(did, "did:polygon:")
+	if !ok {
+		return "", "", fmt.Errorf("%s is not a did:polygon DID", did)
+	}
+	network = "mainnet"
+	if n, addr, ok := strings.Cut(rest, ":"); ok {
+		network, rest = n, addr
+	}
+	if _, err := polygonRPCURL(network); err != nil {
+		return "", "", err
+	}
+	if len(rest) != 42 || !strings.HasPrefix(rest, "0x") {
+		return "", "", fmt.Errorf("%s has no account address", did)
+	}
+	return network, rest, nil
+}
+
+// polygonCall makes a JSON-RPC call returning a hex quantity.
+func polygonCall(network, method string, params ...interface{}) (*big.Int, error) {
+	rpcURL, err := polygonRPCURL(network)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": method, "params": params})
+	resp, err := polygonClient.Post(rpcURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("polygon %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("polygon %s: %w", method, err)
+	}
+	var out struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("polygon %s: HTTP %d", method, resp.StatusCode)
+	}
+	if out.Error != nil {
+		return nil, fmt.Errorf("polygon %s: %s", method, out.Error.Message)
+	}
+	n, ok := new(big.Int).SetString(strings.TrimPrefix(out.Result, "0x"), 16)
+	if !ok {
+		return nil, fmt.Errorf("polygon %s: invalid result %q", method, out.Result)
+	}
+	return n, nil
+}
+
+// GasPreflight compares an account's balance with what an operation needs.
+type GasPreflight struct {
+	Network  string
+	Address  string
+	Balance  *big.Int // wei
+	Required *big.Int // wei
+	GasPrice *big.Int // wei
+}
+
+func (p *GasPreflight) Sufficient() bool { return p.Balance.Cmp(p.Required) >= 0 }
+
+// Warning describes a shortfall, or is empty when the balance suffices.
+func (p *GasPreflight) Warning() string {
+	if p.Sufficient() {
+		return ""
+	}
+	return fmt.Sprintf("%s holds %s POL on %s; about %s POL is needed. Fund the account before continuing.",
+		p.Address, formatPOL(p.Balance), p.Network, formatPOL(p.Required))
+}
+
+// gasPreflight checks an account can pay for an operation of gasLimit gas.
+func gasPreflight(network, address string, gasLimit int64) (*GasPreflight, error) {
+	balance, err := polygonCall(network, "eth_getBalance", address, "latest")
+	if err != nil {
+		return nil, err
+	}
+	price, err := polygonCall(network, "eth_gasPrice")
+	if err != nil {
+		return nil, err
+	}
+	required := new(big.Int).Mul(price, big.NewInt(gasLimit*gasHeadroom))
+	return &GasPreflight{Network: network, Address: address, Balance: balance, Required: required, GasPrice: price}, nil
+}
+
+// formatPOL renders a wei amount in POL.
+func formatPOL(wei *big.Int) string {
+	return new(big.Rat).SetFrac(wei, big.NewInt(1e18)).FloatString(4)
+}
+
+// handlePolygonWallet shows the operational wallet's (POLYGON_WALLET_ADDRESS)
+// balance, warning when it cannot pay for a DID registration.
+func handlePolygonWallet(w http.ResponseWriter, r *http.Request) {
+	if config.PolygonWalletAddress == "" {
+		pages(r).ExecuteTemplate(w, "polygon-wallet", map[string]interface{}{"Unconfigured": true})
+		return
+	}
+	p, err := gasPreflight(config.PolygonNetwork, config.PolygonWalletAddress, didRegistryGas)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "polygon wallet", "err", err)
+		pages(r).ExecuteTemplate(w, "polygon-wallet", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	pages(r).ExecuteTemplate(w, "polygon-wallet", map[string]interface{}{
+		"Preflight": p,
+		"Balance":   formatPOL(p.Balance),
+		"GasPrice":  new(big.Rat).SetFrac(p.GasPrice, big.NewInt(1e9)).FloatString(1),
+	})
+}
@@ -0,0 +1,493 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Student self-service portal. A signed-in student sees every credential
+// stored for their subject DID, can download the credential and its
+// certificate again, and can create fresh share links. For SD-JWT and BBS+
+// credentials the student picks which fields a shared credential or
+// presentation reveals, e.g. to leave out their GPA. Employer verification
+// requests wait here for the student's approval (see verifyrequest.go). Students sign in by
+// proving control of their DID with the holder challenge (see holder.go),
+// or with an emailed magic link (see magiclink.go), which covers every
+// credential sent to their address. Portal sessions are
+// separate from issuance sessions and scoped to /portal.
+
+const (
+	portalCookie     = "student_sid"
+	portalSessionTTL = 8 * time.Hour
+)
+
+// portalSession is signed in either for a subject DID or for an email
+// address, kept as its lookup hash.
+type portalSession struct {
+	subjectDID string
+	emailHash  string
+	label      string // shown to the student: the DID or the masked address
+	expires    time.Time
+}
+
+// holderID identifies the signed-in holder, e.g. for their cloud wallet.
+func (s *portalSession) holderID() string {
+	if s.subjectDID != "" {
+		return "did\x00" + s.subjectDID
+	}
+	return "email\x00" + s.emailHash
+}
+
+func (s *portalSession) owns(cred *StoredCredential) bool {
+	if !cred.ErasedAt.IsZero() {
+		return false
+	}
+	if s.subjectDID != "" {
+		return cred.SubjectID == s.subjectDID
+	}
+	return slices.Contains(cred.EmailHashes, s.emailHash)
+}
+
+// credentials lists the credentials the session can see, newest first.
+func (s *portalSession) credentials() []*StoredCredential {
+	if s.subjectDID != "" {
+		return store.ForSubject(s.subjectDID)
+	}
+	return store.ForEmail(s.emailHash)
+}
+
+var (
+	portalSessions   = make(map[string]*portalSession)
+	portalSessionsMu sync.Mutex
+)
+
+// startPortalSession signs the student in and sets the portal cookie.
+func startPortalSession(w http.ResponseWriter, sess *portalSession) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	portalSessionsMu.Lock()
+	now := time.Now()
+	for sid, s := range portalSessions {
+		if now.After(s.expires) {
+			delete(portalSessions, sid)
+		}
+	}
+	sess.expires = now.Add(portalSessionTTL)
+	portalSessions[id] = sess
+	portalSessionsMu.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     portalCookie,
+		Value:    id,
+		Path:     appPath("/portal"),
+		MaxAge:   int(portalSessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(config.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// endPortalSessions signs subjectDID out of the portal everywhere and
+// returns how many sessions were ended.
+func endPortalSessions(subjectDID string) int {
+	portalSessionsMu.Lock()
+	defer portalSessionsMu.Unlock()
+	n := 0
+	for sid, s := range portalSessions {
+		if s.subjectDID == subjectDID {
+			delete(portalSessions, sid)
+			n++
+		}
+	}
+	return n
+}
+
+// portalStudent returns the signed-in student's session.
+func portalStudent(r *http.Request) (*portalSession, bool) {
+	c, err := r.Cookie(portalCookie)
+	if err != nil {
+		return nil, false
+	}
+	portalSessionsMu.Lock()
+	defer portalSessionsMu.Unlock()
+	s, ok := portalSessions[c.Value]
+	if !ok || time.Now().After(s.expires) {
+		return nil, false
+	}
+	return s, true
+}
+
+// credentialSubjectOf extracts the subject claims of a stored credential,
+// whatever its format. SD-JWT disclosures are merged in.
+func credentialSubjectOf(cred *StoredCredential) (map[string]interface{}, error) {
+	if cred.Encrypted {
+		return nil, fmt.Errorf("credential is encrypted for the holder")
+	}
+	var compact string
+	if err := json.Unmarshal(cred.Credential, &compact); err != nil {
+		var vc struct {
+			Subject map[string]interface{} `json:"credentialSubject"`
+		}
+		if err := json.Unmarshal(cred.Credential, &vc); err != nil {
+			return nil, err
+		}
+		return vc.Subject, nil
+	}
+
+	issuerJWT, disclosures := compact, []Disclosure(nil)
+	if strings.Contains(compact, "~") {
+		var err error
+		if issuerJWT, disclosures, err = splitSDJWT(compact); err != nil {
+			return nil, err
+		}
+	}
+	_, payload, err := decodeJWT(issuerJWT)
+	if err != nil {
+		return nil, err
+	}
+	subject := payload
+	if vc, ok := payload["vc"].(map[string]interface{}); ok {
+		subject, _ = vc["credentialSubject"].(map[string]interface{})
+	} else if cs, ok := payload["credentialSubject"].(map[string]interface{}); ok {
+		subject = cs
+	}
+	if subject == nil {
+		return nil, fmt.Errorf("credential has no subject")
+	}
+	for _, d := range disclosures {
+		subject[d.Claim] = d.Value
+	}
+	return subject, nil
+}
+
+// formFromSubject maps subject claims back to the issuance form fields.
+func formFromSubject(subject map[string]interface{}) CredentialForm {
+	str := func(k string) string {
+		if v, ok := subject[k]; ok && v != nil {
+			return fmt.Sprint(v)
+		}
+		return ""
+	}
+	return CredentialForm{
+		StudentName:    str("name"),
+		Institution:    str("alumniOf"),
+		Degree:         str("degree"),
+		FieldOfStudy:   str("fieldOfStudy"),
+		EnrollmentDate: str("enrollmentDate"),
+		GraduationDate: str("graduationDate"),
+		StudentID:      str("studentId"),
+		GPA:            str("gpa"),
+		Honors:         str("honors"),
+		GPAScale:       str("gpaScale"),
+		GPANormalized:  str("gpaNormalized"),
+		SubjectDID:     str("id"),
+	}
+}
+
+// storedSession rebuilds a session around a stored credential so session
+// artifact builders (PDF, JSON) can run on it. The certificate QR uses
+// compact mode, and is left off if the credential is too large.
+func storedSession(cred *StoredCredential) (*Session, error) {
+	subject, err := credentialSubjectOf(cred)
+	if err != nil {
+		return nil, err
+	}
+	issuer, _ := credentialValidity(&scannedCredential{Format: cred.Format, Credential: cred.Credential})
+	if issuer == "" {
+		issuer = config.IssuerDID
+	}
+	sess := &Session{
+		Form:             formFromSubject(subject),
+		ProofType:        cred.ProofType,
+		Format:           cred.Format,
+		IssuerDID:        issuer,
+		TenantID:         cred.TenantID,
+		QRMode:           QRModeCompact,
+		QROptions:        defaultQROptions(),
+		SignedCredential: cred.Credential,
+		CredentialID:     cred.ID,
+		Verified:         true,
+		CreatedAt:        cred.IssuedAt,
+	}
+	if qr, err := generateQRFor(sess); err == nil {
+		sess.QR = qr
+	}
+	return sess, nil
+}
+
+type portalCredential struct {
+	ID          string
+	Institution string
+	Degree      string
+	Format      string
+	IssuedAt    string
+	HasPDF      bool
+	InWallet    bool
+	Selective
+}
+
+// Selective lists the fields a holder may choose to reveal, and how.
+type Selective struct {
+	SelectiveKind string // "SD-JWT" or "BBS+"; empty if everything is always disclosed
+	Fields        []string
+}
+
+// selectiveFields reports how a credential supports selective disclosure.
+func selectiveFields(format string, credential json.RawMessage) Selective {
+	if format == FormatSDJWT {
+		var compact string
+		if json.Unmarshal(credential, &compact) != nil {
+			return Selective{}
+		}
+		_, disclosures, err := splitSDJWT(compact)
+		if err != nil || len(disclosures) == 0 {
+			return Selective{}
+		}
+		var fields []string
+		for _, d := range disclosures {
+			fields = append(fields, d.Claim)
+		}
+		return Selective{"SD-JWT", fields}
+	}
+	var vc struct {
+		Proof struct {
+			Type string `json:"type"`
+		} `json:"proof"`
+	}
+	if json.Unmarshal(credential, &vc) == nil && vc.Proof.Type == bbsProofType {
+		return Selective{"BBS+", bbsFields(credential)}
+	}
+	return Selective{}
+}
+
+// revealedFields reads the fields ticked in a portal form. It returns nil,
+// meaning everything, unless the form offered a choice.
+func revealedFields(r *http.Request) []string {
+	if r.FormValue("selective") == "" {
+		return nil
+	}
+	return append([]string{}, r.Form["reveal"]...)
+}
+
+// selectivelyDisclose derives a credential revealing only the named
+// fields. A nil reveal list returns the credential unchanged.
+func selectivelyDisclose(ctx context.Context, format string, credential json.RawMessage, reveal []string) (json.RawMessage, error) {
+	if reveal == nil {
+		return credential, nil
+	}
+	switch selectiveFields(format, credential).SelectiveKind {
+	case "SD-JWT":
+		var compact string
+		json.Unmarshal(credential, &compact)
+		presentation, err := presentSDJWT(compact, reveal)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(presentation)
+	case "BBS+":
+		return deriveBBS(ctx, credential, reveal)
+	}
+	return nil, fmt.Errorf("this credential does not support selective disclosure")
+}
+
+// portalCredentials lists the student's stored credentials, newest first.
+func portalCredentials(sess *portalSession) []portalCredential {
+	var out []portalCredential
+	for _, cred := range sess.credentials() {
+		pc := portalCredential{
+			ID:       cred.ID,
+			Format:   cred.Format,
+			IssuedAt: cred.IssuedAt.Format("2 January 2006"),
+		}
+		if subject, err := credentialSubjectOf(cred); err == nil {
+			form := formFromSubject(subject)
+			pc.Institution, pc.Degree, pc.HasPDF = form.Institution, form.Degree, true
+			pc.Selective = selectiveFields(cred.Format, cred.Credential)
+		}
+		out = append(out, pc)
+	}
+	return out
+}
+
+// ownedCredential returns a credential owned by the signed-in student.
+func ownedCredential(r *http.Request) (*StoredCredential, bool) {
+	sess, ok := portalStudent(r)
+	if !ok {
+		return nil, false
+	}
+	cred, ok := store.Get(r.PathValue("id"))
+	if !ok || !sess.owns(cred) {
+		return nil, false
+	}
+	return cred, true
+}
+
+func handlePortal(w http.ResponseWriter, r *http.Request) {
+	sess, ok := portalStudent(r)
+	if !ok {
+		renderPortal(w, r, map[string]interface{}{})
+		return
+	}
+	renderPortal(w, r, portalData(sess))
+}
+
+func portalData(sess *portalSession) map[string]interface{} {
+	credentials := portalCredentials(sess)
+	walletItems, inWallet := portalWallet(sess)
+	for i := range credentials {
+		credentials[i].InWallet = inWallet[credentials[i].ID]
+	}
+	return map[string]interface{}{
+		"SignedIn":     true,
+		"SignedInAs":   sess.label,
+		"Credentials":  credentials,
+		"Wallet":       walletItems,
+		"ShareLinkTTL": liveConfig().ShareLinkTTL,
+
+		"VerifyRequests": verifyRequests.Pending(sess),
+	}
+}
+
+// renderPortalError shows the signed-in portal with an error.
+func renderPortalError(w http.ResponseWriter, r *http.Request, sess *portalSession, msg string) {
+	data := portalData(sess)
+	data["Error"] = msg
+	renderPortal(w, r, data)
+}
+
+func renderPortal(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := pages(r).ExecuteTemplate(w, "portal", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handlePortalLogin signs a student in with a DID-auth proof.
+func handlePortalLogin(w http.ResponseWriter, r *http.Request) {
+	did := strings.TrimSpace(r.FormValue("holderDid"))
+	if did == "" {
+		renderPortal(w, r, map[string]interface{}{"Error": "Enter your wallet DID"})
+		return
+	}
+	if err := bindHolder(did, r.FormValue("holderNonce"), strings.TrimSpace(r.FormValue("holderProof"))); err != nil {
+		slog.ErrorContext(r.Context(), "portal login rejected", "err", err)
+		renderPortal(w, r, map[string]interface{}{"Error": "Sign-in failed: " + err.Error()})
+		return
+	}
+	startPortalSession(w, &portalSession{subjectDID: did, label: did})
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
+
+func handlePortalLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(portalCookie); err == nil {
+		portalSessionsMu.Lock()
+		delete(portalSessions, c.Value)
+		portalSessionsMu.Unlock()
+	}
+	http.SetCookie(w, &http.Cookie{Name: portalCookie, Path: appPath("/portal"), MaxAge: -1})
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
+
+// handlePortalDownload serves a stored credential, or its certificate PDF
+// with ?artifact=pdf.
+func handlePortalDownload(w http.ResponseWriter, r *http.Request) {
+	cred, ok := ownedCredential(r)
+	if !ok {
+		pageNotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if r.URL.Query().Get("artifact") == "pdf" {
+		sess, err := storedSession(cred)
+		if err == nil {
+			var pdf []byte
+			if pdf, err = generatePDF(sess, requestLanguage(r)); err == nil {
+				w.Header().Set("Content-Type", "application/pdf")
+				w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.pdf\"")
+				w.Write(pdf)
+				return
+			}
+		}
+		slog.ErrorContext(r.Context(), "portal PDF error", "err", err)
+		http.Error(w, "Failed to generate the certificate", http.StatusInternalServerError)
+		return
+	}
+
+	body := []byte(cred.Credential)
+	var compact string
+	if err := json.Unmarshal(cred.Credential, &compact); err == nil {
+		body = []byte(compact)
+	}
+	if cred.Encrypted {
+		w.Header().Set("Content-Type", "application/jose")
+	} else {
+		w.Header().Set("Content-Type", credentialMediaType(cred.Format))
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"testa-edu-credential%s\"", credentialFileExt(cred)))
+	w.Write(body)
+}
+
+// handlePortalShare creates a share link for one of the student's
+// credentials.
+func handlePortalShare(w http.ResponseWriter, r *http.Request) {
+	cred, ok := ownedCredential(r)
+	if !ok {
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "Please sign in again."})
+		return
+	}
+	if consent, ok := consents.Get(cred.ConsentID); ok && !consent.Allows(ConsentScopeShare) {
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "Sharing by link was not consented to when this credential was issued."})
+		return
+	}
+	name := r.FormValue("artifact")
+	artifact, ok := shareArtifacts[name]
+	if !ok {
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "Unknown artifact"})
+		return
+	}
+
+	reveal := revealedFields(r)
+	if reveal != nil && name == "pdf" && len(reveal) < len(selectiveFields(cred.Format, cred.Credential).Fields) {
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "The certificate shows every field. Share the credential (JSON) to leave fields out."})
+		return
+	}
+
+	sess, err := storedSession(cred)
+	var content []byte
+	if err == nil && name != "pdf" {
+		sess.SignedCredential, err = selectivelyDisclose(r.Context(), cred.Format, cred.Credential, reveal)
+	}
+	if err == nil {
+		content, err = artifact.Build(sess, requestLanguage(r))
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "portal share", "share", name, "err", err)
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "Failed to build " + artifact.Label})
+		return
+	}
+	link, err := shares.Create(name, cred.SubjectID, content, liveConfig().ShareLinkTTL, r.FormValue("singleUse") != "")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "portal share error", "err", err)
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "Failed to create share link"})
+		return
+	}
+	pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{
+		"Label":     artifact.Label,
+		"URL":       shortenOr(config.PublicURL+"/share/"+link.Token, liveConfig().ShareLinkTTL),
+		"SingleUse": link.SingleUse,
+		"ExpiresAt": link.ExpiresAt,
+	})
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+)
+
+// Presentation download. Several verifier tools only ingest Verifiable
+// Presentations, so /download/presentation.jwt wraps the session's
+// credential in one, as a vp-jwt valid for SHARE_LINK_TTL. Where the
+// portal minted the student's did:key the presentation is signed with it,
+// as the holder; otherwise the issuer signs it, which vouches only that
+// the issuer packaged the credential, not for the holder. SD-JWT
+// credentials are presented with their own disclosures and AnonCreds
+// credentials only from the wallet, so neither has one.
+
+// sessionPresentation returns the session credential's vp-jwt, and
+// whether the holder signed it.
+func sessionPresentation(sess *Session) (string, bool, error) {
+	holder := sess.Form.SubjectDID
+	if _, ok := subjects.Key(holder); ok {
+		_, vp, err := buildPresentation(holder, sess.Format, sess.SignedCredential, liveConfig().ShareLinkTTL)
+		return string(vp), true, err
+	}
+	if sess.Token == "" {
+		return "", false, fmt.Errorf("no agent token; issue the credential first")
+	}
+	vp := newPresentation(holder, sess.SignedCredential)
+	if holder == "" {
+		delete(vp, "holder")
+	}
+	jwt, err := agentClient.SignPresentationJWT(sess.Token, presentationClaims(sess.IssuerDID, vp, liveConfig().ShareLinkTTL),
+		verificationMethodFor(sess.IssuerDID), jwtAlgFor(sess.ProofType))
+	return jwt, false, err
+}
+
+func handleDownloadPresentation(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		http.Error(w, "No credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	if sess.Format == FormatSDJWT || sess.Format == FormatAnonCreds {
+		http.Error(w, "This credential format is not presented as a Verifiable Presentation.", http.StatusNotFound)
+		return
+	}
+
+	vp, _, err := sessionPresentation(sess)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "presentation error", "err", err)
+		http.Error(w, "Failed to sign the presentation", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/jwt")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-presentation.jwt\"")
+	w.Write([]byte(vp))
+}
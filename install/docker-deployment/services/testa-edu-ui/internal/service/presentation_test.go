@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// TestSessionPresentation checks the presentation is signed by the
+// student's minted key when the portal holds it, and by the issuer
+// otherwise, and that it carries the credential unchanged.
+func TestSessionPresentation(t *testing.T) {
+	useKeys(t, bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32))
+	savedSubjects, savedSigner, savedAgent := subjects, localSigner, agentClient
+	t.Cleanup(func() { subjects, localSigner, agentClient = savedSubjects, savedSigner, savedAgent })
+	var err error
+	if subjects, err = NewSubjectStore(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := didKeyEd25519(priv.Public().(ed25519.PublicKey))
+	localSigner = &LocalSigner{did: issuer, key: ed25519Signer(priv), vm: issuer + "#" + strings.TrimPrefix(issuer, "did:key:")}
+	agentClient = NewAgentClient("", "")
+
+	minted, err := subjects.DIDFor(CredentialForm{Institution: "Testa University", StudentID: "S-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credential := json.RawMessage(`{"@context":["https://www.w3.org/ns/credentials/v2"],"id":"urn:uuid:1"}`)
+
+	tests := []struct {
+		name       string
+		holder     string
+		wantHolder bool
+		wantIss    string
+	}{
+		{"minted holder key", minted, true, minted},
+		{"issuer signed", "did:example:amina", false, issuer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sess := &Session{
+				Form:             CredentialForm{SubjectDID: tt.holder},
+				Format:           FormatLDP,
+				IssuerDID:        issuer,
+				ProofType:        "Ed25519Signature2020",
+				Token:            localToken,
+				SignedCredential: credential,
+			}
+			vp, byHolder, err := sessionPresentation(sess)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if byHolder != tt.wantHolder {
+				t.Errorf("signed by holder = %v, want %v", byHolder, tt.wantHolder)
+			}
+			_, claims, err := decodeJWT(vp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims["iss"] != tt.wantIss {
+				t.Errorf("iss = %v, want %s", claims["iss"], tt.wantIss)
+			}
+			body, _ := claims["vp"].(map[string]interface{})
+			if body["holder"] != tt.holder {
+				t.Errorf("holder = %v, want %s", body["holder"], tt.holder)
+			}
+			if ctx, _ := body["@context"].([]interface{}); len(ctx) != 1 || ctx[0] != "https://www.w3.org/ns/credentials/v2" {
+				t.Errorf("@context = %v", body["@context"])
+			}
+			creds, _ := body["verifiableCredential"].([]interface{})
+			if got, _ := json.Marshal(creds); len(creds) != 1 || string(got) != "["+string(credential)+"]" {
+				t.Errorf("verifiableCredential = %s", got)
+			}
+
+			key := priv.Public().(ed25519.PublicKey)
+			if tt.wantHolder {
+				holderKey, _ := subjects.Key(tt.holder)
+				key = holderKey.Public().(ed25519.PublicKey)
+			}
+			i := strings.LastIndex(vp, ".")
+			sig, err := base64.RawURLEncoding.DecodeString(vp[i+1:])
+			if err != nil || !ed25519.Verify(key, []byte(vp[:i]), sig) {
+				t.Error("signature does not verify")
+			}
+		})
+	}
+}
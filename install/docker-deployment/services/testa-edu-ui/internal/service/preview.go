@@ -0,0 +1,177 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// Specimen previews. Before issuing, staff can check how the certificate
+// will lay out: the issuance form's "Preview Certificate" button renders
+// the form's details with the institution's template, stamped "SPECIMEN"
+// across every page. Nothing is signed or stored — the preview has no
+// verification URL or QR code, and is neither made PDF/A nor signed —
+// and the PDF is kept in memory for a few minutes, long enough to view.
+
+const (
+	previewTTL  = 10 * time.Minute
+	maxPreviews = 200
+)
+
+// previewLimiter caps the previews one client can render a minute; each
+// may start a headless Chromium.
+var previewLimiter = newRateLimiter(20, time.Minute)
+
+type specimen struct {
+	pdf     []byte
+	expires time.Time
+}
+
+var (
+	previewsMu sync.Mutex
+	previews   = map[string]specimen{}
+)
+
+// putPreview keeps a rendered specimen and returns its token.
+func putPreview(pdf []byte) (string, error) {
+	previewsMu.Lock()
+	defer previewsMu.Unlock()
+	now := time.Now()
+	for token, p := range previews {
+		if now.After(p.expires) {
+			delete(previews, token)
+		}
+	}
+	if len(previews) >= maxPreviews {
+		return "", fmt.Errorf("too many previews; try again in a few minutes")
+	}
+	token := newSessionID()
+	previews[token] = specimen{pdf: pdf, expires: now.Add(previewTTL)}
+	return token, nil
+}
+
+func getPreview(token string) ([]byte, bool) {
+	previewsMu.Lock()
+	defer previewsMu.Unlock()
+	p, ok := previews[token]
+	if !ok || time.Now().After(p.expires) {
+		return nil, false
+	}
+	return p.pdf, true
+}
+
+// specimenPDF renders a session's certificate, unsigned, with a SPECIMEN
+// watermark.
+func specimenPDF(sess *Session, lang string) ([]byte, error) {
+	mark := translator(lang)("SPECIMEN")
+	if liveConfig().PDFRenderer == PDFRendererHTML {
+		page, err := certificateHTML(sess, lang)
+		if err == nil {
+			var pdf []byte
+			if pdf, err = htmlToPDF(watermarkHTML(page, mark)); err == nil {
+				return pdf, nil
+			}
+		}
+		slog.Warn("certificate template; using the built-in layout", "err", err)
+	}
+	pdf, err := layoutCertificates([]*Session{sess}, lang, false)
+	if err != nil {
+		return nil, err
+	}
+	watermarkPDF(pdf, mark)
+	return outputPDF(pdf)
+}
+
+// watermarkHTML overlays mark on a certificate page.
+func watermarkHTML(page []byte, mark string) []byte {
+	div := `<div style="position:fixed;top:50%;left:50%;transform:translate(-50%,-50%) rotate(-35deg);` +
+		`font:bold 120px sans-serif;letter-spacing:0.1em;color:rgba(220,38,38,0.2);` +
+		`pointer-events:none;z-index:2147483647;white-space:nowrap">` + html.EscapeString(mark) + `</div>`
+	i := bytes.LastIndex(bytes.ToLower(page), []byte("</body>"))
+	if i < 0 {
+		return append(page, div...)
+	}
+	out := make([]byte, 0, len(page)+len(div))
+	out = append(out, page[:i]...)
+	out = append(out, div...)
+	return append(out, page[i:]...)
+}
+
+// watermarkPDF stamps mark diagonally across every page of an A4 document.
+func watermarkPDF(pdf *fpdf.Fpdf, mark string) {
+	enc := pdf.UnicodeTranslatorFromDescriptor("")
+	mark = enc(mark)
+	for i := 1; i <= pdf.PageCount(); i++ {
+		pdf.SetPage(i)
+		pdf.SetFont("Helvetica", "B", 90)
+		pdf.SetTextColor(220, 38, 38)
+		pdf.SetAlpha(0.2, "Normal")
+		pdf.TransformBegin()
+		pdf.TransformRotate(35, 105, 148.5)
+		pdf.Text(105-pdf.GetStringWidth(mark)/2, 148.5+10, mark)
+		pdf.TransformEnd()
+		pdf.SetAlpha(1, "Normal")
+	}
+}
+
+type certificatePreview struct {
+	URL   string
+	Error string
+}
+
+// handleCertificatePreview renders a specimen of the issuance form's
+// certificate.
+func handleCertificatePreview(w http.ResponseWriter, r *http.Request) {
+	if !previewLimiter.Allow(clientIP(r)) {
+		renderFragment(w, r, "certificate-preview", certificatePreview{Error: "Too many previews; try again in a minute"})
+		return
+	}
+	form, err := issueForm(r.FormValue)
+	if err != nil {
+		renderFragment(w, r, "certificate-preview", certificatePreview{Error: err.Error()})
+		return
+	}
+	tenant, err := formTenant(r)
+	if err != nil {
+		renderFragment(w, r, "certificate-preview", certificatePreview{Error: err.Error()})
+		return
+	}
+	sess := &Session{Form: form, CreatedAt: time.Now()}
+	if tenant != nil {
+		sess.IssuerDID, sess.TenantID = tenant.Issuer(), tenant.ID
+		sess.Form.Institution = tenant.Name
+	}
+
+	pdf, err := specimenPDF(sess, requestLanguage(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "preview PDF error", "err", err)
+		renderFragment(w, r, "certificate-preview", certificatePreview{Error: "Failed to generate the preview"})
+		return
+	}
+	token, err := putPreview(pdf)
+	if err != nil {
+		renderFragment(w, r, "certificate-preview", certificatePreview{Error: err.Error()})
+		return
+	}
+	renderFragment(w, r, "certificate-preview", certificatePreview{URL: appPath("/preview/" + token + ".pdf")})
+}
+
+func handlePreviewFile(w http.ResponseWriter, r *http.Request) {
+	token, ok := strings.CutSuffix(r.PathValue("file"), ".pdf")
+	pdf, found := getPreview(token)
+	if !ok || !found {
+		renderErrorPage(w, r, http.StatusNotFound, "This preview has expired. Please preview the certificate again.")
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "inline; filename=\"testa-edu-specimen.pdf\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pdf)
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestWatermarkHTML(t *testing.T) {
+	got := string(watermarkHTML([]byte("<html><body><p>Cheti</p></BODY></html>"), "<SPECIMEN>"))
+	if !strings.Contains(got, "&lt;SPECIMEN&gt;</div></BODY></html>") {
+		t.Errorf("watermark not placed before </body>: %s", got)
+	}
+	if got := string(watermarkHTML([]byte("<p>Cheti</p>"), "SPECIMEN")); !strings.HasSuffix(got, "SPECIMEN</div>") {
+		t.Errorf("watermark not appended: %s", got)
+	}
+}
+
+// TestCertificatePreview checks a preview renders a watermarked specimen
+// from the form without storing a credential.
+func TestCertificatePreview(t *testing.T) {
+	useCohortStore(t)
+	saved, savedLive := config, current.Load()
+	t.Cleanup(func() {
+		config = saved
+		current.Store(savedLive)
+	})
+	config.PDFRenderer, config.DefaultLanguage = PDFRendererBuiltin, "en"
+	if err := initLanguages(); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{"studentName": {"Amina Njeri"}, "institution": {"Testa University"}, "degree": {"Bachelor of Science"}}
+	req := httptest.NewRequest("POST", "/preview/certificate", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	handleCertificatePreview(rec, req)
+	link := regexp.MustCompile(`/preview/[0-9a-f]+\.pdf`).FindString(rec.Body.String())
+	if link == "" {
+		t.Fatalf("no preview link in %s", rec.Body.String())
+	}
+	if n := len(store.ForTenant("")); n != 0 {
+		t.Errorf("preview stored %d credentials", n)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /preview/{file}", handlePreviewFile)
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", link, nil))
+	pdf := rec.Body.Bytes()
+	if rec.Code != http.StatusOK || !bytes.HasPrefix(pdf, []byte("%PDF-")) {
+		t.Fatalf("preview file: %d %.40q", rec.Code, pdf)
+	}
+	if !bytes.Contains(pdf, []byte("/ca 0.2")) {
+		t.Error("specimen has no translucent watermark")
+	}
+	if bytes.Contains(pdf, []byte("/ByteRange")) {
+		t.Error("specimen is signed")
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/preview/unknown.pdf", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown preview: got %d, want 404", rec.Code)
+	}
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ProofSuite describes a Linked Data proof suite the agent can sign with.
+type ProofSuite struct {
+	Type string `json:"type"`
+	// Context is the suite context that must appear in the credential's
+	// @context for the proof to be verifiable; empty when the suite's terms
+	// are already defined by the credentials v1 context.
+	Context string `json:"context,omitempty"`
+}
+
+var proofSuites = map[string]ProofSuite{
+	"EcdsaSecp256k1Signature2019": {
+		Type: "EcdsaSecp256k1Signature2019",
+	},
+	"EcdsaSecp256k1RecoverySignature2020": {
+		Type:    "EcdsaSecp256k1RecoverySignature2020",
+		Context: "https://w3id.org/security/suites/secp256k1recovery-2020/v2",
+	},
+	"Ed25519Signature2020": {
+		Type:    "Ed25519Signature2020",
+		Context: "https://w3id.org/security/suites/ed25519-2020/v1",
+	},
+	"BbsBlsSignature2020": {
+		Type:    "BbsBlsSignature2020",
+		Context: "https://w3id.org/security/bbs/v1",
+	},
+	"JsonWebSignature2020": {
+		Type:    "JsonWebSignature2020",
+		Context: "https://w3id.org/security/suites/jws-2020/v1",
+	},
+}
+
+const defaultProofType = "EcdsaSecp256k1Signature2019"
+
+// W3C VC Data Model versions selectable with VC_VERSION.
+const (
+	vcdm1 = "1.1"
+	vcdm2 = "2.0"
+)
+
+// cryptosuiteFor maps a legacy proof suite to the Data Integrity
+// cryptosuite used for the same key type under VCDM 2.0.
+func cryptosuiteFor(proofType string) string {
+	switch proofType {
+	case "Ed25519Signature2020":
+		return "eddsa-rdfc-2022"
+	case "BbsBlsSignature2020":
+		return "bbs-2023"
+	default:
+		return "ecdsa-rdfc-2019"
+	}
+}
+
+// parseProofTypes parses PROOF_TYPES, a comma-separated list of
+// "<issuer DID>=<proof type>" pairs.
+func parseProofTypes(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		// DIDs contain colons but never "=", so split on the last "=".
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid PROOF_TYPES entry %q, want did=ProofType", pair)
+		}
+		did, pt := pair[:i], pair[i+1:]
+		if _, ok := proofSuites[pt]; !ok {
+			return nil, fmt.Errorf("unsupported proof type %q for %s", pt, did)
+		}
+		m[did] = pt
+	}
+	return m, nil
+}
+
+// proofTypeFor returns the proof type configured for an issuer DID, falling
+// back to the deployment default.
+func proofTypeFor(issuerDID string) string {
+	if pt, ok := config.ProofTypes[issuerDID]; ok {
+		return pt
+	}
+	return config.ProofType
+}
+
+// parseVerificationMethods parses VERIFICATION_METHODS, a comma-separated
+// list of "<issuer DID>=<verification method>" pairs naming the key each
+// agent-held DID signs with, as a full DID URL or a "#fragment".
+func parseVerificationMethods(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		did, vm, ok := strings.Cut(pair, "=")
+		if !ok || did == "" {
+			return nil, fmt.Errorf("invalid VERIFICATION_METHODS entry %q, want did=#fragment", pair)
+		}
+		if strings.HasPrefix(vm, "#") {
+			vm = did + vm
+		}
+		if !strings.HasPrefix(vm, did+"#") {
+			return nil, fmt.Errorf("verification method %q does not belong to %s", vm, did)
+		}
+		m[did] = vm
+	}
+	return m, nil
+}
+
+// verificationMethodFor returns the verification method new credentials
+// from an issuer DID are signed with: the active local key, the configured
+// method, or the agent's conventional "#key-1".
+func verificationMethodFor(issuerDID string) string {
+	if localSigner != nil && issuerDID == localSigner.did {
+		_, vm := localSigner.current()
+		return vm
+	}
+	if vm, ok := config.VerificationMethods[issuerDID]; ok {
+		return vm
+	}
+	return issuerDID + "#key-1"
+}
+
+// resolveProofType validates a proof type requested by the user, using the
+// issuer's configured type when none was requested.
+func resolveProofType(requested, issuerDID string) (string, error) {
+	if requested == "" {
+		return proofTypeFor(issuerDID), nil
+	}
+	if _, ok := proofSuites[requested]; !ok {
+		return "", fmt.Errorf("unsupported proof type %q", requested)
+	}
+	if !currentAgentCapabilities().canSign(issuerDID, requested) {
+		return "", fmt.Errorf("the agent cannot sign %s proofs as %s", requested, issuerDID)
+	}
+	return requested, nil
+}
+
+func supportedProofTypes() []string {
+	types := make([]string, 0, len(proofSuites))
+	for t := range proofSuites {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// offeredProofTypes lists the proof types the issuance form offers: those
+// the agent can sign, when that is known.
+func offeredProofTypes() []string {
+	agentTypes := currentAgentCapabilities().ProofTypes()
+	if agentTypes == nil {
+		return supportedProofTypes()
+	}
+	var types []string
+	for _, t := range supportedProofTypes() {
+		if slices.Contains(agentTypes, t) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// handleProofTypes lists supported proof suites and the one each configured
+// issuer DID signs with by default. "default" is the proof type of
+// PROOF_TYPE, "defaultIssuer" the DID used when the form names none.
+func handleProofTypes(w http.ResponseWriter, r *http.Request) {
+	issuers := map[string]string{}
+	for did, pt := range config.ProofTypes {
+		issuers[did] = pt
+	}
+	for _, iss := range issuerOptions() {
+		issuers[iss.DID] = proofTypeFor(iss.DID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"supported":     supportedProofTypes(),
+		"default":       config.ProofType,
+		"defaultIssuer": config.IssuerDID,
+		"issuers":       issuers,
+	})
+}
@@ -0,0 +1,398 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+)
+
+// QR modes. The default packs JSON-LD credentials with JSON-XT and wraps
+// them with PixelPass for Inji Verify; compact mode deflates the whole
+// signed credential and base45-encodes it so the QR can use alphanumeric
+// mode, in the style of SMART Health Cards and EU DCC. CBOR mode encodes
+// the credential as a CWT Claims Set and uses PixelPass framing (zlib +
+// base45), which the verification adapter decodes. Link mode encodes only
+// a retrieval URL for the stored credential.
+const (
+	QRModePixelPass = "pixelpass"
+	QRModeCompact   = "compact"
+	QRModeCBOR      = "cbor"
+	QRModeLink      = "link"
+)
+
+// compactQRPrefix marks compact-mode QR payloads.
+const compactQRPrefix = "EDU1:"
+
+// QROptions controls how QR codes are drawn. Defaults come from config
+// (QR_ERROR_CORRECTION, QR_MODULE_SIZE, QR_QUIET_ZONE, QR_LOGO) and can be
+// overridden per issuance.
+type QROptions struct {
+	ErrorCorrection string // L, M, Q or H
+	ModuleSize      int    // pixels per module; 0 fits the code to the target size
+	QuietZone       int    // modules of white border
+	Logo            bool   // overlay QR_LOGO in the centre
+}
+
+// defaultQROptions returns the configured QR options; the logo is on by
+// default whenever one is configured.
+func defaultQROptions() QROptions {
+	return QROptions{
+		ErrorCorrection: liveConfig().QRErrorCorrection,
+		ModuleSize:      liveConfig().QRModuleSize,
+		QuietZone:       liveConfig().QRQuietZone,
+		Logo:            liveConfig().QRLogo != "",
+	}
+}
+
+var qrLevels = map[string]qr.ErrorCorrectionLevel{"L": qr.L, "M": qr.M, "Q": qr.Q, "H": qr.H}
+
+// parseQROptions validates raw option values, filling blanks from def.
+func parseQROptions(ec, moduleSize, quietZone string, logo bool, def QROptions) (QROptions, error) {
+	opts := def
+	opts.Logo = logo
+	if ec != "" {
+		opts.ErrorCorrection = strings.ToUpper(ec)
+	}
+	if _, ok := qrLevels[opts.ErrorCorrection]; !ok {
+		return opts, fmt.Errorf("QR error correction must be L, M, Q or H")
+	}
+	if moduleSize != "" {
+		n, err := strconv.Atoi(moduleSize)
+		if err != nil || n < 0 || n > 40 {
+			return opts, fmt.Errorf("QR module size must be between 0 and 40 pixels")
+		}
+		opts.ModuleSize = n
+	}
+	if quietZone != "" {
+		n, err := strconv.Atoi(quietZone)
+		if err != nil || n < 0 || n > 20 {
+			return opts, fmt.Errorf("QR quiet zone must be between 0 and 20 modules")
+		}
+		opts.QuietZone = n
+	}
+	if opts.Logo && liveConfig().QRLogo == "" {
+		return opts, fmt.Errorf("no QR logo is configured")
+	}
+	// A logo hides part of the symbol; only the higher correction levels
+	// recover from that reliably.
+	if opts.Logo && (opts.ErrorCorrection == "L" || opts.ErrorCorrection == "M") {
+		opts.ErrorCorrection = "H"
+	}
+	return opts, nil
+}
+
+func validQRMode(mode string) bool {
+	switch mode {
+	case QRModePixelPass, QRModeCompact, QRModeCBOR, QRModeLink:
+		return true
+	}
+	return false
+}
+
+type QRResult struct {
+	JSONXTUri   string `json:"jsonxtUri"`
+	QRData      string `json:"qrData"`
+	QRPngBase64 string `json:"qrPngBase64"`
+	Sizes       struct {
+		JSONLD int `json:"jsonld"`
+		JSONXT int `json:"jsonxt"`
+		QRData int `json:"qrData"`
+		QRPng  int `json:"qrPng"`
+	} `json:"sizes"`
+
+	// Set when QRData exceeds QR_MAX_CHARS and is split across frames.
+	Frames      []string `json:"-"`
+	FramePNGs   [][]byte `json:"-"`
+	AnimatedGIF []byte   `json:"-"`
+}
+
+// generateQRFor builds the session's QR in its chosen mode, splitting it
+// into animated frames when it is too large for a single code.
+func generateQRFor(sess *Session) (*QRResult, error) {
+	defer qrGenerationSeconds.since(time.Now())
+	payload, err := deliveryPayload(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	var result *QRResult
+	switch sess.QRMode {
+	case QRModeCompact:
+		result, err = generateCompactQR(payload, sess.QROptions)
+	case QRModeCBOR:
+		result, err = generateCBORQR(sess.SignedCredential, sess.Form, sess.QROptions)
+	case QRModeLink:
+		result, err = generateLinkQR(sess)
+	default:
+		result, err = generateQR(payload, sess.QROptions)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.QRData) > liveConfig().QRMaxChars {
+		if err := frameQR(result, liveConfig().QRMaxChars, sess.QROptions); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+	if sess.QROptions.Logo {
+		if err := overlayQRLogo(result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func generateQR(signedCredential json.RawMessage, opts QROptions) (*QRResult, error) {
+	scriptPath := filepath.Join(config.ScriptsDir, "qr-encode.js")
+	cmd := exec.Command(config.NodeBin, scriptPath)
+	cmd.Stdin = bytes.NewReader(signedCredential)
+	cmd.Dir = config.ScriptsDir
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("QR_MAX_CHARS=%d", liveConfig().QRMaxChars),
+		"QR_ERROR_CORRECTION="+opts.ErrorCorrection,
+		fmt.Sprintf("QR_MODULE_SIZE=%d", opts.ModuleSize),
+		fmt.Sprintf("QR_QUIET_ZONE=%d", opts.QuietZone),
+	)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		errMsg := stderr.String()
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return nil, fmt.Errorf("QR generation failed: %s", errMsg)
+	}
+
+	var result QRResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		return nil, fmt.Errorf("parsing QR result: %w", err)
+	}
+
+	return &result, nil
+}
+
+// renderQRPNG encodes text as a square QR code PNG in-process, for payloads
+// that do not go through the Node JSON-XT pipeline.
+func renderQRPNG(text string, size int, opts QROptions) ([]byte, error) {
+	code, err := qrImage(text, size, opts)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, code); err != nil {
+		return nil, fmt.Errorf("encoding PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// qrMatrix encodes text as an unscaled QR code, one pixel per module.
+func qrMatrix(text string, opts QROptions) (barcode.Barcode, error) {
+	level, ok := qrLevels[opts.ErrorCorrection]
+	if !ok {
+		level = qr.M
+	}
+	code, err := qr.Encode(text, level, qr.Auto)
+	if err != nil {
+		return nil, fmt.Errorf("encoding QR: %w", err)
+	}
+	return code, nil
+}
+
+func qrDark(code barcode.Barcode, x, y int) bool {
+	r, _, _, _ := code.At(x, y).RGBA()
+	return r == 0
+}
+
+// qrImage draws text as a QR code with opts' quiet zone. With no fixed
+// module size, modules are sized so the image is at most size pixels wide.
+func qrImage(text string, size int, opts QROptions) (*image.Paletted, error) {
+	code, err := qrMatrix(text, opts)
+	if err != nil {
+		return nil, err
+	}
+	scale := opts.ModuleSize
+	if scale == 0 {
+		scale = max(1, size/(code.Bounds().Dx()+2*opts.QuietZone))
+	}
+	return drawModules(code, scale, opts.QuietZone), nil
+}
+
+// drawModules renders an unscaled 2D barcode at scale pixels per module
+// with a quiet zone of quiet modules on every side.
+func drawModules(code barcode.Barcode, scale, quiet int) *image.Paletted {
+	w, h := code.Bounds().Dx(), code.Bounds().Dy()
+	img := image.NewPaletted(image.Rect(0, 0, (w+2*quiet)*scale, (h+2*quiet)*scale), color.Palette{color.White, color.Black})
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !qrDark(code, x, y) {
+				continue
+			}
+			x0, y0 := (x+quiet)*scale, (y+quiet)*scale
+			for dy := 0; dy < scale; dy++ {
+				for dx := 0; dx < scale; dx++ {
+					img.SetColorIndex(x0+dx, y0+dy, 1)
+				}
+			}
+		}
+	}
+	return img
+}
+
+// generateCompactQR renders the compact payload as the session QR.
+func generateCompactQR(signedCredential json.RawMessage, opts QROptions) (*QRResult, error) {
+	data, inputLen, err := compactPayload(signedCredential)
+	if err != nil {
+		return nil, err
+	}
+	return inProcessQR(data, inputLen, opts)
+}
+
+// compactPayload deflates the signed credential (raw DEFLATE, as in SMART
+// Health Cards) and base45-encodes it behind compactQRPrefix. JWT and
+// SD-JWT credentials are compressed as their compact serialization. It
+// also returns the size of the credential before compression.
+func compactPayload(signedCredential json.RawMessage) (string, int, error) {
+	var payload []byte
+	var compact string
+	if err := json.Unmarshal(signedCredential, &compact); err == nil {
+		payload = []byte(compact)
+	} else {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, signedCredential); err != nil {
+			return "", 0, fmt.Errorf("compacting credential: %w", err)
+		}
+		payload = buf.Bytes()
+	}
+
+	var deflated bytes.Buffer
+	zw, err := flate.NewWriter(&deflated, flate.BestCompression)
+	if err != nil {
+		return "", 0, err
+	}
+	zw.Write(payload)
+	if err := zw.Close(); err != nil {
+		return "", 0, fmt.Errorf("deflating credential: %w", err)
+	}
+
+	return compactQRPrefix + base45Encode(deflated.Bytes()), len(payload), nil
+}
+
+// generateCBORQR encodes the credential's CWT Claims Set with PixelPass
+// framing: zlib-compressed, then base45.
+func generateCBORQR(signedCredential json.RawMessage, form CredentialForm, opts QROptions) (*QRResult, error) {
+	claims, err := encodeCredentialCBOR(signedCredential, form)
+	if err != nil {
+		return nil, err
+	}
+
+	var compressed bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&compressed, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	zw.Write(claims)
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("compressing CBOR: %w", err)
+	}
+
+	return inProcessQR(base45Encode(compressed.Bytes()), len(claims), opts)
+}
+
+// inProcessQR renders qrData as the session QR without the Node pipeline.
+// inputLen is the size of the encoded credential before compression.
+// Oversized data is left unrendered for generateQRFor to frame.
+func inProcessQR(qrData string, inputLen int, opts QROptions) (*QRResult, error) {
+	result := &QRResult{QRData: qrData}
+	result.Sizes.JSONLD = inputLen
+	result.Sizes.QRData = len(qrData)
+	if len(qrData) > liveConfig().QRMaxChars {
+		return result, nil
+	}
+
+	png, err := renderQRPNG(qrData, 1024, opts)
+	if err != nil {
+		return nil, fmt.Errorf("QR generation failed: %w", err)
+	}
+	result.QRPngBase64 = base64.StdEncoding.EncodeToString(png)
+	result.Sizes.QRPng = len(png)
+	return result, nil
+}
+
+const base45Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// base45Encode implements RFC 9285.
+func base45Encode(data []byte) string {
+	out := make([]byte, 0, (len(data)+1)/2*3)
+	for i := 0; i+1 < len(data); i += 2 {
+		n := int(data[i])<<8 | int(data[i+1])
+		out = append(out, base45Alphabet[n%45], base45Alphabet[n/45%45], base45Alphabet[n/2025])
+	}
+	if len(data)%2 == 1 {
+		n := int(data[len(data)-1])
+		out = append(out, base45Alphabet[n%45], base45Alphabet[n/45])
+	}
+	return string(out)
+}
+
+// base45Decode reverses base45Encode.
+func base45Decode(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s)/3*2+1)
+	digit := func(i int) (int, error) {
+		n := strings.IndexByte(base45Alphabet, s[i])
+		if n < 0 {
+			return 0, fmt.Errorf("invalid base45 character %q", s[i])
+		}
+		return n, nil
+	}
+	for i := 0; i < len(s); i += 3 {
+		if len(s)-i == 1 {
+			return nil, fmt.Errorf("invalid base45 length")
+		}
+		c, err := digit(i)
+		if err != nil {
+			return nil, err
+		}
+		d, err := digit(i + 1)
+		if err != nil {
+			return nil, err
+		}
+		n := c + d*45
+		if len(s)-i == 2 {
+			if n > 0xff {
+				return nil, fmt.Errorf("invalid base45 data")
+			}
+			out = append(out, byte(n))
+			break
+		}
+		e, err := digit(i + 2)
+		if err != nil {
+			return nil, err
+		}
+		n += e * 2025
+		if n > 0xffff {
+			return nil, fmt.Errorf("invalid base45 data")
+		}
+		out = append(out, byte(n>>8), byte(n))
+	}
+	return out, nil
+}
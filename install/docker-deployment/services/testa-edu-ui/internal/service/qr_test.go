@@ -0,0 +1,92 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+)
+
+// TestBase45 checks the RFC 9285 examples in both directions.
+func TestBase45(t *testing.T) {
+	tests := []struct{ data, encoded string }{
+		{"", ""},
+		{"AB", "BB8"},
+		{"Hello!!", "%69 VD92EX0"},
+		{"base-45", "UJCLQE7W581"},
+		{"ietf!", "QED8WEX0"},
+		{"\xff\xff", "FGW"},
+	}
+	for _, tt := range tests {
+		if got := base45Encode([]byte(tt.data)); got != tt.encoded {
+			t.Errorf("base45Encode(%q) = %q, want %q", tt.data, got, tt.encoded)
+		}
+		got, err := base45Decode(tt.encoded)
+		if err != nil || string(got) != tt.data {
+			t.Errorf("base45Decode(%q) = %q, %v, want %q", tt.encoded, got, err, tt.data)
+		}
+	}
+}
+
+// TestBase45DecodeInvalid checks that malformed input is rejected rather
+// than wrapped around.
+func TestBase45DecodeInvalid(t *testing.T) {
+	for _, s := range []string{
+		"GGW",  // 65536, past two bytes
+		"GGW0", // trailing single character
+		"B",    // single character
+		":~",   // outside the alphabet
+		"bb8",  // lower case is not in the alphabet
+		"HA",   // 360, past one byte
+	} {
+		if got, err := base45Decode(s); err == nil {
+			t.Errorf("base45Decode(%q) = %q, want error", s, got)
+		}
+	}
+}
+
+// TestCompactPayload checks that compact QR data is the prefixed base45 of
+// the raw-DEFLATEd credential, with JSON credentials compacted and JWTs
+// carried as their compact serialization.
+func TestCompactPayload(t *testing.T) {
+	tests := []struct {
+		name, credential, want string
+	}{
+		{"JSON-LD", "{\n  \"type\": [\"VerifiableCredential\"],\n  \"issuer\": \"did:example:issuer\"\n}", `{"type":["VerifiableCredential"],"issuer":"did:example:issuer"}`},
+		{"JWT", `"eyJhbGciOiJFZERTQSJ9.e30.c2ln"`, "eyJhbGciOiJFZERTQSJ9.e30.c2ln"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, n, err := compactPayload(json.RawMessage(tt.credential))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.want) {
+				t.Errorf("input size = %d, want %d", n, len(tt.want))
+			}
+			if !strings.HasPrefix(data, compactQRPrefix) {
+				t.Fatalf("%q lacks prefix %q", data, compactQRPrefix)
+			}
+			if strings.Trim(data, base45Alphabet) != "" {
+				t.Errorf("%q is not QR alphanumeric", data)
+			}
+			deflated, err := base45Decode(strings.TrimPrefix(data, compactQRPrefix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(flate.NewReader(bytes.NewReader(deflated)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("inflated = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, _, err := compactPayload(json.RawMessage(`{"type":`)); err == nil {
+		t.Error("truncated credential accepted")
+	}
+}
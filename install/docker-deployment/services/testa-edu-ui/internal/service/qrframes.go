@@ -0,0 +1,150 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"log/slog"
+	"net/http"
+)
+
+// Credentials whose QR data exceeds QR_MAX_CHARS are split across several
+// QR frames, shown as an animated GIF and downloadable as a frame sequence.
+// Each frame carries a header so scanners can reassemble the payload in any
+// order:
+//
+//	EDUQR:<set>:<index>/<total>:<chunk>
+//
+// where <set> is a random 4-character tag shared by all frames of one
+// credential and <index> is 1-based. The header uses only QR alphanumeric
+// characters so base45 payloads keep the compact encoding mode.
+const (
+	qrFramePrefix   = "EDUQR:"
+	qrFrameSetChars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	qrFrameSize     = 512
+	qrFrameDelay    = 40 // hundredths of a second
+)
+
+// splitQRFrames cuts data into frames of at most maxChars including the
+// frame header.
+func splitQRFrames(data string, maxChars int) ([]string, error) {
+	set := make([]byte, 4)
+	rand.Read(set)
+	for i := range set {
+		set[i] = qrFrameSetChars[int(set[i])%len(qrFrameSetChars)]
+	}
+
+	// Size the header for the worst case (three-digit index and total).
+	chunk := maxChars - len(qrFramePrefix) - len(set) - len(":999/999:")
+	if chunk < 64 {
+		return nil, fmt.Errorf("QR_MAX_CHARS %d is too small for framed QR codes", maxChars)
+	}
+	total := (len(data) + chunk - 1) / chunk
+	if total > 999 {
+		return nil, fmt.Errorf("credential needs %d QR frames", total)
+	}
+
+	frames := make([]string, 0, total)
+	for i := 0; i < total; i++ {
+		end := min((i+1)*chunk, len(data))
+		frames = append(frames, fmt.Sprintf("%s%s:%d/%d:%s", qrFramePrefix, set, i+1, total, data[i*chunk:end]))
+	}
+	return frames, nil
+}
+
+// frameQR fills in the frame images for an oversized QR result: one PNG per
+// frame, the animated GIF, and the first frame as the primary PNG. Frames
+// never carry a logo, since every frame must scan on the first pass.
+func frameQR(result *QRResult, maxChars int, opts QROptions) error {
+	frames, err := splitQRFrames(result.QRData, maxChars)
+	if err != nil {
+		return err
+	}
+
+	opts.Logo = false
+	images := make([]*image.Paletted, len(frames))
+	side := 0
+	for i, frame := range frames {
+		img, err := qrImage(frame, qrFrameSize, opts)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i+1, err)
+		}
+		images[i] = img
+		side = max(side, img.Bounds().Dx())
+	}
+
+	// The last frame may need a smaller QR version; centre every frame on
+	// a canvas of the same size so the animation does not jump.
+	anim := &gif.GIF{}
+	result.Frames = frames
+	result.FramePNGs = make([][]byte, len(frames))
+	for i, code := range images {
+		img := image.NewPaletted(image.Rect(0, 0, side, side), code.Palette)
+		off := (side - code.Bounds().Dx()) / 2
+		draw.Draw(img, code.Bounds().Add(image.Pt(off, off)), code, image.Point{}, draw.Src)
+
+		var pngBuf bytes.Buffer
+		if err := png.Encode(&pngBuf, img); err != nil {
+			return fmt.Errorf("frame %d: %w", i+1, err)
+		}
+		result.FramePNGs[i] = pngBuf.Bytes()
+		anim.Image = append(anim.Image, img)
+		anim.Delay = append(anim.Delay, qrFrameDelay)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		return fmt.Errorf("encoding animated QR: %w", err)
+	}
+	result.AnimatedGIF = buf.Bytes()
+	result.QRPngBase64 = base64.StdEncoding.EncodeToString(result.FramePNGs[0])
+	result.Sizes.QRPng = len(result.FramePNGs[0])
+	return nil
+}
+
+func handleDownloadQRGIF(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil || sess.QR.AnimatedGIF == nil {
+		http.Error(w, "No animated QR code available for this credential.", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential-qr.gif\"")
+	w.Write(sess.QR.AnimatedGIF)
+}
+
+func handleDownloadQRFrames(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil || len(sess.QR.FramePNGs) == 0 {
+		http.Error(w, "No QR frame sequence available for this credential.", http.StatusNotFound)
+		return
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i, frame := range sess.QR.FramePNGs {
+		f, err := zw.Create(fmt.Sprintf("frame-%03d-of-%03d.png", i+1, len(sess.QR.FramePNGs)))
+		if err == nil {
+			_, err = f.Write(frame)
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "QR frames error", "err", err)
+			http.Error(w, "Failed to package QR frames", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := zw.Close(); err != nil {
+		http.Error(w, "Failed to package QR frames", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential-qr-frames.zip\"")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+	"sync"
+)
+
+// Optional centre logo for the credential QR, loaded from QR_LOGO (PNG or
+// JPEG). It covers qrLogoFraction of the symbol's width on a white pad, so
+// parseQROptions raises error correction to H whenever a logo is used.
+
+const qrLogoFraction = 0.22
+
+var (
+	qrLogoOnce sync.Once
+	qrLogoImg  image.Image
+	qrLogoRaw  []byte
+	qrLogoErr  error
+)
+
+func loadQRLogo() (image.Image, []byte, error) {
+	qrLogoOnce.Do(func() {
+		qrLogoRaw, qrLogoErr = os.ReadFile(liveConfig().QRLogo)
+		if qrLogoErr != nil {
+			qrLogoErr = fmt.Errorf("reading QR_LOGO: %w", qrLogoErr)
+			return
+		}
+		qrLogoImg, _, qrLogoErr = image.Decode(bytes.NewReader(qrLogoRaw))
+		if qrLogoErr != nil {
+			qrLogoErr = fmt.Errorf("decoding QR_LOGO: %w", qrLogoErr)
+		}
+	})
+	return qrLogoImg, qrLogoRaw, qrLogoErr
+}
+
+// overlayQRLogo draws the logo over the centre of the result's PNG.
+func overlayQRLogo(result *QRResult) error {
+	logo, _, err := loadQRLogo()
+	if err != nil {
+		return err
+	}
+	raw, err := base64.StdEncoding.DecodeString(result.QRPngBase64)
+	if err != nil {
+		return fmt.Errorf("decoding QR PNG: %w", err)
+	}
+	code, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("decoding QR PNG: %w", err)
+	}
+
+	img := image.NewRGBA(code.Bounds())
+	draw.Draw(img, img.Bounds(), code, code.Bounds().Min, draw.Src)
+
+	side := img.Bounds().Dx()
+	box := int(float64(side) * qrLogoFraction)
+	at := (side - box) / 2
+	pad := image.Rect(at, at, at+box, at+box)
+	draw.Draw(img, pad, image.NewUniform(color.White), image.Point{}, draw.Src)
+
+	// Fit the logo inside the pad, keeping its aspect ratio, with
+	// nearest-neighbour sampling.
+	lb := logo.Bounds()
+	inner := box * 9 / 10
+	w, h := inner, inner
+	if lb.Dx() > lb.Dy() {
+		h = inner * lb.Dy() / lb.Dx()
+	} else {
+		w = inner * lb.Dx() / lb.Dy()
+	}
+	scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			scaled.Set(x, y, logo.At(lb.Min.X+x*lb.Dx()/w, lb.Min.Y+y*lb.Dy()/h))
+		}
+	}
+	x0, y0 := at+(box-w)/2, at+(box-h)/2
+	draw.Draw(img, scaled.Bounds().Add(image.Pt(x0, y0)), scaled, image.Point{}, draw.Over)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return fmt.Errorf("encoding QR PNG: %w", err)
+	}
+	result.QRPngBase64 = base64.StdEncoding.EncodeToString(buf.Bytes())
+	result.Sizes.QRPng = buf.Len()
+	return nil
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+)
+
+// QR label sheets. For graduation days and other events where credentials
+// are handed out in person, /api/staff/cohorts/qr-sheet.pdf prints a
+// cohort's wallet QR codes (see cohort.go for selecting one) with each
+// student's name and degree on standard A4 label sheets, ready to cut or
+// peel. ?labels= picks the sheet; the default has the largest codes,
+// since credential QRs are dense.
+
+// labelSheet is an A4 label sheet's grid, in millimetres.
+type labelSheet struct {
+	Cols, Rows    int
+	Width, Height float64 // of one label
+	Left, Top     float64 // page margins
+	HGap, VGap    float64 // between labels
+}
+
+var labelSheets = map[string]labelSheet{
+	"2x4": {Cols: 2, Rows: 4, Width: 99.1, Height: 67.7, Left: 4.65, Top: 13.1, HGap: 2.5},  // L7165
+	"2x7": {Cols: 2, Rows: 7, Width: 99.1, Height: 38.1, Left: 4.65, Top: 15.15, HGap: 2.5}, // L7163
+	"3x7": {Cols: 3, Rows: 7, Width: 63.5, Height: 38.1, Left: 7.2, Top: 15.15, HGap: 2.5},  // L7160
+	"3x8": {Cols: 3, Rows: 8, Width: 70, Height: 37, Left: 0, Top: 0.5},                     // 3475
+}
+
+const defaultLabelSheet = "2x4"
+
+// labelSheetNames lists the sheets for error messages.
+func labelSheetNames() string {
+	var names []string
+	for name := range labelSheets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// qrSheetPDF lays the sessions' QR codes out on label sheets. Sessions
+// without a QR code are skipped.
+func qrSheetPDF(sessions []*Session, sheet labelSheet, lang string) ([]byte, error) {
+	pdf := fpdf.New("P", "mm", "A4", "")
+	pdf.SetAutoPageBreak(false, 0)
+	enc := pdf.UnicodeTranslatorFromDescriptor("")
+	t := translator(lang)
+	perPage := sheet.Cols * sheet.Rows
+
+	n := 0
+	for _, sess := range sessions {
+		if sess.QR == nil || sess.QR.QRPngBase64 == "" {
+			slog.Warn("QR sheet: credential has no QR code", "credential", sess.CredentialID)
+			continue
+		}
+		png, err := base64.StdEncoding.DecodeString(sess.QR.QRPngBase64)
+		if err != nil {
+			return nil, fmt.Errorf("credential %s: %w", sess.CredentialID, err)
+		}
+		if n%perPage == 0 {
+			pdf.AddPage()
+		}
+		col, row := n%sheet.Cols, n%perPage/sheet.Cols
+		x := sheet.Left + float64(col)*(sheet.Width+sheet.HGap)
+		y := sheet.Top + float64(row)*(sheet.Height+sheet.VGap)
+
+		// The code fills the label's height, leaving a quiet margin, with
+		// the text beside it.
+		size := min(sheet.Height-6, sheet.Width*0.55)
+		name := fmt.Sprintf("qr-%d", n)
+		pdf.RegisterImageOptionsReader(name, fpdf.ImageOptions{ImageType: "PNG"}, bytes.NewReader(png))
+		pdf.ImageOptions(name, x+3, y+(sheet.Height-size)/2, size, size, false, fpdf.ImageOptions{ImageType: "PNG"}, 0, "")
+
+		textX, textW := x+size+5, sheet.Width-size-8
+		pdf.SetTextColor(31, 41, 55)
+		pdf.SetFont("Helvetica", "B", 10)
+		pdf.SetXY(textX, y+4)
+		pdf.MultiCell(textW, 4.5, enc(sess.Form.StudentName), "", "L", false)
+		pdf.SetFont("Helvetica", "", 8)
+		pdf.SetX(textX)
+		pdf.MultiCell(textW, 3.8, enc(t(sess.Form.Degree)), "", "L", false)
+		if sess.Form.StudentID != "" {
+			pdf.SetTextColor(107, 114, 128)
+			pdf.SetX(textX)
+			pdf.MultiCell(textW, 3.8, enc(sess.Form.StudentID), "", "L", false)
+		}
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("no credential in the cohort has a QR code")
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("generating QR sheet: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func handleCohortQRSheet(w http.ResponseWriter, r *http.Request) {
+	labels := r.URL.Query().Get("labels")
+	if labels == "" {
+		labels = defaultLabelSheet
+	}
+	sheet, ok := labelSheets[labels]
+	if !ok {
+		http.Error(w, "labels must be one of "+labelSheetNames(), http.StatusBadRequest)
+		return
+	}
+	_, sessions, ok := staffCohort(w, r)
+	if !ok {
+		return
+	}
+	pdf, err := qrSheetPDF(sessions, sheet, requestLanguage(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "QR sheet error", "err", err)
+		http.Error(w, "Failed to generate the QR sheet", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-qr-sheet.pdf\"")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(pdf)
+}
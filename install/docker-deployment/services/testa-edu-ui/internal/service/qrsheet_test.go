@@ -0,0 +1,48 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+// TestQRSheetPDF checks labels fill one sheet before the next is started,
+// and that sessions without a QR code take no label.
+func TestQRSheetPDF(t *testing.T) {
+	useCatalogs(t)
+	png, err := renderQRPNG("https://edu.example/claim/abc", 256, defaultQROptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	qr := &QRResult{QRPngBase64: base64.StdEncoding.EncodeToString(png)}
+	var sessions []*Session
+	for i := 0; i < 9; i++ {
+		sessions = append(sessions, &Session{
+			CredentialID: fmt.Sprint("c", i),
+			Form:         CredentialForm{StudentName: fmt.Sprint("Student ", i), Degree: "Bachelor of Science"},
+			QR:           qr,
+		})
+	}
+	sessions = append(sessions, &Session{CredentialID: "no-qr", Form: CredentialForm{StudentName: "No QR"}})
+
+	for name, sheet := range labelSheets {
+		if right := sheet.Left + float64(sheet.Cols)*sheet.Width + float64(sheet.Cols-1)*sheet.HGap; right > 210 {
+			t.Errorf("%s: labels end %.1fmm across an A4 page", name, right)
+		}
+		if bottom := sheet.Top + float64(sheet.Rows)*sheet.Height + float64(sheet.Rows-1)*sheet.VGap; bottom > 297 {
+			t.Errorf("%s: labels end %.1fmm down an A4 page", name, bottom)
+		}
+	}
+
+	pdf, err := qrSheetPDF(sessions, labelSheets["2x4"], "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(pdf, []byte("/Type /Page\n")); n != 2 {
+		t.Errorf("%d pages, want 2", n)
+	}
+	if _, err := qrSheetPDF(sessions[9:], labelSheets["2x4"], "en"); err == nil {
+		t.Error("sheet without any QR code rendered")
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/boombuler/barcode"
+)
+
+// Vector QR output for print shops producing physical certificates. The
+// QR data is re-encoded in-process and drawn module by module, so the code
+// scales to any print size without resampling.
+
+const (
+	qrEPSModulePts = 3 // points per module in EPS output
+	qrSVGModuleMM  = 0.5
+)
+
+// writeQRSVG draws the QR as a single path, one unit per module, with a
+// nominal print size of qrSVGModuleMM per module. A logo is embedded as an
+// image element over the centre.
+func writeQRSVG(code barcode.Barcode, opts QROptions) ([]byte, error) {
+	n := code.Bounds().Dx()
+	q := opts.QuietZone
+	size := n + 2*q
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%gmm" height="%gmm" shape-rendering="crispEdges">`+"\n", size, size, float64(size)*qrSVGModuleMM, float64(size)*qrSVGModuleMM)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", size, size)
+	buf.WriteString(`<path fill="#000" d="`)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			if qrDark(code, x, y) {
+				fmt.Fprintf(&buf, "M%d %dh1v1h-1z", x+q, y+q)
+			}
+		}
+	}
+	buf.WriteString("\"/>\n")
+	if opts.Logo {
+		_, raw, err := loadQRLogo()
+		if err != nil {
+			return nil, err
+		}
+		box := float64(n) * qrLogoFraction
+		at := float64(size)/2 - box/2
+		fmt.Fprintf(&buf, `<rect x="%g" y="%g" width="%g" height="%g" fill="#fff"/>`+"\n", at, at, box, box)
+		fmt.Fprintf(&buf, `<image x="%g" y="%g" width="%g" height="%g" href="data:%s;base64,%s"/>`+"\n",
+			at, at, box, box, http.DetectContentType(raw), base64.StdEncoding.EncodeToString(raw))
+	}
+	buf.WriteString("</svg>\n")
+	return buf.Bytes(), nil
+}
+
+// writeQREPS draws the QR as Encapsulated PostScript, qrEPSModulePts per
+// module. PostScript's origin is bottom-left, so rows are flipped. Logos
+// are left to the print layout.
+func writeQREPS(code barcode.Barcode, opts QROptions) []byte {
+	n := code.Bounds().Dx()
+	q := opts.QuietZone
+	size := (n + 2*q) * qrEPSModulePts
+
+	var buf bytes.Buffer
+	buf.WriteString("%!PS-Adobe-3.0 EPSF-3.0\n")
+	fmt.Fprintf(&buf, "%%%%BoundingBox: 0 0 %d %d\n", size, size)
+	buf.WriteString("%%Title: Testa Edu credential QR\n")
+	fmt.Fprintf(&buf, "%%%%CreationDate: %s\n", time.Now().UTC().Format(time.RFC3339))
+	buf.WriteString("%%EndComments\n")
+	buf.WriteString("/m { 1 1 rectfill } bind def\n")
+	fmt.Fprintf(&buf, "1 setgray 0 0 %d %d rectfill\n", size, size)
+	fmt.Fprintf(&buf, "%d %d scale\n", qrEPSModulePts, qrEPSModulePts)
+	buf.WriteString("0 setgray\n")
+	for y := 0; y < n; y++ {
+		row := n + q - 1 - y
+		for x := 0; x < n; x++ {
+			if qrDark(code, x, y) {
+				fmt.Fprintf(&buf, "%d %d m\n", x+q, row)
+			}
+		}
+	}
+	buf.WriteString("showpage\n%%EOF\n")
+	return buf.Bytes()
+}
+
+// sessionQRMatrix returns the session's QR for vector output, writing an
+// error response if there is none.
+func sessionQRMatrix(w http.ResponseWriter, r *http.Request) (barcode.Barcode, QROptions, bool) {
+	sess := getSession(r)
+	if sess == nil || sess.QR == nil {
+		http.Error(w, "No QR code available. Please issue a credential first.", http.StatusNotFound)
+		return nil, QROptions{}, false
+	}
+	if len(sess.QR.Frames) > 0 {
+		http.Error(w, "This credential uses an animated QR, which has no vector form. Use link mode for printed certificates.", http.StatusNotFound)
+		return nil, QROptions{}, false
+	}
+	code, err := qrMatrix(sess.QR.QRData, sess.QROptions)
+	if err != nil {
+		http.Error(w, "Failed to encode QR", http.StatusInternalServerError)
+		return nil, QROptions{}, false
+	}
+	return code, sess.QROptions, true
+}
+
+func handleDownloadQRSVG(w http.ResponseWriter, r *http.Request) {
+	code, opts, ok := sessionQRMatrix(w, r)
+	if !ok {
+		return
+	}
+	svg, err := writeQRSVG(code, opts)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "QR SVG error", "err", err)
+		http.Error(w, "Failed to render QR", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential-qr.svg\"")
+	w.Write(svg)
+}
+
+func handleDownloadQREPS(w http.ResponseWriter, r *http.Request) {
+	code, opts, ok := sessionQRMatrix(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/postscript")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential-qr.eps\"")
+	w.Write(writeQREPS(code, opts))
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Reloading. SIGHUP re-reads the message catalogs and page templates, and
+// the configuration (the environment and CONFIG_FILE), without a restart,
+// so sessions in memory survive a template tweak. Certificate and email
+// templates are read as they are rendered and need no reload.
+//
+// Only settings that are read as they are used change on a reload; see
+// reloadedConfig. Settings that open stores, listeners or the agent client
+// (DATA_DIR, PORT, AGENT_URL, SIGNING_MODE and the like) take effect on
+// the next restart. A configuration or template that fails to load is
+// logged and the service carries on with what it had.
+
+// snapshot is what a reload replaces: the settings it may change, and the
+// message catalogs and page templates. A reload publishes a new one whole,
+// so nothing waits for it, health probes included, and nothing sees it
+// half done. Each request renders its pages from the snapshot current as
+// it started.
+type snapshot struct {
+	config    *Config
+	catalogs  map[string]*Catalog
+	languages []Language
+	pageSets  map[string]*template.Template
+}
+
+var current atomic.Pointer[snapshot]
+
+// live returns the current snapshot.
+func live() *snapshot {
+	if s := current.Load(); s != nil {
+		return s
+	}
+	return &snapshot{config: &config}
+}
+
+// liveConfig returns the current settings. Those a reload may change (see
+// reloadedConfig) are read from it, the others from config.
+func liveConfig() *Config {
+	return live().config
+}
+
+type snapshotContextKey struct{}
+
+// withSnapshot gives each request the snapshot current as it starts.
+func withSnapshot(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), snapshotContextKey{}, live())))
+	})
+}
+
+// snapshotFor returns the request's snapshot.
+func snapshotFor(r *http.Request) *snapshot {
+	if s, ok := r.Context().Value(snapshotContextKey{}).(*snapshot); ok {
+		return s
+	}
+	return live()
+}
+
+// startReloads reloads on each SIGHUP until ctx is done.
+func startReloads(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := reload(); err != nil {
+					slog.Error("reload failed; keeping the current configuration and templates", "err", err)
+					continue
+				}
+				slog.Info("reload: configuration and templates reloaded")
+			}
+		}
+	}()
+}
+
+// reload reads the configuration and templates again and publishes them.
+func reload() error {
+	next, err := parseConfig()
+	if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	cfg := reloadedConfig(config, next)
+	s, err := loadTemplates(&cfg)
+	if err != nil {
+		return fmt.Errorf("templates: %w", err)
+	}
+	current.Store(s)
+	logLevel.Set(cfg.LogLevel)
+	return nil
+}
+
+// reloadedConfig is cur with the settings a reload may change taken from
+// next: those read as they are used, not at startup.
+func reloadedConfig(cur, next Config) Config {
+	cur.IssuerName = next.IssuerName
+	cur.IssuerLogoURL = next.IssuerLogoURL
+	cur.Theme = next.Theme
+	cur.DefaultLanguage = next.DefaultLanguage
+	cur.LogLevel = next.LogLevel
+
+	cur.QRMode = next.QRMode
+	cur.QRMaxChars = next.QRMaxChars
+	cur.QRErrorCorrection = next.QRErrorCorrection
+	cur.QRModuleSize = next.QRModuleSize
+	cur.QRQuietZone = next.QRQuietZone
+	cur.QRLogo = next.QRLogo
+
+	cur.ShareLinkTTL = next.ShareLinkTTL
+	cur.ClaimCodeTTL = next.ClaimCodeTTL
+	cur.MagicLinkTTL = next.MagicLinkTTL
+	cur.VerifyRequestTTL = next.VerifyRequestTTL
+	cur.CredentialValidity = next.CredentialValidity
+	cur.SDClaims = next.SDClaims
+
+	cur.EmbedOrigins = next.EmbedOrigins
+	cur.ConsentTermsURL = next.ConsentTermsURL
+	cur.PDFRenderer = next.PDFRenderer
+	cur.CertificateTemplatesDir = next.CertificateTemplatesDir
+	cur.EmailTemplatesDir = next.EmailTemplatesDir
+	cur.MaintenanceMode = next.MaintenanceMode
+	return cur
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestReload changes settings, then reloads: those read as they are used
+// change, the others wait for a restart, and an invalid configuration is
+// not taken.
+func TestReload(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	port := config.Port
+
+	t.Setenv("ISSUER_NAME", "Reloaded University")
+	t.Setenv("QR_MODE", QRModeCompact)
+	t.Setenv("PORT", "4444")
+	if err := reload(); err != nil {
+		t.Fatal(err)
+	}
+	if c := liveConfig(); c.IssuerName != "Reloaded University" || c.QRMode != QRModeCompact || c.Port != port {
+		t.Errorf("reloaded %q %q %q", c.IssuerName, c.QRMode, c.Port)
+	}
+	resp, err := srv.Client().Get(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(body), "Reloaded University") {
+		t.Error("page does not show the reloaded issuer name")
+	}
+
+	t.Setenv("QR_MODE", "bogus")
+	if err := reload(); err == nil {
+		t.Error("invalid QR_MODE reloaded")
+	}
+	if liveConfig().QRMode != QRModeCompact {
+		t.Errorf("QR mode %q after a failed reload", liveConfig().QRMode)
+	}
+}
+
+// TestReloadInFlight checks a reload does not wait for the requests in
+// flight, which finish on the snapshot they started with.
+func TestReloadInFlight(t *testing.T) {
+	startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	started, finish := make(chan struct{}), make(chan struct{})
+	names := make(chan string, 1)
+	slow := withSnapshot(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-finish
+		names <- snapshotFor(r).config.IssuerName
+	}))
+	go slow.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	<-started
+
+	t.Setenv("ISSUER_NAME", "Reloaded University")
+	done := make(chan error, 1)
+	go func() { done <- reload() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the reload waited for a request in flight")
+	}
+	close(finish)
+	if name := <-names; name == "Reloaded University" {
+		t.Error("the request in flight saw the reload")
+	}
+	if liveConfig().IssuerName != "Reloaded University" {
+		t.Error("new requests do not see the reload")
+	}
+}
+
+// useCatalogs loads the message catalogs, without the page templates, for
+// one test.
+func useCatalogs(t *testing.T) {
+	t.Helper()
+	cats, err := loadCatalogs("locales")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := current.Load()
+	current.Store(&snapshot{config: &config, catalogs: cats})
+	t.Cleanup(func() { current.Store(saved) })
+}
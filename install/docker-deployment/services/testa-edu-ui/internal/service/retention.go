@@ -0,0 +1,177 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Data retention. RETENTION sets how long each class of data is kept, as
+// class=period pairs ("credentials=365d,pii=365d,shares=30d"); periods are
+// Go durations or a number of days. Classes without a period are kept
+// indefinitely, except sessions, which default to one hour. The engine
+// runs every RETENTION_INTERVAL. With RETENTION_DRY_RUN set, scheduled runs
+// only log what they would purge, so a policy can be trialled first; the
+// staff API also serves a dry-run report on demand.
+//
+// Stored credentials and consent records are anonymized rather than
+// deleted, as on erasure, so issuance statistics survive.
+
+type retentionClass struct {
+	Name   string
+	Expire func(cutoff time.Time, dryRun bool) (int, error)
+}
+
+// retentionClasses lists the purgeable data classes in the order they are
+// processed.
+var retentionClasses = []retentionClass{
+	{"sessions", expireSessions},
+	{"credentials", func(c time.Time, d bool) (int, error) { return store.Expire(c, d) }},
+	{"shares", func(c time.Time, d bool) (int, error) { return shares.Expire(c, d) }},
+	{"shortlinks", func(c time.Time, d bool) (int, error) { return shortLinks.Expire(c, d) }},
+	{"wallets", func(c time.Time, d bool) (int, error) { return wallets.Expire(c, d) }},
+	{"verifyrequests", func(c time.Time, d bool) (int, error) { return verifyRequests.Expire(c, d) }},
+	{"verifications", func(c time.Time, d bool) (int, error) { return verifications.Expire(c, d) }},
+	{"claims", func(c time.Time, d bool) (int, error) { return claims.Expire(c, d) }},
+	{"deliveries", func(c time.Time, d bool) (int, error) { return deliveries.Expire(c, d) }},
+	{"consents", func(c time.Time, d bool) (int, error) { return consents.Expire(c, d) }},
+	{"pii", func(c time.Time, d bool) (int, error) { return pii.Expire(c, d) }},
+}
+
+const defaultSessionRetention = time.Hour
+
+type RetentionResult struct {
+	Class  string    `json:"class"`
+	Period string    `json:"period"`
+	Cutoff time.Time `json:"cutoff"`
+	Purged int       `json:"purged"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type RetentionReport struct {
+	RanAt   time.Time         `json:"ranAt"`
+	DryRun  bool              `json:"dryRun"`
+	Classes []RetentionResult `json:"classes"`
+}
+
+// parseRetention parses the RETENTION policy.
+func parseRetention(s string) (map[string]time.Duration, error) {
+	policy := map[string]time.Duration{"sessions": defaultSessionRetention}
+	for _, pair := range splitList(s) {
+		class, period, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid RETENTION entry %q, want class=period", pair)
+		}
+		class = strings.TrimSpace(class)
+		if !knownRetentionClass(class) {
+			return nil, fmt.Errorf("unknown RETENTION class %q", class)
+		}
+		d, err := parseRetentionPeriod(strings.TrimSpace(period))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid RETENTION period %q for %s", period, class)
+		}
+		policy[class] = d
+	}
+	return policy, nil
+}
+
+func knownRetentionClass(name string) bool {
+	for _, c := range retentionClasses {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRetentionPeriod accepts a Go duration or a whole number of days
+// ("90d").
+func parseRetentionPeriod(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// runRetention applies the policy once, or with dryRun only counts what it
+// would purge.
+func runRetention(dryRun bool) RetentionReport {
+	now := time.Now().UTC()
+	report := RetentionReport{RanAt: now, DryRun: dryRun}
+	for _, class := range retentionClasses {
+		period, ok := config.Retention[class.Name]
+		if !ok {
+			continue
+		}
+		res := RetentionResult{Class: class.Name, Period: period.String(), Cutoff: now.Add(-period)}
+		n, err := class.Expire(res.Cutoff, dryRun)
+		res.Purged = n
+		if err != nil {
+			res.Error = err.Error()
+			slog.Error("retention", "class", class.Name, "err", err)
+		}
+		report.Classes = append(report.Classes, res)
+	}
+	return report
+}
+
+func logRetention(report RetentionReport) {
+	var parts []string
+	for _, res := range report.Classes {
+		if res.Purged > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", res.Class, res.Purged))
+		}
+	}
+	if len(parts) == 0 {
+		return
+	}
+	verb := "purged"
+	if report.DryRun {
+		verb = "would purge"
+	}
+	slog.Info("retention: "+verb+" "+strings.Join(parts, ", "), "dry_run", report.DryRun)
+}
+
+// startRetention runs the policy every RETENTION_INTERVAL, on the leader
+// only (see leader.go). Followers, and scheduled dry runs, still expire
+// sessions: they are scratch state held in each process's memory, and
+// keeping them would only grow the process.
+func startRetention() {
+	go func() {
+		for {
+			time.Sleep(config.RetentionInterval)
+			leader := isLeader()
+			if leader {
+				logRetention(runRetention(config.RetentionDryRun))
+				if _, err := approvals.Expire(); err != nil {
+					slog.Error("approval expiry", "err", err)
+				}
+			}
+			if period, ok := config.Retention["sessions"]; ok && (config.RetentionDryRun || !leader) {
+				if _, err := expireSessions(time.Now().UTC().Add(-period), false); err != nil {
+					slog.Error("retention sessions", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// handleRetention reports what the policy would purge now (GET) or applies
+// it immediately (POST, unless ?dryRun=true).
+func handleRetention(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.Method == http.MethodGet || r.URL.Query().Get("dryRun") == "true"
+	report := runRetention(dryRun)
+	if !dryRun {
+		logRetention(report)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+func TestParseRetention(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		want map[string]time.Duration
+		err  string
+	}{
+		{in: "", want: map[string]time.Duration{"sessions": time.Hour}},
+		{in: "credentials=365d, shares=720h", want: map[string]time.Duration{"sessions": time.Hour, "credentials": 365 * 24 * time.Hour, "shares": 720 * time.Hour}},
+		{in: "sessions=30m", want: map[string]time.Duration{"sessions": 30 * time.Minute}},
+		{in: "credentials", err: "want class=period"},
+		{in: "grades=30d", err: "unknown RETENTION class"},
+		{in: "pii=0d", err: "invalid RETENTION period"},
+		{in: "pii=-1h", err: "invalid RETENTION period"},
+		{in: "pii=soon", err: "invalid RETENTION period"},
+	} {
+		got, err := parseRetention(c.in)
+		if c.err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.err) {
+				t.Errorf("%q: error %v, want %q", c.in, err, c.err)
+			}
+			continue
+		}
+		if err != nil || len(got) != len(c.want) {
+			t.Errorf("%q: %v, %v", c.in, got, err)
+			continue
+		}
+		for class, d := range c.want {
+			if got[class] != d {
+				t.Errorf("%q: %s kept %v, want %v", c.in, class, got[class], d)
+			}
+		}
+	}
+}
+
+// TestRetentionCutoff checks each class purges only what is older than its
+// cutoff: data from the cutoff on, and a dry run's data, survive.
+func TestRetentionCutoff(t *testing.T) {
+	startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	cutoff := time.Now().UTC().Add(-30 * 24 * time.Hour)
+	tokens := map[string]string{}
+
+	for _, c := range []struct {
+		name   string
+		put    func(id string, at time.Time)
+		kept   func(id string) bool
+		expire func(cutoff time.Time, dryRun bool) (int, error)
+	}{
+		{
+			name: "credentials",
+			put: func(id string, at time.Time) {
+				if err := store.Put(&StoredCredential{ID: id, Format: FormatLDP, Credential: []byte(`{}`), SubjectID: "did:key:z6Mk" + id, IssuedAt: at}); err != nil {
+					t.Fatal(err)
+				}
+			},
+			kept: func(id string) bool {
+				c, ok := store.Get(id)
+				return ok && c.SubjectID != ""
+			},
+			expire: store.Expire,
+		},
+		{
+			name: "shares",
+			put: func(id string, at time.Time) {
+				link, err := shares.Create("pdf", "did:key:z6Mk"+id, []byte("%PDF"), 90*24*time.Hour, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				shares.mu.Lock()
+				link.CreatedAt = at
+				shares.mu.Unlock()
+				tokens[id] = link.Token
+			},
+			kept: func(id string) bool {
+				shares.mu.Lock()
+				defer shares.mu.Unlock()
+				return shares.links[tokens[id]] != nil
+			},
+			expire: shares.Expire,
+		},
+		{
+			name: "sessions",
+			put: func(id string, at time.Time) {
+				sessionsMu.Lock()
+				sessions[id] = &Session{CreatedAt: at}
+				sessionsMu.Unlock()
+			},
+			kept: func(id string) bool {
+				sessionsMu.RLock()
+				defer sessionsMu.RUnlock()
+				return sessions[id] != nil
+			},
+			expire: expireSessions,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			ages := map[string]time.Time{
+				c.name + "-old":    cutoff.Add(-24 * time.Hour),
+				c.name + "-just":   cutoff.Add(-time.Nanosecond),
+				c.name + "-cutoff": cutoff,
+				c.name + "-recent": cutoff.Add(24 * time.Hour),
+			}
+			for id, at := range ages {
+				c.put(id, at)
+			}
+			for _, dryRun := range []bool{true, false} {
+				n, err := c.expire(cutoff, dryRun)
+				if err != nil || n != 2 {
+					t.Errorf("dry run %v: purged %d, %v; want 2", dryRun, n, err)
+				}
+				for id, at := range ages {
+					if want := dryRun || !at.Before(cutoff); c.kept(id) != want {
+						t.Errorf("dry run %v: %s kept %v, want %v", dryRun, id, !want, want)
+					}
+				}
+			}
+			if n, _ := c.expire(cutoff, false); n != 0 {
+				t.Errorf("a second run purged %d", n)
+			}
+		})
+	}
+}
+
+// TestRunRetention checks the policy applies only to configured classes.
+func TestRunRetention(t *testing.T) {
+	startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	config.Retention = map[string]time.Duration{"credentials": 24 * time.Hour}
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	store.Put(&StoredCredential{ID: "old", Format: FormatLDP, Credential: []byte(`{}`), SubjectID: "did:key:z6Mkold", IssuedAt: old})
+	sessionsMu.Lock()
+	sessions["old"] = &Session{CreatedAt: old}
+	sessionsMu.Unlock()
+	t.Cleanup(func() {
+		sessionsMu.Lock()
+		delete(sessions, "old")
+		sessionsMu.Unlock()
+	})
+
+	report := runRetention(false)
+	if len(report.Classes) != 1 || report.Classes[0].Class != "credentials" || report.Classes[0].Purged != 1 {
+		t.Fatalf("report %+v", report)
+	}
+	if c, _ := store.Get("old"); c.SubjectID != "" || c.ErasedAt.IsZero() {
+		t.Errorf("the expired credential was not anonymized: %+v", c)
+	}
+	sessionsMu.RLock()
+	_, kept := sessions["old"]
+	sessionsMu.RUnlock()
+	if !kept {
+		t.Error("sessions were purged without a period")
+	}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Link-mode QR codes carry a short retrieval URL instead of the credential
+// itself; the wallet or verifier fetches the credential from /c/{id}.
+//
+// Retrieval requires holder authorization, either:
+//   - the claim token minted at issuance (?token= or "Authorization: Bearer"),
+//     which link-mode QR codes embed in a signed URL (see linksign.go); or
+//   - DID-auth: a JWT signed by the credential subject's did:key, carrying
+//     the nonce from a 401 challenge ("Authorization: DIDAuth <jwt>"). This
+//     lets wallets refresh a credential without keeping the token.
+//
+// Challenges are stateless, so unauthenticated requests cannot grow server
+// state: the nonce carries its expiry and a MAC binding it to the
+// credential. A nonce is remembered only once a proof has used it, until it
+// expires, which keeps nonces single-use.
+
+const retrievalChallengeTTL = 5 * time.Minute
+
+var (
+	spentChallenges   = make(map[string]time.Time) // nonce → expiry
+	spentChallengesMu sync.Mutex
+)
+
+// credentialURL is a stored credential's retrieval URL, on its tenant's
+// domain when it has one.
+func credentialURL(tenantID, id string) string {
+	return tenantURL(tenantID) + "/c/" + id
+}
+
+// verificationURL is the public page checking a stored credential, printed
+// on its certificate.
+func verificationURL(tenantID, id string) string {
+	return tenantURL(tenantID) + "/verify/" + id
+}
+
+func hashClaimToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newClaimToken() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// storeSessionMu makes storeSessionCredential store a credential once
+// when the artifact pipeline and a request both need it stored.
+var storeSessionMu sync.Mutex
+
+// storeSessionCredential saves the session's signed credential in the
+// credential store, once, and returns its ID and claim token.
+func storeSessionCredential(sess *Session) (string, string, error) {
+	storeSessionMu.Lock()
+	defer storeSessionMu.Unlock()
+	sessionsMu.RLock()
+	id, token := sess.CredentialID, sess.ClaimToken
+	sessionsMu.RUnlock()
+	if id != "" {
+		return id, token, nil
+	}
+
+	if !sess.Consent.Allows(ConsentScopeStore) {
+		return "", "", fmt.Errorf("the student has not consented to storing the credential")
+	}
+	payload, err := deliveryPayload(sess)
+	if err != nil {
+		return "", "", err
+	}
+
+	token = newClaimToken()
+	stored := &StoredCredential{
+		Format:     sess.Format,
+		ProofType:  sess.ProofType,
+		Credential: payload,
+		Encrypted:  sess.EncryptTo != "",
+		IssuedAt:   time.Now().UTC(),
+		Timestamp:  sess.Timestamp,
+		TokenHash:  hashClaimToken(token),
+		SubjectID:  studentDID(sess.Form),
+		ConsentID:  sess.Consent.ID,
+		TenantID:   sess.TenantID,
+	}
+	if err := store.Put(stored); err != nil {
+		return "", "", fmt.Errorf("storing credential: %w", err)
+	}
+	if err := consents.LinkCredential(sess.Consent.ID, stored.ID); err != nil {
+		slog.Error("consent link error", "err", err)
+	}
+
+	sessionsMu.Lock()
+	sess.CredentialID = stored.ID
+	sess.ClaimToken = token
+	sessionsMu.Unlock()
+	return stored.ID, token, nil
+}
+
+// claimURL is the signed retrieval URL with the claim token attached.
+func claimURL(tenantID, id, token string) string {
+	return signLink(credentialURL(tenantID, id) + "?token=" + url.QueryEscape(token))
+}
+
+// generateLinkQR stores the credential and encodes its (shortened)
+// retrieval URL.
+func generateLinkQR(sess *Session) (*QRResult, error) {
+	id, token, err := storeSessionCredential(sess)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionsMu.Lock()
+	if sess.LinkURL == "" {
+		sess.LinkURL = shortenOr(claimURL(sess.TenantID, id, token), 0)
+	}
+	link := sess.LinkURL
+	sessionsMu.Unlock()
+	return inProcessQR(link, len(sess.SignedCredential), sess.QROptions)
+}
+
+// credentialMediaType is the response type for a stored credential.
+func credentialMediaType(format string) string {
+	switch format {
+	case FormatJWT:
+		return "application/jwt"
+	case FormatSDJWT:
+		return "application/vc+sd-jwt"
+	default:
+		return "application/vc+ld+json"
+	}
+}
+
+// credentialFileExt is the file extension for a downloaded credential.
+func credentialFileExt(cred *StoredCredential) string {
+	switch {
+	case cred.Encrypted:
+		return ".jwe"
+	case cred.Format == FormatJWT:
+		return ".jwt"
+	case cred.Format == FormatSDJWT:
+		return ".sd-jwt"
+	}
+	return ".json"
+}
+
+// newRetrievalChallenge returns expiry ‖ random ‖ MAC, base64url encoded.
+func newRetrievalChallenge(credentialID string) string {
+	b := make([]byte, 8+16, 8+16+16)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().Add(retrievalChallengeTTL).Unix()))
+	rand.Read(b[8:])
+	return base64.RawURLEncoding.EncodeToString(append(b, retrievalChallengeMAC(credentialID, b)...))
+}
+
+func retrievalChallengeMAC(credentialID string, b []byte) []byte {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte("retrieval-challenge\x00" + credentialID + "\x00"))
+	mac.Write(b)
+	return mac.Sum(nil)[:16]
+}
+
+// checkRetrievalChallenge reports whether nonce was issued for the
+// credential and is unexpired, and when it expires.
+func checkRetrievalChallenge(nonce, credentialID string) (time.Time, bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 8+16+16 {
+		return time.Time{}, false
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(b)), 0)
+	if !hmac.Equal(b[24:], retrievalChallengeMAC(credentialID, b[:24])) || !time.Now().Before(expires) {
+		return time.Time{}, false
+	}
+	return expires, true
+}
+
+// spendRetrievalChallenge marks a nonce used, reporting false if it
+// already was.
+func spendRetrievalChallenge(nonce string, expires time.Time) bool {
+	spentChallengesMu.Lock()
+	defer spentChallengesMu.Unlock()
+	now := time.Now()
+	for n, exp := range spentChallenges {
+		if now.After(exp) {
+			delete(spentChallenges, n)
+		}
+	}
+	if _, spent := spentChallenges[nonce]; spent {
+		return false
+	}
+	spentChallenges[nonce] = expires
+	return true
+}
+
+// authorizeRetrieval checks the request's claim token or DID-auth proof.
+func authorizeRetrieval(r *http.Request, cred *StoredCredential) error {
+	scheme, value, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	token := r.URL.Query().Get("token")
+	if strings.EqualFold(scheme, "Bearer") {
+		token = value
+	}
+
+	if token != "" {
+		if cred.TokenHash == "" || subtle.ConstantTimeCompare([]byte(hashClaimToken(token)), []byte(cred.TokenHash)) != 1 {
+			return fmt.Errorf("invalid claim token")
+		}
+		return nil
+	}
+
+	if strings.EqualFold(scheme, "DIDAuth") {
+		_, payload, err := decodeJWT(value)
+		if err != nil {
+			return err
+		}
+		nonce, _ := payload["nonce"].(string)
+		expires, ok := checkRetrievalChallenge(nonce, cred.ID)
+		if !ok {
+			return fmt.Errorf("unknown or expired challenge")
+		}
+		holder, err := verifyDIDAuth(value, credentialURL(cred.TenantID, cred.ID), nonce)
+		if err != nil {
+			return fmt.Errorf("DID-auth: %w", err)
+		}
+		if cred.SubjectID == "" || holder != cred.SubjectID {
+			return fmt.Errorf("DID-auth: %s is not the credential subject", holder)
+		}
+		if !spendRetrievalChallenge(nonce, expires) {
+			return fmt.Errorf("challenge already used")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("authorization required")
+}
+
+// writeRetrievalChallenge answers an unauthorized request for a stored
+// credential with a fresh DID-auth challenge.
+func writeRetrievalChallenge(w http.ResponseWriter, cred *StoredCredential, err error) {
+	nonce := newRetrievalChallenge(cred.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf(`DIDAuth nonce=%q, aud=%q`, nonce, credentialURL(cred.TenantID, cred.ID)))
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   err.Error(),
+		"nonce":   nonce,
+		"aud":     credentialURL(cred.TenantID, cred.ID),
+		"methods": []string{"claim_token", "did_auth"},
+	})
+}
+
+func handleCredentialRetrieve(w http.ResponseWriter, r *http.Request) {
+	if err := verifyLinkSignature(r); err != nil {
+		slog.ErrorContext(r.Context(), "credential link rejected", "err", err)
+		http.Error(w, "This link has been modified and cannot be used.", http.StatusBadRequest)
+		return
+	}
+
+	cred, ok := store.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if !cred.ErasedAt.IsZero() {
+		http.Error(w, "This credential has been erased at the holder's request.", http.StatusGone)
+		return
+	}
+
+	if err := authorizeRetrieval(r, cred); err != nil {
+		slog.WarnContext(r.Context(), "credential retrieval denied", "credential", cred.ID, "err", err)
+		writeRetrievalChallenge(w, cred, err)
+		return
+	}
+
+	body := []byte(cred.Credential)
+	var compact string
+	if err := json.Unmarshal(cred.Credential, &compact); err == nil {
+		body = []byte(compact)
+	}
+
+	slog.InfoContext(r.Context(), "credential retrieved", "credential", cred.ID)
+	if cred.Encrypted {
+		w.Header().Set("Content-Type", "application/jose")
+	} else {
+		w.Header().Set("Content-Type", credentialMediaType(cred.Format))
+	}
+	if r.URL.Query().Get("download") != "" {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"testa-edu-credential%s\"", credentialFileExt(cred)))
+	}
+	w.Write(body)
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+// TestRetrievalChallenge checks that stateless challenges are bound to
+// their credential and expiry, and can be spent once.
+func TestRetrievalChallenge(t *testing.T) {
+	nonce := newRetrievalChallenge("cred-1")
+
+	expired := make([]byte, 24)
+	binary.BigEndian.PutUint64(expired, uint64(time.Now().Add(-time.Second).Unix()))
+	expired = append(expired, retrievalChallengeMAC("cred-1", expired)...)
+
+	raw, _ := base64.RawURLEncoding.DecodeString(nonce)
+	raw[0] ^= 1 // moves the expiry
+
+	tests := []struct {
+		name, nonce, credentialID string
+		valid                     bool
+	}{
+		{"issued", nonce, "cred-1", true},
+		{"other credential", nonce, "cred-2", false},
+		{"tampered expiry", base64.RawURLEncoding.EncodeToString(raw), "cred-1", false},
+		{"expired", base64.RawURLEncoding.EncodeToString(expired), "cred-1", false},
+		{"malformed", "not-a-nonce", "cred-1", false},
+	}
+	for _, tt := range tests {
+		if _, ok := checkRetrievalChallenge(tt.nonce, tt.credentialID); ok != tt.valid {
+			t.Errorf("%s: valid = %t, want %t", tt.name, ok, tt.valid)
+		}
+	}
+
+	expires, _ := checkRetrievalChallenge(nonce, "cred-1")
+	if !spendRetrievalChallenge(nonce, expires) {
+		t.Fatal("first use rejected")
+	}
+	if spendRetrievalChallenge(nonce, expires) {
+		t.Error("second use accepted")
+	}
+}
@@ -0,0 +1,573 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Verify by scan: the counterpart to the QR codes the issuance flow
+// produces. The /scan page reads a credential QR with the device camera
+// (or takes its text pasted in) and posts what it read here, where it is
+// decoded and verified. Every QR mode is understood: PixelPass (JSON-XT,
+// or a JWT as-is), compact (EDU1:), CBOR, and link mode, whose retrieval
+// URL is resolved against this server's own credential store. Framed QR
+// codes are reassembled: the page posts each new frame as it is read and
+// keeps scanning until every frame of the set has been seen.
+//
+// Verification runs the credential through the agent as at issuance,
+// checks SD-JWT disclosures against their digests, and checks expiry.
+//
+// Printed certificates carry their credential ID and a verification URL,
+// /verify/{id}, which runs the same checks on the stored credential
+// without a scan. /verify takes the ID typed in from the paper.
+
+// maxScanData bounds the scanned text accepted in one request.
+const maxScanData = 256 << 10
+
+// scannedCredential is a credential decoded from a QR code. Credential is
+// shaped like Session.SignedCredential: a JSON-LD object, or a JSON string
+// for JWT and SD-JWT credentials.
+type scannedCredential struct {
+	Mode       string
+	Format     string
+	Credential json.RawMessage
+
+	CredentialID string // set when the QR linked to a stored credential
+}
+
+// joinScannedFrames reassembles framed QR data. It returns the joined data,
+// or how many frames of the set have been seen so far when some are missing.
+func joinScannedFrames(frames []string) (data string, have, total int, err error) {
+	chunks := make(map[int]string)
+	set := ""
+	for _, f := range frames {
+		rest, ok := strings.CutPrefix(f, qrFramePrefix)
+		if !ok {
+			return "", 0, 0, fmt.Errorf("mixed framed and unframed QR codes")
+		}
+		parts := strings.SplitN(rest, ":", 3)
+		if len(parts) != 3 {
+			return "", 0, 0, fmt.Errorf("malformed QR frame header")
+		}
+		idx, count, ok := strings.Cut(parts[1], "/")
+		i, err1 := strconv.Atoi(idx)
+		n, err2 := strconv.Atoi(count)
+		if !ok || err1 != nil || err2 != nil || i < 1 || i > n {
+			return "", 0, 0, fmt.Errorf("malformed QR frame header")
+		}
+		if set == "" {
+			set, total = parts[0], n
+		}
+		if parts[0] != set || n != total {
+			return "", 0, 0, fmt.Errorf("QR frames from different credentials")
+		}
+		chunks[i] = parts[2]
+	}
+	if len(chunks) < total {
+		return "", len(chunks), total, nil
+	}
+	keys := make([]int, 0, total)
+	for i := range chunks {
+		keys = append(keys, i)
+	}
+	sort.Ints(keys)
+	var b strings.Builder
+	for _, i := range keys {
+		b.WriteString(chunks[i])
+	}
+	return b.String(), total, total, nil
+}
+
+// decodeScannedQR decodes the text of a credential QR in any mode.
+func decodeScannedQR(data string) (*scannedCredential, error) {
+	data = strings.TrimSpace(data)
+	switch {
+	case data == "":
+		return nil, fmt.Errorf("no QR data")
+	case strings.HasPrefix(data, compactQRPrefix):
+		raw, err := base45Decode(strings.TrimPrefix(data, compactQRPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("compact QR: %w", err)
+		}
+		payload, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(raw)), maxScanData*8))
+		if err != nil {
+			return nil, fmt.Errorf("compact QR: %w", err)
+		}
+		return scannedPayload(QRModeCompact, payload)
+	case strings.HasPrefix(data, "https://") || strings.HasPrefix(data, "http://"):
+		cred, err := resolveScannedLink(data)
+		if err != nil {
+			return nil, err
+		}
+		return &scannedCredential{Mode: QRModeLink, Format: cred.Format, Credential: cred.Credential, CredentialID: cred.ID}, nil
+	}
+
+	raw, err := base45Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("not a credential QR code")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("not a credential QR code")
+	}
+	payload, err := io.ReadAll(io.LimitReader(zr, maxScanData*8))
+	if err != nil {
+		return nil, fmt.Errorf("PixelPass QR: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(payload, []byte("jxt:")):
+		credential, err := unpackJSONXT(string(payload))
+		if err != nil {
+			return nil, err
+		}
+		return &scannedCredential{Mode: QRModePixelPass, Format: FormatLDP, Credential: credential}, nil
+	case len(payload) > 0 && payload[0]>>5 == 5: // CBOR map
+		credential, err := decodeCredentialCBOR(payload)
+		if err != nil {
+			return nil, err
+		}
+		return scannedPayload(QRModeCBOR, credential)
+	}
+	return scannedPayload(QRModePixelPass, payload)
+}
+
+// decodeCredentialText accepts a credential as issued (JSON-LD, JWT or
+// SD-JWT), a JSON-XT URI, or the text of any credential QR.
+func decodeCredentialText(text string) (*scannedCredential, error) {
+	text = strings.TrimSpace(text)
+	switch {
+	case strings.HasPrefix(text, "jxt:"):
+		credential, err := unpackJSONXT(text)
+		if err != nil {
+			return nil, err
+		}
+		return &scannedCredential{Format: FormatLDP, Credential: credential}, nil
+	case strings.HasPrefix(text, "{") || strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "eyJ"):
+		return scannedPayload("", []byte(text))
+	}
+	return decodeScannedQR(text)
+}
+
+// scannedPayload classifies a decoded payload: a JSON-LD credential, a
+// JSON-encoded compact JWT, or a bare JWT or SD-JWT.
+func scannedPayload(mode string, payload []byte) (*scannedCredential, error) {
+	payload = bytes.TrimSpace(payload)
+	var compact string
+	switch {
+	case len(payload) > 0 && payload[0] == '{':
+		if !json.Valid(payload) {
+			return nil, fmt.Errorf("the QR code does not hold a valid credential")
+		}
+		return &scannedCredential{Mode: mode, Format: FormatLDP, Credential: payload}, nil
+	case json.Unmarshal(payload, &compact) == nil:
+	default:
+		compact = string(payload)
+	}
+	jwt, _, _ := strings.Cut(compact, "~")
+	if _, _, err := decodeJWT(jwt); err != nil {
+		return nil, fmt.Errorf("the QR code does not hold a credential")
+	}
+	format := FormatJWT
+	if strings.Contains(compact, "~") {
+		format = FormatSDJWT
+	}
+	credential, _ := json.Marshal(compact)
+	return &scannedCredential{Mode: mode, Format: format, Credential: credential}, nil
+}
+
+// decodeCredentialCBOR extracts the credential from a CWT Claims Set made
+// by encodeCredentialCBOR.
+func decodeCredentialCBOR(data []byte) ([]byte, error) {
+	var claims map[interface{}]cbor.RawMessage
+	if err := cbor.Unmarshal(data, &claims); err != nil {
+		return nil, fmt.Errorf("CBOR QR: %w", err)
+	}
+	// PixelPass also CBOR-encodes plain JSON credentials; those have no
+	// CWT envelope.
+	vc, ok := claims[cwtClaimVC]
+	if !ok {
+		vc = data
+	}
+	dec, err := cbor.DecOptions{DefaultMapType: reflect.TypeOf(map[string]interface{}{})}.DecMode()
+	if err != nil {
+		return nil, err
+	}
+	var credential interface{}
+	if err := dec.Unmarshal(vc, &credential); err != nil {
+		return nil, fmt.Errorf("CBOR QR: %w", err)
+	}
+	return json.Marshal(credential)
+}
+
+// unpackJSONXT expands a JSON-XT URI with the Node pipeline's templates.
+func unpackJSONXT(uri string) (json.RawMessage, error) {
+	cmd := exec.Command(config.NodeBin, filepath.Join(config.ScriptsDir, "qr-decode.js"))
+	cmd.Stdin = strings.NewReader(uri)
+	cmd.Dir = config.ScriptsDir
+	cmd.Env = os.Environ()
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		errMsg := stderr.String()
+		if errMsg == "" {
+			errMsg = err.Error()
+		}
+		return nil, fmt.Errorf("JSON-XT decoding failed: %s", errMsg)
+	}
+	if !json.Valid(stdout.Bytes()) {
+		return nil, fmt.Errorf("JSON-XT decoding returned invalid JSON")
+	}
+	return stdout.Bytes(), nil
+}
+
+// resolveScannedLink looks up the credential behind a link-mode QR. Only
+// links to this server, PUBLIC_URL or a tenant domain, are followed, with
+// the same signature and claim token checks as the retrieval endpoint.
+func resolveScannedLink(raw string) (*StoredCredential, error) {
+	_, path, ok := ownLink(raw)
+	if !ok {
+		return nil, fmt.Errorf("the QR code links to another site; only credentials issued here can be looked up")
+	}
+	if code, ok := strings.CutPrefix(path, "/s/"); ok {
+		target, ok := shortLinks.Resolve(strings.TrimSuffix(code, "/"))
+		if !ok {
+			return nil, fmt.Errorf("the credential link has expired")
+		}
+		_, path, _ = ownLink(target)
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential link")
+	}
+	id, ok := strings.CutPrefix(u.Path, "/c/")
+	if !ok {
+		return nil, fmt.Errorf("the QR code does not link to a credential")
+	}
+
+	r := &http.Request{Method: http.MethodGet, URL: u, Header: make(http.Header)}
+	if err := verifyLinkSignature(r); err != nil {
+		return nil, fmt.Errorf("the credential link has been modified")
+	}
+	cred, ok := store.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("credential not found")
+	}
+	if !cred.ErasedAt.IsZero() {
+		return nil, fmt.Errorf("this credential has been erased at the holder's request")
+	}
+	if err := authorizeRetrieval(r, cred); err != nil {
+		return nil, fmt.Errorf("credential link: %w", err)
+	}
+	if cred.Encrypted {
+		return nil, fmt.Errorf("this credential is encrypted for its holder and cannot be verified here")
+	}
+	return cred, nil
+}
+
+// ScanResult is the outcome of verifying a scanned credential.
+type ScanResult struct {
+	Mode     string
+	Format   string
+	Verified bool
+	Expired  bool
+	Problems []string
+	Checks   []VerificationCheck
+	Message  string
+	Issuer   string
+	Subject  CredentialForm
+	Anchor   *AnchorProof
+}
+
+const (
+	CheckPassed  = "passed"
+	CheckFailed  = "failed"
+	CheckSkipped = "skipped"
+)
+
+// VerificationCheck is one check run on a credential.
+type VerificationCheck struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// check records the outcome of a check; failures are also problems.
+func (r *ScanResult) check(name, status, detail string) {
+	r.Checks = append(r.Checks, VerificationCheck{Check: name, Status: status, Detail: detail})
+	if status == CheckFailed {
+		r.Problems = append(r.Problems, detail)
+	}
+}
+
+// credentialClaims decodes a credential into its JWT claims, nil for a
+// JSON-LD credential, and its VC data model object, which for a JWT is the
+// "vc" claim when present.
+func credentialClaims(sc *scannedCredential) (claims, vc map[string]interface{}) {
+	var compact string
+	if json.Unmarshal(sc.Credential, &compact) == nil {
+		jwt, _, _ := strings.Cut(compact, "~")
+		_, payload, err := decodeJWT(jwt)
+		if err != nil {
+			return nil, nil
+		}
+		vc, _ = payload["vc"].(map[string]interface{})
+		return payload, vc
+	}
+	json.Unmarshal(sc.Credential, &vc)
+	return nil, vc
+}
+
+// credentialValidity returns the issuer and expiry of a decoded credential.
+func credentialValidity(sc *scannedCredential) (issuer string, expires time.Time) {
+	claims, vc := credentialClaims(sc)
+	issuer, _ = claims["iss"].(string)
+	if exp, ok := claims["exp"].(float64); ok {
+		expires = time.Unix(int64(exp), 0)
+	}
+
+	switch iss := vc["issuer"].(type) {
+	case string:
+		issuer = iss
+	case map[string]interface{}:
+		if id, ok := iss["id"].(string); ok {
+			issuer = id
+		}
+	}
+	for _, k := range []string{"validUntil", "expirationDate"} {
+		if s, ok := vc[k].(string); ok {
+			if t, err := time.Parse(time.RFC3339, s); err == nil {
+				expires = t
+			}
+		}
+	}
+	return issuer, expires
+}
+
+// verifyScanned runs full verification of a decoded credential.
+func verifyScanned(ctx context.Context, sc *scannedCredential) (*ScanResult, error) {
+	result := &ScanResult{Mode: sc.Mode, Format: sc.Format}
+	var expires time.Time
+	result.Issuer, expires = credentialValidity(sc)
+	if subject, err := credentialSubjectOf(&StoredCredential{Format: sc.Format, Credential: sc.Credential}); err == nil {
+		result.Subject = formFromSubject(subject)
+	}
+
+	if sc.Format == FormatSDJWT {
+		var sdjwt string
+		json.Unmarshal(sc.Credential, &sdjwt)
+		if err := checkDisclosures(sdjwt); err != nil {
+			result.check("disclosures", CheckFailed, err.Error())
+		} else {
+			result.check("disclosures", CheckPassed, "")
+		}
+	} else {
+		result.check("disclosures", CheckSkipped, "not an SD-JWT")
+	}
+	switch {
+	case expires.IsZero():
+		result.check("expiry", CheckSkipped, "no expiry date")
+	case time.Now().After(expires):
+		result.Expired = true
+		result.check("expiry", CheckFailed, "expired on "+expires.Format("2 January 2006"))
+	default:
+		result.check("expiry", CheckPassed, "valid until "+expires.Format("2 January 2006"))
+	}
+
+	result.checkTrust(result.Issuer)
+	result.checkDomainLinkage(sc)
+	cred, ok := storedCredentialFor(sc)
+	if !ok {
+		result.check("status", CheckSkipped, "not issued by this service")
+	} else if st := cred.Status; st != "" {
+		result.check("status", CheckFailed, st+" on "+cred.StatusChangedAt.Format("2 January 2006"))
+	} else {
+		result.check("status", CheckPassed, "")
+	}
+	result.checkTimestamp(sc, cred)
+	result.checkAnchor(sc)
+
+	var verified bool
+	var msg string
+	verification, err := agentVerify(ctx, sc)
+	switch {
+	case err == nil:
+		verified, msg = verification.Verified, verification.Message()
+		if verified {
+			result.check("signature", CheckPassed, "")
+		} else {
+			result.check("signature", CheckFailed, "signature verification failed: "+msg)
+		}
+	default:
+		// The agent is unreachable: fall back to verifying in-process.
+		localErr := verifyLocally(sc)
+		if errors.Is(localErr, errNotLocallyVerifiable) {
+			slog.Error("local verification", "err", localErr)
+			return nil, err
+		}
+		slog.WarnContext(ctx, "agent unavailable; checking the credential locally", "err", err)
+		verified, msg = localErr == nil, "Verified locally; the agent is unavailable"
+		if verified {
+			result.check("signature", CheckPassed, "verified locally; the agent is unavailable")
+		} else {
+			result.check("signature", CheckFailed, "signature verification failed: "+localErr.Error())
+		}
+	}
+	result.Message = msg
+	result.Verified = verified && len(result.Problems) == 0
+	return result, nil
+}
+
+// agentVerify has the agent check a credential's signature. Errors mean
+// the agent could not be reached, not that the credential is invalid.
+func agentVerify(ctx context.Context, sc *scannedCredential) (*AgentVerification, error) {
+	agent := agentClient.WithContext(ctx)
+	token, err := agent.GetToken()
+	if err != nil {
+		return nil, fmt.Errorf("authenticating with the agent: %w", err)
+	}
+	return agent.VerifyCredential(token, sc.Credential)
+}
+
+func handleScanPage(w http.ResponseWriter, r *http.Request) {
+	var data map[string]interface{}
+	if t := hostTenant(r); t != "" {
+		data = map[string]interface{}{"Theme": themeFor(t)}
+	}
+	if err := pages(r).ExecuteTemplate(w, "scan", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// handleScanVerify decodes and verifies the scanned QR data. Framed codes
+// are posted as one "data" value per frame; until the set is complete the
+// reply reports progress and the page keeps scanning.
+func handleScanVerify(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxScanData)
+	if err := r.ParseForm(); err != nil {
+		pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Error": "The scanned data is too large"})
+		return
+	}
+	frames := r.Form["data"]
+	if len(frames) == 0 {
+		pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Error": "Scan or paste a credential QR code"})
+		return
+	}
+
+	data := frames[0]
+	if strings.HasPrefix(strings.TrimSpace(data), qrFramePrefix) {
+		for i := range frames {
+			frames[i] = strings.TrimSpace(frames[i])
+		}
+		joined, have, total, err := joinScannedFrames(frames)
+		if err != nil {
+			pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Error": err.Error()})
+			return
+		}
+		if have < total {
+			pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Pending": true, "Have": have, "Total": total})
+			return
+		}
+		data = joined
+	}
+
+	sc, err := decodeScannedQR(data)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "scan decode error", "err", err)
+		recordVerification(VerifyChannelScan, "", nil, nil, err)
+		pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	result, err := verifyScanned(r.Context(), sc)
+	recordVerification(VerifyChannelScan, "", sc, result, err)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "scan verify error", "err", err)
+		pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Error": "Verification failed: " + err.Error()})
+		return
+	}
+	slog.InfoContext(r.Context(), "scanned credential", "format", result.Format, "qr_mode", result.Mode, "verified", result.Verified)
+	pages(r).ExecuteTemplate(w, "scan-result", map[string]interface{}{"Result": result})
+}
+
+// storedForVerification looks up a stored credential for the verification
+// URL. Unlike a link QR, the URL needs no claim token: the ID alone is
+// printed on the certificate.
+func storedForVerification(id string) (*scannedCredential, error) {
+	cred, ok := store.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("credential not found")
+	}
+	if !cred.ErasedAt.IsZero() {
+		return nil, fmt.Errorf("this credential has been erased at the holder's request")
+	}
+	if cred.Encrypted {
+		return nil, fmt.Errorf("this credential is encrypted for its holder and cannot be verified here")
+	}
+	return &scannedCredential{Format: cred.Format, Credential: cred.Credential, CredentialID: cred.ID}, nil
+}
+
+// handleVerifyPage verifies the credential a certificate's verification
+// URL names or, at /verify, asks for the credential ID printed on it.
+func handleVerifyPage(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.PathValue("id"))
+	if id == "" {
+		if id = strings.TrimSpace(r.URL.Query().Get("id")); id != "" {
+			http.Redirect(w, r, appPath("/verify/"+url.PathEscape(id)), http.StatusSeeOther)
+			return
+		}
+	}
+	data := map[string]interface{}{"CredentialID": id}
+	if t := hostTenant(r); t != "" {
+		data["Theme"] = themeFor(t)
+	}
+	if id != "" {
+		if !statusLimiter.Allow(clientIP(r)) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		sc, err := storedForVerification(id)
+		if err != nil {
+			recordVerification(VerifyChannelCertificate, "", nil, nil, err)
+			w.WriteHeader(http.StatusNotFound)
+			data["Error"] = err.Error()
+		} else {
+			result, err := verifyScanned(r.Context(), sc)
+			recordVerification(VerifyChannelCertificate, "", sc, result, err)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "certificate verify error", "err", err)
+				data["Error"] = "Verification failed: " + err.Error()
+			} else {
+				data["Result"] = result
+				if og := credentialOpenGraph(id, requestLanguage(r)); og != nil {
+					data["OpenGraph"] = og
+				}
+			}
+		}
+	}
+	if err := pages(r).ExecuteTemplate(w, "verify", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+	}
+}
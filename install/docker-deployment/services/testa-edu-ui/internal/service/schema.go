@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const credentialSchemaPath = "/schemas/education-credential.json"
+
+// CredentialSchema validates built credentials against a JSON Schema before
+// they are sent to the agent for signing. Only the subset of JSON Schema
+// needed by the credential schemas shipped in templates-data is supported:
+// type, const, enum, anyOf, required, properties, additionalProperties,
+// items, minItems, minLength, maxLength, pattern and the date, date-time and
+// uri formats.
+type CredentialSchema struct {
+	raw  []byte
+	root map[string]interface{}
+}
+
+var credSchema *CredentialSchema
+
+func LoadCredentialSchema(path string) (*CredentialSchema, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credential schema: %w", err)
+	}
+	var root map[string]interface{}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("parsing credential schema: %w", err)
+	}
+	return &CredentialSchema{raw: raw, root: root}, nil
+}
+
+// Validate checks v (typically the "credential" member of the sign payload)
+// and returns every violation found, or nil when the document is valid.
+func (s *CredentialSchema) Validate(v interface{}) error {
+	// Round-trip through JSON so Go maps/slices built in code compare the
+	// same way as decoded documents.
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("marshaling credential: %w", err)
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("unmarshaling credential: %w", err)
+	}
+
+	var errs []string
+	validateNode(s.root, doc, "$", &errs)
+	if len(errs) > 0 {
+		return &SchemaError{Problems: errs}
+	}
+	return nil
+}
+
+// SchemaError lists every schema violation for display to the user.
+type SchemaError struct {
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return "credential does not match schema: " + strings.Join(e.Problems, "; ")
+}
+
+func validateNode(schema map[string]interface{}, v interface{}, path string, errs *[]string) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, v) {
+		fail("expected type %v", t)
+		return
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		fail("must equal %v", c)
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, alt := range anyOf {
+			altSchema, _ := alt.(map[string]interface{})
+			var altErrs []string
+			validateNode(altSchema, v, path, &altErrs)
+			if len(altErrs) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any allowed alternative")
+		}
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail("must be one of %v", enum)
+		}
+	}
+
+	switch val := v.(type) {
+	case string:
+		n := len([]rune(val))
+		if min, ok := schema["minLength"].(float64); ok && n < int(min) {
+			fail("must be at least %d characters", int(min))
+		}
+		if max, ok := schema["maxLength"].(float64); ok && n > int(max) {
+			fail("must be at most %d characters", int(max))
+		}
+		if p, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				fail("invalid pattern in schema: %v", err)
+			} else if !re.MatchString(val) {
+				fail("does not match pattern %s", p)
+			}
+		}
+		if f, ok := schema["format"].(string); ok {
+			if err := checkFormat(f, val); err != nil {
+				fail("invalid %s: %v", f, err)
+			}
+		}
+
+	case []interface{}:
+		if min, ok := schema["minItems"].(float64); ok && len(val) < int(min) {
+			fail("must have at least %d items", int(min))
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				validateNode(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+
+	case map[string]interface{}:
+		if req, ok := schema["required"].([]interface{}); ok {
+			for _, r := range req {
+				name, _ := r.(string)
+				if _, present := val[name]; !present {
+					fail("missing required property %q", name)
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if ps, ok := props[k].(map[string]interface{}); ok {
+				validateNode(ps, val[k], path+"."+k, errs)
+			} else if ap, ok := schema["additionalProperties"].(bool); ok && !ap {
+				fail("unexpected property %q", k)
+			}
+		}
+	}
+}
+
+func matchesType(t interface{}, v interface{}) bool {
+	switch tt := t.(type) {
+	case string:
+		return isType(tt, v)
+	case []interface{}:
+		for _, x := range tt {
+			if s, ok := x.(string); ok && isType(s, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isType(t string, v interface{}) bool {
+	switch t {
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "object":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "number":
+		_, ok := v.(float64)
+		return ok
+	case "integer":
+		f, ok := v.(float64)
+		return ok && f == float64(int64(f))
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "null":
+		return v == nil
+	}
+	return false
+}
+
+func checkFormat(format, v string) error {
+	switch format {
+	case "date":
+		_, err := time.Parse("2006-01-02", v)
+		return err
+	case "date-time":
+		_, err := time.Parse(time.RFC3339, v)
+		return err
+	case "uri":
+		u, err := url.Parse(v)
+		if err != nil {
+			return err
+		}
+		if u.Scheme == "" {
+			return fmt.Errorf("missing scheme")
+		}
+	}
+	return nil
+}
+
+func jsonEqual(a, b interface{}) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return string(ab) == string(bb)
+}
+
+// credentialSchemaRef is the credentialSchema entry attached to issued
+// credentials, pointing back at the schema served by this deployment.
+func credentialSchemaRef() map[string]interface{} {
+	schemaType := "JsonSchemaValidator2018"
+	if config.VCVersion == vcdm2 {
+		schemaType = "JsonSchema"
+	}
+	return map[string]interface{}{
+		"id":   strings.TrimRight(config.PublicURL, "/") + credentialSchemaPath,
+		"type": schemaType,
+	}
+}
+
+func handleCredentialSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if _, err := w.Write(credSchema.raw); err != nil {
+		slog.ErrorContext(r.Context(), "schema write", "err", err)
+	}
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// schemaCredential returns a credential that matches the shipped schema.
+func schemaCredential() map[string]interface{} {
+	return map[string]interface{}{
+		"@context":     []interface{}{"https://www.w3.org/2018/credentials/v1"},
+		"type":         []interface{}{"VerifiableCredential", "EducationCredential"},
+		"issuer":       "did:key:z6MkIssuer",
+		"issuanceDate": "2026-01-01T00:00:00Z",
+		"credentialSchema": map[string]interface{}{
+			"id":   "https://edu.example/schemas/education-credential.json",
+			"type": "JsonSchemaValidator2018",
+		},
+		"credentialSubject": map[string]interface{}{
+			"id":             "did:key:z6MkStudent",
+			"type":           "EducationCredential",
+			"name":           "Amina Njeri",
+			"alumniOf":       "Testa University",
+			"degree":         "BSc Computer Science",
+			"graduationDate": "2025-12-01",
+			"gpa":            "3.8",
+		},
+	}
+}
+
+// TestCredentialSchema checks the shipped schema against a valid credential
+// and one violation of each keyword it uses.
+func TestCredentialSchema(t *testing.T) {
+	schema, err := LoadCredentialSchema(filepath.Join("templates-data", "education-credential.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := func(c map[string]interface{}) map[string]interface{} {
+		return c["credentialSubject"].(map[string]interface{})
+	}
+	tests := []struct {
+		name    string
+		edit    func(c map[string]interface{})
+		problem string // substring of the reported problem; empty when valid
+	}{
+		{"valid", func(c map[string]interface{}) {}, ""},
+		{"validFrom instead of issuanceDate", func(c map[string]interface{}) {
+			delete(c, "issuanceDate")
+			c["validFrom"] = "2026-01-01T00:00:00Z"
+		}, ""},
+		{"missing required", func(c map[string]interface{}) { delete(c, "issuer") }, `$: missing required property "issuer"`},
+		{"no anyOf alternative", func(c map[string]interface{}) { delete(c, "issuanceDate") }, "$: does not match any allowed alternative"},
+		{"wrong type", func(c map[string]interface{}) { c["type"] = "VerifiableCredential" }, "$.type: expected type array"},
+		{"minItems", func(c map[string]interface{}) { c["@context"] = []interface{}{} }, "$.@context: must have at least 1 items"},
+		{"items type", func(c map[string]interface{}) { c["@context"] = []interface{}{float64(1)} }, "$.@context[0]: expected type"},
+		{"pattern", func(c map[string]interface{}) { c["issuer"] = "https://issuer.example" }, "$.issuer: does not match pattern"},
+		{"date-time", func(c map[string]interface{}) { c["issuanceDate"] = "2026-01-01" }, "$.issuanceDate: invalid date-time"},
+		{"uri", func(c map[string]interface{}) {
+			c["credentialSchema"].(map[string]interface{})["id"] = "schemas/education-credential.json"
+		}, "$.credentialSchema.id: invalid uri: missing scheme"},
+		{"const", func(c map[string]interface{}) { subject(c)["type"] = "Diploma" }, "$.credentialSubject.type: must equal EducationCredential"},
+		{"minLength", func(c map[string]interface{}) { subject(c)["name"] = "" }, "$.credentialSubject.name: must be at least 1 characters"},
+		{"maxLength counts characters", func(c map[string]interface{}) { subject(c)["honors"] = strings.Repeat("é", 100) }, ""},
+		{"maxLength", func(c map[string]interface{}) { subject(c)["honors"] = strings.Repeat("é", 101) }, "$.credentialSubject.honors: must be at most 100 characters"},
+		{"date", func(c map[string]interface{}) { subject(c)["graduationDate"] = "01/12/2025" }, "$.credentialSubject.graduationDate: invalid date"},
+		{"additionalProperties", func(c map[string]interface{}) { subject(c)["email"] = "amina@example.com" }, `$.credentialSubject: unexpected property "email"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred := schemaCredential()
+			tt.edit(cred)
+			err := schema.Validate(cred)
+			if tt.problem == "" {
+				if err != nil {
+					t.Fatalf("Validate: %v", err)
+				}
+				return
+			}
+			var se *SchemaError
+			if !errors.As(err, &se) {
+				t.Fatalf("Validate = %v, want *SchemaError", err)
+			}
+			if len(se.Problems) != 1 || !strings.HasPrefix(se.Problems[0], tt.problem) {
+				t.Errorf("problems = %q, want one starting %q", se.Problems, tt.problem)
+			}
+		})
+	}
+}
+
+// TestValidateNode covers the keywords and types the shipped schema does
+// not use.
+func TestValidateNode(t *testing.T) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"properties": {
+			"level": {"enum": ["bachelor", "master"]},
+			"credits": {"type": "integer"},
+			"score": {"type": "number"},
+			"public": {"type": "boolean"},
+			"note": {"type": ["string", "null"]}
+		}
+	}`), &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		doc   string
+		valid bool
+	}{
+		{`{"level":"master","credits":180,"score":71.5,"public":true,"note":null}`, true},
+		{`{"note":"with distinction"}`, true},
+		{`{"level":"doctorate"}`, false},
+		{`{"credits":180.5}`, false},
+		{`{"score":"71.5"}`, false},
+		{`{"public":"yes"}`, false},
+		{`{"note":1}`, false},
+		{`[]`, false},
+	}
+	for _, tt := range tests {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatal(err)
+		}
+		var errs []string
+		validateNode(schema, doc, "$", &errs)
+		if (len(errs) == 0) != tt.valid {
+			t.Errorf("%s: problems = %q, want valid = %t", tt.doc, errs, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SCIM provisioning. An institution's identity provider keeps its tenant's
+// staff users (see tenants.go) in step with its directory over SCIM 2.0
+// (RFC 7643, RFC 7644), at /scim/v2 with the tenant's SCIM token as
+// bearer token. The operator issues the token with POST
+// /api/staff/tenants/<id>/scim-token; a new one replaces the old.
+//
+// Users are created, replaced, patched and deleted at /scim/v2/Users, and
+// listed with the filters identity providers look users up by, userName
+// eq and externalId eq. A user's roles (the core schema's roles
+// attribute, values maker and approver) are its maker-checker roles (see
+// approvals.go), and active false disables the user's token.
+// Provisioned users have no token: a staff member issues one with POST
+// /api/staff/tenants/<id>/users/<user>/token. Groups are not supported.
+
+const (
+	scimTokenPrefix  = "scim_"
+	scimUserSchema   = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimListSchema   = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema  = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimContentType  = "application/scim+json"
+	scimMaxPageCount = 200
+)
+
+// scimFilter is the filters supported: attribute eq "value".
+var scimFilter = regexp.MustCompile(`^(?i)(userName|externalId)\s+eq\s+"((?:[^"\\]|\\.)*)"$`)
+
+type scimValue struct {
+	Value   string `json:"value"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMUser is a staff user as a SCIM resource.
+type SCIMUser struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	UserName    string      `json:"userName"`
+	Name        *scimName   `json:"name,omitempty"`
+	DisplayName string      `json:"displayName,omitempty"`
+	Emails      []scimValue `json:"emails,omitempty"`
+	Roles       []scimValue `json:"roles,omitempty"`
+	Active      *bool       `json:"active,omitempty"`
+	Meta        *scimMeta   `json:"meta,omitempty"`
+}
+
+type scimName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+}
+
+// scimError answers with a SCIM error.
+func scimError(w http.ResponseWriter, status int, scimType, detail string) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	body := map[string]interface{}{"schemas": []string{scimErrorSchema}, "status": strconv.Itoa(status), "detail": detail}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	json.NewEncoder(w).Encode(body)
+}
+
+func scimWrite(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", scimContentType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// requireSCIM admits a tenant's identity provider, passing the tenant on.
+func requireSCIM(next func(http.ResponseWriter, *http.Request, Tenant)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		t, ok := tenants.AuthenticateSCIM(token)
+		if !strings.EqualFold(scheme, "Bearer") || !ok {
+			slog.WarnContext(r.Context(), "SCIM: unauthorized", "method", r.Method, "path", r.URL.Path)
+			scimError(w, http.StatusUnauthorized, "", "Unauthorized")
+			return
+		}
+		next(w, r, t)
+	}
+}
+
+// scimResource is a staff user as a SCIM resource.
+func scimResource(tenantID string, u TenantUser) SCIMUser {
+	active := !u.Disabled
+	res := SCIMUser{
+		Schemas:     []string{scimUserSchema},
+		ID:          u.ID,
+		ExternalID:  u.ExternalID,
+		UserName:    u.UserName,
+		DisplayName: u.Name,
+		Active:      &active,
+		Meta: &scimMeta{
+			ResourceType: "User",
+			Created:      u.CreatedAt,
+			Location:     tenantURL(tenantID) + "/scim/v2/Users/" + u.ID,
+		},
+	}
+	if res.UserName == "" {
+		res.UserName = u.Email
+	}
+	if u.Name != "" {
+		res.Name = &scimName{Formatted: u.Name}
+	}
+	if u.Email != "" {
+		res.Emails = []scimValue{{Value: u.Email, Primary: true}}
+	}
+	for _, role := range u.Roles {
+		res.Roles = append(res.Roles, scimValue{Value: role})
+	}
+	return res
+}
+
+// apply sets a staff user's details from a SCIM resource, as a create or
+// a replace does.
+func (res SCIMUser) apply(u *TenantUser) error {
+	if strings.TrimSpace(res.UserName) == "" {
+		return errors.New("userName is required")
+	}
+	u.UserName, u.ExternalID = strings.TrimSpace(res.UserName), res.ExternalID
+	u.Name = res.DisplayName
+	if res.Name != nil && u.Name == "" {
+		u.Name = res.Name.Formatted
+		if u.Name == "" {
+			u.Name = strings.TrimSpace(res.Name.GivenName + " " + res.Name.FamilyName)
+		}
+	}
+	if u.Name == "" {
+		u.Name = u.UserName
+	}
+	u.Email = ""
+	for i, e := range res.Emails {
+		if i == 0 || e.Primary {
+			u.Email = e.Value
+		}
+	}
+	u.Roles = nil
+	for _, role := range res.Roles {
+		if !slices.Contains(staffRoles, role.Value) {
+			return fmt.Errorf("unknown role %q; roles are maker and approver", role.Value)
+		}
+		if !slices.Contains(u.Roles, role.Value) {
+			u.Roles = append(u.Roles, role.Value)
+		}
+	}
+	u.Disabled = res.Active != nil && !*res.Active
+	return nil
+}
+
+// scimUser returns a tenant's user, by ID.
+func scimUser(tenantID, id string) (TenantUser, bool) {
+	t, ok := tenants.Get(tenantID)
+	if !ok {
+		return TenantUser{}, false
+	}
+	for _, u := range t.Users {
+		if u.ID == id {
+			return *u, true
+		}
+	}
+	return TenantUser{}, false
+}
+
+func handleSCIMUsers(w http.ResponseWriter, r *http.Request, t Tenant) {
+	var attr, value string
+	if f := r.URL.Query().Get("filter"); f != "" {
+		m := scimFilter.FindStringSubmatch(f)
+		if m == nil {
+			scimError(w, http.StatusBadRequest, "invalidFilter", `filters are userName eq "..." and externalId eq "..."`)
+			return
+		}
+		attr, value = strings.ToLower(m[1]), strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(m[2])
+	}
+	var matched []SCIMUser
+	for _, u := range t.Users {
+		res := scimResource(t.ID, *u)
+		if (attr == "username" && !strings.EqualFold(res.UserName, value)) || (attr == "externalid" && res.ExternalID != value) {
+			continue
+		}
+		matched = append(matched, res)
+	}
+	start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	start = max(start, 1)
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count > scimMaxPageCount {
+		count = scimMaxPageCount
+	}
+	page := matched[min(start-1, len(matched)):min(start-1+max(count, 0), len(matched))]
+	scimWrite(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{scimListSchema},
+		"totalResults": len(matched),
+		"startIndex":   start,
+		"itemsPerPage": len(page),
+		"Resources":    append([]SCIMUser{}, page...),
+	})
+}
+
+func handleSCIMUserGet(w http.ResponseWriter, r *http.Request, t Tenant) {
+	u, ok := scimUser(t.ID, r.PathValue("id"))
+	if !ok {
+		scimError(w, http.StatusNotFound, "", "Unknown user")
+		return
+	}
+	scimWrite(w, http.StatusOK, scimResource(t.ID, u))
+}
+
+// handleSCIMUserPut creates a user (POST) or replaces one (PUT).
+func handleSCIMUserPut(w http.ResponseWriter, r *http.Request, t Tenant) {
+	var res SCIMUser
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "expected a SCIM user")
+		return
+	}
+	var u TenantUser
+	if r.Method == http.MethodPut {
+		var ok bool
+		if u, ok = scimUser(t.ID, r.PathValue("id")); !ok {
+			scimError(w, http.StatusNotFound, "", "Unknown user")
+			return
+		}
+	}
+	if err := res.apply(&u); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	scimSave(w, r, t, u)
+}
+
+// scimPatchOp is an operation of a SCIM PATCH.
+type scimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// handleSCIMUserPatch applies a PATCH, by replacing the attributes it
+// changes on the user's resource. Identity providers send active as a
+// boolean or, some, a string.
+func handleSCIMUserPatch(w http.ResponseWriter, r *http.Request, t Tenant) {
+	var patch struct {
+		Operations []scimPatchOp `json:"Operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidSyntax", "expected a SCIM PatchOp")
+		return
+	}
+	u, ok := scimUser(t.ID, r.PathValue("id"))
+	if !ok {
+		scimError(w, http.StatusNotFound, "", "Unknown user")
+		return
+	}
+	res := scimResource(t.ID, u)
+	for _, op := range patch.Operations {
+		if err := res.patch(op); err != nil {
+			scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+			return
+		}
+	}
+	if err := res.apply(&u); err != nil {
+		scimError(w, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+	scimSave(w, r, t, u)
+}
+
+// patch applies an add, replace or remove operation to a resource.
+func (res *SCIMUser) patch(op scimPatchOp) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return fmt.Errorf("unsupported op %q", op.Op)
+	}
+	if op.Path == "" {
+		if kind == "remove" {
+			return errors.New("remove needs a path")
+		}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errors.New("a patch without a path needs an object value")
+		}
+		for path, value := range attrs {
+			if err := res.patch(scimPatchOp{Op: kind, Path: path, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var s string
+	json.Unmarshal(op.Value, &s)
+	switch strings.ToLower(op.Path) {
+	case "active":
+		active := kind != "remove"
+		if kind != "remove" {
+			var b bool
+			if json.Unmarshal(op.Value, &b) != nil {
+				if b, ok := parseSCIMBool(s); ok {
+					active = b
+				} else {
+					return errors.New("active must be true or false")
+				}
+			} else {
+				active = b
+			}
+		}
+		res.Active = &active
+	case "username":
+		res.UserName = s
+	case "externalid":
+		res.ExternalID = s
+	case "displayname":
+		res.DisplayName = s
+	case "name.formatted":
+		res.DisplayName, res.Name = s, &scimName{Formatted: s}
+	case "emails", `emails[type eq "work"].value`, `emails[primary eq true].value`:
+		res.Emails = nil
+		if kind == "remove" {
+			break
+		}
+		if s != "" {
+			res.Emails = []scimValue{{Value: s, Primary: true}}
+		} else if err := json.Unmarshal(op.Value, &res.Emails); err != nil {
+			return errors.New("emails must be a list of values")
+		}
+	case "roles":
+		var roles []scimValue
+		if kind != "remove" {
+			if err := json.Unmarshal(op.Value, &roles); err != nil {
+				return errors.New("roles must be a list of values")
+			}
+		}
+		switch kind {
+		case "add":
+			res.Roles = append(res.Roles, roles...)
+		case "replace":
+			res.Roles = roles
+		default:
+			res.Roles = nil
+		}
+	default:
+		return fmt.Errorf("unsupported path %q", op.Path)
+	}
+	return nil
+}
+
+func parseSCIMBool(s string) (bool, bool) {
+	switch strings.ToLower(s) {
+	case "true":
+		return true, true
+	case "false":
+		return false, true
+	}
+	return false, false
+}
+
+// scimSave stores a created or changed user and answers with it.
+func scimSave(w http.ResponseWriter, r *http.Request, t Tenant, u TenantUser) {
+	created := u.ID == ""
+	saved, err := tenants.PutUser(t.ID, u)
+	switch {
+	case errors.Is(err, errUserNameTaken):
+		scimError(w, http.StatusConflict, "uniqueness", "userName is taken")
+		return
+	case errors.Is(err, os.ErrNotExist):
+		scimError(w, http.StatusNotFound, "", "Unknown user")
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "SCIM: saving user", "err", err)
+		scimError(w, http.StatusInternalServerError, "", "Failed to save the user")
+		return
+	}
+	res := scimResource(t.ID, saved)
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+		w.Header().Set("Location", res.Meta.Location)
+	}
+	slog.InfoContext(r.Context(), "SCIM: user saved", "tenant", t.ID, "user", saved.ID, "created", created, "roles", saved.Roles, "disabled", saved.Disabled)
+	scimWrite(w, status, res)
+}
+
+func handleSCIMUserDelete(w http.ResponseWriter, r *http.Request, t Tenant) {
+	err := tenants.DeleteUser(t.ID, r.PathValue("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		scimError(w, http.StatusNotFound, "", "Unknown user")
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "SCIM: deleting user", "err", err)
+		scimError(w, http.StatusInternalServerError, "", "Failed to delete the user")
+		return
+	}
+	slog.InfoContext(r.Context(), "SCIM: user deleted", "tenant", t.ID, "user", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleSCIMServiceProviderConfig(w http.ResponseWriter, r *http.Request, t Tenant) {
+	unsupported := map[string]bool{"supported": false}
+	scimWrite(w, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": scimMaxPageCount},
+		"changePassword": unsupported,
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []map[string]string{{
+			"type": "oauthbearertoken", "name": "Bearer token", "description": "The tenant's SCIM token",
+		}},
+	})
+}
+
+func handleSCIMResourceTypes(w http.ResponseWriter, r *http.Request, t Tenant) {
+	scimWrite(w, http.StatusOK, map[string]interface{}{
+		"schemas":      []string{scimListSchema},
+		"totalResults": 1,
+		"Resources": []map[string]interface{}{{
+			"schemas":  []string{"urn:ietf:params:scim:schemas:core:2.0:ResourceType"},
+			"id":       "User",
+			"name":     "User",
+			"endpoint": "/Users",
+			"schema":   scimUserSchema,
+		}},
+	})
+}
+
+// handleSCIMToken issues a tenant's SCIM token.
+func handleSCIMToken(w http.ResponseWriter, r *http.Request) {
+	token, err := tenants.SetSCIMToken(r.PathValue("id"))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Unknown tenant", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "SCIM token error", "err", err)
+		http.Error(w, "Failed to issue the token", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: SCIM token issued", "tenant", r.PathValue("id"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"token": token, "url": tenantURL(r.PathValue("id")) + "/scim/v2"})
+}
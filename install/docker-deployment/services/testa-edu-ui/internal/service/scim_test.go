@@ -0,0 +1,101 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestSCIMProvisioning checks an identity provider can create, find,
+// deactivate, re-role and delete a tenant's staff users, and only its own.
+func TestSCIMProvisioning(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	config.StaffAPIToken = "staff-token-0123456789"
+	for _, id := range []string{"testa", "other"} {
+		if _, err := tenants.Create(Tenant{ID: id, Name: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call := func(method, path, token, body string, want int) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", scimContentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s: HTTP %d, want %d: %s", method, path, resp.StatusCode, want, data)
+		}
+		var out map[string]interface{}
+		json.Unmarshal(data, &out)
+		return out
+	}
+	scim := call("POST", "/api/staff/tenants/testa/scim-token", config.StaffAPIToken, "", http.StatusOK)["token"].(string)
+	otherSCIM := call("POST", "/api/staff/tenants/other/scim-token", config.StaffAPIToken, "", http.StatusOK)["token"].(string)
+	call("GET", "/scim/v2/Users", "scim_wrong", "", http.StatusUnauthorized)
+
+	user := call("POST", "/scim/v2/Users", scim, `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "jwanjiku@testa.ac.ke", "externalId": "00u1",
+		"name": {"givenName": "Jane", "familyName": "Wanjiku"},
+		"emails": [{"value": "jwanjiku@testa.ac.ke", "primary": true}],
+		"roles": [{"value": "maker"}], "active": true
+	}`, http.StatusCreated)
+	id := user["id"].(string)
+	if user["displayName"] != "Jane Wanjiku" {
+		t.Errorf("displayName = %v", user["displayName"])
+	}
+	call("POST", "/scim/v2/Users", scim, `{"userName": "JWanjiku@testa.ac.ke"}`, http.StatusConflict)
+	call("POST", "/scim/v2/Users", scim, `{"userName": "dean", "roles": [{"value": "admin"}]}`, http.StatusBadRequest)
+
+	list := call("GET", `/scim/v2/Users?filter=userName+eq+%22jwanjiku@testa.ac.ke%22`, scim, "", http.StatusOK)
+	if list["totalResults"] != 1.0 {
+		t.Errorf("userName filter: %v", list)
+	}
+	if list := call("GET", `/scim/v2/Users?filter=externalId+eq+%2200u2%22`, scim, "", http.StatusOK); list["totalResults"] != 0.0 {
+		t.Errorf("externalId filter: %v", list)
+	}
+	if list := call("GET", "/scim/v2/Users", otherSCIM, "", http.StatusOK); list["totalResults"] != 0.0 {
+		t.Errorf("another tenant's IdP sees the user: %v", list)
+	}
+	call("GET", "/scim/v2/Users/"+id, otherSCIM, "", http.StatusNotFound)
+
+	token := call("POST", "/api/staff/tenants/testa/users/"+id+"/token", config.StaffAPIToken, "", http.StatusOK)["token"].(string)
+	if _, u, ok := tenants.Authenticate(token); !ok || u.UserName != "jwanjiku@testa.ac.ke" || !slices.Contains(u.Roles, RoleMaker) {
+		t.Fatalf("provisioned user's token: %+v, %v", u, ok)
+	}
+
+	user = call("PATCH", "/scim/v2/Users/"+id, scim, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [
+			{"op": "Add", "path": "roles", "value": [{"value": "approver"}]},
+			{"op": "Replace", "value": {"active": "False"}}
+		]
+	}`, http.StatusOK)
+	if user["active"] != false || len(user["roles"].([]interface{})) != 2 {
+		t.Errorf("patched user: %v", user)
+	}
+	if _, _, ok := tenants.Authenticate(token); ok {
+		t.Error("a deactivated user's token is accepted")
+	}
+	call("PATCH", "/scim/v2/Users/"+id, scim, `{"Operations": [{"op": "replace", "path": "active", "value": true}]}`, http.StatusOK)
+	if _, u, ok := tenants.Authenticate(token); !ok || !slices.Contains(u.Roles, RoleApprover) {
+		t.Errorf("reactivated user: %+v, %v", u, ok)
+	}
+
+	call("DELETE", "/scim/v2/Users/"+id, scim, "", http.StatusNoContent)
+	call("GET", "/scim/v2/Users/"+id, scim, "", http.StatusNotFound)
+	if _, _, ok := tenants.Authenticate(token); ok {
+		t.Error("a deleted user's token is accepted")
+	}
+}
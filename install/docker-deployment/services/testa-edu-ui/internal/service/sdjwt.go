@@ -0,0 +1,265 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FormatSDJWT issues the credential as an SD-JWT VC (IETF
+// draft-ietf-oauth-sd-jwt-vc): an issuer-signed JWT plus one disclosure per
+// selectively disclosable claim, joined with "~".
+const FormatSDJWT = "vc+sd-jwt"
+
+// Disclosure is a single SD-JWT disclosure: the base64url-encoded JSON
+// array [salt, claim name, claim value] and its SHA-256 digest.
+type Disclosure struct {
+	Salt    string
+	Claim   string
+	Value   interface{}
+	Encoded string
+	Digest  string
+}
+
+func newDisclosure(claim string, value interface{}) (Disclosure, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return Disclosure{}, fmt.Errorf("generating salt: %w", err)
+	}
+	d := Disclosure{
+		Salt:  base64.RawURLEncoding.EncodeToString(salt),
+		Claim: claim,
+		Value: value,
+	}
+	raw, err := json.Marshal([]interface{}{d.Salt, d.Claim, d.Value})
+	if err != nil {
+		return Disclosure{}, fmt.Errorf("encoding disclosure: %w", err)
+	}
+	d.Encoded = base64.RawURLEncoding.EncodeToString(raw)
+	d.Digest = disclosureDigest(d.Encoded)
+	return d, nil
+}
+
+func disclosureDigest(encoded string) string {
+	sum := sha256.Sum256([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func parseDisclosure(encoded string) (Disclosure, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Disclosure{}, fmt.Errorf("decoding disclosure: %w", err)
+	}
+	var parts []interface{}
+	if err := json.Unmarshal(raw, &parts); err != nil || len(parts) != 3 {
+		return Disclosure{}, fmt.Errorf("malformed disclosure")
+	}
+	salt, _ := parts[0].(string)
+	claim, _ := parts[1].(string)
+	return Disclosure{
+		Salt:    salt,
+		Claim:   claim,
+		Value:   parts[2],
+		Encoded: encoded,
+		Digest:  disclosureDigest(encoded),
+	}, nil
+}
+
+// buildSDJWTClaims converts a JSON-LD credential into SD-JWT VC issuer
+// claims. Subject properties become top-level claims; those listed in
+// sdClaims are replaced by digests in "_sd" and returned as disclosures.
+func buildSDJWTClaims(credential map[string]interface{}, sdClaims []string) (map[string]interface{}, []Disclosure, error) {
+	subject, _ := credential["credentialSubject"].(map[string]interface{})
+	issued := credentialIssued(nil, credential)
+	if issued.IsZero() {
+		issued = time.Now()
+	}
+
+	claims := map[string]interface{}{
+		"iss":     credential["issuer"],
+		"iat":     issued.Unix(),
+		"vct":     "EducationCredential",
+		"_sd_alg": "sha-256",
+	}
+	if id, ok := subject["id"]; ok {
+		claims["sub"] = id
+	}
+
+	selective := make(map[string]bool, len(sdClaims))
+	for _, c := range sdClaims {
+		selective[c] = true
+	}
+
+	keys := make([]string, 0, len(subject))
+	for k := range subject {
+		if k != "id" && k != "type" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var disclosures []Disclosure
+	var digests []string
+	for _, k := range keys {
+		if !selective[k] {
+			claims[k] = subject[k]
+			continue
+		}
+		d, err := newDisclosure(k, subject[k])
+		if err != nil {
+			return nil, nil, err
+		}
+		disclosures = append(disclosures, d)
+		digests = append(digests, d.Digest)
+	}
+	// Digests are sorted so their order reveals nothing about claim order.
+	sort.Strings(digests)
+	if len(digests) > 0 {
+		claims["_sd"] = digests
+	}
+	return claims, disclosures, nil
+}
+
+// assembleSDJWT joins the issuer JWT with disclosures in combined format.
+func assembleSDJWT(issuerJWT string, disclosures []Disclosure) string {
+	var b strings.Builder
+	b.WriteString(issuerJWT)
+	b.WriteByte('~')
+	for _, d := range disclosures {
+		b.WriteString(d.Encoded)
+		b.WriteByte('~')
+	}
+	return b.String()
+}
+
+// splitSDJWT separates an SD-JWT into its issuer JWT and disclosures.
+func splitSDJWT(sdjwt string) (string, []Disclosure, error) {
+	parts := strings.Split(sdjwt, "~")
+	if len(parts) < 2 {
+		return "", nil, fmt.Errorf("not an SD-JWT")
+	}
+	var disclosures []Disclosure
+	for _, p := range parts[1:] {
+		if p == "" {
+			continue
+		}
+		d, err := parseDisclosure(p)
+		if err != nil {
+			return "", nil, err
+		}
+		disclosures = append(disclosures, d)
+	}
+	return parts[0], disclosures, nil
+}
+
+// checkDisclosures verifies that every disclosure is committed to by a
+// digest in the issuer-signed payload, and that no digest is repeated in
+// the payload or among the disclosures.
+func checkDisclosures(sdjwt string) error {
+	issuerJWT, disclosures, err := splitSDJWT(sdjwt)
+	if err != nil {
+		return err
+	}
+	_, payload, err := decodeJWT(issuerJWT)
+	if err != nil {
+		return err
+	}
+	committed := make(map[string]bool)
+	if sd, ok := payload["_sd"].([]interface{}); ok {
+		for _, d := range sd {
+			s, ok := d.(string)
+			if !ok {
+				continue
+			}
+			if committed[s] {
+				return fmt.Errorf("digest %s is repeated in the issuer JWT", s)
+			}
+			committed[s] = true
+		}
+	}
+	disclosed := make(map[string]bool, len(disclosures))
+	for _, d := range disclosures {
+		if !committed[d.Digest] {
+			return fmt.Errorf("disclosure for %q is not committed in the issuer JWT", d.Claim)
+		}
+		if disclosed[d.Digest] {
+			return fmt.Errorf("disclosure for %q is repeated", d.Claim)
+		}
+		disclosed[d.Digest] = true
+	}
+	return nil
+}
+
+// presentSDJWT produces a presentation revealing only the named claims.
+func presentSDJWT(sdjwt string, reveal []string) (string, error) {
+	issuerJWT, disclosures, err := splitSDJWT(sdjwt)
+	if err != nil {
+		return "", err
+	}
+	keep := make(map[string]bool, len(reveal))
+	for _, c := range reveal {
+		keep[c] = true
+	}
+	var selected []Disclosure
+	for _, d := range disclosures {
+		if keep[d.Claim] {
+			selected = append(selected, d)
+		}
+	}
+	return assembleSDJWT(issuerJWT, selected), nil
+}
+
+// sdjwtString returns the stored SD-JWT for a session, if it was issued in
+// that format.
+func sdjwtString(sess *Session) (string, bool) {
+	if sess == nil || sess.Format != FormatSDJWT || sess.SignedCredential == nil {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(sess.SignedCredential, &s); err != nil {
+		return "", false
+	}
+	return s, true
+}
+
+func handleDownloadSDJWT(w http.ResponseWriter, r *http.Request) {
+	sdjwt, ok := sdjwtString(getSession(r))
+	if !ok {
+		http.Error(w, "No SD-JWT credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vc+sd-jwt")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-credential.sd-jwt\"")
+	w.Write([]byte(sdjwt))
+}
+
+// handleSDJWTPresent builds a presentation containing only the disclosures
+// the holder ticked on the results page.
+func handleSDJWTPresent(w http.ResponseWriter, r *http.Request) {
+	sdjwt, ok := sdjwtString(getSession(r))
+	if !ok {
+		http.Error(w, "No SD-JWT credential available. Please issue a credential first.", http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Invalid form data", http.StatusBadRequest)
+		return
+	}
+
+	presentation, err := presentSDJWT(sdjwt, r.Form["disclose"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vc+sd-jwt")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-edu-presentation.sd-jwt\"")
+	w.Write([]byte(presentation))
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// testIssuerJWT encodes claims as an issuer JWT; checkDisclosures reads the
+// payload only, so the signature is a placeholder.
+func testIssuerJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"EdDSA","typ":"vc+sd-jwt"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+}
+
+func TestNewDisclosure(t *testing.T) {
+	d, err := newDisclosure("studentName", "Amina Odhiambo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseDisclosure(d.Encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Salt != d.Salt || parsed.Claim != "studentName" || parsed.Value != "Amina Odhiambo" || parsed.Digest != d.Digest {
+		t.Errorf("parsed %+v, want %+v", parsed, d)
+	}
+	if again, _ := newDisclosure("studentName", "Amina Odhiambo"); again.Salt == d.Salt || again.Digest == d.Digest {
+		t.Error("two disclosures of one claim share a salt")
+	}
+}
+
+func TestCheckDisclosures(t *testing.T) {
+	name, _ := newDisclosure("studentName", "Amina Odhiambo")
+	grade, _ := newDisclosure("grade", "First Class Honours")
+	stray, _ := newDisclosure("dateOfBirth", "2001-04-12")
+	issuerJWT := testIssuerJWT(t, map[string]interface{}{"iss": "did:example:uni", "_sd": []string{name.Digest, grade.Digest}})
+
+	tampered := []byte(name.Encoded)
+	tampered[len(tampered)/2] ^= 1
+
+	tests := []struct {
+		name, sdjwt string
+		wantErr     string
+	}{
+		{"all disclosed", assembleSDJWT(issuerJWT, []Disclosure{name, grade}), ""},
+		{"none disclosed", assembleSDJWT(issuerJWT, nil), ""},
+		{"tampered disclosure", issuerJWT + "~" + string(tampered) + "~", "disclosure"},
+		{"unknown digest", assembleSDJWT(issuerJWT, []Disclosure{name, stray}), "not committed"},
+		{"duplicate disclosure", assembleSDJWT(issuerJWT, []Disclosure{name, grade, name}), "repeated"},
+		{"duplicate digest", assembleSDJWT(testIssuerJWT(t, map[string]interface{}{"_sd": []string{name.Digest, name.Digest}}), []Disclosure{name}), "repeated"},
+		{"not an SD-JWT", issuerJWT, "not an SD-JWT"},
+	}
+	for _, tt := range tests {
+		err := checkDisclosures(tt.sdjwt)
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPresentSDJWT(t *testing.T) {
+	name, _ := newDisclosure("studentName", "Amina Odhiambo")
+	grade, _ := newDisclosure("grade", "First Class Honours")
+	issuerJWT := testIssuerJWT(t, map[string]interface{}{"_sd": []string{name.Digest, grade.Digest}})
+	sdjwt := assembleSDJWT(issuerJWT, []Disclosure{name, grade})
+
+	tests := []struct {
+		name   string
+		reveal []string
+		want   []string
+	}{
+		{"one claim", []string{"grade"}, []string{"grade"}},
+		{"both claims", []string{"studentName", "grade"}, []string{"studentName", "grade"}},
+		{"nothing", nil, nil},
+		{"unknown claim", []string{"dateOfBirth"}, nil},
+	}
+	for _, tt := range tests {
+		presented, err := presentSDJWT(sdjwt, tt.reveal)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := checkDisclosures(presented); err != nil {
+			t.Errorf("%s: presentation rejected: %v", tt.name, err)
+		}
+		jwt, disclosures, _ := splitSDJWT(presented)
+		if jwt != issuerJWT {
+			t.Errorf("%s: issuer JWT changed", tt.name)
+		}
+		var got []string
+		for _, d := range disclosures {
+			got = append(got, d.Claim)
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: disclosed %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
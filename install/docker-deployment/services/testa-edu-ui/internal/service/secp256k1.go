@@ -0,0 +1,102 @@
+package service
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+)
+
+// secp256k1, the curve behind ES256K and EcdsaSecp256k1Signature2019. The
+// standard library has no implementation; point encoding, signing and
+// verification use decred's constant-time secp256k1 package. Signatures are
+// deterministic (RFC 6979) and low S, as Bitcoin-derived verifiers require.
+
+type secp256k1PublicKey struct {
+	X, Y *big.Int
+}
+
+type secp256k1PrivateKey struct {
+	secp256k1PublicKey
+	key *secp256k1.PrivateKey
+}
+
+// secp256k1N is the order of the curve.
+var secp256k1N = secp256k1.Params().N
+
+// parseSecp256k1 decodes a compressed (33 byte) or uncompressed (65 byte)
+// SEC 1 point.
+func parseSecp256k1(b []byte) (*secp256k1PublicKey, error) {
+	switch {
+	case len(b) == 65 && b[0] == 4:
+	case len(b) == 33 && (b[0] == 2 || b[0] == 3):
+	default:
+		return nil, errors.New("invalid secp256k1 point encoding")
+	}
+	pub, err := secp256k1.ParsePubKey(b)
+	if err != nil {
+		return nil, errors.New("secp256k1 point is not on the curve")
+	}
+	return &secp256k1PublicKey{pub.X(), pub.Y()}, nil
+}
+
+func (pub *secp256k1PublicKey) pubKey() *secp256k1.PublicKey {
+	var x, y secp256k1.FieldVal
+	x.SetByteSlice(pub.X.Bytes())
+	y.SetByteSlice(pub.Y.Bytes())
+	return secp256k1.NewPublicKey(&x, &y)
+}
+
+// verifySecp256k1 checks an ECDSA signature (r, s) over a message digest.
+func verifySecp256k1(pub *secp256k1PublicKey, digest []byte, r, s *big.Int) bool {
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(secp256k1N) >= 0 || s.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+	var rs, ss secp256k1.ModNScalar
+	rs.SetByteSlice(r.Bytes())
+	ss.SetByteSlice(s.Bytes())
+	return ecdsa.NewSignature(&rs, &ss).Verify(digest, pub.pubKey())
+}
+
+// compressed returns the 33 byte SEC 1 encoding of the point.
+func (pub *secp256k1PublicKey) compressed() []byte {
+	return pub.pubKey().SerializeCompressed()
+}
+
+// newSecp256k1PrivateKey takes a 32 byte big-endian scalar in [1, n).
+func newSecp256k1PrivateKey(d []byte) (*secp256k1PrivateKey, error) {
+	var scalar secp256k1.ModNScalar
+	if len(d) != 32 || scalar.SetByteSlice(d) || scalar.IsZero() {
+		return nil, errors.New("invalid secp256k1 private key")
+	}
+	return secp256k1Key(secp256k1.NewPrivateKey(&scalar)), nil
+}
+
+func generateSecp256k1Key() (*secp256k1PrivateKey, error) {
+	k, err := secp256k1.GeneratePrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	return secp256k1Key(k), nil
+}
+
+func secp256k1Key(k *secp256k1.PrivateKey) *secp256k1PrivateKey {
+	pub := k.PubKey()
+	return &secp256k1PrivateKey{secp256k1PublicKey{pub.X(), pub.Y()}, k}
+}
+
+// bytes returns the 32 byte private scalar.
+func (k *secp256k1PrivateKey) bytes() []byte {
+	return k.key.Serialize()
+}
+
+// sign returns an ECDSA signature over a digest as r‖s.
+func (k *secp256k1PrivateKey) sign(digest []byte) []byte {
+	sig := ecdsa.Sign(k.key, digest)
+	r, s := sig.R(), sig.S()
+	raw := make([]byte, 64)
+	r.PutBytesUnchecked(raw[:32])
+	s.PutBytesUnchecked(raw[32:])
+	return raw
+}
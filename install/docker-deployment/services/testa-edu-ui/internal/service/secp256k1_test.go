@@ -0,0 +1,120 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("test vector: %v", err)
+	}
+	return b
+}
+
+func scalar(n int64) []byte {
+	return big.NewInt(n).FillBytes(make([]byte, 32))
+}
+
+// TestSecp256k1PublicKeys checks public key derivation and point encoding
+// against known multiples of the generator.
+func TestSecp256k1PublicKeys(t *testing.T) {
+	tests := []struct {
+		d          int64
+		compressed string
+	}{
+		{1, "0279be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{2, "02c6047f9441ed7d6d3045406e95c07cd85c778e4b8cef3ca7abac09b95c709ee5"},
+		{3, "02f9308a019258c31049344f85f89d5229b531c845836f99b08601f113bce036f9"},
+	}
+	for _, tt := range tests {
+		k, err := newSecp256k1PrivateKey(scalar(tt.d))
+		if err != nil {
+			t.Fatalf("d=%d: %v", tt.d, err)
+		}
+		if got := hex.EncodeToString(k.compressed()); got != tt.compressed {
+			t.Errorf("d=%d: public key %s, want %s", tt.d, got, tt.compressed)
+		}
+		pub, err := parseSecp256k1(mustHex(t, tt.compressed))
+		if err != nil {
+			t.Fatalf("d=%d: parsing: %v", tt.d, err)
+		}
+		if pub.X.Cmp(k.X) != 0 || pub.Y.Cmp(k.Y) != 0 {
+			t.Errorf("d=%d: parsed point differs from derived point", tt.d)
+		}
+	}
+}
+
+func TestSecp256k1InvalidInputs(t *testing.T) {
+	keys := map[string][]byte{
+		"zero":  scalar(0),
+		"order": secp256k1N.FillBytes(make([]byte, 32)),
+		"short": scalar(1)[1:],
+	}
+	for name, d := range keys {
+		if _, err := newSecp256k1PrivateKey(d); err == nil {
+			t.Errorf("private key %s: accepted", name)
+		}
+	}
+	g := "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"
+	points := map[string]string{
+		"hybrid":       "06" + g + strings.Repeat("00", 32),
+		"not on curve": "04" + g + strings.Repeat("00", 32),
+		"x too large":  "02" + strings.Repeat("ff", 32),
+		"truncated":    "02" + g[:62],
+	}
+	for name, p := range points {
+		if _, err := parseSecp256k1(mustHex(t, p)); err == nil {
+			t.Errorf("point %s: accepted", name)
+		}
+	}
+}
+
+// TestSecp256k1Sign checks signing against the RFC 6979 vector for
+// private key 1 and "Satoshi Nakamoto", and the checks verification makes.
+func TestSecp256k1Sign(t *testing.T) {
+	k, err := newSecp256k1PrivateKey(scalar(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("Satoshi Nakamoto")
+	sig, err := secp256k1Signer{k}.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := mustHex(t, "934b1ea10a4b3c1757e2b0c017d0b6143ce3c9a7e6a4a49860d7a6ab210ee3d8"+
+		"2442ce9d2b916064108014783e923ec36b49743e2ffa1c4496f01a512aafd9e5")
+	if !bytes.Equal(sig, want) {
+		t.Errorf("signature %x, want %x", sig, want)
+	}
+
+	digest := sha256.Sum256(msg)
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	highS := new(big.Int).Sub(secp256k1N, s)
+	tests := []struct {
+		name   string
+		digest []byte
+		r, s   *big.Int
+		valid  bool
+	}{
+		{"valid", digest[:], r, s, true},
+		{"high S", digest[:], r, highS, true},
+		{"other message", make([]byte, 32), r, s, false},
+		{"zero r", digest[:], big.NewInt(0), s, false},
+		{"r not below n", digest[:], new(big.Int).Add(r, secp256k1N), s, false},
+	}
+	for _, tt := range tests {
+		if got := verifySecp256k1(&k.secp256k1PublicKey, tt.digest, tt.r, tt.s); got != tt.valid {
+			t.Errorf("%s: verified = %t, want %t", tt.name, got, tt.valid)
+		}
+	}
+	if err := verifyDetached(&k.secp256k1PublicKey, msg, sig); err != nil {
+		t.Errorf("verifyDetached: %v", err)
+	}
+}
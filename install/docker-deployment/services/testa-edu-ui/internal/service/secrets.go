@@ -0,0 +1,189 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Secrets. The settings in secretSettings can be kept out of the
+// environment in two ways:
+//
+//   - <NAME>_FILE names a file holding the secret, as Docker and
+//     Kubernetes secrets are mounted, e.g. API_KEY_FILE=/run/secrets/api_key.
+//   - The setting is a Vault reference, vault:<path>#<field>, read from
+//     HashiCorp Vault at VAULT_ADDR with VAULT_TOKEN (or VAULT_TOKEN_FILE),
+//     e.g. API_KEY=vault:secret/data/testa#api_key. KV version 1 and 2
+//     paths both work; VAULT_NAMESPACE and VAULT_CACERT are honoured.
+//
+// Secrets are resolved when the configuration is loaded, and again on a
+// reload, so a rotated secret is picked up with SIGHUP. They are held in
+// memory only: the environment, and so the scripts the service runs, keeps
+// the file name or reference.
+
+// secretSettings are the settings that may be given as a file or a Vault
+// reference.
+var secretSettings = []string{
+	"API_KEY", "STAFF_API_TOKEN", "METRICS_TOKEN", "LINK_SIGNING_KEY", "HOLDER_KEY", "BACKUP_KEY", "SIGNING_KEY",
+	"SMTP_PASSWORD", "TWILIO_AUTH_TOKEN", "AT_API_KEY", "ORCID_CLIENT_SECRET",
+	"AGENT_CLIENT_SECRET", "OTEL_EXPORTER_OTLP_HEADERS", "SENTRY_DSN", "VAULT_TOKEN",
+}
+
+const vaultPrefix = "vault:"
+
+const vaultTimeout = 10 * time.Second
+
+var (
+	secretsMu sync.RWMutex
+	// secretValues are the resolved secrets, which getenv returns instead
+	// of the environment's file names and references.
+	secretValues = map[string]string{}
+)
+
+// secretValue is the resolved value of a secret setting, if it has one.
+func secretValue(key string) (string, bool) {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	v, ok := secretValues[key]
+	return v, ok
+}
+
+// resolveSecrets reads the secret settings given as files, then those
+// given as Vault references.
+func resolveSecrets() error {
+	values := map[string]string{}
+	for _, name := range secretSettings {
+		path := rawenv(name + "_FILE")
+		if path == "" {
+			continue
+		}
+		if rawenv(name) != "" {
+			return fmt.Errorf("%s and %s_FILE are both set", name, name)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("%s_FILE: %w", name, err)
+		}
+		values[name] = strings.TrimRight(string(data), "\r\n")
+	}
+
+	var vault *vaultClient
+	for _, name := range secretSettings {
+		ref, ok := strings.CutPrefix(rawenv(name), vaultPrefix)
+		if !ok || name == "VAULT_TOKEN" {
+			continue
+		}
+		if vault == nil {
+			token, ok := values["VAULT_TOKEN"]
+			if !ok {
+				token = rawenv("VAULT_TOKEN")
+			}
+			var err error
+			if vault, err = newVaultClient(rawenv("VAULT_ADDR"), token, rawenv("VAULT_NAMESPACE"), rawenv("VAULT_CACERT")); err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+		}
+		v, err := vault.read(ref)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		values[name] = v
+	}
+
+	secretsMu.Lock()
+	secretValues = values
+	secretsMu.Unlock()
+	return nil
+}
+
+// vaultClient reads secrets from Vault's HTTP API.
+type vaultClient struct {
+	addr, token, namespace string
+	client                 *http.Client
+	// secrets caches the secrets read, by path.
+	secrets map[string]map[string]interface{}
+}
+
+func newVaultClient(addr, token, namespace, caFile string) (*vaultClient, error) {
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("vault references need VAULT_ADDR and VAULT_TOKEN")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("VAULT_CACERT: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("VAULT_CACERT: no certificates in %s", caFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &vaultClient{
+		addr:      strings.TrimRight(addr, "/"),
+		token:     token,
+		namespace: namespace,
+		client:    &http.Client{Timeout: vaultTimeout, Transport: transport},
+		secrets:   map[string]map[string]interface{}{},
+	}, nil
+}
+
+// read returns the field of a reference, <path>#<field>.
+func (v *vaultClient) read(ref string) (string, error) {
+	path, field, ok := strings.Cut(ref, "#")
+	path = strings.Trim(path, "/")
+	if !ok || path == "" || field == "" {
+		return "", fmt.Errorf("invalid vault reference %q, want vault:<path>#<field>", vaultPrefix+ref)
+	}
+	secret, ok := v.secrets[path]
+	if !ok {
+		var err error
+		if secret, err = v.get(path); err != nil {
+			return "", err
+		}
+		v.secrets[path] = secret
+	}
+	value, ok := secret[field].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no string field %q", path, field)
+	}
+	return value, nil
+}
+
+// get reads the secret at path. KV version 2 nests its fields under
+// data.data, version 1 under data.
+func (v *vaultClient) get(path string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, v.addr+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("reading vault secret %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading vault secret %s: %s", path, resp.Status)
+	}
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("reading vault secret %s: %w", path, err)
+	}
+	if nested, ok := body.Data["data"].(map[string]interface{}); ok && body.Data["metadata"] != nil {
+		return nested, nil
+	}
+	return body.Data, nil
+}
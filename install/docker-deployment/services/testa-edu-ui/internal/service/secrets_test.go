@@ -0,0 +1,67 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/testa":
+			w.Write([]byte(`{"data":{"data":{"staff_token":"staff-token-from-vault"},"metadata":{"version":3}}}`))
+		case "/v1/kv/testa":
+			w.Write([]byte(`{"data":{"smtp_password":"smtp-from-vault"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer vault.Close()
+	t.Cleanup(func() { secretValues = map[string]string{} })
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "api_key"), []byte("api-key-from-a-file\n"), 0o600)
+	os.WriteFile(filepath.Join(dir, "vault_token"), []byte("vault-token"), 0o600)
+	t.Setenv("API_KEY_FILE", filepath.Join(dir, "api_key"))
+	t.Setenv("VAULT_TOKEN_FILE", filepath.Join(dir, "vault_token"))
+	t.Setenv("VAULT_ADDR", vault.URL)
+	t.Setenv("STAFF_API_TOKEN", "vault:secret/data/testa#staff_token")
+	t.Setenv("SMTP_PASSWORD", "vault:kv/testa#smtp_password")
+	os.Unsetenv("API_KEY")
+	if err := resolveSecrets(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"API_KEY":         "api-key-from-a-file",
+		"STAFF_API_TOKEN": "staff-token-from-vault",
+		"SMTP_PASSWORD":   "smtp-from-vault",
+	} {
+		if got := getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if os.Getenv("STAFF_API_TOKEN") != "vault:secret/data/testa#staff_token" {
+		t.Error("the secret was put in the environment")
+	}
+
+	for _, tc := range []struct{ key, value string }{
+		{"API_KEY", "set-as-well"},
+		{"SMTP_PASSWORD", "vault:kv/testa#missing"},
+		{"SMTP_PASSWORD", "vault:kv/testa"},
+		{"VAULT_TOKEN_FILE", filepath.Join(dir, "missing")},
+	} {
+		t.Run(tc.key, func(t *testing.T) {
+			t.Setenv(tc.key, tc.value)
+			if err := resolveSecrets(); err == nil {
+				t.Errorf("%s=%s resolved", tc.key, tc.value)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Secure boot. API_KEY and ISSUER_DID have no defaults, but deployments
+// copied from the local CREDEBL stack's samples carry its sample agent API
+// key and issuer DID. With SECURE_BOOT=true the service refuses to start on
+// them, or on other placeholder secrets, a demo agent or an unverified
+// agent certificate, and keys it would otherwise run without (HOLDER_KEY)
+// are generated once per install and kept in DATA_DIR, as the link signing
+// key is.
+//
+// SECURE_BOOT defaults to true in production builds (go build -tags
+// production, as the Dockerfile builds) and in the Docker Compose
+// deployment, and to false otherwise.
+
+const (
+	sampleAPIKey    = "supersecret-that-too-16chars"
+	sampleIssuerDID = "did:polygon:0xD3A288e4cCeb5ADE57c5B674475d6728Af3bD9Fd"
+)
+
+// placeholderSecrets are secrets copied from samples, matched ignoring case.
+var placeholderSecrets = []string{"changeme", "change-me", "secret", "password", "supersecret", "test", "example"}
+
+// isPlaceholderSecret reports whether a secret is a sample's.
+func isPlaceholderSecret(s string) bool {
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "supersecret") {
+		return true
+	}
+	for _, p := range placeholderSecrets {
+		if lower == p {
+			return true
+		}
+	}
+	return false
+}
+
+// secureBootProblems returns why c may not start in secure boot mode.
+func secureBootProblems(c Config) []string {
+	var problems []string
+	if c.APIKey == sampleAPIKey && c.AgentTokenURL == "" {
+		problems = append(problems, "API_KEY is the sample agent key; set the agent's own key")
+	}
+	if c.IssuerDID == sampleIssuerDID {
+		problems = append(problems, "ISSUER_DID is the sample DID; set the institution's DID")
+	}
+	for _, s := range []struct{ name, value string }{
+		{"API_KEY", c.APIKey},
+		{"STAFF_API_TOKEN", c.StaffAPIToken},
+		{"METRICS_TOKEN", c.MetricsToken},
+		{"LINK_SIGNING_KEY", c.LinkSigningKey},
+		{"SMTP_PASSWORD", c.SMTPPassword},
+		{"AGENT_CLIENT_SECRET", c.AgentClientSecret},
+	} {
+		if s.value != "" && s.value != sampleAPIKey && isPlaceholderSecret(s.value) {
+			problems = append(problems, s.name+" is a placeholder; set a generated secret")
+		}
+	}
+	if c.DemoMode {
+		problems = append(problems, "DEMO_MODE signs with a fake agent; turn it off")
+	}
+	if c.AgentTLSInsecure {
+		problems = append(problems, "AGENT_TLS_INSECURE skips verifying the agent; give AGENT_CA_FILE instead")
+	}
+	return problems
+}
+
+// loadInstallKey returns the 32-byte key kept in a DATA_DIR file,
+// generating it on first use.
+func loadInstallKey(dataDir, name string) ([]byte, error) {
+	path := filepath.Join(dataDir, name)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading %s: %w", name, err)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0o600); err != nil {
+		return nil, fmt.Errorf("writing %s: %w", name, err)
+	}
+	return key, nil
+}
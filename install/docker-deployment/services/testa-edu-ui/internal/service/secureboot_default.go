@@ -0,0 +1,6 @@
+//go:build !production
+
+package service
+
+// defaultSecureBoot is SECURE_BOOT's default; see secureboot.go.
+const defaultSecureBoot = false
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSecureBoot(t *testing.T) {
+	c := Config{
+		Port:       "3002",
+		PublicURL:  "https://credentials.example.ac.ke",
+		APIKey:     sampleAPIKey,
+		IssuerDID:  sampleIssuerDID,
+		SecureBoot: true,
+		DemoMode:   true,
+
+		StaffAPIToken: "CHANGEME",
+	}
+	problems := strings.Join(validateConfig(c), "\n")
+	for _, want := range []string{"API_KEY is the sample", "ISSUER_DID is the sample", "STAFF_API_TOKEN is a placeholder", "DEMO_MODE"} {
+		if !strings.Contains(problems, want) {
+			t.Errorf("no %q in\n%s", want, problems)
+		}
+	}
+
+	c.APIKey, c.IssuerDID, c.DemoMode, c.StaffAPIToken = "k3Jd9sLq0vXz7TfWm2Ya", "did:web:credentials.example.ac.ke", false, ""
+	if problems := validateConfig(c); len(problems) != 0 {
+		t.Errorf("secure config: %v", problems)
+	}
+	c.SecureBoot, c.APIKey = false, sampleAPIKey
+	if problems := validateConfig(c); len(problems) != 0 {
+		t.Errorf("sample key without secure boot: %v", problems)
+	}
+}
+
+func TestLoadInstallKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := loadInstallKey(dir, "holder.key")
+	if err != nil || len(key) != 32 {
+		t.Fatalf("%x, %v", key, err)
+	}
+	again, err := loadInstallKey(dir, "holder.key")
+	if err != nil || !bytes.Equal(key, again) {
+		t.Errorf("key changed: %x, %x, %v", key, again, err)
+	}
+}
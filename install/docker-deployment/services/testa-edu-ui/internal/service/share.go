@@ -0,0 +1,278 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Share links let a registrar send a credential artifact by email without
+// the link living forever: each link expires after SHARE_LINK_TTL and can
+// optionally be single-use. The artifact is snapshotted when the link is
+// created, since issuance sessions are short-lived.
+
+type shareArtifact struct {
+	Label       string
+	Filename    string
+	ContentType string
+	Build       func(sess *Session, lang string) ([]byte, error)
+}
+
+var shareArtifacts = map[string]shareArtifact{
+	"pdf": {
+		Label:       "Certificate (PDF)",
+		Filename:    "testa-edu-credential.pdf",
+		ContentType: "application/pdf",
+		Build:       generatePDF,
+	},
+	"json": {
+		Label:       "Credential (JSON)",
+		Filename:    "testa-edu-credential.json",
+		ContentType: "application/json",
+		Build: func(sess *Session, _ string) ([]byte, error) {
+			var pretty bytes.Buffer
+			if err := json.Indent(&pretty, sess.SignedCredential, "", "  "); err != nil {
+				return nil, err
+			}
+			return pretty.Bytes(), nil
+		},
+	},
+}
+
+type ShareLink struct {
+	Token     string    `json:"token"`
+	Artifact  string    `json:"artifact"`
+	SubjectID string    `json:"subjectId,omitempty"`
+	SingleUse bool      `json:"singleUse"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ShareStore keeps share link metadata in shares.json and each artifact
+// snapshot in its own file under DATA_DIR/shares.
+type ShareStore struct {
+	dir string
+
+	mu    sync.Mutex
+	links map[string]*ShareLink
+}
+
+var shares *ShareStore
+
+func NewShareStore(dataDir string) (*ShareStore, error) {
+	s := &ShareStore{
+		dir:   filepath.Join(dataDir, "shares"),
+		links: make(map[string]*ShareLink),
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating shares dir: %w", err)
+	}
+	data, err := os.ReadFile(s.indexPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &s.links); err != nil {
+			return nil, fmt.Errorf("parsing share index: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading share index: %w", err)
+	}
+
+	go func() {
+		for {
+			s.sweep()
+			time.Sleep(15 * time.Minute)
+		}
+	}()
+	return s, nil
+}
+
+func (s *ShareStore) indexPath() string { return filepath.Join(s.dir, "shares.json") }
+
+func (s *ShareStore) contentPath(token string) string { return filepath.Join(s.dir, token+".bin") }
+
+func (s *ShareStore) saveLocked() error {
+	data, err := json.Marshal(s.links)
+	if err != nil {
+		return err
+	}
+	tmp := s.indexPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing share index: %w", err)
+	}
+	return os.Rename(tmp, s.indexPath())
+}
+
+func (s *ShareStore) deleteLocked(token string) {
+	delete(s.links, token)
+	os.Remove(s.contentPath(token))
+}
+
+// Create snapshots content and returns a new link for it.
+func (s *ShareStore) Create(artifact, subjectID string, content []byte, ttl time.Duration, singleUse bool) (*ShareLink, error) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	now := time.Now().UTC()
+	link := &ShareLink{
+		Token:     base64.RawURLEncoding.EncodeToString(b),
+		Artifact:  artifact,
+		SubjectID: subjectID,
+		SingleUse: singleUse,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.WriteFile(s.contentPath(link.Token), content, 0o600); err != nil {
+		return nil, fmt.Errorf("writing share content: %w", err)
+	}
+	s.links[link.Token] = link
+	if err := s.saveLocked(); err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+// Open returns a link's content, consuming single-use links.
+func (s *ShareStore) Open(token string) (*ShareLink, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[token]
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	if time.Now().After(link.ExpiresAt) {
+		s.deleteLocked(token)
+		s.saveLocked()
+		return nil, nil, os.ErrNotExist
+	}
+	content, err := os.ReadFile(s.contentPath(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if link.SingleUse {
+		s.deleteLocked(token)
+		if err := s.saveLocked(); err != nil {
+			return nil, nil, err
+		}
+	}
+	return link, content, nil
+}
+
+// EraseSubject deletes every share link and snapshot for subjectID and
+// returns the removed tokens.
+func (s *ShareStore) EraseSubject(subjectID string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []string
+	for token, link := range s.links {
+		if link.SubjectID == subjectID {
+			s.deleteLocked(token)
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+	return tokens, s.saveLocked()
+}
+
+// Expire deletes share links created before cutoff, whatever their TTL.
+func (s *ShareStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for token, link := range s.links {
+		if !link.CreatedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			s.deleteLocked(token)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+func (s *ShareStore) sweep() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	removed := 0
+	for token, link := range s.links {
+		if now.After(link.ExpiresAt) {
+			s.deleteLocked(token)
+			removed++
+		}
+	}
+	if removed > 0 {
+		if err := s.saveLocked(); err != nil {
+			slog.Error("share sweep", "err", err)
+		}
+	}
+}
+
+func handleShareCreate(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderFragment(w, r, "share-link", map[string]interface{}{"Error": "Session expired. Please start over."})
+		return
+	}
+	if !sess.Consent.Allows(ConsentScopeShare) {
+		renderFragment(w, r, "share-link", map[string]interface{}{"Error": "The student has not consented to sharing by link."})
+		return
+	}
+	name := r.FormValue("artifact")
+	artifact, ok := shareArtifacts[name]
+	if !ok {
+		renderFragment(w, r, "share-link", map[string]interface{}{"Error": "Unknown artifact"})
+		return
+	}
+
+	content, err := artifact.Build(sess, requestLanguage(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "share", "share", name, "err", err)
+		renderFragment(w, r, "share-link", map[string]interface{}{"Error": "Failed to build " + artifact.Label})
+		return
+	}
+	link, err := shares.Create(name, studentDID(sess.Form), content, liveConfig().ShareLinkTTL, r.FormValue("singleUse") != "")
+	if err != nil {
+		slog.ErrorContext(r.Context(), "share error", "err", err)
+		renderFragment(w, r, "share-link", map[string]interface{}{"Error": "Failed to create share link"})
+		return
+	}
+
+	renderFragment(w, r, "share-link", map[string]interface{}{
+		"URL":       shortenOr(config.PublicURL+"/share/"+link.Token, liveConfig().ShareLinkTTL),
+		"Label":     artifact.Label,
+		"SingleUse": link.SingleUse,
+		"ExpiresAt": link.ExpiresAt,
+	})
+}
+
+func handleShareOpen(w http.ResponseWriter, r *http.Request) {
+	link, content, err := shares.Open(r.PathValue("token"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.ErrorContext(r.Context(), "share open error", "err", err)
+		}
+		renderErrorPage(w, r, http.StatusGone, "This link has expired or has already been used.")
+		return
+	}
+	artifact := shareArtifacts[link.Artifact]
+
+	w.Header().Set("Content-Type", artifact.ContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", artifact.Filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(content)
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Built-in URL shortener for claim and share URLs, so QR codes and emails
+// stay short without handing URLs to a third-party shortener. Only URLs on
+// this portal (PUBLIC_URL) can be shortened, so /s/ is not an open redirect.
+
+const (
+	shortCodeAlphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shortCodeLength   = 8
+)
+
+type ShortLink struct {
+	Code      string    `json:"code"`
+	Target    string    `json:"target"`
+	Hits      int       `json:"hits"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"` // zero: never
+}
+
+func (l *ShortLink) expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && now.After(l.ExpiresAt)
+}
+
+type ShortLinkStore struct {
+	path string
+
+	mu    sync.Mutex
+	links map[string]*ShortLink
+}
+
+var shortLinks *ShortLinkStore
+
+func NewShortLinkStore(dataDir string) (*ShortLinkStore, error) {
+	s := &ShortLinkStore{
+		path:  filepath.Join(dataDir, "shortlinks.json"),
+		links: make(map[string]*ShortLink),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.links); err != nil {
+			return nil, fmt.Errorf("parsing short links: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading short links: %w", err)
+	}
+	return s, nil
+}
+
+func (s *ShortLinkStore) saveLocked() error {
+	data, err := json.Marshal(s.links)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing short links: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func randomShortCode() string {
+	b := make([]byte, shortCodeLength)
+	max := big.NewInt(int64(len(shortCodeAlphabet)))
+	for i := range b {
+		n, _ := rand.Int(rand.Reader, max)
+		b[i] = shortCodeAlphabet[n.Int64()]
+	}
+	return string(b)
+}
+
+// Shorten returns a short URL for target, valid for ttl (0 for no expiry),
+// on the same site as target. Codes are drawn at random and redrawn on
+// collision; expired codes are reclaimed.
+func (s *ShortLinkStore) Shorten(target string, ttl time.Duration) (string, error) {
+	base, _, ok := ownLink(target)
+	if !ok {
+		return "", fmt.Errorf("refusing to shorten external URL %s", target)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now().UTC()
+	var code string
+	for attempt := 0; ; attempt++ {
+		if attempt == 10 {
+			return "", fmt.Errorf("could not allocate a free short code")
+		}
+		code = randomShortCode()
+		if existing, taken := s.links[code]; !taken || existing.expired(now) {
+			break
+		}
+	}
+
+	link := &ShortLink{Code: code, Target: target, CreatedAt: now}
+	if ttl > 0 {
+		link.ExpiresAt = now.Add(ttl)
+	}
+	s.links[code] = link
+	if err := s.saveLocked(); err != nil {
+		return "", err
+	}
+	return base + "/s/" + code, nil
+}
+
+// Resolve returns the target for code and counts the hit.
+func (s *ShortLinkStore) Resolve(code string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	link, ok := s.links[code]
+	if !ok || link.expired(time.Now()) {
+		return "", false
+	}
+	link.Hits++
+	if err := s.saveLocked(); err != nil {
+		slog.Error("short link hit count", "err", err)
+	}
+	return link.Target, true
+}
+
+// RemoveTargets deletes the short links whose target matches and returns
+// how many were removed.
+func (s *ShortLinkStore) RemoveTargets(match func(target string) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := 0
+	for code, link := range s.links {
+		if match(link.Target) {
+			delete(s.links, code)
+			removed++
+		}
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, s.saveLocked()
+}
+
+// Expire deletes short links created before cutoff.
+func (s *ShortLinkStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for code, link := range s.links {
+		if !link.CreatedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			delete(s.links, code)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+// shortenOr returns a short URL for target, falling back to target itself
+// if shortening fails.
+func shortenOr(target string, ttl time.Duration) string {
+	short, err := shortLinks.Shorten(target, ttl)
+	if err != nil {
+		slog.Error("short link error", "err", err)
+		return target
+	}
+	return short
+}
+
+func handleShortLink(w http.ResponseWriter, r *http.Request) {
+	target, ok := shortLinks.Resolve(r.PathValue("code"))
+	if !ok {
+		renderErrorPage(w, r, http.StatusNotFound, "This link has expired or does not exist.")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, target, http.StatusFound)
+}
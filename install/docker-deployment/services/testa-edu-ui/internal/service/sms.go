@@ -0,0 +1,232 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SMS delivery of claim links, for students who gave a phone number.
+// SMS_PROVIDER selects the gateway (twilio or africastalking); each
+// provider reads its own credentials. Sends share the delivery queue and
+// status tracking with email.
+
+// SMSProvider sends one text message. Errors worth retrying are wrapped
+// in transientSMSError.
+type SMSProvider interface {
+	Name() string
+	Send(to, body string) error
+}
+
+type transientSMSError struct{ err error }
+
+func (e *transientSMSError) Error() string { return e.err.Error() }
+func (e *transientSMSError) Unwrap() error { return e.err }
+
+func transientSMS(err error) bool {
+	var t *transientSMSError
+	return errors.As(err, &t)
+}
+
+// e164 matches international phone numbers: "+" and up to 15 digits.
+var e164 = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+
+// normalizePhone strips spaces, dashes and brackets from a typed number.
+func normalizePhone(s string) string {
+	return strings.NewReplacer(" ", "", "-", "", "(", "", ")", "").Replace(strings.TrimSpace(s))
+}
+
+var smsHTTP = &http.Client{Timeout: 30 * time.Second}
+
+var smsProvider SMSProvider
+
+func smsEnabled() bool {
+	return smsProvider != nil
+}
+
+// newSMSProvider builds the configured provider, or nil if SMS is off.
+func newSMSProvider() (SMSProvider, error) {
+	// SMS_API_URL overrides the provider's API host, e.g. for a proxy.
+	switch config.SMSProvider {
+	case "":
+		return nil, nil
+	case "twilio":
+		if config.SMSAPIURL == "" {
+			config.SMSAPIURL = "https://api.twilio.com"
+		}
+		p := &twilioSMS{
+			baseURL:    config.SMSAPIURL,
+			accountSID: config.TwilioAccountSID,
+			authToken:  config.TwilioAuthToken,
+			from:       config.TwilioFrom,
+		}
+		if p.accountSID == "" || p.authToken == "" || p.from == "" {
+			return nil, fmt.Errorf("twilio needs TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM")
+		}
+		return p, nil
+	case "africastalking":
+		if config.SMSAPIURL == "" {
+			config.SMSAPIURL = "https://api.africastalking.com"
+			if config.ATUsername == "sandbox" {
+				config.SMSAPIURL = "https://api.sandbox.africastalking.com"
+			}
+		}
+		p := &africasTalkingSMS{
+			baseURL:  config.SMSAPIURL,
+			username: config.ATUsername,
+			apiKey:   config.ATAPIKey,
+			senderID: config.ATSenderID,
+		}
+		if p.username == "" || p.apiKey == "" {
+			return nil, fmt.Errorf("africastalking needs AT_USERNAME and AT_API_KEY")
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown SMS_PROVIDER %q", config.SMSProvider)
+}
+
+// postSMSForm posts a provider request, classing network errors, 429 and
+// 5xx responses as transient.
+func postSMSForm(req *http.Request) ([]byte, error) {
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := smsHTTP.Do(req)
+	if err != nil {
+		return nil, &transientSMSError{err}
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return nil, &transientSMSError{fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)}
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+type twilioSMS struct {
+	baseURL, accountSID, authToken, from string
+}
+
+func (t *twilioSMS) Name() string { return "twilio" }
+
+func (t *twilioSMS) Send(to, body string) error {
+	form := url.Values{"To": {to}, "Body": {body}}
+	// A messaging service SID sends from the service's number pool.
+	if strings.HasPrefix(t.from, "MG") {
+		form.Set("MessagingServiceSid", t.from)
+	} else {
+		form.Set("From", t.from)
+	}
+	req, err := http.NewRequest("POST", t.baseURL+"/2010-04-01/Accounts/"+url.PathEscape(t.accountSID)+"/Messages.json",
+		strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(t.accountSID, t.authToken)
+	_, err = postSMSForm(req)
+	return err
+}
+
+type africasTalkingSMS struct {
+	baseURL, username, apiKey, senderID string
+}
+
+func (a *africasTalkingSMS) Name() string { return "africastalking" }
+
+func (a *africasTalkingSMS) Send(to, body string) error {
+	form := url.Values{"username": {a.username}, "to": {to}, "message": {body}}
+	if a.senderID != "" {
+		form.Set("from", a.senderID)
+	}
+	req, err := http.NewRequest("POST", a.baseURL+"/version1/messaging", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("apiKey", a.apiKey)
+	respBody, err := postSMSForm(req)
+	if err != nil {
+		return err
+	}
+
+	// The API answers 201 even when the recipient was rejected; the
+	// per-recipient statusCode says what happened (100-102 accepted,
+	// 500+ gateway errors).
+	var result struct {
+		SMSMessageData struct {
+			Recipients []struct {
+				StatusCode int    `json:"statusCode"`
+				Status     string `json:"status"`
+			} `json:"Recipients"`
+		} `json:"SMSMessageData"`
+	}
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("parsing response: %w", err)
+	}
+	if len(result.SMSMessageData.Recipients) == 0 {
+		return fmt.Errorf("message not accepted: %s", respBody)
+	}
+	r := result.SMSMessageData.Recipients[0]
+	switch {
+	case r.StatusCode >= 100 && r.StatusCode <= 102:
+		return nil
+	case r.StatusCode >= 500:
+		return &transientSMSError{fmt.Errorf("%s (%d)", r.Status, r.StatusCode)}
+	}
+	return fmt.Errorf("%s (%d)", r.Status, r.StatusCode)
+}
+
+// queueClaimSMS texts the student a claim link for the session's
+// credential.
+func queueClaimSMS(sess *Session, phone string) (*Delivery, error) {
+	phone = normalizePhone(phone)
+	if !e164.MatchString(phone) {
+		return nil, fmt.Errorf("phone numbers must be in international format, e.g. +254712345678")
+	}
+	if !sess.Consent.Allows(ConsentScopeStore) {
+		return nil, fmt.Errorf("a claim link needs the student's consent to storing the credential")
+	}
+	link, expires, err := sessionClaimLink(sess)
+	if err != nil {
+		return nil, fmt.Errorf("creating claim link: %w", err)
+	}
+	body := fmt.Sprintf("%s: your %s credential is ready. Claim it once at %s before %s.",
+		sess.Form.Institution, sess.Form.Degree, link, expires.Format("2 Jan 2006"))
+
+	provider := smsProvider
+	return queueSessionDelivery(sess, &deliveryJob{
+		channel:     "sms",
+		recipient:   phone,
+		maxAttempts: config.SMSMaxAttempts,
+		send:        func() error { return provider.Send(phone, body) },
+		transient:   transientSMS,
+	})
+}
+
+// handleDeliverSMS texts a claim link to the given number.
+func handleDeliverSMS(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil || sess.SignedCredential == nil {
+		renderFragment(w, r, "delivery-status", map[string]interface{}{"Error": "Session expired. Please start over."})
+		return
+	}
+	if !smsEnabled() {
+		renderFragment(w, r, "delivery-status", map[string]interface{}{"Error": "SMS delivery is not configured"})
+		return
+	}
+	d, err := queueClaimSMS(sess, r.FormValue("phone"))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "SMS delivery error", "err", err)
+		renderFragment(w, r, "delivery-status", map[string]interface{}{"Error": err.Error()})
+		return
+	}
+	renderFragment(w, r, "delivery-status", map[string]interface{}{"Delivery": d})
+}
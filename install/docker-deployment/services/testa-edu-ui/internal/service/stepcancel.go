@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"net/http"
+)
+
+// Step cancellation. Signing and verifying wait on the agent, which may
+// hang; the wizard shows a Cancel button while they run. /step/cancel
+// cancels the context of the session's running step, which aborts its
+// agent request, and the step then fails with a Retry button as for any
+// other error.
+
+// stepCall is a running wizard step that can be cancelled.
+type stepCall struct {
+	cancel context.CancelFunc
+}
+
+// stepContext returns the context for a step's agent calls, cancelled by
+// /step/cancel, and the function to call when the step is done.
+func stepContext(r *http.Request, sess *Session) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(r.Context())
+	call := &stepCall{cancel: cancel}
+	sessionsMu.Lock()
+	sess.step = call
+	sessionsMu.Unlock()
+	return ctx, func() {
+		sessionsMu.Lock()
+		if sess.step == call {
+			sess.step = nil
+		}
+		sessionsMu.Unlock()
+		cancel()
+	}
+}
+
+func handleStepCancel(w http.ResponseWriter, r *http.Request) {
+	sess := getSession(r)
+	if sess == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	sessionsMu.Lock()
+	if sess.step != nil {
+		sess.step.cancel()
+	}
+	sessionsMu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
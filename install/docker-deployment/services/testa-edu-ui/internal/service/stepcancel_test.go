@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStepCancel(t *testing.T) {
+	sess := &Session{}
+	sessionsMu.Lock()
+	sessions["cancel-test"] = sess
+	sessionsMu.Unlock()
+	t.Cleanup(func() {
+		sessionsMu.Lock()
+		delete(sessions, "cancel-test")
+		sessionsMu.Unlock()
+	})
+	cancelReq := func() {
+		req := httptest.NewRequest("POST", "/step/cancel", nil)
+		req.AddCookie(&http.Cookie{Name: "sid", Value: "cancel-test"})
+		rec := httptest.NewRecorder()
+		handleStepCancel(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("cancel: got %d", rec.Code)
+		}
+	}
+
+	ctx, done := stepContext(httptest.NewRequest("POST", "/step/sign", nil), sess)
+	cancelReq()
+	<-ctx.Done()
+	if msg := agentFailure(ctx, "sign error", fmt.Errorf("agent unreachable: %w", ctx.Err())); msg != "Cancelled" {
+		t.Errorf("message %q", msg)
+	}
+	done()
+
+	// Finishing a superseded step leaves the running one cancellable.
+	_, done = stepContext(httptest.NewRequest("POST", "/step/verify", nil), sess)
+	defer done()
+	next, nextDone := stepContext(httptest.NewRequest("POST", "/step/verify", nil), sess)
+	defer nextDone()
+	done()
+	if sess.step == nil {
+		t.Fatal("finishing an earlier step dropped the running one")
+	}
+	cancelReq()
+	if next.Err() != context.Canceled {
+		t.Error("running step not cancelled")
+	}
+}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// StoredCredential is an issued credential kept server-side so it can be
+// fetched later by URL rather than carried whole in a QR code.
+type StoredCredential struct {
+	ID         string          `json:"id"`
+	Format     string          `json:"format"`
+	ProofType  string          `json:"proofType,omitempty"`
+	Credential json.RawMessage `json:"credential"`
+	Encrypted  bool            `json:"encrypted,omitempty"` // Credential is a JWE for the holder
+	IssuedAt   time.Time       `json:"issuedAt"`
+	Timestamp  []byte          `json:"timestamp,omitempty"` // RFC 3161 timestamp token (see timestamp.go)
+
+	// Retrieval authorization: the SHA-256 of the holder's claim token, and
+	// the subject DID accepted for DID-auth.
+	TokenHash string `json:"tokenHash,omitempty"`
+	SubjectID string `json:"subjectId,omitempty"`
+
+	ConsentID string `json:"consentId,omitempty"`
+
+	// TenantID is the institution the credential was issued for (see
+	// tenants.go); empty for the deployment's own.
+	TenantID string `json:"tenantId,omitempty"`
+
+	// EmailHashes are the lookup hashes of the addresses the credential was
+	// emailed to, for magic-link sign-in.
+	EmailHashes []string `json:"emailHashes,omitempty"`
+
+	// Status is empty while the credential is active, otherwise revoked or
+	// suspended (see credstatus.go).
+	Status          string    `json:"status,omitempty"`
+	StatusReason    string    `json:"statusReason,omitempty"`
+	StatusChangedAt time.Time `json:"statusChangedAt,omitempty"`
+
+	// ErasedAt is set when the record has been anonymized on a data
+	// subject's request; only non-identifying fields remain.
+	ErasedAt time.Time `json:"erasedAt,omitempty"`
+
+	// ArchivedAt is set while the record is archived (see archive.go): its
+	// credential and timestamp are in cold storage, and Fingerprint keeps
+	// it findable by signature meanwhile.
+	ArchivedAt  time.Time `json:"archivedAt,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+}
+
+// CredentialStore is an in-memory credential index persisted as a single
+// JSON file under DATA_DIR. Every write rewrites the file, which is fine
+// for the volumes a single issuance portal handles.
+type CredentialStore struct {
+	path       string
+	archiveDir string
+
+	mu    sync.RWMutex
+	items map[string]*StoredCredential
+}
+
+var store *CredentialStore
+
+func NewCredentialStore(dir string) (*CredentialStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("creating data dir: %w", err)
+	}
+	s := &CredentialStore{
+		path:       filepath.Join(dir, "credentials.json"),
+		archiveDir: filepath.Join(dir, "archive"),
+		items:      make(map[string]*StoredCredential),
+	}
+
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading credential store: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.items); err != nil {
+		return nil, fmt.Errorf("parsing credential store: %w", err)
+	}
+	return s, nil
+}
+
+// newCredentialID returns a short random URL-safe identifier.
+func newCredentialID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Get returns a credential, with its artifacts read back from cold
+// storage if it is archived.
+func (s *CredentialStore) Get(id string) (*StoredCredential, bool) {
+	s.mu.RLock()
+	c, ok := s.items[id]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return s.withArtifacts(c), true
+}
+
+// Put adds or replaces a credential, assigning an ID if it has none.
+func (s *CredentialStore) Put(c *StoredCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ID == "" {
+		c.ID = newCredentialID()
+	}
+	s.items[c.ID] = c
+	return s.saveLocked()
+}
+
+func (s *CredentialStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing credential store: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// SetTokenHash replaces a credential's claim token hash, invalidating the
+// old token.
+func (s *CredentialStore) SetTokenHash(id, tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[id]
+	if !ok {
+		return fmt.Errorf("credential %s not found", id)
+	}
+	c.TokenHash = tokenHash
+	return s.saveLocked()
+}
+
+// SetStatus records a status change. Revocation is final.
+func (s *CredentialStore) SetStatus(id, status, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if c.Status == CredentialRevoked {
+		return errCredentialRevoked
+	}
+	if status == CredentialActive {
+		status = ""
+	}
+	c.Status, c.StatusReason, c.StatusChangedAt = status, reason, time.Now().UTC()
+	return s.saveLocked()
+}
+
+// ForSubject returns the credentials held for subjectID, newest first.
+// Erased records are left out; archived ones are the holder's still.
+func (s *CredentialStore) ForSubject(subjectID string) []*StoredCredential {
+	return s.withAllArtifacts(s.filter(func(c *StoredCredential) bool { return c.SubjectID == subjectID }))
+}
+
+// ForEmail returns the credentials emailed to the address with emailHash,
+// newest first.
+func (s *CredentialStore) ForEmail(emailHash string) []*StoredCredential {
+	return s.withAllArtifacts(s.filter(func(c *StoredCredential) bool { return slices.Contains(c.EmailHashes, emailHash) }))
+}
+
+func (s *CredentialStore) filter(match func(*StoredCredential) bool) []*StoredCredential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var out []*StoredCredential
+	for _, c := range s.items {
+		if c.ErasedAt.IsZero() && match(c) {
+			out = append(out, c)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IssuedAt.After(out[j].IssuedAt) })
+	return out
+}
+
+// ForTenant returns a tenant's credentials, or every credential for "",
+// newest first. Archived records are left out.
+func (s *CredentialStore) ForTenant(tenantID string) []*StoredCredential {
+	return s.filter(func(c *StoredCredential) bool {
+		return c.ArchivedAt.IsZero() && (tenantID == "" || c.TenantID == tenantID)
+	})
+}
+
+// Archived returns a tenant's archived credentials, or every archived
+// credential for "", newest first, without their artifacts.
+func (s *CredentialStore) Archived(tenantID string) []*StoredCredential {
+	return s.filter(func(c *StoredCredential) bool {
+		return !c.ArchivedAt.IsZero() && (tenantID == "" || c.TenantID == tenantID)
+	})
+}
+
+// ByFingerprint returns the newest credential with the given signature
+// fingerprint (see credentialFingerprint).
+func (s *CredentialStore) ByFingerprint(fp string) (*StoredCredential, bool) {
+	found := s.filter(func(c *StoredCredential) bool {
+		if !c.ArchivedAt.IsZero() {
+			return c.Fingerprint == fp
+		}
+		return credentialFingerprint(c.Credential) == fp
+	})
+	if len(found) == 0 {
+		return nil, false
+	}
+	return s.withArtifacts(found[0]), true
+}
+
+// AddEmail records that a credential was emailed to the address with
+// emailHash.
+func (s *CredentialStore) AddEmail(id, emailHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.items[id]
+	if !ok || slices.Contains(c.EmailHashes, emailHash) {
+		return nil
+	}
+	c.EmailHashes = append(c.EmailHashes, emailHash)
+	return s.saveLocked()
+}
+
+// LatestForSubject returns the most recently issued credential held for
+// subjectID.
+func (s *CredentialStore) LatestForSubject(subjectID string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var latest *StoredCredential
+	for _, c := range s.items {
+		if c.SubjectID == subjectID && (latest == nil || c.IssuedAt.After(latest.IssuedAt)) {
+			latest = c
+		}
+	}
+	if latest == nil {
+		return "", false
+	}
+	return latest.ID, true
+}
+
+// EraseSubject removes the credentials issued to subjectID, or with
+// anonymize strips them down to format, proof type and issue date, and
+// deletes their archived artifacts. It returns the affected credential
+// IDs.
+func (s *CredentialStore) EraseSubject(subjectID string, anonymize bool) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids, archived []string
+	for id, c := range s.items {
+		if c.SubjectID != subjectID {
+			continue
+		}
+		ids = append(ids, id)
+		if !c.ArchivedAt.IsZero() {
+			archived = append(archived, id)
+		}
+		if anonymize {
+			s.items[id] = anonymizedCredential(c)
+		} else {
+			delete(s.items, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := s.saveLocked(); err != nil {
+		return ids, err
+	}
+	return ids, s.removeArtifacts(archived)
+}
+
+// Expire anonymizes credentials issued before cutoff, as EraseSubject
+// does, and returns how many were (or with dryRun would be) affected.
+func (s *CredentialStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	var archived []string
+	for id, c := range s.items {
+		if !c.ErasedAt.IsZero() || !c.IssuedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			if !c.ArchivedAt.IsZero() {
+				archived = append(archived, id)
+			}
+			s.items[id] = anonymizedCredential(c)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	if err := s.saveLocked(); err != nil {
+		return n, err
+	}
+	return n, s.removeArtifacts(archived)
+}
+
+// anonymizedCredential keeps only the non-identifying fields of c.
+func anonymizedCredential(c *StoredCredential) *StoredCredential {
+	return &StoredCredential{
+		ID:        c.ID,
+		Format:    c.Format,
+		ProofType: c.ProofType,
+		IssuedAt:  c.IssuedAt,
+		ErasedAt:  time.Now().UTC(),
+
+		Status:          c.Status,
+		StatusChangedAt: c.StatusChangedAt,
+	}
+}
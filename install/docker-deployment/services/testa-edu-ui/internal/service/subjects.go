@@ -0,0 +1,200 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Students who do not bring their own DID get a did:key minted for them,
+// so subject identifiers are resolvable and never collide for students who
+// share a name. A student with an institution-issued ID keeps one DID
+// across credentials; without an ID every issuance mints a fresh one.
+//
+// The Ed25519 seed is kept, so the key can later be handed to the student's
+// wallet, only when HOLDER_KEY is set: seeds are sealed with it, and it
+// never lives in DATA_DIR. Without it minted DIDs are identifiers only.
+// Seeds written in the clear by earlier versions are sealed on start, or
+// dropped when there is no key to seal them with.
+
+// holderKey, from HOLDER_KEY, seals holder secrets at rest (see sealKey).
+var holderKey []byte
+
+type Subject struct {
+	DID        string    `json:"did"`
+	Seed       []byte    `json:"seed,omitempty"` // legacy, unsealed
+	SealedSeed []byte    `json:"sealedSeed,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type SubjectStore struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*Subject // by subjectKey, or by DID for students without an ID
+}
+
+var subjects *SubjectStore
+
+func NewSubjectStore(dataDir string) (*SubjectStore, error) {
+	s := &SubjectStore{
+		path:  filepath.Join(dataDir, "subjects.json"),
+		items: make(map[string]*Subject),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing subjects: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading subjects: %w", err)
+	}
+
+	migrated := 0
+	for _, subject := range s.items {
+		if subject.Seed == nil {
+			continue
+		}
+		if subject.SealedSeed, err = sealSubjectSeed(subject.DID, subject.Seed); err != nil {
+			return nil, err
+		}
+		subject.Seed = nil
+		migrated++
+	}
+	if migrated > 0 {
+		if holderKey == nil {
+			slog.Warn("subjects: dropped unsealed holder keys; set HOLDER_KEY to keep minted keys", "count", migrated)
+		}
+		if err := s.saveLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// sealSubjectSeed encrypts a minted key's seed with the holder key, bound
+// to its DID. It returns nil when no holder key is configured.
+func sealSubjectSeed(did string, seed []byte) ([]byte, error) {
+	if holderKey == nil {
+		return nil, nil
+	}
+	key, err := sealKey("subject-seed", did)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := walletSeal(key, seed, did)
+	if err != nil {
+		return nil, fmt.Errorf("sealing subject key: %w", err)
+	}
+	return sealed, nil
+}
+
+func (s *SubjectStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing subjects: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// subjectKey identifies a student by institution and student ID, hashed
+// so the index holds no identifiers in the clear.
+func subjectKey(form CredentialForm) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(form.Institution)) + "\x00" + strings.TrimSpace(form.StudentID)))
+	return hex.EncodeToString(sum[:])
+}
+
+// DIDFor returns the student's DID, minting and recording one if needed.
+func (s *SubjectStore) DIDFor(form CredentialForm) (string, error) {
+	key := ""
+	if strings.TrimSpace(form.StudentID) != "" {
+		key = subjectKey(form)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if subject, ok := s.items[key]; ok && key != "" {
+		return subject.DID, nil
+	}
+
+	seed := make([]byte, ed25519.SeedSize)
+	rand.Read(seed)
+	pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	did := didKeyEd25519(pub)
+	sealed, err := sealSubjectSeed(did, seed)
+	if err != nil {
+		return "", err
+	}
+	subject := &Subject{
+		DID:        did,
+		SealedSeed: sealed,
+		CreatedAt:  time.Now().UTC(),
+	}
+	if key == "" {
+		key = subject.DID
+	}
+	s.items[key] = subject
+	if err := s.saveLocked(); err != nil {
+		return "", err
+	}
+	return subject.DID, nil
+}
+
+// Lookup returns the DID recorded for a student with an institution ID.
+func (s *SubjectStore) Lookup(form CredentialForm) (string, bool) {
+	if strings.TrimSpace(form.StudentID) == "" {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subject, ok := s.items[subjectKey(form)]
+	if !ok {
+		return "", false
+	}
+	return subject.DID, true
+}
+
+// Key returns the private key minted for did, if the portal holds it.
+func (s *SubjectStore) Key(did string) (ed25519.PrivateKey, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, subject := range s.items {
+		if subject.DID != did || subject.SealedSeed == nil || holderKey == nil {
+			continue
+		}
+		key, _ := sealKey("subject-seed", did)
+		seed, err := walletOpen(key, subject.SealedSeed, did)
+		if err != nil {
+			slog.Error("subject key", "did", did, "err", err)
+			return nil, false
+		}
+		return ed25519.NewKeyFromSeed(seed), true
+	}
+	return nil, false
+}
+
+// Erase forgets the minted key for did, reporting whether there was one.
+func (s *SubjectStore) Erase(did string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, subject := range s.items {
+		if subject.DID == did {
+			delete(s.items, key)
+			return true, s.saveLocked()
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestSubjectSeedsSealed checks that minted keys are kept only when a
+// holder key seals them, and that legacy plaintext seeds do not survive a
+// restart in the clear.
+func TestSubjectSeedsSealed(t *testing.T) {
+	legacySeed := bytes.Repeat([]byte{7}, ed25519.SeedSize)
+	legacyDID := didKeyEd25519(ed25519.NewKeyFromSeed(legacySeed).Public().(ed25519.PublicKey))
+
+	tests := []struct {
+		name    string
+		key     []byte
+		keepKey bool
+	}{
+		{"with holder key", bytes.Repeat([]byte{1}, 32), true},
+		{"without holder key", nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := holderKey
+			holderKey = tt.key
+			t.Cleanup(func() { holderKey = saved })
+
+			dir := t.TempDir()
+			legacy, _ := json.Marshal(map[string]*Subject{
+				legacyDID: {DID: legacyDID, Seed: legacySeed, CreatedAt: time.Now()},
+			})
+			if err := os.WriteFile(filepath.Join(dir, "subjects.json"), legacy, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err := NewSubjectStore(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			did, err := store.DIDFor(CredentialForm{StudentName: "Amina", Institution: "Testa", StudentID: "S1"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, "subjects.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(data, []byte(`"seed"`)) {
+				t.Errorf("subjects.json holds an unsealed seed: %s", data)
+			}
+
+			for _, d := range []string{legacyDID, did} {
+				key, ok := store.Key(d)
+				if ok != tt.keepKey {
+					t.Errorf("Key(%s) found = %t, want %t", d, ok, tt.keepKey)
+				}
+				if ok && didKeyEd25519(key.Public().(ed25519.PublicKey)) != d {
+					t.Errorf("Key(%s) returned another DID's key", d)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mapping scripts. The script=<dir> sign hook (see hooks.go) lets an
+// institution's mapping logic live in configuration: before signing, the
+// credential subject is replaced with what the script for the credential
+// type returns. The script for a qualification is <dir>/<slug>.js, the
+// slug being its name in lower case with dashes ("Bachelor of Science" is
+// bachelor-of-science.js), else <dir>/default.js; with neither, the
+// subject is left as built.
+//
+// A script is JavaScript defining map(input, subject). input holds the
+// issuance's fields by their form and API names (studentName, degree, gpa
+// and so on, with PII_MODE applied); subject is the subject built from
+// them. For example:
+//
+//	function map(input, subject) {
+//	  subject.degree = input.degree + " (" + input.fieldOfStudy + ")";
+//	  return subject;
+//	}
+//
+// Scripts run sandboxed (scripts/map-subject.js): in a context of their
+// own with no modules, I/O or timers, for at most subjectScriptTimeout, in
+// a Node process with no environment and no file access. The subject's id stays
+// the student's DID whatever the script returns, and the result must
+// still pass SCHEMA_FILE.
+
+// subjectScriptTimeout is how long a script may run, which the runner
+// enforces in V8; the Node process gets subjectScriptStartup more to start
+// and exit before it is killed.
+const (
+	subjectScriptTimeout = time.Second
+	subjectScriptStartup = 4 * time.Second
+)
+
+// scriptHook maps the subject with the scripts in dir.
+type scriptHook struct {
+	dir string
+}
+
+func newScriptHook(dir string) (SignHook, error) {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("%q is not a directory", dir)
+	}
+	return scriptHook{dir}, nil
+}
+
+// script returns the source of the script for a qualification, or nil.
+func (h scriptHook) script(degree string) ([]byte, error) {
+	for _, name := range []string{tenantSlug(degree), "default"} {
+		if name == "" {
+			continue
+		}
+		source, err := os.ReadFile(filepath.Join(h.dir, name+".js"))
+		if err == nil || !errors.Is(err, os.ErrNotExist) {
+			return source, err
+		}
+	}
+	return nil, nil
+}
+
+func (h scriptHook) BeforeSign(ctx context.Context, sess *Session, payload map[string]interface{}) error {
+	form, err := credentialForm(sess)
+	if err != nil {
+		return err
+	}
+	source, err := h.script(form.Degree)
+	if err != nil || source == nil {
+		return err
+	}
+	credential, _ := payload["credential"].(map[string]interface{})
+	subject, ok := credential["credentialSubject"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("mapping script: the credential has no subject")
+	}
+	mapped, err := runSubjectScript(ctx, source, formFields(form), subject)
+	if err != nil {
+		return fmt.Errorf("mapping script for %s: %w", form.Degree, err)
+	}
+	mapped["id"] = subject["id"]
+	credential["credentialSubject"] = mapped
+	return nil
+}
+
+func (scriptHook) AfterSign(context.Context, *Session, json.RawMessage) {}
+
+// runSubjectScript runs a mapping script in the sandbox.
+func runSubjectScript(ctx context.Context, source []byte, input map[string]string, subject map[string]interface{}) (map[string]interface{}, error) {
+	in, err := json.Marshal(map[string]interface{}{
+		"source":    string(source),
+		"input":     input,
+		"subject":   subject,
+		"timeoutMs": subjectScriptTimeout.Milliseconds(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, subjectScriptTimeout+subjectScriptStartup)
+	defer cancel()
+	runner, err := filepath.Abs(filepath.Join(config.ScriptsDir, "map-subject.js"))
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, config.NodeBin, "--experimental-permission", "--allow-fs-read="+runner, "--no-warnings", runner)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Env = []string{}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errors.New(msg)
+		}
+		return nil, err
+	}
+	var mapped map[string]interface{}
+	if err := json.Unmarshal(stdout.Bytes(), &mapped); err != nil || mapped == nil {
+		return nil, fmt.Errorf("map must return the subject, an object")
+	}
+	return mapped, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScriptHook checks the script for the qualification maps the subject,
+// the default script covers the rest, and scripts are sandboxed.
+func TestScriptHook(t *testing.T) {
+	if _, err := exec.LookPath("node"); err != nil {
+		t.Skip("Node is not installed")
+	}
+	dir := t.TempDir()
+	scripts := map[string]string{
+		"bachelor-of-science.js": `function map(input, subject) {
+			subject.degree = input.degree + " in " + input.fieldOfStudy;
+			subject.id = "did:example:someone-else";
+			return subject;
+		}`,
+		"default.js": `function map(input, subject) {
+			return {id: subject.id, type: subject.type, name: input.studentName, sandboxed: typeof require + typeof process + typeof setTimeout};
+		}`,
+		"diploma.js":        `function map() { while (true) {} }`,
+		"certificate.js":    `var x = 1;`,
+		"higher-diploma.js": `function map() { return this.constructor.constructor("return process")().env; }`,
+	}
+	for name, src := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := config
+	t.Cleanup(func() { config = saved })
+	config.PIIMode = ""
+	config.NodeBin, config.ScriptsDir = "node", "scripts"
+	hook, err := newScriptHook(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(degree string) (map[string]interface{}, error) {
+		sess := &Session{Form: CredentialForm{StudentName: "Jane Wanjiku", Degree: degree, FieldOfStudy: "Physics", SubjectDID: "did:key:z6Mkjane"}}
+		form, _ := credentialForm(sess)
+		payload := buildCredentialPayload(form, e2eIssuerDID, "Ed25519Signature2020")
+		err := hook.BeforeSign(context.Background(), sess, payload)
+		subject, _ := payload["credential"].(map[string]interface{})["credentialSubject"].(map[string]interface{})
+		return subject, err
+	}
+
+	subject, err := sign("Bachelor of Science")
+	if err != nil || subject["degree"] != "Bachelor of Science in Physics" || subject["id"] != "did:key:z6Mkjane" {
+		t.Errorf("mapped subject %v, %v", subject, err)
+	}
+	subject, err = sign("Master of Arts")
+	if err != nil || subject["name"] != "Jane Wanjiku" || subject["sandboxed"] != "undefinedundefinedundefined" {
+		t.Errorf("default subject %v, %v", subject, err)
+	}
+	for degree, want := range map[string]string{
+		"Diploma":        "timed out",
+		"Certificate":    "defines no map",
+		"Higher Diploma": "process is not defined",
+	} {
+		if _, err := sign(degree); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: %v, want %q", degree, err, want)
+		}
+	}
+}
@@ -0,0 +1,631 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Multi-tenancy. One deployment can issue for several institutions, each a
+// tenant in DATA_DIR/tenants.json with its own name, issuer DID, staff
+// users, branding and the credential types (qualifications) it may issue.
+// The issuance form is opened for a tenant at /?tenant=<id>, and the
+// credentials issued there are recorded under the tenant. A tenant user's
+// staff token ("Authorization: Bearer tst_...") reaches only that tenant's
+// credentials; STAFF_API_TOKEN stays the operator's token, for every tenant
+// and for the deployment-wide endpoints. A tenant can also be given custom
+// domains (see domains.go). Without tenants nothing changes.
+
+const tenantTokenPrefix = "tst_"
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,39}$`)
+
+var errTenantExists = errors.New("tenant already exists")
+
+// TenantBranding overrides the default theme (see theme.go).
+type TenantBranding struct {
+	LogoURL          string `json:"logoUrl,omitempty"`
+	PrimaryColor     string `json:"primaryColor,omitempty"`
+	Tagline          string `json:"tagline,omitempty"`
+	CertificateTitle string `json:"certificateTitle,omitempty"`
+	CertificateText  string `json:"certificateText,omitempty"`
+}
+
+type TenantUser struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Email     string   `json:"email,omitempty"`
+	TokenHash string   `json:"tokenHash,omitempty"`
+	Roles     []string `json:"roles,omitempty"` // maker, approver; see approvals.go
+	// UserName and ExternalID are the identity provider's, for users it
+	// provisions (see scim.go); Disabled users' tokens are refused.
+	UserName   string    `json:"userName,omitempty"`
+	ExternalID string    `json:"externalId,omitempty"`
+	Disabled   bool      `json:"disabled,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+type Tenant struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	IssuerDID string `json:"issuerDid,omitempty"` // ISSUER_DID when empty
+
+	Users    []*TenantUser  `json:"users,omitempty"`
+	Branding TenantBranding `json:"branding"`
+
+	// CredentialTypes are the qualifications the tenant issues; any when
+	// empty.
+	CredentialTypes []string `json:"credentialTypes,omitempty"`
+
+	// Domains serve the tenant's links and pages (see domains.go).
+	Domains []string `json:"domains,omitempty"`
+
+	// PDFArchival makes the tenant's certificates PDF/A (see pdfa.go).
+	PDFArchival bool `json:"pdfArchival,omitempty"`
+
+	// SCIMTokenHash is the hash of the identity provider's SCIM token.
+	SCIMTokenHash string `json:"scimTokenHash,omitempty"`
+
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Issuer is the DID the tenant's credentials are issued as.
+func (t Tenant) Issuer() string {
+	if t.IssuerDID == "" {
+		return config.IssuerDID
+	}
+	return t.IssuerDID
+}
+
+// Issues reports whether the tenant issues a qualification.
+func (t Tenant) Issues(degree string) bool {
+	return len(t.CredentialTypes) == 0 || slices.Contains(t.CredentialTypes, degree)
+}
+
+// public copies a tenant without its users' token hashes.
+func (t *Tenant) public() Tenant {
+	c := *t
+	c.SCIMTokenHash = ""
+	c.Users = make([]*TenantUser, len(t.Users))
+	for i, u := range t.Users {
+		uc := *u
+		uc.TokenHash = ""
+		c.Users[i] = &uc
+	}
+	return c
+}
+
+type TenantStore struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*Tenant
+}
+
+var tenants *TenantStore
+
+func NewTenantStore(dataDir string) (*TenantStore, error) {
+	s := &TenantStore{
+		path:  filepath.Join(dataDir, "tenants.json"),
+		items: make(map[string]*Tenant),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing tenants: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading tenants: %w", err)
+	}
+	return s, nil
+}
+
+func (s *TenantStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing tenants: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Create adds a tenant.
+func (s *TenantStore) Create(t Tenant) (Tenant, error) {
+	if !tenantIDPattern.MatchString(t.ID) {
+		return Tenant{}, fmt.Errorf("tenant IDs are lowercase letters, digits and dashes")
+	}
+	if strings.TrimSpace(t.Name) == "" {
+		return Tenant{}, fmt.Errorf("a tenant needs a name")
+	}
+	if t.IssuerDID != "" && !strings.HasPrefix(t.IssuerDID, "did:") {
+		return Tenant{}, fmt.Errorf("invalid issuer DID %q", t.IssuerDID)
+	}
+	if err := t.Branding.validate(); err != nil {
+		return Tenant{}, err
+	}
+	domains, err := validateDomains(t.Domains)
+	if err != nil {
+		return Tenant{}, err
+	}
+	t.Domains = domains
+	t.Users = nil
+	t.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.items[t.ID]; ok {
+		return Tenant{}, errTenantExists
+	}
+	if err := s.checkDomainsLocked(t.ID, domains); err != nil {
+		return Tenant{}, err
+	}
+	s.items[t.ID] = &t
+	return t.public(), s.saveLocked()
+}
+
+func (s *TenantStore) Get(id string) (Tenant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[id]
+	if !ok {
+		return Tenant{}, false
+	}
+	return t.public(), true
+}
+
+// List returns every tenant, oldest first.
+func (s *TenantStore) List() []Tenant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Tenant, 0, len(s.items))
+	for _, t := range s.items {
+		out = append(out, t.public())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// ByIssuer returns the tenant issuing as a DID of its own.
+func (s *TenantStore) ByIssuer(did string) (Tenant, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.items {
+		if t.IssuerDID != "" && t.IssuerDID == did {
+			return t.public(), true
+		}
+	}
+	return Tenant{}, false
+}
+
+// ByDomain returns the tenant a domain belongs to.
+func (s *TenantStore) ByDomain(domain string) (Tenant, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.items {
+		if slices.Contains(t.Domains, domain) {
+			return t.public(), true
+		}
+	}
+	return Tenant{}, false
+}
+
+// checkDomainsLocked rejects domains another tenant already has.
+func (s *TenantStore) checkDomainsLocked(id string, domains []string) error {
+	for _, t := range s.items {
+		if t.ID == id {
+			continue
+		}
+		for _, d := range domains {
+			if slices.Contains(t.Domains, d) {
+				return fmt.Errorf("%s is already a domain of tenant %s", d, t.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// SetDomains replaces a tenant's domains.
+func (s *TenantStore) SetDomains(id string, domains []string) error {
+	domains, err := validateDomains(domains)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if err := s.checkDomainsLocked(id, domains); err != nil {
+		return err
+	}
+	t.Domains = domains
+	return s.saveLocked()
+}
+
+// SetBranding replaces a tenant's branding.
+func (s *TenantStore) SetBranding(id string, b TenantBranding) error {
+	if err := b.validate(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	t.Branding = b
+	return s.saveLocked()
+}
+
+// newTenantToken returns a new staff token, with prefix, and its hash.
+func newTenantToken(prefix string) (string, string) {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	token := prefix + base64.RawURLEncoding.EncodeToString(secret)
+	return token, hashVerifierKey(token)
+}
+
+func newTenantUserID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// AddUser gives a tenant a staff user with roles and returns the user's
+// token.
+func (s *TenantStore) AddUser(tenantID, name, email string, roles []string) (TenantUser, string, error) {
+	token, hash := newTenantToken(tenantTokenPrefix)
+	u := &TenantUser{
+		ID:        newTenantUserID(),
+		Name:      name,
+		Email:     email,
+		TokenHash: hash,
+		Roles:     roles,
+		CreatedAt: time.Now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[tenantID]
+	if !ok {
+		return TenantUser{}, "", os.ErrNotExist
+	}
+	t.Users = append(t.Users, u)
+	pub := *u
+	pub.TokenHash = ""
+	return pub, token, s.saveLocked()
+}
+
+// errUserNameTaken is returned for a user name another user of the tenant
+// has.
+var errUserNameTaken = errors.New("user name already taken")
+
+// PutUser adds a staff user to a tenant without a token, or, with u.ID
+// set, replaces the user's details, keeping the token.
+func (s *TenantStore) PutUser(tenantID string, u TenantUser) (TenantUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[tenantID]
+	if !ok {
+		return TenantUser{}, os.ErrNotExist
+	}
+	i := -1
+	for j, other := range t.Users {
+		if other.ID == u.ID {
+			i = j
+		} else if u.UserName != "" && strings.EqualFold(other.UserName, u.UserName) {
+			return TenantUser{}, errUserNameTaken
+		}
+	}
+	switch {
+	case u.ID == "":
+		u.ID, u.TokenHash, u.CreatedAt = newTenantUserID(), "", time.Now().UTC()
+		t.Users = append(t.Users, &u)
+	case i < 0:
+		return TenantUser{}, os.ErrNotExist
+	default:
+		u.TokenHash, u.CreatedAt = t.Users[i].TokenHash, t.Users[i].CreatedAt
+		t.Users[i] = &u
+	}
+	pub := u
+	pub.TokenHash = ""
+	return pub, s.saveLocked()
+}
+
+// DeleteUser removes a staff user from a tenant.
+func (s *TenantStore) DeleteUser(tenantID, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[tenantID]
+	if !ok {
+		return os.ErrNotExist
+	}
+	i := slices.IndexFunc(t.Users, func(u *TenantUser) bool { return u.ID == userID })
+	if i < 0 {
+		return os.ErrNotExist
+	}
+	t.Users = slices.Delete(t.Users, i, i+1)
+	return s.saveLocked()
+}
+
+// IssueUserToken gives a staff user a new token, which replaces any the
+// user had, and returns it.
+func (s *TenantStore) IssueUserToken(tenantID, userID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[tenantID]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	for _, u := range t.Users {
+		if u.ID == userID {
+			token, hash := newTenantToken(tenantTokenPrefix)
+			u.TokenHash = hash
+			return token, s.saveLocked()
+		}
+	}
+	return "", os.ErrNotExist
+}
+
+// SetSCIMToken gives a tenant a new SCIM token, which replaces any it had,
+// and returns it.
+func (s *TenantStore) SetSCIMToken(tenantID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.items[tenantID]
+	if !ok {
+		return "", os.ErrNotExist
+	}
+	token, hash := newTenantToken(scimTokenPrefix)
+	t.SCIMTokenHash = hash
+	return token, s.saveLocked()
+}
+
+// AuthenticateSCIM returns the tenant a SCIM token belongs to.
+func (s *TenantStore) AuthenticateSCIM(token string) (Tenant, bool) {
+	if !strings.HasPrefix(token, scimTokenPrefix) {
+		return Tenant{}, false
+	}
+	hash := hashVerifierKey(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.items {
+		if t.SCIMTokenHash != "" && subtle.ConstantTimeCompare([]byte(t.SCIMTokenHash), []byte(hash)) == 1 {
+			return t.public(), true
+		}
+	}
+	return Tenant{}, false
+}
+
+// Authenticate returns the tenant and user a staff token belongs to.
+func (s *TenantStore) Authenticate(token string) (Tenant, TenantUser, bool) {
+	if !strings.HasPrefix(token, tenantTokenPrefix) {
+		return Tenant{}, TenantUser{}, false
+	}
+	hash := hashVerifierKey(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.items {
+		for _, u := range t.Users {
+			if subtle.ConstantTimeCompare([]byte(u.TokenHash), []byte(hash)) == 1 && !u.Disabled {
+				pub := *u
+				pub.TokenHash = ""
+				return t.public(), pub, true
+			}
+		}
+	}
+	return Tenant{}, TenantUser{}, false
+}
+
+type tenantContextKey struct{}
+
+// requireTenantStaff admits the operator (STAFF_API_TOKEN) and tenant
+// users, passing a tenant user's tenant on in the request context.
+func requireTenantStaff(next http.HandlerFunc) http.HandlerFunc {
+	operator := requireStaff(next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(token, tenantTokenPrefix) {
+			operator(w, r)
+			return
+		}
+		t, u, ok := tenants.Authenticate(token)
+		if !ok {
+			slog.WarnContext(r.Context(), "staff API: unauthorized", "method", r.Method, "path", r.URL.Path)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		slog.InfoContext(r.Context(), "staff API", "user", u.ID, "tenant", t.ID, "method", r.Method, "path", r.URL.Path)
+		next(w, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, t.ID)))
+	}
+}
+
+// staffTenant is the tenant a staff request is limited to, or "" for the
+// operator.
+func staffTenant(r *http.Request) string {
+	id, _ := r.Context().Value(tenantContextKey{}).(string)
+	return id
+}
+
+// staffCredential looks up a stored credential the staff member may manage.
+func staffCredential(r *http.Request, id string) (*StoredCredential, bool) {
+	cred, ok := store.Get(id)
+	if !ok {
+		return nil, false
+	}
+	if t := staffTenant(r); t != "" && cred.TenantID != t {
+		return nil, false
+	}
+	return cred, true
+}
+
+// formTenant returns the tenant an issuance form was opened for.
+func formTenant(r *http.Request) (*Tenant, error) {
+	id := r.FormValue("tenant")
+	if id == "" {
+		return nil, nil
+	}
+	t, ok := tenants.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("unknown institution %q", id)
+	}
+	return &t, nil
+}
+
+func handleTenantList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tenants.List())
+}
+
+func handleTenantCreate(w http.ResponseWriter, r *http.Request) {
+	var req Tenant
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "expected JSON tenant", http.StatusBadRequest)
+		return
+	}
+	if req.IssuerDID != "" && localSigner != nil && req.IssuerDID != config.IssuerDID {
+		http.Error(w, "tenant issuer DIDs need the agent; SIGNING_MODE is local", http.StatusBadRequest)
+		return
+	}
+	t, err := tenants.Create(req)
+	switch {
+	case errors.Is(err, errTenantExists):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: tenant created", "tenant", t.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+func handleTenantUserCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name  string   `json:"name"`
+		Email string   `json:"email"`
+		Roles []string `json:"roles"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "expected JSON body with name", http.StatusBadRequest)
+		return
+	}
+	for _, role := range req.Roles {
+		if !slices.Contains(staffRoles, role) {
+			http.Error(w, "roles are maker and approver", http.StatusBadRequest)
+			return
+		}
+	}
+	u, token, err := tenants.AddUser(r.PathValue("id"), strings.TrimSpace(req.Name), strings.TrimSpace(req.Email), req.Roles)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Unknown tenant", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "tenant user error", "err", err)
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: tenant user created", "tenant", r.PathValue("id"), "user", u.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		TenantUser
+		Token string `json:"token"`
+	}{u, token})
+}
+
+// handleTenantUserToken gives a staff user a new token, as users
+// provisioned by SCIM need before they first sign in.
+func handleTenantUserToken(w http.ResponseWriter, r *http.Request) {
+	token, err := tenants.IssueUserToken(r.PathValue("id"), r.PathValue("user"))
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Unknown tenant or user", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "tenant user token error", "err", err)
+		http.Error(w, "Failed to issue the token", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: tenant user token issued", "tenant", r.PathValue("id"), "user", r.PathValue("user"))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+// handleTenantBranding replaces a tenant's branding. Tenant users may
+// brand their own tenant.
+func handleTenantBranding(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if t := staffTenant(r); t != "" && t != id {
+		http.Error(w, "Unknown tenant", http.StatusNotFound)
+		return
+	}
+	var b TenantBranding
+	if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+		http.Error(w, "expected JSON branding", http.StatusBadRequest)
+		return
+	}
+	switch err := tenants.SetBranding(id, b); {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "Unknown tenant", http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: tenant branding updated", "tenant", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCredentialList lists the stored credentials a staff member may
+// manage, newest first: those in use, or with ?archived=true the archived
+// ones (see archive.go).
+func handleCredentialList(w http.ResponseWriter, r *http.Request) {
+	type item struct {
+		ID         string    `json:"id"`
+		Tenant     string    `json:"tenant,omitempty"`
+		Format     string    `json:"format"`
+		IssuedAt   time.Time `json:"issuedAt"`
+		Status     string    `json:"status,omitempty"`
+		ArchivedAt time.Time `json:"archivedAt,omitempty"`
+	}
+	tenant := staffTenant(r)
+	if q := r.URL.Query().Get("tenant"); tenant == "" {
+		tenant = q
+	}
+	list := store.ForTenant
+	if r.URL.Query().Get("archived") == "true" {
+		list = store.Archived
+	}
+	out := []item{}
+	for _, c := range list(tenant) {
+		out = append(out, item{ID: c.ID, Tenant: c.TenantID, Format: c.Format, IssuedAt: c.IssuedAt, Status: c.Status, ArchivedAt: c.ArchivedAt})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestTenantIsolation checks a tenant user's staff token reaches only the
+// tenant's own credentials and settings, while the operator's reaches all.
+func TestTenantIsolation(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	config.StaffAPIToken = "staff-token-0123456789"
+	for _, tenant := range []Tenant{{ID: "testa", Name: "Testa University"}, {ID: "other", Name: "Other University"}} {
+		if _, err := tenants.Create(tenant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, registrar, err := tenants.AddUser("testa", "Registrar", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id, tenant := range map[string]string{"testa-1": "testa", "other-1": "other"} {
+		if err := store.Put(&StoredCredential{ID: id, TenantID: tenant, Format: FormatLDP, Credential: []byte(`{}`), IssuedAt: time.Now().UTC()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	call := func(token, method, path, body string) (int, string) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		out, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(out)
+	}
+	listed := func(token string) []string {
+		t.Helper()
+		status, body := call(token, "GET", "/api/staff/credentials", "")
+		var list []struct{ ID string }
+		if status != http.StatusOK || json.Unmarshal([]byte(body), &list) != nil {
+			t.Fatalf("listing: HTTP %d: %s", status, body)
+		}
+		var ids []string
+		for _, c := range list {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	if ids := listed(registrar); len(ids) != 1 || ids[0] != "testa-1" {
+		t.Errorf("the tenant user lists %v", ids)
+	}
+	if ids := listed(config.StaffAPIToken); len(ids) != 2 {
+		t.Errorf("the operator lists %v", ids)
+	}
+
+	for _, c := range []struct {
+		name, method, path, body string
+		want                     int
+	}{
+		{"own status", "POST", "/api/staff/credentials/testa-1/status", `{"status":"suspended"}`, http.StatusOK},
+		{"other's status", "POST", "/api/staff/credentials/other-1/status", `{"status":"revoked"}`, http.StatusNotFound},
+		{"other's archive", "POST", "/api/staff/credentials/other-1/archive", "", http.StatusNotFound},
+		{"other's timestamp", "GET", "/api/staff/credentials/other-1/timestamp.tsr", "", http.StatusNotFound},
+		{"own branding", "PUT", "/api/staff/tenants/testa/branding", `{"tagline":"Ours"}`, http.StatusNoContent},
+		{"other's branding", "PUT", "/api/staff/tenants/other/branding", `{"tagline":"Theirs"}`, http.StatusNotFound},
+		{"operator endpoint", "POST", "/api/staff/tenants", `{"id":"third","name":"Third"}`, http.StatusUnauthorized},
+	} {
+		if status, body := call(registrar, c.method, c.path, c.body); status != c.want {
+			t.Errorf("%s: HTTP %d, want %d: %s", c.name, status, c.want, body)
+		}
+	}
+	if c, _ := store.Get("other-1"); c.Status != "" || !c.ArchivedAt.IsZero() {
+		t.Errorf("the tenant user changed another tenant's credential: %+v", c)
+	}
+	if other, _ := tenants.Get("other"); other.Branding.Tagline != "" {
+		t.Errorf("the tenant user rebranded another tenant: %+v", other.Branding)
+	}
+	if status, _ := call(tenantTokenPrefix+"forged", "GET", "/api/staff/credentials", ""); status != http.StatusUnauthorized {
+		t.Errorf("a forged tenant token: HTTP %d", status)
+	}
+	if status, body := call(config.StaffAPIToken, "POST", "/api/staff/credentials/other-1/status", `{"status":"suspended"}`); status != http.StatusOK {
+		t.Errorf("the operator: HTTP %d: %s", status, body)
+	}
+}
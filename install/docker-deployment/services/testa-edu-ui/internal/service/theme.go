@@ -0,0 +1,175 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Themes. The issuance wizard, emails and PDF certificates carry the
+// issuing institution's name, logo, primary colour and certificate
+// wording. The deployment's theme comes from THEME_NAME (ISSUER_NAME by
+// default), THEME_TAGLINE, THEME_LOGO, THEME_PRIMARY_COLOR and
+// THEME_FOOTER; a tenant's branding (see tenants.go) overrides it field by
+// field.
+
+// Theme is the branding a page, email or certificate is rendered with.
+type Theme struct {
+	Name             string
+	Tagline          string
+	LogoURL          string
+	PrimaryColor     string // #rrggbb
+	CertificateTitle string
+	CertificateText  string // statement printed above the credential details
+	Footer           string
+}
+
+var defaultTheme = Theme{
+	Name:             "Testa Edu",
+	Tagline:          "Education Credential Issuance Portal",
+	LogoURL:          "/static/logo.svg",
+	PrimaryColor:     "#4338ca",
+	CertificateTitle: "Verifiable Education Credential",
+	Footer:           "Powered by CREDEBL · Verifiable with Inji Verify",
+}
+
+var hexColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+const maxLogoSize = 1 << 20
+
+var logoClient = &http.Client{Timeout: 5 * time.Second}
+
+// loadTheme reads the deployment's theme from the environment.
+func loadTheme(institution string) (Theme, error) {
+	t := defaultTheme
+	t.Name = envOr("THEME_NAME", institution)
+	t.Tagline = envOr("THEME_TAGLINE", t.Tagline)
+	t.LogoURL = envOr("THEME_LOGO", t.LogoURL)
+	t.PrimaryColor = envOr("THEME_PRIMARY_COLOR", t.PrimaryColor)
+	t.Footer = envOr("THEME_FOOTER", t.Footer)
+	if !hexColorPattern.MatchString(t.PrimaryColor) {
+		return Theme{}, fmt.Errorf("THEME_PRIMARY_COLOR must be a #rrggbb colour")
+	}
+	if !strings.HasPrefix(t.LogoURL, "https://") && !strings.HasPrefix(t.LogoURL, "/") {
+		return Theme{}, fmt.Errorf("THEME_LOGO must be an https URL or a path on this site")
+	}
+	return t, nil
+}
+
+// themeFor returns the theme of a tenant, or the deployment's theme for ""
+// and unknown tenants.
+func themeFor(tenantID string) Theme {
+	t := liveConfig().Theme
+	if tenantID == "" {
+		return t
+	}
+	tenant, ok := tenants.Get(tenantID)
+	if !ok {
+		return t
+	}
+	t.Name = tenant.Name
+	b := tenant.Branding
+	for dst, src := range map[*string]string{
+		&t.Tagline:          b.Tagline,
+		&t.LogoURL:          b.LogoURL,
+		&t.PrimaryColor:     b.PrimaryColor,
+		&t.CertificateTitle: b.CertificateTitle,
+		&t.CertificateText:  b.CertificateText,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+	return t
+}
+
+// pageTheme is the "theme" template function: the Theme in a page's data,
+// or the deployment's theme, with a logo of the app's under the base path.
+func pageTheme(data interface{}) Theme {
+	t := liveConfig().Theme
+	if m, ok := data.(map[string]interface{}); ok {
+		if th, ok := m["Theme"].(Theme); ok {
+			t = th
+		}
+	}
+	if strings.HasPrefix(t.LogoURL, "/") && !strings.HasPrefix(t.LogoURL, "//") {
+		t.LogoURL = appPath(t.LogoURL)
+	}
+	return t
+}
+
+func (t Theme) rgb() (r, g, b int) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(t.PrimaryColor, "#"), 16, 32)
+	if err != nil || !hexColorPattern.MatchString(t.PrimaryColor) {
+		return defaultTheme.rgb()
+	}
+	return int(n >> 16 & 0xff), int(n >> 8 & 0xff), int(n & 0xff)
+}
+
+// PrimaryDark is the primary colour darkened for hover states.
+func (t Theme) PrimaryDark() string {
+	r, g, b := t.rgb()
+	return fmt.Sprintf("#%02x%02x%02x", r*85/100, g*85/100, b*85/100)
+}
+
+// AbsoluteLogoURL is the logo URL for use outside the site, e.g. in emails.
+func (t Theme) AbsoluteLogoURL() string {
+	if strings.HasPrefix(t.LogoURL, "/") {
+		return config.PublicURL + t.LogoURL
+	}
+	return t.LogoURL
+}
+
+// logoImage loads a PNG or JPEG logo for embedding in a PDF, returning its
+// fpdf image type. The default SVG logo cannot be embedded.
+func (t Theme) logoImage() ([]byte, string, error) {
+	var data []byte
+	var err error
+	if p, ok := strings.CutPrefix(t.LogoURL, "/static/"); ok {
+		data, err = os.ReadFile(filepath.Join("static", filepath.Clean("/"+p)))
+	} else {
+		data, err = fetchLogo(t.LogoURL)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	switch http.DetectContentType(data) {
+	case "image/png":
+		return data, "PNG", nil
+	case "image/jpeg":
+		return data, "JPG", nil
+	}
+	return nil, "", fmt.Errorf("logo is not a PNG or JPEG image")
+}
+
+func fetchLogo(logoURL string) ([]byte, error) {
+	if !strings.HasPrefix(logoURL, "https://") {
+		return nil, fmt.Errorf("logo %q is not an https URL", logoURL)
+	}
+	resp, err := logoClient.Get(logoURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("logo: HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxLogoSize))
+}
+
+// validate checks tenant branding before it is saved.
+func (b TenantBranding) validate() error {
+	if b.PrimaryColor != "" && !hexColorPattern.MatchString(b.PrimaryColor) {
+		return fmt.Errorf("primaryColor must be a #rrggbb colour")
+	}
+	if b.LogoURL != "" && !strings.HasPrefix(b.LogoURL, "https://") && !strings.HasPrefix(b.LogoURL, "/") {
+		return fmt.Errorf("logoUrl must be an https URL or a path on this site")
+	}
+	return nil
+}
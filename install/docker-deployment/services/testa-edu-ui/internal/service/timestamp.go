@@ -0,0 +1,245 @@
+package service
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RFC 3161 timestamping. With TSA_URL set, the SHA-256 of every signed
+// credential (its credentialDigest, as anchored) is sent to that Time
+// Stamping Authority, and the timestamp token it returns is kept with the
+// credential record. The token is signed by the TSA, so it proves when the
+// credential was issued without trusting this server's clock: staff can
+// download it from /api/staff/credentials/{id}/timestamp.tsr and check it
+// with `openssl ts -verify`. Verification reports the time it attests.
+//
+// A failed timestamp request is logged and does not stop issuance.
+
+const maxTimestampResponse = 1 << 20
+
+var tsaClient = &http.Client{Timeout: 15 * time.Second}
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+type tsMessageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type tsRequest struct {
+	Version        int
+	MessageImprint tsMessageImprint
+	Nonce          *big.Int
+	CertReq        bool `asn1:"optional"`
+}
+
+type tsStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional,utf8"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type tsResponse struct {
+	Status tsStatusInfo
+	Token  asn1.RawValue `asn1:"optional"`
+}
+
+type tsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type tsSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo struct {
+		EContentType asn1.ObjectIdentifier
+		EContent     []byte `asn1:"explicit,tag:0"`
+	}
+}
+
+type tsAccuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint tsMessageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time  `asn1:"generalized"`
+	Accuracy       tsAccuracy `asn1:"optional"`
+	Ordering       bool       `asn1:"optional"`
+	Nonce          *big.Int   `asn1:"optional"`
+}
+
+// requestTimestamp gets a timestamp token for a hex SHA-256 digest.
+func requestTimestamp(digest string) ([]byte, error) {
+	hash, err := hex.DecodeString(digest)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+	req, err := asn1.Marshal(tsRequest{
+		Version: 1,
+		MessageImprint: tsMessageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue},
+			HashedMessage: hash,
+		},
+		Nonce:   nonce,
+		CertReq: true, // embed the TSA certificate so the token verifies on its own
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := tsaClient.Post(config.TSAURL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("TSA: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTimestampResponse))
+	if err != nil {
+		return nil, fmt.Errorf("TSA: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TSA: HTTP %d", resp.StatusCode)
+	}
+
+	var out tsResponse
+	if _, err := asn1.Unmarshal(body, &out); err != nil {
+		return nil, fmt.Errorf("TSA: parsing response: %w", err)
+	}
+	// 0 is granted, 1 granted with modifications.
+	if out.Status.Status > 1 {
+		return nil, fmt.Errorf("TSA: request rejected (status %d) %s", out.Status.Status, strings.Join(out.Status.StatusString, "; "))
+	}
+	token := out.Token.FullBytes
+	info, err := parseTimestampToken(token)
+	if err != nil {
+		return nil, fmt.Errorf("TSA: %w", err)
+	}
+	if !bytes.Equal(info.MessageImprint.HashedMessage, hash) || info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return nil, fmt.Errorf("TSA: the token does not answer the request")
+	}
+	return token, nil
+}
+
+// parseTimestampToken extracts the TSTInfo from a timestamp token. The
+// TSA's signature is not checked here; that is left to the holder of the
+// TSA's certificate chain.
+func parseTimestampToken(token []byte) (*tstInfo, error) {
+	var ci tsContentInfo
+	if _, err := asn1.Unmarshal(token, &ci); err != nil || !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("timestamp token is not CMS signed data")
+	}
+	var sd tsSignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("parsing timestamp token: %w", err)
+	}
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("timestamp token holds no TSTInfo")
+	}
+	var info tstInfo
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent, &info); err != nil {
+		return nil, fmt.Errorf("parsing TSTInfo: %w", err)
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("timestamp is not over a SHA-256 hash")
+	}
+	return &info, nil
+}
+
+// timestampIssued timestamps a newly signed credential, returning nil when
+// timestamping is off or fails.
+func timestampIssued(sess *Session) []byte {
+	if config.TSAURL == "" || sess.Format == FormatAnonCreds {
+		return nil
+	}
+	digest, err := credentialDigest(sess.SignedCredential)
+	if err == nil {
+		var token []byte
+		if token, err = requestTimestamp(digest); err == nil {
+			return token
+		}
+	}
+	slog.Error("timestamping", "err", err)
+	return nil
+}
+
+// checkTimestamp reports the issuance time a stored credential's timestamp
+// token attests.
+func (r *ScanResult) checkTimestamp(sc *scannedCredential, cred *StoredCredential) {
+	if cred == nil || cred.Timestamp == nil {
+		r.check("timestamp", CheckSkipped, "no trusted timestamp")
+		return
+	}
+	info, err := parseTimestampToken(cred.Timestamp)
+	if err != nil {
+		r.check("timestamp", CheckFailed, err.Error())
+		return
+	}
+	digest, err := credentialDigest(sc.Credential)
+	if err != nil || hex.EncodeToString(info.MessageImprint.HashedMessage) != digest {
+		r.check("timestamp", CheckFailed, "the timestamp is for a different credential")
+		return
+	}
+	r.check("timestamp", CheckPassed, "issued by "+info.GenTime.UTC().Format(time.RFC3339))
+}
+
+func handleTimestampDownload(w http.ResponseWriter, r *http.Request) {
+	cred, ok := staffCredential(r, r.PathValue("id"))
+	if !ok || cred.Timestamp == nil {
+		http.Error(w, "No timestamp for this credential", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+cred.ID+`.tsr"`)
+	// A TimeStampResp wrapping the token, as openssl ts -verify expects.
+	status, _ := asn1.Marshal(tsStatusInfo{})
+	resp, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: append(status, cred.Timestamp...)})
+	w.Write(resp)
+}
+
+// credentialDigest is the hex SHA-256 a credential is timestamped and
+// anchored under. It covers the credential's JSON with object keys sorted,
+// or for a JWT its compact form; an SD-JWT is hashed without its
+// disclosures, so every presentation of it matches.
+func credentialDigest(credential json.RawMessage) (string, error) {
+	var compact string
+	if json.Unmarshal(credential, &compact) == nil {
+		jwt, _, _ := strings.Cut(compact, "~")
+		sum := sha256.Sum256([]byte(jwt))
+		return hex.EncodeToString(sum[:]), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(credential, &v); err != nil {
+		return "", err
+	}
+	normalized, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:]), nil
+}
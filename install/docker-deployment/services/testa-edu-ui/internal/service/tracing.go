@@ -0,0 +1,370 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tracing. With OTEL_EXPORTER_OTLP_ENDPOINT set, e.g. to an OpenTelemetry
+// collector at http://otel-collector:4318, the service records spans for
+// each request, its agent calls and the QR codes and PDFs it generates,
+// and exports them over OTLP/HTTP (JSON), with the headers in
+// OTEL_EXPORTER_OTLP_HEADERS (k=v,k2=v2) and as OTEL_SERVICE_NAME.
+//
+// Trace context travels in the W3C traceparent header: a request that
+// arrives with one continues its trace, and agent calls carry it on, so
+// an agent that traces too shows its work under the wizard step that
+// asked for it. A request's trace ID is added to its log fields.
+//
+// Spans are exported in batches, every few seconds or when a batch is
+// full; spans that would overflow the buffer are dropped rather than
+// slow a request.
+
+const (
+	traceparentHeader  = "traceparent"
+	traceBatchSize     = 512
+	traceBufferSize    = 4096
+	traceFlushEvery    = 5 * time.Second
+	traceExportTimeout = 10 * time.Second
+)
+
+// Span kinds and status codes of the OTLP protocol.
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+	spanKindClient   = 3
+
+	spanStatusError = 2
+)
+
+// tracer exports the spans; nil when tracing is off.
+var tracer *spanExporter
+
+// traceContext identifies a span, for its children and for propagation.
+type traceContext struct {
+	traceID [16]byte
+	spanID  [8]byte
+	sampled bool
+}
+
+type traceContextKey struct{}
+
+// span is an operation being traced. A nil span, as started with tracing
+// off, records nothing.
+type span struct {
+	tc       traceContext
+	parentID [8]byte
+	name     string
+	kind     int
+	start    time.Time
+	attrs    map[string]interface{}
+}
+
+// startSpan starts a span, the child of the span in ctx if there is one,
+// and returns ctx with the new span in it.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &span{name: name, kind: kind, start: time.Now(), attrs: map[string]interface{}{}}
+	if parent, ok := ctx.Value(traceContextKey{}).(traceContext); ok {
+		s.tc.traceID, s.tc.sampled, s.parentID = parent.traceID, parent.sampled, parent.spanID
+	} else {
+		rand.Read(s.tc.traceID[:])
+		s.tc.sampled = true
+	}
+	rand.Read(s.tc.spanID[:])
+	return context.WithValue(ctx, traceContextKey{}, s.tc), s
+}
+
+// set records an attribute of the span.
+func (s *span) set(key string, value interface{}) {
+	if s != nil {
+		s.attrs[key] = value
+	}
+}
+
+// end ends the span, failed if err is set, and queues it for export.
+func (s *span) end(err error) {
+	if s == nil || !s.tc.sampled {
+		return
+	}
+	tracer.export(s.otlp(time.Now(), err))
+}
+
+// traceparent is the W3C traceparent header value of tc.
+func (tc traceContext) traceparent() string {
+	flags := "00"
+	if tc.sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(tc.traceID[:]) + "-" + hex.EncodeToString(tc.spanID[:]) + "-" + flags
+}
+
+// parseTraceparent reads a W3C traceparent header value.
+func parseTraceparent(v string) (traceContext, bool) {
+	var tc traceContext
+	parts := strings.Split(v, "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return tc, false
+	}
+	if parts[0] == "00" && len(parts) != 4 {
+		return tc, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.traceID[:], []byte(parts[1])); err != nil || tc.traceID == [16]byte{} {
+		return tc, false
+	}
+	if _, err := hex.Decode(tc.spanID[:], []byte(parts[2])); err != nil || tc.spanID == [8]byte{} {
+		return tc, false
+	}
+	tc.sampled = flags&1 == 1
+	return tc, true
+}
+
+// injectTrace adds the trace context of ctx to an outgoing request.
+func injectTrace(ctx context.Context, req *http.Request) {
+	if tc, ok := ctx.Value(traceContextKey{}).(traceContext); ok {
+		req.Header.Set(traceparentHeader, tc.traceparent())
+	}
+}
+
+// traceRequests traces each request as a server span named by its route.
+func traceRequests(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracer == nil {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if tc, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+			ctx = context.WithValue(ctx, traceContextKey{}, tc)
+		}
+		name := r.Method
+		if _, pattern := mux.Handler(r); pattern != "" {
+			name = pattern
+		}
+		ctx, s := startSpan(ctx, name, spanKindServer)
+		s.set("http.request.method", r.Method)
+		s.set("url.path", r.URL.Path)
+		if id := requestID(ctx); id != "" {
+			s.set("request.id", id)
+		}
+		ctx = withLogFields(ctx, slog.String("trace_id", hex.EncodeToString(s.tc.traceID[:])))
+
+		sw := &accessLogWriter{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(sw, r.WithContext(ctx))
+		s.set("http.response.status_code", sw.status)
+		var err error
+		if sw.status >= 500 {
+			err = fmt.Errorf("HTTP %d", sw.status)
+		}
+		s.end(err)
+	})
+}
+
+// spanExporter batches spans and posts them to the OTLP endpoint.
+type spanExporter struct {
+	endpoint string
+	headers  map[string]string
+	service  string
+	client   *http.Client
+	spans    chan otlpSpan
+	flushes  chan chan struct{}
+}
+
+// startTracing starts exporting spans, if an OTLP endpoint is configured.
+func startTracing() {
+	if config.OTLPEndpoint == "" {
+		return
+	}
+	tracer = &spanExporter{
+		endpoint: strings.TrimRight(config.OTLPEndpoint, "/") + "/v1/traces",
+		headers:  config.OTLPHeaders,
+		service:  config.TraceServiceName,
+		client:   &http.Client{Timeout: traceExportTimeout},
+		spans:    make(chan otlpSpan, traceBufferSize),
+		flushes:  make(chan chan struct{}),
+	}
+	go tracer.run()
+}
+
+// stopTracing exports the spans still buffered, waiting until ctx is done
+// at most.
+func stopTracing(ctx context.Context) {
+	if tracer == nil {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case tracer.flushes <- done:
+	case <-ctx.Done():
+		return
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+func (e *spanExporter) export(s otlpSpan) {
+	select {
+	case e.spans <- s:
+	default:
+	}
+}
+
+func (e *spanExporter) run() {
+	ticker := time.NewTicker(traceFlushEvery)
+	defer ticker.Stop()
+	var batch []otlpSpan
+	flush := func() {
+		if len(batch) > 0 {
+			if err := e.post(batch); err != nil {
+				slog.Warn("trace export", "spans", len(batch), "err", err)
+			}
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.spans:
+			if batch = append(batch, s); len(batch) >= traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case done := <-e.flushes:
+			for len(e.spans) > 0 {
+				batch = append(batch, <-e.spans)
+			}
+			flush()
+			close(done)
+		}
+	}
+}
+
+// post sends a batch of spans in an OTLP ExportTraceServiceRequest.
+func (e *spanExporter) post(spans []otlpSpan) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{"service.name": e.service}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "testa-edu-ui"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
+
+// otlpSpan is a span in OTLP's JSON encoding.
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string                 `json:"key"`
+	Value map[string]interface{} `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+func (s *span) otlp(end time.Time, err error) otlpSpan {
+	o := otlpSpan{
+		TraceID:    hex.EncodeToString(s.tc.traceID[:]),
+		SpanID:     hex.EncodeToString(s.tc.spanID[:]),
+		Name:       s.name,
+		Kind:       s.kind,
+		Start:      strconv.FormatInt(s.start.UnixNano(), 10),
+		End:        strconv.FormatInt(end.UnixNano(), 10),
+		Attributes: otlpAttributes(s.attrs),
+	}
+	if s.parentID != [8]byte{} {
+		o.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if err != nil {
+		o.Status = &otlpStatus{Code: spanStatusError, Message: err.Error()}
+	}
+	return o
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpAttribute {
+	var out []otlpAttribute
+	for k, v := range attrs {
+		var value map[string]interface{}
+		switch v := v.(type) {
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+		out = append(out, otlpAttribute{Key: k, Value: value})
+	}
+	return out
+}
+
+// parseOTLPHeaders reads OTEL_EXPORTER_OTLP_HEADERS: comma-separated
+// key=value pairs, the values URL-encoded.
+func parseOTLPHeaders(s string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, pair := range splitList(s) {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS entry %q, want key=value", pair)
+		}
+		value, err := url.QueryUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTEL_EXPORTER_OTLP_HEADERS value for %s: %w", k, err)
+		}
+		headers[strings.TrimSpace(k)] = value
+	}
+	return headers, nil
+}
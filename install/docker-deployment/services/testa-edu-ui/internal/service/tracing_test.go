@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tc, ok := parseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok || tc.traceparent() != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("traceparent %v %v", tc, ok)
+	}
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, ok := parseTraceparent(bad); ok {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+}
+
+// TestTracing checks a request continues the caller's trace, its agent
+// call is its child and carries the trace on, and the spans are exported.
+func TestTracing(t *testing.T) {
+	exported := make(chan []byte, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer otlp" {
+			http.Error(w, "bad export", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		exported <- body
+	}))
+	defer collector.Close()
+	var agentTraceparent string
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agentTraceparent = r.Header.Get(traceparentHeader)
+		w.Write([]byte(`{"token":"t"}`))
+	}))
+	defer agent.Close()
+
+	saved := config
+	t.Cleanup(func() { config, tracer = saved, nil })
+	config.OTLPEndpoint = collector.URL
+	config.OTLPHeaders = map[string]string{"Authorization": "Bearer otlp"}
+	config.TraceServiceName = "testa-edu-ui"
+	startTracing()
+
+	client := NewAgentClient(agent.URL, "key")
+	client.tokens = nil
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /step/token", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := client.WithContext(r.Context()).GetToken(); err != nil {
+			t.Error(err)
+		}
+	})
+	req := httptest.NewRequest("POST", "/step/token", nil)
+	req.Header.Set(traceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	traceRequests(mux).ServeHTTP(httptest.NewRecorder(), req)
+	stopTracing(context.Background())
+
+	var export struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []otlpSpan
+			}
+		}
+	}
+	if err := json.Unmarshal(<-exported, &export); err != nil {
+		t.Fatal(err)
+	}
+	spans := map[string]otlpSpan{}
+	for _, s := range export.ResourceSpans[0].ScopeSpans[0].Spans {
+		spans[s.Name] = s
+	}
+	server, ok := spans["POST /step/token"]
+	if !ok || server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != spanKindServer {
+		t.Errorf("server span %+v", server)
+	}
+	call, ok := spans["agent POST /agent/token"]
+	if !ok || call.TraceID != server.TraceID || call.ParentSpanID != server.SpanID || call.Kind != spanKindClient {
+		t.Errorf("agent span %+v", call)
+	}
+	if want := "00-" + call.TraceID + "-" + call.SpanID + "-01"; agentTraceparent != want {
+		t.Errorf("agent got traceparent %q, want %q", agentTraceparent, want)
+	}
+	status := false
+	for _, a := range server.Attributes {
+		status = status || a.Key == "http.response.status_code" && a.Value["intValue"] == "200"
+	}
+	if !status {
+		t.Errorf("server span attributes %+v", server.Attributes)
+	}
+}
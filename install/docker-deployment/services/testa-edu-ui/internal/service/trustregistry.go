@@ -0,0 +1,282 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trust registry. TRUST_REGISTRY lists the sources of trusted issuer DIDs,
+// comma-separated: local files and https URLs. A source is either JSON, a
+// list of DIDs or {"issuers": [{"did": ..., "name": ...}]}, or an ETSI TS
+// 119 612 trusted list in XML, the format TRAIN trust frameworks publish,
+// in which every did: identifier under a trust service provider is taken
+// as trusted under the provider's name. Remote sources are refetched every
+// TRUST_REGISTRY_REFRESH; a failed fetch keeps the previous entries.
+//
+// With a registry configured, verification checks the credential's issuer
+// against it and flags unknown issuers even when the signature verifies.
+// This deployment's own issuer DIDs are always trusted.
+
+const maxTrustList = 8 << 20
+
+type TrustedIssuer struct {
+	DID    string `json:"did"`
+	Name   string `json:"name,omitempty"`
+	Source string `json:"source"`
+}
+
+// TrustSource reports the state of one registry source.
+type TrustSource struct {
+	Source   string    `json:"source"`
+	Issuers  int       `json:"issuers"`
+	LoadedAt time.Time `json:"loadedAt,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type TrustRegistry struct {
+	sources []string
+	client  *http.Client
+
+	mu      sync.RWMutex
+	entries map[string][]TrustedIssuer // by source
+	status  map[string]*TrustSource
+}
+
+var trustRegistry *TrustRegistry
+
+// NewTrustRegistry loads the local sources; remote ones are loaded by
+// Refresh. A missing or malformed local file is an error.
+func NewTrustRegistry(sources []string) (*TrustRegistry, error) {
+	t := &TrustRegistry{
+		sources: sources,
+		client:  &http.Client{Timeout: 30 * time.Second},
+		entries: make(map[string][]TrustedIssuer),
+		status:  make(map[string]*TrustSource),
+	}
+	for _, src := range sources {
+		t.status[src] = &TrustSource{Source: src}
+		if isRemoteTrustSource(src) {
+			if !strings.HasPrefix(src, "https://") {
+				return nil, fmt.Errorf("remote source %s must use https", src)
+			}
+			continue
+		}
+		if err := t.load(src); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func isRemoteTrustSource(src string) bool {
+	return strings.HasPrefix(src, "https://") || strings.HasPrefix(src, "http://")
+}
+
+// Enabled reports whether any source is configured.
+func (t *TrustRegistry) Enabled() bool {
+	return len(t.sources) > 0
+}
+
+// Refresh reloads every source, logging failures.
+func (t *TrustRegistry) Refresh() {
+	for _, src := range t.sources {
+		if err := t.load(src); err != nil {
+			slog.Error("trust registry", "err", err)
+		}
+	}
+}
+
+func (t *TrustRegistry) load(src string) error {
+	issuers, err := t.read(src)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	st := t.status[src]
+	if err != nil {
+		st.Error = err.Error()
+		return fmt.Errorf("loading %s: %w", src, err)
+	}
+	t.entries[src] = issuers
+	st.Issuers, st.LoadedAt, st.Error = len(issuers), time.Now().UTC(), ""
+	return nil
+}
+
+func (t *TrustRegistry) read(src string) ([]TrustedIssuer, error) {
+	var data []byte
+	if isRemoteTrustSource(src) {
+		resp, err := t.client.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, maxTrustList)); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(src); err != nil {
+			return nil, err
+		}
+	}
+	issuers, err := parseTrustList(data)
+	if err != nil {
+		return nil, err
+	}
+	for i := range issuers {
+		issuers[i].Source = src
+	}
+	return issuers, nil
+}
+
+// parseTrustList reads a JSON registry or an ETSI trusted list.
+func parseTrustList(data []byte) ([]TrustedIssuer, error) {
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("<")) {
+		return parseETSITrustList(data)
+	}
+	var dids []string
+	if json.Unmarshal(data, &dids) == nil {
+		issuers := make([]TrustedIssuer, 0, len(dids))
+		for _, did := range dids {
+			issuers = append(issuers, TrustedIssuer{DID: did})
+		}
+		return validTrustedIssuers(issuers)
+	}
+	var doc struct {
+		Issuers []TrustedIssuer `json:"issuers"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing trust list: %w", err)
+	}
+	return validTrustedIssuers(doc.Issuers)
+}
+
+func validTrustedIssuers(issuers []TrustedIssuer) ([]TrustedIssuer, error) {
+	for _, iss := range issuers {
+		if !strings.HasPrefix(iss.DID, "did:") {
+			return nil, fmt.Errorf("trust list entry %q is not a DID", iss.DID)
+		}
+	}
+	return issuers, nil
+}
+
+// parseETSITrustList collects the DIDs in a TS 119 612 trusted list, named
+// after the trust service provider they are listed under.
+func parseETSITrustList(data []byte) ([]TrustedIssuer, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var issuers []TrustedIssuer
+	var path []string
+	provider := ""
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing trusted list: %w", err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+			if tok.Name.Local == "TrustServiceProvider" {
+				provider = ""
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+		case xml.CharData:
+			text := strings.TrimSpace(string(tok))
+			n := len(path)
+			switch {
+			case n >= 2 && path[n-2] == "TSPName" && provider == "":
+				provider = text
+			case strings.HasPrefix(text, "did:"):
+				issuers = append(issuers, TrustedIssuer{DID: text, Name: provider})
+			}
+		}
+	}
+	return issuers, nil
+}
+
+// Lookup returns the registry entry for an issuer DID. Key references
+// ("did:example:123#key-1") match their DID.
+func (t *TrustRegistry) Lookup(did string) (TrustedIssuer, bool) {
+	did, _, _ = strings.Cut(did, "#")
+	if did == config.IssuerDID {
+		return TrustedIssuer{DID: did, Name: "this issuer", Source: "ISSUER_DID"}, true
+	}
+	if iss, ok := ownIssuer(did); ok {
+		return TrustedIssuer{DID: did, Name: iss.Name, Source: "ISSUER_DIDS"}, true
+	}
+	if _, ok := config.ProofTypes[did]; ok {
+		return TrustedIssuer{DID: did, Name: "this issuer", Source: "PROOF_TYPES"}, true
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for _, src := range t.sources {
+		for _, iss := range t.entries[src] {
+			if iss.DID == did {
+				return iss, true
+			}
+		}
+	}
+	return TrustedIssuer{}, false
+}
+
+// startTrustRegistry loads the remote sources now and every
+// TRUST_REGISTRY_REFRESH.
+func startTrustRegistry() {
+	if !trustRegistry.Enabled() {
+		return
+	}
+	go func() {
+		for {
+			trustRegistry.Refresh()
+			time.Sleep(config.TrustRegistryRefresh)
+		}
+	}()
+}
+
+// checkTrust records whether the issuer is in the trust registry.
+func (r *ScanResult) checkTrust(issuer string) {
+	switch iss, ok := trustRegistry.Lookup(issuer); {
+	case !trustRegistry.Enabled():
+		r.check("trust", CheckSkipped, "no trust registry configured")
+	case issuer == "":
+		r.check("trust", CheckFailed, "the credential names no issuer")
+	case ok && iss.Name != "":
+		r.check("trust", CheckPassed, "trusted issuer: "+iss.Name)
+	case ok:
+		r.check("trust", CheckPassed, "trusted issuer")
+	default:
+		r.check("trust", CheckFailed, "issuer "+issuer+" is not in the trust registry")
+	}
+}
+
+// handleTrustRegistry lists the registry's sources and trusted issuers.
+func handleTrustRegistry(w http.ResponseWriter, r *http.Request) {
+	trustRegistry.mu.RLock()
+	out := struct {
+		Sources []TrustSource   `json:"sources"`
+		Issuers []TrustedIssuer `json:"issuers"`
+	}{Sources: []TrustSource{}, Issuers: []TrustedIssuer{}}
+	for _, src := range trustRegistry.sources {
+		out.Sources = append(out.Sources, *trustRegistry.status[src])
+		out.Issuers = append(out.Issuers, trustRegistry.entries[src]...)
+	}
+	trustRegistry.mu.RUnlock()
+	sort.SliceStable(out.Issuers, func(i, j int) bool { return out.Issuers[i].DID < out.Issuers[j].DID })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
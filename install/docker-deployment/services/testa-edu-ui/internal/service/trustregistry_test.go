@@ -0,0 +1,140 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testETSITrustList = `<?xml version="1.0" encoding="UTF-8"?>
+<TrustServiceStatusList>
+  <TrustServiceProviderList>
+    <TrustServiceProvider>
+      <TSPInformation>
+        <TSPName><Name xml:lang="en">Kenya National Qualifications Authority</Name></TSPName>
+      </TSPInformation>
+      <TSPServices><TSPService><ServiceInformation>
+        <ServiceDigitalIdentity><DigitalId><Other>did:web:knqa.go.ke</Other></DigitalId></ServiceDigitalIdentity>
+      </ServiceInformation></TSPService></TSPServices>
+    </TrustServiceProvider>
+  </TrustServiceProviderList>
+</TrustServiceStatusList>`
+
+// useTrustRegistry gives a test its own issuer and tenants, and restores
+// the registry afterwards.
+func useTrustRegistry(t *testing.T) {
+	t.Helper()
+	saved, savedRegistry, savedTenants := config, trustRegistry, tenants
+	t.Cleanup(func() { config, trustRegistry, tenants = saved, savedRegistry, savedTenants })
+	config.IssuerDID, config.IssuerDIDs, config.ProofTypes = "did:web:testa.example", nil, nil
+	tenants, _ = NewTenantStore(t.TempDir())
+}
+
+// trustCheck runs checkTrust and returns the status and detail it records.
+func trustCheck(issuer string) (string, string) {
+	var r ScanResult
+	r.checkTrust(issuer)
+	return r.Checks[0].Status, r.Checks[0].Detail
+}
+
+// TestTrustRegistry checks issuers in JSON and ETSI lists are trusted under
+// their names, others are flagged, and this deployment's issuer is always
+// trusted.
+func TestTrustRegistry(t *testing.T) {
+	useTrustRegistry(t)
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "dids.json")
+	named := filepath.Join(dir, "issuers.json")
+	etsi := filepath.Join(dir, "trusted-list.xml")
+	os.WriteFile(plain, []byte(`["did:web:uonbi.ac.ke"]`), 0o644)
+	os.WriteFile(named, []byte(`{"issuers": [{"did": "did:web:ku.ac.ke", "name": "Kenyatta University"}]}`), 0o644)
+	os.WriteFile(etsi, []byte(testETSITrustList), 0o644)
+
+	var err error
+	if trustRegistry, err = NewTrustRegistry([]string{plain, named, etsi}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		issuer, status, detail string
+	}{
+		{"did:web:uonbi.ac.ke", CheckPassed, "trusted issuer"},
+		{"did:web:ku.ac.ke#key-1", CheckPassed, "trusted issuer: Kenyatta University"},
+		{"did:web:knqa.go.ke", CheckPassed, "trusted issuer: Kenya National Qualifications Authority"},
+		{"did:web:testa.example", CheckPassed, "trusted issuer: this issuer"},
+		{"did:web:diploma-mill.example", CheckFailed, "issuer did:web:diploma-mill.example is not in the trust registry"},
+		{"", CheckFailed, "the credential names no issuer"},
+	}
+	for _, tt := range tests {
+		if status, detail := trustCheck(tt.issuer); status != tt.status || detail != tt.detail {
+			t.Errorf("%q: %s %q, want %s %q", tt.issuer, status, detail, tt.status, tt.detail)
+		}
+	}
+
+	trustRegistry, _ = NewTrustRegistry(nil)
+	if status, _ := trustCheck("did:web:diploma-mill.example"); status != CheckSkipped {
+		t.Errorf("without a registry: %s, want %s", status, CheckSkipped)
+	}
+}
+
+// TestTrustRegistryUnreachable checks a remote source that stops answering
+// keeps its last entries and reports the error, and that bad sources are
+// refused at startup.
+func TestTrustRegistryUnreachable(t *testing.T) {
+	useTrustRegistry(t)
+	var down atomic.Bool
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`["did:web:uonbi.ac.ke"]`))
+	}))
+	defer srv.Close()
+
+	var err error
+	if trustRegistry, err = NewTrustRegistry([]string{srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	trustRegistry.client = srv.Client()
+	if status, _ := trustCheck("did:web:uonbi.ac.ke"); status != CheckFailed {
+		t.Errorf("before the first fetch: %s, want %s", status, CheckFailed)
+	}
+	trustRegistry.Refresh()
+	if status, _ := trustCheck("did:web:uonbi.ac.ke"); status != CheckPassed {
+		t.Fatalf("after fetching: %s, want %s", status, CheckPassed)
+	}
+
+	down.Store(true)
+	trustRegistry.Refresh()
+	if status, _ := trustCheck("did:web:uonbi.ac.ke"); status != CheckPassed {
+		t.Errorf("with the source down: %s, want the previous entries kept", status)
+	}
+	if st := trustRegistry.status[srv.URL]; st.Error != "HTTP 503" || st.Issuers != 1 {
+		t.Errorf("source status %+v", st)
+	}
+
+	srv.Close()
+	trustRegistry.Refresh()
+	if st := trustRegistry.status[srv.URL]; st.Error == "" || st.Error == "HTTP 503" {
+		t.Errorf("with the source unreachable: %+v", st)
+	}
+
+	for _, sources := range [][]string{
+		{"http://registry.example/trust.json"},
+		{filepath.Join(t.TempDir(), "missing.json")},
+	} {
+		if _, err := NewTrustRegistry(sources); err == nil {
+			t.Errorf("%v accepted", sources)
+		}
+	}
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(bad, []byte(`["uonbi.ac.ke"]`), 0o644)
+	if _, err := NewTrustRegistry([]string{bad}); err == nil || !strings.Contains(err.Error(), "not a DID") {
+		t.Errorf("an entry that is not a DID: %v", err)
+	}
+}
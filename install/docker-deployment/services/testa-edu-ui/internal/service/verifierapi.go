@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verifier REST API. POST /api/v1/verify takes a credential (JSON-LD
+// object, JWT, SD-JWT, JSON-XT URI or QR text) and returns a structured
+// report of the checks run on it and of the verifier's acceptance policy
+// (see policy.go). Callers authenticate with verifier API
+// keys, which only grant verification: they are separate from the staff
+// token and from the agent's issuer key. Staff issue and revoke keys under
+// /api/staff/verifier-keys; a key is shown once, at creation, and only its
+// hash is kept in DATA_DIR/verifier-keys.json.
+
+const verifierKeyPrefix = "tvk_"
+
+type VerifierKey struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	KeyHash    string    `json:"keyHash,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  time.Time `json:"revokedAt,omitempty"`
+
+	Policy *VerificationPolicy `json:"policy,omitempty"`
+}
+
+type VerifierKeyStore struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*VerifierKey
+}
+
+var verifierKeys *VerifierKeyStore
+
+func NewVerifierKeyStore(dataDir string) (*VerifierKeyStore, error) {
+	s := &VerifierKeyStore{
+		path:  filepath.Join(dataDir, "verifier-keys.json"),
+		items: make(map[string]*VerifierKey),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing verifier keys: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading verifier keys: %w", err)
+	}
+	return s, nil
+}
+
+func (s *VerifierKeyStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing verifier keys: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func hashVerifierKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create issues a key for the named verifier and returns it with its secret.
+func (s *VerifierKeyStore) Create(name string) (VerifierKey, string, error) {
+	id := make([]byte, 8)
+	rand.Read(id)
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	key := verifierKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+	k := &VerifierKey{
+		ID:        hex.EncodeToString(id),
+		Name:      name,
+		KeyHash:   hashVerifierKey(key),
+		CreatedAt: time.Now().UTC(),
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[k.ID] = k
+	return k.public(), key, s.saveLocked()
+}
+
+func (k *VerifierKey) public() VerifierKey {
+	c := *k
+	c.KeyHash = ""
+	return c
+}
+
+// Authenticate returns the live key matching the presented secret.
+func (s *VerifierKeyStore) Authenticate(key string) (VerifierKey, bool) {
+	if !strings.HasPrefix(key, verifierKeyPrefix) {
+		return VerifierKey{}, false
+	}
+	hash := hashVerifierKey(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, k := range s.items {
+		if k.KeyHash == hash && k.RevokedAt.IsZero() {
+			// Record use at most hourly to keep writes down.
+			if now := time.Now().UTC(); now.Sub(k.LastUsedAt) > time.Hour {
+				k.LastUsedAt = now
+				if err := s.saveLocked(); err != nil {
+					slog.Error("verifier key", "verifier_key", k.ID, "err", err)
+				}
+			}
+			return k.public(), true
+		}
+	}
+	return VerifierKey{}, false
+}
+
+// Revoke disables a key.
+func (s *VerifierKeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.items[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if k.RevokedAt.IsZero() {
+		k.RevokedAt = time.Now().UTC()
+	}
+	return s.saveLocked()
+}
+
+// SetPolicy saves a verifier's acceptance policy; an empty one clears it.
+func (s *VerifierKeyStore) SetPolicy(id string, p *VerificationPolicy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.items[id]
+	if !ok {
+		return os.ErrNotExist
+	}
+	if p.empty() {
+		p = nil
+	}
+	k.Policy = p
+	return s.saveLocked()
+}
+
+// List returns every key, oldest first, without hashes.
+func (s *VerifierKeyStore) List() []VerifierKey {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]VerifierKey, 0, len(s.items))
+	for _, k := range s.items {
+		out = append(out, k.public())
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+type verifierContextKey struct{}
+
+// requireVerifier checks a verifier API key ("Authorization: Bearer tvk_...")
+// and passes the verifier on in the request context.
+func requireVerifier(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, key, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		var k VerifierKey
+		ok := strings.EqualFold(scheme, "Bearer")
+		if ok {
+			k, ok = verifierKeys.Authenticate(key)
+		}
+		if !ok {
+			slog.WarnContext(r.Context(), "verifier API: unauthorized", "method", r.Method, "path", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="verifier"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), verifierContextKey{}, k)))
+	}
+}
+
+// requestVerifier returns the verifier authenticated for the request.
+func requestVerifier(r *http.Request) (VerifierKey, bool) {
+	k, ok := r.Context().Value(verifierContextKey{}).(VerifierKey)
+	return k, ok
+}
+
+// VerificationReport is the /api/v1/verify response. Accepted is Verified
+// further subject to the verifier's policy, when there is one.
+type VerificationReport struct {
+	Verified bool                `json:"verified"`
+	Accepted bool                `json:"accepted"`
+	Policy   *PolicyResult       `json:"policy,omitempty"`
+	Format   string              `json:"format"`
+	QRMode   string              `json:"qrMode,omitempty"`
+	Issuer   string              `json:"issuer,omitempty"`
+	Checks   []VerificationCheck `json:"checks"`
+	Subject  map[string]string   `json:"subject,omitempty"`
+	Anchor   *AnchorProof        `json:"anchor,omitempty"`
+	Agent    json.RawMessage     `json:"agent,omitempty"` // the agent's own verification response
+	Checked  time.Time           `json:"checkedAt"`
+}
+
+func verificationReport(result *ScanResult) VerificationReport {
+	report := VerificationReport{
+		Verified: result.Verified,
+		Accepted: result.Verified,
+		Format:   result.Format,
+		QRMode:   result.Mode,
+		Issuer:   result.Issuer,
+		Checks:   result.Checks,
+		Anchor:   result.Anchor,
+		Checked:  time.Now().UTC(),
+	}
+	if json.Valid([]byte(result.Message)) {
+		report.Agent = json.RawMessage(result.Message)
+	}
+	subject := map[string]string{
+		"studentName":    result.Subject.StudentName,
+		"institution":    result.Subject.Institution,
+		"degree":         result.Subject.Degree,
+		"fieldOfStudy":   result.Subject.FieldOfStudy,
+		"graduationDate": result.Subject.GraduationDate,
+	}
+	for k, v := range subject {
+		if v == "" {
+			delete(subject, k)
+		}
+	}
+	if len(subject) > 0 {
+		report.Subject = subject
+	}
+	return report
+}
+
+// handleVerifyAPI verifies the credential in the JSON body's "credential"
+// member: a JSON-LD object, or a string holding any other form. A "policy"
+// member applies instead of the verifier's saved policy.
+func handleVerifyAPI(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Credential json.RawMessage     `json:"credential"`
+		Policy     *VerificationPolicy `json:"policy"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxScanData)).Decode(&req); err != nil || req.Credential == nil {
+		http.Error(w, "expected JSON body with credential", http.StatusBadRequest)
+		return
+	}
+	if req.Policy != nil {
+		if err := req.Policy.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	text := string(req.Credential)
+	var s string
+	if json.Unmarshal(req.Credential, &s) == nil {
+		text = s
+	}
+
+	k, _ := requestVerifier(r)
+	policy := req.Policy
+	if policy == nil {
+		policy = k.Policy
+	}
+	sc, err := decodeCredentialText(text)
+	if err != nil {
+		recordVerification(VerifyChannelAPI, k.Name, nil, nil, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, err := verifyScanned(r.Context(), sc)
+	recordVerification(VerifyChannelAPI, k.Name, sc, result, err)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "verifier API error", "err", err)
+		http.Error(w, "verification failed: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	report := verificationReport(result)
+	if policy != nil && !policy.empty() {
+		report.Policy = evaluatePolicy(policy, sc, result)
+		report.Accepted = report.Verified && report.Policy.Accepted
+	}
+	slog.InfoContext(r.Context(), "verifier API: credential verified", "verifier_key", k.ID, "format", result.Format, "verified", report.Verified, "accepted", report.Accepted)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(report)
+}
+
+func handleVerifierKeyCreate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Name) == "" {
+		http.Error(w, "expected JSON body with name", http.StatusBadRequest)
+		return
+	}
+	k, key, err := verifierKeys.Create(strings.TrimSpace(req.Name))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "verifier key error", "err", err)
+		http.Error(w, "Failed to create key", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: verifier key created", "verifier_key", k.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		VerifierKey
+		Key string `json:"key"`
+	}{k, key})
+}
+
+func handleVerifierKeyList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verifierKeys.List())
+}
+
+func handleVerifierKeyRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := verifierKeys.Revoke(r.PathValue("id")); err != nil {
+		http.Error(w, "Unknown key", http.StatusNotFound)
+		return
+	}
+	slog.InfoContext(r.Context(), "staff API: verifier key revoked", "verifier_key", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
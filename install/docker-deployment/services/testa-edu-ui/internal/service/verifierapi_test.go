@@ -0,0 +1,112 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+// TestVerifyAPI checks /api/v1/verify reports valid, tampered, revoked and
+// malformed credentials, and admits only live verifier keys.
+func TestVerifyAPI(t *testing.T) {
+	srv := startE2EServer(t, mockagent.New().Start(t), "", e2eIssuerDID)
+	client := e2eClient(t)
+	e2eStep(t, client, srv.URL+"/issue", url.Values{
+		"studentName":    {"Jane Wanjiku"},
+		"institution":    {"Testa University"},
+		"degree":         {"Bachelor of Science"},
+		"fieldOfStudy":   {"Computer Science"},
+		"enrollmentDate": {"2021-09-01"},
+		"graduationDate": {"2025-07-15"},
+		"consent_issue":  {"on"},
+		"format":         {FormatLDP},
+	}, "")
+	e2eStep(t, client, srv.URL+"/step/token", nil, "step-success")
+	e2eStep(t, client, srv.URL+"/step/sign", nil, "step-success")
+	sess := e2eSession(t, client, srv.URL)
+	valid := string(sess.SignedCredential)
+	// Record it as issued here, as the QR step does with the student's consent.
+	stored := &StoredCredential{Format: FormatLDP, Credential: sess.SignedCredential, IssuedAt: time.Now().UTC()}
+	if err := store.Put(stored); err != nil {
+		t.Fatal(err)
+	}
+	var cred map[string]interface{}
+	json.Unmarshal(sess.SignedCredential, &cred)
+	cred["credentialSubject"].(map[string]interface{})["degree"] = "Doctor of Medicine"
+	tampered, _ := json.Marshal(cred)
+
+	key, secret, err := verifierKeys.Create("Acme Recruiting")
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(key, body string) (int, VerificationReport, string) {
+		t.Helper()
+		req, _ := http.NewRequest("POST", srv.URL+"/api/v1/verify", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		out, _ := io.ReadAll(resp.Body)
+		var report VerificationReport
+		json.Unmarshal(out, &report)
+		return resp.StatusCode, report, string(out)
+	}
+	checked := func(report VerificationReport, name string) string {
+		for _, c := range report.Checks {
+			if c.Check == name {
+				return c.Status
+			}
+		}
+		return ""
+	}
+
+	status, report, body := verify(secret, `{"credential":`+valid+`}`)
+	if status != http.StatusOK || !report.Verified || !report.Accepted || checked(report, "signature") != CheckPassed || checked(report, "status") != CheckPassed {
+		t.Errorf("valid: HTTP %d: %s", status, body)
+	}
+	if report.Issuer != e2eIssuerDID || report.Subject["studentName"] != "Jane Wanjiku" {
+		t.Errorf("valid: issuer %q, subject %v", report.Issuer, report.Subject)
+	}
+
+	status, report, body = verify(secret, `{"credential":`+string(tampered)+`}`)
+	if status != http.StatusOK || report.Verified || report.Accepted || checked(report, "signature") != CheckFailed {
+		t.Errorf("tampered: HTTP %d: %s", status, body)
+	}
+
+	if err := store.SetStatus(stored.ID, CredentialRevoked, "issued in error"); err != nil {
+		t.Fatal(err)
+	}
+	status, report, body = verify(secret, `{"credential":`+valid+`}`)
+	if status != http.StatusOK || report.Verified || checked(report, "signature") != CheckPassed || checked(report, "status") != CheckFailed {
+		t.Errorf("revoked: HTTP %d: %s", status, body)
+	}
+
+	for name, body := range map[string]string{
+		"not JSON":         `credential`,
+		"no credential":    `{}`,
+		"not a credential": `{"credential":"hello"}`,
+		"invalid policy":   `{"credential":` + valid + `,"policy":{"maxAge":"soon"}}`,
+	} {
+		if status, _, out := verify(secret, body); status != http.StatusBadRequest {
+			t.Errorf("%s: HTTP %d: %s", name, status, out)
+		}
+	}
+
+	if status, _, _ := verify("staff-token-0123456789", `{"credential":`+valid+`}`); status != http.StatusUnauthorized {
+		t.Errorf("a non-verifier key: HTTP %d", status)
+	}
+	if err := verifierKeys.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ := verify(secret, `{"credential":`+valid+`}`); status != http.StatusUnauthorized {
+		t.Errorf("a revoked key: HTTP %d", status)
+	}
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Verification log. Every verification run through the service (the scan
+// page, a certificate's verification URL, the embedded widget and the
+// verifier API) is recorded in DATA_DIR/verifications.json with the
+// channel, the verifier when known (the API key, or the page embedding the
+// widget), the result and, when the credential was issued and stored here,
+// its ID. Scanned credentials are matched to stored ones by their
+// signature, which survives the QR encodings. Staff list events at
+// /api/staff/verifications and get counts per institution, credential,
+// verifier, channel and day from /api/staff/verifications/report, so
+// institutions can see how often their credentials are checked.
+//
+// Events hold no student data beyond the subject DID of a matched
+// credential, kept so the events can be erased with the subject.
+
+const (
+	VerifyChannelScan        = "scan"
+	VerifyChannelCertificate = "certificate"
+	VerifyChannelEmbed       = "embed"
+	VerifyChannelAPI         = "api"
+)
+
+const (
+	VerifyResultVerified = "verified"
+	VerifyResultFailed   = "failed"
+	VerifyResultError    = "error"
+)
+
+type VerificationEvent struct {
+	ID           string    `json:"id"`
+	At           time.Time `json:"at"`
+	Channel      string    `json:"channel"`
+	Verifier     string    `json:"verifier,omitempty"`
+	CredentialID string    `json:"credentialId,omitempty"`
+	SubjectID    string    `json:"subjectId,omitempty"`
+	Institution  string    `json:"institution,omitempty"`
+	Issuer       string    `json:"issuer,omitempty"`
+	Format       string    `json:"format,omitempty"`
+	Result       string    `json:"result"`
+	Problems     []string  `json:"problems,omitempty"`
+}
+
+type VerificationLog struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*VerificationEvent
+}
+
+var verifications *VerificationLog
+
+func NewVerificationLog(dataDir string) (*VerificationLog, error) {
+	s := &VerificationLog{
+		path:  filepath.Join(dataDir, "verifications.json"),
+		items: make(map[string]*VerificationEvent),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing verification log: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading verification log: %w", err)
+	}
+	return s, nil
+}
+
+func (s *VerificationLog) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing verification log: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Add records an event.
+func (s *VerificationLog) Add(e *VerificationEvent) error {
+	b := make([]byte, 12)
+	rand.Read(b)
+	e.ID = hex.EncodeToString(b)
+	e.At = time.Now().UTC()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[e.ID] = e
+	return s.saveLocked()
+}
+
+// verificationFilter selects events for listing and reports.
+type verificationFilter struct {
+	From, To     time.Time
+	Institution  string
+	CredentialID string
+	Verifier     string
+}
+
+func parseVerificationFilter(r *http.Request) (verificationFilter, error) {
+	q := r.URL.Query()
+	f := verificationFilter{
+		Institution:  q.Get("institution"),
+		CredentialID: q.Get("credentialId"),
+		Verifier:     q.Get("verifier"),
+	}
+	for name, t := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		v := q.Get(name)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			if parsed, err = time.Parse("2006-01-02", v); err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 time or a date", name)
+			}
+		}
+		*t = parsed
+	}
+	return f, nil
+}
+
+func (f verificationFilter) match(e *VerificationEvent) bool {
+	return (f.From.IsZero() || !e.At.Before(f.From)) &&
+		(f.To.IsZero() || e.At.Before(f.To)) &&
+		(f.Institution == "" || strings.EqualFold(e.Institution, f.Institution)) &&
+		(f.CredentialID == "" || e.CredentialID == f.CredentialID) &&
+		(f.Verifier == "" || e.Verifier == f.Verifier)
+}
+
+// List returns the matching events, newest first.
+func (s *VerificationLog) List(f verificationFilter) []VerificationEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := []VerificationEvent{}
+	for _, e := range s.items {
+		if f.match(e) {
+			out = append(out, *e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].At.After(out[j].At) })
+	return out
+}
+
+// VerificationStats counts verification events.
+type VerificationStats struct {
+	Total         int            `json:"total"`
+	ByResult      map[string]int `json:"byResult"`
+	ByChannel     map[string]int `json:"byChannel"`
+	ByInstitution map[string]int `json:"byInstitution"`
+	ByCredential  map[string]int `json:"byCredential"`
+	ByVerifier    map[string]int `json:"byVerifier"`
+	ByDay         map[string]int `json:"byDay"`
+}
+
+func (s *VerificationLog) Report(f verificationFilter) VerificationStats {
+	stats := VerificationStats{
+		ByResult:      map[string]int{},
+		ByChannel:     map[string]int{},
+		ByInstitution: map[string]int{},
+		ByCredential:  map[string]int{},
+		ByVerifier:    map[string]int{},
+		ByDay:         map[string]int{},
+	}
+	for _, e := range s.List(f) {
+		stats.Total++
+		stats.ByResult[e.Result]++
+		stats.ByChannel[e.Channel]++
+		stats.ByDay[e.At.Format("2006-01-02")]++
+		if e.Institution != "" {
+			stats.ByInstitution[e.Institution]++
+		}
+		if e.CredentialID != "" {
+			stats.ByCredential[e.CredentialID]++
+		}
+		if e.Verifier != "" {
+			stats.ByVerifier[e.Verifier]++
+		}
+	}
+	return stats
+}
+
+// EraseSubject deletes the events for subjectID's credentials.
+func (s *VerificationLog) EraseSubject(subjectID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, e := range s.items {
+		if e.SubjectID == subjectID {
+			delete(s.items, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// Expire deletes events recorded before cutoff.
+func (s *VerificationLog) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, e := range s.items {
+		if !e.At.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			delete(s.items, id)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+// credentialFingerprint identifies a credential by its signature: the
+// proof value of a JSON-LD credential, or the signature of a JWT or of an
+// SD-JWT's issuer JWT. It is empty when there is none to go by.
+func credentialFingerprint(credential json.RawMessage) string {
+	var sig string
+	var compact string
+	if json.Unmarshal(credential, &compact) == nil {
+		jwt, _, _ := strings.Cut(compact, "~")
+		if parts := strings.Split(jwt, "."); len(parts) == 3 {
+			sig = parts[2]
+		}
+	} else {
+		var vc struct {
+			Proof json.RawMessage `json:"proof"`
+		}
+		json.Unmarshal(credential, &vc)
+		var proofs []map[string]interface{}
+		if json.Unmarshal(vc.Proof, &proofs) != nil {
+			var proof map[string]interface{}
+			json.Unmarshal(vc.Proof, &proof)
+			proofs = append(proofs, proof)
+		}
+		for _, p := range proofs {
+			for _, k := range []string{"proofValue", "jws", "signatureValue"} {
+				if v, ok := p[k].(string); ok && sig == "" {
+					sig = v
+				}
+			}
+		}
+	}
+	if sig == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(sig))
+	return hex.EncodeToString(sum[:16])
+}
+
+// recordVerification logs a verification. sc is nil when the input could
+// not be decoded and result is nil when verification failed; err says why.
+func recordVerification(channel, verifier string, sc *scannedCredential, result *ScanResult, err error) {
+	e := &VerificationEvent{Channel: channel, Verifier: verifier, Result: VerifyResultError}
+	if err != nil {
+		e.Problems = []string{err.Error()}
+	}
+	if sc != nil {
+		e.Format = sc.Format
+		if cred, ok := storedCredentialFor(sc); ok {
+			e.CredentialID, e.SubjectID = cred.ID, cred.SubjectID
+		}
+	}
+	if result != nil {
+		e.Result = VerifyResultFailed
+		if result.Verified {
+			e.Result = VerifyResultVerified
+		}
+		e.Problems = result.Problems
+		e.Issuer = result.Issuer
+		e.Institution = result.Subject.Institution
+	}
+	if err := verifications.Add(e); err != nil {
+		slog.Error("verification log", "err", err)
+	}
+}
+
+func handleVerificationList(w http.ResponseWriter, r *http.Request) {
+	f, err := parseVerificationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verifications.List(f))
+}
+
+func handleVerificationReport(w http.ResponseWriter, r *http.Request) {
+	f, err := parseVerificationFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verifications.Report(f))
+}
@@ -0,0 +1,514 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Employer verification requests. An employer asks to verify a named
+// student's degree, identifying the student by email address or DID. The
+// student is emailed (when they were named by address) and sees the
+// request in the student portal, where they approve it with a credential
+// of their choice, revealing only the fields they pick, or decline it.
+// Only on approval is a presentation released, sealed at rest like the
+// cloud wallet and handed only to whoever holds the request's token. The
+// employer follows the request at its status link. Pending
+// requests expire after VERIFY_REQUEST_TTL; released presentations stay
+// available for SHARE_LINK_TTL. Records are kept in
+// DATA_DIR/verification-requests.json and listed for staff at
+// /api/staff/verification-requests.
+
+const (
+	VerifyRequestPending  = "pending"
+	VerifyRequestApproved = "approved"
+	VerifyRequestDeclined = "declined"
+	VerifyRequestExpired  = "expired"
+)
+
+type VerificationRequest struct {
+	ID            string `json:"id"`
+	Employer      string `json:"employer"`
+	EmployerEmail string `json:"employerEmail,omitempty"`
+	Purpose       string `json:"purpose"`
+	Requested     string `json:"requested,omitempty"` // e.g. the degree to verify
+
+	// The student, by email lookup hash or DID. SubjectID is the subject
+	// of the credential released on approval.
+	StudentEmailHash string `json:"studentEmailHash,omitempty"`
+	StudentDID       string `json:"studentDid,omitempty"`
+	SubjectID        string `json:"subjectId,omitempty"`
+
+	TokenHash string    `json:"tokenHash,omitempty"`
+	Status    string    `json:"status"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	DecidedAt time.Time `json:"decidedAt,omitempty"`
+
+	CredentialID string    `json:"credentialId,omitempty"`
+	ContentType  string    `json:"contentType,omitempty"`
+	Sealed       []byte    `json:"sealed,omitempty"`
+	HolderSealed bool      `json:"holderSealed,omitempty"` // under HOLDER_KEY
+	ReleasedTill time.Time `json:"releasedTill,omitempty"`
+}
+
+// matches reports whether a signed-in student is the one asked.
+func (v *VerificationRequest) matches(sess *portalSession) bool {
+	if sess.subjectDID != "" {
+		return v.StudentDID == sess.subjectDID
+	}
+	return v.StudentEmailHash != "" && v.StudentEmailHash == sess.emailHash
+}
+
+type VerificationRequestStore struct {
+	path string
+
+	mu    sync.Mutex
+	items map[string]*VerificationRequest
+}
+
+var verifyRequests *VerificationRequestStore
+
+// verifyRequestLimiter caps the requests one client can file an hour, so
+// the form cannot be used to mail-bomb students.
+var verifyRequestLimiter = newRateLimiter(10, time.Hour)
+
+func NewVerificationRequestStore(dataDir string) (*VerificationRequestStore, error) {
+	s := &VerificationRequestStore{
+		path:  filepath.Join(dataDir, "verification-requests.json"),
+		items: make(map[string]*VerificationRequest),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, &s.items); err != nil {
+			return nil, fmt.Errorf("parsing verification requests: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading verification requests: %w", err)
+	}
+	return s, nil
+}
+
+func (s *VerificationRequestStore) saveLocked() error {
+	data, err := json.Marshal(s.items)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing verification requests: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func hashRequestToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// expireLocked marks a pending request past its expiry as expired and
+// drops a released presentation past its availability.
+func (v *VerificationRequest) expireLocked(now time.Time) bool {
+	switch {
+	case v.Status == VerifyRequestPending && now.After(v.ExpiresAt):
+		v.Status = VerifyRequestExpired
+		return true
+	case v.Sealed != nil && now.After(v.ReleasedTill):
+		v.Sealed = nil
+		return true
+	}
+	return false
+}
+
+// Create records a request and returns the employer's access token.
+func (s *VerificationRequestStore) Create(v *VerificationRequest, ttl time.Duration) (string, error) {
+	b := make([]byte, 24)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	now := time.Now().UTC()
+	v.ID = newCredentialID()
+	v.TokenHash = hashRequestToken(token)
+	v.Status = VerifyRequestPending
+	v.CreatedAt = now
+	v.ExpiresAt = now.Add(ttl)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[v.ID] = v
+	return token, s.saveLocked()
+}
+
+// Get returns a copy of a request for the employer holding token.
+func (s *VerificationRequestStore) Get(id, token string) (VerificationRequest, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.items[id]
+	if !ok || subtle.ConstantTimeCompare([]byte(v.TokenHash), []byte(hashRequestToken(token))) != 1 {
+		return VerificationRequest{}, false
+	}
+	if v.expireLocked(time.Now()) {
+		s.saveLocked()
+	}
+	return *v, true
+}
+
+// Pending lists the requests awaiting the signed-in student, oldest first.
+func (s *VerificationRequestStore) Pending(sess *portalSession) []VerificationRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var out []VerificationRequest
+	for _, v := range s.items {
+		v.expireLocked(now)
+		if v.Status == VerifyRequestPending && v.matches(sess) {
+			out = append(out, *v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.Before(out[j].CreatedAt) })
+	return out
+}
+
+// Decide approves or declines a pending request addressed to the student.
+// approve fills in the released presentation; nil declines.
+func (s *VerificationRequestStore) Decide(id string, sess *portalSession, approve func(*VerificationRequest) error) (*VerificationRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.items[id]
+	if !ok || !v.matches(sess) {
+		return nil, os.ErrNotExist
+	}
+	v.expireLocked(time.Now())
+	if v.Status != VerifyRequestPending {
+		return nil, fmt.Errorf("this request is already %s", v.Status)
+	}
+	if approve != nil {
+		if err := approve(v); err != nil {
+			return nil, err
+		}
+		v.Status = VerifyRequestApproved
+	} else {
+		v.Status = VerifyRequestDeclined
+	}
+	v.DecidedAt = time.Now().UTC()
+	copy := *v
+	return &copy, s.saveLocked()
+}
+
+// List returns every request, newest first, without sealed content.
+func (s *VerificationRequestStore) List() []VerificationRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var out []VerificationRequest
+	for _, v := range s.items {
+		v.expireLocked(now)
+		c := *v
+		c.Sealed, c.TokenHash, c.StudentEmailHash = nil, "", ""
+		out = append(out, c)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out
+}
+
+// EraseSubject deletes the requests addressed to or answered by subjectID.
+func (s *VerificationRequestStore) EraseSubject(subjectID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, v := range s.items {
+		if v.StudentDID == subjectID || v.SubjectID == subjectID {
+			delete(s.items, id)
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	return n, s.saveLocked()
+}
+
+// Expire deletes requests created before cutoff.
+func (s *VerificationRequestStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, v := range s.items {
+		if !v.CreatedAt.Before(cutoff) {
+			continue
+		}
+		n++
+		if !dryRun {
+			delete(s.items, id)
+		}
+	}
+	if n == 0 || dryRun {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+func verifyRequestURL(id, token string) string {
+	return config.PublicURL + "/verify-requests/" + id + "?token=" + token
+}
+
+func renderVerifyRequestPage(w http.ResponseWriter, r *http.Request, data map[string]interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := pages(r).ExecuteTemplate(w, "verify-request", data); err != nil {
+		slog.ErrorContext(r.Context(), "template error", "err", err)
+		internalError(w, r)
+	}
+}
+
+// notifyVerifyRequest emails the student about a new request.
+func notifyVerifyRequest(v *VerificationRequest, to string) error {
+	rendered, err := renderEmail(EmailVerifyRequest, "", EmailData{
+		Employer:   v.Employer,
+		Purpose:    v.Purpose,
+		Requested:  v.Requested,
+		RequestURL: config.PublicURL + "/portal",
+	})
+	if err != nil {
+		return err
+	}
+	msg, err := buildMail(to, rendered, nil)
+	if err != nil {
+		return err
+	}
+	_, err = queueDelivery(&deliveryJob{
+		channel:     "email",
+		recipient:   to,
+		maxAttempts: config.MailMaxAttempts,
+		send:        func() error { return sendSMTP(to, msg) },
+		transient:   transientMailError,
+	}, "", v.StudentDID)
+	return err
+}
+
+// notifyVerifyDecision tells the employer the student answered.
+func notifyVerifyDecision(v *VerificationRequest) {
+	if v.EmployerEmail == "" || !mailEnabled() {
+		return
+	}
+	rendered, err := renderEmail(EmailVerifyDecision, "", EmailData{
+		Employer:  v.Employer,
+		Purpose:   v.Purpose,
+		Requested: v.Requested,
+		Decision:  v.Status,
+	})
+	if err == nil {
+		var msg []byte
+		if msg, err = buildMail(v.EmployerEmail, rendered, nil); err == nil {
+			_, err = queueDelivery(&deliveryJob{
+				channel:     "email",
+				recipient:   v.EmployerEmail,
+				maxAttempts: config.MailMaxAttempts,
+				send:        func() error { return sendSMTP(v.EmployerEmail, msg) },
+				transient:   transientMailError,
+			}, v.CredentialID, v.SubjectID)
+		}
+	}
+	if err != nil {
+		slog.Error("verification request: employer notification", "verify_request", v.ID, "err", err)
+	}
+}
+
+func handleVerifyRequestForm(w http.ResponseWriter, r *http.Request) {
+	renderVerifyRequestPage(w, r, map[string]interface{}{"New": true, "MailEnabled": mailEnabled()})
+}
+
+// handleVerifyRequestCreate files an employer's request.
+func handleVerifyRequestCreate(w http.ResponseWriter, r *http.Request) {
+	v := &VerificationRequest{
+		Employer:   strings.TrimSpace(r.FormValue("employer")),
+		Purpose:    strings.TrimSpace(r.FormValue("purpose")),
+		Requested:  strings.TrimSpace(r.FormValue("requested")),
+		StudentDID: strings.TrimSpace(r.FormValue("studentDid")),
+	}
+	studentEmail := strings.TrimSpace(r.FormValue("studentEmail"))
+	fail := func(msg string) {
+		renderVerifyRequestPage(w, r, map[string]interface{}{"New": true, "MailEnabled": mailEnabled(), "Error": msg})
+	}
+
+	if v.Employer == "" || v.Purpose == "" {
+		fail("Organisation and purpose are required")
+		return
+	}
+	if e := strings.TrimSpace(r.FormValue("employerEmail")); e != "" {
+		addr, err := mail.ParseAddress(e)
+		if err != nil {
+			fail("Enter a valid contact email address")
+			return
+		}
+		v.EmployerEmail = addr.Address
+	}
+	switch {
+	case studentEmail != "" && v.StudentDID != "":
+		fail("Identify the student by email address or by DID, not both")
+		return
+	case studentEmail != "":
+		if !mailEnabled() {
+			fail("Email is not available here; identify the student by DID")
+			return
+		}
+		addr, err := mail.ParseAddress(studentEmail)
+		if err != nil {
+			fail("Enter a valid student email address")
+			return
+		}
+		studentEmail = strings.ToLower(addr.Address)
+		v.StudentEmailHash = emailLookupHash(studentEmail)
+	case strings.HasPrefix(v.StudentDID, "did:"):
+	default:
+		fail("Identify the student by email address or DID")
+		return
+	}
+	if !verifyRequestLimiter.Allow(clientIP(r)) {
+		fail("Too many requests. Please try again later.")
+		return
+	}
+
+	token, err := verifyRequests.Create(v, liveConfig().VerifyRequestTTL)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "verification request error", "err", err)
+		fail("Failed to file the request")
+		return
+	}
+	slog.InfoContext(r.Context(), "verification request filed", "verify_request", v.ID)
+	if studentEmail != "" {
+		if err := notifyVerifyRequest(v, studentEmail); err != nil {
+			slog.ErrorContext(r.Context(), "verification request: student notification", "verify_request", v.ID, "err", err)
+		}
+	}
+	renderVerifyRequestPage(w, r, map[string]interface{}{
+		"Request":   v,
+		"StatusURL": verifyRequestURL(v.ID, token),
+		"Created":   true,
+	})
+}
+
+// handleVerifyRequestStatus shows the employer their request.
+func handleVerifyRequestStatus(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	v, ok := verifyRequests.Get(r.PathValue("id"), token)
+	if !ok {
+		pageNotFound(w, r)
+		return
+	}
+	data := map[string]interface{}{"Request": v}
+	if v.Sealed != nil {
+		data["PresentationURL"] = config.PublicURL + "/verify-requests/" + v.ID + "/presentation?token=" + token
+	}
+	renderVerifyRequestPage(w, r, data)
+}
+
+// handleVerifyRequestPresentation releases an approved presentation to
+// the employer.
+func handleVerifyRequestPresentation(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	v, ok := verifyRequests.Get(r.PathValue("id"), token)
+	if !ok || v.Sealed == nil {
+		http.Error(w, "No presentation is available for this request.", http.StatusNotFound)
+		return
+	}
+	var body []byte
+	key, err := openKey(v.HolderSealed, "vreq-seal", v.ID)
+	if err == nil {
+		body, err = walletOpen(key, v.Sealed, v.ID)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "verification request", "verify_request", v.ID, "err", err)
+		http.Error(w, "Failed to open the presentation", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "verification request: presentation retrieved", "verify_request", v.ID)
+	w.Header().Set("Content-Type", v.ContentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
+
+// handleVerifyRequestApprove releases a presentation of the chosen
+// credential to the employer.
+func handleVerifyRequestApprove(w http.ResponseWriter, r *http.Request) {
+	sess, ok := portalStudent(r)
+	if !ok {
+		http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+		return
+	}
+	r.ParseForm()
+	cred, ok := store.Get(r.FormValue("credentialId"))
+	if !ok || !sess.owns(cred) {
+		renderPortalError(w, r, sess, "Choose one of your credentials to share")
+		return
+	}
+	reveal := revealedFields(r)
+
+	v, err := verifyRequests.Decide(r.PathValue("id"), sess, func(v *VerificationRequest) error {
+		credential, err := selectivelyDisclose(r.Context(), cred.Format, cred.Credential, reveal)
+		if err != nil {
+			return err
+		}
+		contentType, body, err := buildPresentation(cred.SubjectID, cred.Format, credential, liveConfig().ShareLinkTTL)
+		if err != nil {
+			return err
+		}
+		key, err := sealKey("vreq-seal", v.ID)
+		if err != nil {
+			return err
+		}
+		if v.Sealed, err = walletSeal(key, body, v.ID); err != nil {
+			return err
+		}
+		v.HolderSealed = true
+		v.ContentType = contentType
+		v.CredentialID, v.SubjectID = cred.ID, cred.SubjectID
+		v.ReleasedTill = time.Now().UTC().Add(liveConfig().ShareLinkTTL)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "verification request approval error", "err", err)
+		if errors.Is(err, errNoHolderKey) {
+			renderPortalError(w, r, sess, walletUnavailable)
+			return
+		}
+		renderPortalError(w, r, sess, "Could not approve the request: "+err.Error())
+		return
+	}
+	slog.InfoContext(r.Context(), "verification request approved", "verify_request", v.ID)
+	notifyVerifyDecision(v)
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
+
+func handleVerifyRequestDecline(w http.ResponseWriter, r *http.Request) {
+	sess, ok := portalStudent(r)
+	if !ok {
+		http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+		return
+	}
+	v, err := verifyRequests.Decide(r.PathValue("id"), sess, nil)
+	if err != nil {
+		renderPortalError(w, r, sess, "Could not decline the request: "+err.Error())
+		return
+	}
+	slog.InfoContext(r.Context(), "verification request declined", "verify_request", v.ID)
+	notifyVerifyDecision(v)
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
+
+func handleVerifyRequestList(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verifyRequests.List())
+}
@@ -0,0 +1,648 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cloud wallet. Students without a mobile wallet can keep credentials in a
+// managed wallet tied to their portal sign-in (DID or email address). Each
+// item is sealed with AES-GCM under a key derived from the holder key
+// (HOLDER_KEY) and the holder's identity. The holder key is not kept in
+// DATA_DIR, so wallets.json, even with the rest of the data directory,
+// reveals nothing; without it the cloud wallet is unavailable. Items sealed
+// by earlier versions under the link signing key are resealed when opened.
+// From the wallet a student can create presentation links, /vp/<token>,
+// which serve a Verifiable Presentation of one credential until
+// SHARE_LINK_TTL passes; the presentation is sealed under a key derived
+// from its token. Where the portal minted the student's did:key, the
+// presentation is a vp+jwt signed with that key; otherwise it is an
+// unsigned VP. SD-JWT credentials are presented as the SD-JWT itself. For
+// SD-JWT and BBS+ credentials the student chooses which fields a
+// presentation reveals. Students can export the whole wallet, with their
+// minted keys, to import into a real wallet later.
+
+type WalletItem struct {
+	ID           string    `json:"id"`
+	CredentialID string    `json:"credentialId"`
+	SubjectID    string    `json:"subjectId,omitempty"`
+	Format       string    `json:"format"`
+	AddedAt      time.Time `json:"addedAt"`
+	Sealed       []byte    `json:"sealed"`
+	HolderSealed bool      `json:"holderSealed,omitempty"` // under HOLDER_KEY
+}
+
+// walletEntry is the sealed part of a wallet item.
+type walletEntry struct {
+	Credential  json.RawMessage `json:"credential"`
+	Degree      string          `json:"degree,omitempty"`
+	Institution string          `json:"institution,omitempty"`
+}
+
+// WalletView is a wallet item opened for display.
+type WalletView struct {
+	ID           string
+	CredentialID string
+	Format       string
+	AddedAt      time.Time
+	Degree       string
+	Institution  string
+	Selective
+}
+
+type WalletPresentation struct {
+	WalletID     string    `json:"walletId"`
+	ItemID       string    `json:"itemId"`
+	SubjectID    string    `json:"subjectId,omitempty"`
+	ContentType  string    `json:"contentType"`
+	Sealed       []byte    `json:"sealed"`
+	HolderSealed bool      `json:"holderSealed,omitempty"`
+	CreatedAt    time.Time `json:"createdAt"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+type WalletStore struct {
+	path string
+
+	mu            sync.Mutex
+	Wallets       map[string][]*WalletItem       `json:"wallets"`       // by walletID
+	Presentations map[string]*WalletPresentation `json:"presentations"` // by presentation lookup key
+}
+
+var wallets *WalletStore
+
+func NewWalletStore(dataDir string) (*WalletStore, error) {
+	s := &WalletStore{
+		path:          filepath.Join(dataDir, "wallets.json"),
+		Wallets:       make(map[string][]*WalletItem),
+		Presentations: make(map[string]*WalletPresentation),
+	}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("parsing wallets: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading wallets: %w", err)
+	}
+	return s, nil
+}
+
+func (s *WalletStore) saveLocked() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("writing wallets: %w", err)
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// walletKey derives lookup keys from the link signing key, and the sealing
+// keys of records from before HOLDER_KEY.
+func walletKey(label, value string) []byte {
+	mac := hmac.New(sha256.New, linkKey)
+	mac.Write([]byte(label + "\x00" + value))
+	return mac.Sum(nil)
+}
+
+var errNoHolderKey = errors.New("HOLDER_KEY is not set")
+
+const walletUnavailable = "The cloud wallet is not enabled on this portal"
+
+// sealKey derives a sealing key from the holder key.
+func sealKey(label, value string) ([]byte, error) {
+	if holderKey == nil {
+		return nil, errNoHolderKey
+	}
+	mac := hmac.New(sha256.New, holderKey)
+	mac.Write([]byte(label + "\x00" + value))
+	return mac.Sum(nil), nil
+}
+
+// openKey is the key a record was sealed under.
+func openKey(holderSealed bool, label, value string) ([]byte, error) {
+	if holderSealed {
+		return sealKey(label, value)
+	}
+	return walletKey(label, value), nil
+}
+
+func walletID(holderID string) string {
+	return hex.EncodeToString(walletKey("wallet-id", holderID))
+}
+
+func walletSeal(key []byte, plaintext []byte, ad string) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, []byte(ad)), nil
+}
+
+func walletOpen(key []byte, sealed []byte, ad string) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, fmt.Errorf("corrupt wallet record")
+	}
+	return aead.Open(nil, sealed[:n], sealed[n:], []byte(ad))
+}
+
+// Add seals a stored credential into the holder's wallet. Adding the same
+// credential twice returns the existing item.
+func (s *WalletStore) Add(holderID string, cred *StoredCredential, entry walletEntry) (*WalletItem, error) {
+	wid := walletID(holderID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.Wallets[wid] {
+		if item.CredentialID == cred.ID {
+			return item, nil
+		}
+	}
+	item := &WalletItem{
+		ID:           newCredentialID(),
+		CredentialID: cred.ID,
+		SubjectID:    cred.SubjectID,
+		Format:       cred.Format,
+		AddedAt:      time.Now().UTC(),
+	}
+	plaintext, err := json.Marshal(entry)
+	if err != nil {
+		return nil, err
+	}
+	key, err := sealKey("wallet-seal", holderID)
+	if err != nil {
+		return nil, err
+	}
+	if item.Sealed, err = walletSeal(key, plaintext, item.ID); err != nil {
+		return nil, err
+	}
+	item.HolderSealed = true
+	s.Wallets[wid] = append(s.Wallets[wid], item)
+	return item, s.saveLocked()
+}
+
+func (s *WalletStore) openLocked(holderID string, item *WalletItem) (walletEntry, error) {
+	var entry walletEntry
+	key, err := openKey(item.HolderSealed, "wallet-seal", holderID)
+	if err != nil {
+		return entry, err
+	}
+	plaintext, err := walletOpen(key, item.Sealed, item.ID)
+	if err != nil {
+		return entry, fmt.Errorf("unsealing wallet item: %w", err)
+	}
+	if !item.HolderSealed && holderKey != nil {
+		key, _ := sealKey("wallet-seal", holderID)
+		if sealed, err := walletSeal(key, plaintext, item.ID); err == nil {
+			item.Sealed, item.HolderSealed = sealed, true
+			err = s.saveLocked()
+		}
+		if err != nil {
+			slog.Error("wallet: resealing item", "item", item.ID, "err", err)
+		}
+	}
+	return entry, json.Unmarshal(plaintext, &entry)
+}
+
+// Items opens the holder's wallet, oldest item first.
+func (s *WalletStore) Items(holderID string) ([]WalletView, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []WalletView
+	for _, item := range s.Wallets[walletID(holderID)] {
+		entry, err := s.openLocked(holderID, item)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, WalletView{
+			ID:           item.ID,
+			CredentialID: item.CredentialID,
+			Format:       item.Format,
+			AddedAt:      item.AddedAt,
+			Degree:       entry.Degree,
+			Institution:  entry.Institution,
+			Selective:    selectiveFields(item.Format, entry.Credential),
+		})
+	}
+	return out, nil
+}
+
+// Open returns one of the holder's wallet items and its credential.
+func (s *WalletStore) Open(holderID, itemID string) (*WalletItem, walletEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, item := range s.Wallets[walletID(holderID)] {
+		if item.ID == itemID {
+			entry, err := s.openLocked(holderID, item)
+			return item, entry, err
+		}
+	}
+	return nil, walletEntry{}, os.ErrNotExist
+}
+
+// Remove deletes an item from the holder's wallet with its presentations.
+func (s *WalletStore) Remove(holderID, itemID string) error {
+	wid := walletID(holderID)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := s.Wallets[wid]
+	for i, item := range items {
+		if item.ID != itemID {
+			continue
+		}
+		s.Wallets[wid] = append(items[:i:i], items[i+1:]...)
+		if len(s.Wallets[wid]) == 0 {
+			delete(s.Wallets, wid)
+		}
+		for key, p := range s.Presentations {
+			if p.ItemID == itemID {
+				delete(s.Presentations, key)
+			}
+		}
+		return s.saveLocked()
+	}
+	return os.ErrNotExist
+}
+
+func presentationLookupKey(token string) string {
+	return hex.EncodeToString(walletKey("vp-lookup", token))
+}
+
+// Present creates a presentation link for a wallet item, valid for ttl,
+// revealing only the named fields (all of them if reveal is nil).
+func (s *WalletStore) Present(ctx context.Context, holderID, itemID string, ttl time.Duration, reveal []string) (string, time.Time, error) {
+	item, entry, err := s.Open(holderID, itemID)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	credential, err := selectivelyDisclose(ctx, item.Format, entry.Credential, reveal)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	contentType, body, err := buildPresentation(item.SubjectID, item.Format, credential, ttl)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	b := make([]byte, 24)
+	rand.Read(b)
+	token := base64.RawURLEncoding.EncodeToString(b)
+	key := presentationLookupKey(token)
+	seal, err := sealKey("vp-seal", token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	sealed, err := walletSeal(seal, body, key)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	now := time.Now().UTC()
+	p := &WalletPresentation{
+		WalletID:     walletID(holderID),
+		ItemID:       itemID,
+		SubjectID:    item.SubjectID,
+		ContentType:  contentType,
+		Sealed:       sealed,
+		HolderSealed: true,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(ttl),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Presentations[key] = p
+	return token, p.ExpiresAt, s.saveLocked()
+}
+
+// OpenPresentation returns a presentation by its link token.
+func (s *WalletStore) OpenPresentation(token string) (string, []byte, error) {
+	key := presentationLookupKey(token)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Presentations[key]
+	if !ok {
+		return "", nil, os.ErrNotExist
+	}
+	if time.Now().After(p.ExpiresAt) {
+		delete(s.Presentations, key)
+		s.saveLocked()
+		return "", nil, os.ErrNotExist
+	}
+	seal, err := openKey(p.HolderSealed, "vp-seal", token)
+	if err != nil {
+		return "", nil, err
+	}
+	body, err := walletOpen(seal, p.Sealed, key)
+	if err != nil {
+		return "", nil, fmt.Errorf("unsealing presentation: %w", err)
+	}
+	return p.ContentType, body, nil
+}
+
+// EraseSubject deletes the wallet items and presentations for subjectID.
+func (s *WalletStore) EraseSubject(subjectID string) (int, error) {
+	return s.remove(func(subject string, _ time.Time) bool { return subject == subjectID }, false)
+}
+
+// Expire deletes wallet items and presentations created before cutoff.
+func (s *WalletStore) Expire(cutoff time.Time, dryRun bool) (int, error) {
+	return s.remove(func(_ string, created time.Time) bool { return created.Before(cutoff) }, dryRun)
+}
+
+// remove deletes the items matching match, and their presentations as
+// well as any others matching. It returns how many items matched.
+func (s *WalletStore) remove(match func(subjectID string, created time.Time) bool, dryRun bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, changed := 0, false
+	removed := make(map[string]bool)
+	for wid, items := range s.Wallets {
+		kept := items[:0:0]
+		for _, item := range items {
+			if match(item.SubjectID, item.AddedAt) {
+				n++
+				removed[item.ID] = true
+			} else {
+				kept = append(kept, item)
+			}
+		}
+		if dryRun || len(kept) == len(items) {
+			continue
+		}
+		changed = true
+		if len(kept) == 0 {
+			delete(s.Wallets, wid)
+		} else {
+			s.Wallets[wid] = kept
+		}
+	}
+	if dryRun {
+		return n, nil
+	}
+	for key, p := range s.Presentations {
+		if removed[p.ItemID] || match(p.SubjectID, p.CreatedAt) {
+			delete(s.Presentations, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return n, nil
+	}
+	return n, s.saveLocked()
+}
+
+// buildPresentation wraps a credential in a Verifiable Presentation for
+// its subject, signed as a vp+jwt when the subject's key is held.
+func buildPresentation(subjectID, format string, credential json.RawMessage, ttl time.Duration) (string, []byte, error) {
+	var compact string
+	if format == FormatSDJWT && json.Unmarshal(credential, &compact) == nil {
+		return "application/vc+sd-jwt", []byte(compact), nil
+	}
+	vp := newPresentation(subjectID, credential)
+
+	key, ok := subjects.Key(subjectID)
+	if !ok {
+		var pretty bytes.Buffer
+		data, err := json.Marshal(vp)
+		if err != nil {
+			return "", nil, err
+		}
+		json.Indent(&pretty, data, "", "  ")
+		return "application/json", pretty.Bytes(), nil
+	}
+
+	header, _ := json.Marshal(map[string]string{
+		"alg": "EdDSA",
+		"typ": "JWT",
+		"kid": subjectID + "#" + strings.TrimPrefix(subjectID, "did:key:"),
+	})
+	payload, err := json.Marshal(presentationClaims(subjectID, vp, ttl))
+	if err != nil {
+		return "", nil, err
+	}
+	input := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sig := ed25519.Sign(key, []byte(input))
+	return "application/jwt", []byte(input + "." + base64.RawURLEncoding.EncodeToString(sig)), nil
+}
+
+// newPresentation wraps a credential in an unsigned Verifiable
+// Presentation held by subjectID, in the credential's VC data model.
+func newPresentation(subjectID string, credential json.RawMessage) map[string]interface{} {
+	context := "https://www.w3.org/2018/credentials/v1"
+	var vc struct {
+		Context []interface{} `json:"@context"`
+	}
+	if json.Unmarshal(credential, &vc) == nil && len(vc.Context) > 0 {
+		if c, ok := vc.Context[0].(string); ok {
+			context = c
+		}
+	}
+	return map[string]interface{}{
+		"@context":             []string{context},
+		"type":                 []string{"VerifiablePresentation"},
+		"holder":               subjectID,
+		"verifiableCredential": []json.RawMessage{credential},
+	}
+}
+
+// presentationClaims are the vp-jwt claims of a presentation signed by
+// iss, valid for ttl.
+func presentationClaims(iss string, vp map[string]interface{}, ttl time.Duration) map[string]interface{} {
+	now := time.Now()
+	return map[string]interface{}{
+		"iss": iss,
+		"jti": "urn:uuid:" + newUUID(),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+		"vp":  vp,
+	}
+}
+
+// portalWallet renders the wallet section of the portal.
+func portalWallet(sess *portalSession) ([]WalletView, map[string]bool) {
+	items, err := wallets.Items(sess.holderID())
+	if err != nil {
+		slog.Error("wallet error", "err", err)
+	}
+	inWallet := make(map[string]bool)
+	for _, item := range items {
+		inWallet[item.CredentialID] = true
+	}
+	return items, inWallet
+}
+
+// handleWalletAdd keeps one of the student's credentials in their wallet.
+func handleWalletAdd(w http.ResponseWriter, r *http.Request) {
+	sess, _ := portalStudent(r)
+	cred, ok := ownedCredential(r)
+	if !ok {
+		http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+		return
+	}
+	subject, err := credentialSubjectOf(cred)
+	if err != nil {
+		renderPortalError(w, r, sess, "This credential cannot be kept in the cloud wallet: "+err.Error())
+		return
+	}
+	form := formFromSubject(subject)
+	if _, err := wallets.Add(sess.holderID(), cred, walletEntry{
+		Credential:  cred.Credential,
+		Degree:      form.Degree,
+		Institution: form.Institution,
+	}); err != nil {
+		slog.ErrorContext(r.Context(), "wallet add error", "err", err)
+		msg := "Failed to add the credential to your wallet"
+		if errors.Is(err, errNoHolderKey) {
+			msg = walletUnavailable
+		}
+		renderPortalError(w, r, sess, msg)
+		return
+	}
+	slog.InfoContext(r.Context(), "credential added to a cloud wallet", "credential", cred.ID)
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
+
+func handleWalletRemove(w http.ResponseWriter, r *http.Request) {
+	if sess, ok := portalStudent(r); ok {
+		if err := wallets.Remove(sess.holderID(), r.PathValue("item")); err != nil && !os.IsNotExist(err) {
+			slog.ErrorContext(r.Context(), "wallet remove error", "err", err)
+		}
+	}
+	http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+}
+
+// handleWalletPresent creates a presentation link for a wallet item.
+func handleWalletPresent(w http.ResponseWriter, r *http.Request) {
+	sess, ok := portalStudent(r)
+	if !ok {
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": "Please sign in again."})
+		return
+	}
+	r.ParseForm()
+	token, expires, err := wallets.Present(r.Context(), sess.holderID(), r.PathValue("item"), liveConfig().ShareLinkTTL, revealedFields(r))
+	if err != nil {
+		slog.ErrorContext(r.Context(), "wallet presentation error", "err", err)
+		msg := "Failed to create the presentation link"
+		if errors.Is(err, errNoHolderKey) {
+			msg = walletUnavailable
+		}
+		pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{"Error": msg})
+		return
+	}
+	pages(r).ExecuteTemplate(w, "share-link", map[string]interface{}{
+		"Label":     "Presentation",
+		"URL":       config.PublicURL + "/vp/" + token,
+		"ExpiresAt": expires,
+	})
+}
+
+func handlePresentationOpen(w http.ResponseWriter, r *http.Request) {
+	contentType, body, err := wallets.OpenPresentation(r.PathValue("token"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.ErrorContext(r.Context(), "presentation open error", "err", err)
+		}
+		http.Error(w, "This link has expired.", http.StatusGone)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(body)
+}
+
+// handleWalletExport downloads the wallet's credentials and the student's
+// minted keys as JWKs, for import into another wallet.
+func handleWalletExport(w http.ResponseWriter, r *http.Request) {
+	sess, ok := portalStudent(r)
+	if !ok {
+		http.Redirect(w, r, appPath("/portal"), http.StatusSeeOther)
+		return
+	}
+	items, err := wallets.Items(sess.holderID())
+	if err != nil {
+		slog.ErrorContext(r.Context(), "wallet export error", "err", err)
+		http.Error(w, "Failed to open the wallet", http.StatusInternalServerError)
+		return
+	}
+
+	var credentials []map[string]interface{}
+	var keys []map[string]interface{}
+	seen := make(map[string]bool)
+	for _, view := range items {
+		item, entry, err := wallets.Open(sess.holderID(), view.ID)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "wallet export error", "err", err)
+			http.Error(w, "Failed to open the wallet", http.StatusInternalServerError)
+			return
+		}
+		credentials = append(credentials, map[string]interface{}{
+			"format":     item.Format,
+			"credential": entry.Credential,
+		})
+		if seen[item.SubjectID] {
+			continue
+		}
+		seen[item.SubjectID] = true
+		if key, ok := subjects.Key(item.SubjectID); ok {
+			keys = append(keys, map[string]interface{}{
+				"id":         item.SubjectID + "#" + strings.TrimPrefix(item.SubjectID, "did:key:"),
+				"controller": item.SubjectID,
+				"privateKeyJwk": map[string]string{
+					"kty": "OKP",
+					"crv": "Ed25519",
+					"x":   base64.RawURLEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+					"d":   base64.RawURLEncoding.EncodeToString(key.Seed()),
+				},
+			})
+		}
+	}
+
+	slog.InfoContext(r.Context(), "cloud wallet exported", "credentials", len(credentials), "keys", len(keys))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"testa-cloud-wallet.json\"")
+	w.Header().Set("Cache-Control", "no-store")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(map[string]interface{}{
+		"type":        "CloudWalletExport",
+		"exportedAt":  time.Now().UTC().Format(time.RFC3339),
+		"credentials": credentials,
+		"keys":        keys,
+	})
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+// useKeys sets the link signing and holder keys for one test.
+func useKeys(t *testing.T, link, holder []byte) {
+	t.Helper()
+	savedLink, savedHolder := linkKey, holderKey
+	linkKey, holderKey = link, holder
+	t.Cleanup(func() { linkKey, holderKey = savedLink, savedHolder })
+}
+
+// TestWalletSealing checks that wallet items are sealed under the holder
+// key, not the link signing key, and that items from before the holder
+// key are resealed when opened.
+func TestWalletSealing(t *testing.T) {
+	link, holder := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	entry := walletEntry{Credential: json.RawMessage(`{"id":"urn:uuid:1"}`), Degree: "BSc"}
+	cred := &StoredCredential{ID: "cred-1", Format: FormatLDP}
+
+	t.Run("no holder key", func(t *testing.T) {
+		useKeys(t, link, nil)
+		s, err := NewWalletStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Add("did:example:amina", cred, entry); !errors.Is(err, errNoHolderKey) {
+			t.Errorf("Add err = %v, want errNoHolderKey", err)
+		}
+	})
+
+	t.Run("holder key", func(t *testing.T) {
+		useKeys(t, link, holder)
+		s, err := NewWalletStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err := s.Add("did:example:amina", cred, entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !item.HolderSealed {
+			t.Error("item not sealed under the holder key")
+		}
+		if _, err := walletOpen(walletKey("wallet-seal", "did:example:amina"), item.Sealed, item.ID); err == nil {
+			t.Error("item opens with a key derived from the link signing key")
+		}
+		if _, got, err := s.Open("did:example:amina", item.ID); err != nil || got.Degree != "BSc" {
+			t.Errorf("Open = %+v, %v", got, err)
+		}
+	})
+
+	t.Run("legacy item", func(t *testing.T) {
+		useKeys(t, link, holder)
+		s, err := NewWalletStore(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		plaintext, _ := json.Marshal(entry)
+		item := &WalletItem{ID: "item-1", CredentialID: cred.ID, Format: cred.Format, AddedAt: time.Now()}
+		if item.Sealed, err = walletSeal(walletKey("wallet-seal", "did:example:amina"), plaintext, item.ID); err != nil {
+			t.Fatal(err)
+		}
+		s.Wallets[walletID("did:example:amina")] = []*WalletItem{item}
+
+		if _, got, err := s.Open("did:example:amina", item.ID); err != nil || got.Degree != "BSc" {
+			t.Fatalf("Open = %+v, %v", got, err)
+		}
+		if !item.HolderSealed {
+			t.Fatal("legacy item not resealed")
+		}
+		key, _ := sealKey("wallet-seal", "did:example:amina")
+		if _, err := walletOpen(key, item.Sealed, item.ID); err != nil {
+			t.Errorf("resealed item: %v", err)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+)
+
+// Wallet display. Wallets show a credential's claims by the labels its
+// issuer publishes for them, or else by their raw JSON keys, so each
+// credential field has a label and a description, in English here and in
+// every language with a catalog in locales/, translated like the pages
+// are. A Swahili wallet shows "Taasisi" rather than alumniOf.
+//
+// The labels are published as OpenID for VCI claim display (see
+// issuermeta.go), keyed by the properties the field mapping issues, and
+// as an Overlays Capture Architecture bundle (Aries RFC 0755) at
+// ocaBundlePath for AnonCreds wallets such as Bifold, with meta, label,
+// information and branding overlays. The bundle is identified by a SHA-256
+// digest of its capture base rather than a self-addressing identifier.
+
+const ocaBundlePath = "/oca/education-credential.json"
+
+// fieldVocabulary is how wallets name and describe a credential field.
+type fieldVocabulary struct {
+	Label       string
+	Description string
+}
+
+// credentialFieldVocabulary are the fields' labels and descriptions in
+// English, by the form's field names; the catalogs translate them.
+var credentialFieldVocabulary = map[string]fieldVocabulary{
+	"studentName":    {"Student Name", "The student's full name"},
+	"institution":    {"Institution", "The institution that awarded the qualification"},
+	"degree":         {"Degree", "The qualification awarded"},
+	"fieldOfStudy":   {"Field of Study", "The programme or major studied"},
+	"enrollmentDate": {"Enrollment Date", "The date the student enrolled"},
+	"graduationDate": {"Graduation Date", "The date the student graduated"},
+	"studentId":      {"Student ID", "The student's registration number at the institution"},
+	"gpa":            {"GPA", "The final grade, on the grading scale given with it"},
+	"honors":         {"Honors", "The class or distinction awarded"},
+	"gpaScale":       {"Grading Scale", "The scale the GPA is given on"},
+	"gpaNormalized":  {"GPA (%)", "The GPA as a percentage of its scale"},
+}
+
+const (
+	credentialDisplayName        = "Education Credential"
+	credentialDisplayDescription = "Academic qualification issued by %s"
+)
+
+// displayLanguages are the languages credentials are labelled in: those
+// with a catalog, English first.
+func displayLanguages() []string {
+	codes := []string{"en"}
+	for code := range live().catalogs {
+		if code != "en" {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes[1:])
+	return codes
+}
+
+// displayText translates wallet display text into a language.
+func displayText(lang, msg string, args ...interface{}) string {
+	if live().catalogs[lang] != nil {
+		return translator(lang)(msg, args...)
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// fieldVocabularyFor looks up the vocabulary of the field a mapping issues
+// as property.
+func fieldVocabularyFor(property string, mapping []FieldMapping) (fieldVocabulary, bool) {
+	for _, m := range mapping {
+		if m.Property == property {
+			v, ok := credentialFieldVocabulary[m.Field]
+			return v, ok
+		}
+	}
+	return fieldVocabulary{}, false
+}
+
+// ocaOverlay is an OCA bundle overlay; its content is by type.
+type ocaOverlay map[string]interface{}
+
+// buildOCABundle labels the AnonCreds attributes in every display language.
+func buildOCABundle() map[string]interface{} {
+	attributes := map[string]string{}
+	for _, a := range anonCredsAttributes {
+		attributes[a] = "Text"
+	}
+	captureBase := map[string]interface{}{
+		"type":               "spec/capture_base/1.0",
+		"classification":     "",
+		"attributes":         attributes,
+		"flagged_attributes": []string{"name", "studentId"},
+	}
+	raw, _ := json.Marshal(captureBase)
+	sum := sha256.Sum256(raw)
+	digest := hex.EncodeToString(sum[:])
+	captureBase["digest"] = digest
+
+	var overlays []ocaOverlay
+	for _, lang := range displayLanguages() {
+		labels, information := map[string]string{}, map[string]string{}
+		for _, a := range anonCredsAttributes {
+			if v, ok := fieldVocabularyFor(a, defaultFieldMapping); ok {
+				labels[a] = displayText(lang, v.Label)
+				information[a] = displayText(lang, v.Description)
+			}
+		}
+		overlays = append(overlays,
+			ocaOverlay{
+				"type":         "spec/overlays/meta/1.0",
+				"capture_base": digest,
+				"language":     lang,
+				"name":         displayText(lang, credentialDisplayName),
+				"description":  displayText(lang, credentialDisplayDescription, liveConfig().IssuerName),
+				"issuer":       liveConfig().IssuerName,
+			},
+			ocaOverlay{"type": "spec/overlays/label/1.0", "capture_base": digest, "language": lang, "attribute_labels": labels},
+			ocaOverlay{"type": "spec/overlays/information/1.0", "capture_base": digest, "language": lang, "attribute_information": information},
+		)
+	}
+	overlays = append(overlays, ocaOverlay{
+		"type":                     "aries/overlays/branding/1.0",
+		"capture_base":             digest,
+		"logo":                     issuerLogo().URI,
+		"primary_background_color": credentialBackgroundColor,
+		"primary_attribute":        "name",
+		"secondary_attribute":      "degree",
+	})
+	return map[string]interface{}{"capture_base": captureBase, "overlays": overlays}
+}
+
+func handleOCABundle(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	if err := json.NewEncoder(w).Encode(buildOCABundle()); err != nil {
+		slog.ErrorContext(r.Context(), "OCA bundle write", "err", err)
+	}
+}
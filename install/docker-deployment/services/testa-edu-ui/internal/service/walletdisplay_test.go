@@ -0,0 +1,44 @@
+package service
+
+import "testing"
+
+// TestWalletDisplay checks wallets are given the credential's labels in
+// every language, keyed by the properties issued.
+func TestWalletDisplay(t *testing.T) {
+	savedMapping := fieldMapping
+	t.Cleanup(func() { fieldMapping = savedMapping })
+	useCatalogs(t)
+
+	fieldMapping = append([]FieldMapping{{Field: "institution", Property: "awardingBody", IRI: "https://schema.org/alumniOf"}}, defaultFieldMapping[0])
+	claims := credentialClaimDisplay()
+	display := claims["awardingBody"].(map[string]interface{})["display"].([]issuerDisplay)
+	if len(display) != len(live().catalogs) || display[0] != (issuerDisplay{Name: "Institution", Locale: "en"}) {
+		t.Errorf("awardingBody display %+v", display)
+	}
+	labels := map[string]string{}
+	for _, d := range display {
+		labels[d.Locale] = d.Name
+	}
+	if labels["sw"] != "Taasisi" || claims["alumniOf"] != nil {
+		t.Errorf("labels %v, claims %v", labels, claims)
+	}
+
+	bundle := buildOCABundle()
+	digest := bundle["capture_base"].(map[string]interface{})["digest"]
+	found := map[string]bool{}
+	for _, o := range bundle["overlays"].([]ocaOverlay) {
+		if o["capture_base"] != digest {
+			t.Errorf("overlay %v is not of the capture base %v", o, digest)
+		}
+		if o["type"] == "spec/overlays/label/1.0" && o["language"] == "sw" {
+			l := o["attribute_labels"].(map[string]string)
+			found["label"] = l["alumniOf"] == "Taasisi" && l["name"] == "Jina la Mwanafunzi"
+		}
+		if o["type"] == "spec/overlays/meta/1.0" && o["language"] == "sw" {
+			found["meta"] = o["name"] == "Cheti cha Elimu"
+		}
+	}
+	if !found["label"] || !found["meta"] {
+		t.Errorf("Swahili overlays %v in %v", found, bundle["overlays"])
+	}
+}
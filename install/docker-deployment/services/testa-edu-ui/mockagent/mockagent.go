@@ -0,0 +1,340 @@
+// Package mockagent is a fake CREDEBL agent for tests. It answers the
+// Credo REST controller endpoints testa-edu-ui calls — tokens, the agent
+// record, DID listing and creation, JSON-LD and raw-data signing, and
+// verification — and lets a test slow endpoints down, make them fail, or
+// give canned answers instead:
+//
+//	agent := mockagent.New()
+//	url := agent.Start(t)
+//	agent.Fail("/agent/credential/sign", http.StatusServiceUnavailable, 2)
+//	agent.SetLatency("/agent/credential/verify", time.Second)
+//
+// Its signatures are HMACs under Key: the same credential always gets the
+// same proof, which the agent's own verify endpoint checks, but nothing
+// else can. The service's demo mode (DEMO_MODE) runs on it too.
+package mockagent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// KeyDID is the DID POST /dids/write creates for the key method.
+const KeyDID = "did:key:z6MkhaXgBZDvotDkL5257faiztiGiC2QtKLGpbnnEGta2doK"
+
+// DID is a DID in the agent's wallet, listed by GET /dids.
+type DID struct {
+	DID string
+	// VerificationMethodType is the type of the DID document's one
+	// verification method, e.g. Ed25519VerificationKey2018; empty lists
+	// the DID without a document.
+	VerificationMethodType string
+}
+
+// Agent is a fake agent. Its exported fields may be set before it serves
+// its first request.
+type Agent struct {
+	// Token is the API token POST /agent/token issues; other endpoints
+	// answer 401 to any other bearer token.
+	Token   string
+	Label   string
+	Version string
+	// DIDs are listed by GET /dids; nil answers 404, as an agent without
+	// DID listing does.
+	DIDs []DID
+	// Key is the HMAC key of the agent's signatures.
+	Key []byte
+
+	mu      sync.Mutex
+	latency map[string]time.Duration
+	faults  map[string]*fault
+	canned  map[string]response
+	calls   map[string]int
+	bodies  map[string][]byte
+}
+
+type fault struct {
+	status int
+	times  int
+}
+
+type response struct {
+	status int
+	body   string
+}
+
+// New returns an agent with a fixed token and key and an empty wallet.
+func New() *Agent {
+	return &Agent{
+		Token:   "mock-token",
+		Label:   "Mock agent",
+		Version: "0.5.3",
+		DIDs:    []DID{},
+		Key:     []byte("testa-edu-ui mock agent"),
+		latency: map[string]time.Duration{},
+		faults:  map[string]*fault{},
+		canned:  map[string]response{},
+		calls:   map[string]int{},
+		bodies:  map[string][]byte{},
+	}
+}
+
+// Start serves the agent on a local port until the test ends and returns
+// its URL.
+func (a *Agent) Start(tb testing.TB) string {
+	srv := httptest.NewServer(a)
+	tb.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// SetLatency delays every response of the endpoint at path by d.
+func (a *Agent) SetLatency(path string, d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.latency[path] = d
+}
+
+// Fail has the next times calls to path answer status, with a NestJS-style
+// error body; times < 0 fails every call.
+func (a *Agent) Fail(path string, status, times int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.faults[path] = &fault{status: status, times: times}
+}
+
+// Respond has every call to path answer status and body, in place of the
+// agent's own answer.
+func (a *Agent) Respond(path string, status int, body string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.canned[path] = response{status, body}
+}
+
+// Calls is how many requests path has had, failed ones included.
+func (a *Agent) Calls(path string) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.calls[path]
+}
+
+// LastBody is the body of the last request to path.
+func (a *Agent) LastBody(path string) []byte {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.bodies[path]
+}
+
+func (a *Agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 8<<20))
+	path := r.URL.Path
+
+	a.mu.Lock()
+	a.calls[path]++
+	a.bodies[path] = body
+	delay := a.latency[path]
+	f := a.faults[path]
+	failing := f != nil && f.times != 0
+	if failing && f.times > 0 {
+		f.times--
+	}
+	canned, isCanned := a.canned[path]
+	a.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	switch {
+	case failing:
+		writeError(w, f.status, http.StatusText(f.status))
+		return
+	case isCanned:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(canned.status)
+		io.WriteString(w, canned.body)
+		return
+	case path != "/agent/token" && r.Header.Get("Authorization") != "Bearer "+a.Token:
+		writeError(w, http.StatusUnauthorized, "Unauthorized")
+		return
+	}
+
+	switch {
+	case r.Method == "POST" && path == "/agent/token":
+		writeJSON(w, map[string]string{"token": a.Token})
+	case r.Method == "GET" && path == "/agent":
+		writeJSON(w, map[string]interface{}{"label": a.Label, "version": a.Version, "isInitialized": true})
+	case r.Method == "GET" && path == "/dids":
+		a.listDIDs(w)
+	case r.Method == "POST" && path == "/dids/write":
+		a.writeDID(w, body)
+	case r.Method == "POST" && path == "/agent/credential/sign":
+		a.sign(w, r, body)
+	case r.Method == "POST" && path == "/agent/credential/verify":
+		a.verify(w, body)
+	default:
+		writeError(w, http.StatusNotFound, "Cannot "+r.Method+" "+path)
+	}
+}
+
+func (a *Agent) listDIDs(w http.ResponseWriter) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.DIDs == nil {
+		writeError(w, http.StatusNotFound, "Cannot GET /dids")
+		return
+	}
+	out := []map[string]interface{}{}
+	for _, d := range a.DIDs {
+		rec := map[string]interface{}{"did": d.DID}
+		if d.VerificationMethodType != "" {
+			rec["didDocument"] = map[string]interface{}{
+				"verificationMethod": []map[string]string{{"id": d.DID + "#key-1", "type": d.VerificationMethodType}},
+			}
+		}
+		out = append(out, rec)
+	}
+	writeJSON(w, out)
+}
+
+func (a *Agent) writeDID(w http.ResponseWriter, body []byte) {
+	var req struct {
+		Method string `json:"method"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.Method != "key" {
+		writeError(w, http.StatusBadRequest, "only the key method is supported")
+		return
+	}
+	a.mu.Lock()
+	a.DIDs = append(a.DIDs, DID{DID: KeyDID, VerificationMethodType: "Ed25519VerificationKey2018"})
+	a.mu.Unlock()
+	writeJSON(w, map[string]string{"did": KeyDID})
+}
+
+func (a *Agent) sign(w http.ResponseWriter, r *http.Request, body []byte) {
+	if r.URL.Query().Get("dataTypeToSign") == "rawData" {
+		var req struct {
+			Data string `json:"data"`
+		}
+		if json.Unmarshal(body, &req) != nil {
+			writeError(w, http.StatusBadRequest, "invalid request")
+			return
+		}
+		mac := hmac.New(sha512.New, a.Key)
+		mac.Write([]byte(req.Data))
+		writeJSON(w, map[string]string{"signature": base64.StdEncoding.EncodeToString(mac.Sum(nil))})
+		return
+	}
+	var req struct {
+		Credential         map[string]interface{} `json:"credential"`
+		VerificationMethod string                 `json:"verificationMethod"`
+		ProofType          string                 `json:"proofType"`
+		Cryptosuite        string                 `json:"cryptosuite"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.Credential == nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	created, _ := req.Credential["issuanceDate"].(string)
+	if v, ok := req.Credential["validFrom"].(string); ok {
+		created = v
+	}
+	proof := map[string]interface{}{
+		"type":               req.ProofType,
+		"created":            created,
+		"verificationMethod": req.VerificationMethod,
+		"proofPurpose":       "assertionMethod",
+		"proofValue":         a.ProofValue(req.Credential),
+	}
+	if req.Cryptosuite != "" {
+		proof["cryptosuite"] = req.Cryptosuite
+	}
+	req.Credential["proof"] = proof
+	writeJSON(w, req.Credential)
+}
+
+func (a *Agent) verify(w http.ResponseWriter, body []byte) {
+	var req struct {
+		Credential json.RawMessage `json:"credential"`
+	}
+	if json.Unmarshal(body, &req) != nil || len(req.Credential) == 0 {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	var jwt string
+	if json.Unmarshal(req.Credential, &jwt) == nil {
+		a.verifyJWT(w, jwt)
+		return
+	}
+	var credential map[string]interface{}
+	if json.Unmarshal(req.Credential, &credential) != nil {
+		writeError(w, http.StatusBadRequest, "invalid request")
+		return
+	}
+	proof, _ := credential["proof"].(map[string]interface{})
+	value, _ := proof["proofValue"].(string)
+	switch {
+	case proof == nil:
+		writeJSON(w, map[string]interface{}{"isValid": false, "error": "the credential has no proof"})
+	case !hmac.Equal([]byte(value), []byte(a.ProofValue(credential))):
+		writeJSON(w, map[string]interface{}{"isValid": false, "error": "the proof does not match the credential"})
+	default:
+		writeJSON(w, map[string]interface{}{"isValid": true})
+	}
+}
+
+// verifyJWT checks a compact JWS signed by the raw-data sign endpoint.
+func (a *Agent) verifyJWT(w http.ResponseWriter, jwt string) {
+	i := strings.LastIndex(jwt, ".")
+	sig, err := base64.RawURLEncoding.DecodeString(jwt[i+1:])
+	if i < 0 || err != nil {
+		writeJSON(w, map[string]interface{}{"isValid": false, "error": "not a compact JWS"})
+		return
+	}
+	mac := hmac.New(sha512.New, a.Key)
+	mac.Write([]byte(jwt[:i]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		writeJSON(w, map[string]interface{}{"isValid": false, "error": "the signature does not match the JWT"})
+		return
+	}
+	writeJSON(w, map[string]interface{}{"isValid": true})
+}
+
+// ProofValue is the agent's proof value for a credential: a multibase
+// base64url HMAC of its JSON without the proof. The credential's proof,
+// if any, is removed.
+func (a *Agent) ProofValue(credential map[string]interface{}) string {
+	delete(credential, "proof")
+	data, _ := json.Marshal(credential)
+	mac := hmac.New(sha256.New, a.Key)
+	mac.Write(data)
+	return "u" + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with the NestJS error body the agent sends.
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"statusCode": status,
+		"message":    message,
+		"error":      strings.TrimSpace(http.StatusText(status)),
+	})
+}
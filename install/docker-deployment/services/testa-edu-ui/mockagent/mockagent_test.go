@@ -0,0 +1,79 @@
+package mockagent_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/credebl/testa-edu-ui/mockagent"
+)
+
+func post(t *testing.T, url, token, body string) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var out map[string]interface{}
+	json.NewDecoder(resp.Body).Decode(&out)
+	return resp.StatusCode, out
+}
+
+func TestSignVerify(t *testing.T) {
+	agent := mockagent.New()
+	url := agent.Start(t)
+
+	if status, _ := post(t, url+"/agent/credential/sign", "wrong", `{}`); status != http.StatusUnauthorized {
+		t.Errorf("wrong token: %d", status)
+	}
+	_, tok := post(t, url+"/agent/token", "", "")
+	token, _ := tok["token"].(string)
+	status, signed := post(t, url+"/agent/credential/sign?dataTypeToSign=jsonLd", token,
+		`{"credential":{"issuer":"`+mockagent.KeyDID+`","issuanceDate":"2026-01-01T00:00:00Z"},"proofType":"Ed25519Signature2020"}`)
+	if status != http.StatusOK || signed["proof"] == nil {
+		t.Fatalf("sign: %d %v", status, signed)
+	}
+	cred, _ := json.Marshal(signed)
+	if _, v := post(t, url+"/agent/credential/verify", token, `{"credential":`+string(cred)+`}`); v["isValid"] != true {
+		t.Errorf("verify: %v", v)
+	}
+	signed["issuer"] = "did:example:other"
+	cred, _ = json.Marshal(signed)
+	if _, v := post(t, url+"/agent/credential/verify", token, `{"credential":`+string(cred)+`}`); v["isValid"] != false {
+		t.Errorf("tampered credential verified: %v", v)
+	}
+}
+
+func TestFaultsAndLatency(t *testing.T) {
+	agent := mockagent.New()
+	url := agent.Start(t)
+
+	agent.Fail("/agent/token", http.StatusServiceUnavailable, 2)
+	for i, want := range []int{503, 503, 200} {
+		if status, _ := post(t, url+"/agent/token", "", ""); status != want {
+			t.Errorf("call %d: %d, want %d", i+1, status, want)
+		}
+	}
+	if n := agent.Calls("/agent/token"); n != 3 {
+		t.Errorf("%d calls recorded", n)
+	}
+
+	agent.Respond("/agent/token", http.StatusOK, `{"token":"canned"}`)
+	if _, out := post(t, url+"/agent/token", "", ""); out["token"] != "canned" {
+		t.Errorf("canned response: %v", out)
+	}
+
+	agent.SetLatency("/agent/token", 50*time.Millisecond)
+	start := time.Now()
+	post(t, url+"/agent/token", "", "")
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("answered in %s", d)
+	}
+}
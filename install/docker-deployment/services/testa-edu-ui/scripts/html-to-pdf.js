@@ -0,0 +1,35 @@
+#!/usr/bin/env node
+/**
+ * HTML to PDF for Testa Edu
+ *
+ * Reads a self-contained certificate page (rendered by the Go server from
+ * its certificate template) from stdin and prints it to an A4 PDF on
+ * stdout with headless Chromium. The page's own @page rule wins over the
+ * A4 default. CHROMIUM_PATH points at the browser.
+ */
+const puppeteer = require('puppeteer-core');
+const fs = require('fs');
+
+const CHROMIUM_PATH = process.env.CHROMIUM_PATH || '/usr/bin/chromium-browser';
+
+async function main() {
+    const html = fs.readFileSync(0, 'utf8');
+    const browser = await puppeteer.launch({
+        executablePath: CHROMIUM_PATH,
+        args: ['--no-sandbox', '--disable-dev-shm-usage'],
+    });
+    try {
+        const page = await browser.newPage();
+        await page.setJavaScriptEnabled(false);
+        await page.setContent(html, { waitUntil: 'networkidle0', timeout: 20000 });
+        const pdf = await page.pdf({ format: 'A4', printBackground: true, preferCSSPageSize: true });
+        process.stdout.write(pdf);
+    } finally {
+        await browser.close();
+    }
+}
+
+main().catch(err => {
+    process.stderr.write(err.message || String(err));
+    process.exit(1);
+});
@@ -0,0 +1,46 @@
+#!/usr/bin/env node
+/**
+ * Credential subject mapping for Testa Edu
+ *
+ * Runs an institution's mapping script (see subjectscript.go) in a fresh
+ * V8 context holding only its input: no require, no process, no timers.
+ * The Go server runs this under Node's permission model with an empty
+ * environment, so a script that escapes the context still cannot read
+ * secrets, write files or start processes.
+ *
+ * Reads {source, input, subject, timeoutMs} from stdin; the script defines
+ * map(input, subject), and the subject it returns is written to stdout.
+ * Defining and running it together may take timeoutMs, which the Go server
+ * sets (subjectScriptTimeout).
+ */
+const fs = require('fs');
+const vm = require('vm');
+
+function main() {
+    const { source, input, subject, timeoutMs } = JSON.parse(fs.readFileSync(0, 'utf8'));
+    if (!Number.isInteger(timeoutMs) || timeoutMs <= 0) {
+        throw new Error('timeoutMs must be a positive whole number of milliseconds');
+    }
+    const deadline = Date.now() + timeoutMs;
+    const remaining = () => Math.max(1, deadline - Date.now());
+    const context = vm.createContext(Object.create(null));
+    vm.runInContext(source, context, { filename: 'mapping.js', timeout: remaining() });
+    if (typeof context.map !== 'function') {
+        throw new Error('the script defines no map(input, subject) function');
+    }
+    // The arguments are copied into the context as JSON, so the script
+    // gets plain objects of its own realm.
+    context.__args = JSON.stringify([input, subject]);
+    const out = vm.runInContext('JSON.stringify(map(...JSON.parse(__args)))', context, { timeout: remaining() });
+    if (out === undefined) {
+        throw new Error('map returned nothing');
+    }
+    process.stdout.write(out);
+}
+
+try {
+    main();
+} catch (err) {
+    process.stderr.write(err.message || String(err));
+    process.exit(1);
+}
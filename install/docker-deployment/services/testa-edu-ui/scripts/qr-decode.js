@@ -0,0 +1,25 @@
+#!/usr/bin/env node
+/**
+ * JSON-XT Decode for Testa Edu
+ *
+ * Reads a JSON-XT URI (the content of a PixelPass QR, already unwrapped
+ * by the Go server) from stdin and writes the expanded credential JSON to
+ * stdout, using the same local templates qr-encode.js packs with.
+ */
+const jsonxt = require('jsonxt');
+const fs = require('fs');
+const path = require('path');
+
+const TEMPLATES_PATH = path.join(__dirname, '..', 'templates-data', 'jsonxt-templates.json');
+
+async function main() {
+    const uri = fs.readFileSync(0, 'utf8').trim();
+    const templates = JSON.parse(fs.readFileSync(TEMPLATES_PATH, 'utf8'));
+    const credential = await jsonxt.unpack(uri, async () => templates);
+    process.stdout.write(JSON.stringify(credential));
+}
+
+main().catch(err => {
+    process.stderr.write(err.message || String(err));
+    process.exit(1);
+});
@@ -4,6 +4,13 @@
  *
  * Reads a signed credential JSON from stdin.
  * Outputs JSON to stdout: { jsonxtUri, qrData, qrPngBase64, sizes }
+ *
+ * When qrData is longer than QR_MAX_CHARS no PNG is rendered; the Go
+ * server splits the data into animated QR frames instead.
+ *
+ * QR_ERROR_CORRECTION (L/M/Q/H), QR_MODULE_SIZE (pixels per module, 0 to
+ * fit 1024px) and QR_QUIET_ZONE (modules) are set by the Go server from
+ * its config and the issuance form.
  */
 const jsonxt = require('jsonxt');
 const { generateQRData } = require('@injistack/pixelpass');
@@ -12,26 +19,58 @@ const fs = require('fs');
 const path = require('path');
 
 const TEMPLATES_PATH = path.join(__dirname, '..', 'templates-data', 'jsonxt-templates.json');
+const QR_MAX_CHARS = parseInt(process.env.QR_MAX_CHARS || '1800', 10);
+const QR_ERROR_CORRECTION = process.env.QR_ERROR_CORRECTION || 'H';
+const QR_MODULE_SIZE = parseInt(process.env.QR_MODULE_SIZE || '0', 10);
+const QR_QUIET_ZONE = parseInt(process.env.QR_QUIET_ZONE || '4', 10);
 
 async function main() {
     const input = fs.readFileSync(0, 'utf8');
     const credential = JSON.parse(input);
 
+    // JWT credentials arrive as a JSON string and are already compact, so
+    // they go into the QR as-is without JSON-XT packing.
+    if (typeof credential === 'string') {
+        return writeResult(credential, '', generateQRData(credential));
+    }
+
     const templates = JSON.parse(fs.readFileSync(TEMPLATES_PATH, 'utf8'));
 
-    // Pack credential to JSON-XT URI
-    const jsonxtUri = await jsonxt.pack(credential, templates, 'educ', '1', 'local');
+    // Pack credential to JSON-XT URI. educ:2 carries the credentialSchema
+    // reference, educ:3 is the VCDM 2.0 shape; credentials issued without a
+    // schema keep using educ:1. educ:4 and educ:5 are educ:2 and educ:3 with
+    // the GPA's grading scale, for credentials whose context defines it.
+    const contexts = [].concat(credential['@context']);
+    const isV2 = contexts[0] === 'https://www.w3.org/ns/credentials/v2';
+    const graded = contexts.some(c => c && typeof c === 'object' && 'gpaScale' in c);
+    let version = isV2 ? '3' : (credential.credentialSchema ? '2' : '1');
+    if (graded && version !== '1') {
+        version = isV2 ? '5' : '4';
+    }
+    const jsonxtUri = await jsonxt.pack(credential, templates, 'educ', version, 'local');
 
     // Wrap with PixelPass for Inji Verify compatibility
     const qrData = generateQRData(jsonxtUri);
 
+    return writeResult(credential, jsonxtUri, qrData);
+}
+
+async function writeResult(credential, jsonxtUri, qrData) {
     // Generate QR code as PNG (min 10KB for Inji Verify compatibility)
-    const qrPngBuffer = await QRCode.toBuffer(qrData, {
-        type: 'png',
-        width: 1024,
-        margin: 4,
-        errorCorrectionLevel: 'H'
-    });
+    let qrPngBuffer = Buffer.alloc(0);
+    if (qrData.length <= QR_MAX_CHARS) {
+        const options = {
+            type: 'png',
+            margin: QR_QUIET_ZONE,
+            errorCorrectionLevel: QR_ERROR_CORRECTION
+        };
+        if (QR_MODULE_SIZE > 0) {
+            options.scale = QR_MODULE_SIZE;
+        } else {
+            options.width = 1024;
+        }
+        qrPngBuffer = await QRCode.toBuffer(qrData, options);
+    }
     const qrPngBase64 = qrPngBuffer.toString('base64');
 
     const result = {
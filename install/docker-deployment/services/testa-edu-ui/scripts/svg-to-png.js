@@ -0,0 +1,41 @@
+#!/usr/bin/env node
+/**
+ * SVG to PNG for Testa Edu
+ *
+ * Reads a self-contained SVG image (rendered by the Go server from a
+ * certificate image template) from stdin and rasterizes it to PNG on
+ * stdout with headless Chromium, at the SVG's own width and height.
+ * CHROMIUM_PATH points at the browser.
+ */
+const puppeteer = require('puppeteer-core');
+const fs = require('fs');
+
+const CHROMIUM_PATH = process.env.CHROMIUM_PATH || '/usr/bin/chromium-browser';
+
+async function main() {
+    const svg = fs.readFileSync(0, 'utf8');
+    const browser = await puppeteer.launch({
+        executablePath: CHROMIUM_PATH,
+        args: ['--no-sandbox', '--disable-dev-shm-usage'],
+    });
+    try {
+        const page = await browser.newPage();
+        await page.setJavaScriptEnabled(false);
+        await page.setContent('<!DOCTYPE html><html><body style="margin:0">' +
+            svg.replace(/^<\?xml[^>]*\?>\s*/, '') + '</body></html>',
+            { waitUntil: 'networkidle0', timeout: 20000 });
+        const image = await page.$('svg');
+        if (!image) {
+            throw new Error('input has no <svg> element');
+        }
+        const png = await image.screenshot({ type: 'png', omitBackground: false });
+        process.stdout.write(png);
+    } finally {
+        await browser.close();
+    }
+}
+
+main().catch(err => {
+    process.stderr.write(err.message || String(err));
+    process.exit(1);
+});
@@ -0,0 +1,9 @@
+// Runs inside the /embed/verify iframe and tells the embedding page (see
+// widget.js) how tall the content is, so the iframe never scrolls.
+(function () {
+    function report() {
+        parent.postMessage({ testaVerifyHeight: document.documentElement.scrollHeight }, '*');
+    }
+    window.addEventListener('load', report);
+    document.body.addEventListener('htmx:afterSettle', report);
+})();
@@ -0,0 +1,130 @@
+// Camera scanning for the /scan page. Frames are read with the browser's
+// BarcodeDetector where there is one, and with jsQR otherwise. Every new
+// code read is posted to /scan/verify; the frames of an animated code are
+// posted together until the server has them all.
+(function () {
+    const video = document.getElementById('scan-video');
+    const startBtn = document.getElementById('scan-start');
+    const stopBtn = document.getElementById('scan-stop');
+    const status = document.getElementById('scan-status');
+    const result = document.getElementById('scan-result');
+    const canvas = document.createElement('canvas');
+    const FRAME_PREFIX = 'EDUQR:';
+    // The app's URL, under any base path it is mounted at.
+    const base = document.currentScript.src.replace(/\/static\/scan\.js(\?.*)?$/, '');
+
+    let stream = null;
+    let timer = null;
+    let busy = false;
+    let detector = null;
+    let frames = [];
+    let frameSet = '';
+
+    if ('BarcodeDetector' in window) {
+        detector = new BarcodeDetector({ formats: ['qr_code'] });
+    }
+
+    async function readCode() {
+        if (detector) {
+            const codes = await detector.detect(video);
+            return codes.length ? codes[0].rawValue : null;
+        }
+        if (!window.jsQR || !video.videoWidth) {
+            return null;
+        }
+        canvas.width = video.videoWidth;
+        canvas.height = video.videoHeight;
+        const ctx = canvas.getContext('2d');
+        ctx.drawImage(video, 0, 0);
+        const image = ctx.getImageData(0, 0, canvas.width, canvas.height);
+        const code = jsQR(image.data, image.width, image.height, { inversionAttempts: 'dontInvert' });
+        return code ? code.data : null;
+    }
+
+    // collect records a code read and reports whether it is new.
+    function collect(text) {
+        if (text.startsWith(FRAME_PREFIX)) {
+            const set = text.slice(FRAME_PREFIX.length).split(':')[0];
+            if (set !== frameSet) {
+                frameSet = set;
+                frames = [];
+            }
+        } else {
+            if (frames.length === 1 && frames[0] === text) {
+                return false;
+            }
+            frameSet = '';
+            frames = [];
+        }
+        if (frames.includes(text)) {
+            return false;
+        }
+        frames.push(text);
+        return true;
+    }
+
+    async function verify() {
+        const body = new URLSearchParams();
+        frames.forEach(f => body.append('data', f));
+        const resp = await fetch(base + '/scan/verify', { method: 'POST', body: body });
+        result.innerHTML = await resp.text();
+        if (result.querySelector('[data-scan-done]')) {
+            stop();
+        }
+    }
+
+    async function tick() {
+        if (busy) {
+            return;
+        }
+        busy = true;
+        try {
+            const text = await readCode();
+            if (text && collect(text)) {
+                status.textContent = 'Code read, verifying...';
+                await verify();
+            }
+        } catch (err) {
+            status.textContent = 'Scanning failed: ' + err.message;
+        } finally {
+            busy = false;
+        }
+    }
+
+    async function start() {
+        if (!navigator.mediaDevices || !navigator.mediaDevices.getUserMedia) {
+            status.textContent = 'This browser cannot use the camera. Paste the QR data below instead.';
+            return;
+        }
+        try {
+            stream = await navigator.mediaDevices.getUserMedia({ video: { facingMode: 'environment' } });
+        } catch (err) {
+            status.textContent = 'Camera unavailable: ' + err.message;
+            return;
+        }
+        frames = [];
+        frameSet = '';
+        result.innerHTML = '';
+        video.srcObject = stream;
+        await video.play();
+        startBtn.hidden = true;
+        stopBtn.hidden = false;
+        status.textContent = 'Scanning...';
+        timer = setInterval(tick, 250);
+    }
+
+    function stop() {
+        clearInterval(timer);
+        if (stream) {
+            stream.getTracks().forEach(t => t.stop());
+            stream = null;
+        }
+        video.srcObject = null;
+        startBtn.hidden = false;
+        stopBtn.hidden = true;
+        status.textContent = '';
+    }
+
+    startBtn.addEventListener('click', start);
+    stopBtn.addEventListener('click', stop);
+})();
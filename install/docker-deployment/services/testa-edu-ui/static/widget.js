@@ -0,0 +1,40 @@
+// Testa Edu verification widget. Include this script on a page and add
+// <div data-testa-verify></div> where the widget should appear. The page's
+// origin must be listed in the issuer's EMBED_ORIGINS.
+(function () {
+    const script = document.currentScript;
+    const base = script.src.replace(/\/static\/widget\.js(\?.*)?$/, '');
+    const origin = new URL(base).origin;
+    const frames = [];
+
+    function mount() {
+        document.querySelectorAll('[data-testa-verify]').forEach(function (el) {
+            if (el.querySelector('iframe')) {
+                return;
+            }
+            const iframe = document.createElement('iframe');
+            iframe.src = base + '/embed/verify';
+            iframe.title = 'Credential verification';
+            iframe.style.cssText = 'width:100%;max-width:480px;height:240px;border:1px solid #e5e7eb;border-radius:8px;';
+            el.appendChild(iframe);
+            frames.push(iframe);
+        });
+    }
+
+    window.addEventListener('message', function (e) {
+        if (e.origin !== origin || !e.data || typeof e.data.testaVerifyHeight !== 'number') {
+            return;
+        }
+        frames.forEach(function (iframe) {
+            if (iframe.contentWindow === e.source) {
+                iframe.style.height = Math.min(e.data.testaVerifyHeight, 800) + 'px';
+            }
+        });
+    });
+
+    if (document.readyState === 'loading') {
+        document.addEventListener('DOMContentLoaded', mount);
+    } else {
+        mount();
+    }
+})();